@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"path/filepath"
 	"time"
 
 	"github.com/JustinTDCT/CineVault/internal/analytics"
@@ -31,7 +32,7 @@ const bannerArt = `
 
 func main() {
 	v := version.Get()
-	fmt.Println(bannerArt)
+	fmt.Print(bannerArt)
 	fmt.Printf("  Self-Hosted Media Server - Phase %d\n", v.Phase)
 	fmt.Printf("  Version %s\n\n", v.Version)
 	cfg, err := config.Load()
@@ -66,7 +67,7 @@ func main() {
 	jobs.RegisterHandlers(jobQueue, server.Scanner(), server.LibRepo(),
 		server.MediaRepo(), server.JobRepo(), fp, server.WSHub(),
 		server.Scrapers(), server.SettingsRepo(), server.Config(),
-		det, server.SegmentRepo())
+		det, server.SegmentRepo(), server.OptimizedRepo())
 
 	// Start job queue worker in background
 	go func() {
@@ -79,8 +80,17 @@ func main() {
 	// Start transcode session cleanup (every 5m, expire after 30m idle)
 	transcodeCleanupStop := make(chan struct{})
 	server.Transcoder().RunCleanupLoop(transcodeCleanupStop, 30*time.Minute)
+	// Pause encoders far ahead of their viewers, evict played segments
+	server.Transcoder().RunThrottleLoop(transcodeCleanupStop, 2*time.Second)
+	// Drop converted music files unused for a week or over the cache size
+	server.AudioCache().RunCleanupLoop(transcodeCleanupStop, 7*24*time.Hour)
 	defer close(transcodeCleanupStop)
 
+	// Clean up optimized versions hourly (orphans, unused, over quota)
+	optimizedCleanupStop := make(chan struct{})
+	go jobs.StartOptimizedCleanup(server.OptimizedRepo(), cfg.Optimized, optimizedCleanupStop)
+	defer close(optimizedCleanupStop)
+
 	// Start analytics collector (system metrics every 60s)
 	collector := analytics.NewCollector(server.AnalyticsRepo(), server.Transcoder(), []string{cfg.Paths.Media})
 	go collector.Start()
@@ -108,14 +118,16 @@ func main() {
 				log.Printf("[watcher] scan error for %s: %v", path, err)
 			}
 		} else {
-			if err := server.MediaRepo().MarkUnavailable(path); err != nil {
-				log.Printf("[watcher] mark unavailable error for %s: %v", path, err)
+			// Files that moved were picked up at their new path first
+			if _, err := server.Scanner().RemoveMissing(path); err != nil {
+				log.Printf("[watcher] remove error for %s: %v", path, err)
 			}
 		}
 	})
 	if err != nil {
 		log.Printf("Filesystem watcher failed to start: %v", err)
 	} else {
+		fsWatcher.SetPolling(time.Duration(cfg.Watcher.PollIntervalSec)*time.Second, cfg.Watcher.PollRate)
 		fsWatcher.Start()
 		defer fsWatcher.Stop()
 	}
@@ -137,6 +149,30 @@ func main() {
 	editionWorker.Start()
 	defer editionWorker.Stop()
 
+	// Start DVR recorder (checks every 10s, scans finished recordings into dvr_library_id)
+	recorder := scheduler.NewRecorder(server.DVRRepo(), server.LibRepo(), server.SettingsRepo(), server.Tuners(),
+		cfg.FFmpeg.FFmpegPath, filepath.Join(cfg.Paths.Media, "Recordings"),
+		func(libraryID uuid.UUID, path string) error {
+			lib, err := server.LibRepo().GetByID(libraryID)
+			if err != nil {
+				return err
+			}
+			return server.Scanner().ScanSingleFile(lib, path)
+		})
+	recorder.Start()
+	defer recorder.Stop()
+
+	// Start EPG guide worker (every 15m, refreshes tuners older than epg_refresh_hours
+	// and re-applies DVR series rules)
+	guideWorker := scheduler.NewGuideWorker(server.Guide(), server.SeriesRules(), server.SettingsRepo())
+	guideWorker.Start()
+	defer guideWorker.Stop()
+
+	// Disconnect server-driven Chromecast / DLNA sessions and stop SyncPlay on exit
+	defer server.Cast().Shutdown()
+	defer server.PlayTo().Shutdown()
+	defer server.SyncPlay().Shutdown()
+
 	addr := cfg.Server.Address()
 	log.Printf("Server starting on http://%s\n", addr)
 	log.Printf("WebSocket available at ws://%s/api/v1/ws\n", addr)
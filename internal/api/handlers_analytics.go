@@ -122,6 +122,7 @@ func (s *Server) handleAnalyticsTranscodes(w http.ResponseWriter, r *http.Reques
 	s.respondJSON(w, http.StatusOK, Response{Success: true, Data: map[string]interface{}{
 		"stats":  stats,
 		"recent": recent,
+		"active": s.transcoder.Stats(),
 	}})
 }
 
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/JustinTDCT/CineVault/internal/ffmpeg"
+	"github.com/JustinTDCT/CineVault/internal/jobs"
+	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/JustinTDCT/CineVault/internal/stream"
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+)
+
+// ──────────────────── Music Audio ────────────────────
+
+// GET /api/v1/stream/{mediaId}/audio — play a music track as the original
+// file or converted with ?format=opus|mp3|aac at ?bitrate= kbps.
+// ?gain=track|album|off picks the ReplayGain applied to conversions
+// (default: track gain when the library normalizes audio). Originals are
+// sent untouched with the gains in X-ReplayGain-* headers. The first play
+// of a conversion streams as it is made; after that, and for originals,
+// range requests seek.
+func (s *Server) handleStreamAudio(w http.ResponseWriter, r *http.Request) {
+	mediaID, err := uuid.Parse(r.PathValue("mediaId"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid media id")
+		return
+	}
+	media, err := s.mediaRepo.GetByID(mediaID)
+	if err != nil {
+		s.respondError(w, http.StatusNotFound, "media not found")
+		return
+	}
+
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = "original"
+	}
+	var target stream.AudioFormat
+	if format != "original" {
+		var ok bool
+		if target, ok = stream.AudioFormats[format]; !ok {
+			s.respondError(w, http.StatusBadRequest, "format must be original, opus, mp3 or aac")
+			return
+		}
+	}
+	gainMode, ok := s.audioGainMode(media, q.Get("gain"))
+	if !ok {
+		s.respondError(w, http.StatusBadRequest, "gain must be track, album or off")
+		return
+	}
+
+	trackGain, albumGain := s.replayGains(media)
+	if trackGain != nil {
+		w.Header().Set("X-ReplayGain-Track-Gain", fmt.Sprintf("%.2f dB", *trackGain))
+	}
+	if albumGain != nil {
+		w.Header().Set("X-ReplayGain-Album-Gain", fmt.Sprintf("%.2f dB", *albumGain))
+	}
+
+	if format == "original" {
+		w.Header().Set("X-Gain-Applied", "0.00 dB")
+		f, err := os.Open(media.FilePath)
+		if err != nil {
+			s.respondError(w, http.StatusNotFound, "file not found")
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			s.respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", stream.AudioContentType(media.FilePath))
+		http.ServeContent(w, r, "", info.ModTime(), f)
+		return
+	}
+
+	// Conversions bake the gain in. A track the loudness job hasn't
+	// reached yet plays unadjusted this once, and is queued for it
+	if gainMode != "off" && trackGain == nil {
+		s.queueLoudness(media.LibraryID)
+	}
+	applied := 0.0
+	switch {
+	case gainMode == "album" && albumGain != nil:
+		applied = *albumGain
+	case gainMode != "off" && trackGain != nil:
+		applied = *trackGain
+	}
+	w.Header().Set("X-Gain-Applied", fmt.Sprintf("%.2f dB", applied))
+	bitrate, _ := strconv.Atoi(q.Get("bitrate"))
+	if err := s.audioCache.Serve(w, r, media.FilePath, target, target.Bitrate(bitrate), applied); err != nil {
+		if r.Context().Err() == nil {
+			s.respondError(w, http.StatusInternalServerError, "audio conversion failed")
+		}
+	}
+}
+
+// audioGainMode validates a ?gain= value, defaulting to track gain when the
+// media's library has audio normalization on.
+func (s *Server) audioGainMode(media *models.MediaItem, mode string) (string, bool) {
+	switch mode {
+	case "track", "album", "off":
+		return mode, true
+	case "":
+		if lib, err := s.libRepo.GetByID(media.LibraryID); err == nil && lib.AudioNormalization {
+			return "track", true
+		}
+		return "off", true
+	}
+	return "", false
+}
+
+// replayGains returns a track's gain and its album's gain to TargetLUFS,
+// nil where the loudness hasn't been measured.
+func (s *Server) replayGains(media *models.MediaItem) (track, album *float64) {
+	track = media.LoudnessGainDB
+	if media.AlbumID != nil {
+		if lufs, ok, err := s.musicRepo.AlbumLoudness(*media.AlbumID); err == nil && ok {
+			g := ffmpeg.TargetLUFS - lufs
+			album = &g
+		}
+	}
+	return track, album
+}
+
+// queueLoudness queues the loudness job for a library with a track that
+// was played before it was measured. The job is unique per library, so
+// this is a no-op while one is queued or running.
+func (s *Server) queueLoudness(libraryID uuid.UUID) {
+	if s.jobQueue == nil {
+		return
+	}
+	uniqueID := "loudness:" + libraryID.String()
+	if _, err := s.jobQueue.EnqueueUnique(jobs.TaskLoudnessLibrary, jobs.LoudnessLibraryPayload{LibraryID: libraryID.String()}, uniqueID,
+		asynq.Timeout(12*time.Hour), asynq.Retention(1*time.Hour)); err != nil {
+		log.Printf("Loudness: failed to queue analysis for library %s: %v", libraryID, err)
+	}
+}
+
+// GET /api/v1/albums/{id}/gapless — what a client needs to play an album
+// back to back without gaps: tracks in order with exact durations, sample
+// rates, track and album gain, and the audio URL for each. Takes the audio
+// endpoint's ?format=, ?bitrate= and ?gain= to build the URLs.
+func (s *Server) handleAlbumGapless(w http.ResponseWriter, r *http.Request) {
+	albumID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid album ID")
+		return
+	}
+	tracks, err := s.musicRepo.ListTracksByAlbum(albumID)
+	if err != nil {
+		s.respondError(w, http.StatusInternalServerError, "failed to list tracks")
+		return
+	}
+
+	// Carry the playback options through to each track's URL
+	params := url.Values{}
+	for _, k := range []string{"format", "bitrate", "gain"} {
+		if v := r.URL.Query().Get(k); v != "" {
+			params.Set(k, v)
+		}
+	}
+
+	type gaplessTrack struct {
+		MediaID     uuid.UUID  `json:"media_id"`
+		Title       string     `json:"title"`
+		DiscNumber  *int       `json:"disc_number,omitempty"`
+		TrackNumber *int       `json:"track_number,omitempty"`
+		Duration    float64    `json:"duration"` // seconds, encoder delay and padding excluded
+		SampleRate  int        `json:"sample_rate,omitempty"`
+		Codec       string     `json:"codec,omitempty"`
+		TrackGainDB *float64   `json:"track_gain_db,omitempty"`
+		URL         string     `json:"url"`
+		NextMediaID *uuid.UUID `json:"next_media_id,omitempty"`
+	}
+	probe := ffmpeg.NewFFprobe(s.config.FFmpeg.FFprobePath)
+	run := make([]gaplessTrack, len(tracks))
+	for i, t := range tracks {
+		gt := gaplessTrack{
+			MediaID:     t.ID,
+			Title:       t.Title,
+			DiscNumber:  t.DiscNumber,
+			TrackNumber: t.TrackNumber,
+			TrackGainDB: t.LoudnessGainDB,
+			URL:         "/api/v1/stream/" + t.ID.String() + "/audio",
+		}
+		if len(params) > 0 {
+			gt.URL += "?" + params.Encode()
+		}
+		if t.DurationSeconds != nil {
+			gt.Duration = float64(*t.DurationSeconds)
+		}
+		// The stored duration is whole seconds; the transition needs the
+		// exact length
+		if result, err := probe.Probe(t.FilePath); err == nil {
+			if d, err := strconv.ParseFloat(result.Format.Duration, 64); err == nil {
+				gt.Duration = d
+			}
+			for _, st := range result.Streams {
+				if st.CodecType == "audio" {
+					gt.SampleRate, _ = strconv.Atoi(st.SampleRate)
+					gt.Codec = st.CodecName
+					break
+				}
+			}
+		}
+		if i+1 < len(tracks) {
+			next := tracks[i+1].ID
+			gt.NextMediaID = &next
+		}
+		run[i] = gt
+	}
+
+	data := map[string]interface{}{
+		"album_id":    albumID,
+		"target_lufs": ffmpeg.TargetLUFS,
+		"tracks":      run,
+	}
+	if lufs, ok, err := s.musicRepo.AlbumLoudness(albumID); err == nil && ok {
+		data["album_gain_db"] = ffmpeg.TargetLUFS - lufs
+	}
+	s.respondJSON(w, http.StatusOK, Response{Success: true, Data: data})
+}
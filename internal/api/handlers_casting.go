@@ -1,13 +1,25 @@
 package api
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/JustinTDCT/CineVault/internal/cast"
+	"github.com/JustinTDCT/CineVault/internal/dlna"
 	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/JustinTDCT/CineVault/internal/stream"
 	"github.com/google/uuid"
 )
 
@@ -104,6 +116,13 @@ func (s *Server) handleDLNADescription(w http.ResponseWriter, r *http.Request) {
 					ControlURL:  "/dlna/control/ContentDirectory",
 					EventSubURL: "/dlna/event/ContentDirectory",
 				},
+				{
+					ServiceType: "urn:schemas-upnp-org:service:ConnectionManager:1",
+					ServiceID:   "urn:upnp-org:serviceId:ConnectionManager",
+					SCPDURL:     "/dlna/ConnectionManager.xml",
+					ControlURL:  "/dlna/control/ConnectionManager",
+					EventSubURL: "/dlna/event/ConnectionManager",
+				},
 			},
 		},
 	}
@@ -130,7 +149,7 @@ func (s *Server) handleDLNAContent(w http.ResponseWriter, r *http.Request) {
 
 	if parentID == "0" || parentID == "" {
 		// Root: list libraries as containers
-		rows, err := s.db.Query("SELECT id, name FROM libraries WHERE is_active = TRUE ORDER BY sort_order")
+		rows, err := s.db.Query("SELECT id, name FROM libraries WHERE is_enabled = TRUE ORDER BY name")
 		if err == nil {
 			defer rows.Close()
 			for rows.Next() {
@@ -175,44 +194,661 @@ func (s *Server) handleDLNAContent(w http.ResponseWriter, r *http.Request) {
 	s.respondJSON(w, http.StatusOK, Response{Success: true, Data: items})
 }
 
+// dlnaEnabled reports whether the DLNA media server is switched on; the
+// unauthenticated /dlna endpoints below are only served when it is.
+func (s *Server) dlnaEnabled() bool {
+	var enabled bool
+	s.db.QueryRow("SELECT enabled FROM dlna_config LIMIT 1").Scan(&enabled)
+	return enabled
+}
+
+// GET /dlna/ContentDirectory.xml, /dlna/ConnectionManager.xml — service descriptions
+func (s *Server) handleDLNASCPD(w http.ResponseWriter, r *http.Request) {
+	if !s.dlnaEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	if r.PathValue("service") == "ConnectionManager.xml" {
+		s.connectionManager.HandleSCPD(w, r)
+		return
+	}
+	if r.PathValue("service") == "ContentDirectory.xml" {
+		s.contentDirectory.HandleSCPD(w, r)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// POST /dlna/control/{service} — SOAP control (Browse, Search, ...)
+func (s *Server) handleDLNAControl(w http.ResponseWriter, r *http.Request) {
+	if !s.dlnaEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.PathValue("service") {
+	case "ContentDirectory":
+		s.contentDirectory.HandleControl(w, r)
+	case "ConnectionManager":
+		s.connectionManager.HandleControl(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// GET /dlna/media/{id}?mode=direct|remux|transcode — stream for a renderer.
+// Renderers can't log in, so this is open while DLNA is enabled; the mode
+// comes from the res URL the renderer's profile picked in Browse/Search.
+func (s *Server) handleDLNAMedia(w http.ResponseWriter, r *http.Request) {
+	if !s.dlnaEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	mediaID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var filePath, container, audioCodec string
+	err = s.db.QueryRow(`SELECT file_path, COALESCE(container, ''), COALESCE(audio_codec, '')
+		FROM media_items WHERE id = $1`, mediaID).Scan(&filePath, &container, &audioCodec)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	profile := dlna.MatchProfile(r.Header)
+	mode := dlna.Delivery(r.URL.Query().Get("mode"))
+	if mode != dlna.DeliveryRemux && mode != dlna.DeliveryTranscode {
+		mode = dlna.DeliveryDirect
+	}
+	mimeType := dlna.MimeType(container)
+	if mode != dlna.DeliveryDirect {
+		mimeType = "video/mp2t"
+	}
+	w.Header().Set("transferMode.dlna.org", "Streaming")
+	w.Header().Set("contentFeatures.dlna.org", profile.ContentFeatures(mode, mimeType))
+
+	if mode == dlna.DeliveryDirect {
+		if err := stream.ServeDirectFile(w, r, filePath); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	start := dlna.ParseTimeSeekRange(r.Header.Get("TimeSeekRange.dlna.org"))
+	if v, err := strconv.ParseFloat(r.URL.Query().Get("start"), 64); err == nil && v > 0 {
+		start = v
+	}
+	if start > 0 {
+		w.Header().Set("TimeSeekRange.dlna.org", fmt.Sprintf("npt=%.3f-", start))
+	}
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Type", mimeType)
+		return
+	}
+	opts := stream.RemuxOptions{AudioStreamIndex: -1, AudioCodec: audioCodec}
+	if mode == dlna.DeliveryTranscode {
+		opts.TranscodeVideo = true
+		opts.MaxHeight = profile.MaxHeight
+	}
+	if err := stream.ServeRemuxedMPEGTS(r.Context(), w, s.config.FFmpeg.FFmpegPath, filePath, audioCodec, start, opts); err != nil {
+		if r.Context().Err() == nil {
+			log.Printf("[DLNA] %s stream for %s failed: %v", mode, mediaID, err)
+		}
+	}
+}
+
+// dlnaProvider answers ContentDirectory queries from the media tables.
+// Libraries are the top-level containers; items are a library's top-level
+// media (no extras or editions).
+type dlnaProvider struct {
+	db *sql.DB
+}
+
+const dlnaItemColumns = `m.id, m.library_id, m.title, m.media_type, COALESCE(m.container, ''),
+	COALESCE(m.codec, ''), COALESCE(m.audio_codec, ''), COALESCE(m.width, 0), COALESCE(m.height, 0),
+	COALESCE(m.duration_seconds, 0), COALESCE(m.file_size, 0), COALESCE(m.bitrate, 0), COALESCE(m.year, 0),
+	COALESCE(ar.name, ''), COALESCE(al.title, ''), COALESCE(al.genre, '')`
+
+const dlnaItemFrom = ` FROM media_items m
+	JOIN libraries l ON l.id = m.library_id AND l.is_enabled = TRUE
+	LEFT JOIN artists ar ON ar.id = m.artist_id
+	LEFT JOIN albums al ON al.id = m.album_id`
+
+func (p *dlnaProvider) GetLibraries() ([]dlna.DIDLContainer, error) {
+	rows, err := p.db.Query(`SELECT l.id, l.name,
+		(SELECT COUNT(*) FROM media_items m WHERE m.library_id = l.id AND m.parent_media_id IS NULL)
+		FROM libraries l WHERE l.is_enabled = TRUE ORDER BY l.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var containers []dlna.DIDLContainer
+	for rows.Next() {
+		var id uuid.UUID
+		c := dlna.DIDLContainer{ParentID: "0"}
+		if err := rows.Scan(&id, &c.Title, &c.Count); err != nil {
+			return nil, err
+		}
+		c.ID = id.String()
+		containers = append(containers, c)
+	}
+	return containers, rows.Err()
+}
+
+func (p *dlnaProvider) GetLibraryItems(libraryID string, offset, limit int) ([]dlna.DIDLItem, int, error) {
+	libID, err := uuid.Parse(libraryID)
+	if err != nil {
+		return nil, 0, err
+	}
+	var total int
+	if err := p.db.QueryRow(`SELECT COUNT(*) FROM media_items WHERE library_id = $1 AND parent_media_id IS NULL`,
+		libID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	query := `SELECT ` + dlnaItemColumns + dlnaItemFrom + `
+		WHERE m.library_id = $1 AND m.parent_media_id IS NULL
+		ORDER BY COALESCE(m.sort_title, m.title), m.id OFFSET $2`
+	args := []interface{}{libID, offset}
+	if limit > 0 {
+		query += ` LIMIT $3`
+		args = append(args, limit)
+	}
+	items, err := p.queryItems(query, args...)
+	return items, total, err
+}
+
+func (p *dlnaProvider) GetItem(id string) (*dlna.DIDLItem, error) {
+	mediaID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	items, err := p.queryItems(`SELECT `+dlnaItemColumns+dlnaItemFrom+` WHERE m.id = $1`, mediaID)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &items[0], nil
+}
+
+// SearchItems translates the common criteria into SQL and pages there.
+// Criteria it can't translate fall back to filtering the wider result in
+// Go, since they can reference anything in the DIDL item.
+func (p *dlnaProvider) SearchItems(containerID string, criteria *dlna.SearchCriteria, offset, limit int) ([]dlna.DIDLItem, int, error) {
+	where := ` WHERE m.parent_media_id IS NULL`
+	var args []interface{}
+	if containerID != "0" {
+		libID, err := uuid.Parse(containerID)
+		if err != nil {
+			return nil, 0, err
+		}
+		where += ` AND m.library_id = $1`
+		args = append(args, libID)
+	}
+	cond, condArgs, exact := criteria.SQL(len(args), dlnaSearchSQL)
+	if cond != "" {
+		where += ` AND ` + cond
+		args = append(args, condArgs...)
+	}
+	query := `SELECT ` + dlnaItemColumns + dlnaItemFrom + where + ` ORDER BY COALESCE(m.sort_title, m.title), m.id`
+
+	if exact {
+		var total int
+		if err := p.db.QueryRow(`SELECT COUNT(*)`+dlnaItemFrom+where, args...).Scan(&total); err != nil {
+			return nil, 0, err
+		}
+		query += fmt.Sprintf(` OFFSET $%d`, len(args)+1)
+		args = append(args, offset)
+		if limit > 0 {
+			query += fmt.Sprintf(` LIMIT $%d`, len(args)+1)
+			args = append(args, limit)
+		}
+		items, err := p.queryItems(query, args...)
+		return items, total, err
+	}
+
+	items, err := p.queryItems(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	var matched []dlna.DIDLItem
+	for i := range items {
+		if criteria.Match(&items[i]) {
+			matched = append(matched, items[i])
+		}
+	}
+	total := len(matched)
+	if offset >= total {
+		return nil, total, nil
+	}
+	matched = matched[offset:]
+	if limit > 0 && limit < len(matched) {
+		matched = matched[:limit]
+	}
+	return matched, total, nil
+}
+
+// dlnaMediaTypes are the media types dlnaMediaClass maps to classes.
+var dlnaMediaTypes = []models.MediaType{
+	models.MediaTypeMovies, models.MediaTypeAdultMovies, models.MediaTypeTVShows,
+	models.MediaTypeMusic, models.MediaTypeMusicVideos, models.MediaTypeHomeVideos,
+	models.MediaTypeOtherVideos, models.MediaTypeImages, models.MediaTypeAudiobooks,
+}
+
+// dlnaSearchSQL renders a search condition against dlnaItemFrom's columns,
+// matching as dlna.Condition.Match does: case-insensitively, and with an
+// empty artist, album or genre counted as missing.
+func dlnaSearchSQL(cond *dlna.Condition) (string, []interface{}, bool) {
+	if cond.Property == "upnp:class" {
+		// The class comes from the media type, so test each type's class
+		var types []interface{}
+		for _, mt := range dlnaMediaTypes {
+			if cond.Match(&dlna.DIDLItem{Class: dlnaMediaClass(string(mt))}) {
+				types = append(types, string(mt))
+			}
+		}
+		if len(types) == 0 {
+			return "FALSE", nil, true
+		}
+		return `m.media_type::text IN (?` + strings.Repeat(`, ?`, len(types)-1) + `)`, types, true
+	}
+
+	var column string
+	optional := true
+	switch cond.Property {
+	case "dc:title":
+		column, optional = "m.title", false
+	case "dc:creator", "upnp:artist":
+		column = "ar.name"
+	case "upnp:album":
+		column = "al.title"
+	case "upnp:genre":
+		column = "al.genre"
+	default:
+		return "", nil, false
+	}
+	present := ""
+	if optional {
+		present = "COALESCE(" + column + ", '') <> '' AND "
+	}
+	like := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(cond.Value)
+	switch cond.Op {
+	case "exists":
+		if optional {
+			if strings.EqualFold(cond.Value, "true") {
+				return "COALESCE(" + column + ", '') <> ''", nil, true
+			}
+			return "COALESCE(" + column + ", '') = ''", nil, true
+		}
+	case "contains":
+		return "(" + present + column + " ILIKE ?)", []interface{}{"%" + like + "%"}, true
+	case "doesnotcontain":
+		return "(" + present + column + " NOT ILIKE ?)", []interface{}{"%" + like + "%"}, true
+	case "=":
+		return "(" + present + "LOWER(" + column + ") = LOWER(?::text))", []interface{}{cond.Value}, true
+	case "!=":
+		return "(" + present + "LOWER(" + column + ") <> LOWER(?::text))", []interface{}{cond.Value}, true
+	}
+	return "", nil, false
+}
+
+// UpdateID derives the SystemUpdateID ("0") or a library's ContainerUpdateID
+// from its newest change and item count, so it moves whenever a scan adds,
+// updates or removes items.
+func (p *dlnaProvider) UpdateID(containerID string) uint32 {
+	query := `SELECT COALESCE(EXTRACT(EPOCH FROM MAX(GREATEST(added_at, updated_at)))::BIGINT, 0) + COUNT(*) FROM media_items`
+	var args []interface{}
+	if containerID != "0" {
+		// Items report their library's ID
+		var libID uuid.UUID
+		if err := p.db.QueryRow(`SELECT id FROM libraries WHERE id::text = $1
+			UNION ALL SELECT library_id FROM media_items WHERE id::text = $1 LIMIT 1`, containerID).Scan(&libID); err != nil {
+			return 0
+		}
+		query += ` WHERE library_id = $1`
+		args = append(args, libID)
+	}
+	var id int64
+	if err := p.db.QueryRow(query, args...).Scan(&id); err != nil {
+		return 0
+	}
+	return uint32(id)
+}
+
+func (p *dlnaProvider) queryItems(query string, args ...interface{}) ([]dlna.DIDLItem, error) {
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []dlna.DIDLItem
+	for rows.Next() {
+		var id, libID uuid.UUID
+		var mediaType string
+		var width, duration, year int
+		var bitrate int64
+		var item dlna.DIDLItem
+		if err := rows.Scan(&id, &libID, &item.Title, &mediaType, &item.Container,
+			&item.VideoCodec, &item.AudioCodec, &width, &item.Height,
+			&duration, &item.Size, &bitrate, &year,
+			&item.Creator, &item.Album, &item.Genre); err != nil {
+			return nil, err
+		}
+		item.ID, item.ParentID = id.String(), libID.String()
+		item.Class = dlnaMediaClass(mediaType)
+		item.MimeType = dlna.MimeType(item.Container)
+		item.Bitrate = int(bitrate / 8) // DIDL bitrate is bytes/second
+		if duration > 0 {
+			item.Duration = dlna.FormatDuration(float64(duration))
+		}
+		if width > 0 && item.Height > 0 {
+			item.Resolution = fmt.Sprintf("%dx%d", width, item.Height)
+		}
+		if year > 0 {
+			item.Date = strconv.Itoa(year)
+		}
+		if strings.HasPrefix(item.Class, "object.item.audioItem") {
+			item.VideoCodec = ""
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// GET /api/v1/dlna/renderers — Discover DLNA MediaRenderers ("Play To" targets) via SSDP
+func (s *Server) handleDLNARenderers(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+	renderers, err := dlna.DiscoverRenderers(ctx)
+	if err != nil {
+		s.respondError(w, http.StatusInternalServerError, "discovery failed: "+err.Error())
+		return
+	}
+	if renderers == nil {
+		renderers = []*dlna.Renderer{}
+	}
+	s.respondJSON(w, http.StatusOK, Response{Success: true, Data: renderers})
+}
+
 // ══════════════════════ Chromecast (P14-02) ══════════════════════
 
-// POST /api/v1/cast/session — Create/update cast session
+// GET /api/v1/cast/devices — Discover Chromecast receivers on the LAN (mDNS)
+func (s *Server) handleCastDevices(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+	defer cancel()
+	devices, err := cast.Discover(ctx)
+	if err != nil {
+		s.respondError(w, http.StatusInternalServerError, "discovery failed: "+err.Error())
+		return
+	}
+	if devices == nil {
+		devices = []cast.Device{}
+	}
+	s.respondJSON(w, http.StatusOK, Response{Success: true, Data: devices})
+}
+
+// POST /api/v1/cast/session — Create/update cast session. With device_address
+// (host:port from /cast/devices, or a renderer location from /dlna/renderers
+// with device_type "dlna") the server connects to the device and starts
+// playback itself.
 func (s *Server) handleCastSession(w http.ResponseWriter, r *http.Request) {
 	userID := s.getUserID(r)
 	var req struct {
-		MediaItemID string  `json:"media_item_id"`
-		DeviceName  string  `json:"device_name"`
-		DeviceType  string  `json:"device_type"`
-		State       string  `json:"state"`
-		CurrentTime float64 `json:"current_time"`
-		Duration    float64 `json:"duration"`
+		MediaItemID   string  `json:"media_item_id"`
+		DeviceName    string  `json:"device_name"`
+		DeviceType    string  `json:"device_type"`
+		DeviceAddress string  `json:"device_address"`
+		ServerURL     string  `json:"server_url"` // base URL the receiver can reach; defaults to this request's host
+		State         string  `json:"state"`
+		CurrentTime   float64 `json:"current_time"`
+		Duration      float64 `json:"duration"`
 	}
 	if json.NewDecoder(r.Body).Decode(&req) != nil {
 		s.respondError(w, http.StatusBadRequest, "invalid body")
 		return
 	}
+	if req.DeviceType == "" {
+		req.DeviceType = "chromecast"
+	}
+	if req.DeviceAddress != "" {
+		req.State = "buffering"
+		if req.DeviceType == "dlna" {
+			// DLNA renderers are addressed by their description URL
+			if u, err := url.Parse(req.DeviceAddress); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				s.respondError(w, http.StatusBadRequest, "device_address must be the renderer's description URL")
+				return
+			}
+		} else if _, _, err := net.SplitHostPort(req.DeviceAddress); err != nil {
+			req.DeviceAddress = net.JoinHostPort(req.DeviceAddress, "8009")
+		}
+	}
+	if req.State == "" {
+		req.State = "idle"
+	}
 
-	id := uuid.New()
-	_, err := s.db.Exec(`INSERT INTO cast_sessions (id, user_id, media_item_id, device_name, device_type, state, current_time_sec, duration_sec)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	var address *string
+	if req.DeviceAddress != "" {
+		address = &req.DeviceAddress
+	}
+	var id uuid.UUID
+	err := s.db.QueryRow(`INSERT INTO cast_sessions (id, user_id, media_item_id, device_name, device_type, state, current_time_sec, duration_sec, device_address)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		ON CONFLICT (user_id, media_item_id) DO UPDATE SET
-			device_name = EXCLUDED.device_name, state = EXCLUDED.state,
-			current_time_sec = EXCLUDED.current_time_sec, duration_sec = EXCLUDED.duration_sec, updated_at = NOW()`,
-		id, userID, req.MediaItemID, req.DeviceName, req.DeviceType, req.State, req.CurrentTime, req.Duration)
+			device_name = EXCLUDED.device_name, device_type = EXCLUDED.device_type, state = EXCLUDED.state,
+			current_time_sec = EXCLUDED.current_time_sec, duration_sec = EXCLUDED.duration_sec,
+			device_address = EXCLUDED.device_address, error_message = NULL, updated_at = NOW()
+		RETURNING id`,
+		uuid.New(), userID, req.MediaItemID, req.DeviceName, req.DeviceType, req.State, req.CurrentTime, req.Duration, address).Scan(&id)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "failed to save cast session")
+		return
+	}
+
+	if req.DeviceAddress == "" {
+		s.respondJSON(w, http.StatusOK, Response{Success: true, Data: map[string]interface{}{"id": id}})
+		return
+	}
+
+	mediaID, _ := uuid.Parse(req.MediaItemID)
+	media, err := s.mediaRepo.GetByID(mediaID)
 	if err != nil {
-		// Unique constraint on (user_id, media_item_id) may not exist; try UPDATE
-		s.db.Exec(`UPDATE cast_sessions SET device_name=$1, state=$2, current_time_sec=$3, duration_sec=$4, updated_at=NOW()
-			WHERE user_id=$5 AND media_item_id=$6`, req.DeviceName, req.State, req.CurrentTime, req.Duration, userID, req.MediaItemID)
+		s.respondError(w, http.StatusNotFound, "media not found")
+		return
+	}
+	load := cast.LoadRequest{
+		URL:         castStreamURL(r, req.ServerURL, mediaID),
+		ContentType: castContentType(media.FilePath),
+		Title:       media.Title,
+		StartTime:   req.CurrentTime,
+		Duration:    req.Duration,
+	}
+	if load.Duration == 0 && media.DurationSeconds != nil {
+		load.Duration = float64(*media.DurationSeconds)
 	}
 
-	s.respondJSON(w, http.StatusOK, Response{Success: true, Data: map[string]interface{}{"id": id}})
+	ctx, cancel := context.WithTimeout(r.Context(), 20*time.Second)
+	defer cancel()
+	if req.DeviceType == "dlna" {
+		item := dlna.DIDLItem{Title: media.Title, MimeType: load.ContentType, Class: dlnaClass(load.ContentType)}
+		if load.Duration > 0 {
+			item.Duration = dlna.FormatDuration(load.Duration)
+		}
+		if err := s.playTo.Start(ctx, id, req.DeviceAddress, load.URL, item, req.CurrentTime); err != nil {
+			s.db.Exec("UPDATE cast_sessions SET state = 'idle', error_message = $2, updated_at = NOW() WHERE id = $1", id, err.Error())
+			s.respondError(w, http.StatusBadGateway, "play to failed: "+err.Error())
+			return
+		}
+		s.respondJSON(w, http.StatusOK, Response{Success: true, Data: map[string]interface{}{"id": id, "connected": true}})
+		return
+	}
+	st, err := s.cast.Start(ctx, id, req.DeviceAddress, load)
+	if err != nil {
+		s.db.Exec("UPDATE cast_sessions SET state = 'idle', error_message = $2, updated_at = NOW() WHERE id = $1", id, err.Error())
+		s.respondError(w, http.StatusBadGateway, "cast failed: "+err.Error())
+		return
+	}
+	if st != nil {
+		s.onCastStatus(id, *st)
+	}
+	s.respondJSON(w, http.StatusOK, Response{Success: true, Data: map[string]interface{}{"id": id, "connected": true}})
+}
+
+// castStreamURL is the absolute direct-stream URL handed to the receiver.
+// The caller's token goes in the query string since receivers can't send
+// headers.
+func castStreamURL(r *http.Request, base string, mediaID uuid.UUID) string {
+	if base == "" {
+		scheme := "http"
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	return fmt.Sprintf("%s/api/v1/stream/%s/direct?token=%s", strings.TrimSuffix(base, "/"), mediaID, url.QueryEscape(token))
+}
+
+// castContentType matches what handleStreamDirect serves: the file as-is
+// for native formats, otherwise an MPEG-TS remux.
+func castContentType(filePath string) string {
+	if stream.NeedsRemux(filePath) {
+		return "video/mp2t"
+	}
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".webm":
+		return "video/webm"
+	case ".mp3":
+		return "audio/mpeg"
+	case ".m4a", ".aac":
+		return "audio/mp4"
+	case ".flac":
+		return "audio/flac"
+	}
+	return "video/mp4"
+}
+
+// dlnaClass is the UPnP object class renderers expect for a content type.
+func dlnaClass(contentType string) string {
+	if strings.HasPrefix(contentType, "audio/") {
+		return "object.item.audioItem.musicTrack"
+	}
+	return "object.item.videoItem"
+}
+
+// dlnaMediaClass maps a media type to its DIDL-Lite upnp:class.
+func dlnaMediaClass(mediaType string) string {
+	switch models.MediaType(mediaType) {
+	case models.MediaTypeMusic, models.MediaTypeAudiobooks:
+		return "object.item.audioItem.musicTrack"
+	case models.MediaTypeMusicVideos:
+		return "object.item.videoItem.musicVideoClip"
+	case models.MediaTypeMovies, models.MediaTypeAdultMovies:
+		return "object.item.videoItem.movie"
+	case models.MediaTypeImages:
+		return "object.item.imageItem.photo"
+	}
+	return "object.item.videoItem"
+}
+
+// onCastStatus writes a receiver MEDIA_STATUS back into cast_sessions.
+func (s *Server) onCastStatus(sessionID uuid.UUID, st cast.MediaStatus) {
+	state := "idle"
+	switch st.PlayerState {
+	case "PLAYING":
+		state = "playing"
+	case "PAUSED":
+		state = "paused"
+	case "BUFFERING":
+		state = "buffering"
+	case "IDLE":
+		if st.IdleReason == "FINISHED" || st.IdleReason == "CANCELLED" {
+			state = "stopped"
+		}
+	}
+	var errMsg *string
+	if st.IdleReason == "ERROR" {
+		msg := "receiver reported a playback error"
+		errMsg = &msg
+	}
+	volume := st.Volume.Level
+	s.recordCastProgress(sessionID, state, st.CurrentTime, st.Duration(), &volume, errMsg)
+}
+
+// onCastClose records a dropped receiver connection. Deliberate stops pass a
+// nil error and have already updated the row.
+func (s *Server) onCastClose(sessionID uuid.UUID, err error) {
+	if err == nil {
+		return
+	}
+	s.db.Exec(`UPDATE cast_sessions SET state = 'idle', error_message = $2, updated_at = NOW()
+		WHERE id = $1 AND state != 'stopped'`, sessionID, err.Error())
+}
+
+// onPlayToStatus records a DLNA renderer's polled position.
+func (s *Server) onPlayToStatus(sessionID uuid.UUID, st dlna.PlaybackStatus) {
+	state := "idle"
+	switch st.State {
+	case dlna.StatePlaying:
+		state = "playing"
+	case dlna.StatePaused:
+		state = "paused"
+	case dlna.StateTransitioning:
+		state = "buffering"
+	case dlna.StateStopped, dlna.StateNoMedia:
+		state = "stopped"
+	}
+	s.recordCastProgress(sessionID, state, st.Position, st.Duration, nil, nil)
+}
+
+// onPlayToClose marks a session stopped once the renderer has finished or
+// stopped responding.
+func (s *Server) onPlayToClose(sessionID uuid.UUID, err error) {
+	if err != nil {
+		s.db.Exec(`UPDATE cast_sessions SET state = 'idle', error_message = $2, updated_at = NOW() WHERE id = $1`, sessionID, err.Error())
+		return
+	}
+	s.db.Exec(`UPDATE cast_sessions SET state = 'stopped', updated_at = NOW() WHERE id = $1`, sessionID)
+}
+
+// recordCastProgress updates a cast session from what the device reports and
+// mirrors the position into the user's watch history, since nothing in the
+// browser is playing to report it.
+func (s *Server) recordCastProgress(sessionID uuid.UUID, state string, position, duration float64, volume *float64, errMsg *string) {
+	var userID, mediaID uuid.UUID
+	err := s.db.QueryRow(`UPDATE cast_sessions SET state = $2, current_time_sec = $3,
+		duration_sec = CASE WHEN $4::float > 0 THEN $4::float ELSE duration_sec END,
+		volume = COALESCE($5, volume), error_message = COALESCE($6, error_message), updated_at = NOW()
+		WHERE id = $1
+		RETURNING user_id, media_item_id, duration_sec`, sessionID, state, position, duration, volume, errMsg).Scan(&userID, &mediaID, &duration)
+	if err != nil || position <= 0 {
+		return
+	}
+	durationSec := int(duration)
+	wh := &models.WatchHistory{
+		ID:              uuid.New(),
+		UserID:          userID,
+		MediaItemID:     mediaID,
+		ProgressSeconds: int(position),
+		Completed:       duration > 0 && position >= duration*0.9,
+	}
+	if durationSec > 0 {
+		wh.DurationSeconds = &durationSec
+	}
+	if err := s.watchRepo.Upsert(wh); err != nil {
+		log.Printf("[cast] watch progress for %s: %v", mediaID, err)
+	}
 }
 
 // GET /api/v1/cast/sessions — List active cast sessions for user
 func (s *Server) handleListCastSessions(w http.ResponseWriter, r *http.Request) {
 	userID := s.getUserID(r)
-	rows, err := s.db.Query(`SELECT cs.id, cs.media_item_id, mi.title, cs.device_name, cs.device_type, cs.state, cs.current_time_sec, cs.duration_sec, cs.updated_at
+	rows, err := s.db.Query(`SELECT cs.id, cs.media_item_id, mi.title, cs.device_name, cs.device_type, cs.state, cs.current_time_sec, cs.duration_sec,
+			cs.device_address, cs.volume, cs.error_message, cs.updated_at
 		FROM cast_sessions cs JOIN media_items mi ON cs.media_item_id = mi.id
 		WHERE cs.user_id = $1 AND cs.state != 'stopped'
 		ORDER BY cs.updated_at DESC`, userID)
@@ -227,12 +863,15 @@ func (s *Server) handleListCastSessions(w http.ResponseWriter, r *http.Request)
 		var id, mediaID uuid.UUID
 		var title, deviceName, deviceType, state string
 		var curTime, duration float64
+		var address, errMsg *string
+		var volume *float64
 		var updatedAt time.Time
-		if rows.Scan(&id, &mediaID, &title, &deviceName, &deviceType, &state, &curTime, &duration, &updatedAt) == nil {
+		if rows.Scan(&id, &mediaID, &title, &deviceName, &deviceType, &state, &curTime, &duration, &address, &volume, &errMsg, &updatedAt) == nil {
 			sessions = append(sessions, map[string]interface{}{
 				"id": id, "media_item_id": mediaID, "title": title, "device_name": deviceName,
 				"device_type": deviceType, "state": state, "current_time": curTime,
-				"duration": duration, "updated_at": updatedAt,
+				"duration": duration, "device_address": address, "volume": volume,
+				"error": errMsg, "connected": s.cast.Connected(id) || s.playTo.Connected(id), "updated_at": updatedAt,
 			})
 		}
 	}
@@ -245,7 +884,17 @@ func (s *Server) handleListCastSessions(w http.ResponseWriter, r *http.Request)
 // DELETE /api/v1/cast/{id} — End cast session
 func (s *Server) handleEndCastSession(w http.ResponseWriter, r *http.Request) {
 	id, _ := uuid.Parse(r.PathValue("id"))
-	s.db.Exec("UPDATE cast_sessions SET state = 'stopped', updated_at = NOW() WHERE id = $1", id)
+	res, err := s.db.Exec("UPDATE cast_sessions SET state = 'stopped', updated_at = NOW() WHERE id = $1 AND user_id = $2", id, s.getUserID(r))
+	if err == nil {
+		if n, _ := res.RowsAffected(); n > 0 {
+			s.cast.Stop(id)
+			if s.playTo.Connected(id) {
+				ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+				s.playTo.Command(ctx, id, "stop", 0)
+				cancel()
+			}
+		}
+	}
 	s.respondJSON(w, http.StatusOK, Response{Success: true})
 }
 
@@ -265,23 +914,75 @@ func (s *Server) handleCastCommand(w http.ResponseWriter, r *http.Request) {
 		s.respondError(w, http.StatusBadRequest, "command required")
 		return
 	}
+	switch req.Command {
+	case "play", "pause", "seek", "volume", "stop":
+	default:
+		s.respondError(w, http.StatusBadRequest, "unknown command")
+		return
+	}
+
+	var exists bool
+	s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM cast_sessions WHERE id = $1 AND user_id = $2)", sessionID, s.getUserID(r)).Scan(&exists)
+	if !exists {
+		s.respondError(w, http.StatusNotFound, "cast session not found")
+		return
+	}
+
+	// Connected receiver: send the command and record what it reports back
+	if s.cast.Connected(sessionID) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		st, err := s.cast.Command(ctx, sessionID, req.Command, req.Value)
+		if err != nil && !errors.Is(err, cast.ErrNoSession) {
+			s.respondError(w, http.StatusBadGateway, "receiver: "+err.Error())
+			return
+		}
+		if st != nil {
+			s.onCastStatus(sessionID, *st)
+		}
+		if req.Command == "stop" {
+			s.db.Exec("UPDATE cast_sessions SET state = 'stopped', updated_at = NOW() WHERE id = $1", sessionID)
+		}
+		if err == nil {
+			s.respondJSON(w, http.StatusOK, Response{Success: true, Data: st})
+			return
+		}
+	}
 
-	// Update the session state based on command
+	if s.playTo.Connected(sessionID) {
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		st, err := s.playTo.Command(ctx, sessionID, req.Command, req.Value)
+		if err != nil && !errors.Is(err, dlna.ErrNoPlayback) {
+			s.respondError(w, http.StatusBadGateway, "renderer: "+err.Error())
+			return
+		}
+		if st != nil {
+			s.onPlayToStatus(sessionID, *st)
+		}
+		if req.Command == "stop" {
+			s.db.Exec("UPDATE cast_sessions SET state = 'stopped', updated_at = NOW() WHERE id = $1", sessionID)
+		}
+		if err == nil {
+			s.respondJSON(w, http.StatusOK, Response{Success: true, Data: st})
+			return
+		}
+	}
+
+	// Client-driven session (the browser's Cast SDK owns the device): just
+	// track the state it reports
 	switch req.Command {
 	case "play":
 		s.db.Exec("UPDATE cast_sessions SET state = 'playing', updated_at = NOW() WHERE id = $1", sessionID)
 	case "pause":
 		s.db.Exec("UPDATE cast_sessions SET state = 'paused', updated_at = NOW() WHERE id = $1", sessionID)
 	case "seek":
-		s.db.Exec("UPDATE cast_sessions SET current_time = $2, updated_at = NOW() WHERE id = $1", sessionID, req.Value)
+		s.db.Exec("UPDATE cast_sessions SET current_time_sec = $2, updated_at = NOW() WHERE id = $1", sessionID, req.Value)
 	case "volume":
-		// Volume is client-side only, just acknowledge
+		s.db.Exec("UPDATE cast_sessions SET volume = $2, updated_at = NOW() WHERE id = $1", sessionID, req.Value)
 	case "stop":
 		s.db.Exec("UPDATE cast_sessions SET state = 'stopped', updated_at = NOW() WHERE id = $1", sessionID)
 	}
 
 	s.respondJSON(w, http.StatusOK, Response{Success: true})
 }
-
-// Suppress unused import
-var _ = models.RoleUser
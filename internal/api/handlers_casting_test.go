@@ -0,0 +1,40 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/JustinTDCT/CineVault/internal/dlna"
+)
+
+func TestDLNASearchSQL(t *testing.T) {
+	tests := []struct {
+		criteria string
+		where    string
+		args     []interface{}
+		exact    bool
+	}{
+		{`upnp:class derivedfrom "object.item.audioItem"`,
+			"m.media_type::text IN ($1, $2)", []interface{}{"music", "audiobooks"}, true},
+		{`upnp:class derivedfrom "object.item.videoItem.movie"`,
+			"m.media_type::text IN ($1, $2)", []interface{}{"movies", "adult_movies"}, true},
+		{`upnp:class = "object.container"`, "FALSE", nil, true},
+		{`dc:title contains "50% off_"`, `(m.title ILIKE $1)`, []interface{}{`%50\% off\_%`}, true},
+		{`upnp:artist = "Queen" and upnp:album doesNotContain "live"`,
+			`((COALESCE(ar.name, '') <> '' AND LOWER(ar.name) = LOWER($1::text)) AND (COALESCE(al.title, '') <> '' AND al.title NOT ILIKE $2))`,
+			[]interface{}{"Queen", "%live%"}, true},
+		{`upnp:genre exists true`, "COALESCE(al.genre, '') <> ''", nil, true},
+		{`dc:title contains "star" and res@size > "1000"`, `(m.title ILIKE $1)`, []interface{}{"%star%"}, false},
+		{`dc:date >= "2020"`, "", nil, false},
+	}
+	for _, tt := range tests {
+		c, err := dlna.ParseSearchCriteria(tt.criteria)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.criteria, err)
+		}
+		where, args, exact := c.SQL(0, dlnaSearchSQL)
+		if where != tt.where || !reflect.DeepEqual(args, tt.args) || exact != tt.exact {
+			t.Errorf("%s:\n got %q %v exact=%v\nwant %q %v exact=%v", tt.criteria, where, args, exact, tt.where, tt.args, tt.exact)
+		}
+	}
+}
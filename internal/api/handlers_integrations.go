@@ -15,6 +15,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/JustinTDCT/CineVault/internal/importer"
+	"github.com/JustinTDCT/CineVault/internal/models"
 	"github.com/google/uuid"
 )
 
@@ -290,25 +292,64 @@ func (s *Server) handleArrWebhook(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse webhook payload
-	var payload map[string]interface{}
+	var payload arrPayload
 	if json.NewDecoder(r.Body).Decode(&payload) != nil {
 		s.respondError(w, http.StatusBadRequest, "invalid payload")
 		return
 	}
 
-	eventType, _ := payload["eventType"].(string)
+	eventType := payload.EventType
 	s.db.Exec("UPDATE webhook_secrets SET last_triggered_at = NOW() WHERE id = $1", webhookID)
 
 	switch eventType {
-	case "Download", "Import", "Upgrade":
-		// Trigger targeted scan for the library
-		if libraryID != nil {
-			log.Printf("[webhook] %s/%s: triggering scan for library %s", service, eventType, *libraryID)
-			// Trigger scan via job queue
+	case "Test":
+		log.Printf("[webhook] %s: test event received", service)
+	case "Download", "Import", "Upgrade", "AlbumDownload":
+		// Sonarr/Radarr report upgrades as Download with isUpgrade + deletedFiles
+		files := payload.importedFiles()
+		if len(files) == 0 {
+			log.Printf("[webhook] %s/%s: no file paths in payload", service, eventType)
+			break
+		}
+		replaced := payload.replacedFiles()
+		log.Printf("[webhook] %s/%s: %d file(s), upgrade=%v", service, eventType, len(files), payload.IsUpgrade || eventType == "Upgrade")
+		go s.arrImport(service, libraryID, files, replaced)
+	case "Rename":
+		renamed := payload.renamedFiles()
+		log.Printf("[webhook] %s/%s: %d file(s) renamed", service, eventType, len(renamed))
+		go s.arrRename(service, libraryID, renamed)
+	case "Delete", "MovieFileDelete", "EpisodeFileDelete", "TrackFileDelete", "BookFileDelete":
+		// With "On File Delete For Upgrade" on, the old file's delete arrives
+		// before the Download event; leave the item for that event's
+		// deletedFiles to hand to ReplaceFile.
+		if payload.DeleteReason == "upgrade" {
+			log.Printf("[webhook] %s/%s: file replaced by an upgrade, waiting for the import", service, eventType)
+			break
+		}
+		files := payload.importedFiles()
+		log.Printf("[webhook] %s/%s: marking %d file(s) unavailable", service, eventType, len(files))
+		for _, f := range files {
+			if err := s.mediaRepo.MarkUnavailable(f); err != nil {
+				log.Printf("[webhook] mark unavailable %s: %v", f, err)
+			}
+		}
+	case "MovieDelete", "SeriesDelete", "ArtistDelete", "AuthorDelete", "AlbumDelete", "BookDelete":
+		// Only drop items when the *arr app actually removed the files from disk
+		if !payload.filesDeleted() {
+			log.Printf("[webhook] %s/%s: files kept on disk, nothing to do", service, eventType)
+			break
+		}
+		for _, f := range payload.importedFiles() {
+			s.mediaRepo.MarkUnavailable(f)
+		}
+		if dir := payload.folderPath(); dir != "" {
+			n, err := s.mediaRepo.MarkUnavailableUnder(dir)
+			if err != nil {
+				log.Printf("[webhook] mark unavailable under %s: %v", dir, err)
+			} else {
+				log.Printf("[webhook] %s/%s: marked %d item(s) under %s unavailable", service, eventType, n, dir)
+			}
 		}
-	case "Delete", "MovieDelete", "SeriesDelete", "EpisodeFileDelete":
-		log.Printf("[webhook] %s/%s: marking items unavailable", service, eventType)
-		// Mark items as unavailable
 	default:
 		log.Printf("[webhook] %s/%s: unhandled event type", service, eventType)
 	}
@@ -316,6 +357,224 @@ func (s *Server) handleArrWebhook(w http.ResponseWriter, r *http.Request) {
 	s.respondJSON(w, http.StatusOK, Response{Success: true})
 }
 
+// arrServiceTypes maps a webhook service to the library types its files land in.
+var arrServiceTypes = map[string][]models.MediaType{
+	"sonarr":  {models.MediaTypeTVShows},
+	"radarr":  {models.MediaTypeMovies, models.MediaTypeAdultMovies},
+	"lidarr":  {models.MediaTypeMusic},
+	"readarr": {models.MediaTypeAudiobooks},
+}
+
+// arrImport scans newly imported files, replacing the previous file in place
+// when the event is an upgrade so the item keeps its history and editions.
+func (s *Server) arrImport(service string, libraryID *uuid.UUID, files, replaced []string) {
+	for i, f := range files {
+		lib := s.arrLibraryFor(service, libraryID, f)
+		if lib == nil {
+			log.Printf("[webhook] %s: no library contains %s", service, f)
+			continue
+		}
+		var err error
+		// *arr only sends one replaced path per imported file (multi-episode
+		// files share one), so pair them up by position.
+		if i < len(replaced) && replaced[i] != f {
+			err = s.scanner.ReplaceFile(lib, replaced[i], f)
+		} else {
+			err = s.scanner.ScanSingleFile(lib, f)
+		}
+		if err != nil {
+			log.Printf("[webhook] %s: scan %s: %v", service, f, err)
+		}
+	}
+	// Extra removed files (e.g. two episodes merged into one multi-episode file)
+	for i := len(files); i < len(replaced); i++ {
+		s.mediaRepo.MarkUnavailable(replaced[i])
+	}
+}
+
+// arrRename follows files the *arr app renamed on disk.
+func (s *Server) arrRename(service string, libraryID *uuid.UUID, renamed [][2]string) {
+	for _, rn := range renamed {
+		lib := s.arrLibraryFor(service, libraryID, rn[1])
+		if lib == nil {
+			continue
+		}
+		if err := s.scanner.ReplaceFile(lib, rn[0], rn[1]); err != nil {
+			log.Printf("[webhook] %s: rename %s: %v", service, rn[1], err)
+		}
+	}
+}
+
+// arrLibraryFor returns the secret's pinned library, or the library of the
+// service's media type whose folder is the longest prefix of path.
+func (s *Server) arrLibraryFor(service string, libraryID *uuid.UUID, path string) *models.Library {
+	if libraryID != nil {
+		lib, err := s.libRepo.GetByID(*libraryID)
+		if err != nil {
+			return nil
+		}
+		return lib
+	}
+	libs, err := s.libRepo.List()
+	if err != nil {
+		return nil
+	}
+	types, typed := arrServiceTypes[service]
+	var best *models.Library
+	bestLen := 0
+	for _, lib := range libs {
+		if typed && !containsMediaType(types, lib.MediaType) {
+			continue
+		}
+		roots := []string{lib.Path}
+		for _, f := range lib.Folders {
+			roots = append(roots, f.FolderPath)
+		}
+		for _, root := range roots {
+			root = strings.TrimRight(root, "/")
+			if root != "" && strings.HasPrefix(path, root+"/") && len(root) > bestLen {
+				best, bestLen = lib, len(root)
+			}
+		}
+	}
+	return best
+}
+
+func containsMediaType(types []models.MediaType, t models.MediaType) bool {
+	for _, mt := range types {
+		if mt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// arrFile is a file reference in Sonarr/Radarr/Lidarr/Readarr webhook payloads.
+type arrFile struct {
+	Path         string `json:"path"`
+	RelativePath string `json:"relativePath"`
+	PreviousPath string `json:"previousPath"`
+}
+
+// arrFolder is the movie/series/artist/author object; Radarr names the
+// folder "folderPath", the others "path".
+type arrFolder struct {
+	Path       string `json:"path"`
+	FolderPath string `json:"folderPath"`
+}
+
+func (f *arrFolder) dir() string {
+	if f == nil {
+		return ""
+	}
+	if f.FolderPath != "" {
+		return f.FolderPath
+	}
+	return f.Path
+}
+
+// arrPayload covers the fields CineVault uses from all four *arr apps.
+type arrPayload struct {
+	EventType string `json:"eventType"`
+	IsUpgrade bool   `json:"isUpgrade"`
+	// Why a *FileDelete event's file went: "upgrade", "manual", "missingFromDisk"...
+	DeleteReason string `json:"deleteReason"`
+
+	Movie  *arrFolder `json:"movie"`
+	Series *arrFolder `json:"series"`
+	Artist *arrFolder `json:"artist"`
+	Author *arrFolder `json:"author"`
+
+	MovieFile    *arrFile  `json:"movieFile"`
+	EpisodeFile  *arrFile  `json:"episodeFile"`
+	EpisodeFiles []arrFile `json:"episodeFiles"`
+	TrackFiles   []arrFile `json:"trackFiles"`
+	BookFiles    []arrFile `json:"bookFiles"`
+
+	RenamedMovieFiles   []arrFile `json:"renamedMovieFiles"`
+	RenamedEpisodeFiles []arrFile `json:"renamedEpisodeFiles"`
+
+	// Files removed by an upgrade on Download events; a bool on *Delete events
+	DeletedFiles json.RawMessage `json:"deletedFiles"`
+}
+
+func (p *arrPayload) folderPath() string {
+	for _, f := range []*arrFolder{p.Movie, p.Series, p.Artist, p.Author} {
+		if dir := f.dir(); dir != "" {
+			return dir
+		}
+	}
+	return ""
+}
+
+func (p *arrPayload) resolve(f arrFile) string {
+	if f.Path != "" {
+		return f.Path
+	}
+	if f.RelativePath != "" {
+		if dir := p.folderPath(); dir != "" {
+			return filepath.Join(dir, f.RelativePath)
+		}
+	}
+	return ""
+}
+
+// importedFiles returns the absolute paths of the files the event is about.
+func (p *arrPayload) importedFiles() []string {
+	var refs []arrFile
+	if p.MovieFile != nil {
+		refs = append(refs, *p.MovieFile)
+	}
+	if len(p.EpisodeFiles) > 0 {
+		refs = append(refs, p.EpisodeFiles...)
+	} else if p.EpisodeFile != nil {
+		refs = append(refs, *p.EpisodeFile)
+	}
+	refs = append(refs, p.TrackFiles...)
+	refs = append(refs, p.BookFiles...)
+
+	var out []string
+	seen := make(map[string]bool)
+	for _, f := range refs {
+		if path := p.resolve(f); path != "" && !seen[path] {
+			seen[path] = true
+			out = append(out, path)
+		}
+	}
+	return out
+}
+
+// replacedFiles returns the paths an upgrade removed.
+func (p *arrPayload) replacedFiles() []string {
+	var files []arrFile
+	if json.Unmarshal(p.DeletedFiles, &files) != nil {
+		return nil
+	}
+	var out []string
+	for _, f := range files {
+		if path := p.resolve(f); path != "" {
+			out = append(out, path)
+		}
+	}
+	return out
+}
+
+// filesDeleted reports whether a *Delete event removed files from disk.
+func (p *arrPayload) filesDeleted() bool {
+	var deleted bool
+	return json.Unmarshal(p.DeletedFiles, &deleted) == nil && deleted
+}
+
+func (p *arrPayload) renamedFiles() [][2]string {
+	var out [][2]string
+	for _, f := range append(p.RenamedMovieFiles, p.RenamedEpisodeFiles...) {
+		if f.PreviousPath != "" && f.Path != "" {
+			out = append(out, [2]string{f.PreviousPath, f.Path})
+		}
+	}
+	return out
+}
+
 // CRUD for webhook secrets (admin only)
 func (s *Server) handleListWebhookSecrets(w http.ResponseWriter, r *http.Request) {
 	rows, err := s.db.Query("SELECT id, name, service, library_id, is_active, last_triggered_at, created_at FROM webhook_secrets ORDER BY created_at DESC")
@@ -352,6 +611,10 @@ func (s *Server) handleCreateWebhookSecret(w http.ResponseWriter, r *http.Reques
 		s.respondError(w, http.StatusBadRequest, "name and service required")
 		return
 	}
+	if _, ok := arrServiceTypes[req.Service]; !ok && req.Service != "custom" {
+		s.respondError(w, http.StatusBadRequest, "service must be sonarr, radarr, lidarr, readarr or custom")
+		return
+	}
 	// Generate random secret
 	secretBytes := make([]byte, 32)
 	rand.Read(secretBytes)
@@ -542,34 +805,45 @@ func (s *Server) handleDownloadBackup(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleStartImport(w http.ResponseWriter, r *http.Request) {
 	userID := s.getUserID(r)
 	var req struct {
-		Source   string `json:"source"` // plex, jellyfin
-		FilePath string `json:"file_path"`
+		Source   string                 `json:"source"`    // plex, jellyfin
+		FilePath string                 `json:"file_path"` // com.plexapp.plugins.library.db, library.db or jellyfin.db
+		PathMap  []importer.PathMapping `json:"path_map"`  // source → CineVault path prefixes
+		UserMap  map[string]uuid.UUID   `json:"user_map"`  // source username → CineVault user ID
+	}
+	if json.NewDecoder(r.Body).Decode(&req) != nil || req.Source == "" || req.FilePath == "" {
+		s.respondError(w, http.StatusBadRequest, "source and file_path required")
+		return
 	}
-	if json.NewDecoder(r.Body).Decode(&req) != nil || req.Source == "" {
-		s.respondError(w, http.StatusBadRequest, "source required")
+	source := importer.Source(req.Source)
+	if source != importer.SourcePlex && source != importer.SourceJellyfin {
+		s.respondError(w, http.StatusBadRequest, "source must be plex or jellyfin")
+		return
+	}
+	if info, err := os.Stat(req.FilePath); err != nil || info.IsDir() {
+		s.respondError(w, http.StatusBadRequest, "file_path is not a readable database file")
 		return
 	}
 	id := uuid.New()
-	_, err := s.db.Exec("INSERT INTO import_jobs (id, user_id, source) VALUES ($1, $2, $3)", id, userID, req.Source)
+	_, err := s.db.Exec("INSERT INTO import_jobs (id, user_id, source, file_path) VALUES ($1, $2, $3, $4)",
+		id, userID, req.Source, req.FilePath)
 	if err != nil {
 		s.respondError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 	// Start import in background
 	go func() {
-		s.db.Exec("UPDATE import_jobs SET status = 'running' WHERE id = $1", id)
-		log.Printf("[import] starting %s import for user %s", req.Source, userID)
-		// In a full implementation, this would parse the SQLite DB
-		// For now, mark as completed
-		time.Sleep(2 * time.Second)
-		s.db.Exec("UPDATE import_jobs SET status = 'completed', completed_at = NOW() WHERE id = $1", id)
+		log.Printf("[import] starting %s import for user %s from %s", req.Source, userID, req.FilePath)
+		importer.New(s.db.DB).Run(importer.Options{
+			JobID: id, UserID: userID, Source: source, FilePath: req.FilePath,
+			PathMap: req.PathMap, UserMap: req.UserMap,
+		})
 	}()
 	s.respondJSON(w, http.StatusAccepted, Response{Success: true, Data: map[string]interface{}{"id": id}})
 }
 
 // GET /api/v1/admin/imports
 func (s *Server) handleListImports(w http.ResponseWriter, r *http.Request) {
-	rows, err := s.db.Query("SELECT id, source, status, total_items, matched_items, failed_items, created_at, completed_at FROM import_jobs ORDER BY created_at DESC LIMIT 20")
+	rows, err := s.db.Query("SELECT id, source, status, total_items, matched_items, failed_items, error_message, created_at, completed_at FROM import_jobs ORDER BY created_at DESC LIMIT 20")
 	if err != nil {
 		s.respondError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -580,14 +854,55 @@ func (s *Server) handleListImports(w http.ResponseWriter, r *http.Request) {
 		var id uuid.UUID
 		var source, status string
 		var total, matched, failed int
+		var errMsg *string
 		var createdAt time.Time
 		var completedAt *time.Time
-		if rows.Scan(&id, &source, &status, &total, &matched, &failed, &createdAt, &completedAt) != nil { continue }
+		if rows.Scan(&id, &source, &status, &total, &matched, &failed, &errMsg, &createdAt, &completedAt) != nil { continue }
 		imports = append(imports, map[string]interface{}{
 			"id": id, "source": source, "status": status, "total_items": total,
-			"matched_items": matched, "failed_items": failed, "created_at": createdAt, "completed_at": completedAt,
+			"matched_items": matched, "failed_items": failed, "error_message": errMsg,
+			"created_at": createdAt, "completed_at": completedAt,
 		})
 	}
 	if imports == nil { imports = []map[string]interface{}{} }
 	s.respondJSON(w, http.StatusOK, Response{Success: true, Data: imports})
 }
+
+// GET /api/v1/admin/imports/{id}/report — source items that could not be matched
+func (s *Server) handleImportReport(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	var source, status string
+	var total, matched, failed int
+	if err := s.db.QueryRow("SELECT source, status, total_items, matched_items, failed_items FROM import_jobs WHERE id = $1",
+		jobID).Scan(&source, &status, &total, &matched, &failed); err != nil {
+		s.respondError(w, http.StatusNotFound, "import not found")
+		return
+	}
+	rows, err := s.db.Query(`SELECT source_item_id, kind, title, year, file_path, COALESCE(provider_ids, '{}'::jsonb), reason
+		FROM import_job_items WHERE job_id = $1 ORDER BY kind, title`, jobID)
+	if err != nil {
+		s.respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+	unmatched := []map[string]interface{}{}
+	for rows.Next() {
+		var srcID, kind, title, reason string
+		var year *int
+		var filePath *string
+		var providerIDs json.RawMessage
+		if rows.Scan(&srcID, &kind, &title, &year, &filePath, &providerIDs, &reason) != nil { continue }
+		unmatched = append(unmatched, map[string]interface{}{
+			"source_item_id": srcID, "kind": kind, "title": title, "year": year,
+			"file_path": filePath, "provider_ids": providerIDs, "reason": reason,
+		})
+	}
+	s.respondJSON(w, http.StatusOK, Response{Success: true, Data: map[string]interface{}{
+		"id": jobID, "source": source, "status": status, "total_items": total,
+		"matched_items": matched, "failed_items": failed, "unmatched": unmatched,
+	}})
+}
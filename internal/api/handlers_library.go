@@ -2,11 +2,13 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/JustinTDCT/CineVault/internal/jobs"
+	"github.com/JustinTDCT/CineVault/internal/metadata"
 	"github.com/JustinTDCT/CineVault/internal/models"
 	"github.com/google/uuid"
 	"github.com/hibiken/asynq"
@@ -45,6 +47,14 @@ type createLibraryRequest struct {
 	AdultContentType   *string  `json:"adult_content_type"`
 	ScanInterval       string   `json:"scan_interval"`
 	WatchEnabled       bool     `json:"watch_enabled"`
+	WatchMode          string   `json:"watch_mode"`
+	// Scan concurrency; 0 uses the server default
+	ScanProbeWorkers    *int `json:"scan_probe_workers"`
+	ScanCPUWorkers      *int `json:"scan_cpu_workers"`
+	ScanMetadataWorkers *int `json:"scan_metadata_workers"`
+	// Metadata providers in order, and per-field provider precedence
+	MetadataProviders    []string            `json:"metadata_providers"`
+	MetadataFieldSources map[string][]string `json:"metadata_field_sources"`
 }
 
 func (s *Server) handleCreateLibrary(w http.ResponseWriter, r *http.Request) {
@@ -108,26 +118,54 @@ func (s *Server) handleCreateLibrary(w http.ResponseWriter, r *http.Request) {
 		scanInterval = req.ScanInterval
 	}
 
+	watchMode := models.WatchModeFSNotify
+	if req.WatchMode != "" {
+		watchMode = models.WatchMode(req.WatchMode)
+	}
+	if !watchMode.Valid() {
+		s.respondError(w, http.StatusBadRequest, "watch_mode must be fsnotify, poll or both")
+		return
+	}
+
+	probeWorkers, cpuWorkers, metadataWorkers := 0, 0, 0
+	if !applyScanWorkers(req.ScanProbeWorkers, &probeWorkers) ||
+		!applyScanWorkers(req.ScanCPUWorkers, &cpuWorkers) ||
+		!applyScanWorkers(req.ScanMetadataWorkers, &metadataWorkers) {
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("scan worker counts must be between 0 and %d", maxScanWorkers))
+		return
+	}
+
+	if msg := s.validateMetadataProviders(req.MetadataProviders, req.MetadataFieldSources); msg != "" {
+		s.respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
 	library := models.Library{
-		ID:                 uuid.New(),
-		Name:               req.Name,
-		MediaType:          models.MediaType(req.MediaType),
-		Path:               primaryPath,
-		IsEnabled:          req.IsEnabled,
-		SeasonGrouping:     req.SeasonGrouping,
-		AccessLevel:        accessLevel,
-		IncludeInHomepage:  includeHomepage,
-		IncludeInSearch:    includeSearch,
-		RetrieveMetadata:   retrieveMeta,
-		NFOImport:          nfoImport,
-		NFOExport:          nfoExport,
-		PreferLocalArtwork: preferLocalArtwork,
-		CreatePreviews:     createPreviews,
-		CreateThumbnails:   createThumbnails,
-		AudioNormalization: audioNormalization,
-		AdultContentType:   req.AdultContentType,
-		ScanInterval:       scanInterval,
-		WatchEnabled:       req.WatchEnabled,
+		ID:                   uuid.New(),
+		Name:                 req.Name,
+		MediaType:            models.MediaType(req.MediaType),
+		Path:                 primaryPath,
+		IsEnabled:            req.IsEnabled,
+		SeasonGrouping:       req.SeasonGrouping,
+		AccessLevel:          accessLevel,
+		IncludeInHomepage:    includeHomepage,
+		IncludeInSearch:      includeSearch,
+		RetrieveMetadata:     retrieveMeta,
+		NFOImport:            nfoImport,
+		NFOExport:            nfoExport,
+		PreferLocalArtwork:   preferLocalArtwork,
+		CreatePreviews:       createPreviews,
+		CreateThumbnails:     createThumbnails,
+		AudioNormalization:   audioNormalization,
+		AdultContentType:     req.AdultContentType,
+		ScanInterval:         scanInterval,
+		WatchEnabled:         req.WatchEnabled,
+		WatchMode:            watchMode,
+		ScanProbeWorkers:     probeWorkers,
+		ScanCPUWorkers:       cpuWorkers,
+		ScanMetadataWorkers:  metadataWorkers,
+		MetadataProviders:    req.MetadataProviders,
+		MetadataFieldSources: req.MetadataFieldSources,
 	}
 
 	// Calculate initial next_scan_at if interval is set
@@ -187,7 +225,7 @@ func (s *Server) handleCreateLibrary(w http.ResponseWriter, r *http.Request) {
 		}
 		// Synchronous fallback
 		log.Printf("Auto-scan: starting sync scan for new library %q", library.Name)
-		result, err := s.scanner.ScanLibrary(created)
+		result, err := s.scanner.ScanLibrary(created, false)
 		if err != nil {
 			log.Printf("Auto-scan: sync scan failed for library %q: %v", library.Name, err)
 			return
@@ -247,6 +285,14 @@ type updateLibraryRequest struct {
 	AdultContentType   *string  `json:"adult_content_type"`
 	ScanInterval       string   `json:"scan_interval"`
 	WatchEnabled       bool     `json:"watch_enabled"`
+	WatchMode          string   `json:"watch_mode"`
+	// Scan concurrency; 0 uses the server default
+	ScanProbeWorkers    *int `json:"scan_probe_workers"`
+	ScanCPUWorkers      *int `json:"scan_cpu_workers"`
+	ScanMetadataWorkers *int `json:"scan_metadata_workers"`
+	// Metadata providers in order, and per-field provider precedence
+	MetadataProviders    []string            `json:"metadata_providers"`
+	MetadataFieldSources map[string][]string `json:"metadata_field_sources"`
 }
 
 func (s *Server) handleUpdateLibrary(w http.ResponseWriter, r *http.Request) {
@@ -327,6 +373,35 @@ func (s *Server) handleUpdateLibrary(w http.ResponseWriter, r *http.Request) {
 		scanInterval = existing.ScanInterval
 	}
 
+	watchMode := existing.WatchMode
+	if req.WatchMode != "" {
+		watchMode = models.WatchMode(req.WatchMode)
+	}
+	if !watchMode.Valid() {
+		s.respondError(w, http.StatusBadRequest, "watch_mode must be fsnotify, poll or both")
+		return
+	}
+
+	probeWorkers, cpuWorkers, metadataWorkers := existing.ScanProbeWorkers, existing.ScanCPUWorkers, existing.ScanMetadataWorkers
+	if !applyScanWorkers(req.ScanProbeWorkers, &probeWorkers) ||
+		!applyScanWorkers(req.ScanCPUWorkers, &cpuWorkers) ||
+		!applyScanWorkers(req.ScanMetadataWorkers, &metadataWorkers) {
+		s.respondError(w, http.StatusBadRequest, fmt.Sprintf("scan worker counts must be between 0 and %d", maxScanWorkers))
+		return
+	}
+
+	providers, fieldSources := existing.MetadataProviders, existing.MetadataFieldSources
+	if req.MetadataProviders != nil {
+		providers = req.MetadataProviders
+	}
+	if req.MetadataFieldSources != nil {
+		fieldSources = req.MetadataFieldSources
+	}
+	if msg := s.validateMetadataProviders(providers, fieldSources); msg != "" {
+		s.respondError(w, http.StatusBadRequest, msg)
+		return
+	}
+
 	// Calculate next_scan_at if interval changed
 	var nextScanAt *time.Time
 	if scanInterval != "disabled" {
@@ -338,27 +413,33 @@ func (s *Server) handleUpdateLibrary(w http.ResponseWriter, r *http.Request) {
 	}
 
 	library := models.Library{
-		ID:                 id,
-		Name:               req.Name,
-		MediaType:          existing.MediaType,
-		Path:               primaryPath,
-		IsEnabled:          req.IsEnabled,
-		ScanOnStartup:      req.ScanOnStartup,
-		SeasonGrouping:     req.SeasonGrouping,
-		AccessLevel:        accessLevel,
-		IncludeInHomepage:  includeHomepage,
-		IncludeInSearch:    includeSearch,
-		RetrieveMetadata:   retrieveMeta,
-		NFOImport:          nfoImport,
-		NFOExport:          nfoExport,
-		PreferLocalArtwork: preferLocalArtwork,
-		CreatePreviews:     createPreviews,
-		CreateThumbnails:   createThumbnails,
-		AudioNormalization: audioNormalization,
-		AdultContentType:   adultContentType,
-		ScanInterval:       scanInterval,
-		NextScanAt:         nextScanAt,
-		WatchEnabled:       req.WatchEnabled,
+		ID:                   id,
+		Name:                 req.Name,
+		MediaType:            existing.MediaType,
+		Path:                 primaryPath,
+		IsEnabled:            req.IsEnabled,
+		ScanOnStartup:        req.ScanOnStartup,
+		SeasonGrouping:       req.SeasonGrouping,
+		AccessLevel:          accessLevel,
+		IncludeInHomepage:    includeHomepage,
+		IncludeInSearch:      includeSearch,
+		RetrieveMetadata:     retrieveMeta,
+		NFOImport:            nfoImport,
+		NFOExport:            nfoExport,
+		PreferLocalArtwork:   preferLocalArtwork,
+		CreatePreviews:       createPreviews,
+		CreateThumbnails:     createThumbnails,
+		AudioNormalization:   audioNormalization,
+		AdultContentType:     adultContentType,
+		ScanInterval:         scanInterval,
+		NextScanAt:           nextScanAt,
+		WatchEnabled:         req.WatchEnabled,
+		WatchMode:            watchMode,
+		ScanProbeWorkers:     probeWorkers,
+		ScanCPUWorkers:       cpuWorkers,
+		ScanMetadataWorkers:  metadataWorkers,
+		MetadataProviders:    providers,
+		MetadataFieldSources: fieldSources,
 	}
 
 	if err := s.libRepo.Update(&library); err != nil {
@@ -429,12 +510,15 @@ func (s *Server) handleScanLibrary(w http.ResponseWriter, r *http.Request) {
 		s.respondError(w, http.StatusNotFound, "library not found")
 		return
 	}
+	// ?deep=true re-probes every file instead of only new and changed ones
+	deep := r.URL.Query().Get("deep") == "true"
 
 	// If job queue is available, enqueue async scan (deduplicated by library ID)
 	if s.jobQueue != nil {
 		uniqueID := "scan:" + id.String()
 		jobID, err := s.jobQueue.EnqueueUnique(jobs.TaskScanLibrary, jobs.ScanPayload{
 			LibraryID: id.String(),
+			Deep:      deep,
 		}, uniqueID, asynq.Timeout(6*time.Hour), asynq.Retention(1*time.Hour))
 		if err != nil {
 			// Fallback to synchronous scan
@@ -452,15 +536,15 @@ func (s *Server) handleScanLibrary(w http.ResponseWriter, r *http.Request) {
 	// Synchronous fallback
 	log.Printf("Starting sync scan for library %q (%s) at %s", library.Name, library.MediaType, library.Path)
 
-	result, err := s.scanner.ScanLibrary(library)
+	result, err := s.scanner.ScanLibrary(library, deep)
 	if err != nil {
 		s.respondError(w, http.StatusInternalServerError, "scan failed: "+err.Error())
 		return
 	}
 
 	_ = s.libRepo.UpdateLastScan(id)
-	log.Printf("Scan complete: %d found, %d added, %d skipped, %d errors",
-		result.FilesFound, result.FilesAdded, result.FilesSkipped, len(result.Errors))
+	log.Printf("Scan complete: %d found, %d added, %d changed, %d removed, %d skipped, %d errors",
+		result.FilesFound, result.FilesAdded, result.FilesChanged, result.FilesRemoved, result.FilesSkipped, len(result.Errors))
 
 	s.respondJSON(w, http.StatusOK, Response{Success: true, Data: result})
 }
@@ -625,3 +709,57 @@ func calculateNextScan(interval string) *time.Time {
 	}
 	return &next
 }
+
+// handleMetadataProviders lists the registered metadata providers and the
+// fields a library can give its own provider precedence.
+func (s *Server) handleMetadataProviders(w http.ResponseWriter, r *http.Request) {
+	providers := []string{}
+	if s.metadataRegistry != nil {
+		providers = s.metadataRegistry.Names()
+	}
+	s.respondJSON(w, http.StatusOK, map[string]interface{}{
+		"providers": providers,
+		"fields":    append([]string{metadata.FieldArtwork}, metadata.MergeFields...),
+	})
+}
+
+// maxScanWorkers caps each per-library scan concurrency setting.
+const maxScanWorkers = 64
+
+// applyScanWorkers copies a requested scan worker count into dst if one was
+// given, reporting false if it is out of range.
+func applyScanWorkers(req *int, dst *int) bool {
+	if req == nil {
+		return true
+	}
+	if *req < 0 || *req > maxScanWorkers {
+		return false
+	}
+	*dst = *req
+	return true
+}
+
+// validateMetadataProviders checks that a library's metadata providers and
+// per-field sources name registered providers and mergeable fields,
+// returning a message for the client if not.
+func (s *Server) validateMetadataProviders(providers []string, fieldSources map[string][]string) string {
+	known := func(name string) bool {
+		return s.metadataRegistry != nil && s.metadataRegistry.Get(name) != nil
+	}
+	for _, name := range providers {
+		if !known(name) {
+			return fmt.Sprintf("unknown metadata provider %q", name)
+		}
+	}
+	for field, sources := range fieldSources {
+		if !metadata.IsMergeField(field) {
+			return fmt.Sprintf("unknown metadata field %q", field)
+		}
+		for _, name := range sources {
+			if !known(name) {
+				return fmt.Sprintf("unknown metadata provider %q for %s", name, field)
+			}
+		}
+	}
+	return ""
+}
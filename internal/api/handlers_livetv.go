@@ -0,0 +1,306 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/JustinTDCT/CineVault/internal/stream"
+	"github.com/google/uuid"
+)
+
+// ══════════════════════ Live TV Streaming ══════════════════════
+
+// GET /api/v1/livetv/channels/{id}/stream — Live-edge HLS playlist for a channel.
+// The first viewer opens the tuner; later viewers join the same session.
+func (s *Server) handleLiveStream(w http.ResponseWriter, r *http.Request) {
+	channelID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid channel id")
+		return
+	}
+	session, ok := s.startLiveSession(w, r, channelID)
+	if !ok {
+		return
+	}
+	// Segment URIs resolve relative to .../stream, so point them into .../stream/
+	s.serveLivePlaylist(w, r, session, session.WindowSegments, "stream/")
+}
+
+// GET /api/v1/livetv/channels/{id}/stream/{file} — Timeshift playlist
+// (timeshift.m3u8, the whole on-disk buffer) or a live segment.
+func (s *Server) handleLiveStreamFile(w http.ResponseWriter, r *http.Request) {
+	channelID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid channel id")
+		return
+	}
+	file := r.PathValue("file")
+	if file == "timeshift.m3u8" {
+		session, ok := s.startLiveSession(w, r, channelID)
+		if !ok {
+			return
+		}
+		s.serveLivePlaylist(w, r, session, 0, "")
+		return
+	}
+
+	session := s.transcoder.GetSession(stream.LiveSessionKey(channelID.String()))
+	if session == nil {
+		s.respondError(w, http.StatusNotFound, "channel is not streaming")
+		return
+	}
+	if filepath.Base(file) != file || !strings.HasSuffix(file, ".ts") {
+		s.respondError(w, http.StatusBadRequest, "invalid segment")
+		return
+	}
+	segPath := filepath.Join(session.OutputDir, file)
+	if _, err := os.Stat(segPath); err != nil {
+		// Paused past the start of the buffer, or not written yet
+		s.respondError(w, http.StatusNotFound, "segment not available")
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp2t")
+	http.ServeFile(w, r, segPath)
+}
+
+// startLiveSession joins or starts the shared live session for a channel,
+// reserving one of its tuner's slots when a new upstream connection is needed.
+func (s *Server) startLiveSession(w http.ResponseWriter, r *http.Request, channelID uuid.UUID) (*stream.Session, bool) {
+	if session := s.transcoder.GetSession(stream.LiveSessionKey(channelID.String())); session != nil {
+		return session, true
+	}
+
+	src, err := s.dvrRepo.GetChannelSource(channelID)
+	if err != nil {
+		s.respondError(w, http.StatusNotFound, "channel not found")
+		return nil, false
+	}
+
+	quality := r.URL.Query().Get("quality")
+	if quality == "" {
+		quality, _ = s.settingsRepo.Get("livetv_quality")
+	}
+	if _, ok := stream.Qualities[quality]; !ok && quality != "copy" {
+		quality = "720p"
+	}
+	bufferMinutes := 30
+	if v, _ := s.settingsRepo.Get("livetv_buffer_minutes"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			bufferMinutes = n
+		}
+	}
+
+	session, err := s.transcoder.StartLive(channelID.String(), src.URL(), stream.LiveOptions{
+		Quality: quality,
+		Buffer:  time.Duration(bufferMinutes) * time.Minute,
+		Acquire: func() bool { return s.tuners.Acquire(src.TunerID, src.TunerCount) },
+		OnStop:  func() { s.tuners.Release(src.TunerID) },
+	})
+	if errors.Is(err, stream.ErrNoTuner) {
+		s.respondError(w, http.StatusServiceUnavailable, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		s.respondError(w, http.StatusInternalServerError, "live stream failed: "+err.Error())
+		return nil, false
+	}
+	return session, true
+}
+
+// serveLivePlaylist waits for the first segment of a freshly started session,
+// then serves ffmpeg's buffer playlist trimmed to window segments (0 = all).
+func (s *Server) serveLivePlaylist(w http.ResponseWriter, r *http.Request, session *stream.Session, window int, uriPrefix string) {
+	bufferPath := filepath.Join(session.OutputDir, stream.LiveBufferPlaylist)
+	var playlist []byte
+	for i := 0; i < 30; i++ {
+		if data, err := os.ReadFile(bufferPath); err == nil {
+			if playlist = stream.LivePlaylist(data, window, uriPrefix); playlist != nil {
+				break
+			}
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	if playlist == nil {
+		s.respondError(w, http.StatusAccepted, "tuning in progress")
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(playlist)
+}
+
+// ══════════════════════ DVR Series Rules ══════════════════════
+
+type recordingRuleRequest struct {
+	Title           string  `json:"title"`
+	SeriesID        *string `json:"series_id"`
+	ChannelID       *string `json:"channel_id"`
+	NewOnly         *bool   `json:"new_only"`
+	KeepCount       *int    `json:"keep_count"`
+	PadStartMinutes *int    `json:"pad_start_minutes"`
+	PadEndMinutes   *int    `json:"pad_end_minutes"`
+	IsActive        *bool   `json:"is_active"`
+}
+
+// apply copies the request onto a rule, leaving omitted fields unchanged.
+func (req *recordingRuleRequest) apply(rule *models.DVRRule) error {
+	if strings.TrimSpace(req.Title) != "" {
+		rule.Title = strings.TrimSpace(req.Title)
+	}
+	if req.SeriesID != nil {
+		rule.SeriesID = nil
+		if *req.SeriesID != "" {
+			rule.SeriesID = req.SeriesID
+		}
+	}
+	if req.ChannelID != nil {
+		rule.ChannelID = nil
+		if *req.ChannelID != "" {
+			id, err := uuid.Parse(*req.ChannelID)
+			if err != nil {
+				return errors.New("invalid channel_id")
+			}
+			rule.ChannelID = &id
+		}
+	}
+	if req.NewOnly != nil {
+		rule.NewOnly = *req.NewOnly
+	}
+	if req.KeepCount != nil {
+		rule.KeepCount = *req.KeepCount
+	}
+	if req.PadStartMinutes != nil {
+		rule.PadStartMinutes = *req.PadStartMinutes
+	}
+	if req.PadEndMinutes != nil {
+		rule.PadEndMinutes = *req.PadEndMinutes
+	}
+	if req.IsActive != nil {
+		rule.IsActive = *req.IsActive
+	}
+	if rule.KeepCount < 0 || rule.PadStartMinutes < 0 || rule.PadEndMinutes < 0 {
+		return errors.New("keep_count and padding must not be negative")
+	}
+	if rule.PadStartMinutes > 60 || rule.PadEndMinutes > 180 {
+		return errors.New("padding is limited to 60 minutes before and 180 minutes after")
+	}
+	return nil
+}
+
+// ruleForRequest loads a rule the caller may modify: their own, or any for admins.
+func (s *Server) ruleForRequest(w http.ResponseWriter, r *http.Request) (*models.DVRRule, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid id")
+		return nil, false
+	}
+	rule, err := s.dvrRepo.GetRule(id)
+	if err != nil {
+		s.respondError(w, http.StatusNotFound, "rule not found")
+		return nil, false
+	}
+	if rule.UserID != s.getUserID(r) && models.UserRole(r.Header.Get("X-User-Role")) != models.RoleAdmin {
+		s.respondError(w, http.StatusForbidden, "not your rule")
+		return nil, false
+	}
+	return rule, true
+}
+
+// GET /api/v1/livetv/recordings/rules — Caller's series rules (all rules for admins)
+func (s *Server) handleListRecordingRules(w http.ResponseWriter, r *http.Request) {
+	userID := s.getUserID(r)
+	if models.UserRole(r.Header.Get("X-User-Role")) == models.RoleAdmin {
+		userID = uuid.Nil
+	}
+	rules, err := s.dvrRepo.ListRules(userID)
+	if err != nil {
+		s.respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if rules == nil {
+		rules = []*models.DVRRule{}
+	}
+	s.respondJSON(w, http.StatusOK, Response{Success: true, Data: rules})
+}
+
+// POST /api/v1/livetv/recordings/rules — Create a series rule and schedule its matches
+func (s *Server) handleCreateRecordingRule(w http.ResponseWriter, r *http.Request) {
+	var req recordingRuleRequest
+	if json.NewDecoder(r.Body).Decode(&req) != nil || strings.TrimSpace(req.Title) == "" {
+		s.respondError(w, http.StatusBadRequest, "title required")
+		return
+	}
+	rule := &models.DVRRule{UserID: s.getUserID(r), IsActive: true}
+	if err := req.apply(rule); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := s.dvrRepo.CreateRule(rule); err != nil {
+		s.respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	scheduled := 0
+	if rule.IsActive {
+		scheduled = s.seriesRules.ApplyRule(rule)
+	}
+	s.respondJSON(w, http.StatusCreated, Response{Success: true, Data: map[string]interface{}{
+		"rule": rule, "scheduled": scheduled,
+	}})
+}
+
+// PUT /api/v1/livetv/recordings/rules/{id}
+func (s *Server) handleUpdateRecordingRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := s.ruleForRequest(w, r)
+	if !ok {
+		return
+	}
+	var req recordingRuleRequest
+	if json.NewDecoder(r.Body).Decode(&req) != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+	if err := req.apply(rule); err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := s.dvrRepo.UpdateRule(rule); err != nil {
+		s.respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// Re-match from scratch so title, channel and padding changes take effect
+	if err := s.dvrRepo.ClearScheduled(rule.ID); err != nil {
+		s.respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	scheduled := 0
+	if rule.IsActive {
+		scheduled = s.seriesRules.ApplyRule(rule)
+	}
+	s.respondJSON(w, http.StatusOK, Response{Success: true, Data: map[string]interface{}{
+		"rule": rule, "scheduled": scheduled,
+	}})
+}
+
+// DELETE /api/v1/livetv/recordings/rules/{id} — Remove a rule and its pending recordings
+func (s *Server) handleDeleteRecordingRule(w http.ResponseWriter, r *http.Request) {
+	rule, ok := s.ruleForRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := s.dvrRepo.DeleteRule(rule.ID); err != nil {
+		s.respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.respondJSON(w, http.StatusOK, Response{Success: true})
+}
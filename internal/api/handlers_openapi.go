@@ -106,11 +106,12 @@ func buildAPIPaths() map[string]interface{} {
 	merge("/libraries/{id}", "get", endpoint("Get Library", "libraries", "Get library details"))
 	merge("/libraries/{id}", "put", endpoint("Update Library", "libraries", "Update library"))
 	merge("/libraries/{id}", "delete", endpoint("Delete Library", "libraries", "Delete library"))
-	merge("/libraries/{id}/scan", "post", endpoint("Scan Library", "libraries", "Trigger library scan"))
+	merge("/libraries/{id}/scan", "post", endpoint("Scan Library", "libraries", "Trigger library scan of new and changed files; ?deep=true re-probes every file"))
 	merge("/libraries/{id}/auto-match", "post", endpoint("Auto Match Library", "libraries", "Run auto-match on library"))
 	merge("/libraries/{id}/refresh-metadata", "post", endpoint("Refresh Metadata", "libraries", "Refresh library metadata"))
 	merge("/libraries/{id}/phash", "post", endpoint("Generate pHash", "libraries", "Generate perceptual hashes"))
 	merge("/libraries/{id}/filters", "get", endpoint("Library Filters", "libraries", "Get available filters for library"))
+	merge("/metadata/providers", "get", endpoint("Metadata Providers", "libraries", "List metadata providers and the fields a library can set per-field sources for"))
 	merge("/libraries/{id}/detect-segments", "post", endpoint("Detect Segments", "libraries", "Trigger segment detection"))
 
 	// ── TV Shows ──
@@ -166,12 +167,31 @@ func buildAPIPaths() map[string]interface{} {
 
 	// ── Streaming ──
 	merge("/stream/{mediaId}/info", "get", endpoint("Stream Info", "streaming", "Get stream metadata and available qualities"))
+	merge("/stream/{mediaId}/playback-info", "post", endpoint("Playback Info", "streaming", "Choose direct play, remux or transcode for a device profile"))
 	merge("/stream/{mediaId}/master.m3u8", "get", endpoint("HLS Master", "streaming", "Get HLS master playlist"))
 	merge("/stream/{mediaId}/{quality}/{segment}", "get", endpoint("HLS Segment", "streaming", "Get HLS segment"))
+	merge("/stream/sessions/{sessionId}", "delete", endpoint("Stop Transcode", "streaming", "Stop a transcode session"))
 	merge("/stream/{mediaId}/direct", "get", endpoint("Direct Stream", "streaming", "Stream media directly or via remux"))
+	merge("/stream/{mediaId}/audio", "get", endpoint("Audio Stream", "streaming", "Stream a music track as the original or converted to Opus, MP3 or AAC with ReplayGain"))
+	merge("/stream/{mediaId}/trickplay.vtt", "get", endpoint("Trickplay WebVTT", "streaming", "Get a WebVTT thumbnails track pointing into the sprite sheet"))
+	merge("/stream/{mediaId}/trickplay.bif", "get", endpoint("Trickplay BIF", "streaming", "Get Roku BIF thumbnails built from the sprite sheet"))
+	merge("/stream/{mediaId}/trickplay/sprite.jpg", "get", endpoint("Trickplay Sprite", "streaming", "Get the timeline thumbnail sprite sheet"))
+	merge("/stream/{mediaId}/trickplay/images.m3u8", "get", endpoint("Trickplay Image Playlist", "streaming", "Get the HLS image playlist over the sprite sheet"))
+	merge("/stream/{mediaId}/trickplay/iframes.m3u8", "get", endpoint("Trickplay I-Frame Playlist", "streaming", "Get the HLS I-frame playlist of thumbnail frames"))
+	merge("/stream/{mediaId}/trickplay/iframe/{segment}", "get", endpoint("Trickplay I-Frame Segment", "streaming", "Get one I-frame segment"))
+	merge("/albums/{id}/gapless", "get", endpoint("Album Gapless Info", "streaming", "Get exact track durations, gains and audio URLs for gapless album playback"))
 	merge("/stream/{mediaId}/subtitles/{id}", "get", endpoint("Stream Subtitle", "streaming", "Get subtitle content"))
+	merge("/stream/{mediaId}/subtitles/{id}/playlist.m3u8", "get", endpoint("Subtitle Playlist", "streaming", "Get a subtitle as a segmented WebVTT HLS playlist"))
+	merge("/stream/{mediaId}/subtitles/{id}/{segment}", "get", endpoint("Subtitle Segment", "streaming", "Get one WebVTT segment of a subtitle playlist"))
 	merge("/stream/{mediaId}/manifest.mpd", "get", endpoint("DASH Manifest", "streaming", "Get DASH manifest"))
 
+	// ── Optimized Versions ──
+	merge("/optimized/profiles", "get", endpoint("List Optimize Profiles", "streaming", "List profiles optimized versions can be made in"))
+	merge("/optimized", "get", endpoint("List Optimized Versions", "streaming", "List optimized versions"))
+	merge("/optimized", "post", endpoint("Create Optimized Versions", "streaming", "Queue optimized versions of a media item, season or collection"))
+	merge("/optimized/{id}", "delete", endpoint("Delete Optimized Version", "streaming", "Delete an optimized version"))
+	merge("/optimized/{id}/download", "get", endpoint("Download Optimized Version", "streaming", "Download an optimized version (resumable)"))
+
 	// ── Editions ──
 	merge("/editions", "get", endpoint("List Editions", "media", "List edition groups"))
 	merge("/editions", "post", endpoint("Create Edition", "media", "Create edition group"))
@@ -351,7 +371,7 @@ func buildAPIPaths() map[string]interface{} {
 
 	// ── Sync (Watch Together) ──
 	merge("/sync/create", "post", endpoint("Create Sync Session", "sync", "Create Watch Together session"))
-	merge("/sync/join", "post", endpoint("Join Sync Session", "sync", "Join Watch Together session"))
+	merge("/sync/join", "post", endpoint("Join Sync Session", "sync", "Join Watch Together session by invite code; playback then syncs over the WebSocket (sync:join)"))
 	merge("/sync/{id}", "get", endpoint("Sync Info", "sync", "Get sync session info"))
 	merge("/sync/{id}", "delete", endpoint("End Sync Session", "sync", "End Watch Together session"))
 	merge("/sync/{id}/action", "post", endpoint("Sync Action", "sync", "Send sync action (play/pause/seek)"))
@@ -366,12 +386,14 @@ func buildAPIPaths() map[string]interface{} {
 	// ── DLNA ──
 	merge("/dlna/config", "get", endpoint("Get DLNA Config", "dlna", "Get DLNA configuration"))
 	merge("/dlna/config", "put", endpoint("Update DLNA Config", "dlna", "Update DLNA configuration"))
+	merge("/dlna/renderers", "get", endpoint("Discover DLNA Renderers", "dlna", "Find MediaRenderer devices (smart TVs) for Play To via SSDP"))
 
 	// ── Casting (Chromecast) ──
-	merge("/cast/session", "post", endpoint("Create Cast Session", "casting", "Start Chromecast session"))
+	merge("/cast/devices", "get", endpoint("Discover Cast Devices", "casting", "Find Chromecast receivers on the local network via mDNS"))
+	merge("/cast/session", "post", endpoint("Create Cast Session", "casting", "Start Chromecast session; with device_address the server drives the receiver"))
 	merge("/cast/sessions", "get", endpoint("List Cast Sessions", "casting", "List active cast sessions"))
 	merge("/cast/{id}", "delete", endpoint("End Cast Session", "casting", "End Chromecast session"))
-	merge("/cast/session/{id}/command", "put", endpoint("Cast Command", "casting", "Send command to cast session (play/pause/seek)"))
+	merge("/cast/session/{id}/command", "put", endpoint("Cast Command", "casting", "Send command to cast session (play/pause/seek/volume/stop)"))
 
 	// ── Markers ──
 	merge("/markers/{id}", "delete", endpoint("Delete Marker", "media", "Delete scene marker"))
@@ -380,9 +402,16 @@ func buildAPIPaths() map[string]interface{} {
 	merge("/livetv/tuners", "get", endpoint("List Tuners", "live-tv", "List tuners"))
 	merge("/livetv/tuners", "post", endpoint("Create Tuner", "live-tv", "Create tuner"))
 	merge("/livetv/tuners/{id}", "delete", endpoint("Delete Tuner", "live-tv", "Delete tuner"))
+	merge("/livetv/tuners/{id}/refresh", "post", endpoint("Refresh Tuner Guide", "live-tv", "Reload channel lineup and XMLTV guide"))
 	merge("/livetv/epg", "get", endpoint("Get EPG", "live-tv", "Get electronic program guide"))
+	merge("/livetv/channels/{id}/stream", "get", endpoint("Live Channel Stream", "live-tv", "Live-edge HLS playlist for a channel"))
+	merge("/livetv/channels/{id}/stream/{file}", "get", endpoint("Live Channel Timeshift/Segment", "live-tv", "Timeshift playlist (timeshift.m3u8) or live segment"))
 	merge("/livetv/recordings", "post", endpoint("Schedule Recording", "live-tv", "Schedule DVR recording"))
 	merge("/livetv/recordings", "get", endpoint("List Recordings", "live-tv", "List DVR recordings"))
+	merge("/livetv/recordings/rules", "get", endpoint("List Recording Rules", "live-tv", "List DVR series rules"))
+	merge("/livetv/recordings/rules", "post", endpoint("Create Recording Rule", "live-tv", "Create series rule and schedule matching programmes"))
+	merge("/livetv/recordings/rules/{id}", "put", endpoint("Update Recording Rule", "live-tv", "Update series rule"))
+	merge("/livetv/recordings/rules/{id}", "delete", endpoint("Delete Recording Rule", "live-tv", "Delete series rule and its pending recordings"))
 
 	// ── Notifications ──
 	merge("/notifications/channels", "get", endpoint("List Notification Channels", "notifications", "List notification channels"))
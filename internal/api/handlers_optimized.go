@@ -0,0 +1,289 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/JustinTDCT/CineVault/internal/ffmpeg"
+	"github.com/JustinTDCT/CineVault/internal/jobs"
+	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/JustinTDCT/CineVault/internal/stream"
+	"github.com/google/uuid"
+)
+
+// ──────────────────── Optimized Versions ────────────────────
+
+// GET /api/v1/optimized/profiles — profiles optimized versions can be made in
+func (s *Server) handleListOptimizeProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles := make([]stream.OptimizeProfile, 0, len(stream.OptimizeProfiles))
+	for _, p := range stream.OptimizeProfiles {
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return stream.Qualities[profiles[i].Quality].Height < stream.Qualities[profiles[j].Quality].Height
+	})
+	s.respondJSON(w, http.StatusOK, Response{Success: true, Data: profiles})
+}
+
+// GET /api/v1/optimized — the caller's optimized versions (every user's for
+// admins); ?media_id= lists one media item's versions instead
+func (s *Server) handleListOptimized(w http.ResponseWriter, r *http.Request) {
+	var versions []*models.OptimizedVersion
+	var err error
+	if m := r.URL.Query().Get("media_id"); m != "" {
+		mediaID, perr := uuid.Parse(m)
+		if perr != nil {
+			s.respondError(w, http.StatusBadRequest, "invalid media_id")
+			return
+		}
+		versions, err = s.optimizedRepo.ListByMedia(mediaID)
+	} else {
+		userID := s.getUserID(r)
+		if models.UserRole(r.Header.Get("X-User-Role")) == models.RoleAdmin && r.URL.Query().Get("mine") != "true" {
+			userID = uuid.Nil
+		}
+		versions, err = s.optimizedRepo.List(userID)
+	}
+	if err != nil {
+		s.respondError(w, http.StatusInternalServerError, "failed to list optimized versions")
+		return
+	}
+	if versions == nil {
+		versions = []*models.OptimizedVersion{}
+	}
+	s.respondJSON(w, http.StatusOK, Response{Success: true, Data: versions})
+}
+
+// POST /api/v1/optimized — queue optimized versions of a media item, every
+// episode of a season or every item of a collection
+func (s *Server) handleCreateOptimized(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Profile      string     `json:"profile"`
+		MediaID      *uuid.UUID `json:"media_id"`
+		SeasonID     *uuid.UUID `json:"season_id"`
+		CollectionID *uuid.UUID `json:"collection_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if _, ok := stream.OptimizeProfiles[req.Profile]; !ok {
+		s.respondError(w, http.StatusBadRequest, "unknown profile")
+		return
+	}
+
+	mediaIDs, err := s.optimizeTargets(req.MediaID, req.SeasonID, req.CollectionID)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(mediaIDs) == 0 {
+		s.respondError(w, http.StatusBadRequest, "nothing to optimize")
+		return
+	}
+
+	// Quotas: storage for everyone, a version count for non-admins
+	userID := s.getUserID(r)
+	if quota := int64(s.config.Optimized.QuotaGB) << 30; quota > 0 {
+		if used, err := s.optimizedRepo.TotalSize(); err == nil && used >= quota {
+			s.respondError(w, http.StatusInsufficientStorage, jobs.ErrOptimizedQuota.Error())
+			return
+		}
+	}
+	isAdmin := models.UserRole(r.Header.Get("X-User-Role")) == models.RoleAdmin
+	if limit := s.config.Optimized.MaxPerUser; limit > 0 && !isAdmin {
+		count, err := s.optimizedRepo.CountByUser(userID)
+		if err != nil {
+			s.respondError(w, http.StatusInternalServerError, "failed to count optimized versions")
+			return
+		}
+		if count+len(mediaIDs) > limit {
+			s.respondError(w, http.StatusTooManyRequests, fmt.Sprintf("optimized version limit reached: %d of %d in use", count, limit))
+			return
+		}
+	}
+
+	versions := make([]*models.OptimizedVersion, 0, len(mediaIDs))
+	queued := 0
+	for _, id := range mediaIDs {
+		v, needsJob, err := s.optimizedRepo.Request(id, req.Profile, userID)
+		if err != nil {
+			s.respondError(w, http.StatusInternalServerError, "failed to create optimized version")
+			return
+		}
+		if needsJob {
+			if err := jobs.EnqueueOptimize(s.jobQueue, v.ID); err != nil {
+				s.optimizedRepo.MarkFailed(v.ID, "failed to queue job")
+				continue
+			}
+			queued++
+		}
+		versions = append(versions, v)
+	}
+
+	s.respondJSON(w, http.StatusAccepted, Response{Success: true, Data: map[string]interface{}{
+		"queued":   queued,
+		"versions": versions,
+	}})
+}
+
+// optimizeTargets resolves an optimize request to the video media items it
+// covers.
+func (s *Server) optimizeTargets(mediaID, seasonID, collectionID *uuid.UUID) ([]uuid.UUID, error) {
+	var items []*models.MediaItem
+	switch {
+	case mediaID != nil:
+		media, err := s.mediaRepo.GetByID(*mediaID)
+		if err != nil {
+			return nil, fmt.Errorf("media not found")
+		}
+		items = append(items, media)
+	case seasonID != nil:
+		episodes, err := s.tvRepo.ListEpisodesBySeason(*seasonID)
+		if err != nil {
+			return nil, fmt.Errorf("season not found")
+		}
+		items = episodes
+	case collectionID != nil:
+		coll, err := s.collectionRepo.GetByID(*collectionID)
+		if err != nil {
+			return nil, fmt.Errorf("collection not found")
+		}
+		if coll.CollectionType == "smart" && coll.Rules != nil {
+			items, err = s.collectionRepo.EvaluateSmartCollection(*coll.Rules, coll.LibraryID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to evaluate smart collection: %w", err)
+			}
+			break
+		}
+		for _, ci := range coll.Items {
+			if ci.MediaItemID == nil {
+				continue
+			}
+			if media, err := s.mediaRepo.GetByID(*ci.MediaItemID); err == nil {
+				items = append(items, media)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("one of media_id, season_id or collection_id is required")
+	}
+
+	var ids []uuid.UUID
+	for _, m := range items {
+		// Only video has anything to optimize
+		if m.Height == nil || *m.Height == 0 {
+			continue
+		}
+		ids = append(ids, m.ID)
+	}
+	return ids, nil
+}
+
+// DELETE /api/v1/optimized/{id} — remove an optimized version (requester or admin)
+func (s *Server) handleDeleteOptimized(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	v, err := s.optimizedRepo.GetByID(id)
+	if err != nil {
+		s.respondError(w, http.StatusNotFound, "optimized version not found")
+		return
+	}
+	isAdmin := models.UserRole(r.Header.Get("X-User-Role")) == models.RoleAdmin
+	if !isAdmin && (v.RequestedBy == nil || *v.RequestedBy != s.getUserID(r)) {
+		s.respondError(w, http.StatusNotFound, "optimized version not found")
+		return
+	}
+	if err := jobs.RemoveOptimizedVersion(s.optimizedRepo, v); err != nil {
+		s.respondError(w, http.StatusInternalServerError, "failed to delete optimized version")
+		return
+	}
+	s.respondJSON(w, http.StatusOK, Response{Success: true})
+}
+
+// GET /api/v1/optimized/{id}/download — download an optimized version;
+// Range and If-Range requests resume interrupted downloads
+func (s *Server) handleDownloadOptimized(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	v, err := s.optimizedRepo.GetByID(id)
+	if err != nil || v.Status != models.OptimizedReady || v.FilePath == nil || v.MediaItemID == nil {
+		s.respondError(w, http.StatusNotFound, "optimized version not ready")
+		return
+	}
+	f, err := os.Open(*v.FilePath)
+	if err != nil {
+		s.respondError(w, http.StatusNotFound, "optimized file missing")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		s.respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.optimizedRepo.Touch(v.ID)
+
+	name := "video"
+	if media, err := s.mediaRepo.GetByID(*v.MediaItemID); err == nil {
+		name = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`/\:*?"<>|`, r) {
+				return '_'
+			}
+			return r
+		}, media.Title)
+	}
+	filename := fmt.Sprintf("%s (%s).mp4", name, v.Profile)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%d"`, v.ID, info.ModTime().Unix()))
+	http.ServeContent(w, r, filename, info.ModTime(), f)
+}
+
+// optimizedVersionFor returns a ready version of a media item by id, for
+// ?version= on the direct stream.
+func (s *Server) optimizedVersionFor(mediaID uuid.UUID, versionID string) (*models.OptimizedVersion, error) {
+	id, err := uuid.Parse(versionID)
+	if err != nil {
+		return nil, err
+	}
+	v, err := s.optimizedRepo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if v.MediaItemID == nil || *v.MediaItemID != mediaID || v.Status != models.OptimizedReady || v.FilePath == nil {
+		return nil, fmt.Errorf("optimized version not ready")
+	}
+	return v, nil
+}
+
+// playableOptimizedVersion returns the best ready version of a media item
+// that a device profile direct plays, or nil.
+func (s *Server) playableOptimizedVersion(mediaID uuid.UUID, profile *stream.DeviceProfile) *models.OptimizedVersion {
+	versions, err := s.optimizedRepo.ListReady(mediaID)
+	if err != nil {
+		return nil
+	}
+	probe := ffmpeg.NewFFprobe(s.config.FFmpeg.FFprobePath)
+	for _, v := range versions {
+		result, err := probe.Probe(*v.FilePath)
+		if err != nil {
+			continue
+		}
+		d := stream.Decide(result, profile, stream.PlaybackRequest{AudioStreamIndex: -1, SubtitleStreamIndex: -1})
+		if d.Method == stream.MethodDirectPlay {
+			return v
+		}
+	}
+	return nil
+}
@@ -1,13 +1,19 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/JustinTDCT/CineVault/internal/stream"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
 )
@@ -264,11 +270,220 @@ func (s *Server) handleUpdateStreamLimits(w http.ResponseWriter, r *http.Request
 	s.respondJSON(w, http.StatusOK, Response{Success: true})
 }
 
+// streamLimits are a user's streaming limits as they apply to one request.
+// On WAN connections remote_quality_cap also caps the height.
+type streamLimits struct {
+	MaxStreams int
+	MaxBitrate int64 // bits per second; 0 = unlimited
+	MaxHeight  int   // 0 = unlimited
+	Remote     bool
+}
+
+// streamLimitsFor loads the limits of the requesting user.
+func (s *Server) streamLimitsFor(r *http.Request, userID uuid.UUID) streamLimits {
+	var maxStreams, maxBitrateKbps int
+	var remoteCap string
+	s.db.QueryRow("SELECT max_simultaneous_streams, max_bitrate_kbps, COALESCE(remote_quality_cap, '') FROM users WHERE id = $1", userID).
+		Scan(&maxStreams, &maxBitrateKbps, &remoteCap)
+	limits := streamLimits{
+		MaxStreams: maxStreams,
+		MaxBitrate: int64(maxBitrateKbps) * 1000,
+		Remote:     !isLANAddress(streamClientIP(r, s.trustedProxies)),
+	}
+	if limits.Remote {
+		if q, ok := stream.Qualities[remoteCap]; ok {
+			limits.MaxHeight = q.Height
+		}
+	}
+	return limits
+}
+
+// streamClientIP is the address LAN or remote is decided on: the direct
+// peer, unless the peer is a trusted proxy. Then it is the nearest hop in
+// X-Forwarded-For that isn't one, or X-Real-IP. Clients can't claim to be
+// on the LAN by sending the headers themselves.
+func streamClientIP(r *http.Request, trusted []*net.IPNet) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	if !isTrustedProxy(peer, trusted) {
+		return peer
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i > 0; i-- {
+			if hop := strings.TrimSpace(hops[i]); !isTrustedProxy(hop, trusted) {
+				return hop
+			}
+		}
+		return strings.TrimSpace(hops[0])
+	}
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); xri != "" {
+		return xri
+	}
+	return peer
+}
+
+// parseTrustedProxies turns the configured addresses and CIDRs into
+// networks, skipping entries that are neither.
+func parseTrustedProxies(list []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, entry := range list {
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("[config] TRUSTED_PROXIES: %q is not an address or CIDR", entry)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func isTrustedProxy(ip string, trusted []*net.IPNet) bool {
+	addr := net.ParseIP(strings.Trim(strings.TrimSpace(ip), "[]"))
+	if addr == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLANAddress reports whether ip is loopback, link-local or private.
+func isLANAddress(ip string) bool {
+	addr := net.ParseIP(strings.Trim(strings.TrimSpace(ip), "[]"))
+	if addr == nil {
+		return false
+	}
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast()
+}
+
+// allows reports whether a transcode quality fits the limits.
+func (l streamLimits) allows(quality string) bool {
+	q, ok := stream.Qualities[quality]
+	if !ok {
+		return false
+	}
+	if l.MaxHeight > 0 && q.Height > l.MaxHeight {
+		return false
+	}
+	if l.MaxBitrate > 0 && stream.QualityBitrate(q) > l.MaxBitrate {
+		return false
+	}
+	return true
+}
+
+// capQuality steps a transcode quality down until it fits the limits; the
+// lowest quality is always allowed.
+func (l streamLimits) capQuality(quality string) string {
+	order := []string{"4K", "1080p", "720p", "480p", "360p"}
+	for i, q := range order {
+		if q != quality {
+			continue
+		}
+		for _, lower := range order[i:] {
+			if l.allows(lower) {
+				return lower
+			}
+		}
+		return "360p"
+	}
+	return quality
+}
+
+// applyTo narrows a device profile to the limits, so PlaybackInfo picks a
+// transcode when the source is over them.
+func (l streamLimits) applyTo(p stream.DeviceProfile) stream.DeviceProfile {
+	if l.MaxBitrate > 0 && (p.MaxBitrate == 0 || l.MaxBitrate < p.MaxBitrate) {
+		p.MaxBitrate = l.MaxBitrate
+	}
+	if l.MaxHeight > 0 && (p.MaxHeight == 0 || l.MaxHeight < p.MaxHeight) {
+		p.MaxHeight = l.MaxHeight
+	}
+	return p
+}
+
+// streamTracker counts in-flight direct play and remux requests per
+// playback, so they count toward max_simultaneous_streams alongside
+// transcode sessions.
+type streamTracker struct {
+	mu   sync.Mutex
+	open map[string]int // "userID|mediaID|device" → open requests
+}
+
+func newStreamTracker() *streamTracker {
+	return &streamTracker{open: make(map[string]int)}
+}
+
+// begin registers a request; call the returned func when it ends.
+func (t *streamTracker) begin(userID, playback string) func() {
+	key := userID + "|" + playback
+	t.mu.Lock()
+	t.open[key]++
+	t.mu.Unlock()
+	return func() {
+		t.mu.Lock()
+		if t.open[key]--; t.open[key] <= 0 {
+			delete(t.open, key)
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (t *streamTracker) playbacks(userID string, into map[string]bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prefix := userID + "|"
+	for key := range t.open {
+		if strings.HasPrefix(key, prefix) {
+			into[strings.TrimPrefix(key, prefix)] = true
+		}
+	}
+}
+
+// checkStreamCount reports whether starting playback ("mediaID|device")
+// would take the user over max_simultaneous_streams. Resuming or switching
+// quality on a playback that's already running doesn't count.
+func (s *Server) checkStreamCount(userID uuid.UUID, playback string, limits streamLimits) bool {
+	if limits.MaxStreams <= 0 {
+		return true
+	}
+	active := s.transcoder.UserPlaybacks(userID.String())
+	s.directStreams.playbacks(userID.String(), active)
+	return active[playback] || len(active) < limits.MaxStreams
+}
+
+// respondStreamLimit is the 429 for a stream limit; limit names which one
+// ("max_streams", "max_bitrate", "remote_quality_cap", "transcode_budget").
+func (s *Server) respondStreamLimit(w http.ResponseWriter, limit, message string) {
+	if limit == "transcode_budget" {
+		w.Header().Set("Retry-After", "10")
+	}
+	s.respondJSON(w, http.StatusTooManyRequests, Response{
+		Success: false,
+		Error:   message,
+		Data:    map[string]string{"limit": limit},
+	})
+}
+
 // ══════════════════════ Live TV / DVR (P15-05) ══════════════════════
 
 // GET /api/v1/livetv/tuners
 func (s *Server) handleListTuners(w http.ResponseWriter, r *http.Request) {
-	rows, err := s.db.Query("SELECT id, name, device_type, url, is_active, channel_count FROM tuner_devices ORDER BY name")
+	rows, err := s.db.Query(`SELECT id, name, device_type, url, is_active, channel_count, tuner_count,
+		guide_url, guide_refreshed_at, guide_error FROM tuner_devices ORDER BY name`)
 	if err != nil {
 		s.respondJSON(w, http.StatusOK, Response{Success: true, Data: []interface{}{}})
 		return
@@ -279,10 +494,14 @@ func (s *Server) handleListTuners(w http.ResponseWriter, r *http.Request) {
 		var id uuid.UUID
 		var name, dtype, url string
 		var active bool
-		var channels int
-		if rows.Scan(&id, &name, &dtype, &url, &active, &channels) == nil {
+		var channels, tunerCount int
+		var guideURL, guideErr *string
+		var refreshedAt *time.Time
+		if rows.Scan(&id, &name, &dtype, &url, &active, &channels, &tunerCount, &guideURL, &refreshedAt, &guideErr) == nil {
 			tuners = append(tuners, map[string]interface{}{
-				"id": id, "name": name, "device_type": dtype, "url": url, "is_active": active, "channel_count": channels,
+				"id": id, "name": name, "device_type": dtype, "url": url, "is_active": active,
+				"channel_count": channels, "tuner_count": tunerCount,
+				"guide_url": guideURL, "guide_refreshed_at": refreshedAt, "guide_error": guideErr,
 			})
 		}
 	}
@@ -298,6 +517,8 @@ func (s *Server) handleCreateTuner(w http.ResponseWriter, r *http.Request) {
 		Name       string `json:"name"`
 		DeviceType string `json:"device_type"`
 		URL        string `json:"url"`
+		TunerCount int    `json:"tuner_count"` // simultaneous streams the device supports
+		GuideURL   string `json:"guide_url"`   // XMLTV file or URL; M3U playlists may advertise their own
 	}
 	if json.NewDecoder(r.Body).Decode(&req) != nil || req.Name == "" || req.URL == "" {
 		s.respondError(w, http.StatusBadRequest, "name and url required")
@@ -306,11 +527,52 @@ func (s *Server) handleCreateTuner(w http.ResponseWriter, r *http.Request) {
 	if req.DeviceType == "" {
 		req.DeviceType = "hdhomerun"
 	}
+	switch req.DeviceType {
+	case "hdhomerun":
+		// Accept a bare IP/hostname as shown by the HDHomeRun app
+		if !strings.Contains(req.URL, "://") {
+			req.URL = "http://" + req.URL
+		}
+	case "m3u", "iptv":
+	default:
+		s.respondError(w, http.StatusBadRequest, "device_type must be hdhomerun or m3u")
+		return
+	}
+	if req.TunerCount <= 0 {
+		req.TunerCount = 2
+	}
 	id := uuid.New()
-	s.db.Exec("INSERT INTO tuner_devices (id, name, device_type, url) VALUES ($1, $2, $3, $4)", id, req.Name, req.DeviceType, req.URL)
+	if _, err := s.db.Exec("INSERT INTO tuner_devices (id, name, device_type, url, tuner_count, guide_url) VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))",
+		id, req.Name, req.DeviceType, req.URL, req.TunerCount, req.GuideURL); err != nil {
+		s.respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	go func() {
+		if _, err := s.guide.RefreshTuner(context.Background(), id); err == nil {
+			s.seriesRules.Apply()
+		}
+	}()
 	s.respondJSON(w, http.StatusCreated, Response{Success: true, Data: map[string]interface{}{"id": id}})
 }
 
+// POST /api/v1/livetv/tuners/{id}/refresh — Reload channel lineup and guide now
+func (s *Server) handleRefreshTuner(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+	result, err := s.guide.RefreshTuner(ctx, id)
+	if err != nil {
+		s.respondError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	s.seriesRules.Apply()
+	s.respondJSON(w, http.StatusOK, Response{Success: true, Data: result})
+}
+
 // DELETE /api/v1/livetv/tuners/{id}
 func (s *Server) handleDeleteTuner(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
@@ -326,7 +588,7 @@ func (s *Server) handleGetEPG(w http.ResponseWriter, r *http.Request) {
 	}
 
 	rows, err := s.db.Query(`SELECT c.id, c.channel_number, c.name, c.icon_url, c.is_favorite,
-		p.id, p.title, p.description, p.start_time, p.end_time, p.category
+		p.id, p.title, p.sub_title, p.description, p.start_time, p.end_time, p.category, p.episode_info, p.is_new, p.series_id
 		FROM epg_channels c LEFT JOIN epg_programs p ON c.id = p.channel_id
 			AND p.start_time >= $1::date AND p.start_time < ($1::date + interval '1 day')
 		ORDER BY c.channel_number, p.start_time`, date)
@@ -344,9 +606,10 @@ func (s *Server) handleGetEPG(w http.ResponseWriter, r *http.Request) {
 		var cIcon *string
 		var isFav bool
 		var pID *uuid.UUID
-		var pTitle, pDesc, pCat *string
+		var pTitle, pSub, pDesc, pCat, pEp, pSeries *string
 		var pStart, pEnd *time.Time
-		if rows.Scan(&cID, &chNum, &cName, &cIcon, &isFav, &pID, &pTitle, &pDesc, &pStart, &pEnd, &pCat) != nil {
+		var pNew *bool
+		if rows.Scan(&cID, &chNum, &cName, &cIcon, &isFav, &pID, &pTitle, &pSub, &pDesc, &pStart, &pEnd, &pCat, &pEp, &pNew, &pSeries) != nil {
 			continue
 		}
 		key := cID.String()
@@ -367,6 +630,18 @@ func (s *Server) handleGetEPG(w http.ResponseWriter, r *http.Request) {
 			if pCat != nil {
 				prog["category"] = *pCat
 			}
+			if pSub != nil {
+				prog["sub_title"] = *pSub
+			}
+			if pEp != nil {
+				prog["episode_info"] = *pEp
+			}
+			if pNew != nil && *pNew {
+				prog["is_new"] = true
+			}
+			if pSeries != nil {
+				prog["series_id"] = *pSeries
+			}
 			progs := channelMap[key]["programs"].([]map[string]interface{})
 			channelMap[key]["programs"] = append(progs, prog)
 		}
@@ -402,15 +677,49 @@ func (s *Server) handleScheduleRecording(w http.ResponseWriter, r *http.Request)
 		s.respondError(w, http.StatusBadRequest, "start_time and end_time required (RFC3339)")
 		return
 	}
+	if !end.After(start) || !end.After(time.Now()) {
+		s.respondError(w, http.StatusBadRequest, "end_time must be after start_time and in the future")
+		return
+	}
+	channelID, err := uuid.Parse(req.ChannelID)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid channel_id")
+		return
+	}
+	src, err := s.dvrRepo.GetChannelSource(channelID)
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "channel has no active tuner")
+		return
+	}
+	// Reject when every tuner on the device is already booked for part of the window
+	if src.TunerCount > 0 {
+		peak, err := s.dvrRepo.PeakOverlap(src.TunerID, start, end, uuid.Nil)
+		if err != nil {
+			s.respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if peak >= src.TunerCount {
+			s.respondError(w, http.StatusConflict, fmt.Sprintf("tuner limit reached: %d of %d tuners already recording during this time", peak, src.TunerCount))
+			return
+		}
+	}
 	id := uuid.New()
-	s.db.Exec(`INSERT INTO dvr_recordings (id, channel_id, program_id, user_id, title, start_time, end_time)
-		VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5, $6, $7)`, id, req.ChannelID, req.ProgramID, userID, req.Title, start, end)
+	// Copy the programme's episode IDs so series rules treat it as already recorded
+	if _, err := s.db.Exec(`INSERT INTO dvr_recordings (id, channel_id, program_id, user_id, title, start_time, end_time,
+			external_id, episode_info)
+		SELECT $1, $2, p.id, $4, $5, $6, $7, p.external_id, p.episode_info
+		FROM (SELECT NULLIF($3, '')::uuid AS pid) x LEFT JOIN epg_programs p ON p.id = x.pid`,
+		id, channelID, req.ProgramID, userID, req.Title, start, end); err != nil {
+		s.respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	s.respondJSON(w, http.StatusCreated, Response{Success: true, Data: map[string]interface{}{"id": id}})
 }
 
 // GET /api/v1/livetv/recordings
 func (s *Server) handleListRecordings(w http.ResponseWriter, r *http.Request) {
-	rows, err := s.db.Query(`SELECT id, channel_id, title, file_path, state, start_time, end_time FROM dvr_recordings ORDER BY start_time DESC LIMIT 100`)
+	rows, err := s.db.Query(`SELECT id, channel_id, title, file_path, state, start_time, end_time, error_message, media_item_id,
+		rule_id, episode_info FROM dvr_recordings ORDER BY start_time DESC LIMIT 100`)
 	if err != nil {
 		s.respondJSON(w, http.StatusOK, Response{Success: true, Data: []interface{}{}})
 		return
@@ -423,10 +732,15 @@ func (s *Server) handleListRecordings(w http.ResponseWriter, r *http.Request) {
 		var filePath *string
 		var state string
 		var start, end time.Time
-		if rows.Scan(&id, &chID, &title, &filePath, &state, &start, &end) == nil {
+		var errMsg *string
+		var mediaID, ruleID *uuid.UUID
+		var episode *string
+		if rows.Scan(&id, &chID, &title, &filePath, &state, &start, &end, &errMsg, &mediaID, &ruleID, &episode) == nil {
 			recs = append(recs, map[string]interface{}{
 				"id": id, "channel_id": chID, "title": title, "file_path": filePath,
 				"state": state, "start_time": start, "end_time": end,
+				"error_message": errMsg, "media_item_id": mediaID,
+				"rule_id": ruleID, "episode_info": episode,
 			})
 		}
 	}
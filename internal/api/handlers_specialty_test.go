@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStreamClientIP(t *testing.T) {
+	trusted := parseTrustedProxies([]string{"10.0.0.2", "172.16.0.0/12", "not-an-address"})
+	if len(trusted) != 2 {
+		t.Fatalf("parsed %d trusted proxies, want 2", len(trusted))
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    string
+		xri    string
+		want   string
+		lan    bool
+	}{
+		{"LAN client", "192.168.1.20:51000", "", "", "192.168.1.20", true},
+		{"remote client", "203.0.113.7:51000", "", "", "203.0.113.7", false},
+		{"remote client spoofing X-Forwarded-For", "203.0.113.7:51000", "192.168.1.20", "", "203.0.113.7", false},
+		{"remote client spoofing X-Real-IP", "203.0.113.7:51000", "", "127.0.0.1", "203.0.113.7", false},
+		{"untrusted LAN peer's headers ignored", "192.168.1.5:443", "203.0.113.7", "", "192.168.1.5", true},
+		{"trusted proxy, remote client", "10.0.0.2:443", "203.0.113.7", "", "203.0.113.7", false},
+		{"trusted proxy, LAN client", "10.0.0.2:443", "192.168.1.20", "", "192.168.1.20", true},
+		{"trusted proxy by CIDR", "172.18.0.3:443", "203.0.113.7", "", "203.0.113.7", false},
+		{"spoofed hop before the proxy chain", "10.0.0.2:443", "192.168.1.20, 203.0.113.7, 172.18.0.3", "", "203.0.113.7", false},
+		{"trusted proxy, X-Real-IP", "10.0.0.2:443", "", "203.0.113.7", "203.0.113.7", false},
+		{"trusted proxy without headers", "10.0.0.2:443", "", "", "10.0.0.2", true},
+		{"IPv6 peer", "[fd00::1]:443", "203.0.113.7", "", "fd00::1", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/v1/stream", nil)
+		r.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if tt.xri != "" {
+			r.Header.Set("X-Real-IP", tt.xri)
+		}
+		got := streamClientIP(r, trusted)
+		if got != tt.want || isLANAddress(got) != tt.lan {
+			t.Errorf("%s: client %q (LAN %v), want %q (LAN %v)", tt.name, got, isLANAddress(got), tt.want, tt.lan)
+		}
+	}
+}
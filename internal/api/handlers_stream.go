@@ -2,15 +2,19 @@ package api
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/JustinTDCT/CineVault/internal/ffmpeg"
 	"github.com/JustinTDCT/CineVault/internal/models"
 	"github.com/JustinTDCT/CineVault/internal/stream"
 	"github.com/google/uuid"
@@ -31,6 +35,55 @@ func (s *Server) handleStreamMaster(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tcOpts, sessionQuery := transcodeRequestOptions(r)
+	userID := s.getUserID(r)
+	limits := s.streamLimitsFor(r, userID)
+	if !s.checkStreamCount(userID, mediaID.String()+"|"+tcOpts.DeviceID, limits) {
+		s.respondStreamLimit(w, "max_streams", fmt.Sprintf("maximum of %d simultaneous streams reached", limits.MaxStreams))
+		return
+	}
+	// Only offer the qualities the user's bitrate and remote caps allow
+	var qualities []string
+	for _, q := range transcodeQualities(media) {
+		if limits.allows(q) {
+			qualities = append(qualities, q)
+		}
+	}
+	if len(qualities) == 0 {
+		qualities = []string{"360p"}
+	}
+
+	var playlist string
+	if tcOpts.Packaging == stream.PackagingCMAF {
+		pres, err := s.cmafPresentation(media, qualities, tcOpts, sessionQuery)
+		if err != nil {
+			s.respondError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		playlist = stream.CMAFMasterPlaylist(pres)
+	} else {
+		pres, err := s.cmafPresentation(media, qualities, tcOpts, sessionQuery)
+		if err != nil {
+			// Without a duration there are no subtitle playlists; the
+			// variants carry the audio as ever
+			pres = &stream.CMAFPresentation{MediaID: mediaID.String(), Qualities: qualities, Codec: tcOpts.Codec, Query: sessionQuery}
+		}
+		playlist = stream.HLSMasterPlaylist(pres)
+	}
+	// Scrubbing thumbnails from the sprite sheet
+	if _, layout, ok := s.trickplaySprite(media); ok {
+		urls := trickplayURLs(mediaID, tokenQuery(r))
+		playlist += layout.MasterPlaylistLines(urls["images"], urls["iframes"])
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(playlist))
+}
+
+// transcodeQualities lists the HLS/DASH renditions offered for a media
+// item: every standard quality up to the source's.
+func transcodeQualities(media *models.MediaItem) []string {
 	var qualities []string
 	height := 0
 	if media.Height != nil {
@@ -44,12 +97,173 @@ func (s *Server) handleStreamMaster(w http.ResponseWriter, r *http.Request) {
 	if len(qualities) == 0 {
 		qualities = []string{"720p"}
 	}
+	return qualities
+}
 
-	playlist := s.transcoder.GenerateMasterPlaylist(mediaID.String(), media.FilePath, qualities)
+// cmafPresentation gathers the tracks the master playlists and DASH
+// manifest list. Audio tracks become separate renditions unless a CMAF
+// request picked one (muxed masters list them all and carry the picked one
+// in the variants); text subtitles are offered as WebVTT unless they are
+// burned in.
+func (s *Server) cmafPresentation(media *models.MediaItem, qualities []string, opts stream.TranscodeOptions, query url.Values) (*stream.CMAFPresentation, error) {
+	if media.DurationSeconds == nil || *media.DurationSeconds <= 0 {
+		return nil, errors.New("media duration unknown; scan the file first")
+	}
+	pres := &stream.CMAFPresentation{
+		MediaID:   media.ID.String(),
+		Duration:  float64(*media.DurationSeconds),
+		Qualities: qualities,
+		Codec:     opts.Codec,
+		Query:     query,
+	}
+	if s.tracksRepo != nil {
+		if tracks, err := s.tracksRepo.GetAudioTracksByMediaID(media.ID); err == nil {
+			for _, t := range tracks {
+				if opts.Packaging == stream.PackagingCMAF && opts.AudioStreamIndex >= 0 && t.StreamIndex != opts.AudioStreamIndex {
+					continue
+				}
+				a := stream.CMAFAudio{StreamIndex: t.StreamIndex, Channels: t.Channels, Default: t.IsDefault}
+				if t.Language != nil {
+					a.Language = *t.Language
+				}
+				if t.Title != nil {
+					a.Label = *t.Title
+				}
+				pres.Audio = append(pres.Audio, a)
+			}
+		}
+		if !opts.BurnSubtitles {
+			if subs, err := s.tracksRepo.GetSubtitlesByMediaID(media.ID); err == nil {
+				for _, sub := range subs {
+					if !stream.IsTextSubtitle(sub.Format) {
+						continue
+					}
+					cs := stream.CMAFSubtitle{ID: sub.ID.String(), Default: sub.IsDefault, Forced: sub.IsForced}
+					if sub.Language != nil {
+						cs.Language = *sub.Language
+					}
+					if sub.Title != nil {
+						cs.Label = *sub.Title
+					}
+					pres.Subtitles = append(pres.Subtitles, cs)
+				}
+			}
+		}
+	}
+	if len(pres.Audio) == 0 {
+		// Unprobed tracks: the file's first audio stream
+		pres.Audio = []stream.CMAFAudio{{StreamIndex: opts.AudioStreamIndex, Default: true}}
+	}
+	return pres, nil
+}
 
-	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(playlist))
+// defaultDeviceProfile is assumed for PlaybackInfo requests that don't send
+// a profile: what every current browser plays through MSE.
+var defaultDeviceProfile = stream.DeviceProfile{
+	Name:             "Browser",
+	Containers:       []string{"mp4", "m4v", "webm"},
+	VideoCodecs:      []string{"h264", "vp8", "vp9"},
+	AudioCodecs:      []string{"aac", "mp3", "opus", "vorbis", "flac"},
+	MaxAudioChannels: 6,
+	SubtitleFormats:  []string{"webvtt"},
+}
+
+// POST /api/v1/stream/{mediaId}/playback-info — choose how to play a media
+// item on the posting client's device profile
+func (s *Server) handlePlaybackInfo(w http.ResponseWriter, r *http.Request) {
+	mediaID, err := uuid.Parse(r.PathValue("mediaId"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid media id")
+		return
+	}
+	var req struct {
+		DeviceProfile       *stream.DeviceProfile `json:"device_profile"`
+		AudioStreamIndex    *int                  `json:"audio_stream_index"`
+		SubtitleStreamIndex *int                  `json:"subtitle_stream_index"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.respondError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+	media, err := s.mediaRepo.GetByID(mediaID)
+	if err != nil {
+		s.respondError(w, http.StatusNotFound, "media not found")
+		return
+	}
+	probe, err := ffmpeg.NewFFprobe(s.config.FFmpeg.FFprobePath).Probe(media.FilePath)
+	if err != nil {
+		s.respondError(w, http.StatusUnprocessableEntity, "failed to probe media: "+err.Error())
+		return
+	}
+
+	profile := defaultDeviceProfile
+	if req.DeviceProfile != nil {
+		profile = *req.DeviceProfile
+	}
+	// The user's bitrate and remote caps turn an over-limit direct play
+	// into a transcode
+	profile = s.streamLimitsFor(r, s.getUserID(r)).applyTo(profile)
+	playReq := stream.PlaybackRequest{AudioStreamIndex: -1, SubtitleStreamIndex: -1}
+	if req.AudioStreamIndex != nil {
+		playReq.AudioStreamIndex = *req.AudioStreamIndex
+	}
+	if req.SubtitleStreamIndex != nil {
+		playReq.SubtitleStreamIndex = *req.SubtitleStreamIndex
+	}
+	decision := stream.Decide(probe, &profile, playReq)
+
+	// A ready optimized version the client can play as-is beats remuxing
+	// or transcoding the original. Versions carry only the default audio
+	// track and no subtitles, so not when the request needs others.
+	var versionID *uuid.UUID
+	if decision.Method != stream.MethodDirectPlay && playReq.AudioStreamIndex < 0 && !decision.BurnSubtitles {
+		if v := s.playableOptimizedVersion(mediaID, &profile); v != nil {
+			versionID = &v.ID
+			decision = stream.PlaybackDecision{Method: stream.MethodDirectPlay, Reasons: decision.Reasons}
+		}
+	}
+
+	// URL of the chosen stream; the client adds its token
+	q := url.Values{}
+	if versionID != nil {
+		q.Set("version", versionID.String())
+	}
+	if playReq.AudioStreamIndex >= 0 {
+		q.Set("audio", strconv.Itoa(playReq.AudioStreamIndex))
+	}
+	path := fmt.Sprintf("/api/v1/stream/%s/direct", mediaID)
+	switch decision.Method {
+	case stream.MethodDirectStream:
+		q.Set("mode", "remux")
+	case stream.MethodAudioTranscode:
+		q.Set("mode", "audio")
+	case stream.MethodTranscode:
+		path = fmt.Sprintf("/api/v1/stream/%s/master.m3u8", mediaID)
+		if decision.BurnSubtitles {
+			q.Set("subtitle", strconv.Itoa(playReq.SubtitleStreamIndex))
+			q.Set("burn", "true")
+		}
+		if decision.ToneMap {
+			q.Set("hdr", "false")
+		}
+	}
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	s.respondJSON(w, http.StatusOK, Response{Success: true, Data: map[string]interface{}{
+		"media_id":       mediaID,
+		"method":         decision.Method,
+		"reasons":        decision.Reasons,
+		"url":            path,
+		"quality":        decision.Quality,
+		"tone_map":       decision.ToneMap,
+		"burn_subtitles": decision.BurnSubtitles,
+		"version_id":     versionID,
+		"profile":        profile.Name,
+	}})
 }
 
 // handleStreamInfo returns media stream info as JSON
@@ -139,11 +353,11 @@ func (s *Server) handleStreamInfo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Audio normalization: include gain for client-side direct play normalization
-	var loudnessGainDB *float64
-	if media.LoudnessGainDB != nil {
+	var loudnessGainDB, albumGainDB *float64
+	if media.LoudnessGainDB != nil || media.AlbumID != nil {
 		lib, libErr := s.libRepo.GetByID(media.LibraryID)
 		if libErr == nil && lib.AudioNormalization {
-			loudnessGainDB = media.LoudnessGainDB
+			loudnessGainDB, albumGainDB = s.replayGains(media)
 		}
 	}
 
@@ -168,6 +382,22 @@ func (s *Server) handleStreamInfo(w http.ResponseWriter, r *http.Request) {
 	if loudnessGainDB != nil {
 		data["loudness_gain_db"] = *loudnessGainDB
 	}
+	if albumGainDB != nil {
+		data["album_gain_db"] = *albumGainDB
+	}
+
+	// Trickplay thumbnails; the client adds its token to the URLs
+	if _, layout, ok := s.trickplaySprite(media); ok {
+		data["trickplay"] = map[string]interface{}{
+			"layout": layout,
+			"urls":   trickplayURLs(mediaID, ""),
+		}
+	}
+
+	// Optimized versions that can be played or downloaded
+	if versions, err := s.optimizedRepo.ListReady(mediaID); err == nil && len(versions) > 0 {
+		data["optimized_versions"] = versions
+	}
 
 	// Music video overlay metadata (artist, album, label, year)
 	if media.MediaType == "music_videos" {
@@ -204,15 +434,66 @@ func (s *Server) handleStreamInfo(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// transcodeRequestOptions reads the transcode options of an HLS request and
+// the query that carries them onto variant and segment URLs. The device is
+// the player's device id, or its User-Agent when it doesn't send one.
+func transcodeRequestOptions(r *http.Request) (stream.TranscodeOptions, url.Values) {
+	q := r.URL.Query()
+	opts := stream.TranscodeOptions{AudioStreamIndex: -1, SubtitleIndex: -1}
+	fwd := url.Values{}
+	if idx, err := strconv.Atoi(q.Get("audio")); err == nil && idx >= 0 {
+		opts.AudioStreamIndex = idx
+		fwd.Set("audio", q.Get("audio"))
+	}
+	if idx, err := strconv.Atoi(q.Get("subtitle")); err == nil && idx >= 0 && q.Get("burn") == "true" {
+		opts.SubtitleIndex = idx
+		opts.BurnSubtitles = true
+		fwd.Set("subtitle", q.Get("subtitle"))
+		fwd.Set("burn", "true")
+	}
+	if q.Get("hdr") == "false" {
+		opts.HDRToSDR = true
+		fwd.Set("hdr", "false")
+	}
+	if q.Get("codec") == "hevc" {
+		opts.Codec = "hevc"
+		fwd.Set("codec", "hevc")
+	}
+	if q.Get("packaging") == stream.PackagingCMAF {
+		opts.Packaging = stream.PackagingCMAF
+		fwd.Set("packaging", stream.PackagingCMAF)
+	}
+	if parsed, err := strconv.ParseFloat(q.Get("start"), 64); err == nil && parsed > 0 {
+		opts.StartSeconds = parsed
+	}
+	if device := q.Get("device"); device != "" {
+		opts.DeviceID = device
+		fwd.Set("device", device)
+	} else {
+		opts.DeviceID = r.UserAgent()
+	}
+	return opts, fwd
+}
+
 func (s *Server) handleStreamSegment(w http.ResponseWriter, r *http.Request) {
 	mediaID := r.PathValue("mediaId")
 	quality := r.PathValue("quality")
 	segmentFile := r.PathValue("segment")
 
-	sessionKey := fmt.Sprintf("%s-%s", mediaID, quality)
-	session := s.transcoder.GetSession(sessionKey)
+	userID := s.getUserID(r)
+	tcOpts, sessionQuery := transcodeRequestOptions(r)
+	limits := s.streamLimitsFor(r, userID)
+	if quality != stream.AudioRendition {
+		// A quality over the user's caps is stepped down, not refused
+		quality = limits.capQuality(quality)
+	}
+	session := s.transcoder.GetSession(stream.SessionKey(mediaID, userID.String(), quality, tcOpts))
 
 	if session == nil {
+		if !s.checkStreamCount(userID, mediaID+"|"+tcOpts.DeviceID, limits) {
+			s.respondStreamLimit(w, "max_streams", fmt.Sprintf("maximum of %d simultaneous streams reached", limits.MaxStreams))
+			return
+		}
 		mid, err := uuid.Parse(mediaID)
 		if err != nil {
 			s.respondError(w, http.StatusBadRequest, "invalid media id")
@@ -224,42 +505,22 @@ func (s *Server) handleStreamSegment(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		userID := s.getUserID(r)
-
-		// Build transcode options from query params
-		tcOpts := stream.TranscodeOptions{AudioStreamIndex: -1, SubtitleIndex: -1}
-		if audioParam := r.URL.Query().Get("audio"); audioParam != "" {
-			if idx, err := strconv.Atoi(audioParam); err == nil {
-				tcOpts.AudioStreamIndex = idx
-				if s.tracksRepo != nil {
-					if track, err := s.tracksRepo.GetAudioTrackByIndex(mid, idx); err == nil {
-						tcOpts.AudioCodec = track.Codec
-						tcOpts.AudioChannels = track.Channels
-					}
-				}
+		// Fill in what ffmpeg needs to know about the selected tracks
+		if tcOpts.AudioStreamIndex >= 0 && s.tracksRepo != nil {
+			if track, err := s.tracksRepo.GetAudioTrackByIndex(mid, tcOpts.AudioStreamIndex); err == nil {
+				tcOpts.AudioCodec = track.Codec
+				tcOpts.AudioChannels = track.Channels
 			}
 		}
-		if subParam := r.URL.Query().Get("subtitle"); subParam != "" {
-			if idx, err := strconv.Atoi(subParam); err == nil {
-				tcOpts.SubtitleIndex = idx
-				tcOpts.BurnSubtitles = r.URL.Query().Get("burn") == "true"
-				if s.tracksRepo != nil {
-					if sub, err := s.tracksRepo.GetSubtitleByStreamIndex(mid, idx); err == nil {
-						tcOpts.SubtitleFormat = sub.Format
-					}
-				}
-			}
-		}
-		if r.URL.Query().Get("hdr") == "false" {
-			tcOpts.HDRToSDR = true
-		}
-		if startParam := r.URL.Query().Get("start"); startParam != "" {
-			if parsed, err := strconv.ParseFloat(startParam, 64); err == nil {
-				tcOpts.StartSeconds = parsed
+		if tcOpts.SubtitleIndex >= 0 && s.tracksRepo != nil {
+			if sub, err := s.tracksRepo.GetSubtitleByStreamIndex(mid, tcOpts.SubtitleIndex); err == nil {
+				tcOpts.SubtitleFormat = sub.Format
 			}
 		}
-		if r.URL.Query().Get("codec") == "hevc" {
-			tcOpts.Codec = "hevc"
+		// A segment request without a session (e.g. after it expired)
+		// starts encoding right there
+		if n, ok := stream.ParseSegmentName(segmentFile); ok {
+			tcOpts.StartSeconds = float64(n) * stream.SegmentDuration
 		}
 
 		// Audio normalization gain
@@ -269,7 +530,12 @@ func (s *Server) handleStreamSegment(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		sess, err := s.transcoder.StartTranscode(mediaID, userID.String(), media.FilePath, quality, tcOpts)
+		// Wait a while for a slot when the server-wide budget is used up
+		sess, err := s.transcoder.QueueTranscode(r.Context(), 20*time.Second, mediaID, userID.String(), media.FilePath, quality, tcOpts)
+		if errors.Is(err, stream.ErrTranscodeBusy) {
+			s.respondStreamLimit(w, "transcode_budget", "all transcode slots on the server are busy; try again shortly")
+			return
+		}
 		if err != nil {
 			s.respondError(w, http.StatusInternalServerError, "transcode failed: "+err.Error())
 			return
@@ -299,9 +565,43 @@ func (s *Server) handleStreamSegment(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if strings.HasSuffix(segmentFile, ".m3u8") {
-		playlistPath := filepath.Join(session.OutputDir, "stream.m3u8")
+		// Full-length VOD playlist with fixed segment boundaries; segments
+		// are transcoded on demand, so any of them can be fetched first
+		var duration float64
+		if mid, err := uuid.Parse(mediaID); err == nil {
+			if media, err := s.mediaRepo.GetByID(mid); err == nil && media.DurationSeconds != nil {
+				duration = float64(*media.DurationSeconds)
+			}
+		}
+		if duration <= 0 {
+			s.respondError(w, http.StatusUnprocessableEntity, "media duration unknown; scan the file first")
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("X-Transcode-Session", session.ID)
+		w.Write([]byte(stream.VODPlaylist(duration, session.SegmentExt, sessionQuery.Encode())))
+		return
+	}
+
+	var segPath string
+	if n, ok := stream.ParseSegmentName(segmentFile); ok {
+		path, err := s.transcoder.WaitSegment(r.Context(), session.ID, n, 30*time.Second)
+		if errors.Is(err, os.ErrNotExist) {
+			s.respondError(w, http.StatusNotFound, "no such segment")
+			return
+		}
+		if err != nil {
+			if r.Context().Err() == nil {
+				s.respondError(w, http.StatusServiceUnavailable, err.Error())
+			}
+			return
+		}
+		segPath = path
+	} else {
+		// fmp4 init segment
+		segPath = filepath.Join(session.OutputDir, filepath.Base(segmentFile))
 		for i := 0; i < 20; i++ {
-			if _, err := os.Stat(playlistPath); err == nil {
+			if _, err := os.Stat(segPath); err == nil {
 				break
 			}
 			select {
@@ -310,24 +610,18 @@ func (s *Server) handleStreamSegment(w http.ResponseWriter, r *http.Request) {
 			case <-time.After(500 * time.Millisecond):
 			}
 		}
-		if _, err := os.Stat(playlistPath); err != nil {
-			s.respondError(w, http.StatusAccepted, "transcoding in progress")
+		if _, err := os.Stat(segPath); err != nil {
+			s.respondError(w, http.StatusNotFound, "segment not ready")
 			return
 		}
-		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
-		http.ServeFile(w, r, playlistPath)
-		return
 	}
 
-	segPath := filepath.Join(session.OutputDir, segmentFile)
-	if _, err := os.Stat(segPath); err != nil {
-		s.respondError(w, http.StatusNotFound, "segment not ready")
-		return
-	}
-
-	if strings.HasSuffix(segmentFile, ".mp4") {
+	switch {
+	case quality == stream.AudioRendition:
+		w.Header().Set("Content-Type", "audio/mp4")
+	case strings.HasSuffix(segmentFile, ".mp4"), strings.HasSuffix(segmentFile, ".m4s"):
 		w.Header().Set("Content-Type", "video/mp4")
-	} else {
+	default:
 		w.Header().Set("Content-Type", "video/mp2t")
 	}
 	http.ServeFile(w, r, segPath)
@@ -352,6 +646,20 @@ func (s *Server) handleStreamDirect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// ?version= plays an optimized version in place of the original
+	if vid := r.URL.Query().Get("version"); vid != "" {
+		v, err := s.optimizedVersionFor(mediaID, vid)
+		if err != nil {
+			s.respondError(w, http.StatusNotFound, "optimized version not found")
+			return
+		}
+		s.optimizedRepo.Touch(v.ID)
+		container, codec, audioCodec := "mp4", "h264", "aac"
+		media.FilePath = *v.FilePath
+		media.Container, media.Codec, media.AudioCodec = &container, &codec, &audioCodec
+		media.Bitrate, media.Height = v.Bitrate, v.Height
+	}
+
 	// Parse optional seek position (in seconds)
 	startSeconds := 0.0
 	if startParam := r.URL.Query().Get("start"); startParam != "" {
@@ -362,22 +670,37 @@ func (s *Server) handleStreamDirect(w http.ResponseWriter, r *http.Request) {
 
 	// Enforce per-user streaming limits (P15-04)
 	userID := s.getUserID(r)
+	device := r.URL.Query().Get("device")
+	if device == "" {
+		device = r.UserAgent()
+	}
+	playback := mediaID.String() + "|" + device
 	if userID != uuid.Nil {
-		var maxStreams int
-		s.db.QueryRow("SELECT max_simultaneous_streams FROM users WHERE id = $1", userID).Scan(&maxStreams)
-		if maxStreams > 0 {
-			var activeCount int
-			s.db.QueryRow("SELECT COUNT(*) FROM stream_sessions WHERE user_id = $1 AND ended_at IS NULL", userID).Scan(&activeCount)
-			if activeCount >= maxStreams {
-				s.respondError(w, http.StatusTooManyRequests, "maximum simultaneous streams reached")
-				return
-			}
+		limits := s.streamLimitsFor(r, userID)
+		if !s.checkStreamCount(userID, playback, limits) {
+			s.respondStreamLimit(w, "max_streams", fmt.Sprintf("maximum of %d simultaneous streams reached", limits.MaxStreams))
+			return
+		}
+		// The original can't be scaled down; the client should transcode
+		if limits.MaxBitrate > 0 && media.Bitrate != nil && *media.Bitrate > limits.MaxBitrate {
+			s.respondStreamLimit(w, "max_bitrate", fmt.Sprintf("source bitrate is over your %d kbps limit; play a transcode instead", limits.MaxBitrate/1000))
+			return
+		}
+		if limits.MaxHeight > 0 && media.Height != nil && normalizeResolution(*media.Height) > limits.MaxHeight {
+			s.respondStreamLimit(w, "remote_quality_cap", fmt.Sprintf("source resolution is over your %dp remote limit; play a transcode instead", limits.MaxHeight))
+			return
 		}
 	}
+	defer s.directStreams.begin(userID.String(), playback)()
+
+	// mode=remux forces the remux path for players that can't open the
+	// container, mode=audio also re-encodes the audio (see PlaybackInfo)
+	mode := r.URL.Query().Get("mode")
+	remux := stream.NeedsRemux(media.FilePath) || mode == "remux" || mode == "audio"
 
 	// Determine playback type and record stream session
 	playbackType := models.PlaybackDirectPlay
-	if stream.NeedsRemux(media.FilePath) {
+	if remux {
 		playbackType = models.PlaybackDirectStream
 	}
 
@@ -401,14 +724,14 @@ func (s *Server) handleStreamDirect(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 
 	// Non-native formats: remux to MPEG-TS on-the-fly (Plex direct stream)
-	if stream.NeedsRemux(media.FilePath) {
+	if remux {
 		audioCodec := ""
 		if media.AudioCodec != nil {
 			audioCodec = *media.AudioCodec
 		}
 
 		// Parse optional audio track selection
-		remuxOpts := stream.RemuxOptions{AudioStreamIndex: -1, AudioCodec: audioCodec}
+		remuxOpts := stream.RemuxOptions{AudioStreamIndex: -1, AudioCodec: audioCodec, TranscodeAudio: mode == "audio"}
 		if audioParam := r.URL.Query().Get("audio"); audioParam != "" {
 			if idx, err := strconv.Atoi(audioParam); err == nil && idx >= 0 {
 				remuxOpts.AudioStreamIndex = idx
@@ -483,28 +806,140 @@ func (s *Server) handleStreamSubtitle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Embedded subtitle: extract from media file via FFmpeg
-	if sub.StreamIndex == nil {
-		s.respondError(w, http.StatusInternalServerError, "embedded subtitle missing stream index")
+	vtt, err := s.embeddedSubtitleVTT(sub)
+	if err != nil {
+		log.Printf("Embedded subtitle extraction error: %v", err)
+		s.respondError(w, http.StatusInternalServerError, "failed to extract subtitle")
 		return
 	}
 
-	// Get the media item to find the file path
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(vtt))
+}
+
+// subtitleVTT returns a subtitle as WebVTT.
+func (s *Server) subtitleVTT(sub *models.MediaSubtitle) (string, error) {
+	if sub.Source == models.SubtitleSourceExternal && sub.FilePath != nil {
+		return stream.ConvertToWebVTT(*sub.FilePath, sub.Format)
+	}
+	return s.embeddedSubtitleVTT(sub)
+}
+
+// embeddedSubtitleVTT extracts an embedded subtitle as WebVTT. Extraction
+// reads the whole media file, so the result is kept on disk until the file
+// changes; segmented playlists ask for it once per segment.
+func (s *Server) embeddedSubtitleVTT(sub *models.MediaSubtitle) (string, error) {
+	if sub.StreamIndex == nil {
+		return "", errors.New("embedded subtitle missing stream index")
+	}
 	media, err := s.mediaRepo.GetByID(sub.MediaItemID)
 	if err != nil {
-		s.respondError(w, http.StatusNotFound, "media not found")
-		return
+		return "", fmt.Errorf("media not found: %w", err)
+	}
+
+	cachePath := filepath.Join(s.config.Paths.Preview, "subtitles", sub.ID.String()+".vtt")
+	if cached, err := os.Stat(cachePath); err == nil {
+		if src, err := os.Stat(media.FilePath); err == nil && cached.ModTime().After(src.ModTime()) {
+			if data, err := os.ReadFile(cachePath); err == nil {
+				return string(data), nil
+			}
+		}
 	}
 
 	vtt, err := stream.ExtractEmbeddedSubtitle(s.config.FFmpeg.FFmpegPath, media.FilePath, *sub.StreamIndex)
 	if err != nil {
-		log.Printf("Embedded subtitle extraction error: %v", err)
-		s.respondError(w, http.StatusInternalServerError, "failed to extract subtitle")
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err == nil {
+		tmp := cachePath + ".tmp"
+		if os.WriteFile(tmp, []byte(vtt), 0644) == nil {
+			os.Rename(tmp, cachePath)
+		}
+	}
+	return vtt, nil
+}
+
+// handleStreamSubtitlePlaylist lists a subtitle as WebVTT segments on the
+// video's segment boundaries, for the subtitles group of master playlists.
+// ?container=ts maps cue times onto MPEG-TS timestamps.
+// GET /api/v1/stream/{mediaId}/subtitles/{id}/playlist.m3u8
+func (s *Server) handleStreamSubtitlePlaylist(w http.ResponseWriter, r *http.Request) {
+	mediaID, err := uuid.Parse(r.PathValue("mediaId"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid media id")
+		return
+	}
+	subtitleID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid subtitle id")
+		return
+	}
+	media, err := s.mediaRepo.GetByID(mediaID)
+	if err != nil {
+		s.respondError(w, http.StatusNotFound, "media not found")
+		return
+	}
+	if media.DurationSeconds == nil || *media.DurationSeconds <= 0 {
+		s.respondError(w, http.StatusUnprocessableEntity, "media duration unknown; scan the file first")
 		return
 	}
+	q := url.Values{}
+	for _, k := range []string{"token", "container"} {
+		if v := r.URL.Query().Get(k); v != "" {
+			q.Set(k, v)
+		}
+	}
+	query := q.Encode()
+	if query != "" {
+		query = "?" + query
+	}
+	base := fmt.Sprintf("/api/v1/stream/%s/subtitles/%s/", mediaID, subtitleID)
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Write([]byte(stream.SubtitlePlaylist(float64(*media.DurationSeconds), func(n int) string {
+		return base + stream.SegmentName(n, "vtt") + query
+	})))
+}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(vtt))
+// handleStreamSubtitleSegment serves one WebVTT segment of a subtitle
+// playlist.
+// GET /api/v1/stream/{mediaId}/subtitles/{id}/{segment}
+func (s *Server) handleStreamSubtitleSegment(w http.ResponseWriter, r *http.Request) {
+	subtitleID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid subtitle id")
+		return
+	}
+	n, ok := stream.ParseSegmentName(r.PathValue("segment"))
+	if !ok {
+		s.respondError(w, http.StatusNotFound, "segment not found")
+		return
+	}
+	sub, err := s.tracksRepo.GetSubtitleByID(subtitleID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			s.respondError(w, http.StatusNotFound, "subtitle not found")
+		} else {
+			s.respondError(w, http.StatusInternalServerError, "failed to fetch subtitle")
+		}
+		return
+	}
+	if !stream.IsTextSubtitle(sub.Format) {
+		s.respondError(w, http.StatusUnprocessableEntity, "image subtitles can't be served as WebVTT")
+		return
+	}
+	vtt, err := s.subtitleVTT(sub)
+	if err != nil {
+		log.Printf("Subtitle segment error: %v", err)
+		s.respondError(w, http.StatusInternalServerError, "failed to convert subtitle")
+		return
+	}
+	var offset int64
+	if r.URL.Query().Get("container") == "ts" {
+		offset = stream.MPEGTSTimestampOffset
+	}
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write([]byte(stream.SegmentWebVTT(vtt, n, offset)))
 }
 
 // normalizeResolution maps actual pixel heights to standard resolution labels
@@ -523,3 +958,18 @@ func normalizeResolution(height int) int {
 	}
 	return height
 }
+
+// DELETE /api/v1/stream/sessions/{sessionId} — stop a transcode session
+func (s *Server) handleStopStreamSession(w http.ResponseWriter, r *http.Request) {
+	session := s.transcoder.GetSession(r.PathValue("sessionId"))
+	if session == nil || session.Live {
+		s.respondError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	if session.UserID != s.getUserID(r).String() && models.UserRole(r.Header.Get("X-User-Role")) != models.RoleAdmin {
+		s.respondError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	s.transcoder.StopSession(session.ID)
+	s.respondJSON(w, http.StatusOK, Response{Success: true})
+}
@@ -2,12 +2,17 @@ package api
 
 import (
 	"crypto/rand"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
+	"github.com/JustinTDCT/CineVault/internal/stream"
+	"github.com/JustinTDCT/CineVault/internal/syncplay"
 	"github.com/google/uuid"
 )
 
@@ -45,7 +50,9 @@ func (s *Server) handleSyncCreate(w http.ResponseWriter, r *http.Request) {
 	}})
 }
 
-// POST /api/v1/sync/join — Join a session by invite code
+// POST /api/v1/sync/join — Join a session by invite code. Playback state is
+// then synchronised over the WebSocket: the client sends sync:join with the
+// returned session_id.
 func (s *Server) handleSyncJoin(w http.ResponseWriter, r *http.Request) {
 	userID := s.getUserID(r)
 	var req struct {
@@ -56,37 +63,39 @@ func (s *Server) handleSyncJoin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var sessionID uuid.UUID
-	var mediaItemID uuid.UUID
+	var sessionID, mediaItemID, hostID uuid.UUID
 	var state string
 	var currentTime float64
 	var maxParticipants int
-	err := s.db.QueryRow(`SELECT id, media_item_id, state, current_time_sec, max_participants
+	err := s.db.QueryRow(`SELECT id, media_item_id, host_user_id, state, current_time_sec, max_participants
 		FROM sync_sessions WHERE invite_code = $1 AND state != 'ended'`, req.InviteCode).
-		Scan(&sessionID, &mediaItemID, &state, &currentTime, &maxParticipants)
+		Scan(&sessionID, &mediaItemID, &hostID, &state, &currentTime, &maxParticipants)
 	if err != nil {
 		s.respondError(w, http.StatusNotFound, "session not found or ended")
 		return
 	}
 
-	// Check participant count
+	// Check participant count (rejoining doesn't take another seat)
 	var count int
-	s.db.QueryRow("SELECT COUNT(*) FROM sync_participants WHERE session_id = $1", sessionID).Scan(&count)
-	if count >= maxParticipants {
+	var already bool
+	s.db.QueryRow(`SELECT COUNT(*), COALESCE(BOOL_OR(user_id = $2), FALSE) FROM sync_participants WHERE session_id = $1`,
+		sessionID, userID).Scan(&count, &already)
+	if !already && count >= maxParticipants {
 		s.respondError(w, http.StatusConflict, "session is full")
 		return
 	}
 
-	s.db.Exec("INSERT INTO sync_participants (session_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", sessionID, userID)
-
-	// Broadcast join event
-	s.wsHub.Broadcast("sync:join", map[string]interface{}{
-		"session_id": sessionID, "user_id": userID,
-	})
+	if _, err := s.db.Exec("INSERT INTO sync_participants (session_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", sessionID, userID); err != nil {
+		s.respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 
+	if live, ok := s.syncPlay.Snapshot(sessionID); ok {
+		state, currentTime, hostID = live.State, live.Position, live.HostID
+	}
 	s.respondJSON(w, http.StatusOK, Response{Success: true, Data: map[string]interface{}{
-		"session_id": sessionID, "media_item_id": mediaItemID,
-		"state": state, "current_time": currentTime,
+		"session_id": sessionID, "media_item_id": mediaItemID, "host_id": hostID,
+		"invite_code": req.InviteCode, "state": state, "current_time": currentTime,
 	}})
 }
 
@@ -99,14 +108,6 @@ func (s *Server) handleSyncAction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify host
-	var hostID string
-	s.db.QueryRow("SELECT host_user_id FROM sync_sessions WHERE id = $1", sessionID).Scan(&hostID)
-	if hostID != userID.String() {
-		s.respondError(w, http.StatusForbidden, "only host can control playback")
-		return
-	}
-
 	var req struct {
 		Action      string  `json:"action"` // play, pause, seek
 		CurrentTime float64 `json:"current_time"`
@@ -115,23 +116,33 @@ func (s *Server) handleSyncAction(w http.ResponseWriter, r *http.Request) {
 		s.respondError(w, http.StatusBadRequest, "invalid body")
 		return
 	}
-
-	// Update session state
-	newState := "playing"
-	if req.Action == "pause" {
-		newState = "paused"
+	if req.Action != "play" && req.Action != "pause" && req.Action != "seek" {
+		s.respondError(w, http.StatusBadRequest, "action must be play, pause or seek")
+		return
 	}
-	s.db.Exec("UPDATE sync_sessions SET state = $1, current_time_sec = $2, updated_at = NOW() WHERE id = $3",
-		newState, req.CurrentTime, sessionID)
-
-	// Broadcast to all participants
-	s.wsHub.Broadcast("sync:action", map[string]interface{}{
-		"session_id":   sessionID,
-		"action":       req.Action,
-		"current_time": req.CurrentTime,
-		"from_user":    userID,
-	})
 
+	err = s.syncPlay.Command(sessionID, userID, req.Action, req.CurrentTime)
+	switch {
+	case errors.Is(err, syncplay.ErrNotHost):
+		s.respondError(w, http.StatusForbidden, err.Error())
+		return
+	case errors.Is(err, syncplay.ErrNoSession):
+		// Nobody connected: just record where the host left it
+		newState := syncplay.StatePlaying
+		if req.Action == "pause" {
+			newState = syncplay.StatePaused
+		}
+		res, err := s.db.Exec(`UPDATE sync_sessions SET state = $1, current_time_sec = $2, updated_at = NOW()
+			WHERE id = $3 AND host_user_id = $4 AND state != 'ended'`, newState, req.CurrentTime, sessionID, userID)
+		if err != nil {
+			s.respondError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			s.respondError(w, http.StatusForbidden, syncplay.ErrNotHost.Error())
+			return
+		}
+	}
 	s.respondJSON(w, http.StatusOK, Response{Success: true})
 }
 
@@ -151,13 +162,20 @@ func (s *Server) handleSyncChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var member bool
+	s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM sync_participants WHERE session_id = $1 AND user_id = $2)", sessionID, userID).Scan(&member)
+	if !member {
+		s.respondError(w, http.StatusForbidden, "not a participant")
+		return
+	}
+
 	s.db.Exec("INSERT INTO sync_chat (session_id, user_id, message) VALUES ($1, $2, $3)", sessionID, userID, req.Message)
 
 	// Get display name
 	var displayName string
 	s.db.QueryRow("SELECT COALESCE(display_name, username) FROM users WHERE id = $1", userID).Scan(&displayName)
 
-	s.wsHub.Broadcast("sync:chat", map[string]interface{}{
+	s.syncPlay.Broadcast(sessionID, "sync:chat", map[string]interface{}{
 		"session_id": sessionID, "user": displayName, "message": req.Message,
 		"timestamp": time.Now(),
 	})
@@ -183,6 +201,10 @@ func (s *Server) handleSyncInfo(w http.ResponseWriter, r *http.Request) {
 		s.respondError(w, http.StatusNotFound, "session not found")
 		return
 	}
+	connected := []uuid.UUID{}
+	if live, ok := s.syncPlay.Snapshot(sessionID); ok {
+		state, currentTime, hostID, connected = live.State, live.Position, live.HostID, live.Users
+	}
 
 	// Get participants
 	rows, _ := s.db.Query(`SELECT u.id, COALESCE(u.display_name, u.username) FROM sync_participants sp
@@ -194,7 +216,11 @@ func (s *Server) handleSyncInfo(w http.ResponseWriter, r *http.Request) {
 			var uid uuid.UUID
 			var name string
 			if rows.Scan(&uid, &name) == nil {
-				participants = append(participants, map[string]interface{}{"id": uid, "name": name})
+				online := false
+				for _, c := range connected {
+					online = online || c == uid
+				}
+				participants = append(participants, map[string]interface{}{"id": uid, "name": name, "connected": online})
 			}
 		}
 	}
@@ -228,11 +254,52 @@ func (s *Server) handleSyncEnd(w http.ResponseWriter, r *http.Request) {
 		s.respondError(w, http.StatusBadRequest, "invalid session id")
 		return
 	}
-	s.db.Exec("UPDATE sync_sessions SET state = 'ended', updated_at = NOW() WHERE id = $1 AND host_user_id = $2", sessionID, userID)
-	s.wsHub.Broadcast("sync:ended", map[string]interface{}{"session_id": sessionID})
+	res, err := s.db.Exec("UPDATE sync_sessions SET state = 'ended', updated_at = NOW() WHERE id = $1 AND host_user_id = $2", sessionID, userID)
+	if err != nil {
+		s.respondError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		s.respondError(w, http.StatusForbidden, "only the host can end the session")
+		return
+	}
+	s.syncPlay.End(sessionID)
 	s.respondJSON(w, http.StatusOK, Response{Success: true})
 }
 
+// syncStore persists SyncPlay state in sync_sessions for the coordinator.
+type syncStore struct {
+	db *sql.DB
+}
+
+func (st *syncStore) Open(sessionID, userID uuid.UUID) (*syncplay.Session, error) {
+	sess := &syncplay.Session{ID: sessionID}
+	err := st.db.QueryRow(`SELECT s.media_item_id, s.host_user_id, s.state, s.current_time_sec
+		FROM sync_sessions s JOIN sync_participants p ON p.session_id = s.id AND p.user_id = $2
+		WHERE s.id = $1 AND s.state != 'ended'`, sessionID, userID).
+		Scan(&sess.MediaItemID, &sess.HostID, &sess.State, &sess.Position)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("session not found, ended, or not joined")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+func (st *syncStore) SaveState(sessionID uuid.UUID, state string, position float64) {
+	if _, err := st.db.Exec(`UPDATE sync_sessions SET state = $1, current_time_sec = $2, updated_at = NOW()
+		WHERE id = $3 AND state != 'ended'`, state, position, sessionID); err != nil {
+		log.Printf("[sync] save session %s: %v", sessionID, err)
+	}
+}
+
+func (st *syncStore) SetHost(sessionID, hostID uuid.UUID) {
+	if _, err := st.db.Exec(`UPDATE sync_sessions SET host_user_id = $1, updated_at = NOW() WHERE id = $2`, hostID, sessionID); err != nil {
+		log.Printf("[sync] hand over session %s: %v", sessionID, err)
+	}
+}
+
 // ══════════════════════ Cinema Mode (P12-02) ══════════════════════
 
 // GET /api/v1/cinema/pre-rolls
@@ -376,58 +443,37 @@ func (s *Server) handleCinemaQueue(w http.ResponseWriter, r *http.Request) {
 
 // GET /api/v1/stream/{mediaId}/manifest.mpd — Generate DASH manifest
 func (s *Server) handleStreamDASH(w http.ResponseWriter, r *http.Request) {
-	mediaID := r.PathValue("mediaId")
-	token := r.URL.Query().Get("token")
-
-	var height, duration int
-	s.db.QueryRow("SELECT COALESCE(height, 0), COALESCE(duration_seconds, 0) FROM media_items WHERE id = $1", mediaID).
-		Scan(&height, &duration)
-
-	qualities := buildDASHQualities(height)
-
-	// Build MPD XML
-	mpd := `<?xml version="1.0" encoding="UTF-8"?>` + "\n"
-	mpd += fmt.Sprintf(`<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" profiles="urn:mpeg:dash:profile:isoff-live:2011" type="static" mediaPresentationDuration="PT%dS" minBufferTime="PT2S">`, duration) + "\n"
-	mpd += `  <Period>` + "\n"
-	mpd += `    <AdaptationSet mimeType="video/mp4" segmentAlignment="true">` + "\n"
-	for _, q := range qualities {
-		mpd += fmt.Sprintf(`      <Representation id="%s" bandwidth="%d" width="%d" height="%d">`, q.name, q.bitrate, q.width, q.height) + "\n"
-		mpd += fmt.Sprintf(`        <BaseURL>/api/v1/stream/%s/%s/init.mp4?token=%s</BaseURL>`, mediaID, q.name, token) + "\n"
-		mpd += `      </Representation>` + "\n"
+	mediaID, err := uuid.Parse(r.PathValue("mediaId"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid media id")
+		return
+	}
+	media, err := s.mediaRepo.GetByID(mediaID)
+	if err != nil {
+		s.respondError(w, http.StatusNotFound, "media not found")
+		return
 	}
-	mpd += `    </AdaptationSet>` + "\n"
-	mpd += `  </Period>` + "\n"
-	mpd += `</MPD>`
-
-	w.Header().Set("Content-Type", "application/dash+xml")
-	w.Write([]byte(mpd))
-}
-
-type dashQuality struct {
-	name    string
-	width   int
-	height  int
-	bitrate int
-}
 
-func buildDASHQualities(sourceHeight int) []dashQuality {
-	all := []dashQuality{
-		{"360p", 640, 360, 800000},
-		{"480p", 854, 480, 1400000},
-		{"720p", 1280, 720, 2800000},
-		{"1080p", 1920, 1080, 5000000},
-		{"4K", 3840, 2160, 14000000},
-	}
-	var result []dashQuality
-	for _, q := range all {
-		if q.height <= sourceHeight+100 {
-			result = append(result, q)
-		}
+	// DASH is always CMAF; the segments are the same files the CMAF HLS
+	// playlists reference. dash.js doesn't add the token to segment
+	// requests, so it travels in the URLs.
+	tcOpts, sessionQuery := transcodeRequestOptions(r)
+	tcOpts.Packaging = stream.PackagingCMAF
+	if token := r.URL.Query().Get("token"); token != "" {
+		sessionQuery.Set("token", token)
+	}
+	pres, err := s.cmafPresentation(media, transcodeQualities(media), tcOpts, sessionQuery)
+	if err != nil {
+		s.respondError(w, http.StatusUnprocessableEntity, err.Error())
+		return
 	}
-	if len(result) == 0 {
-		result = append(result, all[0])
+	mpd, err := stream.DASHManifest(pres)
+	if err != nil {
+		s.respondError(w, http.StatusInternalServerError, "failed to build manifest")
+		return
 	}
-	return result
+	w.Header().Set("Content-Type", "application/dash+xml")
+	w.Write(mpd)
 }
 
 // ══════════════════════ Lyrics (P13-03) ══════════════════════
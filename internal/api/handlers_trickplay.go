@@ -0,0 +1,182 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/JustinTDCT/CineVault/internal/preview"
+	"github.com/google/uuid"
+)
+
+// ──────────────────── Trickplay ────────────────────
+
+// Trickplay thumbnails for scrubbing, all cut from the timeline sprite
+// sheet the sprites job makes: a WebVTT thumbnails track, HLS image and
+// I-frame playlists and a Roku BIF file.
+
+// trickplaySprite returns a media item's sprite sheet on disk and its
+// layout, or ok false if it has none.
+func (s *Server) trickplaySprite(media *models.MediaItem) (spritePath string, layout *preview.SpriteLayout, ok bool) {
+	if media.SpritePath == nil || *media.SpritePath == "" || media.DurationSeconds == nil {
+		return "", nil, false
+	}
+	spritePath = filepath.Join(s.config.Paths.Preview, "sprites", media.ID.String()+".jpg")
+	layout, err := preview.LoadSpriteLayout(spritePath, *media.DurationSeconds)
+	if err != nil {
+		return "", nil, false
+	}
+	return spritePath, layout, true
+}
+
+// trickplayDir is where the BIF and I-frame segments made from a media
+// item's sprite sheet are kept.
+func (s *Server) trickplayDir(mediaID uuid.UUID) string {
+	return filepath.Join(s.config.Paths.Preview, "trickplay", mediaID.String())
+}
+
+// trickplayURLs are the trickplay endpoints for a media item, with query
+// (the token, for clients that can't send headers) appended.
+func trickplayURLs(mediaID uuid.UUID, query string) map[string]string {
+	if query != "" {
+		query = "?" + query
+	}
+	base := "/api/v1/stream/" + mediaID.String()
+	return map[string]string{
+		"sprite":  base + "/trickplay/sprite.jpg" + query,
+		"vtt":     base + "/trickplay.vtt" + query,
+		"images":  base + "/trickplay/images.m3u8" + query,
+		"iframes": base + "/trickplay/iframes.m3u8" + query,
+		"bif":     base + "/trickplay.bif" + query,
+	}
+}
+
+// tokenQuery carries a ?token= over to URLs in generated playlists.
+func tokenQuery(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return "token=" + url.QueryEscape(token)
+	}
+	return ""
+}
+
+// trickplayMedia loads the media item in the path and its sprite sheet,
+// writing a 404 if either is missing.
+func (s *Server) trickplayMedia(w http.ResponseWriter, r *http.Request) (*models.MediaItem, string, *preview.SpriteLayout, bool) {
+	mediaID, err := uuid.Parse(r.PathValue("mediaId"))
+	if err != nil {
+		s.respondError(w, http.StatusBadRequest, "invalid media id")
+		return nil, "", nil, false
+	}
+	media, err := s.mediaRepo.GetByID(mediaID)
+	if err != nil {
+		s.respondError(w, http.StatusNotFound, "media not found")
+		return nil, "", nil, false
+	}
+	spritePath, layout, ok := s.trickplaySprite(media)
+	if !ok {
+		s.respondError(w, http.StatusNotFound, "no trickplay thumbnails for this media")
+		return nil, "", nil, false
+	}
+	return media, spritePath, layout, true
+}
+
+// GET /api/v1/stream/{mediaId}/trickplay/sprite.jpg — the sprite sheet
+func (s *Server) handleTrickplaySprite(w http.ResponseWriter, r *http.Request) {
+	_, spritePath, _, ok := s.trickplayMedia(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, spritePath)
+}
+
+// GET /api/v1/stream/{mediaId}/trickplay.vtt — WebVTT thumbnails track with
+// #xywh= sprite fragments
+func (s *Server) handleTrickplayVTT(w http.ResponseWriter, r *http.Request) {
+	media, _, layout, ok := s.trickplayMedia(w, r)
+	if !ok {
+		return
+	}
+	urls := trickplayURLs(media.ID, tokenQuery(r))
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Write([]byte(layout.WebVTT(urls["sprite"])))
+}
+
+// GET /api/v1/stream/{mediaId}/trickplay/images.m3u8 — HLS image playlist
+// (EXT-X-IMAGES-ONLY) over the sprite sheet
+func (s *Server) handleTrickplayImages(w http.ResponseWriter, r *http.Request) {
+	media, _, layout, ok := s.trickplayMedia(w, r)
+	if !ok {
+		return
+	}
+	urls := trickplayURLs(media.ID, tokenQuery(r))
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Write([]byte(layout.ImagePlaylist(urls["sprite"])))
+}
+
+// GET /api/v1/stream/{mediaId}/trickplay/iframes.m3u8 — HLS I-frame playlist
+// of one-frame segments cut from the sprite sheet
+func (s *Server) handleTrickplayIFrames(w http.ResponseWriter, r *http.Request) {
+	media, _, layout, ok := s.trickplayMedia(w, r)
+	if !ok {
+		return
+	}
+	query := tokenQuery(r)
+	if query != "" {
+		query = "?" + query
+	}
+	base := "/api/v1/stream/" + media.ID.String() + "/trickplay/iframe/"
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Write([]byte(layout.IFramePlaylist(func(i int) string {
+		return base + strconv.Itoa(i) + ".ts" + query
+	})))
+}
+
+// GET /api/v1/stream/{mediaId}/trickplay/iframe/{segment} — one I-frame
+// segment; the set is encoded from the sprite sheet on first request
+func (s *Server) handleTrickplayIFrame(w http.ResponseWriter, r *http.Request) {
+	media, spritePath, layout, ok := s.trickplayMedia(w, r)
+	if !ok {
+		return
+	}
+	var n int
+	if _, err := fmt.Sscanf(r.PathValue("segment"), "%d.ts", &n); err != nil || n < 0 || n >= layout.Count {
+		s.respondError(w, http.StatusNotFound, "segment not found")
+		return
+	}
+	dir := filepath.Join(s.trickplayDir(media.ID), "iframes")
+	if err := preview.EnsureIFrames(s.config.FFmpeg.FFmpegPath, spritePath, dir, layout); err != nil {
+		log.Printf("Trickplay: I-frames for %s: %v", media.FileName, err)
+		s.respondError(w, http.StatusInternalServerError, "failed to build I-frame segments")
+		return
+	}
+	segPath := filepath.Join(dir, preview.IFrameSegmentName(n))
+	if _, err := os.Stat(segPath); err != nil {
+		s.respondError(w, http.StatusNotFound, "segment not found")
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp2t")
+	http.ServeFile(w, r, segPath)
+}
+
+// GET /api/v1/stream/{mediaId}/trickplay.bif — Roku BIF file, built from the
+// sprite sheet on first request
+func (s *Server) handleTrickplayBIF(w http.ResponseWriter, r *http.Request) {
+	media, spritePath, layout, ok := s.trickplayMedia(w, r)
+	if !ok {
+		return
+	}
+	bifPath := filepath.Join(s.trickplayDir(media.ID), "thumbnails.bif")
+	if err := preview.EnsureBIF(spritePath, bifPath, layout); err != nil {
+		log.Printf("Trickplay: BIF for %s: %v", media.FileName, err)
+		s.respondError(w, http.StatusInternalServerError, "failed to build BIF")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeFile(w, r, bifPath)
+}
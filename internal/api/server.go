@@ -5,21 +5,27 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/JustinTDCT/CineVault/internal/auth"
+	"github.com/JustinTDCT/CineVault/internal/cast"
 	"github.com/JustinTDCT/CineVault/internal/config"
 	"github.com/JustinTDCT/CineVault/internal/db"
 	"github.com/JustinTDCT/CineVault/internal/detection"
+	"github.com/JustinTDCT/CineVault/internal/dlna"
 	"github.com/JustinTDCT/CineVault/internal/jobs"
+	"github.com/JustinTDCT/CineVault/internal/livetv"
 	"github.com/JustinTDCT/CineVault/internal/metadata"
 	"github.com/JustinTDCT/CineVault/internal/models"
 	"github.com/JustinTDCT/CineVault/internal/notifications"
 	"github.com/JustinTDCT/CineVault/internal/repository"
 	"github.com/JustinTDCT/CineVault/internal/scanner"
 	"github.com/JustinTDCT/CineVault/internal/stream"
+	"github.com/JustinTDCT/CineVault/internal/syncplay"
 	"github.com/google/uuid"
 )
 
@@ -49,13 +55,27 @@ type Server struct {
 	notificationRepo  *repository.NotificationRepository
 	displayPrefsRepo  *repository.DisplayPreferencesRepository
 	tracksRepo        *repository.TracksRepository
+	dvrRepo           *repository.DVRRepository
+	optimizedRepo     *repository.OptimizedRepository
+	guide             *livetv.Ingester
+	tuners            *livetv.TunerPool
+	seriesRules       *livetv.SeriesRules
+	cast              *cast.Manager
+	playTo            *dlna.PlayTo
+	contentDirectory  *dlna.ContentDirectoryService
+	connectionManager *dlna.ConnectionManagerService
+	syncPlay          *syncplay.Coordinator
 	detector         *detection.Detector
 	scanner          *scanner.Scanner
 	transcoder       *stream.Transcoder
+	audioCache       *stream.AudioCache
+	directStreams    *streamTracker
+	trustedProxies   []*net.IPNet
 	jobQueue         *jobs.Queue
 	wsHub            *WSHub
 	webhookSender    *notifications.WebhookSender
 	scrapers         []metadata.Scraper
+	metadataRegistry *metadata.Registry
 	router           *http.ServeMux
 }
 
@@ -77,15 +97,15 @@ func NewServer(cfg *config.Config, database *db.DB, jobQueue *jobs.Queue) (*Serv
 	audiobookRepo := repository.NewAudiobookRepository(database.DB)
 	galleryRepo := repository.NewGalleryRepository(database.DB)
 
-	// Initialize metadata scrapers
-	var scrapers []metadata.Scraper
+	// Initialize metadata providers
+	registry := metadata.NewRegistry()
 	tmdbKey := cfg.TMDBAPIKey
 	if tmdbKey != "" {
-		scrapers = append(scrapers, metadata.NewTMDBScraper(tmdbKey))
+		registry.Register(metadata.NewTMDBScraper(tmdbKey))
 	}
-	scrapers = append(scrapers, metadata.NewMusicBrainzScraper())
-	scrapers = append(scrapers, metadata.NewOpenLibraryScraper())
-	scrapers = append(scrapers, metadata.NewAudnexusScraper())
+	registry.Register(metadata.NewMusicBrainzScraper())
+	registry.Register(metadata.NewOpenLibraryScraper())
+	registry.Register(metadata.NewAudnexusScraper())
 
 	tagRepo := repository.NewTagRepository(database.DB)
 	settingsRepo := repository.NewSettingsRepository(database.DB)
@@ -93,16 +113,25 @@ func NewServer(cfg *config.Config, database *db.DB, jobQueue *jobs.Queue) (*Serv
 	// Initialize TVDB scraper if API key is configured
 	tvdbKey, _ := settingsRepo.Get("tvdb_api_key")
 	if tvdbKey != "" {
-		scrapers = append(scrapers, metadata.NewTVDBScraper(tvdbKey))
+		registry.Register(metadata.NewTVDBScraper(tvdbKey))
 	}
+	// OMDb and fanart.tv read their keys from settings as they go; they
+	// are only consulted by libraries that name them
+	registry.Register(metadata.NewOMDbScraper(settingsRepo))
+	registry.Register(metadata.NewFanartScraper(settingsRepo))
+	scrapers := registry.All()
 
 	performerRepo := repository.NewPerformerRepository(database.DB)
 	sisterRepo := repository.NewSisterRepository(database.DB)
 	seriesRepo := repository.NewSeriesRepository(database.DB)
 	tracksRepo := repository.NewTracksRepository(database.DB)
+	fileIndexRepo := repository.NewFileIndexRepository(database.DB)
 	posterDir := cfg.Paths.Preview
-	sc := scanner.NewScanner(cfg.FFmpeg.FFprobePath, cfg.FFmpeg.FFmpegPath, cfg.FFmpeg.HWAccel, mediaRepo, tvRepo, musicRepo, audiobookRepo, galleryRepo, tagRepo, performerRepo, settingsRepo, sisterRepo, seriesRepo, tracksRepo, scrapers, posterDir)
+	sc := scanner.NewScanner(cfg.FFmpeg.FFprobePath, cfg.FFmpeg.FFmpegPath, cfg.FFmpeg.HWAccel, mediaRepo, tvRepo, musicRepo, audiobookRepo, galleryRepo, tagRepo, performerRepo, settingsRepo, sisterRepo, seriesRepo, tracksRepo, fileIndexRepo, registry, posterDir)
+	sc.SetConcurrency(cfg.Scanner.ProbeWorkers, cfg.Scanner.CPUWorkers, cfg.Scanner.MetadataWorkers)
 	transcoder := stream.NewTranscoder(cfg.FFmpeg.FFmpegPath, cfg.Paths.Preview)
+	transcoder.SetMaxTranscodes(cfg.FFmpeg.MaxTranscodes)
+	transcoder.SetThrottle(cfg.FFmpeg.ThrottleSegments, int64(cfg.FFmpeg.DiskQuotaMB)<<20)
 
 	wsHub := NewWSHub()
 
@@ -138,16 +167,31 @@ func NewServer(cfg *config.Config, database *db.DB, jobQueue *jobs.Queue) (*Serv
 		notificationRepo: notificationRepo,
 		displayPrefsRepo: repository.NewDisplayPreferencesRepository(database.DB),
 		tracksRepo:       tracksRepo,
+		dvrRepo:          repository.NewDVRRepository(database.DB),
+		optimizedRepo:    repository.NewOptimizedRepository(database.DB),
+		guide:            livetv.NewIngester(database.DB),
+		tuners:           livetv.NewTunerPool(),
 		detector:         det,
 		scanner:          sc,
 		transcoder:       transcoder,
+		audioCache:       stream.NewAudioCache(cfg.FFmpeg.FFmpegPath, filepath.Join(cfg.Paths.Preview, "audio"), int64(cfg.FFmpeg.AudioCacheMB)<<20),
+		directStreams:    newStreamTracker(),
+		trustedProxies:   parseTrustedProxies(cfg.Server.TrustedProxies),
 		jobQueue:         jobQueue,
 		wsHub:            wsHub,
 		webhookSender:    webhookSender,
 		scrapers:         scrapers,
+		metadataRegistry: registry,
 		router:           http.NewServeMux(),
 	}
 
+	s.seriesRules = livetv.NewSeriesRules(s.dvrRepo, s.mediaRepo)
+	s.cast = cast.NewManager(s.onCastStatus, s.onCastClose)
+	s.playTo = dlna.NewPlayTo(s.onPlayToStatus, s.onPlayToClose)
+	s.contentDirectory = dlna.NewContentDirectoryService("", &dlnaProvider{db: database.DB})
+	s.connectionManager = dlna.NewConnectionManagerService()
+	s.syncPlay = syncplay.NewCoordinator(&syncStore{db: database.DB}, wsHub.SendTo)
+
 	s.setupRoutes()
 	return s, nil
 }
@@ -168,6 +212,38 @@ func (s *Server) MediaRepo() *repository.MediaRepository {
 	return s.mediaRepo
 }
 
+func (s *Server) DVRRepo() *repository.DVRRepository {
+	return s.dvrRepo
+}
+
+func (s *Server) OptimizedRepo() *repository.OptimizedRepository {
+	return s.optimizedRepo
+}
+
+func (s *Server) Guide() *livetv.Ingester {
+	return s.guide
+}
+
+func (s *Server) Tuners() *livetv.TunerPool {
+	return s.tuners
+}
+
+func (s *Server) SeriesRules() *livetv.SeriesRules {
+	return s.seriesRules
+}
+
+func (s *Server) Cast() *cast.Manager {
+	return s.cast
+}
+
+func (s *Server) PlayTo() *dlna.PlayTo {
+	return s.playTo
+}
+
+func (s *Server) SyncPlay() *syncplay.Coordinator {
+	return s.syncPlay
+}
+
 func (s *Server) JobRepo() *repository.JobRepository {
 	return s.jobRepo
 }
@@ -204,6 +280,10 @@ func (s *Server) Transcoder() *stream.Transcoder {
 	return s.transcoder
 }
 
+func (s *Server) AudioCache() *stream.AudioCache {
+	return s.audioCache
+}
+
 func (s *Server) WebhookSender() *notifications.WebhookSender {
 	return s.webhookSender
 }
@@ -277,6 +357,7 @@ func (s *Server) setupRoutes() {
 	s.router.HandleFunc("POST /api/v1/libraries/{id}/rebuild-previews", s.authMiddleware(s.handleRebuildPreviews, models.RoleAdmin))
 	s.router.HandleFunc("POST /api/v1/libraries/{id}/build-previews", s.authMiddleware(s.handleBuildMissingPreviews, models.RoleAdmin))
 	s.router.HandleFunc("GET /api/v1/libraries/{id}/filters", s.authMiddleware(s.handleLibraryFilters, models.RoleUser))
+	s.router.HandleFunc("GET /api/v1/metadata/providers", s.authMiddleware(s.handleMetadataProviders, models.RoleAdmin))
 
 	// TV Shows
 	s.router.HandleFunc("GET /api/v1/libraries/{id}/shows", s.authMiddleware(s.handleListLibraryShows, models.RoleUser))
@@ -286,6 +367,7 @@ func (s *Server) setupRoutes() {
 	s.router.HandleFunc("GET /api/v1/artists/{id}/albums", s.authMiddleware(s.handleListArtistAlbums, models.RoleUser))
 	s.router.HandleFunc("GET /api/v1/albums/{id}", s.authMiddleware(s.handleGetAlbum, models.RoleUser))
 	s.router.HandleFunc("GET /api/v1/albums/{id}/tracks", s.authMiddleware(s.handleListAlbumTracks, models.RoleUser))
+	s.router.HandleFunc("GET /api/v1/albums/{id}/gapless", s.authMiddleware(s.handleAlbumGapless, models.RoleUser))
 	s.router.HandleFunc("GET /api/v1/libraries/{id}/music-genres", s.authMiddleware(s.handleListMusicGenres, models.RoleUser))
 	s.router.HandleFunc("GET /api/v1/libraries/{id}/music-playlist", s.authMiddleware(s.handleSmartPlaylist, models.RoleUser))
 	s.router.HandleFunc("GET /api/v1/libraries/{id}/music-search", s.authMiddleware(s.handleMusicSearch, models.RoleUser))
@@ -334,10 +416,28 @@ func (s *Server) setupRoutes() {
 
 	// Streaming
 	s.router.HandleFunc("GET /api/v1/stream/{mediaId}/info", s.authMiddleware(s.handleStreamInfo, models.RoleUser))
+	s.router.HandleFunc("POST /api/v1/stream/{mediaId}/playback-info", s.authMiddleware(s.handlePlaybackInfo, models.RoleUser))
 	s.router.HandleFunc("GET /api/v1/stream/{mediaId}/master.m3u8", s.authMiddleware(s.handleStreamMaster, models.RoleUser))
 	s.router.HandleFunc("GET /api/v1/stream/{mediaId}/{quality}/{segment}", s.authMiddleware(s.handleStreamSegment, models.RoleUser))
+	s.router.HandleFunc("DELETE /api/v1/stream/sessions/{sessionId}", s.authMiddleware(s.handleStopStreamSession, models.RoleUser))
 	s.router.HandleFunc("GET /api/v1/stream/{mediaId}/direct", s.authMiddleware(s.handleStreamDirect, models.RoleUser))
+	s.router.HandleFunc("GET /api/v1/stream/{mediaId}/audio", s.authMiddleware(s.handleStreamAudio, models.RoleUser))
+	s.router.HandleFunc("GET /api/v1/stream/{mediaId}/trickplay.vtt", s.authMiddleware(s.handleTrickplayVTT, models.RoleUser))
+	s.router.HandleFunc("GET /api/v1/stream/{mediaId}/trickplay.bif", s.authMiddleware(s.handleTrickplayBIF, models.RoleUser))
+	s.router.HandleFunc("GET /api/v1/stream/{mediaId}/trickplay/sprite.jpg", s.authMiddleware(s.handleTrickplaySprite, models.RoleUser))
+	s.router.HandleFunc("GET /api/v1/stream/{mediaId}/trickplay/images.m3u8", s.authMiddleware(s.handleTrickplayImages, models.RoleUser))
+	s.router.HandleFunc("GET /api/v1/stream/{mediaId}/trickplay/iframes.m3u8", s.authMiddleware(s.handleTrickplayIFrames, models.RoleUser))
+	s.router.HandleFunc("GET /api/v1/stream/{mediaId}/trickplay/iframe/{segment}", s.authMiddleware(s.handleTrickplayIFrame, models.RoleUser))
 	s.router.HandleFunc("GET /api/v1/stream/{mediaId}/subtitles/{id}", s.authMiddleware(s.handleStreamSubtitle, models.RoleUser))
+	s.router.HandleFunc("GET /api/v1/stream/{mediaId}/subtitles/{id}/playlist.m3u8", s.authMiddleware(s.handleStreamSubtitlePlaylist, models.RoleUser))
+	s.router.HandleFunc("GET /api/v1/stream/{mediaId}/subtitles/{id}/{segment}", s.authMiddleware(s.handleStreamSubtitleSegment, models.RoleUser))
+
+	// Optimized versions
+	s.router.HandleFunc("GET /api/v1/optimized/profiles", s.authMiddleware(s.handleListOptimizeProfiles, models.RoleUser))
+	s.router.HandleFunc("GET /api/v1/optimized", s.authMiddleware(s.handleListOptimized, models.RoleUser))
+	s.router.HandleFunc("POST /api/v1/optimized", s.authMiddleware(s.handleCreateOptimized, models.RoleUser))
+	s.router.HandleFunc("DELETE /api/v1/optimized/{id}", s.authMiddleware(s.handleDeleteOptimized, models.RoleUser))
+	s.router.HandleFunc("GET /api/v1/optimized/{id}/download", s.authMiddleware(s.handleDownloadOptimized, models.RoleUser))
 
 	// Edition groups
 	s.router.HandleFunc("GET /api/v1/editions", s.authMiddleware(s.handleListEditions, models.RoleUser))
@@ -550,7 +650,7 @@ func (s *Server) setupRoutes() {
 	s.router.HandleFunc("DELETE /api/v1/lastfm/disconnect", s.authMiddleware(s.handleLastfmDisconnect, models.RoleUser))
 	s.router.HandleFunc("POST /api/v1/lastfm/scrobble", s.authMiddleware(s.handleLastfmScrobble, models.RoleUser))
 
-	// Sonarr/Radarr/Lidarr webhooks
+	// Sonarr/Radarr/Lidarr/Readarr webhooks
 	s.router.HandleFunc("POST /api/v1/webhooks/arr", s.handleArrWebhook) // no auth — uses shared secret
 	s.router.HandleFunc("GET /api/v1/admin/webhooks", s.authMiddleware(s.handleListWebhookSecrets, models.RoleAdmin))
 	s.router.HandleFunc("POST /api/v1/admin/webhooks", s.authMiddleware(s.handleCreateWebhookSecret, models.RoleAdmin))
@@ -569,6 +669,7 @@ func (s *Server) setupRoutes() {
 	// Plex/Jellyfin import
 	s.router.HandleFunc("POST /api/v1/admin/import", s.authMiddleware(s.handleStartImport, models.RoleAdmin))
 	s.router.HandleFunc("GET /api/v1/admin/imports", s.authMiddleware(s.handleListImports, models.RoleAdmin))
+	s.router.HandleFunc("GET /api/v1/admin/imports/{id}/report", s.authMiddleware(s.handleImportReport, models.RoleAdmin))
 
 	// ── Phase 11: Security ──
 
@@ -605,10 +706,15 @@ func (s *Server) setupRoutes() {
 	// DLNA (P14-01)
 	s.router.HandleFunc("GET /api/v1/dlna/config", s.authMiddleware(s.handleDLNAConfig, models.RoleAdmin))
 	s.router.HandleFunc("PUT /api/v1/dlna/config", s.authMiddleware(s.handleUpdateDLNAConfig, models.RoleAdmin))
+	s.router.HandleFunc("GET /api/v1/dlna/renderers", s.authMiddleware(s.handleDLNARenderers, models.RoleUser))
 	s.router.HandleFunc("GET /dlna/description.xml", s.handleDLNADescription)
 	s.router.HandleFunc("GET /dlna/content/{id}", s.handleDLNAContent)
+	s.router.HandleFunc("GET /dlna/{service}", s.handleDLNASCPD)
+	s.router.HandleFunc("POST /dlna/control/{service}", s.handleDLNAControl)
+	s.router.HandleFunc("GET /dlna/media/{id}", s.handleDLNAMedia)
 
 	// Chromecast (P14-02)
+	s.router.HandleFunc("GET /api/v1/cast/devices", s.authMiddleware(s.handleCastDevices, models.RoleUser))
 	s.router.HandleFunc("POST /api/v1/cast/session", s.authMiddleware(s.handleCastSession, models.RoleUser))
 	s.router.HandleFunc("GET /api/v1/cast/sessions", s.authMiddleware(s.handleListCastSessions, models.RoleUser))
 	s.router.HandleFunc("DELETE /api/v1/cast/{id}", s.authMiddleware(s.handleEndCastSession, models.RoleUser))
@@ -631,9 +737,16 @@ func (s *Server) setupRoutes() {
 	s.router.HandleFunc("GET /api/v1/livetv/tuners", s.authMiddleware(s.handleListTuners, models.RoleAdmin))
 	s.router.HandleFunc("POST /api/v1/livetv/tuners", s.authMiddleware(s.handleCreateTuner, models.RoleAdmin))
 	s.router.HandleFunc("DELETE /api/v1/livetv/tuners/{id}", s.authMiddleware(s.handleDeleteTuner, models.RoleAdmin))
+	s.router.HandleFunc("POST /api/v1/livetv/tuners/{id}/refresh", s.authMiddleware(s.handleRefreshTuner, models.RoleAdmin))
 	s.router.HandleFunc("GET /api/v1/livetv/epg", s.authMiddleware(s.handleGetEPG, models.RoleUser))
+	s.router.HandleFunc("GET /api/v1/livetv/channels/{id}/stream", s.authMiddleware(s.handleLiveStream, models.RoleUser))
+	s.router.HandleFunc("GET /api/v1/livetv/channels/{id}/stream/{file}", s.authMiddleware(s.handleLiveStreamFile, models.RoleUser))
 	s.router.HandleFunc("POST /api/v1/livetv/recordings", s.authMiddleware(s.handleScheduleRecording, models.RoleUser))
 	s.router.HandleFunc("GET /api/v1/livetv/recordings", s.authMiddleware(s.handleListRecordings, models.RoleUser))
+	s.router.HandleFunc("GET /api/v1/livetv/recordings/rules", s.authMiddleware(s.handleListRecordingRules, models.RoleUser))
+	s.router.HandleFunc("POST /api/v1/livetv/recordings/rules", s.authMiddleware(s.handleCreateRecordingRule, models.RoleUser))
+	s.router.HandleFunc("PUT /api/v1/livetv/recordings/rules/{id}", s.authMiddleware(s.handleUpdateRecordingRule, models.RoleUser))
+	s.router.HandleFunc("DELETE /api/v1/livetv/recordings/rules/{id}", s.authMiddleware(s.handleDeleteRecordingRule, models.RoleUser))
 
 	// Anime info (P15-01)
 	s.router.HandleFunc("GET /api/v1/media/{id}/anime-info", s.authMiddleware(s.handleGetAnimeInfo, models.RoleUser))
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/google/uuid"
 	"nhooyr.io/websocket"
 )
 
@@ -20,6 +21,7 @@ type WSHub struct {
 }
 
 type WSClient struct {
+	id     string
 	conn   *websocket.Conn
 	userID string
 	send   chan []byte
@@ -58,6 +60,25 @@ func (h *WSHub) Broadcast(event string, data interface{}) {
 	}
 }
 
+// SendTo delivers an event to a single connection.
+func (h *WSHub) SendTo(clientID, event string, data interface{}) {
+	msg, err := json.Marshal(WSMessage{Event: event, Data: data})
+	if err != nil {
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.clients {
+		if client.id == clientID {
+			select {
+			case client.send <- msg:
+			default:
+			}
+			return
+		}
+	}
+}
+
 // trackTask keeps a snapshot of each running task so new clients get current state.
 func (h *WSHub) trackTask(data interface{}, raw []byte) {
 	m, ok := data.(map[string]interface{})
@@ -141,6 +162,7 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 
 	client := &WSClient{
+		id:     uuid.NewString(),
 		conn:   conn,
 		userID: claims.UserID.String(),
 		send:   make(chan []byte, 64),
@@ -162,14 +184,23 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	// Reader goroutine (keep connection alive, handle pings)
+	// Reader goroutine (keep connection alive, handle pings). Clients only
+	// send SyncPlay events; everything else is ignored.
 	for {
-		_, _, err := conn.Read(ctx)
+		_, data, err := conn.Read(ctx)
 		if err != nil {
 			break
 		}
+		var in struct {
+			Event string          `json:"event"`
+			Data  json.RawMessage `json:"data"`
+		}
+		if json.Unmarshal(data, &in) == nil && strings.HasPrefix(in.Event, "sync:") {
+			s.syncPlay.Handle(client.id, claims.UserID, in.Event, in.Data)
+		}
 	}
 
 	s.wsHub.removeClient(client)
+	s.syncPlay.Disconnect(client.id)
 	log.Printf("WebSocket client disconnected: %s", claims.Username)
 }
@@ -0,0 +1,440 @@
+package cast
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed is returned for requests on a connection that has gone away.
+var ErrClosed = errors.New("cast connection closed")
+
+// MediaStatus is the subset of a receiver MEDIA_STATUS entry CineVault tracks.
+type MediaStatus struct {
+	MediaSessionID int     `json:"mediaSessionId"`
+	PlayerState    string  `json:"playerState"` // IDLE, BUFFERING, PLAYING, PAUSED
+	IdleReason     string  `json:"idleReason,omitempty"`
+	CurrentTime    float64 `json:"currentTime"`
+	Media          *struct {
+		Duration float64 `json:"duration"`
+	} `json:"media,omitempty"`
+	Volume Volume `json:"volume"`
+}
+
+// Duration returns the media duration in seconds, or 0 when unknown (live).
+func (s *MediaStatus) Duration() float64 {
+	if s.Media == nil {
+		return 0
+	}
+	return s.Media.Duration
+}
+
+type Volume struct {
+	Level float64 `json:"level"`
+	Muted bool    `json:"muted"`
+}
+
+// LoadRequest describes the media to play on the receiver.
+type LoadRequest struct {
+	URL         string
+	ContentType string
+	Title       string
+	ImageURL    string
+	StartTime   float64
+	Duration    float64
+	Live        bool
+}
+
+type application struct {
+	AppID       string `json:"appId"`
+	SessionID   string `json:"sessionId"`
+	TransportID string `json:"transportId"`
+}
+
+type receiverStatus struct {
+	Applications []application `json:"applications"`
+	Volume       Volume        `json:"volume"`
+}
+
+// envelope is the common shape of every JSON payload.
+type envelope struct {
+	Type      string          `json:"type"`
+	RequestID int64           `json:"requestId,omitempty"`
+	Status    json.RawMessage `json:"status,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+}
+
+// Client is one sender connection to a Cast receiver.
+type Client struct {
+	conn   net.Conn
+	wmu    sync.Mutex
+	nextID atomic.Int64
+
+	mu             sync.Mutex
+	pending        map[int64]chan envelope
+	transportID    string
+	appSessionID   string
+	mediaSessionID int
+	lastSeen       time.Time
+
+	// OnMediaStatus receives every MEDIA_STATUS the receiver sends, solicited
+	// or not. Set it before calling Launch.
+	OnMediaStatus func(MediaStatus)
+	// OnClose is called once when the connection drops.
+	OnClose func(error)
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Dial opens a TLS connection to a receiver (host:port, usually port 8009)
+// and its platform channel. Cast devices present self-signed certificates,
+// so the certificate is not verified.
+func Dial(ctx context.Context, addr string) (*Client, error) {
+	d := tls.Dialer{Config: &tls.Config{InsecureSkipVerify: true}}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", addr, err)
+	}
+	c := &Client{
+		conn:     conn,
+		pending:  make(map[int64]chan envelope),
+		lastSeen: time.Now(),
+		closed:   make(chan struct{}),
+	}
+	if err := c.send(defaultReceiver, NamespaceConnection, map[string]interface{}{"type": "CONNECT"}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go c.readLoop()
+	go c.heartbeat()
+	return c, nil
+}
+
+// Close stops the media app's connection and the socket.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	transport := c.transportID
+	c.mu.Unlock()
+	if transport != "" {
+		c.send(transport, NamespaceConnection, map[string]interface{}{"type": "CLOSE"})
+	}
+	c.send(defaultReceiver, NamespaceConnection, map[string]interface{}{"type": "CLOSE"})
+	c.shutdown(nil)
+	return nil
+}
+
+// Done is closed when the connection has ended.
+func (c *Client) Done() <-chan struct{} {
+	return c.closed
+}
+
+func (c *Client) shutdown(err error) {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+		c.mu.Lock()
+		for id, ch := range c.pending {
+			close(ch)
+			delete(c.pending, id)
+		}
+		c.mu.Unlock()
+		if c.OnClose != nil {
+			c.OnClose(err)
+		}
+	})
+}
+
+func (c *Client) send(dest, namespace string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return writeMessage(c.conn, &Message{
+		SourceID:      defaultSender,
+		DestinationID: dest,
+		Namespace:     namespace,
+		Payload:       string(data),
+	})
+}
+
+// request sends a payload with a fresh requestId and waits for the reply
+// carrying the same ID.
+func (c *Client) request(ctx context.Context, dest, namespace string, payload map[string]interface{}) (envelope, error) {
+	id := c.nextID.Add(1)
+	payload["requestId"] = id
+	ch := make(chan envelope, 1)
+	c.mu.Lock()
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(dest, namespace, payload); err != nil {
+		return envelope{}, err
+	}
+	select {
+	case env, ok := <-ch:
+		if !ok {
+			return envelope{}, ErrClosed
+		}
+		switch env.Type {
+		case "LOAD_FAILED", "LOAD_CANCELLED", "INVALID_REQUEST", "INVALID_PLAYER_STATE", "LAUNCH_ERROR":
+			if env.Reason != "" {
+				return env, fmt.Errorf("receiver: %s (%s)", env.Type, env.Reason)
+			}
+			return env, fmt.Errorf("receiver: %s", env.Type)
+		}
+		return env, nil
+	case <-ctx.Done():
+		return envelope{}, ctx.Err()
+	case <-c.closed:
+		return envelope{}, ErrClosed
+	}
+}
+
+func (c *Client) readLoop() {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+		msg, err := readMessage(c.conn)
+		if err != nil {
+			c.shutdown(err)
+			return
+		}
+		c.mu.Lock()
+		c.lastSeen = time.Now()
+		c.mu.Unlock()
+
+		var env envelope
+		if json.Unmarshal([]byte(msg.Payload), &env) != nil {
+			continue
+		}
+		switch {
+		case msg.Namespace == NamespaceHeartbeat && env.Type == "PING":
+			c.send(msg.SourceID, NamespaceHeartbeat, map[string]interface{}{"type": "PONG"})
+			continue
+		case msg.Namespace == NamespaceConnection && env.Type == "CLOSE":
+			// The media app went away (another sender took over, or it was stopped)
+			if msg.SourceID != defaultReceiver {
+				c.mu.Lock()
+				c.transportID, c.mediaSessionID = "", 0
+				c.mu.Unlock()
+			}
+			continue
+		case msg.Namespace == NamespaceMedia && env.Type == "MEDIA_STATUS":
+			c.handleMediaStatus(env.Status)
+		}
+
+		if env.RequestID != 0 {
+			c.mu.Lock()
+			ch := c.pending[env.RequestID]
+			c.mu.Unlock()
+			if ch != nil {
+				select {
+				case ch <- env:
+				default:
+				}
+			}
+		}
+	}
+}
+
+func (c *Client) handleMediaStatus(raw json.RawMessage) {
+	var statuses []MediaStatus
+	if json.Unmarshal(raw, &statuses) != nil {
+		return
+	}
+	for _, st := range statuses {
+		c.mu.Lock()
+		if st.MediaSessionID != 0 {
+			c.mediaSessionID = st.MediaSessionID
+		}
+		c.mu.Unlock()
+		if c.OnMediaStatus != nil {
+			c.OnMediaStatus(st)
+		}
+	}
+}
+
+// heartbeat pings the platform every 5s and drops connections that have
+// been silent for 30s.
+func (c *Client) heartbeat() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			silent := time.Since(c.lastSeen)
+			c.mu.Unlock()
+			if silent > 30*time.Second {
+				c.shutdown(errors.New("receiver stopped responding"))
+				return
+			}
+			if err := c.send(defaultReceiver, NamespaceHeartbeat, map[string]interface{}{"type": "PING"}); err != nil {
+				c.shutdown(err)
+				return
+			}
+		}
+	}
+}
+
+// Launch starts an app on the receiver (or joins it if already running) and
+// connects to its transport.
+func (c *Client) Launch(ctx context.Context, appID string) error {
+	env, err := c.request(ctx, defaultReceiver, NamespaceReceiver, map[string]interface{}{"type": "LAUNCH", "appId": appID})
+	if err != nil {
+		return err
+	}
+	var st receiverStatus
+	if err := json.Unmarshal(env.Status, &st); err != nil {
+		return fmt.Errorf("launch %s: %w", appID, err)
+	}
+	for _, app := range st.Applications {
+		if app.AppID == appID && app.TransportID != "" {
+			c.mu.Lock()
+			c.transportID, c.appSessionID = app.TransportID, app.SessionID
+			c.mu.Unlock()
+			return c.send(app.TransportID, NamespaceConnection, map[string]interface{}{"type": "CONNECT"})
+		}
+	}
+	return fmt.Errorf("launch %s: app did not start", appID)
+}
+
+func (c *Client) transport() (string, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.transportID == "" {
+		return "", 0, errors.New("no media app running on receiver")
+	}
+	return c.transportID, c.mediaSessionID, nil
+}
+
+// Load starts playback of a URL in the launched media app.
+func (c *Client) Load(ctx context.Context, req LoadRequest) (*MediaStatus, error) {
+	transport, _, err := c.transport()
+	if err != nil {
+		return nil, err
+	}
+	streamType := "BUFFERED"
+	if req.Live {
+		streamType = "LIVE"
+	}
+	media := map[string]interface{}{
+		"contentId":   req.URL,
+		"contentType": req.ContentType,
+		"streamType":  streamType,
+		"metadata": map[string]interface{}{
+			"metadataType": 0,
+			"title":        req.Title,
+		},
+	}
+	if req.ImageURL != "" {
+		media["metadata"].(map[string]interface{})["images"] = []map[string]string{{"url": req.ImageURL}}
+	}
+	if req.Duration > 0 {
+		media["duration"] = req.Duration
+	}
+	env, err := c.request(ctx, transport, NamespaceMedia, map[string]interface{}{
+		"type": "LOAD", "media": media, "autoplay": true, "currentTime": req.StartTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return firstStatus(env.Status), nil
+}
+
+func (c *Client) mediaCommand(ctx context.Context, payload map[string]interface{}) (*MediaStatus, error) {
+	transport, mediaSession, err := c.transport()
+	if err != nil {
+		return nil, err
+	}
+	if mediaSession == 0 {
+		return nil, errors.New("nothing is loaded on the receiver")
+	}
+	payload["mediaSessionId"] = mediaSession
+	env, err := c.request(ctx, transport, NamespaceMedia, payload)
+	if err != nil {
+		return nil, err
+	}
+	return firstStatus(env.Status), nil
+}
+
+func (c *Client) Play(ctx context.Context) (*MediaStatus, error) {
+	return c.mediaCommand(ctx, map[string]interface{}{"type": "PLAY"})
+}
+
+func (c *Client) Pause(ctx context.Context) (*MediaStatus, error) {
+	return c.mediaCommand(ctx, map[string]interface{}{"type": "PAUSE"})
+}
+
+func (c *Client) Stop(ctx context.Context) (*MediaStatus, error) {
+	return c.mediaCommand(ctx, map[string]interface{}{"type": "STOP"})
+}
+
+func (c *Client) Seek(ctx context.Context, seconds float64) (*MediaStatus, error) {
+	return c.mediaCommand(ctx, map[string]interface{}{"type": "SEEK", "currentTime": seconds})
+}
+
+// MediaStatus asks the media app for its current status.
+func (c *Client) MediaStatus(ctx context.Context) (*MediaStatus, error) {
+	transport, _, err := c.transport()
+	if err != nil {
+		return nil, err
+	}
+	env, err := c.request(ctx, transport, NamespaceMedia, map[string]interface{}{"type": "GET_STATUS"})
+	if err != nil {
+		return nil, err
+	}
+	return firstStatus(env.Status), nil
+}
+
+// SetVolume sets the receiver volume (0-1).
+func (c *Client) SetVolume(ctx context.Context, level float64) error {
+	if level < 0 {
+		level = 0
+	} else if level > 1 {
+		level = 1
+	}
+	_, err := c.request(ctx, defaultReceiver, NamespaceReceiver, map[string]interface{}{
+		"type": "SET_VOLUME", "volume": map[string]interface{}{"level": level},
+	})
+	return err
+}
+
+// StopApp closes the running media app on the receiver.
+func (c *Client) StopApp(ctx context.Context) error {
+	c.mu.Lock()
+	session := c.appSessionID
+	c.mu.Unlock()
+	if session == "" {
+		return nil
+	}
+	_, err := c.request(ctx, defaultReceiver, NamespaceReceiver, map[string]interface{}{"type": "STOP", "sessionId": session})
+	c.mu.Lock()
+	c.transportID, c.appSessionID, c.mediaSessionID = "", "", 0
+	c.mu.Unlock()
+	return err
+}
+
+func firstStatus(raw json.RawMessage) *MediaStatus {
+	var statuses []MediaStatus
+	if json.Unmarshal(raw, &statuses) != nil || len(statuses) == 0 {
+		return nil
+	}
+	return &statuses[0]
+}
@@ -0,0 +1,135 @@
+package cast
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newFake(t *testing.T) *FakeReceiver {
+	t.Helper()
+	f, err := NewFakeReceiver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// waitStatus returns the first status from ch that satisfies ok.
+func waitStatus(t *testing.T, ch <-chan MediaStatus, ok func(MediaStatus) bool) MediaStatus {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case st := <-ch:
+			if ok(st) {
+				return st
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for MEDIA_STATUS")
+		}
+	}
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	f := newFake(t)
+	ctx := testContext(t)
+
+	c, err := Dial(ctx, f.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	statuses := make(chan MediaStatus, 32)
+	c.OnMediaStatus = func(st MediaStatus) { statuses <- st }
+
+	if err := c.Launch(ctx, DefaultMediaReceiver); err != nil {
+		t.Fatalf("Launch: %v", err)
+	}
+	st, err := c.Load(ctx, LoadRequest{URL: "http://example/movie.m3u8", ContentType: "application/x-mpegURL",
+		Title: "Movie", StartTime: 30, Duration: 600})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if st == nil || st.PlayerState != "PLAYING" || st.Duration() != 600 || st.CurrentTime < 30 {
+		t.Fatalf("Load status = %+v", st)
+	}
+	if !f.Connected(defaultReceiver) || !f.Connected("web-1") {
+		t.Error("receiver did not get CONNECT on both the platform and the media transport")
+	}
+	contentID, state, _, _ := f.State()
+	if contentID != "http://example/movie.m3u8" || state != "PLAYING" {
+		t.Errorf("receiver state = %q %q", contentID, state)
+	}
+	// Every change is also pushed unsolicited
+	waitStatus(t, statuses, func(st MediaStatus) bool { return st.PlayerState == "PLAYING" })
+
+	if st, err = c.Pause(ctx); err != nil || st.PlayerState != "PAUSED" {
+		t.Fatalf("Pause = %+v, %v", st, err)
+	}
+	if st, err = c.Seek(ctx, 120); err != nil || math.Abs(st.CurrentTime-120) > 0.5 {
+		t.Fatalf("Seek = %+v, %v", st, err)
+	}
+	if err := c.SetVolume(ctx, 1.7); err != nil {
+		t.Fatalf("SetVolume: %v", err)
+	}
+	if _, _, _, vol := f.State(); vol.Level != 1 {
+		t.Errorf("volume = %v, want clamped to 1", vol.Level)
+	}
+	if st, err = c.MediaStatus(ctx); err != nil || st.PlayerState != "PAUSED" {
+		t.Fatalf("MediaStatus = %+v, %v", st, err)
+	}
+
+	f.Finish()
+	waitStatus(t, statuses, func(st MediaStatus) bool { return st.IdleReason == "FINISHED" })
+
+	if err := c.StopApp(ctx); err != nil {
+		t.Fatalf("StopApp: %v", err)
+	}
+	if _, err := c.Play(ctx); err == nil {
+		t.Error("Play after StopApp succeeded")
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	f := newFake(t)
+	ctx := testContext(t)
+	c, err := Dial(ctx, f.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Load(ctx, LoadRequest{URL: "http://example/a.mp4"}); err == nil {
+		t.Error("Load before Launch succeeded")
+	}
+	if err := c.Launch(ctx, "NOTANAPP"); err == nil || !strings.Contains(err.Error(), "LAUNCH_ERROR") {
+		t.Errorf("Launch of unknown app: err = %v", err)
+	}
+	if err := c.Launch(ctx, DefaultMediaReceiver); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Pause(ctx); err == nil {
+		t.Error("Pause with nothing loaded succeeded")
+	}
+
+	// Requests fail, not hang, once the receiver goes away
+	f.Close()
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not notice the closed connection")
+	}
+	if _, err := c.MediaStatus(ctx); err == nil {
+		t.Error("MediaStatus on a closed connection succeeded")
+	}
+}
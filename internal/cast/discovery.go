@@ -0,0 +1,274 @@
+package cast
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const castService = "_googlecast._tcp.local."
+
+var mdnsAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Device is a Cast receiver found on the local network.
+type Device struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Model    string `json:"model"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Instance string `json:"instance"`
+}
+
+// Addr returns host:port for Dial.
+func (d *Device) Addr() string {
+	return net.JoinHostPort(d.Host, strconv.Itoa(d.Port))
+}
+
+// Discover sends an mDNS query for _googlecast._tcp and collects answers
+// until ctx is done.
+func Discover(ctx context.Context) ([]Device, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	query := buildQuery(castService)
+	if _, err := conn.WriteToUDP(query, mdnsAddr); err != nil {
+		return nil, err
+	}
+
+	instances := make(map[string]*Device)
+	targets := make(map[string][]*Device) // SRV target host -> devices
+	addrs := make(map[string]string)      // host -> IPv4
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(3 * time.Second)
+	}
+	buf := make([]byte, 9000)
+	resent := false
+	for {
+		// Re-ask once halfway through; some devices miss the first query
+		if !resent && time.Until(deadline) < 1500*time.Millisecond {
+			conn.WriteToUDP(query, mdnsAddr)
+			resent = true
+		}
+		conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		n, src, err := conn.ReadFromUDP(buf)
+		if ctx.Err() != nil || time.Now().After(deadline) {
+			break
+		}
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return nil, err
+		}
+		records, err := parseRecords(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, rr := range records {
+			switch rr.typ {
+			case typePTR:
+				if strings.EqualFold(rr.name, castService) {
+					deviceFor(instances, rr.target)
+				}
+			case typeSRV:
+				if strings.HasSuffix(strings.ToLower(rr.name), "."+castService) {
+					d := deviceFor(instances, rr.name)
+					d.Port = rr.port
+					targets[rr.target] = append(targets[rr.target], d)
+					if d.Host == "" {
+						d.Host = src.IP.String()
+					}
+				}
+			case typeTXT:
+				if strings.HasSuffix(strings.ToLower(rr.name), "."+castService) {
+					d := deviceFor(instances, rr.name)
+					for _, kv := range rr.txt {
+						k, v, _ := strings.Cut(kv, "=")
+						switch k {
+						case "id":
+							d.ID = v
+						case "fn":
+							d.Name = v
+						case "md":
+							d.Model = v
+						}
+					}
+				}
+			case typeA:
+				addrs[rr.name] = rr.ip
+			}
+		}
+	}
+
+	for host, ip := range addrs {
+		for _, d := range targets[host] {
+			d.Host = ip
+		}
+	}
+	var out []Device
+	for _, d := range instances {
+		if d.Host == "" || d.Port == 0 {
+			continue
+		}
+		if d.Name == "" {
+			d.Name = strings.TrimSuffix(d.Instance, "."+castService)
+		}
+		out = append(out, *d)
+	}
+	return out, nil
+}
+
+func deviceFor(instances map[string]*Device, instance string) *Device {
+	key := strings.ToLower(instance)
+	if d, ok := instances[key]; ok {
+		return d
+	}
+	d := &Device{Instance: instance}
+	instances[key] = d
+	return d
+}
+
+// ── Minimal DNS wire format (RFC 1035 / RFC 6762) ──
+
+const (
+	typeA   = 1
+	typePTR = 12
+	typeTXT = 16
+	typeSRV = 33
+)
+
+type record struct {
+	name   string
+	typ    uint16
+	target string   // PTR / SRV
+	port   int      // SRV
+	txt    []string // TXT
+	ip     string   // A
+}
+
+func buildQuery(name string) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[4:], 1) // QDCOUNT
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, typePTR)
+	msg = binary.BigEndian.AppendUint16(msg, 1) // IN; sent from a non-5353 port, so answers come back unicast
+	return msg
+}
+
+var errShortMessage = errors.New("short dns message")
+
+// parseRecords returns the answer, authority and additional records.
+func parseRecords(msg []byte) ([]record, error) {
+	if len(msg) < 12 {
+		return nil, errShortMessage
+	}
+	qd := int(binary.BigEndian.Uint16(msg[4:]))
+	rrCount := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	off := 12
+	for i := 0; i < qd; i++ {
+		_, n, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = n + 4
+	}
+	var records []record
+	for i := 0; i < rrCount; i++ {
+		name, n, err := readName(msg, off)
+		if err != nil {
+			return records, err
+		}
+		off = n
+		if off+10 > len(msg) {
+			return records, errShortMessage
+		}
+		typ := binary.BigEndian.Uint16(msg[off:])
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		rdata := off + 10
+		off = rdata + rdlen
+		if off > len(msg) {
+			return records, errShortMessage
+		}
+		rr := record{name: name, typ: typ}
+		switch typ {
+		case typePTR:
+			rr.target, _, err = readName(msg, rdata)
+		case typeSRV:
+			if rdlen < 7 {
+				continue
+			}
+			rr.port = int(binary.BigEndian.Uint16(msg[rdata+4:]))
+			rr.target, _, err = readName(msg, rdata+6)
+		case typeTXT:
+			for p := rdata; p < off; {
+				l := int(msg[p])
+				if p+1+l > off {
+					break
+				}
+				rr.txt = append(rr.txt, string(msg[p+1:p+1+l]))
+				p += 1 + l
+			}
+		case typeA:
+			if rdlen == 4 {
+				rr.ip = net.IP(msg[rdata : rdata+4]).String()
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		records = append(records, rr)
+	}
+	return records, nil
+}
+
+// readName decodes a possibly-compressed domain name at off and returns it
+// with a trailing dot, plus the offset just past it in the original position.
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for hops := 0; hops < 32; hops++ {
+		if off >= len(msg) {
+			return "", 0, errShortMessage
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case l&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, errShortMessage
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errShortMessage
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+	return "", 0, errors.New("dns name compression loop")
+}
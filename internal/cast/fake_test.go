@@ -0,0 +1,326 @@
+package cast
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+// FakeReceiver is an in-process Cast receiver for exercising the sender
+// without hardware. It speaks the same TLS + protobuf framing as a device,
+// runs the Default Media Receiver, tracks play/pause/seek/stop/volume and
+// advances currentTime while playing. Every media change is broadcast as
+// an unsolicited MEDIA_STATUS, like a real receiver.
+type FakeReceiver struct {
+	ln net.Listener
+
+	mu          sync.Mutex
+	conns       map[net.Conn]*sync.Mutex
+	connected   map[string]bool
+	appRunning  bool
+	mediaID     int
+	contentID   string
+	playerState string
+	idleReason  string
+	position    float64
+	resumedAt   time.Time
+	duration    float64
+	volume      Volume
+	closed      bool
+}
+
+// NewFakeReceiver starts a receiver on 127.0.0.1 with a throwaway
+// self-signed certificate.
+func NewFakeReceiver() (*FakeReceiver, error) {
+	cert, err := selfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		return nil, err
+	}
+	f := &FakeReceiver{
+		ln:          ln,
+		conns:       make(map[net.Conn]*sync.Mutex),
+		connected:   make(map[string]bool),
+		playerState: "IDLE",
+		volume:      Volume{Level: 1},
+	}
+	go f.accept()
+	return f, nil
+}
+
+// Addr is the host:port to Dial.
+func (f *FakeReceiver) Addr() string {
+	return f.ln.Addr().String()
+}
+
+// Device describes the fake receiver as discovery would.
+func (f *FakeReceiver) Device() Device {
+	host, port, _ := net.SplitHostPort(f.Addr())
+	p, _ := net.LookupPort("tcp", port)
+	return Device{ID: "fake-receiver", Name: "Fake Receiver", Model: "Fake", Host: host, Port: p, Instance: "Fake-Receiver." + castService}
+}
+
+// State reports what the receiver is currently doing.
+func (f *FakeReceiver) State() (contentID, playerState string, position float64, volume Volume) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.contentID, f.playerState, f.currentTime(), f.volume
+}
+
+// Connected reports whether a sender has sent CONNECT to destination
+// (receiver-0 for the platform, the transport ID for the media app).
+func (f *FakeReceiver) Connected(destination string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.connected[destination]
+}
+
+// Finish simulates playback reaching the end of the media.
+func (f *FakeReceiver) Finish() {
+	f.mu.Lock()
+	f.playerState, f.idleReason, f.position = "IDLE", "FINISHED", f.duration
+	f.mu.Unlock()
+	f.broadcastMediaStatus()
+}
+
+func (f *FakeReceiver) Close() error {
+	f.mu.Lock()
+	f.closed = true
+	for c := range f.conns {
+		c.Close()
+	}
+	f.mu.Unlock()
+	return f.ln.Close()
+}
+
+func (f *FakeReceiver) accept() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		if f.closed {
+			f.mu.Unlock()
+			conn.Close()
+			return
+		}
+		f.conns[conn] = &sync.Mutex{}
+		f.mu.Unlock()
+		go f.serve(conn)
+	}
+}
+
+func (f *FakeReceiver) serve(conn net.Conn) {
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+		conn.Close()
+	}()
+	for {
+		msg, err := readMessage(conn)
+		if err != nil {
+			return
+		}
+		var req map[string]interface{}
+		if json.Unmarshal([]byte(msg.Payload), &req) != nil {
+			continue
+		}
+		reply := func(payload map[string]interface{}) {
+			if id, ok := req["requestId"]; ok {
+				payload["requestId"] = id
+			}
+			f.write(conn, msg.DestinationID, msg.SourceID, msg.Namespace, payload)
+		}
+		typ, _ := req["type"].(string)
+
+		switch msg.Namespace {
+		case NamespaceConnection:
+			f.mu.Lock()
+			f.connected[msg.DestinationID] = typ == "CONNECT"
+			f.mu.Unlock()
+		case NamespaceHeartbeat:
+			if typ == "PING" {
+				reply(map[string]interface{}{"type": "PONG"})
+			}
+		case NamespaceReceiver:
+			switch typ {
+			case "LAUNCH":
+				if req["appId"] != DefaultMediaReceiver {
+					reply(map[string]interface{}{"type": "LAUNCH_ERROR", "reason": "NOT_FOUND"})
+					continue
+				}
+				f.mu.Lock()
+				f.appRunning = true
+				f.mu.Unlock()
+				reply(f.receiverStatus())
+			case "STOP":
+				f.mu.Lock()
+				f.appRunning, f.playerState, f.contentID, f.mediaID = false, "IDLE", "", 0
+				f.mu.Unlock()
+				reply(f.receiverStatus())
+			case "SET_VOLUME":
+				if v, ok := req["volume"].(map[string]interface{}); ok {
+					f.mu.Lock()
+					if level, ok := v["level"].(float64); ok {
+						f.volume.Level = level
+					}
+					if muted, ok := v["muted"].(bool); ok {
+						f.volume.Muted = muted
+					}
+					f.mu.Unlock()
+				}
+				reply(f.receiverStatus())
+			case "GET_STATUS":
+				reply(f.receiverStatus())
+			}
+		case NamespaceMedia:
+			f.handleMedia(typ, req, reply)
+		}
+	}
+}
+
+func (f *FakeReceiver) handleMedia(typ string, req map[string]interface{}, reply func(map[string]interface{})) {
+	f.mu.Lock()
+	if typ != "LOAD" && typ != "GET_STATUS" && (f.mediaID == 0 || req["mediaSessionId"] != float64(f.mediaID)) {
+		f.mu.Unlock()
+		reply(map[string]interface{}{"type": "INVALID_REQUEST", "reason": "INVALID_MEDIA_SESSION_ID"})
+		return
+	}
+	switch typ {
+	case "LOAD":
+		if !f.appRunning {
+			f.mu.Unlock()
+			reply(map[string]interface{}{"type": "LOAD_FAILED"})
+			return
+		}
+		media, _ := req["media"].(map[string]interface{})
+		f.mediaID++
+		f.contentID, _ = media["contentId"].(string)
+		f.duration, _ = media["duration"].(float64)
+		f.position, _ = req["currentTime"].(float64)
+		f.playerState, f.idleReason, f.resumedAt = "PLAYING", "", time.Now()
+	case "PLAY":
+		if f.playerState != "PLAYING" {
+			f.playerState, f.resumedAt = "PLAYING", time.Now()
+		}
+	case "PAUSE":
+		f.position = f.currentTime()
+		f.playerState = "PAUSED"
+	case "SEEK":
+		if t, ok := req["currentTime"].(float64); ok {
+			f.position, f.resumedAt = t, time.Now()
+		}
+	case "STOP":
+		f.position = f.currentTime()
+		f.playerState, f.idleReason = "IDLE", "CANCELLED"
+	}
+	status := f.mediaStatusLocked()
+	f.mu.Unlock()
+
+	reply(status)
+	if typ != "GET_STATUS" {
+		f.broadcastMediaStatus()
+	}
+}
+
+func (f *FakeReceiver) currentTime() float64 {
+	if f.playerState != "PLAYING" {
+		return f.position
+	}
+	t := f.position + time.Since(f.resumedAt).Seconds()
+	if f.duration > 0 && t > f.duration {
+		t = f.duration
+	}
+	return t
+}
+
+func (f *FakeReceiver) mediaStatusLocked() map[string]interface{} {
+	var statuses []map[string]interface{}
+	if f.mediaID != 0 {
+		st := map[string]interface{}{
+			"mediaSessionId": f.mediaID,
+			"playerState":    f.playerState,
+			"currentTime":    f.currentTime(),
+			"volume":         f.volume,
+			"media":          map[string]interface{}{"contentId": f.contentID, "duration": f.duration},
+		}
+		if f.idleReason != "" {
+			st["idleReason"] = f.idleReason
+		}
+		statuses = append(statuses, st)
+	}
+	return map[string]interface{}{"type": "MEDIA_STATUS", "status": statuses}
+}
+
+func (f *FakeReceiver) receiverStatus() map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var apps []application
+	if f.appRunning {
+		apps = append(apps, application{AppID: DefaultMediaReceiver, SessionID: "fake-session", TransportID: "web-1"})
+	}
+	return map[string]interface{}{
+		"type":   "RECEIVER_STATUS",
+		"status": map[string]interface{}{"applications": apps, "volume": f.volume},
+	}
+}
+
+// broadcastMediaStatus sends an unsolicited (requestId 0) MEDIA_STATUS to
+// every connected sender.
+func (f *FakeReceiver) broadcastMediaStatus() {
+	f.mu.Lock()
+	status := f.mediaStatusLocked()
+	conns := make([]net.Conn, 0, len(f.conns))
+	for c := range f.conns {
+		conns = append(conns, c)
+	}
+	f.mu.Unlock()
+	for _, c := range conns {
+		f.write(c, "web-1", "*", NamespaceMedia, status)
+	}
+}
+
+func (f *FakeReceiver) write(conn net.Conn, src, dst, namespace string, payload map[string]interface{}) {
+	f.mu.Lock()
+	wmu := f.conns[conn]
+	f.mu.Unlock()
+	if wmu == nil {
+		return
+	}
+	data, _ := json.Marshal(payload)
+	wmu.Lock()
+	defer wmu.Unlock()
+	writeMessage(conn, &Message{SourceID: src, DestinationID: dst, Namespace: namespace, Payload: string(data)})
+}
+
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fake-cast-receiver"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
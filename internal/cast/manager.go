@@ -0,0 +1,166 @@
+package cast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrNoSession is returned for commands on a cast session with no live
+// receiver connection.
+var ErrNoSession = errors.New("no receiver connected for this cast session")
+
+// statusPollInterval is how often a playing receiver is asked for its
+// status; receivers only push MEDIA_STATUS on state changes, not progress.
+const statusPollInterval = 10 * time.Second
+
+// Manager keeps one receiver connection per cast session and reports every
+// status the receiver sends.
+type Manager struct {
+	mu      sync.Mutex
+	clients map[uuid.UUID]*Client
+
+	onStatus func(sessionID uuid.UUID, st MediaStatus)
+	onClose  func(sessionID uuid.UUID, err error)
+}
+
+// NewManager creates a Manager. onClose receives a nil error when the session
+// was stopped deliberately.
+func NewManager(onStatus func(uuid.UUID, MediaStatus), onClose func(uuid.UUID, error)) *Manager {
+	return &Manager{
+		clients:  make(map[uuid.UUID]*Client),
+		onStatus: onStatus,
+		onClose:  onClose,
+	}
+}
+
+// Start connects to the receiver at addr, launches the Default Media Receiver
+// and loads the media. Any previous connection for the session is replaced.
+func (m *Manager) Start(ctx context.Context, sessionID uuid.UUID, addr string, req LoadRequest) (*MediaStatus, error) {
+	m.Stop(sessionID)
+
+	c, err := Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	c.OnMediaStatus = func(st MediaStatus) {
+		if m.onStatus != nil {
+			m.onStatus(sessionID, st)
+		}
+	}
+	c.OnClose = func(err error) {
+		m.mu.Lock()
+		if m.clients[sessionID] == c {
+			delete(m.clients, sessionID)
+		}
+		m.mu.Unlock()
+		if err != nil {
+			log.Printf("[cast] session %s: %v", sessionID, err)
+		}
+		if m.onClose != nil {
+			m.onClose(sessionID, err)
+		}
+	}
+
+	if err := c.Launch(ctx, DefaultMediaReceiver); err != nil {
+		c.Close()
+		return nil, err
+	}
+	st, err := c.Load(ctx, req)
+	if err != nil {
+		c.StopApp(context.Background())
+		c.Close()
+		return nil, fmt.Errorf("load media: %w", err)
+	}
+
+	m.mu.Lock()
+	m.clients[sessionID] = c
+	m.mu.Unlock()
+	go m.poll(c)
+	return st, nil
+}
+
+func (m *Manager) poll(c *Client) {
+	ticker := time.NewTicker(statusPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Done():
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			c.MediaStatus(ctx)
+			cancel()
+		}
+	}
+}
+
+// Connected reports whether the session has a live receiver connection.
+func (m *Manager) Connected(sessionID uuid.UUID) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.clients[sessionID] != nil
+}
+
+// Command sends play, pause, seek (value = seconds), volume (value = 0-1) or
+// stop to the session's receiver. stop also closes the media app and the
+// connection.
+func (m *Manager) Command(ctx context.Context, sessionID uuid.UUID, command string, value float64) (*MediaStatus, error) {
+	m.mu.Lock()
+	c := m.clients[sessionID]
+	m.mu.Unlock()
+	if c == nil {
+		return nil, ErrNoSession
+	}
+	switch command {
+	case "play":
+		return c.Play(ctx)
+	case "pause":
+		return c.Pause(ctx)
+	case "seek":
+		return c.Seek(ctx, value)
+	case "volume":
+		if err := c.SetVolume(ctx, value); err != nil {
+			return nil, err
+		}
+		return c.MediaStatus(ctx)
+	case "stop":
+		st, err := c.Stop(ctx)
+		m.Stop(sessionID)
+		return st, err
+	}
+	return nil, fmt.Errorf("unknown cast command %q", command)
+}
+
+// Stop closes the media app and disconnects from the receiver.
+func (m *Manager) Stop(sessionID uuid.UUID) {
+	m.mu.Lock()
+	c := m.clients[sessionID]
+	delete(m.clients, sessionID)
+	m.mu.Unlock()
+	if c == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c.StopApp(ctx)
+	c.Close()
+}
+
+// Shutdown disconnects every session.
+func (m *Manager) Shutdown() {
+	m.mu.Lock()
+	ids := make([]uuid.UUID, 0, len(m.clients))
+	for id := range m.clients {
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+	for _, id := range ids {
+		m.Stop(id)
+	}
+}
@@ -0,0 +1,118 @@
+package cast
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type closeRecorder struct {
+	mu     sync.Mutex
+	errs   []error
+	closed chan struct{}
+}
+
+func newCloseRecorder() *closeRecorder {
+	return &closeRecorder{closed: make(chan struct{}, 4)}
+}
+
+func (r *closeRecorder) onClose(_ uuid.UUID, err error) {
+	r.mu.Lock()
+	r.errs = append(r.errs, err)
+	r.mu.Unlock()
+	r.closed <- struct{}{}
+}
+
+func (r *closeRecorder) wait(t *testing.T) error {
+	t.Helper()
+	select {
+	case <-r.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("onClose not called")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.errs[len(r.errs)-1]
+}
+
+func TestManagerSession(t *testing.T) {
+	f := newFake(t)
+	ctx := testContext(t)
+	statuses := make(chan MediaStatus, 32)
+	closes := newCloseRecorder()
+	m := NewManager(func(_ uuid.UUID, st MediaStatus) { statuses <- st }, closes.onClose)
+	defer m.Shutdown()
+
+	session := uuid.New()
+	if _, err := m.Command(ctx, session, "play", 0); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("Command before Start: err = %v, want ErrNoSession", err)
+	}
+
+	st, err := m.Start(ctx, session, f.Addr(), LoadRequest{URL: "http://example/ep.mp4", Duration: 1200})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if st.PlayerState != "PLAYING" || !m.Connected(session) {
+		t.Fatalf("after Start: status %+v, connected %v", st, m.Connected(session))
+	}
+	waitStatus(t, statuses, func(st MediaStatus) bool { return st.PlayerState == "PLAYING" })
+
+	tests := []struct {
+		command string
+		value   float64
+		state   string
+	}{
+		{"pause", 0, "PAUSED"},
+		{"seek", 300, "PAUSED"},
+		{"play", 0, "PLAYING"},
+		{"volume", 0.25, "PLAYING"},
+	}
+	for _, tt := range tests {
+		st, err := m.Command(ctx, session, tt.command, tt.value)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.command, err)
+		}
+		if st.PlayerState != tt.state {
+			t.Errorf("%s: state = %s, want %s", tt.command, st.PlayerState, tt.state)
+		}
+	}
+	if _, _, pos, vol := f.State(); pos < 300 || vol.Level != 0.25 {
+		t.Errorf("receiver position %v, volume %v", pos, vol.Level)
+	}
+	if _, err := m.Command(ctx, session, "rewind", 0); err == nil {
+		t.Error("unknown command accepted")
+	}
+
+	if _, err := m.Command(ctx, session, "stop", 0); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if m.Connected(session) {
+		t.Error("session still connected after stop")
+	}
+	if err := closes.wait(t); err != nil {
+		t.Errorf("onClose after stop: err = %v, want nil", err)
+	}
+}
+
+func TestManagerReceiverGone(t *testing.T) {
+	f := newFake(t)
+	ctx := testContext(t)
+	closes := newCloseRecorder()
+	m := NewManager(nil, closes.onClose)
+	defer m.Shutdown()
+
+	session := uuid.New()
+	if _, err := m.Start(ctx, session, f.Addr(), LoadRequest{URL: "http://example/ep.mp4"}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := closes.wait(t); err == nil {
+		t.Error("onClose after the receiver dropped: err = nil")
+	}
+	if m.Connected(session) {
+		t.Error("session still connected after the receiver dropped")
+	}
+}
@@ -0,0 +1,137 @@
+// Package cast implements a Chromecast (CASTV2) sender: the TLS +
+// protobuf framing, the receiver/media control namespaces and mDNS
+// discovery of _googlecast._tcp devices.
+package cast
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	NamespaceConnection = "urn:x-cast:com.google.cast.tp.connection"
+	NamespaceHeartbeat  = "urn:x-cast:com.google.cast.tp.heartbeat"
+	NamespaceReceiver   = "urn:x-cast:com.google.cast.receiver"
+	NamespaceMedia      = "urn:x-cast:com.google.cast.media"
+
+	// DefaultMediaReceiver is Google's stock receiver app for plain media URLs.
+	DefaultMediaReceiver = "CC1AD845"
+
+	defaultSender   = "sender-0"
+	defaultReceiver = "receiver-0"
+
+	// Receivers reject frames above 64 KiB
+	maxMessageSize = 64 * 1024
+)
+
+// Message is a CastMessage (cast_channel.proto). Only string payloads are
+// used by the namespaces implemented here.
+type Message struct {
+	SourceID      string
+	DestinationID string
+	Namespace     string
+	Payload       string
+}
+
+// CastMessage field numbers
+const (
+	fieldProtocolVersion protowire.Number = 1
+	fieldSourceID        protowire.Number = 2
+	fieldDestinationID   protowire.Number = 3
+	fieldNamespace       protowire.Number = 4
+	fieldPayloadType     protowire.Number = 5
+	fieldPayloadUTF8     protowire.Number = 6
+	fieldPayloadBinary   protowire.Number = 7
+)
+
+// Marshal encodes the message as a CastMessage protobuf (CASTV2_1_0, STRING payload).
+func (m *Message) Marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, fieldProtocolVersion, protowire.VarintType)
+	b = protowire.AppendVarint(b, 0)
+	b = protowire.AppendTag(b, fieldSourceID, protowire.BytesType)
+	b = protowire.AppendString(b, m.SourceID)
+	b = protowire.AppendTag(b, fieldDestinationID, protowire.BytesType)
+	b = protowire.AppendString(b, m.DestinationID)
+	b = protowire.AppendTag(b, fieldNamespace, protowire.BytesType)
+	b = protowire.AppendString(b, m.Namespace)
+	b = protowire.AppendTag(b, fieldPayloadType, protowire.VarintType)
+	b = protowire.AppendVarint(b, 0)
+	b = protowire.AppendTag(b, fieldPayloadUTF8, protowire.BytesType)
+	b = protowire.AppendString(b, m.Payload)
+	return b
+}
+
+// Unmarshal decodes a CastMessage protobuf. Binary payloads are kept as text.
+func (m *Message) Unmarshal(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case typ == protowire.BytesType && num >= fieldSourceID && num <= fieldPayloadBinary:
+			v, n := protowire.ConsumeString(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			switch num {
+			case fieldSourceID:
+				m.SourceID = v
+			case fieldDestinationID:
+				m.DestinationID = v
+			case fieldNamespace:
+				m.Namespace = v
+			case fieldPayloadUTF8, fieldPayloadBinary:
+				m.Payload = v
+			}
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+// writeMessage writes one length-prefixed frame.
+func writeMessage(w io.Writer, m *Message) error {
+	body := m.Marshal()
+	if len(body) > maxMessageSize {
+		return fmt.Errorf("cast message too large (%d bytes)", len(body))
+	}
+	frame := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(frame, uint32(len(body)))
+	copy(frame[4:], body)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readMessage reads one length-prefixed frame.
+func readMessage(r io.Reader) (*Message, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(hdr[:])
+	if size > maxMessageSize {
+		return nil, errors.New("cast frame exceeds 64 KiB")
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	m := &Message{}
+	if err := m.Unmarshal(body); err != nil {
+		return nil, fmt.Errorf("decode cast message: %w", err)
+	}
+	return m, nil
+}
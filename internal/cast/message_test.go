@@ -0,0 +1,60 @@
+package cast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	tests := []Message{
+		{SourceID: defaultSender, DestinationID: defaultReceiver, Namespace: NamespaceConnection, Payload: `{"type":"CONNECT"}`},
+		{SourceID: "web-1", DestinationID: "*", Namespace: NamespaceMedia, Payload: `{"type":"MEDIA_STATUS","status":[]}`},
+		{SourceID: "sender-0", DestinationID: "web-1", Namespace: NamespaceMedia, Payload: ""},
+		{SourceID: "a", DestinationID: "b", Namespace: "urn:x-cast:test", Payload: "ünïcödé " + strings.Repeat("x", 300)},
+	}
+	for _, want := range tests {
+		var got Message
+		if err := got.Unmarshal(want.Marshal()); err != nil {
+			t.Fatalf("Unmarshal(%+v): %v", want, err)
+		}
+		if got != want {
+			t.Errorf("round trip = %+v, want %+v", got, want)
+		}
+
+		var buf bytes.Buffer
+		if err := writeMessage(&buf, &want); err != nil {
+			t.Fatalf("writeMessage: %v", err)
+		}
+		framed, err := readMessage(&buf)
+		if err != nil {
+			t.Fatalf("readMessage: %v", err)
+		}
+		if *framed != want {
+			t.Errorf("framed round trip = %+v, want %+v", *framed, want)
+		}
+	}
+}
+
+func TestMessageUnmarshalTruncated(t *testing.T) {
+	body := (&Message{SourceID: "sender-0", Namespace: NamespaceMedia, Payload: `{"type":"PLAY"}`}).Marshal()
+	var m Message
+	if err := m.Unmarshal(body[:len(body)-3]); err == nil {
+		t.Error("Unmarshal of a truncated message succeeded")
+	}
+}
+
+func TestOversizeFrames(t *testing.T) {
+	big := &Message{Namespace: NamespaceMedia, Payload: strings.Repeat("x", maxMessageSize)}
+	if err := writeMessage(&bytes.Buffer{}, big); err == nil {
+		t.Error("writeMessage accepted a frame over 64 KiB")
+	}
+
+	// A header announcing an oversize frame is rejected before the body is read
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], maxMessageSize+1)
+	if _, err := readMessage(bytes.NewReader(hdr[:])); err == nil || !strings.Contains(err.Error(), "64 KiB") {
+		t.Errorf("readMessage of oversize frame: err = %v", err)
+	}
+}
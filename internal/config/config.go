@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
@@ -13,6 +14,9 @@ type Config struct {
 	JWT        JWTConfig
 	Paths      PathsConfig
 	FFmpeg     FFmpegConfig
+	Optimized  OptimizedConfig
+	Watcher    WatcherConfig
+	Scanner    ScannerConfig
 	TMDBAPIKey string
 }
 
@@ -34,6 +38,9 @@ type RedisConfig struct {
 type ServerConfig struct {
 	Host string
 	Port int
+	// Reverse proxies (addresses or CIDRs) whose X-Forwarded-For and
+	// X-Real-IP headers are believed
+	TrustedProxies []string
 }
 
 type JWTConfig struct {
@@ -49,9 +56,37 @@ type PathsConfig struct {
 }
 
 type FFmpegConfig struct {
-	FFmpegPath  string
-	FFprobePath string
-	HWAccel     string
+	FFmpegPath    string
+	FFprobePath   string
+	HWAccel       string
+	MaxTranscodes int // concurrent VOD transcodes; 0 = unlimited
+	// Transcode throttling: segments ffmpeg may run ahead of the client
+	// (0 = off) and the disk quota for transcoded segments (0 = none)
+	ThrottleSegments int
+	DiskQuotaMB      int
+	AudioCacheMB     int // converted music files kept for replay; 0 = unlimited
+}
+
+// OptimizedConfig limits optimized versions (pre-transcoded copies).
+type OptimizedConfig struct {
+	QuotaGB       int // total size of all versions; 0 = unlimited
+	MaxPerUser    int // versions a non-admin may have queued or ready; 0 = unlimited
+	RetentionDays int // remove versions not played or downloaded for this long; 0 = keep
+}
+
+// WatcherConfig paces the polling watcher used by libraries on network
+// mounts.
+type WatcherConfig struct {
+	PollIntervalSec int // seconds between snapshots of a library folder
+	PollRate        int // files and folders stat'ed per second; 0 = unlimited
+}
+
+// ScannerConfig is the default concurrency of a library scan; libraries
+// can override each limit.
+type ScannerConfig struct {
+	ProbeWorkers    int // files probed (ffprobe, hashing) at once
+	CPUWorkers      int // files parsed and written to the database at once; 0 = one per CPU
+	MetadataWorkers int // remote metadata lookups at once
 }
 
 func Load() (*Config, error) {
@@ -72,6 +107,8 @@ func Load() (*Config, error) {
 		Server: ServerConfig{
 			Host: getEnv("SERVER_HOST", "0.0.0.0"),
 			Port: getEnvInt("SERVER_PORT", 8080),
+
+			TrustedProxies: getEnvList("TRUSTED_PROXIES"),
 		},
 		JWT: JWTConfig{
 			Secret:           getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
@@ -84,9 +121,28 @@ func Load() (*Config, error) {
 			Thumbnail: getEnv("THUMBNAIL_PATH", "/thumbnails"),
 		},
 		FFmpeg: FFmpegConfig{
-			FFmpegPath:  getEnv("FFMPEG_PATH", "/usr/lib/jellyfin-ffmpeg/ffmpeg"),
-			FFprobePath: getEnv("FFPROBE_PATH", "/usr/lib/jellyfin-ffmpeg/ffprobe"),
-			HWAccel:     getEnv("FFMPEG_HWACCEL", "auto"),
+			FFmpegPath:    getEnv("FFMPEG_PATH", "/usr/lib/jellyfin-ffmpeg/ffmpeg"),
+			FFprobePath:   getEnv("FFPROBE_PATH", "/usr/lib/jellyfin-ffmpeg/ffprobe"),
+			HWAccel:       getEnv("FFMPEG_HWACCEL", "auto"),
+			MaxTranscodes: getEnvInt("FFMPEG_MAX_TRANSCODES", 0),
+
+			ThrottleSegments: getEnvInt("TRANSCODE_THROTTLE_SEGMENTS", 20),
+			DiskQuotaMB:      getEnvInt("TRANSCODE_DISK_QUOTA_MB", 10240),
+			AudioCacheMB:     getEnvInt("AUDIO_CACHE_MB", 2048),
+		},
+		Optimized: OptimizedConfig{
+			QuotaGB:       getEnvInt("OPTIMIZED_QUOTA_GB", 200),
+			MaxPerUser:    getEnvInt("OPTIMIZED_MAX_PER_USER", 50),
+			RetentionDays: getEnvInt("OPTIMIZED_RETENTION_DAYS", 30),
+		},
+		Watcher: WatcherConfig{
+			PollIntervalSec: getEnvInt("WATCH_POLL_INTERVAL", 60),
+			PollRate:        getEnvInt("WATCH_POLL_RATE", 2000),
+		},
+		Scanner: ScannerConfig{
+			ProbeWorkers:    getEnvInt("SCAN_PROBE_WORKERS", 4),
+			CPUWorkers:      getEnvInt("SCAN_CPU_WORKERS", 0),
+			MetadataWorkers: getEnvInt("SCAN_METADATA_WORKERS", 5),
 		},
 		TMDBAPIKey: getEnv("TMDB_API_KEY", "ca12f0b4ddc375cc34dd9ae9e4fe94e0"),
 	}, nil
@@ -120,3 +176,13 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvList(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
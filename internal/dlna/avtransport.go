@@ -0,0 +1,244 @@
+package dlna
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var soapClient = &http.Client{Timeout: 10 * time.Second}
+
+// Transport states reported by GetTransportInfo.
+const (
+	StatePlaying       = "PLAYING"
+	StatePaused        = "PAUSED_PLAYBACK"
+	StateStopped       = "STOPPED"
+	StateTransitioning = "TRANSITIONING"
+	StateNoMedia       = "NO_MEDIA_PRESENT"
+)
+
+// PositionInfo is the result of AVTransport GetPositionInfo, in seconds.
+type PositionInfo struct {
+	TrackURI string
+	Duration float64
+	Position float64
+}
+
+// UPnPError is a SOAP fault returned by a renderer.
+type UPnPError struct {
+	Code        int
+	Description string
+}
+
+func (e *UPnPError) Error() string {
+	return fmt.Sprintf("UPnP error %d: %s", e.Code, e.Description)
+}
+
+// SetAVTransportURI loads a URL on the renderer. Many TVs refuse URIs
+// without DIDL-Lite metadata, so item describes the media.
+func (rd *Renderer) SetAVTransportURI(ctx context.Context, uri string, item DIDLItem) error {
+	item.URL = uri
+	if item.ID == "" {
+		item.ID, item.ParentID = "0", "-1"
+	}
+	_, err := rd.avTransport(ctx, "SetAVTransportURI", [][2]string{
+		{"InstanceID", "0"},
+		{"CurrentURI", uri},
+		{"CurrentURIMetaData", itemDIDL([]DIDLItem{item})},
+	})
+	return err
+}
+
+func (rd *Renderer) Play(ctx context.Context) error {
+	_, err := rd.avTransport(ctx, "Play", [][2]string{{"InstanceID", "0"}, {"Speed", "1"}})
+	return err
+}
+
+func (rd *Renderer) Pause(ctx context.Context) error {
+	_, err := rd.avTransport(ctx, "Pause", [][2]string{{"InstanceID", "0"}})
+	return err
+}
+
+func (rd *Renderer) Stop(ctx context.Context) error {
+	_, err := rd.avTransport(ctx, "Stop", [][2]string{{"InstanceID", "0"}})
+	return err
+}
+
+// Seek jumps to an absolute position in the current track.
+func (rd *Renderer) Seek(ctx context.Context, seconds float64) error {
+	_, err := rd.avTransport(ctx, "Seek", [][2]string{
+		{"InstanceID", "0"},
+		{"Unit", "REL_TIME"},
+		{"Target", FormatDuration(seconds)},
+	})
+	return err
+}
+
+// TransportState returns the renderer's CurrentTransportState.
+func (rd *Renderer) TransportState(ctx context.Context) (string, error) {
+	out, err := rd.avTransport(ctx, "GetTransportInfo", [][2]string{{"InstanceID", "0"}})
+	if err != nil {
+		return "", err
+	}
+	return out["CurrentTransportState"], nil
+}
+
+func (rd *Renderer) PositionInfo(ctx context.Context) (*PositionInfo, error) {
+	out, err := rd.avTransport(ctx, "GetPositionInfo", [][2]string{{"InstanceID", "0"}})
+	if err != nil {
+		return nil, err
+	}
+	return &PositionInfo{
+		TrackURI: out["TrackURI"],
+		Duration: ParseDuration(out["TrackDuration"]),
+		Position: ParseDuration(out["RelTime"]),
+	}, nil
+}
+
+// SetVolume sets the master volume, 0-100.
+func (rd *Renderer) SetVolume(ctx context.Context, volume int) error {
+	if rd.renderingURL == "" {
+		return errors.New("renderer has no RenderingControl service")
+	}
+	if volume < 0 {
+		volume = 0
+	} else if volume > 100 {
+		volume = 100
+	}
+	_, err := invokeSOAP(ctx, rd.renderingURL, rd.renderingType, "SetVolume", [][2]string{
+		{"InstanceID", "0"},
+		{"Channel", "Master"},
+		{"DesiredVolume", strconv.Itoa(volume)},
+	})
+	return err
+}
+
+func (rd *Renderer) avTransport(ctx context.Context, action string, args [][2]string) (map[string]string, error) {
+	return invokeSOAP(ctx, rd.avTransportURL, rd.avTransportType, action, args)
+}
+
+// invokeSOAP calls a UPnP action and returns the out arguments by name.
+// Arguments are sent in order; some renderers reject them otherwise.
+func invokeSOAP(ctx context.Context, controlURL, serviceType, action string, args [][2]string) (map[string]string, error) {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	sb.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`)
+	sb.WriteString(`<s:Body>`)
+	sb.WriteString(fmt.Sprintf(`<u:%s xmlns:u="%s">`, action, serviceType))
+	for _, a := range args {
+		sb.WriteString(fmt.Sprintf(`<%s>%s</%s>`, a[0], xmlEscape(a[1]), a[0]))
+	}
+	sb.WriteString(fmt.Sprintf(`</u:%s>`, action))
+	sb.WriteString(`</s:Body></s:Envelope>`)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, controlURL, bytes.NewBufferString(sb.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, serviceType, action))
+	req.Header.Set("User-Agent", serverHeader)
+	resp, err := soapClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", action, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, descriptionMaxBytes))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", action, err)
+	}
+
+	out, fault := parseSOAPResponse(body)
+	if fault != nil {
+		return nil, fmt.Errorf("%s: %w", action, fault)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: HTTP %d", action, resp.StatusCode)
+	}
+	return out, nil
+}
+
+// parseSOAPResponse collects the child elements of the action response, or
+// the UPnPError detail of a fault.
+func parseSOAPResponse(body []byte) (map[string]string, *UPnPError) {
+	out := make(map[string]string)
+	var fault *UPnPError
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	depth, respDepth := 0, -1
+	var field string
+	var text strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case t.Name.Local == "Fault":
+				fault = &UPnPError{}
+			case respDepth < 0 && strings.HasSuffix(t.Name.Local, "Response"):
+				respDepth = depth
+			case depth == respDepth+1 || fault != nil:
+				field = t.Name.Local
+				text.Reset()
+			}
+		case xml.CharData:
+			if field != "" {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if field != "" && t.Name.Local == field {
+				v := strings.TrimSpace(text.String())
+				if fault != nil {
+					switch field {
+					case "errorCode":
+						fault.Code, _ = strconv.Atoi(v)
+					case "errorDescription":
+						fault.Description = v
+					}
+				} else {
+					out[field] = v
+				}
+				field = ""
+			}
+			depth--
+		}
+	}
+	return out, fault
+}
+
+// FormatDuration renders seconds as H:MM:SS for Seek targets and DIDL
+// duration attributes.
+func FormatDuration(seconds float64) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+	s := int(math.Round(seconds))
+	return fmt.Sprintf("%d:%02d:%02d", s/3600, (s/60)%60, s%60)
+}
+
+// ParseDuration reads H+:MM:SS[.F+] and returns seconds; renderers report
+// NOT_IMPLEMENTED or an empty string when they don't know.
+func ParseDuration(v string) float64 {
+	parts := strings.Split(strings.TrimSpace(v), ":")
+	if len(parts) != 3 {
+		return 0
+	}
+	h, err1 := strconv.Atoi(parts[0])
+	m, err2 := strconv.Atoi(parts[1])
+	s, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0
+	}
+	return float64(h*3600+m*60) + s
+}
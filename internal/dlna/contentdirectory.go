@@ -3,7 +3,10 @@ package dlna
 import (
 	"encoding/xml"
 	"fmt"
+	"html"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/google/uuid"
@@ -21,6 +24,19 @@ type DIDLItem struct {
 	Resolution string
 	Bitrate    int
 	Size       int64
+	Creator    string
+	Album      string
+	Genre      string
+	Date       string // YYYY or YYYY-MM-DD
+
+	// Source format, used by renderer profiles to pick direct/remux/transcode
+	Container  string
+	VideoCodec string
+	AudioCodec string
+	Height     int
+
+	// ProtocolInfo overrides the default res@protocolInfo when set
+	ProtocolInfo string
 }
 
 // DIDLContainer represents a DLNA container (folder/library).
@@ -34,16 +50,29 @@ type DIDLContainer struct {
 // MediaProvider is the interface the ContentDirectory uses to query media.
 type MediaProvider interface {
 	GetLibraries() ([]DIDLContainer, error)
-	GetLibraryItems(libraryID string) ([]DIDLItem, error)
+	// GetLibraryItems returns one page of a library's items plus the total
+	// number of items in it. limit 0 means no limit.
+	GetLibraryItems(libraryID string, offset, limit int) ([]DIDLItem, int, error)
+	GetItem(id string) (*DIDLItem, error)
+	// SearchItems pages through the items under containerID ("0" for all
+	// libraries) that match criteria.
+	SearchItems(containerID string, criteria *SearchCriteria, offset, limit int) ([]DIDLItem, int, error)
+	// UpdateID changes whenever the container's contents change; "0" is the
+	// SystemUpdateID for the whole server.
+	UpdateID(containerID string) uint32
 }
 
+// MediaPath is where item res URLs point: MediaPath + id + "?mode=" + Delivery.
+const MediaPath = "/dlna/media/"
+
 // ContentDirectoryService implements UPnP ContentDirectory:1.
 type ContentDirectoryService struct {
 	serverAddr string
 	provider   MediaProvider
 }
 
-// NewContentDirectoryService creates a new ContentDirectory service.
+// NewContentDirectoryService creates a new ContentDirectory service. With
+// an empty serverAddr, res URLs use the Host the renderer connected to.
 func NewContentDirectoryService(serverAddr string, provider MediaProvider) *ContentDirectoryService {
 	return &ContentDirectoryService{
 		serverAddr: strings.TrimRight(serverAddr, "/"),
@@ -61,6 +90,12 @@ func (cd *ContentDirectoryService) HandleControl(w http.ResponseWriter, r *http.
 		cd.handleBrowse(w, r)
 	case strings.HasSuffix(soapAction, "#GetSystemUpdateID"):
 		cd.handleGetSystemUpdateID(w, r)
+	case strings.HasSuffix(soapAction, "#GetSearchCapabilities"):
+		cd.soapResponse(w, "GetSearchCapabilities", [][2]string{
+			{"SearchCaps", "dc:title,upnp:class,dc:creator,upnp:artist,upnp:album,upnp:genre,dc:date,@id,@parentID,res@duration,res@size"},
+		})
+	case strings.HasSuffix(soapAction, "#GetSortCapabilities"):
+		cd.soapResponse(w, "GetSortCapabilities", [][2]string{{"SortCaps", ""}})
 	case strings.HasSuffix(soapAction, "#Search"):
 		cd.handleSearch(w, r)
 	default:
@@ -68,64 +103,156 @@ func (cd *ContentDirectoryService) HandleControl(w http.ResponseWriter, r *http.
 	}
 }
 
+// pageArgs reads StartingIndex/RequestedCount; RequestedCount 0 means all.
+func pageArgs(body string) (start, count int) {
+	start, _ = strconv.Atoi(strings.TrimSpace(soapArg(body, "StartingIndex")))
+	count, _ = strconv.Atoi(strings.TrimSpace(soapArg(body, "RequestedCount")))
+	if start < 0 {
+		start = 0
+	}
+	if count < 0 {
+		count = 0
+	}
+	return start, count
+}
+
 func (cd *ContentDirectoryService) handleBrowse(w http.ResponseWriter, r *http.Request) {
 	// Parse SOAP envelope to get ObjectID
 	objectID := "0" // Root
-	if body, err := parseSOAPBody(r); err == nil {
-		if oid := extractXMLTag(body, "ObjectID"); oid != "" {
-			objectID = oid
-		}
+	body, _ := parseSOAPBody(r)
+	if oid := soapArg(body, "ObjectID"); oid != "" {
+		objectID = oid
 	}
+	start, count := pageArgs(body)
+	profile := MatchProfile(r.Header)
+	base := cd.baseURL(r)
 
 	var result string
-	var count, total int
+	var returned, total int
 
-	if objectID == "0" {
+	if soapArg(body, "BrowseFlag") == "BrowseMetadata" {
+		switch objectID {
+		case "0":
+			containers, _ := cd.provider.GetLibraries()
+			result = cd.buildContainerDIDL([]DIDLContainer{{ID: "0", ParentID: "-1", Title: "CineVault", Count: len(containers)}})
+		default:
+			if c, ok := cd.findLibrary(objectID); ok {
+				result = cd.buildContainerDIDL([]DIDLContainer{c})
+			} else if item, err := cd.provider.GetItem(objectID); err == nil && item != nil {
+				result = cd.buildItemDIDL(cd.resolve([]DIDLItem{*item}, profile, base))
+			} else {
+				cd.soapError(w, 701, "No such object")
+				return
+			}
+		}
+		returned, total = 1, 1
+	} else if objectID == "0" {
 		// Root: return libraries as containers
 		containers, err := cd.provider.GetLibraries()
 		if err != nil {
 			cd.soapError(w, 501, "Action Failed")
 			return
 		}
+		total = len(containers)
+		containers = pageSlice(containers, start, count)
 		result = cd.buildContainerDIDL(containers)
-		count = len(containers)
-		total = count
+		returned = len(containers)
 	} else {
 		// Library items
-		items, err := cd.provider.GetLibraryItems(objectID)
+		items, n, err := cd.provider.GetLibraryItems(objectID, start, count)
 		if err != nil {
-			cd.soapError(w, 501, "Action Failed")
+			cd.soapError(w, 701, "No such object")
 			return
 		}
-		result = cd.buildItemDIDL(items)
-		count = len(items)
-		total = count
+		result = cd.buildItemDIDL(cd.resolve(items, profile, base))
+		returned, total = len(items), n
 	}
 
-	cd.soapResponse(w, "Browse", map[string]string{
-		"Result":         result,
-		"NumberReturned": fmt.Sprintf("%d", count),
-		"TotalMatches":   fmt.Sprintf("%d", total),
-		"UpdateID":       "1",
+	cd.soapResponse(w, "Browse", [][2]string{
+		{"Result", result},
+		{"NumberReturned", fmt.Sprintf("%d", returned)},
+		{"TotalMatches", fmt.Sprintf("%d", total)},
+		{"UpdateID", fmt.Sprintf("%d", cd.provider.UpdateID(objectID))},
 	})
 }
 
+func (cd *ContentDirectoryService) findLibrary(id string) (DIDLContainer, bool) {
+	containers, err := cd.provider.GetLibraries()
+	if err != nil {
+		return DIDLContainer{}, false
+	}
+	for _, c := range containers {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return DIDLContainer{}, false
+}
+
+func pageSlice[T any](list []T, start, count int) []T {
+	if start >= len(list) {
+		return nil
+	}
+	list = list[start:]
+	if count > 0 && count < len(list) {
+		list = list[:count]
+	}
+	return list
+}
+
 func (cd *ContentDirectoryService) handleGetSystemUpdateID(w http.ResponseWriter, r *http.Request) {
-	cd.soapResponse(w, "GetSystemUpdateID", map[string]string{
-		"Id": "1",
+	cd.soapResponse(w, "GetSystemUpdateID", [][2]string{
+		{"Id", fmt.Sprintf("%d", cd.provider.UpdateID("0"))},
 	})
 }
 
 func (cd *ContentDirectoryService) handleSearch(w http.ResponseWriter, r *http.Request) {
-	// Minimal search implementation — return empty
-	cd.soapResponse(w, "Search", map[string]string{
-		"Result":         `<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/"></DIDL-Lite>`,
-		"NumberReturned": "0",
-		"TotalMatches":   "0",
-		"UpdateID":       "1",
+	body, _ := parseSOAPBody(r)
+	containerID := soapArg(body, "ContainerID")
+	if containerID == "" {
+		containerID = "0"
+	}
+	criteria, err := ParseSearchCriteria(soapArg(body, "SearchCriteria"))
+	if err != nil {
+		cd.soapError(w, 708, "Unsupported or invalid search criteria")
+		return
+	}
+	start, count := pageArgs(body)
+	items, total, err := cd.provider.SearchItems(containerID, criteria, start, count)
+	if err != nil {
+		cd.soapError(w, 710, "No such container")
+		return
+	}
+	cd.soapResponse(w, "Search", [][2]string{
+		{"Result", cd.buildItemDIDL(cd.resolve(items, MatchProfile(r.Header), cd.baseURL(r)))},
+		{"NumberReturned", fmt.Sprintf("%d", len(items))},
+		{"TotalMatches", fmt.Sprintf("%d", total)},
+		{"UpdateID", fmt.Sprintf("%d", cd.provider.UpdateID(containerID))},
 	})
 }
 
+func (cd *ContentDirectoryService) baseURL(r *http.Request) string {
+	if cd.serverAddr != "" {
+		return cd.serverAddr
+	}
+	return "http://" + r.Host
+}
+
+// resolve points each item at the stream the renderer's profile can play.
+func (cd *ContentDirectoryService) resolve(items []DIDLItem, profile *RendererProfile, base string) []DIDLItem {
+	for i := range items {
+		item := &items[i]
+		d := profile.Choose(item)
+		if d != DeliveryDirect {
+			item.MimeType = "video/mp2t"
+			item.Size = 0
+		}
+		item.URL = base + MediaPath + item.ID + "?mode=" + string(d)
+		item.ProtocolInfo = profile.ProtocolInfo(d, item.MimeType)
+	}
+	return items
+}
+
 func (cd *ContentDirectoryService) buildContainerDIDL(containers []DIDLContainer) string {
 	var sb strings.Builder
 	sb.WriteString(`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/">`)
@@ -138,6 +265,11 @@ func (cd *ContentDirectoryService) buildContainerDIDL(containers []DIDLContainer
 }
 
 func (cd *ContentDirectoryService) buildItemDIDL(items []DIDLItem) string {
+	return itemDIDL(items)
+}
+
+// itemDIDL renders items as DIDL-Lite; also used as SetAVTransportURI metadata.
+func itemDIDL(items []DIDLItem) string {
 	var sb strings.Builder
 	sb.WriteString(`<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/" xmlns:dlna="urn:schemas-dlna-org:metadata-1-0/">`)
 	for _, item := range items {
@@ -147,8 +279,24 @@ func (cd *ContentDirectoryService) buildItemDIDL(items []DIDLItem) string {
 		}
 		sb.WriteString(fmt.Sprintf(`<item id="%s" parentID="%s" restricted="true"><dc:title>%s</dc:title><upnp:class>%s</upnp:class>`,
 			xmlEscape(item.ID), xmlEscape(item.ParentID), xmlEscape(item.Title), class))
+		if item.Creator != "" {
+			sb.WriteString(fmt.Sprintf(`<dc:creator>%s</dc:creator><upnp:artist>%s</upnp:artist>`, xmlEscape(item.Creator), xmlEscape(item.Creator)))
+		}
+		if item.Album != "" {
+			sb.WriteString(fmt.Sprintf(`<upnp:album>%s</upnp:album>`, xmlEscape(item.Album)))
+		}
+		if item.Genre != "" {
+			sb.WriteString(fmt.Sprintf(`<upnp:genre>%s</upnp:genre>`, xmlEscape(item.Genre)))
+		}
+		if item.Date != "" {
+			sb.WriteString(fmt.Sprintf(`<dc:date>%s</dc:date>`, xmlEscape(item.Date)))
+		}
 		// Resource element
-		sb.WriteString(fmt.Sprintf(`<res protocolInfo="http-get:*:%s:DLNA.ORG_OP=01;DLNA.ORG_CI=0"`, item.MimeType))
+		protocolInfo := item.ProtocolInfo
+		if protocolInfo == "" {
+			protocolInfo = fmt.Sprintf("http-get:*:%s:DLNA.ORG_OP=01;DLNA.ORG_CI=0", item.MimeType)
+		}
+		sb.WriteString(fmt.Sprintf(`<res protocolInfo="%s"`, xmlEscape(protocolInfo)))
 		if item.Duration != "" {
 			sb.WriteString(fmt.Sprintf(` duration="%s"`, item.Duration))
 		}
@@ -171,15 +319,17 @@ func (cd *ContentDirectoryService) HandleSCPD(w http.ResponseWriter, r *http.Req
 	w.Write([]byte(contentDirectorySCPD))
 }
 
-func (cd *ContentDirectoryService) soapResponse(w http.ResponseWriter, action string, params map[string]string) {
+// soapResponse writes the out arguments in order; strict renderers expect
+// them in the order the SCPD declares.
+func (cd *ContentDirectoryService) soapResponse(w http.ResponseWriter, action string, params [][2]string) {
 	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
 	var sb strings.Builder
 	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
 	sb.WriteString(`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">`)
 	sb.WriteString(`<s:Body>`)
 	sb.WriteString(fmt.Sprintf(`<u:%sResponse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1">`, action))
-	for k, v := range params {
-		sb.WriteString(fmt.Sprintf(`<%s>%s</%s>`, k, xmlEscape(v), k))
+	for _, p := range params {
+		sb.WriteString(fmt.Sprintf(`<%s>%s</%s>`, p[0], xmlEscape(p[1]), p[0]))
 	}
 	sb.WriteString(fmt.Sprintf(`</u:%sResponse>`, action))
 	sb.WriteString(`</s:Body></s:Envelope>`)
@@ -204,12 +354,16 @@ func xmlEscape(s string) string {
 }
 
 func parseSOAPBody(r *http.Request) (string, error) {
-	buf := make([]byte, 8192)
-	n, err := r.Body.Read(buf)
-	if err != nil && n == 0 {
+	buf, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil && len(buf) == 0 {
 		return "", err
 	}
-	return string(buf[:n]), nil
+	return string(buf), nil
+}
+
+// soapArg returns an action argument with XML entities decoded.
+func soapArg(body, tag string) string {
+	return html.UnescapeString(extractXMLTag(body, tag))
 }
 
 func extractXMLTag(body, tag string) string {
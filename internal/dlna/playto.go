@@ -0,0 +1,230 @@
+package dlna
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrNoPlayback is returned for commands on a session that isn't playing on
+// a renderer.
+var ErrNoPlayback = errors.New("no renderer playback for this session")
+
+const (
+	playToPollInterval = 5 * time.Second
+	playToMaxFailures  = 3
+)
+
+// PlaybackStatus is what a renderer reports on each poll.
+type PlaybackStatus struct {
+	State    string // transport state, see StatePlaying etc.
+	Position float64
+	Duration float64
+}
+
+// PlayTo pushes media to DLNA renderers ("Play To") and polls them for
+// position, keyed by cast session.
+type PlayTo struct {
+	mu       sync.Mutex
+	sessions map[uuid.UUID]*playback
+
+	onStatus func(sessionID uuid.UUID, st PlaybackStatus)
+	onClose  func(sessionID uuid.UUID, err error)
+}
+
+type playback struct {
+	renderer *Renderer
+	seekTo   float64 // applied once the renderer starts playing
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func (pb *playback) stop() {
+	pb.stopOnce.Do(func() { close(pb.done) })
+}
+
+// NewPlayTo creates a PlayTo controller. onClose is called when playback
+// ends on the renderer, with an error if the renderer stopped responding;
+// it is not called for Stop.
+func NewPlayTo(onStatus func(uuid.UUID, PlaybackStatus), onClose func(uuid.UUID, error)) *PlayTo {
+	return &PlayTo{
+		sessions: make(map[uuid.UUID]*playback),
+		onStatus: onStatus,
+		onClose:  onClose,
+	}
+}
+
+// Start loads uri on the renderer described at location and plays it,
+// seeking to startAt once playback begins.
+func (p *PlayTo) Start(ctx context.Context, sessionID uuid.UUID, location, uri string, item DIDLItem, startAt float64) error {
+	p.Stop(sessionID)
+
+	rd, err := LoadRenderer(ctx, location)
+	if err != nil {
+		return err
+	}
+	// Some renderers refuse a new URI while something is playing
+	if state, err := rd.TransportState(ctx); err == nil && (state == StatePlaying || state == StatePaused) {
+		rd.Stop(ctx)
+	}
+	if err := rd.SetAVTransportURI(ctx, uri, item); err != nil {
+		return err
+	}
+	if err := rd.Play(ctx); err != nil {
+		return err
+	}
+
+	pb := &playback{renderer: rd, seekTo: startAt, done: make(chan struct{})}
+	p.mu.Lock()
+	p.sessions[sessionID] = pb
+	p.mu.Unlock()
+	go p.poll(sessionID, pb)
+	return nil
+}
+
+func (p *PlayTo) poll(sessionID uuid.UUID, pb *playback) {
+	ticker := time.NewTicker(playToPollInterval)
+	defer ticker.Stop()
+	failures := 0
+	started := false
+	for {
+		select {
+		case <-pb.done:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), playToPollInterval)
+		st, err := p.status(ctx, pb)
+		if err == nil && st.State == StatePlaying && pb.seekTo > 0 {
+			if pb.renderer.Seek(ctx, pb.seekTo) == nil {
+				st.Position = pb.seekTo
+			}
+			pb.seekTo = 0
+		}
+		cancel()
+
+		if err != nil {
+			failures++
+			if failures >= playToMaxFailures {
+				p.finish(sessionID, pb, fmt.Errorf("renderer stopped responding: %w", err))
+				return
+			}
+			continue
+		}
+		failures = 0
+		if p.onStatus != nil {
+			p.onStatus(sessionID, st)
+		}
+
+		switch st.State {
+		case StatePlaying, StatePaused, StateTransitioning:
+			started = true
+		case StateStopped, StateNoMedia:
+			// Ended, stopped from the TV's remote, or replaced by another
+			// controller; ignore the STOPPED some renderers report before
+			// starting
+			if started {
+				p.finish(sessionID, pb, nil)
+				return
+			}
+		}
+	}
+}
+
+func (p *PlayTo) status(ctx context.Context, pb *playback) (PlaybackStatus, error) {
+	state, err := pb.renderer.TransportState(ctx)
+	if err != nil {
+		return PlaybackStatus{}, err
+	}
+	st := PlaybackStatus{State: state}
+	if pos, err := pb.renderer.PositionInfo(ctx); err == nil {
+		st.Position, st.Duration = pos.Position, pos.Duration
+	}
+	return st, nil
+}
+
+func (p *PlayTo) finish(sessionID uuid.UUID, pb *playback, err error) {
+	p.mu.Lock()
+	if p.sessions[sessionID] == pb {
+		delete(p.sessions, sessionID)
+	}
+	p.mu.Unlock()
+	pb.stop()
+	if err != nil {
+		log.Printf("[DLNA] play-to session %s: %v", sessionID, err)
+	}
+	if p.onClose != nil {
+		p.onClose(sessionID, err)
+	}
+}
+
+// Connected reports whether the session is playing on a renderer.
+func (p *PlayTo) Connected(sessionID uuid.UUID) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sessions[sessionID] != nil
+}
+
+// Command sends play, pause, seek (value = seconds), volume (value = 0-1)
+// or stop to the session's renderer and returns its status afterwards.
+func (p *PlayTo) Command(ctx context.Context, sessionID uuid.UUID, command string, value float64) (*PlaybackStatus, error) {
+	p.mu.Lock()
+	pb := p.sessions[sessionID]
+	p.mu.Unlock()
+	if pb == nil {
+		return nil, ErrNoPlayback
+	}
+	var err error
+	switch command {
+	case "play":
+		err = pb.renderer.Play(ctx)
+	case "pause":
+		err = pb.renderer.Pause(ctx)
+	case "seek":
+		err = pb.renderer.Seek(ctx, value)
+	case "volume":
+		err = pb.renderer.SetVolume(ctx, int(value*100+0.5))
+	case "stop":
+		err = pb.renderer.Stop(ctx)
+		p.Stop(sessionID)
+		return nil, err
+	default:
+		return nil, fmt.Errorf("unknown command %q", command)
+	}
+	if err != nil {
+		return nil, err
+	}
+	st, err := p.status(ctx, pb)
+	if err != nil {
+		return nil, nil
+	}
+	return &st, nil
+}
+
+// Stop stops polling the session's renderer. The renderer itself is left
+// alone; use Command(stop) to stop playback.
+func (p *PlayTo) Stop(sessionID uuid.UUID) {
+	p.mu.Lock()
+	pb := p.sessions[sessionID]
+	delete(p.sessions, sessionID)
+	p.mu.Unlock()
+	if pb != nil {
+		pb.stop()
+	}
+}
+
+// Shutdown stops polling every session.
+func (p *PlayTo) Shutdown() {
+	p.mu.Lock()
+	for id, pb := range p.sessions {
+		pb.stop()
+		delete(p.sessions, id)
+	}
+	p.mu.Unlock()
+}
@@ -0,0 +1,236 @@
+package dlna
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Delivery is how a renderer gets a media item.
+type Delivery string
+
+const (
+	DeliveryDirect    Delivery = "direct"    // the file as-is, byte-range seekable
+	DeliveryRemux     Delivery = "remux"     // video copied into MPEG-TS, audio to AAC if needed
+	DeliveryTranscode Delivery = "transcode" // H.264/AAC MPEG-TS
+)
+
+// DLNA.ORG_FLAGS: streaming transfer mode, background transfer mode,
+// connection stall, DLNA v1.5
+const (
+	dlnaFlagsDirect    = "01700000000000000000000000000000"
+	dlnaFlagsStreaming = "01500000000000000000000000000000"
+)
+
+// RendererProfile describes what a family of renderers can play and how
+// their res elements must be labelled. Empty codec/container lists accept
+// anything.
+type RendererProfile struct {
+	Name string
+	// Case-insensitive substrings of User-Agent, X-AV-Client-Info or
+	// FriendlyName.DLNA.ORG identifying the renderer
+	Match []string
+
+	Containers  []string // played as-is
+	VideoCodecs []string // decodable video; anything else is transcoded
+	AudioCodecs []string // decodable audio; anything else goes through remux
+	MaxHeight   int      // taller sources are transcoded; 0 = no limit
+
+	// DLNA.ORG_PN per served MIME type. Some TVs (Sony, older Samsung)
+	// refuse res elements without one.
+	PN map[string]string
+	// Seekable by time (DLNA.ORG_OP=10) on remux/transcode streams via
+	// TimeSeekRange.dlna.org
+	TimeSeek bool
+}
+
+// profiles is matched in order; the last entry is the fallback.
+var profiles = []*RendererProfile{
+	{
+		Name:     "Kodi / VLC",
+		Match:    []string{"kodi", "xbmc", "vlc", "platinum"},
+		TimeSeek: true,
+	},
+	{
+		Name:        "Samsung",
+		Match:       []string{"samsung", "sec_hhp", "sec hhp"},
+		Containers:  []string{"mp4", "m4v", "mkv", "avi", "ts", "mov", "mp3", "flac", "m4a"},
+		VideoCodecs: []string{"h264", "hevc", "mpeg4", "mpeg2video", "vc1"},
+		AudioCodecs: []string{"aac", "ac3", "eac3", "mp3", "flac"},
+		MaxHeight:   2160,
+		TimeSeek:    true,
+	},
+	{
+		Name:        "LG",
+		Match:       []string{"lge", "lg electronics", "webos", "lg tv"},
+		Containers:  []string{"mp4", "m4v", "mkv", "ts", "mp3", "flac", "m4a"},
+		VideoCodecs: []string{"h264", "hevc", "vp9"},
+		AudioCodecs: []string{"aac", "ac3", "eac3", "mp3", "flac"},
+		MaxHeight:   2160,
+		TimeSeek:    true,
+	},
+	{
+		Name:        "Sony Bravia",
+		Match:       []string{"bravia", "sony"},
+		Containers:  []string{"mp4", "m4v", "ts", "mp3"},
+		VideoCodecs: []string{"h264", "hevc"},
+		AudioCodecs: []string{"aac", "ac3", "mp3"},
+		MaxHeight:   2160,
+		PN: map[string]string{
+			"video/mp2t": "AVC_TS_MP_HD_AAC_MULT5_ISO",
+			"video/mp4":  "AVC_MP4_HP_HD_AAC",
+			"audio/mpeg": "MP3",
+		},
+		TimeSeek: true,
+	},
+	{
+		Name:        "Panasonic Viera",
+		Match:       []string{"panasonic", "viera"},
+		Containers:  []string{"mp4", "m4v", "mkv", "ts", "mp3"},
+		VideoCodecs: []string{"h264"},
+		AudioCodecs: []string{"aac", "ac3", "mp3"},
+		MaxHeight:   1080,
+	},
+	{
+		Name:        "Xbox",
+		Match:       []string{"xbox"},
+		Containers:  []string{"mp4", "m4v", "mkv", "avi", "mov", "mp3", "m4a"},
+		VideoCodecs: []string{"h264", "hevc", "mpeg4"},
+		AudioCodecs: []string{"aac", "ac3", "mp3"},
+		MaxHeight:   2160,
+	},
+	{
+		Name:        "Generic",
+		Containers:  []string{"mp4", "m4v", "mp3"},
+		VideoCodecs: []string{"h264"},
+		AudioCodecs: []string{"aac", "mp3"},
+		MaxHeight:   1080,
+		PN: map[string]string{
+			"audio/mpeg": "MP3",
+		},
+	},
+}
+
+// MatchProfile picks the profile for the renderer making the request.
+func MatchProfile(h http.Header) *RendererProfile {
+	ident := strings.ToLower(h.Get("User-Agent") + " " + h.Get("X-AV-Client-Info") + " " + h.Get("FriendlyName.DLNA.ORG"))
+	for _, p := range profiles[:len(profiles)-1] {
+		for _, m := range p.Match {
+			if strings.Contains(ident, m) {
+				return p
+			}
+		}
+	}
+	return profiles[len(profiles)-1]
+}
+
+// Choose decides how the item is delivered to this renderer. Items without
+// codec information (e.g. unscanned) are trusted to play directly.
+func (p *RendererProfile) Choose(item *DIDLItem) Delivery {
+	audioOnly := strings.HasPrefix(itemClass(item), "object.item.audioItem")
+	if p.MaxHeight > 0 && item.Height > p.MaxHeight {
+		return DeliveryTranscode
+	}
+	if item.VideoCodec != "" && !audioOnly && !accepts(p.VideoCodecs, item.VideoCodec) {
+		return DeliveryTranscode
+	}
+	if accepts(p.Containers, item.Container) && (item.AudioCodec == "" || accepts(p.AudioCodecs, item.AudioCodec)) {
+		return DeliveryDirect
+	}
+	if audioOnly {
+		// No audio-only remux path; let the renderer try the file
+		return DeliveryDirect
+	}
+	return DeliveryRemux
+}
+
+// ProtocolInfo builds the res@protocolInfo for a delivery of mimeType.
+func (p *RendererProfile) ProtocolInfo(d Delivery, mimeType string) string {
+	return fmt.Sprintf("http-get:*:%s:%s", mimeType, p.ContentFeatures(d, mimeType))
+}
+
+// ContentFeatures is the fourth protocolInfo field, also sent back as the
+// contentFeatures.dlna.org header when the renderer fetches the stream.
+func (p *RendererProfile) ContentFeatures(d Delivery, mimeType string) string {
+	var params []string
+	if pn := p.PN[mimeType]; pn != "" {
+		params = append(params, "DLNA.ORG_PN="+pn)
+	}
+	switch {
+	case d == DeliveryDirect:
+		params = append(params, "DLNA.ORG_OP=01", "DLNA.ORG_CI=0", "DLNA.ORG_FLAGS="+dlnaFlagsDirect)
+	case p.TimeSeek:
+		params = append(params, "DLNA.ORG_OP=10", "DLNA.ORG_CI=1", "DLNA.ORG_FLAGS="+dlnaFlagsStreaming)
+	default:
+		params = append(params, "DLNA.ORG_OP=00", "DLNA.ORG_CI=1", "DLNA.ORG_FLAGS="+dlnaFlagsStreaming)
+	}
+	return strings.Join(params, ";")
+}
+
+// MimeType maps a container (lowercased file extension) to the MIME type
+// renderers expect for it.
+func MimeType(container string) string {
+	switch strings.ToLower(strings.TrimPrefix(container, ".")) {
+	case "mkv":
+		return "video/x-matroska"
+	case "avi":
+		return "video/x-msvideo"
+	case "webm":
+		return "video/webm"
+	case "ts", "m2ts", "mts":
+		return "video/mp2t"
+	case "mov":
+		return "video/quicktime"
+	case "mpg", "mpeg":
+		return "video/mpeg"
+	case "wmv":
+		return "video/x-ms-wmv"
+	case "mp3":
+		return "audio/mpeg"
+	case "flac":
+		return "audio/flac"
+	case "m4a", "m4b", "aac":
+		return "audio/mp4"
+	case "ogg", "opus":
+		return "audio/ogg"
+	case "wav":
+		return "audio/wav"
+	case "jpg", "jpeg":
+		return "image/jpeg"
+	case "png":
+		return "image/png"
+	}
+	return "video/mp4"
+}
+
+func accepts(list []string, v string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	v = strings.ToLower(strings.TrimPrefix(v, "."))
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTimeSeekRange reads the start of a TimeSeekRange.dlna.org header
+// ("npt=123.4-" or "npt=0:02:03.4-600"), in seconds.
+func ParseTimeSeekRange(v string) float64 {
+	v = strings.TrimSpace(v)
+	if !strings.HasPrefix(v, "npt=") {
+		return 0
+	}
+	start, _, _ := strings.Cut(strings.TrimPrefix(v, "npt="), "-")
+	if strings.Contains(start, ":") {
+		return ParseDuration(start)
+	}
+	secs, err := strconv.ParseFloat(strings.TrimSpace(start), 64)
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return secs
+}
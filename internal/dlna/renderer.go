@@ -0,0 +1,188 @@
+package dlna
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mediaRendererType   = "urn:schemas-upnp-org:device:MediaRenderer:1"
+	avTransportPrefix   = "urn:schemas-upnp-org:service:AVTransport:"
+	renderingCtlPrefix  = "urn:schemas-upnp-org:service:RenderingControl:"
+	descriptionMaxBytes = 1 << 20
+)
+
+// Renderer is a UPnP MediaRenderer (smart TV, receiver, speaker) that
+// CineVault can push media to.
+type Renderer struct {
+	UDN          string `json:"udn"`
+	Name         string `json:"name"`
+	Manufacturer string `json:"manufacturer"`
+	Model        string `json:"model"`
+	Location     string `json:"location"` // device description URL
+
+	avTransportURL  string
+	avTransportType string
+	renderingURL    string
+	renderingType   string
+}
+
+// DiscoverRenderers sends an SSDP M-SEARCH for MediaRenderer devices and
+// loads the description of each one that answers before ctx is done.
+func DiscoverRenderers(ctx context.Context) ([]*Renderer, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	defer conn.Close()
+
+	addr, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("resolve SSDP addr: %w", err)
+	}
+	search := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\n"+
+		"HOST: %s\r\n"+
+		"MAN: \"ssdp:discover\"\r\n"+
+		"MX: 2\r\n"+
+		"ST: %s\r\n"+
+		"USER-AGENT: %s\r\n"+
+		"\r\n", ssdpAddr, mediaRendererType, serverHeader)
+	if _, err := conn.WriteToUDP([]byte(search), addr); err != nil {
+		return nil, fmt.Errorf("send M-SEARCH: %w", err)
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(3 * time.Second)
+	}
+	locations := make(map[string]bool)
+	buf := make([]byte, 4096)
+	for time.Now().Before(deadline) && ctx.Err() == nil {
+		conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			continue
+		}
+		if loc := extractHeader(string(buf[:n]), "LOCATION"); loc != "" {
+			locations[loc] = true
+		}
+	}
+
+	// Load descriptions with a fresh deadline; discovery used up ctx's
+	descCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		renderers []*Renderer
+		seen      = make(map[string]bool)
+	)
+	for loc := range locations {
+		wg.Add(1)
+		go func(loc string) {
+			defer wg.Done()
+			rd, err := LoadRenderer(descCtx, loc)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			if !seen[rd.UDN] {
+				seen[rd.UDN] = true
+				renderers = append(renderers, rd)
+			}
+			mu.Unlock()
+		}(loc)
+	}
+	wg.Wait()
+	return renderers, nil
+}
+
+type descDevice struct {
+	DeviceType   string        `xml:"deviceType"`
+	FriendlyName string        `xml:"friendlyName"`
+	Manufacturer string        `xml:"manufacturer"`
+	ModelName    string        `xml:"modelName"`
+	UDN          string        `xml:"UDN"`
+	Services     []descService `xml:"serviceList>service"`
+	Devices      []descDevice  `xml:"deviceList>device"`
+}
+
+type descService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+// LoadRenderer fetches a device description and returns the renderer if it
+// has an AVTransport service (on the root or an embedded device).
+func LoadRenderer(ctx context.Context, location string) (*Renderer, error) {
+	base, err := url.Parse(location)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") {
+		return nil, fmt.Errorf("invalid description URL %q", location)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := soapClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch description: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch description: HTTP %d", resp.StatusCode)
+	}
+	var desc struct {
+		URLBase string     `xml:"URLBase"`
+		Device  descDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, descriptionMaxBytes)).Decode(&desc); err != nil {
+		return nil, fmt.Errorf("parse description: %w", err)
+	}
+	if desc.URLBase != "" {
+		if u, err := url.Parse(desc.URLBase); err == nil {
+			base = u
+		}
+	}
+
+	rd := &Renderer{
+		UDN:          desc.Device.UDN,
+		Name:         desc.Device.FriendlyName,
+		Manufacturer: desc.Device.Manufacturer,
+		Model:        desc.Device.ModelName,
+		Location:     location,
+	}
+	var walk func(d descDevice)
+	walk = func(d descDevice) {
+		for _, svc := range d.Services {
+			ctl, err := base.Parse(strings.TrimSpace(svc.ControlURL))
+			if err != nil {
+				continue
+			}
+			switch {
+			case strings.HasPrefix(svc.ServiceType, avTransportPrefix) && rd.avTransportURL == "":
+				rd.avTransportURL, rd.avTransportType = ctl.String(), svc.ServiceType
+			case strings.HasPrefix(svc.ServiceType, renderingCtlPrefix) && rd.renderingURL == "":
+				rd.renderingURL, rd.renderingType = ctl.String(), svc.ServiceType
+			}
+		}
+		for _, child := range d.Devices {
+			walk(child)
+		}
+	}
+	walk(desc.Device)
+	if rd.avTransportURL == "" {
+		return nil, fmt.Errorf("%s has no AVTransport service", location)
+	}
+	if rd.Name == "" {
+		rd.Name = base.Hostname()
+	}
+	return rd, nil
+}
@@ -0,0 +1,370 @@
+package dlna
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SearchCriteria is a parsed ContentDirectory SearchCriteria string
+// (UPnP ContentDirectory:1 §2.5.5), e.g.
+//
+//	upnp:class derivedfrom "object.item.videoItem" and dc:title contains "star"
+//
+// "*" (or an empty string) matches everything.
+type SearchCriteria struct {
+	root searchNode // nil matches everything
+}
+
+type searchNode interface {
+	match(item *DIDLItem) bool
+}
+
+type logicalNode struct {
+	and         bool
+	left, right searchNode
+}
+
+func (n *logicalNode) match(item *DIDLItem) bool {
+	if n.and {
+		return n.left.match(item) && n.right.match(item)
+	}
+	return n.left.match(item) || n.right.match(item)
+}
+
+// Condition is a single comparison in a SearchCriteria, such as
+// dc:title contains "star". Op is lower case.
+type Condition struct {
+	Property string
+	Op       string
+	Value    string
+}
+
+// ParseSearchCriteria parses a SearchCriteria string. Property names are
+// kept as given; unknown properties never match (and "exists false" always
+// does), which is how a server without that property should behave.
+func ParseSearchCriteria(s string) (*SearchCriteria, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "*" {
+		return &SearchCriteria{}, nil
+	}
+	p := &searchParser{}
+	if err := p.tokenize(s); err != nil {
+		return nil, err
+	}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in search criteria", p.tokens[p.pos].text)
+	}
+	return &SearchCriteria{root: node}, nil
+}
+
+// Match reports whether the item satisfies the criteria.
+func (c *SearchCriteria) Match(item *DIDLItem) bool {
+	if c == nil || c.root == nil {
+		return true
+	}
+	return c.root.match(item)
+}
+
+// SQL renders the criteria as a SQL condition for a query whose
+// placeholders so far run up to $argBase. render turns one condition into
+// SQL with ? placeholders for its args, or reports that it can't. Parts it
+// can't render are dropped, which only widens the result, so when exact is
+// false the rows selected still need Match. An empty where matches all.
+func (c *SearchCriteria) SQL(argBase int, render func(cond *Condition) (sql string, args []interface{}, ok bool)) (where string, args []interface{}, exact bool) {
+	if c == nil || c.root == nil {
+		return "", nil, true
+	}
+	where, args, exact = sqlNode(c.root, render)
+	var sb strings.Builder
+	n := argBase
+	for i := 0; i < len(where); i++ {
+		if where[i] == '?' {
+			n++
+			fmt.Fprintf(&sb, "$%d", n)
+			continue
+		}
+		sb.WriteByte(where[i])
+	}
+	return sb.String(), args, exact
+}
+
+func sqlNode(node searchNode, render func(cond *Condition) (string, []interface{}, bool)) (string, []interface{}, bool) {
+	switch n := node.(type) {
+	case *Condition:
+		if sql, args, ok := render(n); ok {
+			return sql, args, true
+		}
+	case *logicalNode:
+		left, largs, lexact := sqlNode(n.left, render)
+		right, rargs, rexact := sqlNode(n.right, render)
+		switch {
+		case left != "" && right != "":
+			op := " OR "
+			if n.and {
+				op = " AND "
+			}
+			return "(" + left + op + right + ")", append(largs, rargs...), lexact && rexact
+		case n.and && left != "":
+			return left, largs, false
+		case n.and && right != "":
+			return right, rargs, false
+		}
+	}
+	return "", nil, false
+}
+
+// ── Tokenizer / recursive-descent parser ──
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type searchToken struct {
+	kind tokenKind
+	text string
+}
+
+type searchParser struct {
+	tokens []searchToken
+	pos    int
+}
+
+func (p *searchParser) tokenize(s string) error {
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == '(':
+			p.tokens = append(p.tokens, searchToken{tokLParen, "("})
+			i++
+		case c == ')':
+			p.tokens = append(p.tokens, searchToken{tokRParen, ")"})
+			i++
+		case c == '"':
+			// Quoted value; \" and \\ are the only escapes
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				sb.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return fmt.Errorf("unterminated string in search criteria")
+			}
+			p.tokens = append(p.tokens, searchToken{tokString, sb.String()})
+			i = j + 1
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			op := string(c)
+			if i+1 < len(s) && s[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return fmt.Errorf("invalid operator '!' in search criteria")
+			}
+			p.tokens = append(p.tokens, searchToken{tokOp, op})
+			i += len(op)
+		default:
+			j := i
+			for j < len(s) && !unicode.IsSpace(rune(s[j])) && !strings.ContainsRune("()\"=!<>", rune(s[j])) {
+				j++
+			}
+			p.tokens = append(p.tokens, searchToken{tokWord, s[i:j]})
+			i = j
+		}
+	}
+	return nil
+}
+
+func (p *searchParser) peekWord(word string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokWord && strings.EqualFold(p.tokens[p.pos].text, word)
+}
+
+// parseOr: andExp ('or' andExp)*  — 'and' binds tighter than 'or'
+func (p *searchParser) parseOr() (searchNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekWord("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *searchParser) parseAnd() (searchNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekWord("and") {
+		p.pos++
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *searchParser) parsePrimary() (searchNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of search criteria")
+	}
+	if p.tokens[p.pos].kind == tokLParen {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokRParen {
+			return nil, fmt.Errorf("missing ')' in search criteria")
+		}
+		p.pos++
+		return node, nil
+	}
+
+	if p.pos+2 >= len(p.tokens) {
+		return nil, fmt.Errorf("incomplete expression in search criteria")
+	}
+	prop := p.tokens[p.pos]
+	if prop.kind != tokWord {
+		return nil, fmt.Errorf("expected property, got %q", prop.text)
+	}
+	opTok := p.tokens[p.pos+1]
+	val := p.tokens[p.pos+2]
+	op := opTok.text
+	switch {
+	case opTok.kind == tokOp:
+	case opTok.kind == tokWord:
+		op = strings.ToLower(op)
+		switch op {
+		case "contains", "doesnotcontain", "derivedfrom", "exists":
+		default:
+			return nil, fmt.Errorf("unknown operator %q in search criteria", opTok.text)
+		}
+	default:
+		return nil, fmt.Errorf("expected operator after %s", prop.text)
+	}
+	if op == "exists" {
+		if val.kind != tokWord || (!strings.EqualFold(val.text, "true") && !strings.EqualFold(val.text, "false")) {
+			return nil, fmt.Errorf("exists needs true or false")
+		}
+	} else if val.kind != tokString {
+		return nil, fmt.Errorf("expected quoted value after %s %s", prop.text, opTok.text)
+	}
+	p.pos += 3
+	return &Condition{Property: prop.text, Op: op, Value: val.text}, nil
+}
+
+// ── Evaluation ──
+
+// property returns the item's value for a DIDL-Lite property name.
+func property(item *DIDLItem, name string) (string, bool) {
+	switch name {
+	case "dc:title":
+		return item.Title, true
+	case "upnp:class":
+		return itemClass(item), true
+	case "@id":
+		return item.ID, true
+	case "@parentID":
+		return item.ParentID, true
+	case "res@protocolInfo", "res":
+		return item.MimeType, item.MimeType != ""
+	case "dc:creator", "upnp:artist":
+		return item.Creator, item.Creator != ""
+	case "upnp:album":
+		return item.Album, item.Album != ""
+	case "upnp:genre":
+		return item.Genre, item.Genre != ""
+	case "dc:date":
+		return item.Date, item.Date != ""
+	case "res@duration":
+		return item.Duration, item.Duration != ""
+	case "res@size":
+		if item.Size > 0 {
+			return strconv.FormatInt(item.Size, 10), true
+		}
+	}
+	return "", false
+}
+
+// Match reports whether the item satisfies the condition.
+func (n *Condition) Match(item *DIDLItem) bool { return n.match(item) }
+
+func (n *Condition) match(item *DIDLItem) bool {
+	v, ok := property(item, n.Property)
+	if n.Op == "exists" {
+		return ok == strings.EqualFold(n.Value, "true")
+	}
+	if !ok {
+		return false
+	}
+	lv, lw := strings.ToLower(v), strings.ToLower(n.Value)
+	switch n.Op {
+	case "contains":
+		return strings.Contains(lv, lw)
+	case "doesnotcontain":
+		return !strings.Contains(lv, lw)
+	case "derivedfrom":
+		return lv == lw || strings.HasPrefix(lv, lw+".")
+	case "=":
+		return lv == lw
+	case "!=":
+		return lv != lw
+	}
+	// Relational operators compare numerically when both sides are numbers
+	// (sizes), otherwise lexically (dates and H:MM:SS durations sort fine)
+	cmp := strings.Compare(lv, lw)
+	if a, err := strconv.ParseFloat(v, 64); err == nil {
+		if b, err := strconv.ParseFloat(n.Value, 64); err == nil {
+			switch {
+			case a < b:
+				cmp = -1
+			case a > b:
+				cmp = 1
+			default:
+				cmp = 0
+			}
+		}
+	}
+	switch n.Op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func itemClass(item *DIDLItem) string {
+	if item.Class == "" {
+		return "object.item.videoItem"
+	}
+	return item.Class
+}
@@ -0,0 +1,49 @@
+package dlna
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchCriteriaSQL(t *testing.T) {
+	// Renders titles and classes; anything else is left to Match
+	render := func(cond *Condition) (string, []interface{}, bool) {
+		switch cond.Property {
+		case "dc:title":
+			return "title ILIKE ?", []interface{}{cond.Value}, true
+		case "upnp:class":
+			return "class = ?", []interface{}{cond.Value}, true
+		}
+		return "", nil, false
+	}
+	tests := []struct {
+		criteria string
+		argBase  int
+		where    string
+		args     []interface{}
+		exact    bool
+	}{
+		{"*", 0, "", nil, true},
+		{`dc:title contains "star"`, 0, "title ILIKE $1", []interface{}{"star"}, true},
+		{`dc:title contains "star"`, 1, "title ILIKE $2", []interface{}{"star"}, true},
+		{`upnp:class derivedfrom "object.item.videoItem" and dc:title contains "star"`, 1,
+			"(class = $2 AND title ILIKE $3)", []interface{}{"object.item.videoItem", "star"}, true},
+		{`dc:title contains "a" or dc:title contains "b"`, 0,
+			"(title ILIKE $1 OR title ILIKE $2)", []interface{}{"a", "b"}, true},
+		{`dc:title contains "star" and res@size > "1000"`, 0, "title ILIKE $1", []interface{}{"star"}, false},
+		{`res@size > "1000" and dc:title contains "star"`, 0, "title ILIKE $1", []interface{}{"star"}, false},
+		{`dc:title contains "star" or res@size > "1000"`, 0, "", nil, false},
+		{`(dc:title contains "a" or res@size > "1") and upnp:class = "x"`, 0, "class = $1", []interface{}{"x"}, false},
+		{`res@size > "1000"`, 0, "", nil, false},
+	}
+	for _, tt := range tests {
+		c, err := ParseSearchCriteria(tt.criteria)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.criteria, err)
+		}
+		where, args, exact := c.SQL(tt.argBase, render)
+		if where != tt.where || !reflect.DeepEqual(args, tt.args) || exact != tt.exact {
+			t.Errorf("%s:\n got %q %v exact=%v\nwant %q %v exact=%v", tt.criteria, where, args, exact, tt.where, tt.args, tt.exact)
+		}
+	}
+}
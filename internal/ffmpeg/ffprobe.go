@@ -37,6 +37,7 @@ type StreamInfo struct {
 	ColorSpace     string         `json:"color_space"`
 	PixFmt         string         `json:"pix_fmt"`
 	Profile        string         `json:"profile"`
+	Level          int            `json:"level"`
 	SideDataList   []SideDataItem `json:"side_data_list"`
 	Tags           map[string]string `json:"tags"`
 	Disposition    Disposition    `json:"disposition"`
@@ -0,0 +1,399 @@
+package importer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	_ "modernc.org/sqlite"
+)
+
+// Source identifies which media server a library database came from.
+type Source string
+
+const (
+	SourcePlex     Source = "plex"
+	SourceJellyfin Source = "jellyfin"
+)
+
+// PathMapping rewrites a path prefix from the source server's view of the
+// filesystem to CineVault's (e.g. /data/movies → /media/movies).
+type PathMapping struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Options configures a single import run.
+type Options struct {
+	JobID    uuid.UUID
+	UserID   uuid.UUID // admin who started the import; owns imported collections
+	Source   Source
+	FilePath string
+	PathMap  []PathMapping
+	// UserMap maps source account names to CineVault user IDs. Source users
+	// not listed here are matched by username (case-insensitive).
+	UserMap map[string]uuid.UUID
+}
+
+// ── Normalized source data ──
+
+type sourceUser struct {
+	ID   string
+	Name string
+}
+
+type sourceItem struct {
+	ID     string
+	Kind   string // movie, episode, track, audiobook, video
+	Title  string
+	Year   int
+	Paths  []string
+	IMDBID string
+	TMDBID string
+	TVDBID string
+}
+
+type sourceUserData struct {
+	UserID     string
+	ItemID     string
+	Played     bool
+	PlayCount  int
+	Position   int // seconds
+	LastPlayed *time.Time
+	Rating     *float64 // 0–10
+	Favorite   bool
+}
+
+type sourceList struct {
+	Name    string
+	UserID  string // owner on the source server; empty for shared collections
+	ItemIDs []string
+}
+
+// sourceLibrary is everything read from a Plex or Jellyfin database, reduced
+// to the fields CineVault can carry over.
+type sourceLibrary struct {
+	Users       []sourceUser
+	Items       []sourceItem
+	UserData    []sourceUserData
+	Collections []sourceList
+	Playlists   []sourceList
+}
+
+// Importer reads a Plex or Jellyfin library database and carries watch state,
+// ratings, collections and playlists over to matching CineVault media items.
+type Importer struct {
+	db *sql.DB
+}
+
+func New(db *sql.DB) *Importer {
+	return &Importer{db: db}
+}
+
+// Result summarises a finished import.
+type Result struct {
+	TotalItems   int `json:"total_items"`
+	MatchedItems int `json:"matched_items"`
+	FailedItems  int `json:"failed_items"`
+	WatchStates  int `json:"watch_states"`
+	Ratings      int `json:"ratings"`
+	Favorites    int `json:"favorites"`
+	Collections  int `json:"collections"`
+	Playlists    int `json:"playlists"`
+}
+
+// Run executes an import job end to end, updating the import_jobs row as it
+// goes. Unmatched items are written to import_job_items for the report.
+func (im *Importer) Run(opts Options) (*Result, error) {
+	im.db.Exec("UPDATE import_jobs SET status = 'running' WHERE id = $1", opts.JobID)
+
+	res, err := im.run(opts)
+	if err != nil {
+		log.Printf("[import] %s job %s failed: %v", opts.Source, opts.JobID, err)
+		im.db.Exec("UPDATE import_jobs SET status = 'failed', error_message = $2, completed_at = NOW() WHERE id = $1",
+			opts.JobID, err.Error())
+		return nil, err
+	}
+
+	im.db.Exec(`UPDATE import_jobs SET status = 'completed', total_items = $2, matched_items = $3,
+		failed_items = $4, completed_at = NOW() WHERE id = $1`,
+		opts.JobID, res.TotalItems, res.MatchedItems, res.FailedItems)
+	log.Printf("[import] %s job %s complete: %d items, %d matched, %d unmatched, %d watch states, %d ratings, %d collections, %d playlists",
+		opts.Source, opts.JobID, res.TotalItems, res.MatchedItems, res.FailedItems,
+		res.WatchStates, res.Ratings, res.Collections, res.Playlists)
+	return res, nil
+}
+
+func (im *Importer) run(opts Options) (*Result, error) {
+	if _, err := os.Stat(opts.FilePath); err != nil {
+		return nil, fmt.Errorf("database file: %w", err)
+	}
+	src, err := openSQLite(opts.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	var lib *sourceLibrary
+	switch opts.Source {
+	case SourcePlex:
+		lib, err = readPlex(src)
+	case SourceJellyfin:
+		lib, err = readJellyfin(src, opts.FilePath)
+	default:
+		return nil, fmt.Errorf("unsupported source %q", opts.Source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s database: %w", opts.Source, err)
+	}
+	log.Printf("[import] read %d items, %d users, %d collections, %d playlists from %s",
+		len(lib.Items), len(lib.Users), len(lib.Collections), len(lib.Playlists), opts.FilePath)
+
+	idx, err := loadMatchIndex(im.db)
+	if err != nil {
+		return nil, fmt.Errorf("load media index: %w", err)
+	}
+
+	res := &Result{TotalItems: len(lib.Items)}
+	im.db.Exec("UPDATE import_jobs SET total_items = $2 WHERE id = $1", opts.JobID, res.TotalItems)
+
+	// Match every source item to a CineVault media item
+	matched := make(map[string]uuid.UUID, len(lib.Items))
+	for _, it := range lib.Items {
+		mediaID, reason := idx.match(it, opts.PathMap)
+		if mediaID == uuid.Nil {
+			res.FailedItems++
+			im.recordUnmatched(opts.JobID, it, reason)
+			continue
+		}
+		matched[it.ID] = mediaID
+		res.MatchedItems++
+	}
+
+	users := im.resolveUsers(lib.Users, opts)
+
+	for _, ud := range lib.UserData {
+		userID, ok := users[ud.UserID]
+		if !ok {
+			continue
+		}
+		mediaID, ok := matched[ud.ItemID]
+		if !ok {
+			continue
+		}
+		if ud.Played || ud.Position > 0 {
+			if im.applyWatchState(userID, mediaID, ud) == nil {
+				res.WatchStates++
+			}
+		}
+		if ud.Rating != nil && *ud.Rating > 0 {
+			if _, err := im.db.Exec(`INSERT INTO user_ratings (user_id, media_item_id, rating) VALUES ($1, $2, $3)
+				ON CONFLICT (user_id, media_item_id) DO UPDATE SET rating = $3, updated_at = NOW()`,
+				userID, mediaID, clampRating(*ud.Rating)); err == nil {
+				res.Ratings++
+			}
+		}
+		if ud.Favorite {
+			if _, err := im.db.Exec(`INSERT INTO user_favorites (user_id, media_item_id) VALUES ($1, $2)
+				ON CONFLICT (user_id, media_item_id) WHERE media_item_id IS NOT NULL DO NOTHING`,
+				userID, mediaID); err == nil {
+				res.Favorites++
+			}
+		}
+	}
+
+	for _, c := range lib.Collections {
+		owner := opts.UserID
+		if id, ok := users[c.UserID]; ok {
+			owner = id
+		}
+		if im.importCollection(owner, c, matched) {
+			res.Collections++
+		}
+	}
+	for _, p := range lib.Playlists {
+		owner, ok := users[p.UserID]
+		if !ok {
+			owner = opts.UserID
+		}
+		if im.importPlaylist(owner, p, matched) {
+			res.Playlists++
+		}
+	}
+
+	return res, nil
+}
+
+// openSQLite opens a source database read-only so a live Plex/Jellyfin
+// database is never modified.
+func openSQLite(path string) (*sql.DB, error) {
+	dsn := (&url.URL{Scheme: "file", Path: path, RawQuery: "mode=ro"}).String()
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// resolveUsers maps source user IDs to CineVault users: explicit UserMap
+// entries first, then case-insensitive username match. A source with a single
+// account (typical Plex owner-only server) maps to the importing admin.
+func (im *Importer) resolveUsers(srcUsers []sourceUser, opts Options) map[string]uuid.UUID {
+	out := make(map[string]uuid.UUID)
+	for _, u := range srcUsers {
+		if id, ok := opts.UserMap[u.Name]; ok {
+			out[u.ID] = id
+			continue
+		}
+		var id uuid.UUID
+		err := im.db.QueryRow("SELECT id FROM users WHERE LOWER(username) = LOWER($1)", u.Name).Scan(&id)
+		if err == nil {
+			out[u.ID] = id
+			continue
+		}
+		if len(srcUsers) == 1 {
+			out[u.ID] = opts.UserID
+			continue
+		}
+		log.Printf("[import] no CineVault user for source account %q, skipping its watch state", u.Name)
+	}
+	return out
+}
+
+// applyWatchState merges source progress into watch_history. Existing rows
+// keep whichever side is further along so an import never rolls back progress.
+func (im *Importer) applyWatchState(userID, mediaID uuid.UUID, ud sourceUserData) error {
+	lastWatched := time.Now()
+	if ud.LastPlayed != nil {
+		lastWatched = *ud.LastPlayed
+	}
+	progress := ud.Position
+	if ud.Played {
+		progress = 0
+	}
+	_, err := im.db.Exec(`
+		INSERT INTO watch_history (id, user_id, media_item_id, progress_seconds, duration_seconds, completed, last_watched_at)
+		VALUES ($1, $2, $3, $4, (SELECT duration_seconds FROM media_items WHERE id = $3), $5, $6)
+		ON CONFLICT (user_id, media_item_id) DO UPDATE SET
+			completed = watch_history.completed OR EXCLUDED.completed,
+			progress_seconds = CASE WHEN EXCLUDED.last_watched_at > watch_history.last_watched_at
+				THEN EXCLUDED.progress_seconds ELSE watch_history.progress_seconds END,
+			last_watched_at = GREATEST(watch_history.last_watched_at, EXCLUDED.last_watched_at)`,
+		uuid.New(), userID, mediaID, progress, ud.Played, lastWatched)
+	return err
+}
+
+// importCollection creates (or reuses, by name) a manual collection for the
+// owner and appends matched items that are not already members.
+func (im *Importer) importCollection(owner uuid.UUID, c sourceList, matched map[string]uuid.UUID) bool {
+	ids := matchedIDs(c.ItemIDs, matched)
+	if len(ids) == 0 {
+		return false
+	}
+	var collID uuid.UUID
+	err := im.db.QueryRow("SELECT id FROM collections WHERE user_id = $1 AND name = $2 AND collection_type = 'manual' LIMIT 1",
+		owner, c.Name).Scan(&collID)
+	if err == sql.ErrNoRows {
+		collID = uuid.New()
+		_, err = im.db.Exec(`INSERT INTO collections (id, user_id, name, collection_type, visibility)
+			VALUES ($1, $2, $3, 'manual', 'private')`, collID, owner, c.Name)
+	}
+	if err != nil {
+		log.Printf("[import] collection %q: %v", c.Name, err)
+		return false
+	}
+	for i, mediaID := range ids {
+		im.db.Exec(`INSERT INTO collection_items (id, collection_id, media_item_id, sort_position, added_by)
+			SELECT $1, $2, $3, $4, $5
+			WHERE NOT EXISTS (SELECT 1 FROM collection_items WHERE collection_id = $2 AND media_item_id = $3)`,
+			uuid.New(), collID, mediaID, i, owner)
+	}
+	return true
+}
+
+// importPlaylist creates (or reuses, by name) a playlist for the owner and
+// appends matched items in source order after any existing entries.
+func (im *Importer) importPlaylist(owner uuid.UUID, p sourceList, matched map[string]uuid.UUID) bool {
+	ids := matchedIDs(p.ItemIDs, matched)
+	if len(ids) == 0 {
+		return false
+	}
+	var plID uuid.UUID
+	err := im.db.QueryRow("SELECT id FROM playlists WHERE user_id = $1 AND name = $2 LIMIT 1", owner, p.Name).Scan(&plID)
+	if err == sql.ErrNoRows {
+		plID = uuid.New()
+		_, err = im.db.Exec("INSERT INTO playlists (id, user_id, name) VALUES ($1, $2, $3)", plID, owner, p.Name)
+	}
+	if err != nil {
+		log.Printf("[import] playlist %q: %v", p.Name, err)
+		return false
+	}
+	var maxOrder int
+	im.db.QueryRow("SELECT COALESCE(MAX(sort_order), 0) FROM playlist_items WHERE playlist_id = $1", plID).Scan(&maxOrder)
+	for i, mediaID := range ids {
+		im.db.Exec(`INSERT INTO playlist_items (id, playlist_id, media_item_id, sort_order)
+			SELECT $1, $2, $3, $4
+			WHERE NOT EXISTS (SELECT 1 FROM playlist_items WHERE playlist_id = $2 AND media_item_id = $3)`,
+			uuid.New(), plID, mediaID, maxOrder+i+1)
+	}
+	return true
+}
+
+func (im *Importer) recordUnmatched(jobID uuid.UUID, it sourceItem, reason string) {
+	var filePath *string
+	if len(it.Paths) > 0 {
+		filePath = &it.Paths[0]
+	}
+	providerIDs := map[string]string{}
+	if it.IMDBID != "" {
+		providerIDs["imdb_id"] = it.IMDBID
+	}
+	if it.TMDBID != "" {
+		providerIDs["tmdb_id"] = it.TMDBID
+	}
+	if it.TVDBID != "" {
+		providerIDs["tvdb_id"] = it.TVDBID
+	}
+	ids, _ := json.Marshal(providerIDs)
+	var year *int
+	if it.Year > 0 {
+		year = &it.Year
+	}
+	if _, err := im.db.Exec(`INSERT INTO import_job_items (job_id, source_item_id, kind, title, year, file_path, provider_ids, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		jobID, it.ID, it.Kind, it.Title, year, filePath, string(ids), reason); err != nil {
+		log.Printf("[import] record unmatched %q: %v", it.Title, err)
+	}
+}
+
+func matchedIDs(srcIDs []string, matched map[string]uuid.UUID) []uuid.UUID {
+	var out []uuid.UUID
+	seen := make(map[uuid.UUID]bool)
+	for _, id := range srcIDs {
+		if mediaID, ok := matched[id]; ok && !seen[mediaID] {
+			seen[mediaID] = true
+			out = append(out, mediaID)
+		}
+	}
+	return out
+}
+
+func clampRating(r float64) float64 {
+	if r < 0 {
+		return 0
+	}
+	if r > 10 {
+		return 10
+	}
+	return r
+}
@@ -0,0 +1,439 @@
+package importer
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Jellyfin .NET type names for the item kinds CineVault can match
+var jellyfinItemKinds = map[string]string{
+	"MediaBrowser.Controller.Entities.Movies.Movie": "movie",
+	"MediaBrowser.Controller.Entities.TV.Episode":   "episode",
+	"MediaBrowser.Controller.Entities.Audio.Audio":  "track",
+	"MediaBrowser.Controller.Entities.AudioBook":    "audiobook",
+	"MediaBrowser.Controller.Entities.MusicVideo":   "video",
+	"MediaBrowser.Controller.Entities.Video":        "video",
+}
+
+const (
+	jellyfinTypeBoxSet   = "MediaBrowser.Controller.Entities.Movies.BoxSet"
+	jellyfinTypePlaylist = "MediaBrowser.Controller.Playlists.Playlist"
+	jellyfinTicksPerSec  = 10_000_000
+)
+
+// jellyfinItemData is the subset of the per-item JSON blob that carries
+// collection/playlist membership and playlist ownership.
+type jellyfinItemData struct {
+	OwnerUserID    string `json:"OwnerUserId"`
+	LinkedChildren []struct {
+		Path   string `json:"Path"`
+		ItemID string `json:"ItemId"`
+	} `json:"LinkedChildren"`
+}
+
+// readJellyfin reads either the EF Core jellyfin.db (10.11+, BaseItems table)
+// or the legacy library.db (TypedBaseItems) with users from the sibling
+// jellyfin.db. Pointing at a legacy jellyfin.db finds library.db next to it.
+func readJellyfin(db *sql.DB, path string) (*sourceLibrary, error) {
+	if tableExists(db, "BaseItems") {
+		return readJellyfinEF(db)
+	}
+	dir := filepath.Dir(path)
+	if tableExists(db, "TypedBaseItems") {
+		var usersDB *sql.DB
+		if sibling := filepath.Join(dir, "jellyfin.db"); sibling != path {
+			if _, err := os.Stat(sibling); err == nil {
+				if udb, err := openSQLite(sibling); err == nil {
+					usersDB = udb
+					defer udb.Close()
+				}
+			}
+		}
+		return readJellyfinLegacy(db, usersDB)
+	}
+	if tableExists(db, "Users") {
+		libPath := filepath.Join(dir, "library.db")
+		libDB, err := openSQLite(libPath)
+		if err != nil {
+			return nil, fmt.Errorf("jellyfin.db has no items and library.db is not readable: %w", err)
+		}
+		defer libDB.Close()
+		if !tableExists(libDB, "TypedBaseItems") {
+			return nil, fmt.Errorf("%s is not a Jellyfin library database", libPath)
+		}
+		return readJellyfinLegacy(libDB, db)
+	}
+	return nil, fmt.Errorf("not a Jellyfin database (BaseItems/TypedBaseItems missing)")
+}
+
+// readJellyfinEF reads the Entity Framework schema used from Jellyfin 10.11.
+func readJellyfinEF(db *sql.DB) (*sourceLibrary, error) {
+	lib := &sourceLibrary{}
+
+	rows, err := db.Query("SELECT Id, Username FROM Users")
+	if err != nil {
+		return nil, fmt.Errorf("users: %w", err)
+	}
+	for rows.Next() {
+		var id, name string
+		if rows.Scan(&id, &name) == nil {
+			lib.Users = append(lib.Users, sourceUser{ID: normalizeGUID(id), Name: name})
+		}
+	}
+	rows.Close()
+
+	hasData := columnExists(db, "BaseItems", "Data")
+	dataCol := "NULL"
+	if hasData {
+		dataCol = "Data"
+	}
+	rows, err = db.Query(`SELECT Id, Type, COALESCE(Path, ''), COALESCE(Name, ''), COALESCE(ProductionYear, 0), ` + dataCol + ` FROM BaseItems`)
+	if err != nil {
+		return nil, fmt.Errorf("base items: %w", err)
+	}
+	itemByID := make(map[string]*sourceItem)
+	pathToID := make(map[string]string)
+	var order []string
+	var lists []jellyfinList
+	for rows.Next() {
+		var id, typ, path, name string
+		var year int
+		var data sql.NullString
+		if rows.Scan(&id, &typ, &path, &name, &year, &data) != nil {
+			continue
+		}
+		id = normalizeGUID(id)
+		if kind, ok := jellyfinItemKinds[typ]; ok {
+			it := &sourceItem{ID: id, Kind: kind, Title: name, Year: year}
+			if path != "" {
+				it.Paths = []string{path}
+				pathToID[path] = id
+			}
+			itemByID[id] = it
+			order = append(order, id)
+			continue
+		}
+		if (typ == jellyfinTypeBoxSet || typ == jellyfinTypePlaylist) && data.Valid {
+			lists = append(lists, jellyfinList{name: name, playlist: typ == jellyfinTypePlaylist, data: data.String})
+		}
+	}
+	rows.Close()
+
+	if tableExists(db, "BaseItemProviders") {
+		rows, err = db.Query("SELECT ItemId, ProviderId, ProviderValue FROM BaseItemProviders")
+		if err == nil {
+			for rows.Next() {
+				var itemID, provider, value string
+				if rows.Scan(&itemID, &provider, &value) != nil {
+					continue
+				}
+				if it, ok := itemByID[normalizeGUID(itemID)]; ok {
+					applyJellyfinProvider(it, provider, value)
+				}
+			}
+			rows.Close()
+		}
+	}
+
+	for _, id := range order {
+		lib.Items = append(lib.Items, *itemByID[id])
+	}
+
+	rows, err = db.Query(`SELECT ItemId, UserId, Rating, COALESCE(PlaybackPositionTicks, 0), COALESCE(PlayCount, 0),
+		COALESCE(IsFavorite, 0), COALESCE(Played, 0), LastPlayedDate FROM UserData`)
+	if err != nil {
+		return nil, fmt.Errorf("user data: %w", err)
+	}
+	for rows.Next() {
+		var itemID, userID string
+		var rating sql.NullFloat64
+		var ticks, playCount int64
+		var favorite, played bool
+		var lastPlayed interface{}
+		if rows.Scan(&itemID, &userID, &rating, &ticks, &playCount, &favorite, &played, &lastPlayed) != nil {
+			continue
+		}
+		itemID = normalizeGUID(itemID)
+		if _, ok := itemByID[itemID]; !ok {
+			continue
+		}
+		lib.UserData = append(lib.UserData, jellyfinUserData(normalizeGUID(userID), itemID, rating, ticks, playCount, favorite, played, lastPlayed))
+	}
+	rows.Close()
+
+	applyJellyfinLists(lib, lists, itemByID, pathToID)
+	return lib, nil
+}
+
+// readJellyfinLegacy reads the SQLite schema used up to Jellyfin 10.10.
+// usersDB may be nil, in which case per-user state cannot be attributed.
+func readJellyfinLegacy(db, usersDB *sql.DB) (*sourceLibrary, error) {
+	lib := &sourceLibrary{}
+
+	// UserDatas references users by InternalId; everything else uses the GUID
+	internalUsers := make(map[int64]string)
+	if usersDB != nil && tableExists(usersDB, "Users") {
+		rows, err := usersDB.Query("SELECT Id, Username, InternalId FROM Users")
+		if err != nil {
+			return nil, fmt.Errorf("users: %w", err)
+		}
+		for rows.Next() {
+			var id, name string
+			var internalID int64
+			if rows.Scan(&id, &name, &internalID) == nil {
+				id = normalizeGUID(id)
+				internalUsers[internalID] = id
+				lib.Users = append(lib.Users, sourceUser{ID: id, Name: name})
+			}
+		}
+		rows.Close()
+	} else {
+		log.Printf("[import] jellyfin users database not found; watch state will not be imported")
+	}
+
+	rows, err := db.Query(`SELECT guid, type, COALESCE(Path, ''), COALESCE(Name, ''), COALESCE(ProductionYear, 0),
+		COALESCE(ProviderIds, ''), data FROM TypedBaseItems`)
+	if err != nil {
+		return nil, fmt.Errorf("typed base items: %w", err)
+	}
+	itemByID := make(map[string]*sourceItem)
+	pathToID := make(map[string]string)
+	keyToID := make(map[string]string) // user data key → item ID
+	var order []string
+	var lists []jellyfinList
+	for rows.Next() {
+		var guid []byte
+		var typ, path, name, providerIDs string
+		var year int
+		var data []byte
+		if rows.Scan(&guid, &typ, &path, &name, &year, &providerIDs, &data) != nil {
+			continue
+		}
+		id := dotnetGUID(guid)
+		if kind, ok := jellyfinItemKinds[typ]; ok {
+			it := &sourceItem{ID: id, Kind: kind, Title: name, Year: year}
+			if path != "" {
+				it.Paths = []string{path}
+				pathToID[path] = id
+			}
+			// ProviderIds is "Imdb=tt0133093|Tmdb=603"
+			for _, pair := range strings.Split(providerIDs, "|") {
+				if k, v, ok := strings.Cut(pair, "="); ok {
+					applyJellyfinProvider(it, k, v)
+				}
+			}
+			itemByID[id] = it
+			order = append(order, id)
+			// Movies key user data by IMDb/TMDB ID, everything else by item ID
+			keyToID[id] = id
+			if it.IMDBID != "" {
+				keyToID[it.IMDBID] = id
+			}
+			if it.TMDBID != "" && kind == "movie" {
+				keyToID[it.TMDBID] = id
+			}
+			continue
+		}
+		if typ == jellyfinTypeBoxSet || typ == jellyfinTypePlaylist {
+			lists = append(lists, jellyfinList{name: name, playlist: typ == jellyfinTypePlaylist, data: string(data)})
+		}
+	}
+	rows.Close()
+
+	// Older releases kept explicit item → key rows
+	if tableExists(db, "UserDataKeys") {
+		if rows, err := db.Query("SELECT ItemId, UserDataKey FROM UserDataKeys"); err == nil {
+			for rows.Next() {
+				var itemID []byte
+				var key string
+				if rows.Scan(&itemID, &key) == nil {
+					keyToID[key] = dotnetGUID(itemID)
+				}
+			}
+			rows.Close()
+		}
+	}
+
+	for _, id := range order {
+		lib.Items = append(lib.Items, *itemByID[id])
+	}
+
+	if len(internalUsers) > 0 && tableExists(db, "UserDatas") {
+		rows, err = db.Query(`SELECT key, userId, rating, COALESCE(playbackPositionTicks, 0), COALESCE(playCount, 0),
+			COALESCE(isFavorite, 0), COALESCE(played, 0), lastPlayedDate FROM UserDatas`)
+		if err != nil {
+			return nil, fmt.Errorf("user datas: %w", err)
+		}
+		for rows.Next() {
+			var key string
+			var internalID, ticks, playCount int64
+			var rating sql.NullFloat64
+			var favorite, played bool
+			var lastPlayed interface{}
+			if rows.Scan(&key, &internalID, &rating, &ticks, &playCount, &favorite, &played, &lastPlayed) != nil {
+				continue
+			}
+			itemID, ok := keyToID[key]
+			if !ok {
+				itemID, ok = keyToID[normalizeGUID(key)]
+			}
+			userID, uok := internalUsers[internalID]
+			if !ok || !uok {
+				continue
+			}
+			lib.UserData = append(lib.UserData, jellyfinUserData(userID, itemID, rating, ticks, playCount, favorite, played, lastPlayed))
+		}
+		rows.Close()
+	}
+
+	applyJellyfinLists(lib, lists, itemByID, pathToID)
+	return lib, nil
+}
+
+type jellyfinList struct {
+	name     string
+	playlist bool
+	data     string
+}
+
+// applyJellyfinLists resolves BoxSet and Playlist LinkedChildren (by item ID
+// or path) into source item IDs.
+func applyJellyfinLists(lib *sourceLibrary, lists []jellyfinList, itemByID map[string]*sourceItem, pathToID map[string]string) {
+	for _, l := range lists {
+		var d jellyfinItemData
+		if json.Unmarshal([]byte(l.data), &d) != nil {
+			continue
+		}
+		out := sourceList{Name: l.name}
+		for _, c := range d.LinkedChildren {
+			if id := normalizeGUID(c.ItemID); id != "" {
+				if _, ok := itemByID[id]; ok {
+					out.ItemIDs = append(out.ItemIDs, id)
+					continue
+				}
+			}
+			if id, ok := pathToID[c.Path]; ok {
+				out.ItemIDs = append(out.ItemIDs, id)
+			}
+		}
+		if len(out.ItemIDs) == 0 {
+			continue
+		}
+		if l.playlist {
+			out.UserID = normalizeGUID(d.OwnerUserID)
+			lib.Playlists = append(lib.Playlists, out)
+		} else {
+			lib.Collections = append(lib.Collections, out)
+		}
+	}
+}
+
+func jellyfinUserData(userID, itemID string, rating sql.NullFloat64, ticks, playCount int64, favorite, played bool, lastPlayed interface{}) sourceUserData {
+	ud := sourceUserData{
+		UserID:     userID,
+		ItemID:     itemID,
+		Played:     played,
+		PlayCount:  int(playCount),
+		Position:   int(ticks / jellyfinTicksPerSec),
+		LastPlayed: parseSQLiteTime(lastPlayed),
+		Favorite:   favorite,
+	}
+	if rating.Valid {
+		r := rating.Float64
+		ud.Rating = &r
+	}
+	return ud
+}
+
+func applyJellyfinProvider(it *sourceItem, provider, value string) {
+	if value == "" {
+		return
+	}
+	switch strings.ToLower(provider) {
+	case "imdb":
+		it.IMDBID = value
+	case "tmdb":
+		it.TMDBID = value
+	case "tvdb":
+		it.TVDBID = value
+	}
+}
+
+// dotnetGUID formats a 16-byte .NET Guid blob the way Guid.ToString("N")
+// does: the first three groups are stored little-endian.
+func dotnetGUID(b []byte) string {
+	if len(b) != 16 {
+		return normalizeGUID(string(b))
+	}
+	o := []byte{b[3], b[2], b[1], b[0], b[5], b[4], b[7], b[6]}
+	o = append(o, b[8:]...)
+	return hex.EncodeToString(o)
+}
+
+// normalizeGUID lower-cases a GUID string and strips dashes/braces so the
+// "N" and "D" formats compare equal.
+func normalizeGUID(s string) string {
+	s = strings.ToLower(strings.Trim(s, "{}"))
+	return strings.ReplaceAll(s, "-", "")
+}
+
+// ── SQLite helpers shared by the Plex and Jellyfin readers ──
+
+func tableExists(db *sql.DB, name string) bool {
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&n)
+	return n > 0
+}
+
+func columnExists(db *sql.DB, table, column string) bool {
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&n)
+	return n > 0
+}
+
+// parseSQLiteTime converts the assorted timestamp encodings found in Plex and
+// Jellyfin databases (unix seconds, ISO/SQL text, driver-parsed time) to a time.
+func parseSQLiteTime(v interface{}) *time.Time {
+	switch t := v.(type) {
+	case time.Time:
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	case int64:
+		if t <= 0 {
+			return nil
+		}
+		ts := time.Unix(t, 0)
+		return &ts
+	case float64:
+		if t <= 0 {
+			return nil
+		}
+		ts := time.Unix(int64(t), 0)
+		return &ts
+	case []byte:
+		return parseSQLiteTime(string(t))
+	case string:
+		if t == "" {
+			return nil
+		}
+		if n, err := strconv.ParseInt(t, 10, 64); err == nil {
+			return parseSQLiteTime(n)
+		}
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.9999999", "2006-01-02 15:04:05", "2006-01-02T15:04:05.9999999"} {
+			if ts, err := time.Parse(layout, t); err == nil {
+				return &ts
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package importer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// matchIndex is an in-memory lookup of every CineVault media item by file
+// path, file name and provider ID. Loading it once keeps matching a large
+// Plex library from issuing a query per item.
+type matchIndex struct {
+	byPath map[string]uuid.UUID
+	byName map[string][]uuid.UUID
+	byIMDB map[string][]uuid.UUID
+	byTMDB map[string][]uuid.UUID
+	byTVDB map[string][]uuid.UUID
+}
+
+func loadMatchIndex(db *sql.DB) (*matchIndex, error) {
+	rows, err := db.Query(`SELECT id, file_path, file_name, COALESCE(external_ids::text, '') FROM media_items
+		WHERE parent_media_id IS NULL ORDER BY added_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	idx := &matchIndex{
+		byPath: make(map[string]uuid.UUID),
+		byName: make(map[string][]uuid.UUID),
+		byIMDB: make(map[string][]uuid.UUID),
+		byTMDB: make(map[string][]uuid.UUID),
+		byTVDB: make(map[string][]uuid.UUID),
+	}
+	for rows.Next() {
+		var id uuid.UUID
+		var path, name, extJSON string
+		if err := rows.Scan(&id, &path, &name, &extJSON); err != nil {
+			return nil, err
+		}
+		// Multi-episode files share a path; the first row is the canonical one
+		if _, ok := idx.byPath[path]; !ok {
+			idx.byPath[path] = id
+		}
+		idx.byName[strings.ToLower(name)] = append(idx.byName[strings.ToLower(name)], id)
+		if extJSON == "" {
+			continue
+		}
+		var ext map[string]interface{}
+		if json.Unmarshal([]byte(extJSON), &ext) != nil {
+			continue
+		}
+		if v := extString(ext, "imdb_id"); v != "" {
+			idx.byIMDB[v] = append(idx.byIMDB[v], id)
+		}
+		if v := extString(ext, "tmdb_id"); v != "" {
+			idx.byTMDB[v] = append(idx.byTMDB[v], id)
+		}
+		if v := extString(ext, "tvdb_id"); v != "" {
+			idx.byTVDB[v] = append(idx.byTVDB[v], id)
+		}
+	}
+	return idx, rows.Err()
+}
+
+// match finds the CineVault item for a source item. Order of preference:
+// exact (mapped) file path, unique file name, then provider IDs. Episodes are
+// never matched on provider IDs alone because Plex's legacy agents attach the
+// show's ID to every episode. Returns uuid.Nil and a reason when nothing fits.
+func (idx *matchIndex) match(it sourceItem, pathMap []PathMapping) (uuid.UUID, string) {
+	for _, p := range it.Paths {
+		if id, ok := idx.byPath[mapPath(p, pathMap)]; ok {
+			return id, ""
+		}
+	}
+	for _, p := range it.Paths {
+		if ids := idx.byName[strings.ToLower(filepath.Base(p))]; len(ids) == 1 {
+			return ids[0], ""
+		}
+	}
+	if it.Kind != "episode" {
+		if it.IMDBID != "" && len(idx.byIMDB[it.IMDBID]) > 0 {
+			return idx.byIMDB[it.IMDBID][0], ""
+		}
+		if it.TMDBID != "" && len(idx.byTMDB[it.TMDBID]) > 0 {
+			return idx.byTMDB[it.TMDBID][0], ""
+		}
+		if it.TVDBID != "" && len(idx.byTVDB[it.TVDBID]) > 0 {
+			return idx.byTVDB[it.TVDBID][0], ""
+		}
+	}
+
+	switch {
+	case len(it.Paths) == 0 && it.IMDBID == "" && it.TMDBID == "" && it.TVDBID == "":
+		return uuid.Nil, "no file path or provider IDs in source"
+	case len(it.Paths) > 0 && it.IMDBID == "" && it.TMDBID == "" && it.TVDBID == "":
+		return uuid.Nil, "file path not found in CineVault"
+	default:
+		return uuid.Nil, "no file path or provider ID match"
+	}
+}
+
+// mapPath applies the first matching prefix rewrite.
+func mapPath(p string, pathMap []PathMapping) string {
+	for _, m := range pathMap {
+		if m.From != "" && strings.HasPrefix(p, m.From) {
+			return m.To + strings.TrimPrefix(p, m.From)
+		}
+	}
+	return p
+}
+
+func extString(m map[string]interface{}, key string) string {
+	switch v := m[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
@@ -0,0 +1,273 @@
+package importer
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Plex metadata_items.metadata_type values
+const (
+	plexTypeMovie    = 1
+	plexTypeEpisode  = 4
+	plexTypeTrack    = 10
+	plexTypePlaylist = 15
+)
+
+// Plex tags.tag_type values
+const (
+	plexTagCollection = 2
+	plexTagGUID       = 314 // external IDs for the new Plex agents, e.g. "imdb://tt0133093"
+)
+
+// readPlex reads a com.plexapp.plugins.library.db file.
+func readPlex(db *sql.DB) (*sourceLibrary, error) {
+	if !tableExists(db, "metadata_items") || !tableExists(db, "media_parts") {
+		return nil, fmt.Errorf("not a Plex library database (metadata_items/media_parts missing)")
+	}
+	lib := &sourceLibrary{}
+
+	// ── Accounts ──
+	if tableExists(db, "accounts") {
+		rows, err := db.Query("SELECT id, name FROM accounts WHERE id > 0")
+		if err != nil {
+			return nil, fmt.Errorf("accounts: %w", err)
+		}
+		for rows.Next() {
+			var id int64
+			var name sql.NullString
+			if rows.Scan(&id, &name) == nil {
+				lib.Users = append(lib.Users, sourceUser{ID: strconv.FormatInt(id, 10), Name: name.String})
+			}
+		}
+		rows.Close()
+	}
+
+	// ── Items and their files ──
+	itemByID := make(map[string]*sourceItem)
+	guidToItem := make(map[string]string)
+	rows, err := db.Query(`SELECT id, metadata_type, COALESCE(guid, ''), COALESCE(title, ''), COALESCE(year, 0)
+		FROM metadata_items WHERE metadata_type IN (?, ?, ?)`, plexTypeMovie, plexTypeEpisode, plexTypeTrack)
+	if err != nil {
+		return nil, fmt.Errorf("metadata_items: %w", err)
+	}
+	var order []string
+	for rows.Next() {
+		var id int64
+		var mtype, year int
+		var guid, title string
+		if err := rows.Scan(&id, &mtype, &guid, &title, &year); err != nil {
+			continue
+		}
+		it := &sourceItem{ID: strconv.FormatInt(id, 10), Kind: plexKind(mtype), Title: title, Year: year}
+		applyPlexGUID(it, guid)
+		itemByID[it.ID] = it
+		order = append(order, it.ID)
+		if guid != "" {
+			guidToItem[guid] = it.ID
+		}
+	}
+	rows.Close()
+
+	rows, err = db.Query(`SELECT mi.metadata_item_id, mp.file FROM media_parts mp
+		JOIN media_items mi ON mi.id = mp.media_item_id WHERE mp.file IS NOT NULL AND mp.file != ''`)
+	if err != nil {
+		return nil, fmt.Errorf("media_parts: %w", err)
+	}
+	for rows.Next() {
+		var metaID int64
+		var file string
+		if rows.Scan(&metaID, &file) != nil {
+			continue
+		}
+		if it, ok := itemByID[strconv.FormatInt(metaID, 10)]; ok {
+			it.Paths = append(it.Paths, file)
+		}
+	}
+	rows.Close()
+
+	// New-agent external IDs live in tags rather than the guid column
+	if tableExists(db, "taggings") && tableExists(db, "tags") {
+		rows, err = db.Query(`SELECT tg.metadata_item_id, t.tag FROM taggings tg
+			JOIN tags t ON t.id = tg.tag_id WHERE t.tag_type = ?`, plexTagGUID)
+		if err == nil {
+			for rows.Next() {
+				var metaID int64
+				var tag string
+				if rows.Scan(&metaID, &tag) != nil {
+					continue
+				}
+				if it, ok := itemByID[strconv.FormatInt(metaID, 10)]; ok {
+					applyPlexGUID(it, tag)
+				}
+			}
+			rows.Close()
+		}
+	}
+
+	for _, id := range order {
+		lib.Items = append(lib.Items, *itemByID[id])
+	}
+
+	// ── Per-account watch state and ratings (keyed by item guid) ──
+	if tableExists(db, "metadata_item_settings") {
+		rows, err = db.Query(`SELECT account_id, guid, rating, COALESCE(view_offset, 0), COALESCE(view_count, 0), last_viewed_at
+			FROM metadata_item_settings`)
+		if err != nil {
+			return nil, fmt.Errorf("metadata_item_settings: %w", err)
+		}
+		for rows.Next() {
+			var accountID int64
+			var guid string
+			var rating sql.NullFloat64
+			var viewOffset, viewCount int64
+			var lastViewed interface{}
+			if rows.Scan(&accountID, &guid, &rating, &viewOffset, &viewCount, &lastViewed) != nil {
+				continue
+			}
+			itemID, ok := guidToItem[guid]
+			if !ok {
+				continue
+			}
+			ud := sourceUserData{
+				UserID:     strconv.FormatInt(accountID, 10),
+				ItemID:     itemID,
+				Played:     viewCount > 0,
+				PlayCount:  int(viewCount),
+				Position:   int(viewOffset / 1000),
+				LastPlayed: parseSQLiteTime(lastViewed),
+			}
+			if rating.Valid {
+				r := rating.Float64
+				ud.Rating = &r
+			}
+			lib.UserData = append(lib.UserData, ud)
+		}
+		rows.Close()
+	}
+
+	// ── Collections (tag_type 2; membership is shared across accounts) ──
+	if tableExists(db, "taggings") && tableExists(db, "tags") {
+		rows, err = db.Query(`SELECT t.tag, tg.metadata_item_id FROM taggings tg
+			JOIN tags t ON t.id = tg.tag_id WHERE t.tag_type = ? ORDER BY t.tag, tg."index"`, plexTagCollection)
+		if err != nil {
+			log.Printf("[import] plex collections skipped: %v", err)
+		} else {
+			byName := make(map[string]*sourceList)
+			var names []string
+			for rows.Next() {
+				var name string
+				var metaID int64
+				if rows.Scan(&name, &metaID) != nil {
+					continue
+				}
+				c, ok := byName[name]
+				if !ok {
+					c = &sourceList{Name: name}
+					byName[name] = c
+					names = append(names, name)
+				}
+				c.ItemIDs = append(c.ItemIDs, strconv.FormatInt(metaID, 10))
+			}
+			rows.Close()
+			for _, n := range names {
+				lib.Collections = append(lib.Collections, *byName[n])
+			}
+		}
+	}
+
+	// ── Playlists ──
+	if tableExists(db, "play_queue_generators") {
+		owners := make(map[int64]string)
+		if tableExists(db, "metadata_item_accounts") {
+			if rows, err := db.Query("SELECT metadata_item_id, account_id FROM metadata_item_accounts"); err == nil {
+				for rows.Next() {
+					var metaID, accountID int64
+					if rows.Scan(&metaID, &accountID) == nil {
+						owners[metaID] = strconv.FormatInt(accountID, 10)
+					}
+				}
+				rows.Close()
+			}
+		}
+
+		rows, err = db.Query(`SELECT p.id, COALESCE(p.title, ''), g.metadata_item_id FROM metadata_items p
+			JOIN play_queue_generators g ON g.playlist_id = p.id
+			WHERE p.metadata_type = ? AND g.metadata_item_id IS NOT NULL
+			ORDER BY p.id, g."order"`, plexTypePlaylist)
+		if err != nil {
+			log.Printf("[import] plex playlists skipped: %v", err)
+			return lib, nil
+		}
+		var current *sourceList
+		var currentID int64 = -1
+		for rows.Next() {
+			var plID, metaID int64
+			var title string
+			if rows.Scan(&plID, &title, &metaID) != nil {
+				continue
+			}
+			if plID != currentID {
+				if current != nil {
+					lib.Playlists = append(lib.Playlists, *current)
+				}
+				owner, ok := owners[plID]
+				if !ok {
+					owner = "1" // server owner
+				}
+				current = &sourceList{Name: title, UserID: owner}
+				currentID = plID
+			}
+			current.ItemIDs = append(current.ItemIDs, strconv.FormatInt(metaID, 10))
+		}
+		rows.Close()
+		if current != nil {
+			lib.Playlists = append(lib.Playlists, *current)
+		}
+	}
+
+	return lib, nil
+}
+
+func plexKind(metadataType int) string {
+	switch metadataType {
+	case plexTypeMovie:
+		return "movie"
+	case plexTypeEpisode:
+		return "episode"
+	case plexTypeTrack:
+		return "track"
+	}
+	return "video"
+}
+
+// applyPlexGUID extracts provider IDs from a legacy agent guid
+// ("com.plexapp.agents.imdb://tt0133093?lang=en") or a new-agent guid tag
+// ("tmdb://603"). Plex's own plex:// guids carry no external ID.
+func applyPlexGUID(it *sourceItem, guid string) {
+	scheme, rest, ok := strings.Cut(guid, "://")
+	if !ok {
+		return
+	}
+	if i := strings.IndexAny(rest, "?"); i >= 0 {
+		rest = rest[:i]
+	}
+	scheme = strings.TrimPrefix(scheme, "com.plexapp.agents.")
+	switch scheme {
+	case "imdb":
+		if strings.HasPrefix(rest, "tt") {
+			it.IMDBID = rest
+		}
+	case "tmdb", "themoviedb":
+		if !strings.Contains(rest, "/") {
+			it.TMDBID = rest
+		}
+	case "tvdb", "thetvdb":
+		// Legacy episode guids are "showID/season/episode" — not an episode ID
+		if !strings.Contains(rest, "/") {
+			it.TVDBID = rest
+		}
+	}
+}
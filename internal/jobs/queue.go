@@ -22,6 +22,7 @@ const (
 	TaskMetadataRefresh  = "metadata:refresh"
 	TaskDetectSegments   = "detect:segments"
 	TaskLoudnessLibrary  = "loudness:library"
+	TaskOptimizeMedia    = "optimize:media"
 )
 
 type Queue struct {
@@ -251,7 +251,7 @@ func (h *MetadataScrapeHandler) ProcessTask(ctx context.Context, t *asynq.Task)
 		}
 
 		autoCfg := metadata.AutoMatchConfig(h.settingsRepo)
-		best := metadata.FindBestMatch(h.scrapers, query, item.MediaType, yearHint)
+		best := findMatch(h.scanner, h.scrapers, library, query, item.MediaType, autoCfg.MinConfidence, yearHint)
 		if best == nil || best.Confidence < autoCfg.MinConfidence {
 			continue
 		}
@@ -261,7 +261,7 @@ func (h *MetadataScrapeHandler) ProcessTask(ctx context.Context, t *asynq.Task)
 			for _, sc := range h.scrapers {
 				if tmdb, ok := sc.(*metadata.TMDBScraper); ok {
 					if details, err := tmdb.GetDetails(best.ExternalID); err == nil {
-						if details.ContentRating != nil {
+						if details.ContentRating != nil && !mergedFrom(best, metadata.FieldContentRating) {
 							best.ContentRating = details.ContentRating
 						}
 						if details.IMDBId != "" {
@@ -333,7 +333,10 @@ func (h *MetadataScrapeHandler) ProcessTask(ctx context.Context, t *asynq.Task)
 
 		// Extended enrichment via scanner (TMDB details, credits, fanart.tv)
 		if best.Source == "tmdb" && h.scanner != nil {
-			h.scanner.EnrichMatchedItem(item.ID, best.ExternalID, item.MediaType, item.LockedFields)
+			h.scanner.EnrichMatchedItem(item.ID, best, item.MediaType, item.LockedFields)
+		}
+		if best.Provenance != nil && h.scanner != nil {
+			h.scanner.ApplyMergedFields(item, best)
 		}
 
 		// Accumulate for batch contribute
@@ -768,14 +771,14 @@ func (h *MetadataRefreshHandler) ProcessTask(ctx context.Context, t *asynq.Task)
 		}
 
 		// ── Cache miss: fall through to direct scraper ──
-		best := metadata.FindBestMatch(h.scrapers, query, item.MediaType, yearHint)
+		best := findMatch(h.scanner, h.scrapers, library, query, item.MediaType, metadata.AutoMatchConfig(h.settingsRepo).MinConfidence, yearHint)
 		if best != nil {
 			// Enrich with source-specific details
 			if best.Source == "tmdb" {
 				for _, sc := range h.scrapers {
 					if tmdb, ok := sc.(*metadata.TMDBScraper); ok {
 						if details, err := tmdb.GetDetails(best.ExternalID); err == nil {
-							if details.ContentRating != nil {
+							if details.ContentRating != nil && !mergedFrom(best, metadata.FieldContentRating) {
 								best.ContentRating = details.ContentRating
 							}
 							if details.IMDBId != "" {
@@ -847,7 +850,10 @@ func (h *MetadataRefreshHandler) ProcessTask(ctx context.Context, t *asynq.Task)
 
 				// Extended enrichment via scanner (TMDB details, credits, fanart.tv)
 				if best.Source == "tmdb" && h.scanner != nil {
-					h.scanner.EnrichMatchedItem(item.ID, best.ExternalID, item.MediaType, item.LockedFields)
+					h.scanner.EnrichMatchedItem(item.ID, best, item.MediaType, item.LockedFields)
+				}
+				if best.Provenance != nil && h.scanner != nil {
+					h.scanner.ApplyMergedFields(item, best)
 				}
 
 				// Accumulate for batch contribute
@@ -1005,6 +1011,23 @@ func (h *MetadataRefreshHandler) findOrCreatePerformer(name, perfType, profilePa
 	return perfID
 }
 
+// findMatch looks up an item via the scanner, which follows the library's
+// metadata providers and merges fields across them, or directly with the
+// scrapers when there is no scanner.
+func findMatch(sc *scanner.Scanner, scrapers []metadata.Scraper, library *models.Library, query string, mediaType models.MediaType, minConfidence float64, year *int) *models.MetadataMatch {
+	if sc == nil {
+		return metadata.FindBestMatch(scrapers, query, mediaType, year)
+	}
+	return sc.FindMatch(library, query, mediaType, minConfidence, year)
+}
+
+// mergedFrom reports whether a merged match took field from a provider
+// other than its source.
+func mergedFrom(m *models.MetadataMatch, field string) bool {
+	source, ok := m.Provenance[field]
+	return ok && source != m.Source
+}
+
 // removeExistingPosters deletes old poster files for an item so fresh downloads aren't saved as _alt.
 func removeExistingPosters(dir, filename string) error {
 	ext := filepath.Ext(filename)
@@ -0,0 +1,242 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+
+	"github.com/JustinTDCT/CineVault/internal/config"
+	ffmpegPkg "github.com/JustinTDCT/CineVault/internal/ffmpeg"
+	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/JustinTDCT/CineVault/internal/repository"
+	"github.com/JustinTDCT/CineVault/internal/stream"
+)
+
+// ErrOptimizedQuota is returned when a version would take optimized
+// versions over their storage quota.
+var ErrOptimizedQuota = errors.New("optimized versions storage quota reached")
+
+// EnqueueOptimize queues the job that makes an optimized version.
+func EnqueueOptimize(q *Queue, versionID uuid.UUID) error {
+	_, err := q.EnqueueUnique(TaskOptimizeMedia, OptimizePayload{VersionID: versionID.String()}, "optimize:"+versionID.String(),
+		asynq.Queue("low"), asynq.Timeout(24*time.Hour), asynq.MaxRetry(2), asynq.Retention(1*time.Hour))
+	return err
+}
+
+// OptimizedPath is where a version is written: a hidden folder beside the
+// original, so it moves with the library, named after the original and the
+// profile. When the library folder is read-only it goes under fallbackDir
+// in a folder of its own per media item, since other shows and libraries
+// have files with the same folder and file names.
+func OptimizedPath(mediaItemID uuid.UUID, mediaPath, profile, fallbackDir string) (string, error) {
+	base := strings.TrimSuffix(filepath.Base(mediaPath), filepath.Ext(mediaPath))
+	name := fmt.Sprintf("%s.%s.mp4", base, profile)
+	dir := filepath.Join(filepath.Dir(mediaPath), models.OptimizedDirName)
+	if err := os.MkdirAll(dir, 0755); err == nil {
+		return filepath.Join(dir, name), nil
+	}
+	dir = filepath.Join(fallbackDir, "optimized", mediaItemID.String())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// RemoveOptimizedVersion deletes a version's file, any partial output and
+// its row.
+func RemoveOptimizedVersion(repo *repository.OptimizedRepository, v *models.OptimizedVersion) error {
+	if v.FilePath != nil && *v.FilePath != "" {
+		for _, p := range []string{*v.FilePath, *v.FilePath + ".part"} {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				log.Printf("Optimize: failed to remove %s: %v", p, err)
+			}
+		}
+		// Drop the folder once its last version is gone
+		os.Remove(filepath.Dir(*v.FilePath))
+	}
+	return repo.Delete(v.ID)
+}
+
+// ──────── Optimized Version Handler ────────
+
+type OptimizeHandler struct {
+	optRepo   *repository.OptimizedRepository
+	mediaRepo *repository.MediaRepository
+	cfg       *config.Config
+	notifier  EventNotifier
+}
+
+func NewOptimizeHandler(optRepo *repository.OptimizedRepository, mediaRepo *repository.MediaRepository, cfg *config.Config, notifier EventNotifier) *OptimizeHandler {
+	return &OptimizeHandler{optRepo: optRepo, mediaRepo: mediaRepo, cfg: cfg, notifier: notifier}
+}
+
+func (h *OptimizeHandler) ProcessTask(ctx context.Context, t *asynq.Task) error {
+	var p OptimizePayload
+	if err := json.Unmarshal(t.Payload(), &p); err != nil {
+		return fmt.Errorf("unmarshal: %w", err)
+	}
+	versionID, err := uuid.Parse(p.VersionID)
+	if err != nil {
+		return fmt.Errorf("invalid version id: %w", err)
+	}
+	v, err := h.optRepo.GetByID(versionID)
+	if err != nil {
+		// Deleted before it ran
+		return nil
+	}
+	if v.Status == models.OptimizedReady || v.MediaItemID == nil {
+		return nil
+	}
+	profile, ok := stream.OptimizeProfiles[v.Profile]
+	if !ok {
+		return h.optRepo.MarkFailed(v.ID, "unknown profile "+v.Profile)
+	}
+	media, err := h.mediaRepo.GetByID(*v.MediaItemID)
+	if err != nil {
+		return h.optRepo.MarkFailed(v.ID, "media item not found")
+	}
+	duration := 0.0
+	if media.DurationSeconds != nil {
+		duration = float64(*media.DurationSeconds)
+	}
+
+	if quota := int64(h.cfg.Optimized.QuotaGB) << 30; quota > 0 {
+		used, err := h.optRepo.TotalSize()
+		if err != nil {
+			return fmt.Errorf("optimized total size: %w", err)
+		}
+		if used+profile.EstimatedSize(duration) > quota {
+			log.Printf("Optimize: %s (%s) would exceed the %d GB quota", media.FileName, v.Profile, h.cfg.Optimized.QuotaGB)
+			return h.optRepo.MarkFailed(v.ID, ErrOptimizedQuota.Error())
+		}
+	}
+
+	outPath, err := OptimizedPath(media.ID, media.FilePath, v.Profile, h.cfg.Paths.Preview)
+	if err != nil {
+		return h.optRepo.MarkFailed(v.ID, "create output folder: "+err.Error())
+	}
+	partPath := outPath + ".part"
+	if err := h.optRepo.MarkProcessing(v.ID, outPath); err != nil {
+		return fmt.Errorf("mark processing: %w", err)
+	}
+
+	taskID := "optimize:" + v.ID.String()
+	taskDesc := fmt.Sprintf("Optimizing %s · %s", media.Title, profile.Label)
+	broadcast := func(status string, pct int) {
+		if h.notifier != nil {
+			h.notifier.Broadcast("task:update", map[string]interface{}{
+				"task_id": taskID, "task_type": TaskOptimizeMedia,
+				"status": status, "progress": pct, "description": taskDesc,
+			})
+		}
+	}
+	broadcast("running", 0)
+	log.Printf("Optimize: %s → %s (%s)", media.FileName, outPath, v.Profile)
+
+	hdr := media.HDRFormat != nil && *media.HDRFormat != ""
+	err = stream.Optimize(ctx, h.cfg.FFmpeg.FFmpegPath, media.FilePath, partPath, profile, hdr, duration, func(pct int) {
+		h.optRepo.UpdateProgress(v.ID, pct)
+		broadcast("running", pct)
+	})
+	if err != nil {
+		os.Remove(partPath)
+		if ctx.Err() != nil {
+			// Shutting down or timed out; asynq retries the task
+			return ctx.Err()
+		}
+		log.Printf("Optimize: failed for %s: %v", media.FileName, err)
+		broadcast("failed", 0)
+		return h.optRepo.MarkFailed(v.ID, err.Error())
+	}
+	if err := os.Rename(partPath, outPath); err != nil {
+		os.Remove(partPath)
+		return h.optRepo.MarkFailed(v.ID, "rename output: "+err.Error())
+	}
+
+	// Deleted while it was being made
+	if _, err := h.optRepo.GetByID(v.ID); err != nil {
+		os.Remove(outPath)
+		return nil
+	}
+
+	var width, height int
+	var size, bitrate int64
+	if info, err := os.Stat(outPath); err == nil {
+		size = info.Size()
+	}
+	if probe, err := ffmpegPkg.NewFFprobe(h.cfg.FFmpeg.FFprobePath).Probe(outPath); err == nil {
+		width, height, bitrate = probe.GetWidth(), probe.GetHeight(), probe.GetBitrate()
+	}
+	if err := h.optRepo.MarkReady(v.ID, size, width, height, bitrate); err != nil {
+		return fmt.Errorf("mark ready: %w", err)
+	}
+	broadcast("complete", 100)
+	log.Printf("Optimize: %s ready (%s, %d MB)", media.FileName, v.Profile, size>>20)
+	return nil
+}
+
+// ──────── Cleanup ────────
+
+// failedOptimizedAge is how long failed versions are listed before cleanup
+// removes them.
+const failedOptimizedAge = 7 * 24 * time.Hour
+
+// CleanupOptimized removes versions whose media item is gone, old failures
+// and versions unused for the retention period, then the least recently
+// used ones while the total is over the quota.
+func CleanupOptimized(repo *repository.OptimizedRepository, cfg config.OptimizedConfig) {
+	expired, err := repo.ListExpired(failedOptimizedAge, time.Duration(cfg.RetentionDays)*24*time.Hour)
+	if err != nil {
+		log.Printf("Optimize cleanup: %v", err)
+		return
+	}
+	for _, v := range expired {
+		if err := RemoveOptimizedVersion(repo, v); err != nil {
+			log.Printf("Optimize cleanup: remove %s: %v", v.ID, err)
+		}
+	}
+	removed := len(expired)
+
+	quota := int64(cfg.QuotaGB) << 30
+	if quota > 0 {
+		total, err := repo.TotalSize()
+		if err == nil && total > quota {
+			lru, _ := repo.ListLeastRecentlyUsed()
+			for _, v := range lru {
+				if total <= quota {
+					break
+				}
+				if err := RemoveOptimizedVersion(repo, v); err == nil {
+					total -= v.FileSize
+					removed++
+				}
+			}
+		}
+	}
+	if removed > 0 {
+		log.Printf("Optimize cleanup: removed %d versions", removed)
+	}
+}
+
+// StartOptimizedCleanup runs CleanupOptimized hourly until stop is closed.
+func StartOptimizedCleanup(repo *repository.OptimizedRepository, cfg config.OptimizedConfig, stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			CleanupOptimized(repo, cfg)
+		case <-stop:
+			return
+		}
+	}
+}
@@ -45,7 +45,11 @@ func (h *ScanHandler) ProcessTask(ctx context.Context, t *asynq.Task) error {
 	taskID := "scan:" + p.LibraryID
 	taskDesc := "Scanning: " + library.Name
 
-	log.Printf("Job: scanning library %q", library.Name)
+	if p.Deep {
+		log.Printf("Job: deep scanning library %q", library.Name)
+	} else {
+		log.Printf("Job: scanning library %q", library.Name)
+	}
 	if h.notifier != nil {
 		h.notifier.Broadcast("scan:start", map[string]string{"library_id": p.LibraryID, "name": library.Name})
 		h.notifier.Broadcast("task:update", map[string]interface{}{
@@ -84,7 +88,7 @@ func (h *ScanHandler) ProcessTask(ctx context.Context, t *asynq.Task) error {
 		}
 	}
 
-	result, err := h.scanner.ScanLibrary(library, progressFn)
+	result, err := h.scanner.ScanLibrary(library, p.Deep, progressFn)
 	if err != nil {
 		if h.notifier != nil {
 			h.notifier.Broadcast("task:update", map[string]interface{}{
@@ -105,7 +109,8 @@ func (h *ScanHandler) ProcessTask(ctx context.Context, t *asynq.Task) error {
 		}
 	}
 
-	log.Printf("Job: scan complete - %d found, %d added", result.FilesFound, result.FilesAdded)
+	log.Printf("Job: scan complete - %d found, %d added, %d changed, %d moved, %d removed, %d unchanged",
+		result.FilesFound, result.FilesAdded, result.FilesChanged, result.FilesUpdated, result.FilesRemoved, result.FilesSkipped)
 	if h.notifier != nil {
 		h.notifier.Broadcast("scan:complete", map[string]interface{}{
 			"library_id": p.LibraryID,
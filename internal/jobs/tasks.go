@@ -13,6 +13,7 @@ import (
 
 type ScanPayload struct {
 	LibraryID string `json:"library_id"`
+	Deep      bool   `json:"deep,omitempty"` // ignore the file index and re-probe every file
 }
 
 type FingerprintPayload struct {
@@ -49,6 +50,10 @@ type DetectSegmentsPayload struct {
 	LibraryID string `json:"library_id"`
 }
 
+type OptimizePayload struct {
+	VersionID string `json:"version_id"`
+}
+
 type EventNotifier interface {
 	Broadcast(event string, data interface{})
 }
@@ -59,7 +64,7 @@ func RegisterHandlers(q *Queue, sc *scanner.Scanner, libRepo *repository.Library
 	mediaRepo *repository.MediaRepository, jobRepo *repository.JobRepository,
 	fp *fingerprint.Fingerprinter, notifier EventNotifier,
 	scrapers []metadata.Scraper, settingsRepo *repository.SettingsRepository, cfg *config.Config,
-	det *detection.Detector, segRepo *repository.SegmentRepository) {
+	det *detection.Detector, segRepo *repository.SegmentRepository, optRepo *repository.OptimizedRepository) {
 
 	q.RegisterHandler(TaskScanLibrary, NewScanHandler(sc, libRepo, jobRepo, settingsRepo, q, notifier))
 	q.RegisterHandler(TaskFingerprint, NewFingerprintHandler(mediaRepo))
@@ -71,4 +76,5 @@ func RegisterHandlers(q *Queue, sc *scanner.Scanner, libRepo *repository.Library
 	q.RegisterHandler(TaskMetadataRefresh, NewMetadataRefreshHandler(mediaRepo, libRepo, settingsRepo, scrapers, cfg, sc, notifier))
 	q.RegisterHandler(TaskDetectSegments, NewDetectSegmentsHandler(det, segRepo, libRepo, notifier))
 	q.RegisterHandler(TaskLoudnessLibrary, NewLoudnessLibraryHandler(mediaRepo, libRepo, notifier, cfg.FFmpeg.FFmpegPath))
+	q.RegisterHandler(TaskOptimizeMedia, NewOptimizeHandler(optRepo, mediaRepo, cfg, notifier))
 }
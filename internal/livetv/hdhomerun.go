@@ -0,0 +1,85 @@
+package livetv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// hdhrDiscover is the subset of an HDHomeRun discover.json response we use.
+type hdhrDiscover struct {
+	FriendlyName string
+	TunerCount   int
+	LineupURL    string
+}
+
+// hdhrLineupEntry is one row of an HDHomeRun lineup.json.
+type hdhrLineupEntry struct {
+	GuideNumber string
+	GuideName   string
+	URL         string
+	DRM         int
+}
+
+// ParseLineup reads an HDHomeRun lineup.json document. DRM-protected
+// channels are dropped because they cannot be streamed.
+func ParseLineup(r io.Reader) ([]GuideChannel, error) {
+	var entries []hdhrLineupEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("lineup.json: %w", err)
+	}
+	var channels []GuideChannel
+	for _, e := range entries {
+		if e.DRM != 0 || e.GuideNumber == "" {
+			continue
+		}
+		channels = append(channels, GuideChannel{
+			GuideID:      e.GuideNumber,
+			Number:       e.GuideNumber,
+			Name:         e.GuideName,
+			DisplayNames: []string{e.GuideName},
+			StreamURL:    e.URL,
+		})
+	}
+	return channels, nil
+}
+
+// FetchHDHomeRun reads the device's discover.json for its tuner count and then
+// its channel lineup. baseURL may also point straight at a lineup.json, in
+// which case the tuner count is reported as 0 (unknown).
+func FetchHDHomeRun(ctx context.Context, client *http.Client, baseURL string) ([]GuideChannel, int, error) {
+	if strings.HasSuffix(strings.ToLower(baseURL), ".json") {
+		body, err := openSource(ctx, client, baseURL)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer body.Close()
+		channels, err := ParseLineup(body)
+		return channels, 0, err
+	}
+
+	base := strings.TrimRight(baseURL, "/")
+	lineupURL := base + "/lineup.json"
+	tunerCount := 0
+	if body, err := openSource(ctx, client, base+"/discover.json"); err == nil {
+		var d hdhrDiscover
+		if json.NewDecoder(body).Decode(&d) == nil {
+			tunerCount = d.TunerCount
+			if d.LineupURL != "" {
+				lineupURL = d.LineupURL
+			}
+		}
+		body.Close()
+	}
+
+	body, err := openSource(ctx, client, lineupURL)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer body.Close()
+	channels, err := ParseLineup(body)
+	return channels, tunerCount, err
+}
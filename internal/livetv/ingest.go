@@ -0,0 +1,367 @@
+package livetv
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Ingester fills epg_channels and epg_programs from each tuner's channel
+// source (HDHomeRun lineup or M3U playlist) and XMLTV guide.
+type Ingester struct {
+	db     *sql.DB
+	client *http.Client
+}
+
+func NewIngester(db *sql.DB) *Ingester {
+	return &Ingester{db: db, client: &http.Client{Timeout: 2 * time.Minute}}
+}
+
+// RefreshResult summarises one tuner refresh.
+type RefreshResult struct {
+	Channels       int `json:"channels"`
+	MappedChannels int `json:"mapped_channels"`
+	Programs       int `json:"programs"`
+}
+
+type tunerRow struct {
+	id         uuid.UUID
+	name       string
+	deviceType string
+	url        string
+	guideURL   string
+}
+
+// RefreshTuner reloads the channel lineup and guide for one tuner. Errors are
+// also recorded on the tuner row so the settings page can show them.
+func (g *Ingester) RefreshTuner(ctx context.Context, tunerID uuid.UUID) (*RefreshResult, error) {
+	var t tunerRow
+	var guideURL sql.NullString
+	err := g.db.QueryRow("SELECT id, name, device_type, url, guide_url FROM tuner_devices WHERE id = $1", tunerID).
+		Scan(&t.id, &t.name, &t.deviceType, &t.url, &guideURL)
+	if err != nil {
+		return nil, fmt.Errorf("tuner not found: %w", err)
+	}
+	t.guideURL = guideURL.String
+
+	res, err := g.refresh(ctx, t)
+	if err != nil {
+		g.db.Exec("UPDATE tuner_devices SET guide_error = $2, guide_refreshed_at = NOW() WHERE id = $1", t.id, err.Error())
+		log.Printf("[epg] %s: refresh failed: %v", t.name, err)
+		return nil, err
+	}
+	g.db.Exec("UPDATE tuner_devices SET guide_error = NULL, guide_refreshed_at = NOW(), channel_count = $2 WHERE id = $1",
+		t.id, res.Channels)
+	log.Printf("[epg] %s: %d channels (%d with guide data), %d programmes", t.name, res.Channels, res.MappedChannels, res.Programs)
+	return res, nil
+}
+
+// RefreshDue refreshes every active tuner whose guide is older than maxAge.
+// Returns the number of tuners refreshed successfully.
+func (g *Ingester) RefreshDue(ctx context.Context, maxAge time.Duration) int {
+	rows, err := g.db.Query(`SELECT id FROM tuner_devices WHERE is_active = TRUE
+		AND (guide_refreshed_at IS NULL OR guide_refreshed_at < $1)`, time.Now().Add(-maxAge))
+	if err != nil {
+		log.Printf("[epg] query tuners: %v", err)
+		return 0
+	}
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	refreshed := 0
+	for _, id := range ids {
+		if _, err := g.RefreshTuner(ctx, id); err == nil {
+			refreshed++
+		}
+	}
+	return refreshed
+}
+
+// Prune deletes programmes that ended more than retention ago.
+func (g *Ingester) Prune(retention time.Duration) (int64, error) {
+	result, err := g.db.Exec("DELETE FROM epg_programs WHERE end_time < $1", time.Now().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (g *Ingester) refresh(ctx context.Context, t tunerRow) (*RefreshResult, error) {
+	// ── Channel lineup ──
+	var channels []GuideChannel
+	guideURL := t.guideURL
+	switch t.deviceType {
+	case "hdhomerun":
+		ch, tunerCount, err := FetchHDHomeRun(ctx, g.client, t.url)
+		if err != nil {
+			return nil, err
+		}
+		channels = ch
+		if tunerCount > 0 {
+			g.db.Exec("UPDATE tuner_devices SET tuner_count = $2 WHERE id = $1", t.id, tunerCount)
+		}
+	default: // m3u / iptv playlists
+		body, err := openSource(ctx, g.client, t.url)
+		if err != nil {
+			return nil, err
+		}
+		ch, advertised := ParseM3U(body)
+		body.Close()
+		channels = ch
+		if guideURL == "" {
+			guideURL = advertised
+		}
+	}
+	if len(channels) == 0 {
+		return nil, fmt.Errorf("no channels found at %s", t.url)
+	}
+
+	dbChannels, err := g.upsertChannels(t.id, channels)
+	if err != nil {
+		return nil, err
+	}
+	res := &RefreshResult{Channels: len(dbChannels)}
+
+	// ── Guide ──
+	if guideURL == "" {
+		return res, nil
+	}
+	body, err := openSource(ctx, g.client, guideURL)
+	if err != nil {
+		return nil, fmt.Errorf("guide: %w", err)
+	}
+	guideChannels, programs, err := ParseXMLTV(body, time.Now().Add(-6*time.Hour))
+	body.Close()
+	if err != nil && len(programs) == 0 {
+		return nil, err
+	}
+
+	mapping := mapGuideChannels(dbChannels, guideChannels)
+	g.saveGuideIDs(dbChannels, mapping)
+
+	byChannel := make(map[uuid.UUID][]GuideProgram)
+	for _, p := range programs {
+		for _, chID := range mapping[p.ChannelGuideID] {
+			byChannel[chID] = append(byChannel[chID], p)
+		}
+	}
+	// Channels without a guide-side <channel> entry can still match by tvg-id
+	for _, c := range dbChannels {
+		if c.guideID == "" || len(byChannel[c.id]) > 0 {
+			continue
+		}
+		for _, p := range programs {
+			if p.ChannelGuideID == c.guideID {
+				byChannel[c.id] = append(byChannel[c.id], p)
+			}
+		}
+	}
+
+	res.MappedChannels = len(byChannel)
+	for chID, progs := range byChannel {
+		n, err := g.replacePrograms(chID, progs)
+		if err != nil {
+			return nil, err
+		}
+		res.Programs += n
+	}
+	return res, nil
+}
+
+type dbChannel struct {
+	id      uuid.UUID
+	number  string
+	name    string
+	guideID string
+}
+
+// upsertChannels inserts or updates the tuner's channels keyed by number.
+// Channels that disappeared from the lineup are kept so existing recordings
+// and favorites stay attached.
+func (g *Ingester) upsertChannels(tunerID uuid.UUID, channels []GuideChannel) ([]dbChannel, error) {
+	var out []dbChannel
+	seen := make(map[string]bool)
+	for _, c := range channels {
+		if seen[c.Number] {
+			continue
+		}
+		seen[c.Number] = true
+		var id uuid.UUID
+		err := g.db.QueryRow(`INSERT INTO epg_channels (tuner_id, channel_number, name, icon_url, stream_url, guide_id)
+			VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''))
+			ON CONFLICT (tuner_id, channel_number) DO UPDATE SET
+				name = EXCLUDED.name,
+				icon_url = COALESCE(EXCLUDED.icon_url, epg_channels.icon_url),
+				stream_url = COALESCE(EXCLUDED.stream_url, epg_channels.stream_url),
+				guide_id = COALESCE(EXCLUDED.guide_id, epg_channels.guide_id)
+			RETURNING id`, tunerID, c.Number, c.Name, c.IconURL, c.StreamURL, c.GuideID).Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("save channel %s: %w", c.Number, err)
+		}
+		out = append(out, dbChannel{id: id, number: c.Number, name: c.Name, guideID: c.GuideID})
+	}
+	return out, nil
+}
+
+// mapGuideChannels maps XMLTV channel IDs to tuner channels: by guide ID
+// (tvg-id) first, then channel number (lcn or a numeric display-name), then
+// case-insensitive name.
+func mapGuideChannels(dbChannels []dbChannel, guide []GuideChannel) map[string][]uuid.UUID {
+	byGuideID := make(map[string][]uuid.UUID)
+	byNumber := make(map[string][]uuid.UUID)
+	byName := make(map[string][]uuid.UUID)
+	for _, c := range dbChannels {
+		if c.guideID != "" {
+			byGuideID[c.guideID] = append(byGuideID[c.guideID], c.id)
+		}
+		byNumber[c.number] = append(byNumber[c.number], c.id)
+		byName[strings.ToLower(c.name)] = append(byName[strings.ToLower(c.name)], c.id)
+	}
+
+	mapping := make(map[string][]uuid.UUID)
+	for _, gc := range guide {
+		if ids := byGuideID[gc.GuideID]; len(ids) > 0 {
+			mapping[gc.GuideID] = ids
+			continue
+		}
+		if ids := byNumber[gc.Number]; gc.Number != "" && len(ids) > 0 {
+			mapping[gc.GuideID] = ids
+			continue
+		}
+		for _, n := range gc.DisplayNames {
+			if ids := byNumber[n]; len(ids) > 0 {
+				mapping[gc.GuideID] = ids
+				break
+			}
+			if ids := byName[strings.ToLower(n)]; len(ids) > 0 {
+				mapping[gc.GuideID] = ids
+				break
+			}
+		}
+	}
+	return mapping
+}
+
+// saveGuideIDs remembers the XMLTV ID for channels matched by number or name
+// so later refreshes (and guides without <channel> entries) map directly.
+func (g *Ingester) saveGuideIDs(dbChannels []dbChannel, mapping map[string][]uuid.UUID) {
+	current := make(map[uuid.UUID]string)
+	for _, c := range dbChannels {
+		current[c.id] = c.guideID
+	}
+	for guideID, ids := range mapping {
+		for _, id := range ids {
+			if current[id] == "" {
+				g.db.Exec("UPDATE epg_channels SET guide_id = $2 WHERE id = $1", id, guideID)
+			}
+		}
+	}
+}
+
+// replacePrograms upserts a channel's programmes by start time and removes
+// programmes inside the refreshed window that the guide no longer lists.
+// Unchanged programmes keep their IDs so scheduled recordings stay linked.
+func (g *Ingester) replacePrograms(channelID uuid.UUID, progs []GuideProgram) (int, error) {
+	tx, err := g.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO epg_programs (channel_id, title, sub_title, description, start_time, end_time,
+			category, episode_info, icon_url, external_id, series_id, is_new)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6, NULLIF($7, ''), NULLIF($8, ''), NULLIF($9, ''),
+			NULLIF($10, ''), NULLIF($11, ''), $12)
+		ON CONFLICT (channel_id, start_time) DO UPDATE SET
+			title = EXCLUDED.title, sub_title = EXCLUDED.sub_title, description = EXCLUDED.description,
+			end_time = EXCLUDED.end_time, category = EXCLUDED.category, episode_info = EXCLUDED.episode_info,
+			icon_url = EXCLUDED.icon_url, external_id = EXCLUDED.external_id, series_id = EXCLUDED.series_id,
+			is_new = EXCLUDED.is_new`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var starts []time.Time
+	first, last := progs[0].Start, progs[0].Start
+	for _, p := range progs {
+		if _, err := stmt.Exec(channelID, p.Title, p.SubTitle, p.Description, p.Start, p.Stop,
+			p.Category, p.EpisodeInfo, p.IconURL, p.ExternalID, p.SeriesID, p.IsNew); err != nil {
+			return 0, err
+		}
+		starts = append(starts, p.Start)
+		if p.Start.Before(first) {
+			first = p.Start
+		}
+		if p.Start.After(last) {
+			last = p.Start
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM epg_programs WHERE channel_id = $1 AND start_time BETWEEN $2 AND $3
+		AND NOT (start_time = ANY($4))`, channelID, first, last, pq.Array(starts)); err != nil {
+		return 0, err
+	}
+	return len(progs), tx.Commit()
+}
+
+// openSource opens an http(s) URL or local file, transparently gunzipping
+// compressed guides.
+func openSource(ctx context.Context, client *http.Client, loc string) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	if strings.HasPrefix(loc, "http://") || strings.HasPrefix(loc, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, loc, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", "CineVault")
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: HTTP %d", loc, resp.StatusCode)
+		}
+		rc = resp.Body
+	} else {
+		f, err := os.Open(strings.TrimPrefix(loc, "file://"))
+		if err != nil {
+			return nil, err
+		}
+		rc = f
+	}
+
+	br := bufio.NewReader(rc)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{gz, rc}, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{br, rc}, nil
+}
@@ -0,0 +1,103 @@
+package livetv
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var m3uAttrRe = regexp.MustCompile(`([A-Za-z0-9_-]+)="([^"]*)"`)
+
+// ParseM3U reads an M3U/M3U8 channel playlist using the IPTV attribute
+// conventions (tvg-id, tvg-name, tvg-logo, tvg-chno). The second return value
+// is the guide URL advertised in the #EXTM3U header (url-tvg / x-tvg-url).
+// Channels without a tvg-chno are numbered by position.
+func ParseM3U(r io.Reader) ([]GuideChannel, string) {
+	var channels []GuideChannel
+	var guideURL string
+	var pending *GuideChannel
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(sc.Text(), "\ufeff"))
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#EXTM3U"):
+			attrs := m3uAttrs(line)
+			for _, k := range []string{"url-tvg", "x-tvg-url", "tvg-url"} {
+				if v := attrs[k]; v != "" {
+					// Some playlists list several guides separated by commas
+					guideURL, _, _ = strings.Cut(v, ",")
+					break
+				}
+			}
+		case strings.HasPrefix(line, "#EXTINF"):
+			attrs := m3uAttrs(line)
+			name := ""
+			if i := firstUnquotedComma(line); i >= 0 {
+				name = strings.TrimSpace(line[i+1:])
+			}
+			if name == "" {
+				name = attrs["tvg-name"]
+			}
+			pending = &GuideChannel{
+				GuideID: attrs["tvg-id"],
+				Number:  attrs["tvg-chno"],
+				Name:    name,
+				IconURL: attrs["tvg-logo"],
+			}
+			if pending.Number == "" {
+				pending.Number = attrs["channel-number"]
+			}
+			if n := attrs["tvg-name"]; n != "" && n != name {
+				pending.DisplayNames = append(pending.DisplayNames, n)
+			}
+		case strings.HasPrefix(line, "#"):
+			continue
+		default:
+			if pending == nil {
+				continue
+			}
+			pending.StreamURL = line
+			if pending.Number == "" {
+				pending.Number = strconv.Itoa(len(channels) + 1)
+			}
+			if pending.Name == "" {
+				pending.Name = "Channel " + pending.Number
+			}
+			pending.DisplayNames = append([]string{pending.Name}, pending.DisplayNames...)
+			channels = append(channels, *pending)
+			pending = nil
+		}
+	}
+	return channels, guideURL
+}
+
+func m3uAttrs(line string) map[string]string {
+	attrs := make(map[string]string)
+	for _, m := range m3uAttrRe.FindAllStringSubmatch(line, -1) {
+		attrs[strings.ToLower(m[1])] = m[2]
+	}
+	return attrs
+}
+
+// firstUnquotedComma finds the comma that separates #EXTINF attributes from
+// the display name; commas inside quoted attribute values don't count.
+func firstUnquotedComma(line string) int {
+	inQuote := false
+	for i, r := range line {
+		switch r {
+		case '"':
+			inQuote = !inQuote
+		case ',':
+			if !inQuote {
+				return i
+			}
+		}
+	}
+	return -1
+}
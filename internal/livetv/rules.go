@@ -0,0 +1,127 @@
+package livetv
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/JustinTDCT/CineVault/internal/repository"
+	"github.com/google/uuid"
+)
+
+// SeriesRules turns DVR series rules into scheduled recordings and enforces
+// each rule's keep_count.
+type SeriesRules struct {
+	dvrRepo   *repository.DVRRepository
+	mediaRepo *repository.MediaRepository
+	mu        sync.Mutex
+}
+
+func NewSeriesRules(dvrRepo *repository.DVRRepository, mediaRepo *repository.MediaRepository) *SeriesRules {
+	return &SeriesRules{dvrRepo: dvrRepo, mediaRepo: mediaRepo}
+}
+
+// Apply matches every active rule against the upcoming guide and schedules
+// programmes that aren't already recorded. Airings that would exceed the
+// tuner's capacity are skipped; a later airing of the same episode may still
+// be picked up. Returns the number of recordings scheduled.
+func (sr *SeriesRules) Apply() int {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	now := time.Now()
+	if err := sr.dvrRepo.SyncRuleSchedules(now); err != nil {
+		log.Printf("[dvr] sync rule schedules: %v", err)
+	}
+	rules, err := sr.dvrRepo.ListActiveRules()
+	if err != nil {
+		log.Printf("[dvr] list rules: %v", err)
+		return 0
+	}
+	scheduled := 0
+	for _, rule := range rules {
+		scheduled += sr.applyRule(rule, now)
+	}
+	if scheduled > 0 {
+		log.Printf("[dvr] series rules scheduled %d recordings", scheduled)
+	}
+	return scheduled
+}
+
+// ApplyRule schedules matches for a single rule, e.g. right after it is created.
+func (sr *SeriesRules) ApplyRule(rule *models.DVRRule) int {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return sr.applyRule(rule, time.Now())
+}
+
+func (sr *SeriesRules) applyRule(rule *models.DVRRule, now time.Time) int {
+	candidates, err := sr.dvrRepo.MatchRule(rule, now)
+	if err != nil {
+		log.Printf("[dvr] match rule %q: %v", rule.Title, err)
+		return 0
+	}
+	scheduled := 0
+	for _, c := range candidates {
+		dup, err := sr.dvrRepo.IsDuplicate(rule.UserID, c)
+		if err != nil || dup {
+			continue
+		}
+		start := c.StartTime.Add(-time.Duration(rule.PadStartMinutes) * time.Minute)
+		end := c.EndTime.Add(time.Duration(rule.PadEndMinutes) * time.Minute)
+		if c.TunerCount > 0 {
+			peak, err := sr.dvrRepo.PeakOverlap(c.TunerID, start, end, uuid.Nil)
+			if err != nil || peak >= c.TunerCount {
+				continue
+			}
+		}
+		if err := sr.dvrRepo.ScheduleFromRule(rule, c, start, end); err != nil {
+			log.Printf("[dvr] schedule %q at %s: %v", c.Title, c.StartTime.Format(time.RFC3339), err)
+			continue
+		}
+		scheduled++
+	}
+	return scheduled
+}
+
+// Expire deletes completed recordings beyond each rule's keep_count, oldest
+// first, removing the file and its library item. Paused rules are trimmed
+// too.
+func (sr *SeriesRules) Expire() int {
+	rules, err := sr.dvrRepo.ListKeepRules()
+	if err != nil {
+		log.Printf("[dvr] list rules: %v", err)
+		return 0
+	}
+	expired := 0
+	for _, rule := range rules {
+		if rule.KeepCount <= 0 {
+			continue
+		}
+		recs, err := sr.dvrRepo.ExpiredForRule(rule)
+		if err != nil {
+			log.Printf("[dvr] expire rule %q: %v", rule.Title, err)
+			continue
+		}
+		for _, rec := range recs {
+			if rec.FilePath != nil && *rec.FilePath != "" {
+				if err := os.Remove(*rec.FilePath); err != nil && !os.IsNotExist(err) {
+					log.Printf("[dvr] expire %s: %v", *rec.FilePath, err)
+					continue
+				}
+				if err := sr.mediaRepo.MarkUnavailable(*rec.FilePath); err != nil {
+					log.Printf("[dvr] remove media item for %s: %v", *rec.FilePath, err)
+				}
+			}
+			if err := sr.dvrRepo.MarkExpired(rec.ID); err == nil {
+				expired++
+			}
+		}
+	}
+	if expired > 0 {
+		log.Printf("[dvr] expired %d old recordings", expired)
+	}
+	return expired
+}
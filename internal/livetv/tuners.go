@@ -0,0 +1,44 @@
+package livetv
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// TunerPool tracks how many streams each tuner device has open so recordings
+// (and live viewers) never exceed the device's tuner count.
+type TunerPool struct {
+	mu    sync.Mutex
+	inUse map[uuid.UUID]int
+}
+
+func NewTunerPool() *TunerPool {
+	return &TunerPool{inUse: make(map[uuid.UUID]int)}
+}
+
+// Acquire reserves one tuner on the device if fewer than limit are in use.
+// A limit of 0 or less means unlimited.
+func (p *TunerPool) Acquire(tunerID uuid.UUID, limit int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if limit > 0 && p.inUse[tunerID] >= limit {
+		return false
+	}
+	p.inUse[tunerID]++
+	return true
+}
+
+func (p *TunerPool) Release(tunerID uuid.UUID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.inUse[tunerID] > 0 {
+		p.inUse[tunerID]--
+	}
+}
+
+func (p *TunerPool) InUse(tunerID uuid.UUID) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.inUse[tunerID]
+}
@@ -0,0 +1,202 @@
+package livetv
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GuideChannel is a channel as described by a guide source (XMLTV <channel>,
+// M3U entry or HDHomeRun lineup row).
+type GuideChannel struct {
+	GuideID      string // XMLTV channel id / tvg-id
+	Number       string // channel number (lcn, tvg-chno, GuideNumber)
+	Name         string
+	DisplayNames []string // every XMLTV display-name, used for mapping
+	IconURL      string
+	StreamURL    string
+}
+
+// GuideProgram is one XMLTV <programme>.
+type GuideProgram struct {
+	ChannelGuideID string
+	Title          string
+	SubTitle       string
+	Description    string
+	Start          time.Time
+	Stop           time.Time
+	Category       string
+	EpisodeInfo    string // "S01E02" when known, else the onscreen text
+	ExternalID     string // dd_progid or similar stable programme ID
+	SeriesID       string // series part of the programme ID, when derivable
+	IconURL        string
+	IsNew          bool
+}
+
+type xmltvChannel struct {
+	ID           string   `xml:"id,attr"`
+	DisplayNames []string `xml:"display-name"`
+	LCN          string   `xml:"lcn"`
+	Icon         struct {
+		Src string `xml:"src,attr"`
+	} `xml:"icon"`
+}
+
+type xmltvProgramme struct {
+	Start      string   `xml:"start,attr"`
+	Stop       string   `xml:"stop,attr"`
+	Channel    string   `xml:"channel,attr"`
+	Title      []string `xml:"title"`
+	SubTitle   string   `xml:"sub-title"`
+	Desc       string   `xml:"desc"`
+	Categories []string `xml:"category"`
+	EpisodeNum []struct {
+		System string `xml:"system,attr"`
+		Value  string `xml:",chardata"`
+	} `xml:"episode-num"`
+	Icon struct {
+		Src string `xml:"src,attr"`
+	} `xml:"icon"`
+	New *struct{} `xml:"new"`
+}
+
+// ParseXMLTV streams an XMLTV document. Programmes ending before since are
+// skipped so large multi-week guides don't load history into memory.
+func ParseXMLTV(r io.Reader, since time.Time) ([]GuideChannel, []GuideProgram, error) {
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		// Most guides are UTF-8 or a Latin-1 label on ASCII content
+		return input, nil
+	}
+
+	var channels []GuideChannel
+	var programs []GuideProgram
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return channels, programs, fmt.Errorf("xmltv: %w", err)
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch se.Name.Local {
+		case "channel":
+			var c xmltvChannel
+			if err := dec.DecodeElement(&c, &se); err != nil {
+				continue
+			}
+			gc := GuideChannel{GuideID: c.ID, Number: strings.TrimSpace(c.LCN), IconURL: c.Icon.Src}
+			for _, n := range c.DisplayNames {
+				if n = strings.TrimSpace(n); n != "" {
+					gc.DisplayNames = append(gc.DisplayNames, n)
+				}
+			}
+			if len(gc.DisplayNames) > 0 {
+				gc.Name = gc.DisplayNames[0]
+			}
+			channels = append(channels, gc)
+		case "programme":
+			var p xmltvProgramme
+			if err := dec.DecodeElement(&p, &se); err != nil {
+				continue
+			}
+			start, err1 := ParseXMLTVTime(p.Start)
+			stop, err2 := ParseXMLTVTime(p.Stop)
+			if err1 != nil || len(p.Title) == 0 {
+				continue
+			}
+			if err2 != nil || !stop.After(start) {
+				stop = start.Add(30 * time.Minute)
+			}
+			if stop.Before(since) {
+				continue
+			}
+			gp := GuideProgram{
+				ChannelGuideID: p.Channel,
+				Title:          strings.TrimSpace(p.Title[0]),
+				SubTitle:       strings.TrimSpace(p.SubTitle),
+				Description:    strings.TrimSpace(p.Desc),
+				Start:          start,
+				Stop:           stop,
+				IconURL:        p.Icon.Src,
+				IsNew:          p.New != nil,
+			}
+			if len(p.Categories) > 0 {
+				gp.Category = strings.TrimSpace(p.Categories[0])
+			}
+			for _, en := range p.EpisodeNum {
+				v := strings.TrimSpace(en.Value)
+				switch en.System {
+				case "xmltv_ns":
+					if s := xmltvNSToEpisode(v); s != "" {
+						gp.EpisodeInfo = s
+					}
+				case "onscreen":
+					if gp.EpisodeInfo == "" {
+						gp.EpisodeInfo = v
+					}
+				case "dd_progid":
+					// "EP01234567.0002" — series is the part before the dot
+					gp.ExternalID = v
+					if i := strings.Index(v, "."); i > 0 {
+						gp.SeriesID = v[:i]
+					}
+				}
+			}
+			programs = append(programs, gp)
+		}
+	}
+	return channels, programs, nil
+}
+
+// ParseXMLTVTime parses "20061016200000 +0000" and its shorter forms. Times
+// without an offset are UTC, as the XMLTV DTD specifies.
+func ParseXMLTVTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	stamp, offset, _ := strings.Cut(s, " ")
+	if len(stamp) > 14 {
+		stamp = stamp[:14]
+	}
+	layouts := map[int]string{14: "20060102150405", 12: "200601021504", 10: "2006010215", 8: "20060102"}
+	layout, ok := layouts[len(stamp)]
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid xmltv time %q", s)
+	}
+	if offset != "" {
+		return time.Parse(layout+" -0700", stamp+" "+offset)
+	}
+	return time.Parse(layout, stamp)
+}
+
+// xmltvNSToEpisode turns xmltv_ns "season.episode.part" (zero-based, with
+// optional "/total") into "S01E02". Returns "" when no episode is given.
+func xmltvNSToEpisode(v string) string {
+	parts := strings.Split(v, ".")
+	if len(parts) < 2 {
+		return ""
+	}
+	num := func(p string) (int, bool) {
+		p, _, _ = strings.Cut(strings.TrimSpace(p), "/")
+		if p == "" {
+			return 0, false
+		}
+		n, err := strconv.Atoi(p)
+		return n, err == nil
+	}
+	ep, ok := num(parts[1])
+	if !ok {
+		return ""
+	}
+	if season, ok := num(parts[0]); ok {
+		return fmt.Sprintf("S%02dE%02d", season+1, ep+1)
+	}
+	return fmt.Sprintf("E%02d", ep+1)
+}
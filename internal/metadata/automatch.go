@@ -17,10 +17,11 @@ import (
 	"github.com/JustinTDCT/CineVault/internal/repository"
 )
 
-const (
-	// scraperDelay prevents hammering external APIs
-	scraperDelay = 300 * time.Millisecond
+// scraperDelay prevents hammering external APIs (a variable so tests can
+// drop it)
+var scraperDelay = 300 * time.Millisecond
 
+const (
 	// DefaultAutoMinMatch is the default minimum confidence for automatic indexing (95%)
 	DefaultAutoMinMatch = 0.95
 	// DefaultManualMinMatch is the default minimum confidence for manual matching (75%)
@@ -86,7 +87,12 @@ func FindTopMatches(scrapers []Scraper, query string, mediaType models.MediaType
 	if len(applicable) == 0 {
 		return nil
 	}
+	return topMatches(applicable, query, mediaType, cfg, itemYear...)
+}
 
+// topMatches is FindTopMatches over the given scrapers, whatever their
+// media types.
+func topMatches(applicable []Scraper, query string, mediaType models.MediaType, cfg MatchConfig, itemYear ...*int) []*models.MetadataMatch {
 	var yearHint *int
 	if len(itemYear) > 0 {
 		yearHint = itemYear[0]
@@ -0,0 +1,372 @@
+package metadata
+
+import (
+	"log"
+	"time"
+
+	"github.com/JustinTDCT/CineVault/internal/models"
+)
+
+// ──────────────────── Field-level Merge ────────────────────
+
+// A library can take each field of a match from a different provider:
+// the title from TMDB, the rating from OMDb, artwork from fanart.tv. The
+// first provider to find the title identifies it (source, IDs,
+// confidence); the others are looked up by its IDs or, failing that, by
+// title, and each field is taken from the first provider in that field's
+// precedence that has it. Provenance records where every field came from.
+
+// Field names for per-field precedence.
+const (
+	FieldTitle            = "title"
+	FieldOriginalTitle    = "original_title"
+	FieldYear             = "year"
+	FieldReleaseDate      = "release_date"
+	FieldDescription      = "description"
+	FieldTagline          = "tagline"
+	FieldPoster           = "poster"
+	FieldBackdrop         = "backdrop"
+	FieldRating           = "rating"
+	FieldGenres           = "genres"
+	FieldContentRating    = "content_rating"
+	FieldOriginalLanguage = "original_language"
+	FieldCountry          = "country"
+	FieldTrailer          = "trailer"
+	FieldKeywords         = "keywords"
+
+	// FieldArtwork sets the precedence of poster and backdrop together.
+	FieldArtwork = "artwork"
+)
+
+// MergeFields are the fields a merged match takes from its providers.
+var MergeFields = []string{
+	FieldTitle, FieldOriginalTitle, FieldYear, FieldReleaseDate, FieldDescription,
+	FieldTagline, FieldPoster, FieldBackdrop, FieldRating, FieldGenres,
+	FieldContentRating, FieldOriginalLanguage, FieldCountry, FieldTrailer, FieldKeywords,
+}
+
+// IsMergeField reports whether name can be given a per-field precedence.
+func IsMergeField(name string) bool {
+	if name == FieldArtwork {
+		return true
+	}
+	for _, f := range MergeFields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldSources maps a field to the providers it prefers, in order.
+// Providers not listed follow in the order of the matches.
+type FieldSources map[string][]string
+
+// sourcesFor is the precedence set for a field, falling back to the
+// artwork setting for poster and backdrop.
+func (fs FieldSources) sourcesFor(field string) []string {
+	if sources, ok := fs[field]; ok {
+		return sources
+	}
+	if field == FieldPoster || field == FieldBackdrop {
+		return fs[FieldArtwork]
+	}
+	return nil
+}
+
+// mergeField reads and copies one field of a match.
+type mergeField struct {
+	has  func(m *models.MetadataMatch) bool
+	copy func(dst, src *models.MetadataMatch)
+}
+
+func hasString(s *string) bool { return s != nil && *s != "" }
+
+var mergeFieldFuncs = map[string]mergeField{
+	FieldTitle: {
+		func(m *models.MetadataMatch) bool { return m.Title != "" },
+		func(dst, src *models.MetadataMatch) { dst.Title = src.Title },
+	},
+	FieldOriginalTitle: {
+		func(m *models.MetadataMatch) bool { return hasString(m.OriginalTitle) },
+		func(dst, src *models.MetadataMatch) { dst.OriginalTitle = src.OriginalTitle },
+	},
+	FieldYear: {
+		func(m *models.MetadataMatch) bool { return m.Year != nil && *m.Year > 0 },
+		func(dst, src *models.MetadataMatch) { dst.Year = src.Year },
+	},
+	FieldReleaseDate: {
+		func(m *models.MetadataMatch) bool { return hasString(m.ReleaseDate) },
+		func(dst, src *models.MetadataMatch) { dst.ReleaseDate = src.ReleaseDate },
+	},
+	FieldDescription: {
+		func(m *models.MetadataMatch) bool { return hasString(m.Description) },
+		func(dst, src *models.MetadataMatch) { dst.Description = src.Description },
+	},
+	FieldTagline: {
+		func(m *models.MetadataMatch) bool { return hasString(m.Tagline) },
+		func(dst, src *models.MetadataMatch) { dst.Tagline = src.Tagline },
+	},
+	FieldPoster: {
+		func(m *models.MetadataMatch) bool { return hasString(m.PosterURL) },
+		func(dst, src *models.MetadataMatch) { dst.PosterURL = src.PosterURL },
+	},
+	FieldBackdrop: {
+		func(m *models.MetadataMatch) bool { return hasString(m.BackdropURL) },
+		func(dst, src *models.MetadataMatch) { dst.BackdropURL = src.BackdropURL },
+	},
+	FieldRating: {
+		// 0 is what TMDB reports for unrated titles
+		func(m *models.MetadataMatch) bool { return m.Rating != nil && *m.Rating > 0 },
+		func(dst, src *models.MetadataMatch) { dst.Rating = src.Rating },
+	},
+	FieldGenres: {
+		func(m *models.MetadataMatch) bool { return len(m.Genres) > 0 },
+		func(dst, src *models.MetadataMatch) { dst.Genres = src.Genres },
+	},
+	FieldContentRating: {
+		func(m *models.MetadataMatch) bool { return hasString(m.ContentRating) },
+		func(dst, src *models.MetadataMatch) { dst.ContentRating = src.ContentRating },
+	},
+	FieldOriginalLanguage: {
+		func(m *models.MetadataMatch) bool { return hasString(m.OriginalLanguage) },
+		func(dst, src *models.MetadataMatch) { dst.OriginalLanguage = src.OriginalLanguage },
+	},
+	FieldCountry: {
+		func(m *models.MetadataMatch) bool { return hasString(m.Country) },
+		func(dst, src *models.MetadataMatch) { dst.Country = src.Country },
+	},
+	FieldTrailer: {
+		func(m *models.MetadataMatch) bool { return hasString(m.TrailerURL) },
+		func(dst, src *models.MetadataMatch) { dst.TrailerURL = src.TrailerURL },
+	},
+	FieldKeywords: {
+		func(m *models.MetadataMatch) bool { return len(m.Keywords) > 0 },
+		func(dst, src *models.MetadataMatch) { dst.Keywords = src.Keywords },
+	},
+}
+
+// MergeMatches merges one match per provider into one. matches[0]
+// identifies the title: the result keeps its source, IDs, confidence and
+// music/audiobook fields. Every field in MergeFields is taken from the
+// first match in the field's precedence that has it, then from the rest in
+// slice order. Nil matches are ignored.
+func MergeMatches(matches []*models.MetadataMatch, sources FieldSources) *models.MetadataMatch {
+	var present []*models.MetadataMatch
+	for _, m := range matches {
+		if m != nil {
+			present = append(present, m)
+		}
+	}
+	if len(present) == 0 {
+		return nil
+	}
+
+	merged := *present[0]
+	merged.Provenance = make(map[string]string)
+	for _, field := range MergeFields {
+		f := mergeFieldFuncs[field]
+		for _, m := range byPrecedence(present, sources.sourcesFor(field)) {
+			if f.has(m) {
+				f.copy(&merged, m)
+				merged.Provenance[field] = m.Source
+				break
+			}
+		}
+	}
+	if merged.IMDBId == "" {
+		for _, m := range present[1:] {
+			if m.IMDBId != "" {
+				merged.IMDBId = m.IMDBId
+				break
+			}
+		}
+	}
+	return &merged
+}
+
+// byPrecedence orders matches with the named sources first, in the order
+// named, then the rest in their original order.
+func byPrecedence(matches []*models.MetadataMatch, sources []string) []*models.MetadataMatch {
+	if len(sources) == 0 {
+		return matches
+	}
+	ordered := make([]*models.MetadataMatch, 0, len(matches))
+	used := make([]bool, len(matches))
+	for _, name := range sources {
+		for i, m := range matches {
+			if !used[i] && m.Source == name {
+				ordered = append(ordered, m)
+				used[i] = true
+			}
+		}
+	}
+	for i, m := range matches {
+		if !used[i] {
+			ordered = append(ordered, m)
+		}
+	}
+	return ordered
+}
+
+// mergeFieldLocks are the fields a merge can supply beyond what the
+// source's own enrichment writes, with the media item lock protecting
+// each. Keywords have none of their own; only "*" holds them.
+var mergeFieldLocks = map[string]string{
+	FieldOriginalTitle:    "title",
+	FieldReleaseDate:      "year",
+	FieldTagline:          "tagline",
+	FieldContentRating:    "content_rating",
+	FieldOriginalLanguage: "original_language",
+	FieldCountry:          "country",
+	FieldTrailer:          "trailer_url",
+	FieldGenres:           "genres",
+	FieldBackdrop:         "backdrop_path",
+	FieldKeywords:         "*",
+}
+
+// SupplementaryFields reports which of those fields a merged match took
+// from providers other than its source and item doesn't lock: what is
+// left to write once the source's enrichment is done.
+func SupplementaryFields(item *models.MediaItem, match *models.MetadataMatch) map[string]bool {
+	fields := make(map[string]bool)
+	for field, lock := range mergeFieldLocks {
+		source, ok := match.Provenance[field]
+		if !ok || source == match.Source {
+			continue
+		}
+		if item.IsFieldLocked(lock) {
+			continue
+		}
+		fields[field] = true
+	}
+	return fields
+}
+
+// DeferredFields reports which of the fields that add tags to an item
+// (genres, keywords) a merged match took from providers other than its
+// source. The source's own enrichment must leave these to the merge: it
+// only adds tags, so writing both would give the item the union.
+func DeferredFields(match *models.MetadataMatch) map[string]bool {
+	fields := make(map[string]bool)
+	if match == nil {
+		return fields
+	}
+	for _, field := range []string{FieldGenres, FieldKeywords} {
+		if source, ok := match.Provenance[field]; ok && source != match.Source {
+			fields[field] = true
+		}
+	}
+	return fields
+}
+
+// FindMergedMatch searches providers in order and merges what each knows
+// of the best match into one. The title is identified by the first
+// provider whose best result reaches minConfidence (or, if none does, the
+// best result overall, for the caller to reject). The rest contribute only
+// results that reach minConfidence and agree on the year.
+func FindMergedMatch(providers []Scraper, sources FieldSources, query string, mediaType models.MediaType, minConfidence float64, itemYear ...*int) *models.MetadataMatch {
+	var yearHint *int
+	if len(itemYear) > 0 {
+		yearHint = itemYear[0]
+	}
+
+	// Identify the title
+	primary := -1
+	var primaryMatch, bestOverall *models.MetadataMatch
+	bestOverallIdx := -1
+	for i, p := range providers {
+		best := bestSearchMatch(p, query, mediaType, yearHint)
+		if best == nil {
+			continue
+		}
+		if best.Confidence >= minConfidence {
+			primary, primaryMatch = i, best
+			break
+		}
+		if bestOverall == nil || best.Confidence > bestOverall.Confidence {
+			bestOverall, bestOverallIdx = best, i
+		}
+	}
+	if primaryMatch == nil {
+		if bestOverall == nil {
+			return nil
+		}
+		primary, primaryMatch = bestOverallIdx, bestOverall
+	}
+	primaryMatch = withDetails(providers[primary], primaryMatch, mediaType)
+	if primaryMatch.Confidence < minConfidence {
+		return primaryMatch
+	}
+
+	// Gather what the other providers have on it
+	matches := []*models.MetadataMatch{primaryMatch}
+	for i, p := range providers {
+		if i == primary {
+			continue
+		}
+		if m := supplementaryMatch(p, primaryMatch, query, mediaType, minConfidence); m != nil {
+			matches = append(matches, m)
+		}
+	}
+	merged := MergeMatches(matches, sources)
+	log.Printf("Auto-match: merged %q from %d provider(s): %v", merged.Title, len(matches), merged.Provenance)
+	return merged
+}
+
+// bestSearchMatch is a provider's highest-confidence search result, with
+// the usual year adjustments.
+func bestSearchMatch(p Scraper, query string, mediaType models.MediaType, yearHint *int) *models.MetadataMatch {
+	matches := topMatches([]Scraper{p}, query, mediaType, MatchConfig{MinConfidence: 0.1, MaxResults: 1}, yearHint)
+	if len(matches) == 0 {
+		return nil
+	}
+	return matches[0]
+}
+
+// supplementaryMatch is what a provider has on the identified title: found
+// by ID where the provider can, else by a title search that must agree.
+func supplementaryMatch(p Scraper, primary *models.MetadataMatch, query string, mediaType models.MediaType, minConfidence float64) *models.MetadataMatch {
+	if l, ok := p.(IDLookup); ok {
+		m, err := l.LookupByID(primary, mediaType)
+		time.Sleep(scraperDelay)
+		if err != nil {
+			log.Printf("Auto-match: %s lookup failed for %q: %v", p.Name(), primary.Title, err)
+		}
+		if m != nil {
+			return m
+		}
+	}
+	m := bestSearchMatch(p, query, mediaType, primary.Year)
+	if m == nil || m.Confidence < minConfidence || !yearsAgree(primary.Year, m.Year) {
+		return nil
+	}
+	return withDetails(p, m, mediaType)
+}
+
+// withDetails replaces a search result with the provider's full record of
+// it, keeping the search confidence. The result stands if that fails.
+func withDetails(p Scraper, m *models.MetadataMatch, mediaType models.MediaType) *models.MetadataMatch {
+	var details *models.MetadataMatch
+	var err error
+	if t, ok := p.(*TMDBScraper); ok && mediaType == models.MediaTypeTVShows {
+		details, err = t.GetTVDetails(m.ExternalID)
+	} else {
+		details, err = p.GetDetails(m.ExternalID)
+	}
+	if err != nil || details == nil {
+		return m
+	}
+	details.Confidence = m.Confidence
+	return details
+}
+
+// yearsAgree reports whether two release years are within a year of each
+// other, or either is unknown.
+func yearsAgree(a, b *int) bool {
+	if a == nil || b == nil {
+		return true
+	}
+	diff := *a - *b
+	return diff >= -1 && diff <= 1
+}
@@ -0,0 +1,328 @@
+package metadata
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/lib/pq"
+)
+
+// fakeScraper answers searches with canned results and records the calls
+// made to it in a shared log.
+type fakeScraper struct {
+	name    string
+	results []*models.MetadataMatch
+	err     error
+	log     *callLog
+}
+
+type callLog struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (l *callLog) add(call string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.calls = append(l.calls, call)
+	l.mu.Unlock()
+}
+
+func (f *fakeScraper) Name() string { return f.name }
+
+func (f *fakeScraper) Search(string, models.MediaType, *int) ([]*models.MetadataMatch, error) {
+	f.log.add(f.name + " search")
+	if f.err != nil {
+		return nil, f.err
+	}
+	// Callers adjust confidences in place
+	out := make([]*models.MetadataMatch, len(f.results))
+	for i, m := range f.results {
+		c := *m
+		out[i] = &c
+	}
+	return out, nil
+}
+
+func (f *fakeScraper) GetDetails(id string) (*models.MetadataMatch, error) {
+	f.log.add(f.name + " details")
+	for _, m := range f.results {
+		if m.ExternalID == id {
+			c := *m
+			return &c, nil
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+// fakeIDScraper also finds titles by another provider's IDs, like OMDb
+// and fanart.tv.
+type fakeIDScraper struct {
+	fakeScraper
+	byIMDB map[string]*models.MetadataMatch
+}
+
+func (f *fakeIDScraper) LookupByID(m *models.MetadataMatch, _ models.MediaType) (*models.MetadataMatch, error) {
+	f.log.add(f.name + " lookup")
+	if found := f.byIMDB[m.IMDBId]; found != nil {
+		c := *found
+		return &c, nil
+	}
+	return nil, nil
+}
+
+func str(s string) *string      { return &s }
+func num(n int) *int            { return &n }
+func rating(r float64) *float64 { return &r }
+
+func init() { scraperDelay = 0 }
+
+func names(scrapers []Scraper) []string {
+	var out []string
+	for _, s := range scrapers {
+		out = append(out, s.Name())
+	}
+	return out
+}
+
+func TestRegistryProviders(t *testing.T) {
+	r := NewRegistry(
+		&fakeScraper{name: ProviderTMDB},
+		&fakeScraper{name: ProviderTVDB},
+		&fakeIDScraper{fakeScraper: fakeScraper{name: ProviderOMDb}},
+		&fakeIDScraper{fakeScraper: fakeScraper{name: ProviderFanartTV}},
+	)
+	tests := []struct {
+		name    string
+		library models.Library
+		want    []string
+	}{
+		{"media type defaults", models.Library{MediaType: models.MediaTypeTVShows}, []string{"tmdb", "tvdb"}},
+		{"library order, unknown skipped",
+			models.Library{MediaType: models.MediaTypeMovies, MetadataProviders: pq.StringArray{"omdb", "musicbrainz", "tmdb"}},
+			[]string{"omdb", "tmdb"}},
+		{"field sources add their providers last",
+			models.Library{MediaType: models.MediaTypeMovies, MetadataFieldSources: map[string][]string{
+				FieldRating: {"omdb", "tmdb"}, FieldArtwork: {"fanarttv"}}},
+			[]string{"tmdb", "fanarttv", "omdb"}},
+		{"field sources already listed aren't repeated",
+			models.Library{MediaType: models.MediaTypeMovies, MetadataProviders: pq.StringArray{"tvdb", "omdb"},
+				MetadataFieldSources: map[string][]string{FieldRating: {"omdb"}}},
+			[]string{"tvdb", "omdb"}},
+	}
+	for _, tt := range tests {
+		if got := names(r.Providers(&tt.library)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: providers %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// testProviders are a TMDB that finds the title but has no rating or
+// tagline, an OMDb with both, and a fanart.tv with artwork.
+func testProviders(log *callLog) map[string]Scraper {
+	tmdb := &fakeScraper{name: ProviderTMDB, log: log, results: []*models.MetadataMatch{{
+		Source: ProviderTMDB, ExternalID: "603", Title: "The Matrix", Year: num(1999), IMDBId: "tt0133093",
+		Description: str("A hacker learns the truth."), PosterURL: str("tmdb/poster.jpg"),
+		Rating: rating(0), Genres: []string{"Action", "Science Fiction"}, Confidence: 0.8,
+	}}}
+	omdb := &fakeIDScraper{
+		fakeScraper: fakeScraper{name: ProviderOMDb, log: log, results: []*models.MetadataMatch{{
+			Source: ProviderOMDb, ExternalID: "tt0133093", Title: "The Matrix", Year: num(1999), IMDBId: "tt0133093", Confidence: 0.8,
+		}}},
+		byIMDB: map[string]*models.MetadataMatch{"tt0133093": {
+			Source: ProviderOMDb, ExternalID: "tt0133093", Title: "Matrix", Year: num(1999), IMDBId: "tt0133093",
+			Rating: rating(8.7), Tagline: str("Free your mind"), ContentRating: str("R"),
+			Description: str("OMDb's plot."), Genres: []string{"Action", "Sci-Fi"},
+		}},
+	}
+	fanart := &fakeIDScraper{
+		fakeScraper: fakeScraper{name: ProviderFanartTV, log: log},
+		byIMDB: map[string]*models.MetadataMatch{"tt0133093": {
+			Source: ProviderFanartTV, PosterURL: str("fanart/poster.jpg"), BackdropURL: str("fanart/backdrop.jpg"),
+		}},
+	}
+	return map[string]Scraper{ProviderTMDB: tmdb, ProviderOMDb: omdb, ProviderFanartTV: fanart}
+}
+
+func TestFindMergedMatch(t *testing.T) {
+	type want struct {
+		source, title string
+		rating        float64
+		tagline       string
+		poster        string
+		backdrop      string
+		genres        []string
+		provenance    map[string]string
+	}
+	tests := []struct {
+		name      string
+		providers []string
+		sources   FieldSources
+		want      want
+	}{
+		{"first provider identifies, the rest fill its gaps",
+			[]string{"tmdb", "omdb", "fanarttv"}, nil,
+			want{"tmdb", "The Matrix", 8.7, "Free your mind", "tmdb/poster.jpg", "fanart/backdrop.jpg",
+				[]string{"Action", "Science Fiction"}, map[string]string{
+					FieldTitle: "tmdb", FieldYear: "tmdb", FieldDescription: "tmdb", FieldPoster: "tmdb", FieldGenres: "tmdb",
+					FieldRating: "omdb", FieldTagline: "omdb", FieldContentRating: "omdb", FieldBackdrop: "fanarttv",
+				}}},
+		{"field sources override provider order",
+			[]string{"tmdb", "omdb", "fanarttv"}, FieldSources{FieldArtwork: {"fanarttv"}, FieldGenres: {"omdb"}},
+			want{"tmdb", "The Matrix", 8.7, "Free your mind", "fanart/poster.jpg", "fanart/backdrop.jpg",
+				[]string{"Action", "Sci-Fi"}, map[string]string{
+					FieldTitle: "tmdb", FieldYear: "tmdb", FieldDescription: "tmdb", FieldPoster: "fanarttv", FieldGenres: "omdb",
+					FieldRating: "omdb", FieldTagline: "omdb", FieldContentRating: "omdb", FieldBackdrop: "fanarttv",
+				}}},
+		{"library order picks who identifies",
+			[]string{"omdb", "tmdb"}, nil,
+			want{"omdb", "The Matrix", 0, "", "tmdb/poster.jpg", "",
+				[]string{"Action", "Science Fiction"}, map[string]string{
+					FieldTitle: "omdb", FieldYear: "omdb", FieldDescription: "tmdb", FieldPoster: "tmdb", FieldGenres: "tmdb",
+				}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			all := testProviders(nil)
+			var providers []Scraper
+			for _, name := range tt.providers {
+				providers = append(providers, all[name])
+			}
+			m := FindMergedMatch(providers, tt.sources, "The Matrix", models.MediaTypeMovies, 0.75, num(1999))
+			if m == nil {
+				t.Fatal("no match")
+			}
+			got := want{m.Source, m.Title, 0, "", "", "", m.Genres, m.Provenance}
+			if m.Rating != nil {
+				got.rating = *m.Rating
+			}
+			if m.Tagline != nil {
+				got.tagline = *m.Tagline
+			}
+			if m.PosterURL != nil {
+				got.poster = *m.PosterURL
+			}
+			if m.BackdropURL != nil {
+				got.backdrop = *m.BackdropURL
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("merged\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindMergedMatchOrder(t *testing.T) {
+	log := &callLog{}
+	all := testProviders(log)
+	// TMDB fails: OMDb identifies the title, the rest are looked up by ID
+	all[ProviderTMDB].(*fakeScraper).err = errors.New("timeout")
+	providers := []Scraper{all[ProviderTMDB], all[ProviderOMDb], all[ProviderFanartTV]}
+
+	m := FindMergedMatch(providers, nil, "The Matrix", models.MediaTypeMovies, 0.75, num(1999))
+	if m == nil || m.Source != ProviderOMDb {
+		t.Fatalf("merged = %+v, want identified by omdb", m)
+	}
+	// tmdb has no ID lookup, so it is searched again by title
+	want := []string{"tmdb search", "omdb search", "omdb details", "tmdb search", "fanarttv lookup"}
+	if !reflect.DeepEqual(log.calls, want) {
+		t.Errorf("calls %v, want %v", log.calls, want)
+	}
+	if m.BackdropURL == nil || m.Provenance[FieldBackdrop] != ProviderFanartTV {
+		t.Errorf("backdrop not filled from fanarttv: %v", m.Provenance)
+	}
+}
+
+func TestMergeMatches(t *testing.T) {
+	a := &models.MetadataMatch{Source: "a", Title: "A", Tagline: str(""), Rating: rating(0)}
+	b := &models.MetadataMatch{Source: "b", Title: "B", Tagline: str("B's tagline"), IMDBId: "tt1"}
+	c := &models.MetadataMatch{Source: "c", Title: "C", Tagline: str("C's tagline"), Rating: rating(7)}
+
+	m := MergeMatches([]*models.MetadataMatch{a, nil, b, c}, FieldSources{FieldTagline: {"c"}})
+	if m.Source != "a" || m.Title != "A" {
+		t.Errorf("identity taken from %s/%s, want a/A", m.Source, m.Title)
+	}
+	if *m.Tagline != "C's tagline" || m.Provenance[FieldTagline] != "c" {
+		t.Errorf("tagline %q from %s, want c's by precedence", *m.Tagline, m.Provenance[FieldTagline])
+	}
+	if *m.Rating != 7 || m.Provenance[FieldRating] != "c" {
+		t.Errorf("rating %v from %s: a's 0 should fall through to c", *m.Rating, m.Provenance[FieldRating])
+	}
+	if m.IMDBId != "tt1" {
+		t.Errorf("IMDb ID %q, want b's", m.IMDBId)
+	}
+	if _, ok := m.Provenance[FieldCountry]; ok {
+		t.Error("provenance recorded for a field no match has")
+	}
+	if MergeMatches([]*models.MetadataMatch{nil}, nil) != nil {
+		t.Error("merge of nothing is not nil")
+	}
+}
+
+func TestSupplementaryFields(t *testing.T) {
+	match := &models.MetadataMatch{Source: ProviderTMDB, Provenance: map[string]string{
+		FieldTitle:            ProviderTMDB,
+		FieldOriginalTitle:    ProviderOMDb,
+		FieldTagline:          ProviderOMDb,
+		FieldContentRating:    ProviderOMDb,
+		FieldGenres:           ProviderTMDB,
+		FieldBackdrop:         ProviderFanartTV,
+		FieldKeywords:         ProviderOMDb,
+		FieldOriginalLanguage: ProviderOMDb,
+	}}
+	tests := []struct {
+		name   string
+		locked []string
+		want   []string
+	}{
+		{"nothing locked", nil, []string{FieldOriginalTitle, FieldTagline, FieldContentRating, FieldBackdrop, FieldKeywords, FieldOriginalLanguage}},
+		{"locked fields kept", []string{"tagline", "backdrop_path", "content_rating"},
+			[]string{FieldOriginalTitle, FieldKeywords, FieldOriginalLanguage}},
+		{"a title lock keeps the original title", []string{"title"},
+			[]string{FieldTagline, FieldContentRating, FieldBackdrop, FieldKeywords, FieldOriginalLanguage}},
+		{"everything locked", []string{"*"}, nil},
+	}
+	for _, tt := range tests {
+		item := &models.MediaItem{LockedFields: tt.locked}
+		got := SupplementaryFields(item, match)
+		want := make(map[string]bool)
+		for _, f := range tt.want {
+			want[f] = true
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: fields %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+func TestDeferredFields(t *testing.T) {
+	tests := []struct {
+		name       string
+		provenance map[string]string
+		want       []string
+	}{
+		{"genres from another provider", map[string]string{FieldTitle: ProviderTMDB, FieldGenres: ProviderOMDb}, []string{FieldGenres}},
+		{"genres and keywords from the source", map[string]string{FieldGenres: ProviderTMDB, FieldKeywords: ProviderTMDB}, nil},
+		{"keywords from another provider", map[string]string{FieldGenres: ProviderTMDB, FieldKeywords: ProviderOMDb}, []string{FieldKeywords}},
+		{"replaced fields aren't deferred", map[string]string{FieldTagline: ProviderOMDb, FieldRating: ProviderOMDb}, nil},
+		{"not a merged match", nil, nil},
+	}
+	for _, tt := range tests {
+		got := DeferredFields(&models.MetadataMatch{Source: ProviderTMDB, Provenance: tt.provenance})
+		want := make(map[string]bool)
+		for _, f := range tt.want {
+			want[f] = true
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: fields %v, want %v", tt.name, got, want)
+		}
+	}
+	if got := DeferredFields(nil); len(got) != 0 {
+		t.Errorf("no match: fields %v", got)
+	}
+}
@@ -0,0 +1,114 @@
+package metadata
+
+import (
+	"log"
+	"sync"
+
+	"github.com/JustinTDCT/CineVault/internal/models"
+)
+
+// Provider names, as set in a library's metadata_providers and
+// metadata_field_sources.
+const (
+	ProviderTMDB        = "tmdb"
+	ProviderTVDB        = "tvdb"
+	ProviderOMDb        = "omdb"
+	ProviderFanartTV    = "fanarttv"
+	ProviderMusicBrainz = "musicbrainz"
+	ProviderOpenLibrary = "openlibrary"
+	ProviderAudnexus    = "audnexus"
+)
+
+// Registry holds the metadata providers by name, in registration order.
+type Registry struct {
+	mu       sync.RWMutex
+	scrapers []Scraper
+}
+
+// NewRegistry returns a registry holding scrapers.
+func NewRegistry(scrapers ...Scraper) *Registry {
+	r := &Registry{}
+	for _, s := range scrapers {
+		r.Register(s)
+	}
+	return r
+}
+
+// Register adds a provider, replacing any registered under the same name.
+func (r *Registry) Register(s Scraper) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.scrapers {
+		if existing.Name() == s.Name() {
+			r.scrapers[i] = s
+			return
+		}
+	}
+	r.scrapers = append(r.scrapers, s)
+}
+
+// Get returns the provider registered as name, or nil.
+func (r *Registry) Get(name string) Scraper {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, s := range r.scrapers {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Names lists the registered providers.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, len(r.scrapers))
+	for i, s := range r.scrapers {
+		names[i] = s.Name()
+	}
+	return names
+}
+
+// All returns every registered provider.
+func (r *Registry) All() []Scraper {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Scraper(nil), r.scrapers...)
+}
+
+// Providers returns the providers a library consults, in order: its own
+// list, or the defaults for its media type, followed by any provider named
+// only in its per-field sources. Unregistered names (a provider missing its
+// API key) are skipped.
+func (r *Registry) Providers(library *models.Library) []Scraper {
+	var providers []Scraper
+	if len(library.MetadataProviders) > 0 {
+		for _, name := range library.MetadataProviders {
+			if s := r.Get(name); s != nil {
+				providers = append(providers, s)
+			} else {
+				log.Printf("Metadata: library %q lists unknown provider %q", library.Name, name)
+			}
+		}
+	} else {
+		providers = ScrapersForMediaType(r.All(), library.MediaType)
+	}
+
+	listed := make(map[string]bool, len(providers))
+	for _, s := range providers {
+		listed[s.Name()] = true
+	}
+	for _, field := range append([]string{FieldArtwork}, MergeFields...) {
+		for _, name := range library.MetadataFieldSources[field] {
+			if listed[name] {
+				continue
+			}
+			if s := r.Get(name); s != nil {
+				providers = append(providers, s)
+				listed[name] = true
+			}
+		}
+	}
+	return providers
+}
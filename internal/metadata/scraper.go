@@ -16,6 +16,14 @@ type Scraper interface {
 	Name() string
 }
 
+// IDLookup is implemented by providers that can find a title from another
+// provider's match by its IDs (TMDB, TVDB, IMDb), which is surer than a
+// title search. Providers with no title search (fanart.tv) rely on it. A
+// nil match means the IDs it needs are missing.
+type IDLookup interface {
+	LookupByID(match *models.MetadataMatch, mediaType models.MediaType) (*models.MetadataMatch, error)
+}
+
 // articles are common words stripped before similarity comparison.
 var articles = map[string]bool{"a": true, "an": true, "the": true}
 
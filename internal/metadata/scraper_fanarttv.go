@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"net/http"
 	"time"
+
+	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/JustinTDCT/CineVault/internal/repository"
 )
 
 // FanartTVClient fetches extended artwork from fanart.tv.
@@ -21,6 +24,7 @@ type FanartArtwork struct {
 	DiscURL     string
 	ThumbURL    string
 	BackdropURL string
+	PosterURL   string
 }
 
 func NewFanartTVClient(apiKey string) *FanartTVClient {
@@ -56,6 +60,7 @@ func (c *FanartTVClient) GetMovieArtwork(tmdbID string) (*FanartArtwork, error)
 		MovieDiscs      []fanartImage `json:"moviedisc"`
 		MovieThumbs     []fanartImage `json:"moviethumb"`
 		MovieBackgrounds []fanartImage `json:"moviebackground"`
+		MoviePosters     []fanartImage `json:"movieposter"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, err
@@ -68,6 +73,7 @@ func (c *FanartTVClient) GetMovieArtwork(tmdbID string) (*FanartArtwork, error)
 	art.DiscURL = firstFanartURL(result.MovieDiscs)
 	art.ThumbURL = firstFanartURL(result.MovieThumbs)
 	art.BackdropURL = firstFanartURL(result.MovieBackgrounds)
+	art.PosterURL = firstFanartURL(result.MoviePosters)
 
 	return art, nil
 }
@@ -97,6 +103,7 @@ func (c *FanartTVClient) GetTVArtwork(tvdbID string) (*FanartArtwork, error) {
 		TVBanners       []fanartImage `json:"tvbanner"`
 		TVThumbs        []fanartImage `json:"tvthumb"`
 		ShowBackgrounds []fanartImage `json:"showbackground"`
+		TVPosters       []fanartImage `json:"tvposter"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, err
@@ -108,6 +115,7 @@ func (c *FanartTVClient) GetTVArtwork(tvdbID string) (*FanartArtwork, error) {
 	art.BannerURL = firstFanartURL(result.TVBanners)
 	art.ThumbURL = firstFanartURL(result.TVThumbs)
 	art.BackdropURL = firstFanartURL(result.ShowBackgrounds)
+	art.PosterURL = firstFanartURL(result.TVPosters)
 
 	return art, nil
 }
@@ -138,3 +146,73 @@ func firstFanartURL(imageSets ...[]fanartImage) string {
 	}
 	return ""
 }
+
+// FanartScraper is fanart.tv as a metadata provider, for libraries that
+// take artwork from it. fanart.tv has no title search: it is reached by
+// the TMDB ID of a movie or the TVDB ID of a show. The API key is read from
+// settings on each call.
+type FanartScraper struct {
+	settingsRepo *repository.SettingsRepository
+}
+
+func NewFanartScraper(settingsRepo *repository.SettingsRepository) *FanartScraper {
+	return &FanartScraper{settingsRepo: settingsRepo}
+}
+
+func (s *FanartScraper) Name() string { return ProviderFanartTV }
+
+func (s *FanartScraper) client() *FanartTVClient {
+	var key string
+	if s.settingsRepo != nil {
+		key, _ = s.settingsRepo.Get("fanart_api_key")
+	}
+	return NewFanartTVClient(key)
+}
+
+// Search finds nothing; see LookupByID.
+func (s *FanartScraper) Search(query string, mediaType models.MediaType, year *int) ([]*models.MetadataMatch, error) {
+	return nil, nil
+}
+
+// GetDetails fetches a movie's artwork by TMDB ID.
+func (s *FanartScraper) GetDetails(externalID string) (*models.MetadataMatch, error) {
+	art, err := s.client().GetMovieArtwork(externalID)
+	if err != nil {
+		return nil, err
+	}
+	return fanartMatch(externalID, art), nil
+}
+
+// LookupByID fetches the artwork for a TMDB movie or TVDB show match.
+func (s *FanartScraper) LookupByID(match *models.MetadataMatch, mediaType models.MediaType) (*models.MetadataMatch, error) {
+	c := s.client()
+	if c.apiKey == "" {
+		return nil, nil
+	}
+	var art *FanartArtwork
+	var err error
+	switch {
+	case match.Source == ProviderTMDB && mediaType != models.MediaTypeTVShows:
+		art, err = c.GetMovieArtwork(match.ExternalID)
+	case match.Source == ProviderTVDB && mediaType == models.MediaTypeTVShows:
+		art, err = c.GetTVArtwork(match.ExternalID)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fanartMatch(match.ExternalID, art), nil
+}
+
+// fanartMatch carries fanart.tv artwork as a match.
+func fanartMatch(externalID string, art *FanartArtwork) *models.MetadataMatch {
+	m := &models.MetadataMatch{Source: ProviderFanartTV, ExternalID: externalID, Confidence: 1.0}
+	if art.PosterURL != "" {
+		m.PosterURL = &art.PosterURL
+	}
+	if art.BackdropURL != "" {
+		m.BackdropURL = &art.BackdropURL
+	}
+	return m
+}
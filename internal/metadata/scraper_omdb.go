@@ -5,7 +5,12 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/JustinTDCT/CineVault/internal/repository"
 )
 
 // OMDbRatings holds the ratings fetched from the OMDb API.
@@ -76,3 +81,166 @@ func FetchOMDbRatings(imdbID, apiKey string) (*OMDbRatings, error) {
 
 	return result, nil
 }
+
+// OMDbScraper is OMDb as a metadata provider, for libraries that take
+// ratings or plots from it. The API key is read from settings on each call,
+// and a provider without one finds nothing.
+type OMDbScraper struct {
+	settingsRepo *repository.SettingsRepository
+	client       *http.Client
+}
+
+func NewOMDbScraper(settingsRepo *repository.SettingsRepository) *OMDbScraper {
+	return &OMDbScraper{
+		settingsRepo: settingsRepo,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *OMDbScraper) Name() string { return ProviderOMDb }
+
+func (s *OMDbScraper) apiKey() string {
+	if s.settingsRepo == nil {
+		return ""
+	}
+	key, _ := s.settingsRepo.Get("omdb_api_key")
+	return key
+}
+
+// omdbType is OMDb's type filter for a media type.
+func omdbType(mediaType models.MediaType) string {
+	if mediaType == models.MediaTypeTVShows {
+		return "series"
+	}
+	return "movie"
+}
+
+func (s *OMDbScraper) Search(query string, mediaType models.MediaType, year *int) ([]*models.MetadataMatch, error) {
+	key := s.apiKey()
+	if key == "" {
+		return nil, nil
+	}
+	params := url.Values{"s": {query}, "type": {omdbType(mediaType)}, "apikey": {key}}
+	if year != nil && *year > 0 {
+		params.Set("y", strconv.Itoa(*year))
+	}
+	resp, err := s.client.Get("http://www.omdbapi.com/?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Response string `json:"Response"`
+		Search   []struct {
+			Title  string `json:"Title"`
+			Year   string `json:"Year"`
+			IMDBID string `json:"imdbID"`
+			Poster string `json:"Poster"`
+		} `json:"Search"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	var matches []*models.MetadataMatch
+	for _, r := range result.Search {
+		matches = append(matches, &models.MetadataMatch{
+			Source:     ProviderOMDb,
+			ExternalID: r.IMDBID,
+			IMDBId:     r.IMDBID,
+			Title:      r.Title,
+			Year:       omdbYear(r.Year),
+			PosterURL:  omdbValue(r.Poster),
+			Confidence: titleSimilarity(query, r.Title),
+		})
+	}
+	return matches, nil
+}
+
+// GetDetails fetches a title by IMDb ID.
+func (s *OMDbScraper) GetDetails(externalID string) (*models.MetadataMatch, error) {
+	key := s.apiKey()
+	if key == "" {
+		return nil, fmt.Errorf("OMDb API key not configured")
+	}
+	params := url.Values{"i": {externalID}, "plot": {"full"}, "apikey": {key}}
+	resp, err := s.client.Get("http://www.omdbapi.com/?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var r struct {
+		Response   string `json:"Response"`
+		Error      string `json:"Error"`
+		Title      string `json:"Title"`
+		Year       string `json:"Year"`
+		Rated      string `json:"Rated"`
+		Released   string `json:"Released"`
+		Plot       string `json:"Plot"`
+		Genre      string `json:"Genre"`
+		Poster     string `json:"Poster"`
+		IMDBRating string `json:"imdbRating"`
+		IMDBID     string `json:"imdbID"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+	if r.Response == "False" {
+		return nil, fmt.Errorf("OMDb error: %s", r.Error)
+	}
+
+	m := &models.MetadataMatch{
+		Source:        ProviderOMDb,
+		ExternalID:    r.IMDBID,
+		IMDBId:        r.IMDBID,
+		Title:         r.Title,
+		Year:          omdbYear(r.Year),
+		Description:   omdbValue(r.Plot),
+		PosterURL:     omdbValue(r.Poster),
+		ContentRating: omdbValue(r.Rated),
+		Confidence:    1.0,
+	}
+	if released, err := time.Parse("02 Jan 2006", r.Released); err == nil {
+		date := released.Format("2006-01-02")
+		m.ReleaseDate = &date
+	}
+	if rating, err := strconv.ParseFloat(r.IMDBRating, 64); err == nil {
+		m.Rating = &rating
+	}
+	if g := omdbValue(r.Genre); g != nil {
+		for _, name := range strings.Split(*g, ",") {
+			m.Genres = append(m.Genres, strings.TrimSpace(name))
+		}
+	}
+	return m, nil
+}
+
+// LookupByID fetches the title of a match by its IMDb ID.
+func (s *OMDbScraper) LookupByID(match *models.MetadataMatch, mediaType models.MediaType) (*models.MetadataMatch, error) {
+	if match.IMDBId == "" || s.apiKey() == "" {
+		return nil, nil
+	}
+	return s.GetDetails(match.IMDBId)
+}
+
+// omdbValue returns an OMDb string field, or nil for "" and "N/A".
+func omdbValue(v string) *string {
+	if v == "" || v == "N/A" {
+		return nil
+	}
+	return &v
+}
+
+// omdbYear parses an OMDb year: "2010", or "2008–2013" for a series.
+func omdbYear(v string) *int {
+	if len(v) < 4 {
+		return nil
+	}
+	y, err := strconv.Atoi(v[:4])
+	if err != nil {
+		return nil
+	}
+	return &y
+}
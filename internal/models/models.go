@@ -2,6 +2,8 @@ package models
 
 import (
 	"encoding/json"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -40,6 +42,20 @@ const (
 	LibraryAccessAdminOnly   LibraryAccess = "admin_only"
 )
 
+// WatchMode is how a watched library notices changes on disk.
+type WatchMode string
+
+const (
+	WatchModeFSNotify WatchMode = "fsnotify" // filesystem events
+	WatchModePoll     WatchMode = "poll"     // periodic snapshot diffs, for network mounts
+	WatchModeBoth     WatchMode = "both"
+)
+
+// Valid reports whether m is a known watch mode.
+func (m WatchMode) Valid() bool {
+	return m == WatchModeFSNotify || m == WatchModePoll || m == WatchModeBoth
+}
+
 type DuplicateAction string
 
 const (
@@ -125,6 +141,16 @@ type Library struct {
 	ScanInterval      string        `json:"scan_interval" db:"scan_interval"`
 	NextScanAt        *time.Time    `json:"next_scan_at,omitempty" db:"next_scan_at"`
 	WatchEnabled      bool          `json:"watch_enabled" db:"watch_enabled"`
+	WatchMode         WatchMode     `json:"watch_mode" db:"watch_mode"`
+	// Scan concurrency limits; 0 uses the server default
+	ScanProbeWorkers    int `json:"scan_probe_workers" db:"scan_probe_workers"`
+	ScanCPUWorkers      int `json:"scan_cpu_workers" db:"scan_cpu_workers"`
+	ScanMetadataWorkers int `json:"scan_metadata_workers" db:"scan_metadata_workers"`
+	// Metadata providers in preference order; empty uses the media type's
+	// defaults. MetadataFieldSources overrides the order per field, e.g.
+	// {"rating": ["omdb"]}.
+	MetadataProviders    pq.StringArray      `json:"metadata_providers" db:"metadata_providers"`
+	MetadataFieldSources map[string][]string `json:"metadata_field_sources,omitempty" db:"metadata_field_sources"`
 	LastScanAt        *time.Time    `json:"last_scan_at" db:"last_scan_at"`
 	CreatedAt         time.Time     `json:"created_at" db:"created_at"`
 	UpdatedAt         time.Time     `json:"updated_at" db:"updated_at"`
@@ -614,8 +640,10 @@ type DuplicateDecision struct {
 type ScanResult struct {
 	FilesFound   int      `json:"files_found"`
 	FilesAdded   int      `json:"files_added"`
-	FilesSkipped int      `json:"files_skipped"`
-	FilesUpdated int      `json:"files_updated"`
+	FilesSkipped int      `json:"files_skipped"` // unchanged since the last scan
+	FilesUpdated int      `json:"files_updated"` // moved or renamed, item kept
+	FilesChanged int      `json:"files_changed"` // re-probed: changed in place, or a deep scan
+	FilesRemoved int      `json:"files_removed"` // gone from disk, item removed
 	Errors       []string `json:"errors,omitempty"`
 }
 
@@ -886,6 +914,9 @@ type MetadataMatch struct {
 	ASIN        string `json:"asin,omitempty"`
 	RuntimeMins int    `json:"runtime_mins,omitempty"`
 	Publisher   string `json:"publisher,omitempty"`
+	// Provenance names the provider each field came from when the match
+	// was merged from several (field → source)
+	Provenance map[string]string `json:"provenance,omitempty"`
 }
 
 // ──────────────────── Media Segments (Skip Detection) ────────────────────
@@ -1164,3 +1195,110 @@ type WatchActivityEntry struct {
 	Completed     bool      `json:"completed"`
 	PosterPath    *string   `json:"poster_path,omitempty"`
 }
+
+// ──────────────────── Live TV / DVR ────────────────────
+
+type DVRRecording struct {
+	ID           uuid.UUID  `json:"id" db:"id"`
+	ChannelID    uuid.UUID  `json:"channel_id" db:"channel_id"`
+	ProgramID    *uuid.UUID `json:"program_id,omitempty" db:"program_id"`
+	UserID       uuid.UUID  `json:"user_id" db:"user_id"`
+	Title        string     `json:"title" db:"title"`
+	FilePath     *string    `json:"file_path,omitempty" db:"file_path"`
+	State        string     `json:"state" db:"state"`
+	StartTime    time.Time  `json:"start_time" db:"start_time"`
+	EndTime      time.Time  `json:"end_time" db:"end_time"`
+	ErrorMessage *string    `json:"error_message,omitempty" db:"error_message"`
+	MediaItemID  *uuid.UUID `json:"media_item_id,omitempty" db:"media_item_id"`
+	RuleID       *uuid.UUID `json:"rule_id,omitempty" db:"rule_id"`
+}
+
+// DVRRule is a series recording rule. Programmes match on series_id when
+// set, otherwise on title, optionally limited to one channel.
+type DVRRule struct {
+	ID              uuid.UUID  `json:"id" db:"id"`
+	UserID          uuid.UUID  `json:"user_id" db:"user_id"`
+	Title           string     `json:"title" db:"title"`
+	SeriesID        *string    `json:"series_id,omitempty" db:"series_id"`
+	ChannelID       *uuid.UUID `json:"channel_id,omitempty" db:"channel_id"`
+	NewOnly         bool       `json:"new_only" db:"new_only"`
+	KeepCount       int        `json:"keep_count" db:"keep_count"` // 0 = keep all
+	PadStartMinutes int        `json:"pad_start_minutes" db:"pad_start_minutes"`
+	PadEndMinutes   int        `json:"pad_end_minutes" db:"pad_end_minutes"`
+	IsActive        bool       `json:"is_active" db:"is_active"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+}
+
+// DVRCandidate is an upcoming programme that matches a DVRRule.
+type DVRCandidate struct {
+	ProgramID   uuid.UUID
+	ChannelID   uuid.UUID
+	TunerID     uuid.UUID
+	TunerCount  int
+	Title       string
+	ExternalID  *string
+	EpisodeInfo *string
+	StartTime   time.Time
+	EndTime     time.Time
+}
+
+// ChannelSource is everything needed to open a channel's stream on its tuner.
+type ChannelSource struct {
+	ChannelID     uuid.UUID `json:"channel_id"`
+	ChannelNumber string    `json:"channel_number"`
+	ChannelName   string    `json:"channel_name"`
+	StreamURL     *string   `json:"stream_url,omitempty"`
+	TunerID       uuid.UUID `json:"tuner_id"`
+	DeviceType    string    `json:"device_type"`
+	TunerURL      string    `json:"tuner_url"`
+	TunerCount    int       `json:"tuner_count"`
+}
+
+// URL returns the channel's MPEG-TS stream. Channels imported from an M3U or
+// lineup.json carry their own URL; otherwise HDHomeRun devices serve every
+// channel at :5004/auto/v<number>.
+func (c *ChannelSource) URL() string {
+	if c.StreamURL != nil && *c.StreamURL != "" {
+		return *c.StreamURL
+	}
+	base := strings.TrimRight(c.TunerURL, "/")
+	if c.DeviceType == "hdhomerun" {
+		if u, err := url.Parse(base); err == nil && u.Host != "" {
+			return "http://" + u.Hostname() + ":5004/auto/v" + c.ChannelNumber
+		}
+	}
+	return base + "/auto/v" + c.ChannelNumber
+}
+
+// ──────────────────── Optimized Versions ────────────────────
+
+// OptimizedDirName is the folder, beside the original, that optimized
+// versions are written to. Scans and the watcher skip it.
+const OptimizedDirName = ".optimized"
+
+const (
+	OptimizedPending    = "pending"
+	OptimizedProcessing = "processing"
+	OptimizedReady      = "ready"
+	OptimizedFailed     = "failed"
+)
+
+// OptimizedVersion is a copy of a media item pre-transcoded to one of the
+// stream.OptimizeProfiles. MediaItemID is nil once the item is removed.
+type OptimizedVersion struct {
+	ID             uuid.UUID  `json:"id" db:"id"`
+	MediaItemID    *uuid.UUID `json:"media_item_id,omitempty" db:"media_item_id"`
+	Profile        string     `json:"profile" db:"profile"`
+	Status         string     `json:"status" db:"status"`
+	Progress       int        `json:"progress" db:"progress"`
+	FilePath       *string    `json:"-" db:"file_path"`
+	FileSize       int64      `json:"file_size" db:"file_size"`
+	Width          *int       `json:"width,omitempty" db:"width"`
+	Height         *int       `json:"height,omitempty" db:"height"`
+	Bitrate        *int64     `json:"bitrate,omitempty" db:"bitrate"`
+	ErrorMessage   *string    `json:"error_message,omitempty" db:"error_message"`
+	RequestedBy    *uuid.UUID `json:"requested_by,omitempty" db:"requested_by"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty" db:"last_accessed_at"`
+}
@@ -101,16 +101,12 @@ func (g *Generator) GenerateSprite(mediaItemID, filePath string, durationSec int
 	}
 
 	outPath := filepath.Join(outDir, "sprite.jpg")
-	interval := 10
-	if durationSec > 600 {
-		interval = durationSec / 60
-	}
 
 	cmd := exec.Command(g.ffmpegPath,
 		"-skip_frame", "nokey",
 		"-i", filePath,
 		"-an", "-sn",
-		"-vf", fmt.Sprintf("fps=1/%d,scale=160:-1,tile=10x10", interval),
+		"-vf", SpriteFilter(durationSec),
 		"-q:v", "5",
 		"-vsync", "passthrough",
 		"-threads", "4",
@@ -0,0 +1,396 @@
+package preview
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Sprite sheets are thumbnails SpriteThumbWidth pixels wide taken every
+// SpriteInterval seconds, tiled SpriteColumns x SpriteRows into one JPEG.
+// The trickplay outputs below (WebVTT, HLS image and I-frame playlists,
+// BIF) are all cut from that sheet.
+const (
+	SpriteColumns    = 10
+	SpriteRows       = 10
+	SpriteThumbWidth = 160
+)
+
+// SpriteInterval is the seconds between sprite thumbnails: every 10s, or
+// ~60 frames total for anything over 10 minutes.
+func SpriteInterval(durationSec int) int {
+	if durationSec > 600 {
+		return durationSec / 60
+	}
+	return 10
+}
+
+// SpriteFilter is the ffmpeg filter chain that builds a sprite sheet.
+func SpriteFilter(durationSec int) string {
+	return fmt.Sprintf("fps=1/%d,scale=%d:-1,tile=%dx%d", SpriteInterval(durationSec), SpriteThumbWidth, SpriteColumns, SpriteRows)
+}
+
+// SpriteLayout describes where each thumbnail sits in a sprite sheet.
+type SpriteLayout struct {
+	Interval   int     `json:"interval"` // seconds per thumbnail
+	Count      int     `json:"count"`
+	Columns    int     `json:"columns"`
+	Rows       int     `json:"rows"`
+	TileWidth  int     `json:"tile_width"`
+	TileHeight int     `json:"tile_height"`
+	Duration   float64 `json:"duration"`
+	Size       int64   `json:"size"` // sprite sheet bytes
+}
+
+// LoadSpriteLayout reads a sprite sheet's dimensions and works out its
+// layout for a durationSec long video.
+func LoadSpriteLayout(spritePath string, durationSec int) (*SpriteLayout, error) {
+	f, err := os.Open(spritePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("decode sprite: %w", err)
+	}
+	if durationSec <= 0 || cfg.Width < SpriteColumns || cfg.Height < SpriteRows {
+		return nil, fmt.Errorf("sprite %s has no usable thumbnails", filepath.Base(spritePath))
+	}
+	interval := SpriteInterval(durationSec)
+	count := (durationSec + interval - 1) / interval
+	if count > SpriteColumns*SpriteRows {
+		count = SpriteColumns * SpriteRows
+	}
+	return &SpriteLayout{
+		Interval:   interval,
+		Count:      count,
+		Columns:    SpriteColumns,
+		Rows:       SpriteRows,
+		TileWidth:  cfg.Width / SpriteColumns,
+		TileHeight: cfg.Height / SpriteRows,
+		Duration:   float64(durationSec),
+		Size:       info.Size(),
+	}, nil
+}
+
+// Tile returns the rectangle of thumbnail i in the sheet.
+func (l *SpriteLayout) Tile(i int) image.Rectangle {
+	x := (i % l.Columns) * l.TileWidth
+	y := (i / l.Columns) * l.TileHeight
+	return image.Rect(x, y, x+l.TileWidth, y+l.TileHeight)
+}
+
+// span returns when thumbnail i starts and how long it covers; the last
+// one runs to the end of the video.
+func (l *SpriteLayout) span(i int) (start, length float64) {
+	start = float64(i * l.Interval)
+	end := start + float64(l.Interval)
+	if i == l.Count-1 && l.Duration > start {
+		end = l.Duration
+	}
+	return start, end - start
+}
+
+// WebVTT builds a thumbnails track: one cue per thumbnail pointing into
+// the sprite sheet at imageURI with a #xywh= fragment.
+func (l *SpriteLayout) WebVTT(imageURI string) string {
+	var sb strings.Builder
+	sb.WriteString("WEBVTT\n")
+	for i := 0; i < l.Count; i++ {
+		start, length := l.span(i)
+		t := l.Tile(i)
+		sb.WriteString(fmt.Sprintf("\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(start+length), imageURI, t.Min.X, t.Min.Y, t.Dx(), t.Dy()))
+	}
+	return sb.String()
+}
+
+func vttTimestamp(sec float64) string {
+	ms := int64(math.Round(sec * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// imageBandwidth is the bits per second the sprite sheet costs spread over
+// the video, for BANDWIDTH attributes.
+func (l *SpriteLayout) imageBandwidth() int64 {
+	if l.Duration <= 0 {
+		return 0
+	}
+	return int64(float64(l.Size*8) / l.Duration)
+}
+
+// MasterPlaylistLines are the tags that link the image and I-frame
+// playlists from an HLS master playlist.
+func (l *SpriteLayout) MasterPlaylistLines(imagesURI, iframesURI string) string {
+	bw := l.imageBandwidth()
+	if bw < 1 {
+		bw = 1
+	}
+	w, h := even(l.TileWidth), even(l.TileHeight)
+	return fmt.Sprintf("#EXT-X-IMAGE-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"jpeg\",URI=\"%s\"\n", bw, l.TileWidth, l.TileHeight, imagesURI) +
+		fmt.Sprintf("#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\",URI=\"%s\"\n", bw, w, h, iframeCodec, iframesURI)
+}
+
+// ImagePlaylist is an HLS image media playlist (the EXT-X-IMAGES-ONLY
+// extension Apple TV and Roku read) with the whole sheet as one tiled
+// segment.
+func (l *SpriteLayout) ImagePlaylist(imageURI string) string {
+	total := float64(l.Count * l.Interval)
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	sb.WriteString("#EXT-X-VERSION:7\n")
+	sb.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(total))))
+	sb.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	sb.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	sb.WriteString("#EXT-X-IMAGES-ONLY\n")
+	sb.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n", total))
+	sb.WriteString(fmt.Sprintf("#EXT-X-TILES:RESOLUTION=%dx%d,LAYOUT=%dx%d,DURATION=%d\n",
+		l.TileWidth, l.TileHeight, l.Columns, l.Rows, l.Interval))
+	sb.WriteString(imageURI + "\n")
+	sb.WriteString("#EXT-X-ENDLIST\n")
+	return sb.String()
+}
+
+// IFramePlaylist is an HLS I-frame playlist with one single-frame segment
+// per thumbnail, named by segmentURI.
+func (l *SpriteLayout) IFramePlaylist(segmentURI func(i int) string) string {
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	sb.WriteString("#EXT-X-VERSION:4\n")
+	sb.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", l.Interval))
+	sb.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	sb.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	sb.WriteString("#EXT-X-I-FRAMES-ONLY\n")
+	for i := 0; i < l.Count; i++ {
+		_, length := l.span(i)
+		sb.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%s\n", length, segmentURI(i)))
+	}
+	sb.WriteString("#EXT-X-ENDLIST\n")
+	return sb.String()
+}
+
+// iframeCodec is the RFC 6381 codec of the I-frame segments: H.264
+// Constrained Baseline, level 3.0.
+const iframeCodec = "avc1.42e01e"
+
+func even(n int) int {
+	return n &^ 1
+}
+
+// IFrameSegmentName is the file name of I-frame segment i.
+func IFrameSegmentName(i int) string {
+	return fmt.Sprintf("%d.ts", i)
+}
+
+// buildLocks serialises trickplay builds per media item, keyed by its
+// trickplay folder. A player fetches many I-frame segments at once, and
+// each request would otherwise start its own build over the same output.
+var buildLocks = pathLocks{locks: make(map[string]*pathLock)}
+
+type pathLocks struct {
+	mu    sync.Mutex
+	locks map[string]*pathLock
+}
+
+type pathLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock takes the lock for key, returning the func that releases it.
+func (p *pathLocks) lock(key string) func() {
+	p.mu.Lock()
+	l := p.locks[key]
+	if l == nil {
+		l = &pathLock{}
+		p.locks[key] = l
+	}
+	l.refs++
+	p.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		p.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(p.locks, key)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// iframesDone is written in the I-frame folder when a build finishes.
+const iframesDone = ".done"
+
+// EnsureIFrames builds a sprite sheet's I-frame segments in outDir unless
+// a build newer than the sheet has finished. Requests that arrive during
+// a build wait for it instead of starting their own.
+func EnsureIFrames(ffmpegPath, spritePath, outDir string, l *SpriteLayout) error {
+	done := filepath.Join(outDir, iframesDone)
+	if UpToDate(done, spritePath) {
+		return nil
+	}
+	defer buildLocks.lock(filepath.Dir(outDir))()
+	if UpToDate(done, spritePath) {
+		return nil
+	}
+	return GenerateIFrames(ffmpegPath, spritePath, outDir, l)
+}
+
+// EnsureBIF builds a sprite sheet's BIF file at outPath unless it is newer
+// than the sheet, one build at a time per media item.
+func EnsureBIF(spritePath, outPath string, l *SpriteLayout) error {
+	if UpToDate(outPath, spritePath) {
+		return nil
+	}
+	defer buildLocks.lock(filepath.Dir(outPath))()
+	if UpToDate(outPath, spritePath) {
+		return nil
+	}
+	return WriteBIF(spritePath, outPath, l)
+}
+
+// GenerateIFrames encodes each thumbnail of a sprite sheet as a one-frame
+// H.264 MPEG-TS segment in outDir, timestamped where it falls in the video.
+// Segments are encoded in a temporary folder and renamed over the old ones
+// one by one, so a reader gets either version of a segment but never a
+// missing or partial one. Callers serialise builds (see EnsureIFrames).
+func GenerateIFrames(ffmpegPath, spritePath, outDir string, l *SpriteLayout) error {
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(outDir), ".iframes-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	filters := fmt.Sprintf("untile=%dx%d,setpts=N*%d/TB,crop=%d:%d:0:0,format=yuv420p",
+		l.Columns, l.Rows, l.Interval, even(l.TileWidth), even(l.TileHeight))
+	cmd := exec.Command(ffmpegPath,
+		"-i", spritePath,
+		"-vf", filters,
+		"-frames:v", fmt.Sprintf("%d", l.Count),
+		"-c:v", "libx264", "-profile:v", "baseline", "-level", "3.0",
+		"-g", "1", "-bf", "0", "-crf", "28",
+		"-vsync", "passthrough",
+		"-f", "segment", "-segment_format", "mpegts", "-segment_time", "0.001", "-reset_timestamps", "0",
+		"-y", filepath.Join(tmpDir, "%d.ts"),
+	)
+	if output, err := runFFmpegWithTimeout(cmd, ffmpegTimeout); err != nil {
+		return fmt.Errorf("iframes: %w (%s)", err, lastLines(string(output), 10))
+	}
+	for i := 0; i < l.Count; i++ {
+		name := IFrameSegmentName(i)
+		if err := os.Rename(filepath.Join(tmpDir, name), filepath.Join(outDir, name)); err != nil {
+			return fmt.Errorf("iframes: %w", err)
+		}
+	}
+	// Drop segments past the end of a sheet that got shorter
+	entries, _ := os.ReadDir(outDir)
+	for _, e := range entries {
+		var n int
+		if _, err := fmt.Sscanf(e.Name(), "%d.ts", &n); err == nil && n >= l.Count {
+			os.Remove(filepath.Join(outDir, e.Name()))
+		}
+	}
+	return os.WriteFile(filepath.Join(outDir, iframesDone), nil, 0644)
+}
+
+// bifMagic starts every BIF file.
+var bifMagic = []byte{0x89, 'B', 'I', 'F', 0x0d, 0x0a, 0x1a, 0x0a}
+
+// WriteBIF builds a Roku BIF (Base Index Frames) file at outPath from a
+// sprite sheet: a 64-byte header, an index of (timestamp, offset) pairs and
+// the thumbnails as separate JPEGs. Callers serialise builds (see
+// EnsureBIF).
+func WriteBIF(spritePath, outPath string, l *SpriteLayout) error {
+	f, err := os.Open(spritePath)
+	if err != nil {
+		return err
+	}
+	sheet, err := jpeg.Decode(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("decode sprite: %w", err)
+	}
+	sub, ok := sheet.(interface {
+		SubImage(r image.Rectangle) image.Image
+	})
+	if !ok {
+		return fmt.Errorf("sprite image can't be cropped")
+	}
+
+	frames := make([][]byte, 0, l.Count)
+	for i := 0; i < l.Count; i++ {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, sub.SubImage(l.Tile(i)), &jpeg.Options{Quality: 85}); err != nil {
+			return fmt.Errorf("encode frame %d: %w", i, err)
+		}
+		frames = append(frames, buf.Bytes())
+	}
+
+	var out bytes.Buffer
+	header := make([]byte, 64)
+	copy(header, bifMagic)
+	binary.LittleEndian.PutUint32(header[8:], 0) // version
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(frames)))
+	binary.LittleEndian.PutUint32(header[16:], uint32(l.Interval*1000)) // ms per timestamp unit
+	out.Write(header)
+
+	offset := uint32(64 + 8*(len(frames)+1))
+	entry := make([]byte, 8)
+	for i, frame := range frames {
+		binary.LittleEndian.PutUint32(entry[0:], uint32(i))
+		binary.LittleEndian.PutUint32(entry[4:], offset)
+		out.Write(entry)
+		offset += uint32(len(frame))
+	}
+	binary.LittleEndian.PutUint32(entry[0:], 0xffffffff)
+	binary.LittleEndian.PutUint32(entry[4:], offset)
+	out.Write(entry)
+	for _, frame := range frames {
+		out.Write(frame)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return err
+	}
+	tmp := outPath + ".tmp"
+	if err := os.WriteFile(tmp, out.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, outPath)
+}
+
+// UpToDate reports whether a file derived from spritePath exists and is
+// newer than it.
+func UpToDate(derivedPath, spritePath string) bool {
+	derived, err := os.Stat(derivedPath)
+	if err != nil {
+		return false
+	}
+	sprite, err := os.Stat(spritePath)
+	return err == nil && !derived.ModTime().Before(sprite.ModTime())
+}
+
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) <= n {
+		return strings.Join(lines, "\n")
+	}
+	return strings.Join(lines[len(lines)-n:], "\n")
+}
@@ -0,0 +1,165 @@
+package preview
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeSprite writes a sprite sheet of 160x90 tiles for a five minute
+// video (30 thumbnails) and returns it with its layout.
+func writeSprite(t *testing.T, dir string) (string, *SpriteLayout) {
+	t.Helper()
+	path := filepath.Join(dir, "sprite.jpg")
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 160*SpriteColumns, 90*SpriteRows)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := LoadSpriteLayout(path, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, l
+}
+
+// fakeFFmpeg stands in for the I-frame encode: it logs the run, takes a
+// moment, then writes each segment with the content in $FAKE_FFMPEG_TAG.
+func fakeFFmpeg(t *testing.T, runs string, frames int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	script := fmt.Sprintf(`#!/bin/sh
+for out; do :; done
+dir=$(dirname "$out")
+echo run >> %q
+sleep 0.2
+i=0
+while [ $i -lt %d ]; do
+	printf "$FAKE_FFMPEG_TAG" > "$dir/$i.ts"
+	i=$((i+1))
+done
+`, runs, frames)
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func countRuns(t *testing.T, runs string) int {
+	data, err := os.ReadFile(runs)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "run")
+}
+
+func TestEnsureIFrames(t *testing.T) {
+	dir := t.TempDir()
+	sprite, l := writeSprite(t, dir)
+	runs := filepath.Join(dir, "runs")
+	ffmpeg := fakeFFmpeg(t, runs, l.Count)
+	outDir := filepath.Join(dir, "trickplay", "item", "iframes")
+	t.Setenv("FAKE_FFMPEG_TAG", "v1")
+
+	// A player asking for every segment at once gets one build
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := EnsureIFrames(ffmpeg, sprite, outDir, l); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := countRuns(t, runs); n != 1 {
+		t.Fatalf("%d ffmpeg runs for concurrent requests, want 1", n)
+	}
+	for i := 0; i < l.Count; i++ {
+		if _, err := os.Stat(filepath.Join(outDir, IFrameSegmentName(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A sprite sheet newer than the set rebuilds it under readers that
+	// never miss a segment
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(outDir, iframesDone), past, past)
+	t.Setenv("FAKE_FFMPEG_TAG", "v2")
+	stop := make(chan struct{})
+	var misses atomic.Int32
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; ; i = (i + 1) % l.Count {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if data, err := os.ReadFile(filepath.Join(outDir, IFrameSegmentName(i))); err != nil || len(data) == 0 {
+					misses.Add(1)
+				}
+			}
+		}()
+	}
+	if err := EnsureIFrames(ffmpeg, sprite, outDir, l); err != nil {
+		t.Fatal(err)
+	}
+	close(stop)
+	wg.Wait()
+	if n := misses.Load(); n > 0 {
+		t.Errorf("readers missed %d segments during the rebuild", n)
+	}
+	if n := countRuns(t, runs); n != 2 {
+		t.Errorf("%d ffmpeg runs after the sprite changed, want 2", n)
+	}
+	if data, _ := os.ReadFile(filepath.Join(outDir, IFrameSegmentName(0))); string(data) != "v2" {
+		t.Errorf("segment after the rebuild is %q, want v2", data)
+	}
+	if err := EnsureIFrames(ffmpeg, sprite, outDir, l); err != nil || countRuns(t, runs) != 2 {
+		t.Errorf("up-to-date set rebuilt (%v)", err)
+	}
+}
+
+func TestEnsureBIF(t *testing.T) {
+	dir := t.TempDir()
+	sprite, l := writeSprite(t, dir)
+	bif := filepath.Join(dir, "trickplay", "item", "thumbnails.bif")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := EnsureBIF(sprite, bif, l); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(bif)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, bifMagic) || binary.LittleEndian.Uint32(data[12:]) != uint32(l.Count) {
+		t.Fatalf("BIF header %x", data[:20])
+	}
+	if leftovers, _ := filepath.Glob(bif + ".tmp*"); len(leftovers) > 0 {
+		t.Errorf("temporary files left: %v", leftovers)
+	}
+}
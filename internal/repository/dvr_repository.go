@@ -0,0 +1,332 @@
+package repository
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/google/uuid"
+)
+
+type DVRRepository struct {
+	db *sql.DB
+}
+
+func NewDVRRepository(db *sql.DB) *DVRRepository {
+	return &DVRRepository{db: db}
+}
+
+const dvrRecordingColumns = `id, channel_id, program_id, user_id, title, file_path, state,
+	start_time, end_time, error_message, media_item_id, rule_id`
+
+func scanDVRRecording(row interface{ Scan(...interface{}) error }) (*models.DVRRecording, error) {
+	rec := &models.DVRRecording{}
+	err := row.Scan(&rec.ID, &rec.ChannelID, &rec.ProgramID, &rec.UserID, &rec.Title, &rec.FilePath,
+		&rec.State, &rec.StartTime, &rec.EndTime, &rec.ErrorMessage, &rec.MediaItemID, &rec.RuleID)
+	return rec, err
+}
+
+// ListDue returns scheduled recordings whose window contains now, earliest first.
+func (r *DVRRepository) ListDue(now time.Time) ([]*models.DVRRecording, error) {
+	rows, err := r.db.Query(`SELECT `+dvrRecordingColumns+` FROM dvr_recordings
+		WHERE state = 'scheduled' AND start_time <= $1 AND end_time > $1
+		ORDER BY start_time, created_at`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var recs []*models.DVRRecording
+	for rows.Next() {
+		rec, err := scanDVRRecording(rows)
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+	return recs, rows.Err()
+}
+
+// FailMissed fails scheduled recordings whose window has already closed
+// (server was down, or no tuner ever became free).
+func (r *DVRRepository) FailMissed(now time.Time, reason string) (int64, error) {
+	result, err := r.db.Exec(`UPDATE dvr_recordings SET state = 'failed', error_message = $2, completed_at = NOW()
+		WHERE state = 'scheduled' AND end_time <= $1`, now, reason)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ResetInterrupted handles rows left in 'recording' by a restart: those still
+// inside their window go back to scheduled, the rest are failed.
+func (r *DVRRepository) ResetInterrupted(now time.Time) error {
+	if _, err := r.db.Exec(`UPDATE dvr_recordings SET state = 'scheduled'
+		WHERE state = 'recording' AND end_time > $1`, now); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(`UPDATE dvr_recordings SET state = 'failed', error_message = 'interrupted by server restart', completed_at = NOW()
+		WHERE state = 'recording' AND end_time <= $1`, now)
+	return err
+}
+
+func (r *DVRRepository) MarkRecording(id uuid.UUID, filePath string) error {
+	_, err := r.db.Exec(`UPDATE dvr_recordings SET state = 'recording', file_path = $2, started_at = NOW(), error_message = NULL
+		WHERE id = $1`, id, filePath)
+	return err
+}
+
+func (r *DVRRepository) MarkCompleted(id uuid.UUID) error {
+	_, err := r.db.Exec(`UPDATE dvr_recordings SET state = 'completed', completed_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+func (r *DVRRepository) MarkFailed(id uuid.UUID, reason string) error {
+	_, err := r.db.Exec(`UPDATE dvr_recordings SET state = 'failed', error_message = $2, completed_at = NOW() WHERE id = $1`, id, reason)
+	return err
+}
+
+// LinkMediaItem attaches the scanned media item for a finished recording.
+func (r *DVRRepository) LinkMediaItem(id uuid.UUID, filePath string) error {
+	_, err := r.db.Exec(`UPDATE dvr_recordings SET media_item_id = (SELECT id FROM media_items WHERE file_path = $2)
+		WHERE id = $1`, id, filePath)
+	return err
+}
+
+// GetChannelSource resolves a channel to its tuner and stream details.
+func (r *DVRRepository) GetChannelSource(channelID uuid.UUID) (*models.ChannelSource, error) {
+	src := &models.ChannelSource{}
+	err := r.db.QueryRow(`SELECT c.id, c.channel_number, c.name, c.stream_url,
+		t.id, t.device_type, t.url, t.tuner_count
+		FROM epg_channels c JOIN tuner_devices t ON t.id = c.tuner_id
+		WHERE c.id = $1 AND t.is_active = TRUE`, channelID).
+		Scan(&src.ChannelID, &src.ChannelNumber, &src.ChannelName, &src.StreamURL,
+			&src.TunerID, &src.DeviceType, &src.TunerURL, &src.TunerCount)
+	if err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+// PeakOverlap returns the highest number of scheduled or active recordings on
+// a tuner running at the same moment within [start, end), excluding one
+// recording ID (uuid.Nil to count all). Back-to-back recordings do not overlap.
+func (r *DVRRepository) PeakOverlap(tunerID uuid.UUID, start, end time.Time, exclude uuid.UUID) (int, error) {
+	rows, err := r.db.Query(`SELECT GREATEST(d.start_time, $2), LEAST(d.end_time, $3) FROM dvr_recordings d
+		JOIN epg_channels c ON c.id = d.channel_id
+		WHERE c.tuner_id = $1 AND d.state IN ('scheduled', 'recording')
+		  AND d.start_time < $3 AND d.end_time > $2 AND d.id != $4`,
+		tunerID, start, end, exclude)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	type edge struct {
+		at    time.Time
+		delta int
+	}
+	var edges []edge
+	for rows.Next() {
+		var s, e time.Time
+		if err := rows.Scan(&s, &e); err != nil {
+			return 0, err
+		}
+		edges = append(edges, edge{s, 1}, edge{e, -1})
+	}
+	// Ends sort before starts at the same instant
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].at.Equal(edges[j].at) {
+			return edges[i].delta < edges[j].delta
+		}
+		return edges[i].at.Before(edges[j].at)
+	})
+	peak, cur := 0, 0
+	for _, e := range edges {
+		cur += e.delta
+		if cur > peak {
+			peak = cur
+		}
+	}
+	return peak, rows.Err()
+}
+
+// ──────────────────── Series rules ────────────────────
+
+const dvrRuleColumns = `id, user_id, title, series_id, channel_id, new_only, keep_count,
+	pad_start_minutes, pad_end_minutes, is_active, created_at`
+
+func scanDVRRule(row interface{ Scan(...interface{}) error }) (*models.DVRRule, error) {
+	rule := &models.DVRRule{}
+	err := row.Scan(&rule.ID, &rule.UserID, &rule.Title, &rule.SeriesID, &rule.ChannelID, &rule.NewOnly,
+		&rule.KeepCount, &rule.PadStartMinutes, &rule.PadEndMinutes, &rule.IsActive, &rule.CreatedAt)
+	return rule, err
+}
+
+func (r *DVRRepository) queryRules(query string, args ...interface{}) ([]*models.DVRRule, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rules []*models.DVRRule
+	for rows.Next() {
+		rule, err := scanDVRRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// ListRules returns a user's rules, or every rule when userID is uuid.Nil.
+func (r *DVRRepository) ListRules(userID uuid.UUID) ([]*models.DVRRule, error) {
+	if userID == uuid.Nil {
+		return r.queryRules(`SELECT ` + dvrRuleColumns + ` FROM dvr_rules ORDER BY title`)
+	}
+	return r.queryRules(`SELECT `+dvrRuleColumns+` FROM dvr_rules WHERE user_id = $1 ORDER BY title`, userID)
+}
+
+func (r *DVRRepository) ListActiveRules() ([]*models.DVRRule, error) {
+	return r.queryRules(`SELECT ` + dvrRuleColumns + ` FROM dvr_rules WHERE is_active = TRUE ORDER BY created_at`)
+}
+
+// ListKeepRules returns every rule with a keep_count, paused or not, so
+// their recordings are still trimmed.
+func (r *DVRRepository) ListKeepRules() ([]*models.DVRRule, error) {
+	return r.queryRules(`SELECT ` + dvrRuleColumns + ` FROM dvr_rules WHERE keep_count > 0 ORDER BY created_at`)
+}
+
+func (r *DVRRepository) GetRule(id uuid.UUID) (*models.DVRRule, error) {
+	return scanDVRRule(r.db.QueryRow(`SELECT `+dvrRuleColumns+` FROM dvr_rules WHERE id = $1`, id))
+}
+
+func (r *DVRRepository) CreateRule(rule *models.DVRRule) error {
+	rule.ID = uuid.New()
+	return r.db.QueryRow(`INSERT INTO dvr_rules (id, user_id, title, series_id, channel_id, new_only, keep_count,
+			pad_start_minutes, pad_end_minutes, is_active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING created_at`,
+		rule.ID, rule.UserID, rule.Title, rule.SeriesID, rule.ChannelID, rule.NewOnly, rule.KeepCount,
+		rule.PadStartMinutes, rule.PadEndMinutes, rule.IsActive).Scan(&rule.CreatedAt)
+}
+
+func (r *DVRRepository) UpdateRule(rule *models.DVRRule) error {
+	_, err := r.db.Exec(`UPDATE dvr_rules SET title = $2, series_id = $3, channel_id = $4, new_only = $5,
+			keep_count = $6, pad_start_minutes = $7, pad_end_minutes = $8, is_active = $9
+		WHERE id = $1`, rule.ID, rule.Title, rule.SeriesID, rule.ChannelID, rule.NewOnly, rule.KeepCount,
+		rule.PadStartMinutes, rule.PadEndMinutes, rule.IsActive)
+	return err
+}
+
+// ClearScheduled drops the recordings a rule scheduled that haven't started,
+// so an edited rule can be re-matched from scratch.
+func (r *DVRRepository) ClearScheduled(ruleID uuid.UUID) error {
+	_, err := r.db.Exec(`DELETE FROM dvr_recordings WHERE rule_id = $1 AND state = 'scheduled'`, ruleID)
+	return err
+}
+
+// DeleteRule removes a rule and its pending recordings; finished recordings
+// are kept.
+func (r *DVRRepository) DeleteRule(id uuid.UUID) error {
+	if err := r.ClearScheduled(id); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(`DELETE FROM dvr_rules WHERE id = $1`, id)
+	return err
+}
+
+// MatchRule returns upcoming programmes matching a rule, earliest first.
+func (r *DVRRepository) MatchRule(rule *models.DVRRule, now time.Time) ([]*models.DVRCandidate, error) {
+	rows, err := r.db.Query(`SELECT p.id, p.channel_id, t.id, t.tuner_count, p.title, p.external_id, p.episode_info,
+			p.start_time, p.end_time
+		FROM epg_programs p
+		JOIN epg_channels c ON c.id = p.channel_id
+		JOIN tuner_devices t ON t.id = c.tuner_id AND t.is_active = TRUE
+		WHERE p.start_time > $1
+		  AND (CASE WHEN COALESCE($2, '') != '' THEN p.series_id = $2 ELSE LOWER(p.title) = LOWER($3) END)
+		  AND ($4::uuid IS NULL OR p.channel_id = $4)
+		  AND (NOT $5 OR p.is_new)
+		ORDER BY p.start_time`, now, rule.SeriesID, rule.Title, rule.ChannelID, rule.NewOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*models.DVRCandidate
+	for rows.Next() {
+		c := &models.DVRCandidate{}
+		if err := rows.Scan(&c.ProgramID, &c.ChannelID, &c.TunerID, &c.TunerCount, &c.Title, &c.ExternalID,
+			&c.EpisodeInfo, &c.StartTime, &c.EndTime); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// IsDuplicate reports whether the user already has this programme recorded or
+// scheduled: same guide programme, same programme ID (dd_progid), or same
+// title and episode number. Failed recordings don't count.
+func (r *DVRRepository) IsDuplicate(userID uuid.UUID, c *models.DVRCandidate) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM dvr_recordings
+		WHERE user_id = $1 AND state != 'failed' AND (
+			program_id = $2
+			OR (COALESCE($3, '') != '' AND external_id = $3)
+			OR (COALESCE($4, '') != '' AND episode_info = $4 AND LOWER(title) = LOWER($5))))`,
+		userID, c.ProgramID, c.ExternalID, c.EpisodeInfo, c.Title).Scan(&exists)
+	return exists, err
+}
+
+// ScheduleFromRule books a matched programme with the rule's padding applied.
+func (r *DVRRepository) ScheduleFromRule(rule *models.DVRRule, c *models.DVRCandidate, start, end time.Time) error {
+	_, err := r.db.Exec(`INSERT INTO dvr_recordings (channel_id, program_id, user_id, title, start_time, end_time,
+			rule_id, external_id, episode_info)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		c.ChannelID, c.ProgramID, rule.UserID, c.Title, start, end, rule.ID, c.ExternalID, c.EpisodeInfo)
+	return err
+}
+
+// SyncRuleSchedules follows guide changes for rule-scheduled recordings that
+// haven't started: times track the programme (plus padding), and recordings
+// whose programme disappeared from the guide are dropped.
+func (r *DVRRepository) SyncRuleSchedules(now time.Time) error {
+	if _, err := r.db.Exec(`DELETE FROM dvr_recordings
+		WHERE rule_id IS NOT NULL AND state = 'scheduled' AND program_id IS NULL AND start_time > $1`, now); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(`UPDATE dvr_recordings d SET
+			start_time = p.start_time - make_interval(mins => ru.pad_start_minutes),
+			end_time = p.end_time + make_interval(mins => ru.pad_end_minutes)
+		FROM epg_programs p, dvr_rules ru
+		WHERE d.program_id = p.id AND d.rule_id = ru.id AND d.state = 'scheduled' AND d.start_time > $1`, now)
+	return err
+}
+
+// ExpiredForRule returns a rule's completed recordings beyond its newest
+// keep_count, oldest last.
+func (r *DVRRepository) ExpiredForRule(rule *models.DVRRule) ([]*models.DVRRecording, error) {
+	rows, err := r.db.Query(`SELECT `+dvrRecordingColumns+` FROM dvr_recordings
+		WHERE rule_id = $1 AND state = 'completed'
+		ORDER BY start_time DESC OFFSET $2`, rule.ID, rule.KeepCount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var recs []*models.DVRRecording
+	for rows.Next() {
+		rec, err := scanDVRRecording(rows)
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+	return recs, rows.Err()
+}
+
+// MarkExpired records that a recording's file was removed by its rule. The row
+// is kept so the episode isn't recorded again.
+func (r *DVRRepository) MarkExpired(id uuid.UUID) error {
+	_, err := r.db.Exec(`UPDATE dvr_recordings SET state = 'expired', media_item_id = NULL WHERE id = $1`, id)
+	return err
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type FileIndexRepository struct {
+	db *sql.DB
+}
+
+func NewFileIndexRepository(db *sql.DB) *FileIndexRepository {
+	return &FileIndexRepository{db: db}
+}
+
+// ──────────────────── Library File Index ────────────────────
+
+// FileIndexEntry is what the last scan saw of a file.
+type FileIndexEntry struct {
+	FilePath     string
+	FileSize     int64
+	ModTime      time.Time
+	ProbeVersion int
+	MediaItemID  uuid.UUID
+}
+
+// List returns a library's index keyed by file path.
+func (r *FileIndexRepository) List(libraryID uuid.UUID) (map[string]FileIndexEntry, error) {
+	rows, err := r.db.Query(`
+		SELECT file_path, file_size, mod_time, probe_version, media_item_id
+		FROM library_files
+		WHERE library_id = $1 AND media_item_id IS NOT NULL`, libraryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	index := make(map[string]FileIndexEntry)
+	for rows.Next() {
+		var e FileIndexEntry
+		if err := rows.Scan(&e.FilePath, &e.FileSize, &e.ModTime, &e.ProbeVersion, &e.MediaItemID); err != nil {
+			return nil, err
+		}
+		index[e.FilePath] = e
+	}
+	return index, rows.Err()
+}
+
+// Get returns the index entry for one file; ok is false if it has none.
+func (r *FileIndexRepository) Get(libraryID uuid.UUID, filePath string) (e FileIndexEntry, ok bool, err error) {
+	err = r.db.QueryRow(`
+		SELECT file_path, file_size, mod_time, probe_version, media_item_id
+		FROM library_files
+		WHERE library_id = $1 AND file_path = $2 AND media_item_id IS NOT NULL`, libraryID, filePath).
+		Scan(&e.FilePath, &e.FileSize, &e.ModTime, &e.ProbeVersion, &e.MediaItemID)
+	if err == sql.ErrNoRows {
+		return e, false, nil
+	}
+	return e, err == nil, err
+}
+
+// Upsert records a scanned file.
+func (r *FileIndexRepository) Upsert(libraryID uuid.UUID, e FileIndexEntry) error {
+	_, err := r.db.Exec(`
+		INSERT INTO library_files (library_id, file_path, file_size, mod_time, probe_version, media_item_id, scanned_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		ON CONFLICT (library_id, file_path) DO UPDATE SET
+			file_size = EXCLUDED.file_size, mod_time = EXCLUDED.mod_time,
+			probe_version = EXCLUDED.probe_version, media_item_id = EXCLUDED.media_item_id,
+			scanned_at = NOW()`,
+		libraryID, e.FilePath, e.FileSize, e.ModTime, e.ProbeVersion, e.MediaItemID)
+	return err
+}
+
+// Delete drops a file from the index.
+func (r *FileIndexRepository) Delete(libraryID uuid.UUID, filePath string) error {
+	_, err := r.db.Exec(`DELETE FROM library_files WHERE library_id = $1 AND file_path = $2`, libraryID, filePath)
+	return err
+}
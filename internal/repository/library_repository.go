@@ -2,10 +2,12 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 	"github.com/JustinTDCT/CineVault/internal/models"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 type LibraryRepository struct {
@@ -20,11 +22,14 @@ const libraryColumns = `id, name, media_type, path, is_enabled, scan_on_startup,
 	season_grouping, access_level, include_in_homepage, include_in_search,
 	retrieve_metadata, nfo_import, nfo_export, prefer_local_artwork,
 	create_previews, create_thumbnails, audio_normalization,
-	adult_content_type, scan_interval, next_scan_at, watch_enabled,
+	adult_content_type, scan_interval, next_scan_at, watch_enabled, watch_mode,
+	scan_probe_workers, scan_cpu_workers, scan_metadata_workers,
+	metadata_providers, metadata_field_sources,
 	last_scan_at, created_at, updated_at`
 
 func scanLibrary(row interface{ Scan(dest ...interface{}) error }) (*models.Library, error) {
 	lib := &models.Library{}
+	var fieldSources []byte
 	err := row.Scan(
 		&lib.ID, &lib.Name, &lib.MediaType, &lib.Path,
 		&lib.IsEnabled, &lib.ScanOnStartup,
@@ -32,29 +37,61 @@ func scanLibrary(row interface{ Scan(dest ...interface{}) error }) (*models.Libr
 		&lib.IncludeInHomepage, &lib.IncludeInSearch,
 		&lib.RetrieveMetadata, &lib.NFOImport, &lib.NFOExport, &lib.PreferLocalArtwork,
 		&lib.CreatePreviews, &lib.CreateThumbnails, &lib.AudioNormalization,
-		&lib.AdultContentType, &lib.ScanInterval, &lib.NextScanAt, &lib.WatchEnabled,
+		&lib.AdultContentType, &lib.ScanInterval, &lib.NextScanAt, &lib.WatchEnabled, &lib.WatchMode,
+		&lib.ScanProbeWorkers, &lib.ScanCPUWorkers, &lib.ScanMetadataWorkers,
+		&lib.MetadataProviders, &fieldSources,
 		&lib.LastScanAt, &lib.CreatedAt, &lib.UpdatedAt,
 	)
+	if err == nil && len(fieldSources) > 0 {
+		err = json.Unmarshal(fieldSources, &lib.MetadataFieldSources)
+	}
 	return lib, err
 }
 
+// providersArray is a library's metadata provider list for the
+// metadata_providers column, which is never NULL.
+func providersArray(library *models.Library) pq.StringArray {
+	if library.MetadataProviders == nil {
+		return pq.StringArray{}
+	}
+	return library.MetadataProviders
+}
+
+// fieldSourcesJSON encodes a library's per-field metadata sources for the
+// metadata_field_sources column.
+func fieldSourcesJSON(library *models.Library) ([]byte, error) {
+	if library.MetadataFieldSources == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(library.MetadataFieldSources)
+}
+
 func (r *LibraryRepository) Create(library *models.Library) error {
 	query := `
 		INSERT INTO libraries (id, name, media_type, path, is_enabled, scan_on_startup,
 			season_grouping, access_level, include_in_homepage, include_in_search,
 			retrieve_metadata, nfo_import, nfo_export, prefer_local_artwork,
 			create_previews, create_thumbnails, audio_normalization,
-			adult_content_type, scan_interval, next_scan_at, watch_enabled)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
+			adult_content_type, scan_interval, next_scan_at, watch_enabled, watch_mode,
+			scan_probe_workers, scan_cpu_workers, scan_metadata_workers,
+			metadata_providers, metadata_field_sources)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27)
 		RETURNING created_at, updated_at`
 
+	fieldSources, err := fieldSourcesJSON(library)
+	if err != nil {
+		return err
+	}
+
 	return r.db.QueryRow(query, library.ID, library.Name, library.MediaType,
 		library.Path, library.IsEnabled, library.ScanOnStartup,
 		library.SeasonGrouping, library.AccessLevel,
 		library.IncludeInHomepage, library.IncludeInSearch,
 		library.RetrieveMetadata, library.NFOImport, library.NFOExport, library.PreferLocalArtwork,
 		library.CreatePreviews, library.CreateThumbnails, library.AudioNormalization,
-		library.AdultContentType, library.ScanInterval, library.NextScanAt, library.WatchEnabled).
+		library.AdultContentType, library.ScanInterval, library.NextScanAt, library.WatchEnabled, library.WatchMode,
+		library.ScanProbeWorkers, library.ScanCPUWorkers, library.ScanMetadataWorkers,
+		providersArray(library), fieldSources).
 		Scan(&library.CreatedAt, &library.UpdatedAt)
 }
 
@@ -114,7 +151,9 @@ func (r *LibraryRepository) ListForUser(userID uuid.UUID, role models.UserRole)
 		l.season_grouping, l.access_level, l.include_in_homepage, l.include_in_search,
 		l.retrieve_metadata, l.nfo_import, l.nfo_export, l.prefer_local_artwork,
 		l.create_previews, l.create_thumbnails, l.audio_normalization,
-		l.adult_content_type, l.scan_interval, l.next_scan_at, l.watch_enabled,
+		l.adult_content_type, l.scan_interval, l.next_scan_at, l.watch_enabled, l.watch_mode,
+		l.scan_probe_workers, l.scan_cpu_workers, l.scan_metadata_workers,
+		l.metadata_providers, l.metadata_field_sources,
 		l.last_scan_at, l.created_at, l.updated_at`
 
 	query = `
@@ -189,7 +228,9 @@ func (r *LibraryRepository) ListHomepageLibraries(userID uuid.UUID, role models.
 		l.season_grouping, l.access_level, l.include_in_homepage, l.include_in_search,
 		l.retrieve_metadata, l.nfo_import, l.nfo_export, l.prefer_local_artwork,
 		l.create_previews, l.create_thumbnails, l.audio_normalization,
-		l.adult_content_type, l.scan_interval, l.next_scan_at, l.watch_enabled,
+		l.adult_content_type, l.scan_interval, l.next_scan_at, l.watch_enabled, l.watch_mode,
+		l.scan_probe_workers, l.scan_cpu_workers, l.scan_metadata_workers,
+		l.metadata_providers, l.metadata_field_sources,
 		l.last_scan_at, l.created_at, l.updated_at`
 
 	query := `
@@ -264,8 +305,14 @@ func (r *LibraryRepository) Update(library *models.Library) error {
 		    retrieve_metadata = $9, nfo_import = $10, nfo_export = $11, prefer_local_artwork = $12,
 		    create_previews = $13, create_thumbnails = $14, audio_normalization = $15,
 		    adult_content_type = $16, scan_interval = $17, next_scan_at = $18, watch_enabled = $19,
-		    updated_at = CURRENT_TIMESTAMP
-		WHERE id = $20`
+		    watch_mode = $20, scan_probe_workers = $21, scan_cpu_workers = $22, scan_metadata_workers = $23,
+		    metadata_providers = $24, metadata_field_sources = $25, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $26`
+
+	fieldSources, err := fieldSourcesJSON(library)
+	if err != nil {
+		return err
+	}
 
 	result, err := r.db.Exec(query, library.Name, library.Path,
 		library.IsEnabled, library.ScanOnStartup,
@@ -274,7 +321,8 @@ func (r *LibraryRepository) Update(library *models.Library) error {
 		library.RetrieveMetadata, library.NFOImport, library.NFOExport, library.PreferLocalArtwork,
 		library.CreatePreviews, library.CreateThumbnails, library.AudioNormalization,
 		library.AdultContentType, library.ScanInterval, library.NextScanAt, library.WatchEnabled,
-		library.ID)
+		library.WatchMode, library.ScanProbeWorkers, library.ScanCPUWorkers, library.ScanMetadataWorkers,
+		providersArray(library), fieldSources, library.ID)
 	if err != nil {
 		return err
 	}
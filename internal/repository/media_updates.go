@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/JustinTDCT/CineVault/internal/models"
 	"github.com/google/uuid"
 	"github.com/lib/pq"
 )
@@ -513,3 +514,124 @@ func (r *MediaRepository) GetEditionsPendingItems(limit int) ([]EditionsPendingI
 	}
 	return items, rows.Err()
 }
+
+// ReplaceFile points an existing media item at a new file (e.g. a Sonarr/Radarr
+// quality upgrade) so watch history, ratings and editions stay attached to the
+// same ID. Technical fields come from item; file-derived data (hashes, sprites,
+// previews, loudness) is cleared so the post-scan jobs regenerate it.
+func (r *MediaRepository) ReplaceFile(item *models.MediaItem) error {
+	query := `UPDATE media_items SET
+		file_path = $2, file_name = $3, file_size = $4, file_hash = NULL, file_inode = NULL, phash = NULL,
+		duration_seconds = $5, resolution = $6, width = $7, height = $8, codec = $9,
+		container = $10, bitrate = $11, audio_codec = $12, audio_format = $13,
+		hdr_format = $14, dynamic_range = $15,
+		sprite_path = NULL, preview_path = NULL, loudness_lufs = NULL, loudness_gain_db = NULL,
+		updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`
+	_, err := r.db.Exec(query, item.ID, item.FilePath, item.FileName, item.FileSize,
+		item.DurationSeconds, item.Resolution, item.Width, item.Height, item.Codec,
+		item.Container, item.Bitrate, item.AudioCodec, item.AudioFormat,
+		item.HDRFormat, item.DynamicRange)
+	return err
+}
+
+// UpdateProbeData stores freshly probed technical fields for an item whose
+// file is unchanged; unlike ReplaceFile, derived data is kept.
+func (r *MediaRepository) UpdateProbeData(item *models.MediaItem) error {
+	query := `UPDATE media_items SET
+		duration_seconds = $2, resolution = $3, width = $4, height = $5, codec = $6,
+		container = $7, bitrate = $8, audio_codec = $9, audio_format = $10,
+		hdr_format = $11, dynamic_range = $12, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`
+	_, err := r.db.Exec(query, item.ID, item.DurationSeconds, item.Resolution, item.Width, item.Height,
+		item.Codec, item.Container, item.Bitrate, item.AudioCodec, item.AudioFormat,
+		item.HDRFormat, item.DynamicRange)
+	return err
+}
+
+// MarkUnavailableUnder removes every media item whose file lives under dir
+// (a movie/series/artist folder deleted by an *arr app).
+func (r *MediaRepository) MarkUnavailableUnder(dir string) (int64, error) {
+	prefix := strings.TrimRight(dir, "/") + "/"
+	result, err := r.db.Exec(`DELETE FROM media_items WHERE left(file_path, length($1)) = $1`, prefix)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// SetFileIdentity stores the quick content hash and inode the scanner
+// recognises a moved file by.
+func (r *MediaRepository) SetFileIdentity(id uuid.UUID, hash string, inode int64) error {
+	_, err := r.db.Exec(`UPDATE media_items SET file_hash = $1, file_inode = $2 WHERE id = $3`, hash, inode, id)
+	return err
+}
+
+// MoveCandidate holds the fields needed to tell whether a new file is an
+// existing item that moved.
+type MoveCandidate struct {
+	ID              uuid.UUID
+	FilePath        string
+	FileHash        *string
+	FileInode       *int64
+	Phash           *string
+	DurationSeconds *int
+}
+
+// ListMoveCandidates returns the items in a library with a file of exactly
+// size bytes anywhere but excludePath.
+func (r *MediaRepository) ListMoveCandidates(libraryID uuid.UUID, size int64, excludePath string) ([]MoveCandidate, error) {
+	rows, err := r.db.Query(`
+		SELECT id, file_path, file_hash, file_inode, phash, duration_seconds
+		FROM media_items
+		WHERE library_id = $1 AND file_size = $2 AND file_path != $3`, libraryID, size, excludePath)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []MoveCandidate
+	for rows.Next() {
+		var c MoveCandidate
+		if err := rows.Scan(&c.ID, &c.FilePath, &c.FileHash, &c.FileInode, &c.Phash, &c.DurationSeconds); err != nil {
+			return nil, err
+		}
+		items = append(items, c)
+	}
+	return items, rows.Err()
+}
+
+// RelocateFile points the items in a library whose file was oldPath at the
+// path it moved to; a multi-episode file has one item per episode. It only
+// applies while they are still at oldPath, so two scans can't both claim
+// them, and returns how many it moved.
+func (r *MediaRepository) RelocateFile(libraryID uuid.UUID, oldPath, newPath, newName string) (int64, error) {
+	result, err := r.db.Exec(`UPDATE media_items SET file_path = $3, file_name = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE library_id = $1 AND file_path = $2`, libraryID, oldPath, newPath, newName)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ListFilePathsUnder returns the items whose file is path or lives under it.
+func (r *MediaRepository) ListFilePathsUnder(path string) (map[uuid.UUID]string, error) {
+	prefix := strings.TrimRight(path, "/") + "/"
+	rows, err := r.db.Query(`SELECT id, file_path FROM media_items
+		WHERE file_path = $1 OR left(file_path, length($2)) = $2`, path, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := make(map[uuid.UUID]string)
+	for rows.Next() {
+		var id uuid.UUID
+		var p string
+		if err := rows.Scan(&id, &p); err != nil {
+			return nil, err
+		}
+		paths[id] = p
+	}
+	return paths, rows.Err()
+}
@@ -215,6 +215,7 @@ func (r *MusicRepository) ListTracksByAlbum(albumID uuid.UUID) ([]*models.MediaI
 		       m.title, m.year, m.duration_seconds, m.audio_codec, m.audio_format,
 		       m.bitrate, m.container, m.artist_id, m.album_id,
 		       m.track_number, m.disc_number, m.poster_path, m.added_at, m.updated_at,
+		       m.loudness_lufs, m.loudness_gain_db,
 		       COALESCE(m.album_artist, ar.name, '') AS artist_name,
 		       COALESCE(al.title, '') AS album_title
 		FROM media_items m
@@ -236,6 +237,7 @@ func (r *MusicRepository) ListTracksByAlbum(albumID uuid.UUID) ([]*models.MediaI
 			&m.Title, &m.Year, &m.DurationSeconds, &m.AudioCodec, &m.AudioFormat,
 			&m.Bitrate, &m.Container, &m.ArtistID, &m.AlbumID,
 			&m.TrackNumber, &m.DiscNumber, &m.PosterPath, &m.AddedAt, &m.UpdatedAt,
+			&m.LoudnessLUFS, &m.LoudnessGainDB,
 			&m.ArtistName, &m.AlbumTitle,
 		); err != nil {
 			return nil, err
@@ -245,6 +247,19 @@ func (r *MusicRepository) ListTracksByAlbum(albumID uuid.UUID) ([]*models.MediaI
 	return items, rows.Err()
 }
 
+// AlbumLoudness returns the integrated loudness of an album: the
+// duration-weighted energy mean of its analyzed tracks. ok is false when
+// none of them has been analyzed.
+func (r *MusicRepository) AlbumLoudness(albumID uuid.UUID) (lufs float64, ok bool, err error) {
+	var v sql.NullFloat64
+	err = r.db.QueryRow(`
+		SELECT 10 * LOG(SUM(GREATEST(COALESCE(duration_seconds, 1), 1) * POWER(10, loudness_lufs / 10))
+		              / SUM(GREATEST(COALESCE(duration_seconds, 1), 1)))
+		FROM media_items
+		WHERE album_id = $1 AND loudness_lufs IS NOT NULL`, albumID).Scan(&v)
+	return v.Float64, v.Valid, err
+}
+
 func (r *MusicRepository) FindAlbumByTitle(artistID uuid.UUID, title string) (*models.Album, error) {
 	a := &models.Album{}
 	query := `
@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/google/uuid"
+)
+
+type OptimizedRepository struct {
+	db *sql.DB
+}
+
+func NewOptimizedRepository(db *sql.DB) *OptimizedRepository {
+	return &OptimizedRepository{db: db}
+}
+
+const optimizedColumns = `id, media_item_id, profile, status, progress, file_path, file_size,
+	width, height, bitrate, error_message, requested_by, created_at, completed_at, last_accessed_at`
+
+func scanOptimized(row interface{ Scan(...interface{}) error }) (*models.OptimizedVersion, error) {
+	v := &models.OptimizedVersion{}
+	err := row.Scan(&v.ID, &v.MediaItemID, &v.Profile, &v.Status, &v.Progress, &v.FilePath, &v.FileSize,
+		&v.Width, &v.Height, &v.Bitrate, &v.ErrorMessage, &v.RequestedBy, &v.CreatedAt, &v.CompletedAt, &v.LastAccessedAt)
+	return v, err
+}
+
+func (r *OptimizedRepository) query(query string, args ...interface{}) ([]*models.OptimizedVersion, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var versions []*models.OptimizedVersion
+	for rows.Next() {
+		v, err := scanOptimized(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// Request returns the version of a media item in a profile, creating a
+// pending one if there is none and resetting a failed one to pending.
+// queued reports whether a job needs to be enqueued for it.
+func (r *OptimizedRepository) Request(mediaItemID uuid.UUID, profile string, userID uuid.UUID) (v *models.OptimizedVersion, queued bool, err error) {
+	v, err = scanOptimized(r.db.QueryRow(`SELECT `+optimizedColumns+` FROM optimized_versions
+		WHERE media_item_id = $1 AND profile = $2`, mediaItemID, profile))
+	switch {
+	case err == nil && v.Status != models.OptimizedFailed:
+		return v, false, nil
+	case err == nil:
+		v, err = scanOptimized(r.db.QueryRow(`UPDATE optimized_versions SET status = 'pending', progress = 0,
+			error_message = NULL, requested_by = $2, created_at = NOW() WHERE id = $1
+			RETURNING `+optimizedColumns, v.ID, userID))
+		return v, err == nil, err
+	case err != sql.ErrNoRows:
+		return nil, false, err
+	}
+	v, err = scanOptimized(r.db.QueryRow(`INSERT INTO optimized_versions (media_item_id, profile, requested_by)
+		VALUES ($1, $2, $3) ON CONFLICT (media_item_id, profile) DO NOTHING
+		RETURNING `+optimizedColumns, mediaItemID, profile, userID))
+	if err == sql.ErrNoRows {
+		// Requested concurrently; the other request queued it
+		v, err = scanOptimized(r.db.QueryRow(`SELECT `+optimizedColumns+` FROM optimized_versions
+			WHERE media_item_id = $1 AND profile = $2`, mediaItemID, profile))
+		return v, false, err
+	}
+	return v, err == nil, err
+}
+
+func (r *OptimizedRepository) GetByID(id uuid.UUID) (*models.OptimizedVersion, error) {
+	return scanOptimized(r.db.QueryRow(`SELECT `+optimizedColumns+` FROM optimized_versions WHERE id = $1`, id))
+}
+
+// ListByMedia returns every version of a media item.
+func (r *OptimizedRepository) ListByMedia(mediaItemID uuid.UUID) ([]*models.OptimizedVersion, error) {
+	return r.query(`SELECT `+optimizedColumns+` FROM optimized_versions WHERE media_item_id = $1 ORDER BY profile`, mediaItemID)
+}
+
+// ListReady returns the versions of a media item that can be played.
+func (r *OptimizedRepository) ListReady(mediaItemID uuid.UUID) ([]*models.OptimizedVersion, error) {
+	return r.query(`SELECT `+optimizedColumns+` FROM optimized_versions
+		WHERE media_item_id = $1 AND status = 'ready' ORDER BY height DESC NULLS LAST`, mediaItemID)
+}
+
+// List returns versions, newest first, optionally only those one user
+// requested (uuid.Nil for all).
+func (r *OptimizedRepository) List(userID uuid.UUID) ([]*models.OptimizedVersion, error) {
+	if userID == uuid.Nil {
+		return r.query(`SELECT ` + optimizedColumns + ` FROM optimized_versions ORDER BY created_at DESC`)
+	}
+	return r.query(`SELECT `+optimizedColumns+` FROM optimized_versions WHERE requested_by = $1 ORDER BY created_at DESC`, userID)
+}
+
+// ListPending returns versions waiting for or in the middle of a job.
+func (r *OptimizedRepository) ListPending() ([]*models.OptimizedVersion, error) {
+	return r.query(`SELECT ` + optimizedColumns + ` FROM optimized_versions
+		WHERE status IN ('pending', 'processing') ORDER BY created_at`)
+}
+
+func (r *OptimizedRepository) MarkProcessing(id uuid.UUID, filePath string) error {
+	_, err := r.db.Exec(`UPDATE optimized_versions SET status = 'processing', progress = 0, file_path = $2,
+		error_message = NULL WHERE id = $1`, id, filePath)
+	return err
+}
+
+func (r *OptimizedRepository) UpdateProgress(id uuid.UUID, progress int) error {
+	_, err := r.db.Exec(`UPDATE optimized_versions SET progress = $2 WHERE id = $1`, id, progress)
+	return err
+}
+
+func (r *OptimizedRepository) MarkReady(id uuid.UUID, fileSize int64, width, height int, bitrate int64) error {
+	_, err := r.db.Exec(`UPDATE optimized_versions SET status = 'ready', progress = 100, file_size = $2,
+		width = $3, height = $4, bitrate = $5, completed_at = NOW(), last_accessed_at = NOW() WHERE id = $1`,
+		id, fileSize, width, height, bitrate)
+	return err
+}
+
+func (r *OptimizedRepository) MarkFailed(id uuid.UUID, reason string) error {
+	_, err := r.db.Exec(`UPDATE optimized_versions SET status = 'failed', error_message = $2, completed_at = NOW()
+		WHERE id = $1`, id, reason)
+	return err
+}
+
+// Touch records that a version was played or downloaded.
+func (r *OptimizedRepository) Touch(id uuid.UUID) error {
+	_, err := r.db.Exec(`UPDATE optimized_versions SET last_accessed_at = NOW() WHERE id = $1`, id)
+	return err
+}
+
+func (r *OptimizedRepository) Delete(id uuid.UUID) error {
+	_, err := r.db.Exec(`DELETE FROM optimized_versions WHERE id = $1`, id)
+	return err
+}
+
+// TotalSize returns the bytes taken by ready versions.
+func (r *OptimizedRepository) TotalSize() (int64, error) {
+	var total int64
+	err := r.db.QueryRow(`SELECT COALESCE(SUM(file_size), 0) FROM optimized_versions WHERE status = 'ready'`).Scan(&total)
+	return total, err
+}
+
+// CountByUser returns how many versions a user has requested that are
+// queued, being made or ready.
+func (r *OptimizedRepository) CountByUser(userID uuid.UUID) (int, error) {
+	var n int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM optimized_versions
+		WHERE requested_by = $1 AND status != 'failed'`, userID).Scan(&n)
+	return n, err
+}
+
+// ListExpired returns versions due for cleanup: those whose media item is
+// gone, failed ones older than failedAge and, when unusedAge is non-zero,
+// ready ones not played or downloaded within it.
+func (r *OptimizedRepository) ListExpired(failedAge, unusedAge time.Duration) ([]*models.OptimizedVersion, error) {
+	now := time.Now()
+	unusedBefore := time.Time{}
+	if unusedAge > 0 {
+		unusedBefore = now.Add(-unusedAge)
+	}
+	return r.query(`SELECT `+optimizedColumns+` FROM optimized_versions
+		WHERE media_item_id IS NULL
+		   OR (status = 'failed' AND created_at < $1)
+		   OR (status = 'ready' AND COALESCE(last_accessed_at, completed_at) < $2)`,
+		now.Add(-failedAge), unusedBefore)
+}
+
+// ListLeastRecentlyUsed returns ready versions, least recently played or
+// downloaded first.
+func (r *OptimizedRepository) ListLeastRecentlyUsed() ([]*models.OptimizedVersion, error) {
+	return r.query(`SELECT ` + optimizedColumns + ` FROM optimized_versions
+		WHERE status = 'ready' ORDER BY COALESCE(last_accessed_at, completed_at)`)
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/JustinTDCT/CineVault/internal/ffmpeg"
 	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/JustinTDCT/CineVault/internal/preview"
 )
 
 const ffmpegPreviewTimeout = 2 * time.Minute
@@ -195,11 +196,7 @@ func (s *Scanner) GenerateTimelineThumbnails(item *models.MediaItem) {
 	spriteDir := filepath.Join(s.posterDir, "sprites")
 	os.MkdirAll(spriteDir, 0755)
 
-	duration := *item.DurationSeconds
-	interval := 10
-	if duration > 600 {
-		interval = duration / 60 // ~60 frames total
-	}
+	interval := preview.SpriteInterval(*item.DurationSeconds)
 
 	outFile := filepath.Join(spriteDir, item.ID.String()+".jpg")
 
@@ -212,7 +209,7 @@ func (s *Scanner) GenerateTimelineThumbnails(item *models.MediaItem) {
 	args = append(args,
 		"-i", item.FilePath,
 		"-an", "-sn",
-		"-vf", fmt.Sprintf("fps=1/%d,scale=160:-1,tile=10x10", interval),
+		"-vf", preview.SpriteFilter(*item.DurationSeconds),
 		"-q:v", "5",
 		"-vsync", "passthrough",
 		"-threads", "4",
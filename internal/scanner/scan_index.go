@@ -0,0 +1,147 @@
+package scanner
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/JustinTDCT/CineVault/internal/repository"
+	"github.com/google/uuid"
+)
+
+// ──────────────────── Incremental Scans ────────────────────
+
+// Each scanned file is recorded in the library's file index with its size,
+// mtime and the ProbeVersion that probed it. Later scans skip files that
+// match their entry before any database lookup or ffprobe; deep scans
+// ignore the index and re-probe everything.
+
+// ProbeVersion is stored with every indexed file. Bump it when probing or
+// track extraction changes, so files indexed before are re-probed by the
+// next scan.
+const ProbeVersion = 1
+
+// fileStatus is how a file on disk compares with its index entry.
+type fileStatus int
+
+const (
+	fileNew       fileStatus = iota // not in the index
+	fileUnchanged                   // same size and mtime, current probe
+	fileChanged                     // size or mtime differ: replaced in place
+	fileStale                       // unchanged, probed by an older ProbeVersion
+)
+
+// indexStatus compares a file's size and mtime with its index entry.
+func indexStatus(e repository.FileIndexEntry, indexed bool, size int64, modTime time.Time) fileStatus {
+	switch {
+	case !indexed:
+		return fileNew
+	case e.FileSize != size || !e.ModTime.Equal(indexModTime(modTime)):
+		return fileChanged
+	case e.ProbeVersion < ProbeVersion:
+		return fileStale
+	}
+	return fileUnchanged
+}
+
+// indexModTime rounds an mtime to what the index stores (microseconds).
+func indexModTime(t time.Time) time.Time {
+	return t.Truncate(time.Microsecond)
+}
+
+// indexFile records a file as scanned into item.
+func (s *Scanner) indexFile(libraryID uuid.UUID, path string, size int64, modTime time.Time, itemID uuid.UUID) {
+	if s.fileIndex == nil {
+		return
+	}
+	err := s.fileIndex.Upsert(libraryID, repository.FileIndexEntry{
+		FilePath:     path,
+		FileSize:     size,
+		ModTime:      indexModTime(modTime),
+		ProbeVersion: ProbeVersion,
+		MediaItemID:  itemID,
+	})
+	if err != nil {
+		log.Printf("Scan: failed to index %s: %v", path, err)
+	}
+}
+
+// loadFileIndex returns a library's file index, or an empty one (every
+// file treated as new) if it can't be read.
+func (s *Scanner) loadFileIndex(libraryID uuid.UUID) map[string]repository.FileIndexEntry {
+	if s.fileIndex != nil {
+		index, err := s.fileIndex.List(libraryID)
+		if err == nil {
+			return index
+		}
+		log.Printf("Scan: file index unavailable, scanning every file: %v", err)
+	}
+	return map[string]repository.FileIndexEntry{}
+}
+
+// reprobe refreshes an existing item from its file. A file changed in
+// place is handled like an upgrade (ReplaceFile: re-probed, derived data
+// cleared); a stale one only has its technical fields and tracks redone.
+func (s *Scanner) reprobe(library *models.Library, item *models.MediaItem, status fileStatus) error {
+	if status == fileChanged {
+		return s.ReplaceFile(library, item.FilePath, item.FilePath)
+	}
+	if !s.isProbeableType(library.MediaType) {
+		return nil
+	}
+	probe, err := s.ffprobe.Probe(item.FilePath)
+	if err != nil {
+		return err
+	}
+	s.applyProbeData(item, probe)
+	if err := s.mediaRepo.UpdateProbeData(item); err != nil {
+		return err
+	}
+	if s.tracksRepo != nil {
+		s.tracksRepo.DeleteSubtitlesByMediaID(item.ID)
+		s.tracksRepo.DeleteAudioTracksByMediaID(item.ID)
+		s.tracksRepo.DeleteChaptersByMediaID(item.ID)
+		s.extractAndStoreTracks(item.ID, item.FilePath, probe)
+	}
+	return nil
+}
+
+// removeUnseen handles indexed files a scan didn't find under roots (the
+// scan folders it walked): items whose file is gone from disk are removed,
+// and the entries dropped. Moved files were picked up at their new path
+// during the scan, so only their stale entries go. Returns the number of
+// items removed.
+func (s *Scanner) removeUnseen(library *models.Library, index map[string]repository.FileIndexEntry, seen map[string]bool, roots []string) int {
+	removed := 0
+	for path := range index {
+		if seen[path] || !underAny(path, roots) {
+			continue
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			continue
+		}
+		n, err := s.RemoveMissing(path)
+		if err != nil {
+			log.Printf("Scan: failed to remove %s: %v", path, err)
+			continue
+		}
+		removed += n
+		if err := s.fileIndex.Delete(library.ID, path); err != nil {
+			log.Printf("Scan: failed to unindex %s: %v", path, err)
+		}
+	}
+	return removed
+}
+
+// underAny reports whether path lies under one of roots.
+func underAny(path string, roots []string) bool {
+	for _, root := range roots {
+		if strings.HasPrefix(path, strings.TrimRight(root, string(filepath.Separator))+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package scanner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/JustinTDCT/CineVault/internal/repository"
+	"github.com/google/uuid"
+)
+
+func TestIndexStatus(t *testing.T) {
+	mod := time.Date(2024, 3, 1, 20, 15, 30, 123456789, time.Local)
+	stored := repository.FileIndexEntry{FileSize: 1000, ModTime: indexModTime(mod), ProbeVersion: ProbeVersion}
+	with := func(change func(e *repository.FileIndexEntry)) repository.FileIndexEntry {
+		e := stored
+		change(&e)
+		return e
+	}
+
+	tests := []struct {
+		name    string
+		entry   repository.FileIndexEntry
+		indexed bool
+		size    int64
+		modTime time.Time
+		want    fileStatus
+	}{
+		{"not indexed", repository.FileIndexEntry{}, false, 1000, mod, fileNew},
+		{"unchanged", stored, true, 1000, mod, fileUnchanged},
+		{"nanoseconds the index doesn't keep", stored, true, 1000, mod.Add(200 * time.Nanosecond), fileUnchanged},
+		{"stored mtime read back in UTC", with(func(e *repository.FileIndexEntry) { e.ModTime = e.ModTime.UTC() }), true, 1000, mod, fileUnchanged},
+		{"mtime a microsecond later", stored, true, 1000, mod.Add(time.Microsecond), fileChanged},
+		{"mtime earlier", stored, true, 1000, mod.Add(-time.Second), fileChanged},
+		{"size differs", stored, true, 1001, mod, fileChanged},
+		{"older probe version", with(func(e *repository.FileIndexEntry) { e.ProbeVersion = ProbeVersion - 1 }), true, 1000, mod, fileStale},
+		{"changed wins over stale", with(func(e *repository.FileIndexEntry) { e.ProbeVersion = 0 }), true, 999, mod, fileChanged},
+	}
+	for _, tt := range tests {
+		if got := indexStatus(tt.entry, tt.indexed, tt.size, tt.modTime); got != tt.want {
+			t.Errorf("%s: indexStatus = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	if got := indexModTime(mod); got.Nanosecond() != 123456000 {
+		t.Errorf("indexModTime kept %d ns, want 123456000", got.Nanosecond())
+	}
+}
+
+// buildTree writes n movies of size bytes in folders of ten, plus files
+// every scan passes over.
+func buildTree(tb testing.TB, n int, size int) string {
+	tb.Helper()
+	root := tb.TempDir()
+	data := make([]byte, size)
+	for i := 0; i < n; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("Group %03d", i/10), fmt.Sprintf("Movie %04d (2020)", i))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			tb.Fatal(err)
+		}
+		data[0] = byte(i)
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("Movie %04d (2020).mkv", i)), data, 0644); err != nil {
+			tb.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "movie.nfo"), []byte("<movie/>"), 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	optimized := filepath.Join(root, "Group 000", models.OptimizedDirName)
+	os.MkdirAll(optimized, 0755)
+	os.WriteFile(filepath.Join(optimized, "Movie 0000 (2020) - 720p.mp4"), data, 0644)
+	os.WriteFile(filepath.Join(root, "Group 000", "Movie-sample.mkv"), data[:1024], 0644)
+	return root
+}
+
+// indexScan walks root as a scan does, hashing every queued file in a
+// probe slot (standing in for ffprobe) and indexing it. It returns the
+// files skipped and queued by status.
+func indexScan(tb testing.TB, s *Scanner, library *models.Library, root string,
+	index map[string]repository.FileIndexEntry, deep bool,
+) (skipped int, queued map[fileStatus]int) {
+	lim := s.limitsFor(library)
+	fileCh := make(chan scanFile, lim.workers()*4)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < lim.workers(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range fileCh {
+				lim.begin()
+				lim.run(lim.probe, func() {
+					if _, err := quickHash(f.path); err != nil {
+						tb.Error(err)
+					}
+				})
+				mu.Lock()
+				index[f.path] = repository.FileIndexEntry{FilePath: f.path, FileSize: f.size,
+					ModTime: indexModTime(f.modTime), ProbeVersion: ProbeVersion, MediaItemID: uuid.New()}
+				mu.Unlock()
+				lim.end()
+			}
+		}()
+	}
+
+	queued = make(map[fileStatus]int)
+	// Keep the walk's reads of the index off the workers' writes
+	snapshot := make(map[string]repository.FileIndexEntry, len(index))
+	for k, v := range index {
+		snapshot[k] = v
+	}
+	_, err := s.walkScanPath(library, root, snapshot, deep,
+		func(scanFile) { skipped++ },
+		func(f scanFile) {
+			queued[f.status]++
+			fileCh <- f
+		})
+	close(fileCh)
+	wg.Wait()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return skipped, queued
+}
+
+func TestWalkScanPath(t *testing.T) {
+	const n = 30
+	root := buildTree(t, n, 4096)
+	s := &Scanner{}
+	library := &models.Library{MediaType: models.MediaTypeMovies}
+	index := make(map[string]repository.FileIndexEntry)
+
+	if skipped, queued := indexScan(t, s, library, root, index, false); skipped != 0 || queued[fileNew] != n || len(queued) != 1 {
+		t.Fatalf("cold scan: %d skipped, queued %v; want all %d new", skipped, queued, n)
+	}
+	if skipped, queued := indexScan(t, s, library, root, index, false); skipped != n || len(queued) != 0 {
+		t.Fatalf("warm scan: %d skipped, queued %v; want all %d skipped", skipped, queued, n)
+	}
+
+	// One file replaced in place, one indexed by an older probe
+	touched := filepath.Join(root, "Group 001", "Movie 0012 (2020)", "Movie 0012 (2020).mkv")
+	if err := os.Chtimes(touched, time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	old := filepath.Join(root, "Group 002", "Movie 0020 (2020)", "Movie 0020 (2020).mkv")
+	e := index[old]
+	e.ProbeVersion = ProbeVersion - 1
+	index[old] = e
+
+	if skipped, queued := indexScan(t, s, library, root, index, true); skipped != 0 || queued[fileChanged] != 1 || queued[fileStale] != n-1 {
+		t.Fatalf("deep scan: %d skipped, queued %v; want 1 changed and %d stale", skipped, queued, n-1)
+	}
+	e = index[old]
+	e.ProbeVersion = ProbeVersion - 1
+	index[old] = e
+	if skipped, queued := indexScan(t, s, library, root, index, false); skipped != n-1 || queued[fileStale] != 1 || len(queued) != 1 {
+		t.Fatalf("scan after a probe version bump: %d skipped, queued %v; want 1 stale", skipped, queued)
+	}
+}
+
+// BenchmarkScan compares scans of the same tree with an empty index
+// (cold), a full one (warm: every file unchanged) and a deep scan, which
+// re-probes everything.
+func BenchmarkScan(b *testing.B) {
+	const n = 300
+	root := buildTree(b, n, 2*quickHashChunk)
+	s := &Scanner{}
+	library := &models.Library{MediaType: models.MediaTypeMovies}
+	full := make(map[string]repository.FileIndexEntry)
+	indexScan(b, s, library, root, full, false)
+
+	for _, bc := range []struct {
+		name  string
+		index func() map[string]repository.FileIndexEntry
+		deep  bool
+		probe int
+	}{
+		{"cold", func() map[string]repository.FileIndexEntry { return map[string]repository.FileIndexEntry{} }, false, n},
+		{"warm", func() map[string]repository.FileIndexEntry { return full }, false, 0},
+		{"deep", func() map[string]repository.FileIndexEntry { return full }, true, n},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, queued := indexScan(b, s, library, root, bc.index(), bc.deep)
+				if probed := queued[fileNew] + queued[fileStale] + queued[fileChanged]; probed != bc.probe {
+					b.Fatalf("probed %d files, want %d", probed, bc.probe)
+				}
+			}
+			b.ReportMetric(float64(n), "files/op")
+		})
+	}
+}
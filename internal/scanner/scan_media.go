@@ -30,8 +30,10 @@ func (s *Scanner) flushPendingMeta() {
 		return
 	}
 
+	s.mu.Lock()
 	items := s.pendingMeta
 	s.pendingMeta = nil
+	s.mu.Unlock()
 	log.Printf("Batch metadata: looking up %d items from cache server...", len(items))
 
 	lookupItems := make([]metadata.BatchLookupItem, len(items))
@@ -60,7 +62,7 @@ func (s *Scanner) flushPendingMeta() {
 }
 
 // but are missing OMDb ratings or cast/crew, and re-enriches them using concurrent workers.
-func (s *Scanner) reEnrichExistingItems(library *models.Library, onProgress ProgressFunc) {
+func (s *Scanner) reEnrichExistingItems(library *models.Library, numWorkers int, onProgress ProgressFunc) {
 	items, err := s.mediaRepo.ListItemsNeedingEnrichment(library.ID)
 	if err != nil {
 		log.Printf("Re-enrich: failed to list items: %v", err)
@@ -105,13 +107,12 @@ func (s *Scanner) reEnrichExistingItems(library *models.Library, onProgress Prog
 	}
 
 	total := len(enrichItems)
-	log.Printf("Re-enrich: %d items need OMDb ratings or cast enrichment (using 5 workers)", total)
+	log.Printf("Re-enrich: %d items need OMDb ratings or cast enrichment (using %d workers)", total, numWorkers)
 	if onProgress != nil {
 		onProgress(0, total, 0, "Enriching metadata...")
 	}
 
 	// Concurrent worker pool
-	const numWorkers = 5
 	itemCh := make(chan *models.MediaItem, numWorkers*2)
 	var wg sync.WaitGroup
 	var processed int64
@@ -427,7 +428,7 @@ func (s *Scanner) applyDirectMatch(item *models.MediaItem, match *models.Metadat
 
 	// Enrich with genres, ratings, etc.
 	if match.Source == "tmdb" {
-		s.enrichWithDetails(item.ID, match.ExternalID, item.MediaType, item.LockedFields)
+		s.enrichWithDetails(item.ID, match.ExternalID, item.MediaType, item.LockedFields, nil)
 	}
 
 	// Store external IDs
@@ -594,6 +595,60 @@ func (s *Scanner) applyNFOData(item *models.MediaItem, nfo *metadata.NFOData) {
 	}
 }
 
+// FindMatch finds the best match for query among a library's metadata
+// providers. A library with its own provider order or per-field sources
+// gets a match merged field by field from its providers; others get the
+// best single match, as FindBestMatch.
+func (s *Scanner) FindMatch(library *models.Library, query string, mediaType models.MediaType, minConfidence float64, year *int) *models.MetadataMatch {
+	if s.registry == nil || library == nil ||
+		(len(library.MetadataProviders) == 0 && len(library.MetadataFieldSources) == 0) {
+		return metadata.FindBestMatch(s.scrapers, query, mediaType, year)
+	}
+	return metadata.FindMergedMatch(s.registry.Providers(library), library.MetadataFieldSources,
+		query, mediaType, minConfidence, year)
+}
+
+// applyMergedFields writes the fields of a merged match that came from
+// providers other than its source, after the source's own enrichment
+// (enrichWithDetails) has written its values for them. Respects locks.
+func (s *Scanner) applyMergedFields(item *models.MediaItem, match *models.MetadataMatch) {
+	fields := metadata.SupplementaryFields(item, match)
+	pick := func(field string, v *string) *string {
+		if fields[field] {
+			return v
+		}
+		return nil
+	}
+
+	if fields[metadata.FieldContentRating] {
+		_ = s.mediaRepo.UpdateContentRating(item.ID, *match.ContentRating)
+	}
+	_ = s.mediaRepo.UpdateExtendedMetadataFull(item.ID, &repository.ExtendedMetadataUpdate{
+		OriginalTitle:    pick(metadata.FieldOriginalTitle, match.OriginalTitle),
+		ReleaseDate:      pick(metadata.FieldReleaseDate, match.ReleaseDate),
+		Tagline:          pick(metadata.FieldTagline, match.Tagline),
+		OriginalLanguage: pick(metadata.FieldOriginalLanguage, match.OriginalLanguage),
+		Country:          pick(metadata.FieldCountry, match.Country),
+		TrailerURL:       pick(metadata.FieldTrailer, match.TrailerURL),
+	})
+
+	if fields[metadata.FieldGenres] && s.tagRepo != nil {
+		s.linkGenreTags(item.ID, match.Genres)
+	}
+	if fields[metadata.FieldKeywords] {
+		s.linkMoodTags(item.ID, match.Keywords)
+		s.storeKeywords(item.ID, match.Keywords)
+	}
+	if fields[metadata.FieldBackdrop] && s.posterDir != "" {
+		filename := item.ID.String() + "_backdrop.jpg"
+		if _, err := metadata.DownloadPoster(*match.BackdropURL, filepath.Join(s.posterDir, "backdrops"), filename); err != nil {
+			log.Printf("Auto-match: backdrop download failed for %s: %v", item.ID, err)
+		} else {
+			_ = s.mediaRepo.UpdateBackdropPath(item.ID, "/previews/backdrops/"+filename)
+		}
+	}
+}
+
 // autoPopulateMetadata searches external sources and applies the best match.
 // When the cache server is enabled, it is tried first; direct TMDB is the fallback.
 // If parsed contains inline provider IDs (TMDB, IMDB), does a direct lookup instead.
@@ -610,7 +665,7 @@ func (s *Scanner) autoPopulateMetadata(library *models.Library, item *models.Med
 
 	// For TV shows with season grouping, match at the show level (not per-episode)
 	if item.MediaType == models.MediaTypeTVShows && item.TVShowID != nil {
-		s.autoMatchTVShow(*item.TVShowID)
+		s.autoMatchTVShow(library, *item.TVShowID)
 		return
 	}
 	// TV shows without season grouping fall through to per-item matching below
@@ -678,7 +733,7 @@ func (s *Scanner) autoPopulateMetadata(library *models.Library, item *models.Med
 	}
 
 	autoCfg := metadata.AutoMatchConfig(s.settingsRepo)
-	match := metadata.FindBestMatch(s.scrapers, searchQuery, item.MediaType, item.Year)
+	match := s.FindMatch(library, searchQuery, item.MediaType, autoCfg.MinConfidence, item.Year)
 	if match == nil || match.Confidence < autoCfg.MinConfidence {
 		if match != nil {
 			log.Printf("Auto-match: %q → %q rejected (confidence=%.2f < threshold=%.2f)",
@@ -720,7 +775,7 @@ func (s *Scanner) autoPopulateMetadata(library *models.Library, item *models.Med
 
 	// Get TMDB details for genres, IMDB ID, OMDb ratings, and cast
 	if match.Source == "tmdb" {
-		s.enrichWithDetails(item.ID, match.ExternalID, item.MediaType, item.LockedFields)
+		s.enrichWithDetails(item.ID, match.ExternalID, item.MediaType, item.LockedFields, metadata.DeferredFields(match))
 	}
 
 	// For MusicBrainz/OpenLibrary, enrich with full details
@@ -728,6 +783,11 @@ func (s *Scanner) autoPopulateMetadata(library *models.Library, item *models.Med
 		s.enrichNonTMDBDetails(item.ID, match, item.LockedFields)
 	}
 
+	// Fields a merged match took from other providers
+	if match.Provenance != nil {
+		s.applyMergedFields(item, match)
+	}
+
 	// Create artist/album from MusicBrainz match when not already linked
 	if match.Source == "musicbrainz" && match.ArtistName != "" {
 		s.linkMusicHierarchyFromMatch(item, match.ArtistName, match.ArtistMBID, match.AlbumTitle, match.Year)
@@ -1053,14 +1113,22 @@ func (s *Scanner) enrichNonTMDBDetails(itemID uuid.UUID, match *models.MetadataM
 // EnrichMatchedItem is the exported entry-point for extended metadata enrichment.
 // It fetches TMDB details (content rating, tagline, language, country, trailer),
 // TMDB credits, OMDb ratings, and fanart.tv artwork — all with per-field lock awareness.
-// Used by the metadata refresh handler after a base match is applied.
-func (s *Scanner) EnrichMatchedItem(itemID uuid.UUID, tmdbExternalID string, mediaType models.MediaType, lockedFields pq.StringArray) {
-	s.enrichWithDetails(itemID, tmdbExternalID, mediaType, lockedFields)
+// Used by the metadata refresh handler after a base match is applied; tags
+// a merged match takes from other providers are left to ApplyMergedFields.
+func (s *Scanner) EnrichMatchedItem(itemID uuid.UUID, match *models.MetadataMatch, mediaType models.MediaType, lockedFields pq.StringArray) {
+	s.enrichWithDetails(itemID, match.ExternalID, mediaType, lockedFields, metadata.DeferredFields(match))
+}
+
+// ApplyMergedFields is the exported entry-point for applyMergedFields, for
+// handlers that apply a match from FindMatch themselves.
+func (s *Scanner) ApplyMergedFields(item *models.MediaItem, match *models.MetadataMatch) {
+	s.applyMergedFields(item, match)
 }
 
 // enrichWithDetails fetches TMDB details, creates genre tags, fetches OMDb ratings, and populates cast.
-// lockedFields is passed through to respect per-field metadata locks.
-func (s *Scanner) enrichWithDetails(itemID uuid.UUID, tmdbExternalID string, mediaType models.MediaType, lockedFields pq.StringArray) {
+// lockedFields is passed through to respect per-field metadata locks; deferred
+// fields (metadata.DeferredFields) are skipped for a merged match to supply.
+func (s *Scanner) enrichWithDetails(itemID uuid.UUID, tmdbExternalID string, mediaType models.MediaType, lockedFields pq.StringArray, deferred map[string]bool) {
 	isLocked := func(field string) bool {
 		for _, f := range lockedFields {
 			if f == "*" || f == field {
@@ -1119,12 +1187,12 @@ func (s *Scanner) enrichWithDetails(itemID uuid.UUID, tmdbExternalID string, med
 	_ = s.mediaRepo.UpdateExtendedMetadataFull(itemID, detExtUpdate)
 
 	// Create/link genre tags (respecting genres lock)
-	if s.tagRepo != nil && len(details.Genres) > 0 && !isLocked("genres") {
+	if s.tagRepo != nil && len(details.Genres) > 0 && !isLocked("genres") && !deferred[metadata.FieldGenres] {
 		s.linkGenreTags(itemID, details.Genres)
 	}
 
 	// Link mood tags and store keywords
-	if len(details.Keywords) > 0 {
+	if len(details.Keywords) > 0 && !deferred[metadata.FieldKeywords] {
 		s.linkMoodTags(itemID, details.Keywords)
 		s.storeKeywords(itemID, details.Keywords)
 	}
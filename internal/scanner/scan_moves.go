@@ -0,0 +1,177 @@
+package scanner
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/JustinTDCT/CineVault/internal/fingerprint"
+	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/JustinTDCT/CineVault/internal/repository"
+	"github.com/google/uuid"
+)
+
+// ──────────────────── Move Detection ────────────────────
+
+// A file that is renamed or moved within its library keeps its media item,
+// so watch history, ratings, locked fields, editions and collections stay
+// with it. A new path is matched against items whose file of the same size
+// has gone missing, by quick content hash, else inode, else pHash.
+
+// moveStore is the part of the media repository move detection works on.
+type moveStore interface {
+	SetFileIdentity(id uuid.UUID, hash string, inode int64) error
+	ListMoveCandidates(libraryID uuid.UUID, size int64, excludePath string) ([]repository.MoveCandidate, error)
+	RelocateFile(libraryID uuid.UUID, oldPath, newPath, newName string) (int64, error)
+	ListFilePathsUnder(path string) (map[uuid.UUID]string, error)
+	Delete(id uuid.UUID) error
+}
+
+// quickHashChunk is how much of each end of a file the quick hash reads.
+const quickHashChunk = 64 << 10
+
+// movePhashSimilarity is how close pHashes must be to count as the same
+// file; the sizes already match, so only identical content gets near it.
+const movePhashSimilarity = 0.97
+
+// quickHash is a SHA-256 of a file's size and its first and last
+// quickHashChunk bytes: cheap on network mounts, and enough together with
+// the size to tell files apart.
+func quickHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	binary.Write(h, binary.LittleEndian, info.Size())
+	if _, err := io.CopyN(h, f, quickHashChunk); err != nil && err != io.EOF {
+		return "", err
+	}
+	if tail := info.Size() - quickHashChunk; tail > quickHashChunk {
+		if _, err := f.Seek(tail, io.SeekStart); err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+	} else if tail > 0 {
+		// Short file: the rest of it
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fileInode returns the inode number of a stat result, or 0.
+func fileInode(info os.FileInfo) int64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int64(st.Ino)
+	}
+	return 0
+}
+
+// storeFileIdentity records the hash and inode of an item's file so it is
+// recognised if it moves.
+func (s *Scanner) storeFileIdentity(id uuid.UUID, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	hash, err := quickHash(path)
+	if err != nil {
+		log.Printf("Scan: quick hash failed for %s: %v", path, err)
+		return
+	}
+	if err := s.moves.SetFileIdentity(id, hash, fileInode(info)); err != nil {
+		log.Printf("Scan: failed to store file identity for %s: %v", path, err)
+	}
+}
+
+// relocateMoved looks for an item in the library whose file moved to path
+// and points it, and any other items for the same file, there. It returns
+// the matched item's ID, or uuid.Nil if path is a new file.
+func (s *Scanner) relocateMoved(library *models.Library, path string, info os.FileInfo) uuid.UUID {
+	candidates, err := s.moves.ListMoveCandidates(library.ID, info.Size(), path)
+	if err != nil || len(candidates) == 0 {
+		return uuid.Nil
+	}
+
+	// Hashes of the new file, worked out only if a candidate needs them
+	var hash, phash string
+	for _, c := range candidates {
+		if _, err := os.Stat(c.FilePath); !os.IsNotExist(err) {
+			continue // still there: a copy, not a move
+		}
+
+		matched := false
+		switch {
+		case c.FileHash != nil && *c.FileHash != "":
+			if hash == "" {
+				if hash, err = quickHash(path); err != nil {
+					return uuid.Nil
+				}
+			}
+			matched = hash == *c.FileHash
+		case c.FileInode != nil && *c.FileInode != 0:
+			matched = *c.FileInode == fileInode(info)
+		case c.Phash != nil && *c.Phash != "" && c.DurationSeconds != nil && s.fingerprinter != nil:
+			if phash == "" {
+				if phash, err = s.fingerprinter.ComputePHash(path, *c.DurationSeconds); err != nil {
+					continue
+				}
+			}
+			matched = fingerprint.Similarity(phash, *c.Phash) >= movePhashSimilarity
+		}
+		if !matched {
+			continue
+		}
+
+		// Every item for the file moves with it, not just the matched one
+		n, err := s.moves.RelocateFile(library.ID, c.FilePath, path, info.Name())
+		if err != nil {
+			log.Printf("Scan: failed to move %s to %s: %v", c.FilePath, path, err)
+			return uuid.Nil
+		}
+		if n == 0 {
+			continue // claimed by another scan
+		}
+		log.Printf("Scan: detected move %s → %s (%d items)", c.FilePath, path, n)
+		s.storeFileIdentity(c.ID, path)
+		return c.ID
+	}
+	return uuid.Nil
+}
+
+// RemoveMissing deletes the items whose file was path, or under path for a
+// directory, and is no longer on disk. Items whose files were moved and
+// already picked up at their new path are left alone.
+func (s *Scanner) RemoveMissing(path string) (int, error) {
+	items, err := s.moves.ListFilePathsUnder(path)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for id, p := range items {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			continue
+		}
+		if err := s.moves.Delete(id); err != nil {
+			return removed, err
+		}
+		log.Printf("[scanner] removed missing file: %s", filepath.Base(p))
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,323 @@
+package scanner
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/JustinTDCT/CineVault/internal/repository"
+	"github.com/google/uuid"
+)
+
+// fakeMoves is an in-memory media_items table for move detection.
+type fakeMoves struct {
+	mu    sync.Mutex
+	items map[uuid.UUID]*fakeItem
+}
+
+type fakeItem struct {
+	path  string
+	size  int64
+	hash  *string
+	inode *int64
+}
+
+func newFakeMoves() *fakeMoves {
+	return &fakeMoves{items: make(map[uuid.UUID]*fakeItem)}
+}
+
+// add records a scanned file as a new item, without a stored identity.
+func (f *fakeMoves) add(t *testing.T, path string) uuid.UUID {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := uuid.New()
+	f.mu.Lock()
+	f.items[id] = &fakeItem{path: path, size: info.Size()}
+	f.mu.Unlock()
+	return id
+}
+
+func (f *fakeMoves) path(id uuid.UUID) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if it := f.items[id]; it != nil {
+		return it.path
+	}
+	return ""
+}
+
+func (f *fakeMoves) SetFileIdentity(id uuid.UUID, hash string, inode int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if it := f.items[id]; it != nil {
+		it.hash, it.inode = &hash, &inode
+	}
+	return nil
+}
+
+func (f *fakeMoves) ListMoveCandidates(_ uuid.UUID, size int64, excludePath string) ([]repository.MoveCandidate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []repository.MoveCandidate
+	for id, it := range f.items {
+		if it.size == size && it.path != excludePath {
+			out = append(out, repository.MoveCandidate{ID: id, FilePath: it.path, FileHash: it.hash, FileInode: it.inode})
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeMoves) RelocateFile(_ uuid.UUID, oldPath, newPath, _ string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int64
+	for _, it := range f.items {
+		if it.path == oldPath {
+			it.path = newPath
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeMoves) ListFilePathsUnder(path string) (map[uuid.UUID]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[uuid.UUID]string)
+	for id, it := range f.items {
+		if rel, err := filepath.Rel(path, it.path); err == nil && (rel == "." || filepath.IsLocal(rel)) {
+			out[id] = it.path
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeMoves) Delete(id uuid.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.items, id)
+	return nil
+}
+
+// writeMedia writes a file big enough for the quick hash to read both ends.
+func writeMedia(t *testing.T, path string, fill byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte{fill}, 3*quickHashChunk)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRelocateMoved(t *testing.T) {
+	library := &models.Library{ID: uuid.New()}
+	tests := []struct {
+		name     string
+		to       string // new path, under the library folder
+		identity bool   // store hash and inode, as a scan does
+		hashless bool   // only the inode is known, as for items scanned before hashes were
+		move     func(t *testing.T, old, new string)
+		moved    bool
+	}{
+		{"rename matched by hash", "Movie (2020)/Movie.2020.1080p.mkv", true, false, rename, true},
+		{"move to another folder matched by hash", "Movie (2020) [1080p]/Movie (2020).mkv", true, false, rename, true},
+		{"move matched by size and inode", "Movie (2020) [1080p]/Movie (2020).mkv", true, true, rename, true},
+		{"same-size file with other content", "Movie (2020)/Movie.2020.1080p.mkv", true, false, rewrite('y'), false},
+		{"same-size file with another inode", "Movie (2020)/Movie.2020.1080p.mkv", true, true, rewrite('x'), false},
+		{"copy with the original kept", "Movie (2020)/Movie.2020.1080p.mkv", true, false, copyFile, false},
+		{"no stored identity", "Movie (2020)/Movie.2020.1080p.mkv", false, false, rename, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			old := filepath.Join(dir, "Movie (2020)", "Movie (2020).mkv")
+			new := filepath.Join(dir, filepath.FromSlash(tt.to))
+			writeMedia(t, old, 'x')
+
+			store := newFakeMoves()
+			s := &Scanner{moves: store}
+			id := store.add(t, old)
+			if tt.identity {
+				s.storeFileIdentity(id, old)
+			}
+			if tt.hashless {
+				store.items[id].hash = nil
+			}
+
+			tt.move(t, old, new)
+			info, err := os.Stat(new)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := s.relocateMoved(library, new, info)
+			if tt.moved {
+				if got != id || store.path(id) != new {
+					t.Fatalf("relocateMoved = %v, item at %q; want %v at %q", got, store.path(id), id, new)
+				}
+				if it := store.items[id]; it.hash == nil || *it.hash == "" {
+					t.Error("identity not stored at the new path")
+				}
+			} else if got != uuid.Nil || store.path(id) != old {
+				t.Fatalf("relocateMoved = %v, item at %q; want a new file", got, store.path(id))
+			}
+
+			// The removal that follows the move leaves a relocated item alone
+			removed, err := s.RemoveMissing(old)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, oldGone := os.Stat(old)
+			wantRemoved := 0
+			if !tt.moved && os.IsNotExist(oldGone) {
+				wantRemoved = 1
+			}
+			if removed != wantRemoved {
+				t.Errorf("RemoveMissing removed %d, want %d", removed, wantRemoved)
+			}
+		})
+	}
+}
+
+func TestRelocateMovedFolder(t *testing.T) {
+	dir := t.TempDir()
+	library := &models.Library{ID: uuid.New()}
+	store := newFakeMoves()
+	s := &Scanner{moves: store}
+
+	oldDir := filepath.Join(dir, "TV", "Show", "Season 1")
+	ids := make(map[string]uuid.UUID)
+	for i, name := range []string{"S01E01.mkv", "S01E02.mkv"} {
+		path := filepath.Join(oldDir, name)
+		writeMedia(t, path, byte('a'+i))
+		ids[name] = store.add(t, path)
+		s.storeFileIdentity(ids[name], path)
+	}
+
+	newDir := filepath.Join(dir, "TV", "Show (2019)", "Season 01")
+	if err := os.MkdirAll(filepath.Dir(newDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(oldDir, newDir); err != nil {
+		t.Fatal(err)
+	}
+	for name, id := range ids {
+		path := filepath.Join(newDir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.relocateMoved(library, path, info); got != id {
+			t.Errorf("%s: relocateMoved = %v, want %v", name, got, id)
+		}
+	}
+	if removed, err := s.RemoveMissing(oldDir); err != nil || removed != 0 {
+		t.Errorf("RemoveMissing of the old folder = %d, %v; want nothing removed", removed, err)
+	}
+	if len(store.items) != len(ids) {
+		t.Errorf("%d items left, want %d", len(store.items), len(ids))
+	}
+}
+
+func TestRelocateMovedMultiEpisode(t *testing.T) {
+	dir := t.TempDir()
+	library := &models.Library{ID: uuid.New()}
+	store := newFakeMoves()
+	s := &Scanner{moves: store}
+
+	// S01E01-E03 is one file with an item per episode; only the first
+	// gets a file identity
+	old := filepath.Join(dir, "Show", "Season 1", "Show.S01E01-E03.mkv")
+	writeMedia(t, old, 'x')
+	first := store.add(t, old)
+	s.storeFileIdentity(first, old)
+	ids := []uuid.UUID{first, store.add(t, old), store.add(t, old)}
+
+	new := filepath.Join(dir, "Show", "Season 1", "Show - S01E01-E03 - Pilot.mkv")
+	rename(t, old, new)
+	info, err := os.Stat(new)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.relocateMoved(library, new, info); got != first {
+		t.Fatalf("relocateMoved = %v, want %v", got, first)
+	}
+	for i, id := range ids {
+		if p := store.path(id); p != new {
+			t.Errorf("episode %d at %q, want %q", i+1, p, new)
+		}
+	}
+	if removed, err := s.RemoveMissing(old); err != nil || removed != 0 {
+		t.Errorf("RemoveMissing of the old path = %d, %v; want nothing removed", removed, err)
+	}
+	if len(store.items) != len(ids) {
+		t.Errorf("%d items left, want %d", len(store.items), len(ids))
+	}
+}
+
+func TestQuickHash(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.mkv"), filepath.Join(dir, "b.mkv")
+	writeMedia(t, a, 'x')
+	writeMedia(t, b, 'x')
+	ha, errA := quickHash(a)
+	hb, errB := quickHash(b)
+	if errA != nil || errB != nil || ha != hb {
+		t.Fatalf("identical files hash differently: %s %v, %s %v", ha, errA, hb, errB)
+	}
+
+	// A change in the tail is seen; one in the unread middle is not
+	f, err := os.OpenFile(b, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte{'y'}, 3*quickHashChunk/2)
+	if hb, _ = quickHash(b); hb != ha {
+		t.Error("a change between the hashed ends altered the hash")
+	}
+	f.WriteAt([]byte{'y'}, 3*quickHashChunk-1)
+	f.Close()
+	if hb, _ = quickHash(b); hb == ha {
+		t.Error("a change in the last chunk did not alter the hash")
+	}
+}
+
+func rename(t *testing.T, old, new string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(new), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(old, new); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rewrite writes a file of the same size at the new path, then deletes
+// the old one (in that order, so the new file can't reuse its inode).
+func rewrite(fill byte) func(t *testing.T, old, new string) {
+	return func(t *testing.T, old, new string) {
+		t.Helper()
+		writeMedia(t, new, fill)
+		if err := os.Remove(old); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func copyFile(t *testing.T, old, new string) {
+	t.Helper()
+	data, err := os.ReadFile(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(new, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,75 @@
+package scanner
+
+import (
+	"runtime"
+
+	"github.com/JustinTDCT/CineVault/internal/models"
+)
+
+// ──────────────────── Scan Worker Pool ────────────────────
+
+// A library scan hands files to a pool of workers. What a file waits on is
+// split three ways, each with its own limit: probing (ffprobe, hashing,
+// screenshots) is local I/O, matching is remote metadata calls, and the
+// rest (parsing, hierarchy, database writes) is CPU. A slow mount then
+// doesn't stall matching, nor a slow metadata source probing.
+
+// Server-wide defaults, used for limits a library leaves at 0.
+const (
+	defaultProbeWorkers    = 4
+	defaultMetadataWorkers = 5
+)
+
+// scanLimits are one scan's concurrency limits, as semaphores.
+type scanLimits struct {
+	probe    chan struct{}
+	cpu      chan struct{}
+	metadata chan struct{}
+}
+
+// SetConcurrency sets the default scan limits; 0 keeps the built-in
+// default (one CPU worker per CPU).
+func (s *Scanner) SetConcurrency(probe, cpu, metadata int) {
+	s.probeWorkers, s.cpuWorkers, s.metadataWorkers = probe, cpu, metadata
+}
+
+// limitsFor returns the limits for a scan of library.
+func (s *Scanner) limitsFor(library *models.Library) *scanLimits {
+	pick := func(lib, server, fallback int) int {
+		switch {
+		case lib > 0:
+			return lib
+		case server > 0:
+			return server
+		}
+		return fallback
+	}
+	return &scanLimits{
+		probe:    make(chan struct{}, pick(library.ScanProbeWorkers, s.probeWorkers, defaultProbeWorkers)),
+		cpu:      make(chan struct{}, pick(library.ScanCPUWorkers, s.cpuWorkers, runtime.NumCPU())),
+		metadata: make(chan struct{}, pick(library.ScanMetadataWorkers, s.metadataWorkers, defaultMetadataWorkers)),
+	}
+}
+
+// workers is how many files a scan keeps in flight: enough to fill every
+// stage at once.
+func (l *scanLimits) workers() int {
+	return cap(l.probe) + cap(l.cpu) + cap(l.metadata)
+}
+
+// begin takes a CPU slot for a worker starting on a file; end releases it.
+func (l *scanLimits) begin() { l.cpu <- struct{}{} }
+func (l *scanLimits) end()   { <-l.cpu }
+
+// run runs fn in a probe or metadata slot in place of the worker's CPU
+// slot. Workers never hold two slots, so the stages can't deadlock; fn
+// must not call run itself.
+func (l *scanLimits) run(stage chan struct{}, fn func()) {
+	<-l.cpu
+	stage <- struct{}{}
+	defer func() {
+		<-stage
+		l.cpu <- struct{}{}
+	}()
+	fn()
+}
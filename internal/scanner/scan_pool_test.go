@@ -0,0 +1,134 @@
+package scanner
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JustinTDCT/CineVault/internal/models"
+)
+
+// gauge counts what is inside a stage and keeps its high-water mark.
+type gauge struct {
+	now, peak atomic.Int32
+}
+
+func (g *gauge) enter() {
+	n := g.now.Add(1)
+	for {
+		peak := g.peak.Load()
+		if n <= peak || g.peak.CompareAndSwap(peak, n) {
+			return
+		}
+	}
+}
+
+func (g *gauge) leave() { g.now.Add(-1) }
+
+func TestScanLimits(t *testing.T) {
+	tests := []struct {
+		name                 string
+		probe, cpu, metadata int
+		files                int
+	}{
+		{"one of each", 1, 1, 1, 200},
+		{"small limits", 2, 1, 3, 500},
+		{"CPU bound", 1, 4, 1, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Scanner{}
+			s.SetConcurrency(tt.probe, tt.cpu, tt.metadata)
+			lim := s.limitsFor(&models.Library{})
+
+			var probe, cpu, metadata gauge
+			var done atomic.Int32
+			files := make(chan int, tt.files)
+			for i := 0; i < tt.files; i++ {
+				files <- i
+			}
+			close(files)
+
+			// A file as processScanFile handles one: CPU work between a
+			// probe and a metadata lookup, some files skipping either
+			work := func(g *gauge) {
+				g.enter()
+				runtime.Gosched()
+				g.leave()
+			}
+			var wg sync.WaitGroup
+			// More workers than the scan would start, to crowd every stage
+			for w := 0; w < 2*lim.workers(); w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for f := range files {
+						lim.begin()
+						work(&cpu)
+						if f%3 != 0 {
+							lim.run(lim.probe, func() { work(&probe) })
+						}
+						work(&cpu)
+						if f%2 == 0 {
+							lim.run(lim.metadata, func() { work(&metadata) })
+						}
+						work(&cpu)
+						lim.end()
+						done.Add(1)
+					}
+				}()
+			}
+
+			finished := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(finished)
+			}()
+			select {
+			case <-finished:
+			case <-time.After(10 * time.Second):
+				t.Fatalf("deadlock: %d of %d files done", done.Load(), tt.files)
+			}
+
+			if int(done.Load()) != tt.files {
+				t.Errorf("%d files done, want %d", done.Load(), tt.files)
+			}
+			for _, st := range []struct {
+				name  string
+				g     *gauge
+				limit int
+			}{
+				{"probe", &probe, tt.probe},
+				{"cpu", &cpu, tt.cpu},
+				{"metadata", &metadata, tt.metadata},
+			} {
+				if peak := int(st.g.peak.Load()); peak > st.limit || peak == 0 {
+					t.Errorf("%s: peak %d in flight, limit %d", st.name, peak, st.limit)
+				}
+			}
+			if len(lim.probe)+len(lim.cpu)+len(lim.metadata) != 0 {
+				t.Error("slots still held after every worker finished")
+			}
+		})
+	}
+}
+
+func TestLimitsFor(t *testing.T) {
+	s := &Scanner{}
+	lim := s.limitsFor(&models.Library{})
+	if cap(lim.probe) != defaultProbeWorkers || cap(lim.cpu) != runtime.NumCPU() || cap(lim.metadata) != defaultMetadataWorkers {
+		t.Errorf("built-in defaults = %d/%d/%d", cap(lim.probe), cap(lim.cpu), cap(lim.metadata))
+	}
+
+	s.SetConcurrency(2, 3, 0)
+	lim = s.limitsFor(&models.Library{ScanProbeWorkers: 7})
+	if cap(lim.probe) != 7 || cap(lim.cpu) != 3 || cap(lim.metadata) != defaultMetadataWorkers {
+		t.Errorf("library over server over built-in = %d/%d/%d, want 7/3/%d",
+			cap(lim.probe), cap(lim.cpu), cap(lim.metadata), defaultMetadataWorkers)
+	}
+	if lim.workers() != 7+3+defaultMetadataWorkers {
+		t.Errorf("workers = %d", lim.workers())
+	}
+}
@@ -30,6 +30,9 @@ func (s *Scanner) handleTVHierarchy(library *models.Library, item *models.MediaI
 
 	// Find or create show — use in-memory folder map to avoid duplicate creation
 	// when autoMatchTVShow renames the title in the DB (e.g. "24 - Legacy" → "24").
+	// Locked so concurrent workers don't create the same show or season twice.
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	folderKey := library.ID.String() + "|" + strings.ToLower(showName)
 	show, ok := s.scanShowsByFolder[folderKey]
 	if !ok {
@@ -147,11 +150,14 @@ func (s *Scanner) cleanShowName(name string) string {
 // autoMatchTVShow searches for a TV show and applies metadata to the show record,
 // then fetches episode-level metadata from TMDB for each season.
 // Only runs once per show per scan.
-func (s *Scanner) autoMatchTVShow(showID uuid.UUID) {
-	if s.matchedShows[showID] {
+func (s *Scanner) autoMatchTVShow(library *models.Library, showID uuid.UUID) {
+	s.mu.Lock()
+	matched := s.matchedShows[showID]
+	s.matchedShows[showID] = true
+	s.mu.Unlock()
+	if matched {
 		return
 	}
-	s.matchedShows[showID] = true
 
 	show, err := s.tvRepo.GetShowByID(showID)
 	if err != nil {
@@ -182,8 +188,8 @@ func (s *Scanner) autoMatchTVShow(showID uuid.UUID) {
 		}
 	}
 
-	// ── Fall back to direct TMDB ──
-	match := metadata.FindBestMatch(s.scrapers, searchQuery, models.MediaTypeTVShows)
+	// ── Fall back to the library's providers ──
+	match := s.FindMatch(library, searchQuery, models.MediaTypeTVShows, metadata.DefaultManualMinMatch, nil)
 	if match == nil {
 		log.Printf("Auto-match: no TV match for %q", searchQuery)
 		return
@@ -215,7 +221,7 @@ func (s *Scanner) autoMatchTVShow(showID uuid.UUID) {
 	if match.Source == "tmdb" && match.ExternalID != "" {
 		s.enrichTVShowDetails(showID, match.ExternalID)
 		// Queue episode-level metadata fetch for after all files are scanned
-		s.pendingEpisodeMeta[showID] = match.ExternalID
+		s.queueEpisodeMeta(showID, match.ExternalID)
 	}
 
 	// Contribute TV show to cache server in background
@@ -282,7 +288,7 @@ func (s *Scanner) applyTVShowCacheResult(showID uuid.UUID, show *models.TVShow,
 
 	// Queue episode-level metadata fetch if we have a TMDB external ID
 	if match.ExternalID != "" {
-		s.pendingEpisodeMeta[showID] = match.ExternalID
+		s.queueEpisodeMeta(showID, match.ExternalID)
 	}
 }
 
@@ -517,7 +523,14 @@ func (s *Scanner) populateEpisodeMetadata(showID uuid.UUID, show *models.TVShow)
 		return
 	}
 
-	s.pendingEpisodeMeta[showID] = match.ExternalID
+	s.queueEpisodeMeta(showID, match.ExternalID)
+}
+
+// queueEpisodeMeta queues a show's episode metadata fetch for after the scan.
+func (s *Scanner) queueEpisodeMeta(showID uuid.UUID, tmdbID string) {
+	s.mu.Lock()
+	s.pendingEpisodeMeta[showID] = tmdbID
+	s.mu.Unlock()
 }
 
 // extractYear tries to find a 4-digit year in a filename using the improved patterns.
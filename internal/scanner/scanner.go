@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/JustinTDCT/CineVault/internal/ffmpeg"
+	"github.com/JustinTDCT/CineVault/internal/fingerprint"
 	"github.com/JustinTDCT/CineVault/internal/metadata"
 	"github.com/JustinTDCT/CineVault/internal/models"
 	"github.com/JustinTDCT/CineVault/internal/repository"
@@ -35,8 +36,15 @@ type Scanner struct {
 	sisterRepo    *repository.SisterRepository
 	seriesRepo    *repository.SeriesRepository
 	tracksRepo    *repository.TracksRepository
+	fileIndex     *repository.FileIndexRepository
 	scrapers      []metadata.Scraper
+	registry      *metadata.Registry
 	posterDir     string
+	// moves is mediaRepo as move detection sees it
+	moves moveStore
+	// fingerprinter computes pHashes to recognise moved files scanned
+	// before file hashes were stored
+	fingerprinter *fingerprint.Fingerprinter
 	// matchedShows tracks TV show IDs already matched this scan to avoid duplicate lookups
 	matchedShows  map[uuid.UUID]bool
 	// pendingEpisodeMeta tracks show IDs → TMDB external IDs for post-scan episode metadata fetch
@@ -56,13 +64,20 @@ type Scanner struct {
 	pendingMeta []pendingMetaItem
 	// mu protects concurrent access during parallel enrichment
 	mu sync.RWMutex
+	// scanMu runs library scans one at a time, as they share the per-scan
+	// state above
+	scanMu sync.Mutex
+	// Default scan concurrency (see SetConcurrency); 0 = built-in default
+	probeWorkers, cpuWorkers, metadataWorkers int
 }
 
 // scanFile holds path info for the concurrent processing queue.
 type scanFile struct {
-	path string
-	name string
-	size int64
+	path    string
+	name    string
+	size    int64
+	modTime time.Time
+	status  fileStatus
 }
 
 // pendingMetaItem is queued during scan for deferred batch cache lookup.
@@ -109,7 +124,7 @@ func NewScanner(ffprobePath, ffmpegPath, hwaccel string, mediaRepo *repository.M
 	tagRepo *repository.TagRepository, performerRepo *repository.PerformerRepository,
 	settingsRepo *repository.SettingsRepository, sisterRepo *repository.SisterRepository,
 	seriesRepo *repository.SeriesRepository, tracksRepo *repository.TracksRepository,
-	scrapers []metadata.Scraper, posterDir string,
+	fileIndex *repository.FileIndexRepository, registry *metadata.Registry, posterDir string,
 ) *Scanner {
 	if hwaccel == "" {
 		hwaccel = "none"
@@ -119,6 +134,7 @@ func NewScanner(ffprobePath, ffmpegPath, hwaccel string, mediaRepo *repository.M
 		ffmpegPath:         ffmpegPath,
 		hwaccel:            hwaccel,
 		mediaRepo:          mediaRepo,
+		moves:              mediaRepo,
 		tvRepo:             tvRepo,
 		musicRepo:          musicRepo,
 		audiobookRepo:      audiobookRepo,
@@ -129,8 +145,11 @@ func NewScanner(ffprobePath, ffmpegPath, hwaccel string, mediaRepo *repository.M
 		sisterRepo:         sisterRepo,
 		seriesRepo:         seriesRepo,
 		tracksRepo:         tracksRepo,
-		scrapers:           scrapers,
+		fileIndex:          fileIndex,
+		scrapers:           registry.All(),
+		registry:           registry,
 		posterDir:          posterDir,
+		fingerprinter:      fingerprint.NewFingerprinter(ffmpegPath, posterDir, hwaccel),
 		matchedShows:       make(map[uuid.UUID]bool),
 		pendingEpisodeMeta: make(map[uuid.UUID]string),
 		pendingMultiParts:  make(map[string][]multiPartEntry),
@@ -149,14 +168,21 @@ func (s *Scanner) PreviewDir() string {
 // ProgressFunc reports scan progress: current processed count, total eligible files, files added so far, current filename.
 type ProgressFunc func(current, total, added int, filename string)
 
-func (s *Scanner) ScanLibrary(library *models.Library, progressFn ...ProgressFunc) (*models.ScanResult, error) {
+// ScanLibrary scans a library's folders. Files unchanged since the last scan
+// are skipped using the file index unless deep is set, which re-probes
+// every file.
+func (s *Scanner) ScanLibrary(library *models.Library, deep bool, progressFn ...ProgressFunc) (*models.ScanResult, error) {
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
 	result := &models.ScanResult{}
 
 	// Reset per-scan caches
+	s.mu.Lock()
 	s.artistCache = make(map[string]*models.Artist)
 	s.albumCache = make(map[string]*models.Album)
 	s.genreCache = make(map[string]uuid.UUID)
 	s.pendingMeta = nil
+	s.mu.Unlock()
 
 	// Determine which folders to scan: use library.Folders if available, else fall back to library.Path
 	scanPaths := []string{}
@@ -192,11 +218,21 @@ func (s *Scanner) ScanLibrary(library *models.Library, progressFn ...ProgressFun
 		shouldRetrieveMetadata = false
 	}
 
+	lim := s.limitsFor(library)
+	log.Printf("Scan: %s with %d probe, %d CPU and %d metadata workers",
+		library.Name, cap(lim.probe), cap(lim.cpu), cap(lim.metadata))
+
 	// Atomic counters for concurrent processing (8C)
-	var filesFound, filesSkipped, filesAdded int64
+	var filesFound, filesSkipped, filesAdded, filesUpdated, filesChanged int64
 	var errorsMu sync.Mutex
 	var scanErrors []string
 
+	// What the last scan saw; seen collects this scan's files, and walked
+	// the folders fully walked, for spotting removed files
+	index := s.loadFileIndex(library.ID)
+	seen := make(map[string]bool)
+	var walked []string
+
 	for _, scanPath := range scanPaths {
 		log.Printf("Scanning folder: %s", scanPath)
 
@@ -226,11 +262,8 @@ func (s *Scanner) ScanLibrary(library *models.Library, progressFn ...ProgressFun
 			}
 		}
 
-		// 8A: Symlink cycle protection
-		visitedDirs := make(map[string]bool)
-
 		// 8C: Buffered channel and worker pool
-		const numWorkers = 8
+		numWorkers := lim.workers()
 		fileCh := make(chan scanFile, numWorkers*4)
 		var wg sync.WaitGroup
 
@@ -239,49 +272,29 @@ func (s *Scanner) ScanLibrary(library *models.Library, progressFn ...ProgressFun
 			go func() {
 				defer wg.Done()
 				for f := range fileCh {
-					s.processScanFile(library, scanPath, f, shouldRetrieveMetadata,
-						&filesFound, &filesSkipped, &filesAdded, &errorsMu, &scanErrors,
+					lim.begin()
+					s.processScanFile(library, scanPath, f, lim, shouldRetrieveMetadata,
+						&filesFound, &filesSkipped, &filesAdded, &filesUpdated, &filesChanged, &errorsMu, &scanErrors,
 						onProgress, &totalFiles)
+					lim.end()
 				}
 			}()
 		}
 
-		// WalkDir: collect files, symlink check for dirs
-		err := filepath.WalkDir(scanPath, func(path string, d os.DirEntry, err error) error {
-			if err != nil {
-				return nil
-			}
-			if d.IsDir() {
-				realPath, eerr := filepath.EvalSymlinks(path)
-				if eerr != nil {
-					return nil
+		// Unchanged files need no database lookup and no ffprobe
+		filesInPath, err := s.walkScanPath(library, scanPath, index, deep,
+			func(f scanFile) {
+				seen[f.path] = true
+				found := atomic.AddInt64(&filesFound, 1)
+				atomic.AddInt64(&filesSkipped, 1)
+				if onProgress != nil {
+					onProgress(int(found), int(atomic.LoadInt64(&totalFiles)), int(atomic.LoadInt64(&filesAdded)), f.name)
 				}
-				if visitedDirs[realPath] {
-					return filepath.SkipDir
-				}
-				visitedDirs[realPath] = true
-				return nil
-			}
-
-			ext := strings.ToLower(filepath.Ext(path))
-			if !s.isValidExtension(library.MediaType, ext) {
-				return nil
-			}
-
-			info, ierr := d.Info()
-			if ierr != nil {
-				return nil
-			}
-
-			extraType := IsExtraFile(path, info.Size())
-			if extraType == "sample" {
-				log.Printf("Skipping sample file: %s", info.Name())
-				return nil
-			}
-
-			fileCh <- scanFile{path: path, name: info.Name(), size: info.Size()}
-			return nil
-		})
+			},
+			func(f scanFile) {
+				seen[f.path] = true
+				fileCh <- f
+			})
 
 		close(fileCh)
 		wg.Wait()
@@ -290,13 +303,22 @@ func (s *Scanner) ScanLibrary(library *models.Library, progressFn ...ProgressFun
 			errorsMu.Lock()
 			scanErrors = append(scanErrors, fmt.Sprintf("walk error for %s: %v", scanPath, err))
 			errorsMu.Unlock()
+		} else if filesInPath > 0 {
+			// An empty folder is more likely an unmounted share than a
+			// library with every file deleted
+			walked = append(walked, scanPath)
 		}
 	}
 
+	// Post-scan: remove items whose files are gone
+	result.FilesRemoved = s.removeUnseen(library, index, seen, walked)
+
 	// Copy atomic counters to result
 	result.FilesFound = int(filesFound)
 	result.FilesSkipped = int(filesSkipped)
 	result.FilesAdded = int(filesAdded)
+	result.FilesUpdated = int(filesUpdated)
+	result.FilesChanged = int(filesChanged)
 	result.Errors = scanErrors
 
 	// Post-scan: group multi-part files (CD-x, DISC-x, PART-x) into sister groups
@@ -319,23 +341,90 @@ func (s *Scanner) ScanLibrary(library *models.Library, progressFn ...ProgressFun
 
 	// Post-scan: re-enrich existing items that are missing OMDb ratings or cast
 	if shouldRetrieveMetadata && len(s.scrapers) > 0 {
-		s.reEnrichExistingItems(library, onProgress)
+		s.reEnrichExistingItems(library, cap(lim.metadata), onProgress)
 	}
 
 	// Reset trackers for next scan
+	s.mu.Lock()
 	s.matchedShows = make(map[uuid.UUID]bool)
 	s.pendingEpisodeMeta = make(map[uuid.UUID]string)
 	s.pendingMultiParts = make(map[string][]multiPartEntry)
 	s.scanShowsByFolder = make(map[string]*models.TVShow)
+	s.mu.Unlock()
 
 	return result, nil
 }
 
+// walkScanPath walks one scan folder and sorts its media files by their
+// index status: unchanged files go to skip and the rest to queue. A deep
+// scan queues every file, as stale unless it changed. It returns the
+// number of files found.
+func (s *Scanner) walkScanPath(library *models.Library, scanPath string, index map[string]repository.FileIndexEntry,
+	deep bool, skip, queue func(scanFile),
+) (int, error) {
+	// 8A: Symlink cycle protection
+	visitedDirs := make(map[string]bool)
+
+	// WalkDir: collect files, symlink check for dirs
+	filesInPath := 0
+	err := filepath.WalkDir(scanPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			// Optimized versions live beside the originals
+			if d.Name() == models.OptimizedDirName {
+				return filepath.SkipDir
+			}
+			realPath, eerr := filepath.EvalSymlinks(path)
+			if eerr != nil {
+				return nil
+			}
+			if visitedDirs[realPath] {
+				return filepath.SkipDir
+			}
+			visitedDirs[realPath] = true
+			return nil
+		}
+
+		ext := strings.ToLower(filepath.Ext(path))
+		if !s.isValidExtension(library.MediaType, ext) {
+			return nil
+		}
+
+		info, ierr := d.Info()
+		if ierr != nil {
+			return nil
+		}
+
+		extraType := IsExtraFile(path, info.Size())
+		if extraType == "sample" {
+			log.Printf("Skipping sample file: %s", info.Name())
+			return nil
+		}
+
+		f := scanFile{path: path, name: info.Name(), size: info.Size(), modTime: info.ModTime()}
+		entry, indexed := index[path]
+		f.status = indexStatus(entry, indexed, f.size, f.modTime)
+		filesInPath++
+		if deep && f.status != fileChanged {
+			f.status = fileStale
+		} else if f.status == fileUnchanged {
+			skip(f)
+			return nil
+		}
+		queue(f)
+		return nil
+	})
+	return filesInPath, err
+}
+
 // processScanFile handles a single file: DB check, ffprobe, metadata, persist.
-// Used by concurrent workers; updates counters via atomics and mutex.
-func (s *Scanner) processScanFile(library *models.Library, scanPath string, f scanFile,
+// Used by concurrent workers holding a CPU slot of lim; probing and metadata
+// lookups run in their own slots. Updates counters via atomics and mutex.
+func (s *Scanner) processScanFile(library *models.Library, scanPath string, f scanFile, lim *scanLimits,
 	shouldRetrieveMetadata bool,
-	filesFound, filesSkipped, filesAdded *int64,
+	filesFound, filesSkipped, filesAdded, filesUpdated, filesChanged *int64,
 	errorsMu *sync.Mutex, errors *[]string,
 	onProgress ProgressFunc, totalFiles *int64,
 ) {
@@ -354,13 +443,43 @@ func (s *Scanner) processScanFile(library *models.Library, scanPath string, f sc
 		return
 	}
 	if existing != nil {
-		if existing.PosterPath == nil && s.isScreenshottableType(library.MediaType) {
-			s.generateScreenshotPoster(existing)
+		lim.run(lim.probe, func() {
+			if existing.PosterPath == nil && s.isScreenshottableType(library.MediaType) {
+				s.generateScreenshotPoster(existing)
+			}
+			// Items scanned before file identities were kept
+			if existing.FileHash == nil {
+				s.storeFileIdentity(existing.ID, path)
+			}
+		})
+		if f.status == fileChanged || f.status == fileStale {
+			var err error
+			lim.run(lim.probe, func() { err = s.reprobe(library, existing, f.status) })
+			if err != nil {
+				errorsMu.Lock()
+				*errors = append(*errors, fmt.Sprintf("re-probe failed for %s: %v", path, err))
+				errorsMu.Unlock()
+				return
+			}
+			atomic.AddInt64(filesChanged, 1)
+		} else {
+			atomic.AddInt64(filesSkipped, 1)
 		}
-		atomic.AddInt64(filesSkipped, 1)
+		s.indexFile(library.ID, path, size, f.modTime, existing.ID)
 		return
 	}
 
+	// A file that moved keeps its item
+	if info, err := os.Stat(path); err == nil {
+		var id uuid.UUID
+		lim.run(lim.probe, func() { id = s.relocateMoved(library, path, info) })
+		if id != uuid.Nil {
+			atomic.AddInt64(filesUpdated, 1)
+			s.indexFile(library.ID, path, size, f.modTime, id)
+			return
+		}
+	}
+
 	parsed := s.parseFilename(name, library.MediaType)
 	extraType := IsExtraFile(path, size)
 
@@ -452,7 +571,9 @@ func (s *Scanner) processScanFile(library *models.Library, scanPath string, f sc
 
 	var probeResult *ffmpeg.ProbeResult
 	if s.isProbeableType(library.MediaType) {
-		probe, probeErr := s.ffprobe.Probe(path)
+		var probe *ffmpeg.ProbeResult
+		var probeErr error
+		lim.run(lim.probe, func() { probe, probeErr = s.ffprobe.Probe(path) })
 		if probeErr != nil {
 			log.Printf("ffprobe failed for %s: %v", path, probeErr)
 			errorsMu.Lock()
@@ -568,6 +689,8 @@ func (s *Scanner) processScanFile(library *models.Library, scanPath string, f sc
 		errorsMu.Unlock()
 		return
 	}
+	lim.run(lim.probe, func() { s.storeFileIdentity(item.ID, path) })
+	s.indexFile(library.ID, path, size, f.modTime, item.ID)
 
 	// Link genre tag from embedded metadata (must be after Create)
 	if parsed.Genre != "" && s.tagRepo != nil {
@@ -659,7 +782,7 @@ func (s *Scanner) processScanFile(library *models.Library, scanPath string, f sc
 			s.linkGenreTags(item.ID, nfoData.Genres)
 		}
 	} else if shouldRetrieveMetadata && item.ExtraType == nil {
-		s.autoPopulateMetadata(library, item, parsed)
+		lim.run(lim.metadata, func() { s.autoPopulateMetadata(library, item, parsed) })
 	}
 
 	if library.NFOExport && item.Description != nil {
@@ -673,7 +796,7 @@ func (s *Scanner) processScanFile(library *models.Library, scanPath string, f sc
 	}
 
 	if item.PosterPath == nil && s.isScreenshottableType(library.MediaType) {
-		s.generateScreenshotPoster(item)
+		lim.run(lim.probe, func() { s.generateScreenshotPoster(item) })
 	}
 
 	atomic.AddInt64(filesAdded, 1)
@@ -693,9 +816,24 @@ func (s *Scanner) ScanSingleFile(library *models.Library, filePath string) error
 		return nil
 	}
 
-	// Check if already scanned
+	// Check if already scanned; one replaced in place is re-probed
 	existing, _ := s.mediaRepo.GetByFilePath(filePath)
 	if existing != nil {
+		if s.fileIndex == nil {
+			return nil
+		}
+		e, indexed, err := s.fileIndex.Get(library.ID, filePath)
+		if err != nil || !indexed || indexStatus(e, indexed, info.Size(), info.ModTime()) != fileChanged {
+			return err
+		}
+		if err := s.reprobe(library, existing, fileChanged); err != nil {
+			return err
+		}
+		s.indexFile(library.ID, filePath, info.Size(), info.ModTime(), existing.ID)
+		return nil
+	}
+	if id := s.relocateMoved(library, filePath, info); id != uuid.Nil {
+		s.indexFile(library.ID, filePath, info.Size(), info.ModTime(), id)
 		return nil
 	}
 
@@ -750,6 +888,8 @@ func (s *Scanner) ScanSingleFile(library *models.Library, filePath string) error
 	if err := s.mediaRepo.Create(item); err != nil {
 		return err
 	}
+	s.storeFileIdentity(item.ID, filePath)
+	s.indexFile(library.ID, filePath, info.Size(), info.ModTime(), item.ID)
 
 	// Link extras to parent
 	if item.ExtraType != nil {
@@ -769,6 +909,56 @@ func (s *Scanner) ScanSingleFile(library *models.Library, filePath string) error
 	return nil
 }
 
+// ReplaceFile swaps the file behind an existing media item for newPath, as
+// happens when Sonarr/Radarr upgrade a release. The item keeps its ID, so
+// watch history, ratings and edition grouping carry over; technical metadata
+// and tracks are re-probed from the new file. Falls back to ScanSingleFile
+// when nothing is known about oldPath.
+func (s *Scanner) ReplaceFile(library *models.Library, oldPath, newPath string) error {
+	existing, _ := s.mediaRepo.GetByFilePath(oldPath)
+	if existing == nil {
+		return s.ScanSingleFile(library, newPath)
+	}
+	info, err := os.Stat(newPath)
+	if err != nil {
+		return err
+	}
+
+	// The watcher may have picked the new file up first — fold it back into
+	// the original item rather than keeping two.
+	if dup, _ := s.mediaRepo.GetByFilePath(newPath); dup != nil && dup.ID != existing.ID {
+		if err := s.mediaRepo.Delete(dup.ID); err != nil {
+			return err
+		}
+	}
+
+	existing.FilePath = newPath
+	existing.FileName = info.Name()
+	existing.FileSize = info.Size()
+
+	var probe *ffmpeg.ProbeResult
+	if s.isProbeableType(library.MediaType) {
+		if p, probeErr := s.ffprobe.Probe(newPath); probeErr == nil {
+			probe = p
+			s.applyProbeData(existing, probe)
+		}
+	}
+	if err := s.mediaRepo.ReplaceFile(existing); err != nil {
+		return err
+	}
+	s.storeFileIdentity(existing.ID, newPath)
+
+	if probe != nil && s.tracksRepo != nil {
+		s.tracksRepo.DeleteSubtitlesByMediaID(existing.ID)
+		s.tracksRepo.DeleteAudioTracksByMediaID(existing.ID)
+		s.tracksRepo.DeleteChaptersByMediaID(existing.ID)
+		s.extractAndStoreTracks(existing.ID, newPath, probe)
+	}
+
+	log.Printf("[scanner] replaced file for %q: %s → %s", existing.Title, filepath.Base(oldPath), info.Name())
+	return nil
+}
+
 // MediaRepo exposes the media repository for post-scan jobs (e.g. phash).
 func (s *Scanner) MediaRepo() *repository.MediaRepository {
 	return s.mediaRepo
@@ -779,6 +969,9 @@ func (s *Scanner) countEligibleFiles(mediaType models.MediaType, scanPaths []str
 	count := 0
 	for _, scanPath := range scanPaths {
 		_ = filepath.Walk(scanPath, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.IsDir() && info.Name() == models.OptimizedDirName {
+				return filepath.SkipDir
+			}
 			if err != nil || info.IsDir() {
 				return nil
 			}
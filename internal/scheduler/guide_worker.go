@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/JustinTDCT/CineVault/internal/livetv"
+	"github.com/JustinTDCT/CineVault/internal/repository"
+)
+
+// GuideWorker keeps the EPG current: it reloads each tuner's lineup and XMLTV
+// guide once it is older than epg_refresh_hours, re-runs the DVR series rules
+// against the new guide, expires recordings past their rule's keep_count and
+// prunes programmes that ended more than epg_retention_hours ago.
+type GuideWorker struct {
+	ingester     *livetv.Ingester
+	rules        *livetv.SeriesRules
+	settingsRepo *repository.SettingsRepository
+	interval     time.Duration
+	stop         chan struct{}
+}
+
+func NewGuideWorker(ingester *livetv.Ingester, rules *livetv.SeriesRules, settingsRepo *repository.SettingsRepository) *GuideWorker {
+	return &GuideWorker{
+		ingester:     ingester,
+		rules:        rules,
+		settingsRepo: settingsRepo,
+		interval:     15 * time.Minute,
+		stop:         make(chan struct{}),
+	}
+}
+
+func (w *GuideWorker) Start() {
+	go w.run()
+	log.Printf("[epg] guide worker started (interval=%s)", w.interval)
+}
+
+func (w *GuideWorker) Stop() {
+	close(w.stop)
+}
+
+func (w *GuideWorker) run() {
+	time.Sleep(time.Minute)
+	w.check()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-w.stop:
+			log.Println("[epg] guide worker stopped")
+			return
+		}
+	}
+}
+
+func (w *GuideWorker) check() {
+	refresh := w.hoursSetting("epg_refresh_hours", 12)
+	retention := w.hoursSetting("epg_retention_hours", 48)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	if w.ingester.RefreshDue(ctx, refresh) > 0 {
+		w.rules.Apply()
+	}
+	w.rules.Expire()
+
+	if n, err := w.ingester.Prune(retention); err != nil {
+		log.Printf("[epg] prune error: %v", err)
+	} else if n > 0 {
+		log.Printf("[epg] pruned %d old programmes", n)
+	}
+}
+
+func (w *GuideWorker) hoursSetting(key string, def int) time.Duration {
+	v, _ := w.settingsRepo.Get(key)
+	if h, err := strconv.Atoi(v); err == nil && h > 0 {
+		return time.Duration(h) * time.Hour
+	}
+	return time.Duration(def) * time.Hour
+}
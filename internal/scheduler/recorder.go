@@ -0,0 +1,307 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JustinTDCT/CineVault/internal/livetv"
+	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/JustinTDCT/CineVault/internal/repository"
+	"github.com/google/uuid"
+)
+
+// OnRecordingComplete is called with the recordings library and the finished
+// file so it can be scanned in.
+type OnRecordingComplete func(libraryID uuid.UUID, filePath string) error
+
+// earlyEndSlack is how long before end_time a capture may stop and still
+// count as complete.
+const earlyEndSlack = 30 * time.Second
+
+// recordingStore is the part of DVRRepository the recorder uses.
+type recordingStore interface {
+	ListDue(now time.Time) ([]*models.DVRRecording, error)
+	FailMissed(now time.Time, reason string) (int64, error)
+	ResetInterrupted(now time.Time) error
+	MarkRecording(id uuid.UUID, filePath string) error
+	MarkCompleted(id uuid.UUID) error
+	MarkFailed(id uuid.UUID, reason string) error
+	LinkMediaItem(id uuid.UUID, filePath string) error
+	GetChannelSource(channelID uuid.UUID) (*models.ChannelSource, error)
+}
+
+// Recorder starts DVR recordings when their start_time arrives, captures the
+// tuner stream with ffmpeg until end_time and hands the file to the scanner.
+// Recordings that cannot get a tuner stay scheduled and are retried on every
+// tick until their window closes.
+type Recorder struct {
+	dvrRepo      recordingStore
+	libRepo      *repository.LibraryRepository
+	settingsRepo *repository.SettingsRepository
+	tuners       *livetv.TunerPool
+	ffmpegPath   string
+	fallbackDir  string
+	onComplete   OnRecordingComplete
+	interval     time.Duration
+	mu           sync.Mutex
+	active       map[uuid.UUID]context.CancelFunc
+	waiting      map[uuid.UUID]bool
+	wg           sync.WaitGroup
+	stop         chan struct{}
+}
+
+func NewRecorder(dvrRepo *repository.DVRRepository, libRepo *repository.LibraryRepository,
+	settingsRepo *repository.SettingsRepository, tuners *livetv.TunerPool, ffmpegPath, fallbackDir string,
+	onComplete OnRecordingComplete) *Recorder {
+	return &Recorder{
+		dvrRepo:      dvrRepo,
+		libRepo:      libRepo,
+		settingsRepo: settingsRepo,
+		tuners:       tuners,
+		ffmpegPath:   ffmpegPath,
+		fallbackDir:  fallbackDir,
+		onComplete:   onComplete,
+		interval:     10 * time.Second,
+		active:       make(map[uuid.UUID]context.CancelFunc),
+		waiting:      make(map[uuid.UUID]bool),
+		stop:         make(chan struct{}),
+	}
+}
+
+func (r *Recorder) Start() {
+	if err := r.dvrRepo.ResetInterrupted(time.Now()); err != nil {
+		log.Printf("[dvr] reset interrupted recordings: %v", err)
+	}
+	go r.run()
+	log.Printf("[dvr] recorder started (interval=%s)", r.interval)
+}
+
+// Stop cancels in-progress captures and waits for ffmpeg to exit. Cancelled
+// rows stay in 'recording' so the next start resumes them if still in window.
+func (r *Recorder) Stop() {
+	close(r.stop)
+	r.mu.Lock()
+	for _, cancel := range r.active {
+		cancel()
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+}
+
+func (r *Recorder) run() {
+	r.check()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.check()
+		case <-r.stop:
+			log.Println("[dvr] recorder stopped")
+			return
+		}
+	}
+}
+
+func (r *Recorder) check() {
+	now := time.Now()
+	if n, err := r.dvrRepo.FailMissed(now, "recording window passed without a free tuner"); err != nil {
+		log.Printf("[dvr] fail missed: %v", err)
+	} else if n > 0 {
+		log.Printf("[dvr] %d recording(s) missed", n)
+	}
+
+	due, err := r.dvrRepo.ListDue(now)
+	if err != nil {
+		log.Printf("[dvr] query error: %v", err)
+		return
+	}
+	dueIDs := make(map[uuid.UUID]bool, len(due))
+	for _, rec := range due {
+		dueIDs[rec.ID] = true
+		r.mu.Lock()
+		_, running := r.active[rec.ID]
+		r.mu.Unlock()
+		if !running {
+			r.begin(rec)
+		}
+	}
+
+	r.mu.Lock()
+	for id := range r.waiting {
+		if !dueIDs[id] {
+			delete(r.waiting, id)
+		}
+	}
+	r.mu.Unlock()
+}
+
+// begin claims a tuner and launches the capture for one recording.
+func (r *Recorder) begin(rec *models.DVRRecording) {
+	src, err := r.dvrRepo.GetChannelSource(rec.ChannelID)
+	if err != nil {
+		r.dvrRepo.MarkFailed(rec.ID, "channel has no active tuner")
+		log.Printf("[dvr] %q: channel lookup failed: %v", rec.Title, err)
+		return
+	}
+	if !r.tuners.Acquire(src.TunerID, src.TunerCount) {
+		r.mu.Lock()
+		if !r.waiting[rec.ID] {
+			log.Printf("[dvr] %q: all %d tuner(s) busy, waiting", rec.Title, src.TunerCount)
+			r.waiting[rec.ID] = true
+		}
+		r.mu.Unlock()
+		return
+	}
+
+	libraryID, dir := r.outputDir()
+	path := filepath.Join(dir, sanitizeFileName(rec.Title),
+		fmt.Sprintf("%s (%s).ts", sanitizeFileName(rec.Title), time.Now().Format("2006-01-02 1504")))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		r.tuners.Release(src.TunerID)
+		r.dvrRepo.MarkFailed(rec.ID, "create output directory: "+err.Error())
+		return
+	}
+	if err := r.dvrRepo.MarkRecording(rec.ID, path); err != nil {
+		r.tuners.Release(src.TunerID)
+		log.Printf("[dvr] %q: update state: %v", rec.Title, err)
+		return
+	}
+
+	// ffmpeg stops itself at end_time via -t; the deadline is a backstop
+	ctx, cancel := context.WithDeadline(context.Background(), rec.EndTime.Add(30*time.Second))
+	r.mu.Lock()
+	r.active[rec.ID] = cancel
+	delete(r.waiting, rec.ID)
+	r.mu.Unlock()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer cancel()
+		defer r.tuners.Release(src.TunerID)
+		defer func() {
+			r.mu.Lock()
+			delete(r.active, rec.ID)
+			r.mu.Unlock()
+		}()
+		r.capture(ctx, rec, src.URL(), path, libraryID)
+	}()
+}
+
+func (r *Recorder) capture(ctx context.Context, rec *models.DVRRecording, streamURL, path string, libraryID uuid.UUID) {
+	duration := time.Until(rec.EndTime)
+	log.Printf("[dvr] recording %q from %s for %s → %s", rec.Title, streamURL, duration.Round(time.Second), path)
+
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y"}
+	if strings.HasPrefix(streamURL, "http://") || strings.HasPrefix(streamURL, "https://") {
+		args = append(args, "-reconnect", "1", "-reconnect_streamed", "1", "-reconnect_delay_max", "10")
+	}
+	args = append(args,
+		"-i", streamURL,
+		"-map", "0", "-c", "copy", "-ignore_unknown",
+		"-t", fmt.Sprintf("%.0f", duration.Seconds()),
+		"-f", "mpegts", path,
+	)
+	cmd := exec.CommandContext(ctx, r.ffmpegPath, args...)
+	// Let ffmpeg flush the container on cancel instead of killing it outright
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = 10 * time.Second
+	output, runErr := cmd.CombinedOutput()
+
+	select {
+	case <-r.stop:
+		log.Printf("[dvr] %q: interrupted by shutdown", rec.Title)
+		return
+	default:
+	}
+
+	info, statErr := os.Stat(path)
+	if statErr != nil || info.Size() == 0 {
+		reason := "no data received from tuner"
+		if runErr != nil {
+			reason = fmt.Sprintf("ffmpeg: %v: %s", runErr, lastLine(string(output)))
+		}
+		os.Remove(path)
+		r.dvrRepo.MarkFailed(rec.ID, reason)
+		log.Printf("[dvr] %q failed: %s", rec.Title, reason)
+		return
+	}
+	// ffmpeg exits cleanly when the tuner closes the stream, so go by the
+	// clock. A truncated capture is failed but kept and scanned in.
+	if left := time.Until(rec.EndTime); left > earlyEndSlack {
+		reason := fmt.Sprintf("stream ended %s early", left.Round(time.Second))
+		if runErr != nil {
+			reason += fmt.Sprintf(": ffmpeg: %v: %s", runErr, lastLine(string(output)))
+		}
+		r.dvrRepo.MarkFailed(rec.ID, reason)
+		log.Printf("[dvr] %q partial (%d MB): %s", rec.Title, info.Size()/(1024*1024), reason)
+	} else {
+		r.dvrRepo.MarkCompleted(rec.ID)
+		log.Printf("[dvr] %q completed (%d MB)", rec.Title, info.Size()/(1024*1024))
+	}
+
+	if libraryID == uuid.Nil || r.onComplete == nil {
+		return
+	}
+	if err := r.onComplete(libraryID, path); err != nil {
+		log.Printf("[dvr] %q: scan error: %v", rec.Title, err)
+		return
+	}
+	r.dvrRepo.LinkMediaItem(rec.ID, path)
+}
+
+// outputDir returns the recordings library (setting dvr_library_id) and its
+// first folder, or the fallback directory when no library is configured.
+func (r *Recorder) outputDir() (uuid.UUID, string) {
+	if r.settingsRepo == nil {
+		return uuid.Nil, r.fallbackDir
+	}
+	if idStr, _ := r.settingsRepo.Get("dvr_library_id"); idStr != "" {
+		if id, err := uuid.Parse(idStr); err == nil {
+			if lib, err := r.libRepo.GetByID(id); err == nil {
+				for _, f := range lib.Folders {
+					if f.FolderPath != "" {
+						return lib.ID, f.FolderPath
+					}
+				}
+				if lib.Path != "" {
+					return lib.ID, lib.Path
+				}
+			}
+		}
+	}
+	return uuid.Nil, r.fallbackDir
+}
+
+func sanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "Recording"
+	}
+	return name
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndex(s, "\n"); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
@@ -0,0 +1,294 @@
+package scheduler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JustinTDCT/CineVault/internal/livetv"
+	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/google/uuid"
+)
+
+// The recorder runs the test binary as its ffmpeg: with fakeFFmpegEnv set it
+// copies the -i URL to the output file for -t seconds, exiting 0 when the
+// stream ends like ffmpeg does, and 1 on an HTTP error without creating it.
+const fakeFFmpegEnv = "CINEVAULT_FAKE_FFMPEG"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeFFmpegEnv) == "1" {
+		os.Exit(fakeFFmpeg(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+func fakeFFmpeg(args []string) int {
+	var input string
+	var limit time.Duration
+	for i := 0; i < len(args)-1; i++ {
+		switch args[i] {
+		case "-i":
+			input = args[i+1]
+		case "-t":
+			secs, _ := strconv.Atoi(args[i+1])
+			limit = time.Duration(secs) * time.Second
+		}
+	}
+	resp, err := http.Get(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "%s: Server returned %d\n", input, resp.StatusCode)
+		return 1
+	}
+	f, err := os.Create(args[len(args)-1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+	time.AfterFunc(limit, func() { resp.Body.Close() })
+	io.Copy(f, resp.Body)
+	return 0
+}
+
+// tsServer stands in for a tuner: /live streams MPEG-TS null packets until
+// the client hangs up, /short sends a few and closes, anything else is 404.
+func tsServer(t *testing.T) *httptest.Server {
+	packet := make([]byte, 188)
+	packet[0], packet[1], packet[2] = 0x47, 0x1f, 0xff
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := 0
+		switch r.URL.Path {
+		case "/live":
+			count = -1
+		case "/short":
+			count = 10
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "video/mp2t")
+		for i := 0; count < 0 || i < count; i++ {
+			if _, err := w.Write(packet); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// fakeStore keeps recordings in memory and the states each passed through.
+type fakeStore struct {
+	mu       sync.Mutex
+	recs     []*models.DVRRecording
+	sources  map[uuid.UUID]*models.ChannelSource
+	history  map[uuid.UUID][]string
+	failures map[uuid.UUID]string
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		sources:  make(map[uuid.UUID]*models.ChannelSource),
+		history:  make(map[uuid.UUID][]string),
+		failures: make(map[uuid.UUID]string),
+	}
+}
+
+func (f *fakeStore) addChannel(tunerID uuid.UUID, tunerCount int, url string) uuid.UUID {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := uuid.New()
+	f.sources[id] = &models.ChannelSource{ChannelID: id, StreamURL: &url, TunerID: tunerID, TunerCount: tunerCount}
+	return id
+}
+
+func (f *fakeStore) schedule(channelID uuid.UUID, title string, start, end time.Time) *models.DVRRecording {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rec := &models.DVRRecording{ID: uuid.New(), ChannelID: channelID, Title: title,
+		State: "scheduled", StartTime: start, EndTime: end}
+	f.recs = append(f.recs, rec)
+	f.history[rec.ID] = []string{"scheduled"}
+	return rec
+}
+
+func (f *fakeStore) setState(id uuid.UUID, state string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, rec := range f.recs {
+		if rec.ID == id {
+			rec.State = state
+		}
+	}
+	f.history[id] = append(f.history[id], state)
+}
+
+func (f *fakeStore) states(id uuid.UUID) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.history[id]...)
+}
+
+func (f *fakeStore) ListDue(now time.Time) ([]*models.DVRRecording, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var due []*models.DVRRecording
+	for _, rec := range f.recs {
+		if rec.State == "scheduled" && !rec.StartTime.After(now) && rec.EndTime.After(now) {
+			copied := *rec
+			due = append(due, &copied)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].StartTime.Before(due[j].StartTime) })
+	return due, nil
+}
+
+func (f *fakeStore) FailMissed(now time.Time, reason string) (int64, error) { return 0, nil }
+func (f *fakeStore) ResetInterrupted(now time.Time) error                   { return nil }
+func (f *fakeStore) LinkMediaItem(id uuid.UUID, filePath string) error      { return nil }
+
+func (f *fakeStore) MarkRecording(id uuid.UUID, filePath string) error {
+	f.setState(id, "recording")
+	return nil
+}
+
+func (f *fakeStore) MarkCompleted(id uuid.UUID) error {
+	f.setState(id, "completed")
+	return nil
+}
+
+func (f *fakeStore) MarkFailed(id uuid.UUID, reason string) error {
+	f.setState(id, "failed")
+	f.mu.Lock()
+	f.failures[id] = reason
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *fakeStore) GetChannelSource(channelID uuid.UUID) (*models.ChannelSource, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	src, ok := f.sources[channelID]
+	if !ok {
+		return nil, fmt.Errorf("no channel %s", channelID)
+	}
+	return src, nil
+}
+
+func newTestRecorder(t *testing.T, store *fakeStore, tuners *livetv.TunerPool) *Recorder {
+	t.Setenv(fakeFFmpegEnv, "1")
+	r := NewRecorder(nil, nil, nil, tuners, os.Args[0], t.TempDir(), nil)
+	r.dvrRepo = store
+	return r
+}
+
+func equalStates(got []string, want ...string) bool {
+	return strings.Join(got, ",") == strings.Join(want, ",")
+}
+
+func TestRecorderTunerLimit(t *testing.T) {
+	srv := tsServer(t)
+	store := newFakeStore()
+	tuners := livetv.NewTunerPool()
+	r := newTestRecorder(t, store, tuners)
+
+	tunerID := uuid.New()
+	chA := store.addChannel(tunerID, 1, srv.URL+"/live")
+	chB := store.addChannel(tunerID, 1, srv.URL+"/live")
+	now := time.Now()
+	first := store.schedule(chA, "First", now.Add(-time.Second), now.Add(2*time.Second))
+	second := store.schedule(chB, "Second", now, now.Add(5*time.Second))
+
+	// One tuner: the overlapping recording waits
+	r.check()
+	if got := tuners.InUse(tunerID); got != 1 {
+		t.Fatalf("tuners in use = %d, want 1", got)
+	}
+	if got := store.states(second.ID); !equalStates(got, "scheduled") {
+		t.Fatalf("second states = %v while tuner busy", got)
+	}
+	r.mu.Lock()
+	waiting := r.waiting[second.ID]
+	r.mu.Unlock()
+	if !waiting {
+		t.Error("second recording not marked waiting")
+	}
+	r.wg.Wait()
+	if got := store.states(first.ID); !equalStates(got, "scheduled", "recording", "completed") {
+		t.Fatalf("first states = %v", got)
+	}
+
+	// The freed tuner goes to the waiting recording on the next tick
+	r.check()
+	if got := store.states(second.ID); !equalStates(got, "scheduled", "recording") {
+		t.Fatalf("second states = %v after tuner freed", got)
+	}
+	r.wg.Wait()
+	if got := store.states(second.ID); !equalStates(got, "scheduled", "recording", "completed") {
+		t.Fatalf("second states = %v", got)
+	}
+	if got := tuners.InUse(tunerID); got != 0 {
+		t.Errorf("tuners in use = %d after recordings finished, want 0", got)
+	}
+}
+
+func TestRecorderFailures(t *testing.T) {
+	srv := tsServer(t)
+	tests := []struct {
+		name      string
+		path      string
+		reason    string
+		keepsFile bool
+	}{
+		{"tuner error", "/missing", "ffmpeg: exit status 1", false},
+		{"stream ends early", "/short", "stream ended", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			r := newTestRecorder(t, store, livetv.NewTunerPool())
+			ch := store.addChannel(uuid.New(), 2, srv.URL+tt.path)
+			now := time.Now()
+			rec := store.schedule(ch, tt.name, now, now.Add(10*time.Minute))
+
+			r.check()
+			r.wg.Wait()
+			if got := store.states(rec.ID); !equalStates(got, "scheduled", "recording", "failed") {
+				t.Fatalf("states = %v", got)
+			}
+			store.mu.Lock()
+			reason := store.failures[rec.ID]
+			store.mu.Unlock()
+			if !strings.Contains(reason, tt.reason) {
+				t.Errorf("reason = %q, want it to contain %q", reason, tt.reason)
+			}
+			files := 0
+			if entries, err := os.ReadDir(filepath.Join(r.fallbackDir, sanitizeFileName(tt.name))); err == nil {
+				files = len(entries)
+			}
+			if kept := files > 0; kept != tt.keepsFile {
+				t.Errorf("file kept = %v, want %v", kept, tt.keepsFile)
+			}
+		})
+	}
+}
@@ -0,0 +1,412 @@
+package stream
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AudioFormat is a format music can be converted to for playback.
+type AudioFormat struct {
+	Name           string `json:"name"`
+	Codec          string `json:"codec"`
+	ContentType    string `json:"content_type"`
+	DefaultBitrate int    `json:"default_bitrate"` // kbps
+	MaxBitrate     int    `json:"max_bitrate"`     // kbps
+	encoder        string
+	muxer          string
+	ext            string
+	// The muxer writes front to back, so a conversion can be streamed as
+	// it is written. MP4 can't: faststart moves the index to the front once
+	// the track is done.
+	streamable bool
+}
+
+// AudioFormats are the formats the audio endpoint converts to. Each is
+// cached as a complete file so its gapless metadata is in place: the LAME
+// header's encoder delay and padding for MP3, the priming edit list for
+// AAC and the pre-skip for Opus. (MP3's LAME header is only filled in once
+// the track is done, so the first, streamed play of a conversion goes
+// without it.)
+var AudioFormats = map[string]AudioFormat{
+	"opus": {Name: "opus", Codec: "opus", ContentType: "audio/ogg; codecs=opus", DefaultBitrate: 128, MaxBitrate: 256, encoder: "libopus", muxer: "ogg", ext: ".opus", streamable: true},
+	"mp3":  {Name: "mp3", Codec: "mp3", ContentType: "audio/mpeg", DefaultBitrate: 192, MaxBitrate: 320, encoder: "libmp3lame", muxer: "mp3", ext: ".mp3", streamable: true},
+	"aac":  {Name: "aac", Codec: "aac", ContentType: "audio/mp4", DefaultBitrate: 192, MaxBitrate: 320, encoder: "aac", muxer: "ipod", ext: ".m4a"},
+}
+
+// minAudioBitrate is the lowest bitrate a conversion may be asked for.
+const minAudioBitrate = 32
+
+// Bitrate clamps a requested bitrate in kbps to what the format allows,
+// using its default for 0.
+func (f AudioFormat) Bitrate(kbps int) int {
+	switch {
+	case kbps <= 0:
+		return f.DefaultBitrate
+	case kbps < minAudioBitrate:
+		return minAudioBitrate
+	case kbps > f.MaxBitrate:
+		return f.MaxBitrate
+	}
+	return kbps
+}
+
+// AudioContentType returns the MIME type an original music file is served as.
+func AudioContentType(filePath string) string {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".mp3":
+		return "audio/mpeg"
+	case ".flac":
+		return "audio/flac"
+	case ".m4a", ".m4b", ".alac", ".mp4":
+		return "audio/mp4"
+	case ".aac":
+		return "audio/aac"
+	case ".ogg", ".oga", ".opus":
+		return "audio/ogg"
+	case ".wav":
+		return "audio/wav"
+	case ".aif", ".aiff":
+		return "audio/aiff"
+	case ".wma":
+		return "audio/x-ms-wma"
+	}
+	return "application/octet-stream"
+}
+
+// AudioArgs builds the ffmpeg arguments that convert the first audio stream
+// of filePath to f at bitrate kbps, applying gainDB (ReplayGain) on the way.
+// Cover art and other streams are dropped; tags are kept.
+func AudioArgs(filePath, outPath string, f AudioFormat, bitrate int, gainDB float64) []string {
+	args := []string{
+		"-nostdin", "-y",
+		"-i", filePath,
+		"-map", "0:a:0", "-vn",
+		"-map_metadata", "0",
+		"-c:a", f.encoder, "-b:a", fmt.Sprintf("%dk", bitrate), "-ac", "2",
+	}
+	if gainDB != 0 {
+		args = append(args, "-af", fmt.Sprintf("volume=%.2fdB", gainDB))
+	}
+	switch f.Name {
+	case "opus":
+		args = append(args, "-vbr", "on")
+	case "mp3":
+		args = append(args, "-id3v2_version", "3")
+	case "aac":
+		args = append(args, "-movflags", "+faststart")
+	}
+	return append(args, "-f", f.muxer, outPath)
+}
+
+// audioTranscodeTimeout bounds one conversion. Conversions aren't tied to
+// the request that started them, so a client that gives up doesn't waste
+// the work for the next one.
+const audioTranscodeTimeout = 10 * time.Minute
+
+// AudioCache holds converted music files. The first request for a
+// conversion streams ffmpeg's output as it is written to the cache; once
+// the file is complete it is served with its length, so byte ranges work
+// for seeking.
+type AudioCache struct {
+	ffmpegPath string
+	dir        string
+	maxBytes   int64
+	mu         sync.Mutex
+	building   map[string]chan struct{}
+	errs       map[string]error
+}
+
+// NewAudioCache creates a cache under dir limited to maxBytes (0 for no limit).
+func NewAudioCache(ffmpegPath, dir string, maxBytes int64) *AudioCache {
+	os.MkdirAll(dir, 0755)
+	return &AudioCache{
+		ffmpegPath: ffmpegPath,
+		dir:        dir,
+		maxBytes:   maxBytes,
+		building:   make(map[string]chan struct{}),
+		errs:       make(map[string]error),
+	}
+}
+
+// Convert returns the path of filePath converted to f, converting it
+// first if it isn't cached.
+func (c *AudioCache) Convert(ctx context.Context, filePath string, f AudioFormat, bitrate int, gainDB float64) (string, error) {
+	key, outPath, err := c.entry(filePath, f, bitrate, gainDB)
+	if err != nil {
+		return "", err
+	}
+	for {
+		done, cached := c.start(key, filePath, outPath, f, bitrate, gainDB)
+		if cached {
+			return outPath, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-done:
+		}
+		if err := c.buildErr(key); err != nil {
+			return "", err
+		}
+	}
+}
+
+// Serve sends filePath converted to f. A cached conversion is served whole
+// with ranges; otherwise the conversion is started (or joined) and, for
+// formats that allow it, ffmpeg's output is copied to w as it is written.
+// That response has no length and ignores ranges. Errors are returned
+// only while nothing has been written; a conversion that fails midway
+// just ends the response.
+func (c *AudioCache) Serve(w http.ResponseWriter, r *http.Request, filePath string, f AudioFormat, bitrate int, gainDB float64) error {
+	if !f.streamable {
+		path, err := c.Convert(r.Context(), filePath, f, bitrate, gainDB)
+		if err != nil {
+			return err
+		}
+		return serveAudioFile(w, r, path, f.ContentType)
+	}
+
+	key, outPath, err := c.entry(filePath, f, bitrate, gainDB)
+	if err != nil {
+		return err
+	}
+	done, cached := c.start(key, filePath, outPath, f, bitrate, gainDB)
+	if cached {
+		return serveAudioFile(w, r, outPath, f.ContentType)
+	}
+
+	// Follow the part file, or the finished file if ffmpeg beat us to it
+	var in *os.File
+	for in == nil {
+		if in, err = os.Open(outPath + ".part"); err == nil {
+			break
+		}
+		if in, err = os.Open(outPath); err == nil {
+			break
+		}
+		in = nil
+		select {
+		case <-r.Context().Done():
+			return r.Context().Err()
+		case <-done:
+			if err := c.buildErr(key); err != nil {
+				return err
+			}
+			return serveAudioFile(w, r, outPath, f.ContentType)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	defer in.Close()
+
+	w.Header().Set("Content-Type", f.ContentType)
+	w.Header().Set("Accept-Ranges", "none")
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 64<<10)
+	finished := false
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return nil
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		switch {
+		case err == io.EOF && finished:
+			return nil
+		case err == io.EOF:
+			// Caught up with ffmpeg; the part file is renamed into place
+			// when it finishes, which leaves this handle on it
+			select {
+			case <-r.Context().Done():
+				return nil
+			case <-done:
+				finished = true
+				if c.buildErr(key) != nil {
+					return nil
+				}
+			case <-time.After(100 * time.Millisecond):
+			}
+		case err != nil:
+			log.Printf("Audio convert: read %s: %v", in.Name(), err)
+			return nil
+		}
+	}
+}
+
+// entry returns the cache key and path of a conversion. The source's
+// modification time is part of the key, so retagged or replaced files are
+// converted again.
+func (c *AudioCache) entry(filePath string, f AudioFormat, bitrate int, gainDB float64) (key, outPath string, err error) {
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return "", "", fmt.Errorf("stat source: %w", err)
+	}
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%d|%d|%s|%d|%.2f", filePath, stat.Size(), stat.ModTime().UnixNano(), f.Name, bitrate, gainDB)))
+	key = hex.EncodeToString(sum[:10])
+	return key, filepath.Join(c.dir, key+f.ext), nil
+}
+
+// start reports whether a conversion is cached and, if it isn't, returns
+// a channel closed when its build finishes, starting the build unless one
+// is already running.
+func (c *AudioCache) start(key, filePath, outPath string, f AudioFormat, bitrate int, gainDB float64) (done <-chan struct{}, cached bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := os.Stat(outPath); err == nil {
+		// The modification time doubles as last use for cleanup
+		now := time.Now()
+		os.Chtimes(outPath, now, now)
+		return nil, true
+	}
+	if wait, busy := c.building[key]; busy {
+		return wait, false
+	}
+	// A part file left by a crash must not be streamed before ffmpeg
+	// truncates it
+	os.Remove(outPath + ".part")
+	ch := make(chan struct{})
+	c.building[key] = ch
+	delete(c.errs, key)
+	go c.build(key, filePath, outPath, f, bitrate, gainDB, ch)
+	return ch, false
+}
+
+func (c *AudioCache) buildErr(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.errs[key]
+}
+
+func serveAudioFile(w http.ResponseWriter, r *http.Request, path, contentType string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", contentType)
+	http.ServeContent(w, r, "", info.ModTime(), f)
+	return nil
+}
+
+func (c *AudioCache) build(key, filePath, outPath string, f AudioFormat, bitrate int, gainDB float64, done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), audioTranscodeTimeout)
+	defer cancel()
+
+	partPath := outPath + ".part"
+	cmd := exec.CommandContext(ctx, c.ffmpegPath, AudioArgs(filePath, partPath, f, bitrate, gainDB)...)
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		err = os.Rename(partPath, outPath)
+	} else {
+		errStr := string(output)
+		if len(errStr) > 500 {
+			errStr = errStr[len(errStr)-500:]
+		}
+		err = fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(errStr))
+	}
+	if err != nil {
+		os.Remove(partPath)
+		log.Printf("Audio convert: %s to %s failed: %v", filepath.Base(filePath), f.Name, err)
+	}
+
+	c.mu.Lock()
+	if err != nil {
+		c.errs[key] = err
+	}
+	delete(c.building, key)
+	c.mu.Unlock()
+	close(done)
+}
+
+// Cleanup removes conversions unused for maxAge, then the least recently
+// used ones while the cache is over its size limit.
+func (c *AudioCache) Cleanup(maxAge time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	type cached struct {
+		path string
+		size int64
+		used time.Time
+	}
+	var files []cached
+	var total int64
+	removed := 0
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".part") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(c.dir, e.Name())
+		if time.Since(info.ModTime()) > maxAge {
+			if os.Remove(path) == nil {
+				removed++
+			}
+			continue
+		}
+		files = append(files, cached{path, info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+
+	if c.maxBytes > 0 && total > c.maxBytes {
+		sort.Slice(files, func(i, j int) bool { return files[i].used.Before(files[j].used) })
+		for _, f := range files {
+			if total <= c.maxBytes {
+				break
+			}
+			if os.Remove(f.path) == nil {
+				total -= f.size
+				removed++
+			}
+		}
+	}
+	if removed > 0 {
+		log.Printf("Audio cache: removed %d conversions, %d MB in use", removed, total>>20)
+	}
+}
+
+// RunCleanupLoop runs Cleanup hourly until done is closed.
+func (c *AudioCache) RunCleanupLoop(done <-chan struct{}, maxAge time.Duration) {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				c.Cleanup(maxAge)
+			}
+		}
+	}()
+}
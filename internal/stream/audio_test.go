@@ -0,0 +1,105 @@
+package stream
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeFFmpeg writes a script that stands in for ffmpeg, running body with
+// $out set to its output file (the last argument).
+func fakeFFmpeg(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	script := "#!/bin/sh\nfor out; do :; done\n" + body
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAudioCacheServe(t *testing.T) {
+	gate := filepath.Join(t.TempDir(), "go")
+	t.Setenv("FAKE_FFMPEG_GO", gate)
+	// Writes half the output, then the rest once the test says so
+	ffmpeg := fakeFFmpeg(t, `printf first > "$out"
+while [ ! -f "$FAKE_FFMPEG_GO" ]; do sleep 0.01; done
+printf second >> "$out"
+`)
+	source := filepath.Join(t.TempDir(), "track.flac")
+	if err := os.WriteFile(source, []byte("flac"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewAudioCache(ffmpeg, t.TempDir(), 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := cache.Serve(w, r, source, AudioFormats["opus"], 128, 0); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	// The first play gets ffmpeg's output while it is still converting
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != -1 {
+		t.Fatalf("streamed response %d with length %d", resp.StatusCode, resp.ContentLength)
+	}
+	head := make([]byte, len("first"))
+	if _, err := io.ReadFull(resp.Body, head); err != nil || string(head) != "first" {
+		t.Fatalf("read %q, %v before the conversion finished", head, err)
+	}
+	if err := os.WriteFile(gate, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(rest) != "second" {
+		t.Fatalf("rest of the stream %q, %v", rest, err)
+	}
+
+	// Once cached, ranges work
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Range", "bytes=5-")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusPartialContent {
+			if string(body) != "second" {
+				t.Fatalf("range of the cached conversion %q", body)
+			}
+			break
+		}
+		// The build may not have been renamed into place yet
+		if time.Now().After(deadline) {
+			t.Fatalf("cached conversion answered a range with %d", resp.StatusCode)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestAudioCacheServeFailure(t *testing.T) {
+	ffmpeg := fakeFFmpeg(t, "echo 'Unknown encoder' >&2\nexit 1\n")
+	source := filepath.Join(t.TempDir(), "track.flac")
+	if err := os.WriteFile(source, []byte("flac"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewAudioCache(ffmpeg, t.TempDir(), 0)
+	for _, format := range []string{"opus", "aac"} {
+		rec := httptest.NewRecorder()
+		err := cache.Serve(rec, httptest.NewRequest(http.MethodGet, "/", nil), source, AudioFormats[format], 128, 0)
+		if err == nil || rec.Body.Len() != 0 {
+			t.Errorf("%s: failed conversion served %q, err %v", format, rec.Body.String(), err)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package stream
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// PackagingCMAF is the TranscodeOptions.Packaging value for CMAF sessions:
+// fragmented MP4 with video and audio encoded by separate sessions, so the
+// same segments serve both an HLS master with an audio group and a DASH MPD.
+const PackagingCMAF = "cmaf"
+
+// AudioRendition is the quality name of an audio-only session: a CMAF
+// audio track or an alternate language of a muxed HLS master.
+const AudioRendition = "audio"
+
+// cmafSegmentExt is the extension of CMAF media segments; init.mp4 holds
+// the track header.
+const cmafSegmentExt = "m4s"
+
+// CMAFPresentation describes everything the CMAF manifests list for one
+// media item.
+type CMAFPresentation struct {
+	MediaID   string
+	Duration  float64
+	Qualities []string // video renditions, lowest first
+	Codec     string   // "h264" or "hevc"
+	Audio     []CMAFAudio
+	Subtitles []CMAFSubtitle
+	// Query is carried onto every URL so segment requests key the same
+	// sessions (device, options and, for DASH, the token)
+	Query url.Values
+}
+
+// CMAFAudio is one audio track, encoded by its own audio-only session.
+type CMAFAudio struct {
+	StreamIndex int // -1 for the file's first audio stream
+	Language    string
+	Label       string
+	Channels    int
+	Default     bool
+}
+
+// CMAFSubtitle is a WebVTT subtitle served by the subtitles endpoint.
+type CMAFSubtitle struct {
+	ID       string
+	Language string
+	Label    string
+	Default  bool
+	Forced   bool
+}
+
+func (p *CMAFPresentation) base() string {
+	return "/api/v1/stream/" + p.MediaID
+}
+
+// query is the query string of a session URL. Video sessions carry no audio
+// track; audio sessions carry theirs (-1 for the default).
+func (p *CMAFPresentation) query(audioIndex int) string {
+	q := url.Values{}
+	for k, v := range p.Query {
+		q[k] = v
+	}
+	q.Set("packaging", PackagingCMAF)
+	q.Del("audio")
+	if audioIndex >= 0 {
+		q.Set("audio", strconv.Itoa(audioIndex))
+	}
+	return q.Encode()
+}
+
+func (p *CMAFPresentation) subtitleQuery() string {
+	// The subtitle endpoint only needs the token
+	q := url.Values{}
+	if token := p.Query.Get("token"); token != "" {
+		q.Set("token", token)
+	}
+	return q.Encode()
+}
+
+func (p *CMAFPresentation) videoCodecs() string {
+	if p.Codec == "hevc" {
+		return "hvc1.1.6.L150.90"
+	}
+	return "avc1.640028"
+}
+
+// audioCodecs is what BuildAudioTranscodeArgs produces for browsers
+const audioCodecs = "mp4a.40.2"
+
+// QualityBitrate is the combined video and audio bitrate of a quality in
+// bits per second.
+func QualityBitrate(q Quality) int64 {
+	return int64(bitrateBPS(q.VideoBitrate) + bitrateBPS(q.AudioBitrate))
+}
+
+// bitrateBPS parses an ffmpeg bitrate such as "2800k".
+func bitrateBPS(s string) int {
+	mult := 1
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult, s = 1000, strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "M"):
+		mult, s = 1000000, strings.TrimSuffix(s, "M")
+	}
+	n, _ := strconv.Atoi(s)
+	return n * mult
+}
+
+// CMAFMasterPlaylist is the HLS view of a CMAF presentation: one variant
+// per video quality sharing an audio group and a subtitles group.
+func CMAFMasterPlaylist(p *CMAFPresentation) string {
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	sb.WriteString("#EXT-X-VERSION:7\n")
+	sb.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+
+	for i, a := range p.Audio {
+		sb.WriteString(fmt.Sprintf("#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aud\",NAME=%q,%sDEFAULT=%s,AUTOSELECT=YES,CHANNELS=\"%d\",URI=\"%s/%s/stream.m3u8?%s\"\n",
+			mediaName(a.Label, a.Language, fmt.Sprintf("Audio %d", i+1)), languageAttr(a.Language), yesNo(a.Default || i == 0 && !hasDefaultAudio(p.Audio)),
+			max(a.Channels, 2), p.base(), AudioRendition, p.query(a.StreamIndex)))
+	}
+	for i, s := range p.Subtitles {
+		sb.WriteString(fmt.Sprintf("#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=%q,%sDEFAULT=%s,AUTOSELECT=YES,FORCED=%s,URI=\"%s/subtitles/%s/playlist.m3u8?%s\"\n",
+			mediaName(s.Label, s.Language, fmt.Sprintf("Subtitles %d", i+1)), languageAttr(s.Language), yesNo(s.Default), yesNo(s.Forced),
+			p.base(), s.ID, p.subtitleQuery()))
+	}
+
+	codecs := p.videoCodecs()
+	if len(p.Audio) > 0 {
+		codecs += "," + audioCodecs
+	}
+	for _, name := range p.Qualities {
+		q := Qualities[name]
+		sb.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\",NAME=\"%s\"",
+			bitrateBPS(q.VideoBitrate)+bitrateBPS(q.AudioBitrate), q.Width, q.Height, codecs, q.Name))
+		if len(p.Audio) > 0 {
+			sb.WriteString(",AUDIO=\"aud\"")
+		}
+		if len(p.Subtitles) > 0 {
+			sb.WriteString(",SUBTITLES=\"subs\"")
+		}
+		sb.WriteString(fmt.Sprintf("\n%s/%s/stream.m3u8?%s\n", p.base(), name, p.query(-1)))
+	}
+	return sb.String()
+}
+
+func mediaName(label, language, fallback string) string {
+	switch {
+	case label != "":
+		return label
+	case language != "":
+		return language
+	}
+	return fallback
+}
+
+func languageAttr(language string) string {
+	if language == "" {
+		return ""
+	}
+	return fmt.Sprintf("LANGUAGE=%q,", language)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
+
+func hasDefaultAudio(tracks []CMAFAudio) bool {
+	for _, a := range tracks {
+		if a.Default {
+			return true
+		}
+	}
+	return false
+}
+
+// ── DASH ──
+
+type mpdRoot struct {
+	XMLName       xml.Name  `xml:"MPD"`
+	Xmlns         string    `xml:"xmlns,attr"`
+	Profiles      string    `xml:"profiles,attr"`
+	Type          string    `xml:"type,attr"`
+	Duration      string    `xml:"mediaPresentationDuration,attr"`
+	MinBufferTime string    `xml:"minBufferTime,attr"`
+	Period        mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	ID             string             `xml:"id,attr"`
+	Start          string             `xml:"start,attr"`
+	AdaptationSets []mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	ID               int                 `xml:"id,attr"`
+	ContentType      string              `xml:"contentType,attr"`
+	MimeType         string              `xml:"mimeType,attr"`
+	Lang             string              `xml:"lang,attr,omitempty"`
+	SegmentAlignment bool                `xml:"segmentAlignment,attr,omitempty"`
+	Label            string              `xml:"Label,omitempty"`
+	Roles            []mpdDescriptor     `xml:"Role"`
+	SegmentTemplate  *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	Representations  []mpdRepresentation `xml:"Representation"`
+}
+
+type mpdDescriptor struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+}
+
+type mpdRepresentation struct {
+	ID                        string         `xml:"id,attr"`
+	Bandwidth                 int            `xml:"bandwidth,attr"`
+	Codecs                    string         `xml:"codecs,attr,omitempty"`
+	Width                     int            `xml:"width,attr,omitempty"`
+	Height                    int            `xml:"height,attr,omitempty"`
+	AudioChannelConfiguration *mpdDescriptor `xml:"AudioChannelConfiguration"`
+	BaseURL                   string         `xml:"BaseURL,omitempty"`
+}
+
+type mpdSegmentTemplate struct {
+	Timescale      int         `xml:"timescale,attr"`
+	Initialization string      `xml:"initialization,attr"`
+	Media          string      `xml:"media,attr"`
+	StartNumber    int         `xml:"startNumber,attr"`
+	Timeline       mpdTimeline `xml:"SegmentTimeline"`
+}
+
+type mpdTimeline struct {
+	S []mpdS `xml:"S"`
+}
+
+type mpdS struct {
+	T *int64 `xml:"t,attr"`
+	D int64  `xml:"d,attr"`
+	R int    `xml:"r,attr,omitempty"`
+}
+
+// dashTimescale is milliseconds; segment boundaries are whole seconds
+const dashTimescale = 1000
+
+// segmentTimeline describes the fixed SegmentDuration segments of a source:
+// one run of full segments and the shorter last one.
+func segmentTimeline(durationSeconds float64) mpdTimeline {
+	count := SegmentCount(durationSeconds)
+	if count == 0 {
+		return mpdTimeline{}
+	}
+	zero := int64(0)
+	full := int64(SegmentDuration * dashTimescale)
+	last := int64(math.Round(durationSeconds*dashTimescale)) - int64(count-1)*full
+	var tl mpdTimeline
+	if count > 1 {
+		tl.S = append(tl.S, mpdS{T: &zero, D: full, R: count - 2})
+		tl.S = append(tl.S, mpdS{D: last})
+	} else {
+		tl.S = append(tl.S, mpdS{T: &zero, D: last})
+	}
+	return tl
+}
+
+// isoDuration formats seconds as an xs:duration ("PT1H2M3.500S").
+func isoDuration(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	h, ms := ms/3600000, ms%3600000
+	m, ms := ms/60000, ms%60000
+	var sb strings.Builder
+	sb.WriteString("PT")
+	if h > 0 {
+		sb.WriteString(fmt.Sprintf("%dH", h))
+	}
+	if m > 0 {
+		sb.WriteString(fmt.Sprintf("%dM", m))
+	}
+	sb.WriteString(strconv.FormatFloat(float64(ms)/1000, 'f', -1, 64) + "S")
+	return sb.String()
+}
+
+// DASHManifest is the DASH view of a CMAF presentation: a video adaptation
+// set with a representation per quality, an audio adaptation set per track
+// and a text adaptation set per subtitle. Media segments are addressed with
+// SegmentTemplate/SegmentTimeline over the same files the HLS playlists use.
+func DASHManifest(p *CMAFPresentation) ([]byte, error) {
+	timeline := segmentTimeline(p.Duration)
+	template := func(dir, query string) *mpdSegmentTemplate {
+		return &mpdSegmentTemplate{
+			Timescale:      dashTimescale,
+			Initialization: fmt.Sprintf("%s/%s/init.mp4?%s", p.base(), dir, query),
+			Media:          fmt.Sprintf("%s/%s/segment_$Number%%05d$.%s?%s", p.base(), dir, cmafSegmentExt, query),
+			StartNumber:    0,
+			Timeline:       timeline,
+		}
+	}
+
+	period := mpdPeriod{ID: "0", Start: "PT0S"}
+	nextID := 0
+
+	video := mpdAdaptationSet{
+		ID:               nextID,
+		ContentType:      "video",
+		MimeType:         "video/mp4",
+		SegmentAlignment: true,
+		SegmentTemplate:  template("$RepresentationID$", p.query(-1)),
+	}
+	for _, name := range p.Qualities {
+		q := Qualities[name]
+		video.Representations = append(video.Representations, mpdRepresentation{
+			ID:        name,
+			Bandwidth: bitrateBPS(q.VideoBitrate),
+			Codecs:    p.videoCodecs(),
+			Width:     q.Width,
+			Height:    q.Height,
+		})
+	}
+	period.AdaptationSets = append(period.AdaptationSets, video)
+	nextID++
+
+	for i, a := range p.Audio {
+		set := mpdAdaptationSet{
+			ID:               nextID,
+			ContentType:      "audio",
+			MimeType:         "audio/mp4",
+			Lang:             a.Language,
+			SegmentAlignment: true,
+			Label:            a.Label,
+			SegmentTemplate:  template(AudioRendition, p.query(a.StreamIndex)),
+		}
+		if a.Default || i == 0 && !hasDefaultAudio(p.Audio) {
+			set.Roles = append(set.Roles, mpdDescriptor{SchemeIDURI: "urn:mpeg:dash:role:2011", Value: "main"})
+		}
+		set.Representations = append(set.Representations, mpdRepresentation{
+			ID:        fmt.Sprintf("audio-%d", i),
+			Bandwidth: 192000,
+			Codecs:    audioCodecs,
+			AudioChannelConfiguration: &mpdDescriptor{
+				SchemeIDURI: "urn:mpeg:dash:23003:3:audio_channel_configuration:2011",
+				Value:       strconv.Itoa(max(a.Channels, 2)),
+			},
+		})
+		period.AdaptationSets = append(period.AdaptationSets, set)
+		nextID++
+	}
+
+	for i, s := range p.Subtitles {
+		role := "subtitle"
+		if s.Forced {
+			role = "forced-subtitle"
+		}
+		period.AdaptationSets = append(period.AdaptationSets, mpdAdaptationSet{
+			ID:          nextID,
+			ContentType: "text",
+			MimeType:    "text/vtt",
+			Lang:        s.Language,
+			Roles:       []mpdDescriptor{{SchemeIDURI: "urn:mpeg:dash:role:2011", Value: role}},
+			Label:       s.Label,
+			Representations: []mpdRepresentation{{
+				ID:        fmt.Sprintf("text-%d", i),
+				Bandwidth: 256,
+				BaseURL:   fmt.Sprintf("%s/subtitles/%s?%s", p.base(), s.ID, p.subtitleQuery()),
+			}},
+		})
+		nextID++
+	}
+
+	doc := mpdRoot{
+		Xmlns:         "urn:mpeg:dash:schema:mpd:2011",
+		Profiles:      "urn:mpeg:dash:profile:isoff-live:2011,urn:mpeg:dash:profile:cmaf:2019",
+		Type:          "static",
+		Duration:      isoDuration(p.Duration),
+		MinBufferTime: fmt.Sprintf("PT%gS", SegmentDuration),
+		Period:        period,
+	}
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
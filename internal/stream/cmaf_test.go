@@ -0,0 +1,172 @@
+package stream
+
+import (
+	"encoding/xml"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Parsed independently of the writer's types, so the test checks the
+// document a player sees.
+type testMPD struct {
+	Type     string `xml:"type,attr"`
+	Duration string `xml:"mediaPresentationDuration,attr"`
+	Period   struct {
+		Sets []struct {
+			ContentType string `xml:"contentType,attr"`
+			MimeType    string `xml:"mimeType,attr"`
+			Lang        string `xml:"lang,attr"`
+			Roles       []struct {
+				Value string `xml:"value,attr"`
+			} `xml:"Role"`
+			Template *struct {
+				Timescale   int    `xml:"timescale,attr"`
+				Media       string `xml:"media,attr"`
+				StartNumber int    `xml:"startNumber,attr"`
+				S           []struct {
+					T *int64 `xml:"t,attr"`
+					D int64  `xml:"d,attr"`
+					R int    `xml:"r,attr"`
+				} `xml:"SegmentTimeline>S"`
+			} `xml:"SegmentTemplate"`
+			Reps []struct {
+				ID      string `xml:"id,attr"`
+				Height  int    `xml:"height,attr"`
+				BaseURL string `xml:"BaseURL"`
+			} `xml:"Representation"`
+		} `xml:"AdaptationSet"`
+	} `xml:"Period"`
+}
+
+func testPresentation() *CMAFPresentation {
+	return &CMAFPresentation{
+		MediaID:   "item-1",
+		Duration:  5432.1,
+		Qualities: []string{"480p", "1080p"},
+		Codec:     "h264",
+		Audio: []CMAFAudio{
+			{StreamIndex: 1, Language: "eng", Label: "English 5.1", Channels: 6, Default: true},
+			{StreamIndex: 2, Language: "fra", Channels: 2},
+		},
+		Subtitles: []CMAFSubtitle{
+			{ID: "sub-en", Language: "eng", Label: "English"},
+			{ID: "sub-fr-forced", Language: "fra", Forced: true},
+		},
+		Query: url.Values{"token": {"t0k"}},
+	}
+}
+
+func TestDASHManifest(t *testing.T) {
+	p := testPresentation()
+	out, err := DASHManifest(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mpd testMPD
+	if err := xml.Unmarshal(out, &mpd); err != nil {
+		t.Fatalf("manifest is not valid XML: %v\n%s", err, out)
+	}
+
+	if mpd.Type != "static" || mpd.Duration != "PT1H30M32.1S" {
+		t.Errorf("type %q, mediaPresentationDuration %q, want static, PT1H30M32.1S", mpd.Type, mpd.Duration)
+	}
+
+	var video, audio, text int
+	for _, set := range mpd.Period.Sets {
+		switch set.ContentType {
+		case "video":
+			video++
+			if len(set.Reps) != 2 || set.Reps[0].Height != 480 || set.Reps[1].Height != 1080 {
+				t.Errorf("video representations = %+v", set.Reps)
+			}
+		case "audio":
+			audio++
+			if set.MimeType != "audio/mp4" || len(set.Reps) != 1 || set.Template == nil {
+				t.Errorf("audio set %q: %+v", set.Lang, set)
+			}
+		case "text":
+			text++
+			if set.MimeType != "text/vtt" || set.Template != nil || len(set.Reps) != 1 ||
+				!strings.Contains(set.Reps[0].BaseURL, "/subtitles/") {
+				t.Errorf("text set %q: %+v", set.Lang, set)
+			}
+		default:
+			t.Errorf("unexpected adaptation set %q", set.ContentType)
+		}
+	}
+	if video != 1 || audio != len(p.Audio) || text != len(p.Subtitles) {
+		t.Fatalf("adaptation sets: %d video, %d audio, %d text; want 1, %d, %d",
+			video, audio, text, len(p.Audio), len(p.Subtitles))
+	}
+
+	// Video and audio share the timeline: 905 full 6s segments, then 2.1s
+	for _, set := range mpd.Period.Sets[:3] {
+		tpl := set.Template
+		if tpl.Timescale != 1000 || tpl.StartNumber != 0 || !strings.Contains(tpl.Media, "$Number%05d$.m4s") {
+			t.Errorf("%s template = %+v", set.ContentType, tpl)
+		}
+		if len(tpl.S) != 2 {
+			t.Fatalf("%s timeline has %d entries, want 2", set.ContentType, len(tpl.S))
+		}
+		first, last := tpl.S[0], tpl.S[1]
+		if first.T == nil || *first.T != 0 || first.D != 6000 || first.R != 904 {
+			t.Errorf("%s first S = t %v d %d r %d, want t 0 d 6000 r 904", set.ContentType, first.T, first.D, first.R)
+		}
+		if last.D != 2100 || last.R != 0 {
+			t.Errorf("%s last S = d %d r %d, want d 2100", set.ContentType, last.D, last.R)
+		}
+	}
+
+	// Roles: the default audio track is main, the forced subtitle flagged
+	audioEng, textFra := mpd.Period.Sets[1], mpd.Period.Sets[4]
+	if audioEng.Lang != "eng" || len(audioEng.Roles) != 1 || audioEng.Roles[0].Value != "main" {
+		t.Errorf("default audio roles = %+v", audioEng.Roles)
+	}
+	if len(mpd.Period.Sets[2].Roles) != 0 {
+		t.Errorf("second audio track has roles %+v", mpd.Period.Sets[2].Roles)
+	}
+	if textFra.Lang != "fra" || len(textFra.Roles) != 1 || textFra.Roles[0].Value != "forced-subtitle" {
+		t.Errorf("forced subtitle roles = %+v", textFra.Roles)
+	}
+}
+
+func TestSegmentTimeline(t *testing.T) {
+	tests := []struct {
+		duration float64
+		want     []mpdS
+	}{
+		{0, nil},
+		{4.5, []mpdS{{D: 4500}}},
+		{6, []mpdS{{D: 6000}}},
+		{12, []mpdS{{D: 6000, R: 0}, {D: 6000}}},
+		{60.25, []mpdS{{D: 6000, R: 9}, {D: 250}}},
+	}
+	for _, tt := range tests {
+		got := segmentTimeline(tt.duration).S
+		if len(got) != len(tt.want) {
+			t.Errorf("%v s: %d entries, want %d", tt.duration, len(got), len(tt.want))
+			continue
+		}
+		for i := range got {
+			if got[i].D != tt.want[i].D || got[i].R != tt.want[i].R || (i == 0) != (got[i].T != nil) {
+				t.Errorf("%v s: S[%d] = %+v, want %+v", tt.duration, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestISODuration(t *testing.T) {
+	tests := map[float64]string{
+		0:       "PT0S",
+		59.5:    "PT59.5S",
+		3600:    "PT1H0S",
+		5432.1:  "PT1H30M32.1S",
+		7322.25: "PT2H2M2.25S",
+	}
+	for in, want := range tests {
+		if got := isoDuration(in); got != want {
+			t.Errorf("isoDuration(%v) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package stream
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// HLSMasterPlaylist is the master playlist for muxed sessions (MPEG-TS, or
+// fMP4 for HEVC). The video variants carry the audio track the request
+// picked, the file's first by default; it is listed in the audio group
+// without a URI, and every other track as an audio-only session, so
+// players switch language without restarting the video encode. Text
+// subtitles are segmented WebVTT renditions. Clients that ignore
+// renditions play the muxed track as before.
+func HLSMasterPlaylist(p *CMAFPresentation) string {
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+
+	// A lone muxed track needs no group
+	audioGroup := len(p.Audio) > 1
+	if audioGroup {
+		muxed := p.muxedAudio()
+		for i, a := range p.Audio {
+			sb.WriteString(fmt.Sprintf("#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aud\",NAME=%q,%sDEFAULT=%s,AUTOSELECT=YES,CHANNELS=\"%d\"",
+				mediaName(a.Label, a.Language, fmt.Sprintf("Audio %d", i+1)), languageAttr(a.Language), yesNo(i == muxed), max(a.Channels, 2)))
+			if i != muxed {
+				sb.WriteString(fmt.Sprintf(",URI=\"%s/%s/stream.m3u8?%s\"", p.base(), AudioRendition, p.audioRenditionQuery(a.StreamIndex)))
+			}
+			sb.WriteString("\n")
+		}
+	}
+	subQuery := p.muxedSubtitleQuery()
+	if subQuery != "" {
+		subQuery = "?" + subQuery
+	}
+	for i, s := range p.Subtitles {
+		sb.WriteString(fmt.Sprintf("#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",NAME=%q,%sDEFAULT=%s,AUTOSELECT=YES,FORCED=%s,URI=\"%s/subtitles/%s/playlist.m3u8%s\"\n",
+			mediaName(s.Label, s.Language, fmt.Sprintf("Subtitles %d", i+1)), languageAttr(s.Language), yesNo(s.Default), yesNo(s.Forced),
+			p.base(), s.ID, subQuery))
+	}
+
+	query := p.Query.Encode()
+	if query != "" {
+		query = "?" + query
+	}
+	for _, name := range p.Qualities {
+		q := Qualities[name]
+		sb.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,NAME=\"%s\"",
+			bitrateBPS(q.VideoBitrate), q.Width, q.Height, q.Name))
+		if audioGroup {
+			sb.WriteString(",AUDIO=\"aud\"")
+		}
+		if len(p.Subtitles) > 0 {
+			sb.WriteString(",SUBTITLES=\"subs\"")
+		}
+		sb.WriteString(fmt.Sprintf("\n%s/%s/stream.m3u8%s\n", p.base(), name, query))
+	}
+	return sb.String()
+}
+
+// muxedAudio is the index in p.Audio of the track the video variants
+// carry: the one ?audio= picked, or the first.
+func (p *CMAFPresentation) muxedAudio() int {
+	if idx, err := strconv.Atoi(p.Query.Get("audio")); err == nil {
+		for i, a := range p.Audio {
+			if a.StreamIndex == idx {
+				return i
+			}
+		}
+	}
+	return 0
+}
+
+// audioRenditionQuery is the query of a muxed master's audio-only session:
+// the request's, minus the video-only options, for one track.
+func (p *CMAFPresentation) audioRenditionQuery(audioIndex int) string {
+	q := url.Values{}
+	for k, v := range p.Query {
+		q[k] = v
+	}
+	for _, k := range []string{"subtitle", "burn", "hdr", "codec"} {
+		q.Del(k)
+	}
+	q.Del("audio")
+	if audioIndex >= 0 {
+		q.Set("audio", strconv.Itoa(audioIndex))
+	}
+	return q.Encode()
+}
+
+// muxedSubtitleQuery is the subtitle playlist query for a muxed master;
+// MPEG-TS sessions need their cue times mapped onto the TS timeline.
+func (p *CMAFPresentation) muxedSubtitleQuery() string {
+	q := url.Values{}
+	if token := p.Query.Get("token"); token != "" {
+		q.Set("token", token)
+	}
+	if p.Codec != "hevc" {
+		q.Set("container", "ts")
+	}
+	return q.Encode()
+}
@@ -0,0 +1,77 @@
+package stream
+
+import (
+	"flag"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// golden compares got byte-for-byte with testdata/name, or rewrites the
+// file under -update.
+func golden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the golden file\n--- got:\n%s\n--- want:\n%s", name, got, want)
+	}
+}
+
+func TestHLSMasterPlaylist(t *testing.T) {
+	tests := []struct {
+		golden string
+		p      *CMAFPresentation
+	}{
+		// Two audio tracks, the second muxed, and a default and a forced
+		// subtitle on an MPEG-TS session
+		{"master_ts.m3u8", &CMAFPresentation{
+			MediaID:   "item-1",
+			Qualities: []string{"720p", "1080p"},
+			Codec:     "h264",
+			Audio: []CMAFAudio{
+				{StreamIndex: 1, Language: "eng", Label: "English 5.1", Channels: 6},
+				{StreamIndex: 2, Language: "fra", Channels: 2},
+				{StreamIndex: 3, Channels: 1},
+			},
+			Subtitles: []CMAFSubtitle{
+				{ID: "sub-en", Language: "eng", Label: "English", Default: true},
+				{ID: "sub-fr-forced", Language: "fra", Forced: true},
+			},
+			Query: url.Values{"token": {"t0k"}, "audio": {"2"}, "subtitle": {"4"}, "hdr": {"tonemap"}},
+		}},
+		// HEVC sessions are fMP4: subtitle cues keep their own timeline
+		{"master_hevc.m3u8", &CMAFPresentation{
+			MediaID:   "item-2",
+			Qualities: []string{"4K"},
+			Codec:     "hevc",
+			Audio:     []CMAFAudio{{StreamIndex: 1, Language: "jpn", Channels: 2}},
+			Subtitles: []CMAFSubtitle{{ID: "sub-en", Language: "eng"}},
+		}},
+		// One track and no subtitles: plain variants, no groups
+		{"master_plain.m3u8", &CMAFPresentation{
+			MediaID:   "item-3",
+			Qualities: []string{"480p", "720p"},
+			Codec:     "h264",
+			Audio:     []CMAFAudio{{StreamIndex: 1, Language: "eng", Channels: 2}},
+			Query:     url.Values{"token": {"t0k"}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			golden(t, tt.golden, HLSMasterPlaylist(tt.p))
+		})
+	}
+}
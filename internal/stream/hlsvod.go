@@ -0,0 +1,129 @@
+package stream
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SegmentDuration is the fixed length of transcoded HLS segments. Segment n
+// always covers [n*SegmentDuration, (n+1)*SegmentDuration) of the source, so
+// the playlist can be written before anything is encoded and ffmpeg can be
+// restarted at any segment without shifting the others.
+const SegmentDuration = 6.0
+
+// maxWaitSegments is how far ahead of the encoder a request may be before
+// it's cheaper to restart ffmpeg there than to wait for it to catch up.
+const maxWaitSegments = 4
+
+// SegmentCount returns the number of segments for a source of the given length.
+func SegmentCount(durationSeconds float64) int {
+	if durationSeconds <= 0 {
+		return 0
+	}
+	return int(math.Ceil(durationSeconds / SegmentDuration))
+}
+
+// SegmentName returns the file name of segment n.
+func SegmentName(n int, ext string) string {
+	return fmt.Sprintf("segment_%05d.%s", n, ext)
+}
+
+// ParseSegmentName returns the index of a segment file name.
+func ParseSegmentName(name string) (int, bool) {
+	if !strings.HasPrefix(name, "segment_") {
+		return 0, false
+	}
+	base := strings.TrimPrefix(name, "segment_")
+	if dot := strings.IndexByte(base, '.'); dot > 0 {
+		base = base[:dot]
+	}
+	n, err := strconv.Atoi(base)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// VODPlaylist writes a complete media playlist covering durationSeconds with
+// fixed-length segments. fmp4 playlists reference init.mp4. query (already
+// encoded, may be empty) is appended to every URI so segment requests find
+// the session the playlist was made for.
+func VODPlaylist(durationSeconds float64, segExt, query string) string {
+	if query != "" {
+		query = "?" + query
+	}
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	sb.WriteString("#EXT-X-VERSION:7\n")
+	sb.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	sb.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(SegmentDuration))))
+	sb.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	sb.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	if segExt != "ts" {
+		sb.WriteString(fmt.Sprintf("#EXT-X-MAP:URI=\"init.mp4%s\"\n", query))
+	}
+	count := SegmentCount(durationSeconds)
+	for n := 0; n < count; n++ {
+		length := SegmentDuration
+		if n == count-1 {
+			length = durationSeconds - float64(n)*SegmentDuration
+		}
+		sb.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%s%s\n", length, SegmentName(n, segExt), query))
+	}
+	sb.WriteString("#EXT-X-ENDLIST\n")
+	return sb.String()
+}
+
+// segmentAction is what to do about a request for a segment that isn't on
+// disk yet.
+type segmentAction int
+
+const (
+	segmentWait    segmentAction = iota // the running encoder will get there soon
+	segmentRestart                      // restart the encoder at the segment
+)
+
+// planSegment decides how to produce segment want. runStart is the segment
+// the current ffmpeg run started at, head the last segment it has finished
+// (runStart-1 if none) and running whether it is still going.
+func planSegment(want, runStart, head int, running bool) segmentAction {
+	switch {
+	case !running:
+		return segmentRestart
+	case want < runStart:
+		// Behind where this run started; it will never come back for it
+		return segmentRestart
+	case want <= head:
+		// Should exist but doesn't (partial write from a killed run)
+		return segmentRestart
+	case want > head+maxWaitSegments:
+		return segmentRestart
+	}
+	return segmentWait
+}
+
+// completedSegments reads the segments ffmpeg has finished from its own
+// playlist; a segment is only listed once it is fully written.
+func completedSegments(playlistPath string) map[int]bool {
+	done := make(map[int]bool)
+	f, err := os.Open(playlistPath)
+	if err != nil {
+		return done
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if n, ok := ParseSegmentName(line); ok {
+			done[n] = true
+		}
+	}
+	return done
+}
@@ -0,0 +1,113 @@
+package stream
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlanSegment(t *testing.T) {
+	tests := []struct {
+		name     string
+		want     int
+		runStart int
+		head     int
+		running  bool
+		action   segmentAction
+	}{
+		{"encoder stopped", 10, 0, 9, false, segmentRestart},
+		{"encoder stopped before any segment", 0, 0, -1, false, segmentRestart},
+		{"seek behind the run start", 3, 20, 25, true, segmentRestart},
+		{"just behind the run start", 19, 20, 19, true, segmentRestart},
+		{"already passed but missing", 22, 20, 25, true, segmentRestart},
+		{"head itself missing", 25, 20, 25, true, segmentRestart},
+		{"far ahead of the encoder", 30, 20, 25, true, segmentRestart},
+		{"one past the wait window", 25 + maxWaitSegments + 1, 20, 25, true, segmentRestart},
+		{"next segment", 26, 20, 25, true, segmentWait},
+		{"edge of the wait window", 25 + maxWaitSegments, 20, 25, true, segmentWait},
+		{"first segment of a fresh run", 20, 20, 19, true, segmentWait},
+	}
+	for _, tt := range tests {
+		if got := planSegment(tt.want, tt.runStart, tt.head, tt.running); got != tt.action {
+			t.Errorf("%s: planSegment(%d, %d, %d, %v) = %v, want %v",
+				tt.name, tt.want, tt.runStart, tt.head, tt.running, got, tt.action)
+		}
+	}
+}
+
+func TestVODPlaylist(t *testing.T) {
+	tests := []struct {
+		duration float64
+		ext      string
+		query    string
+		segments int
+		lastInf  string
+	}{
+		{60, "ts", "", 10, "#EXTINF:6.000,"},
+		{61.5, "ts", "", 11, "#EXTINF:1.500,"},
+		{5, "m4s", "session=abc", 1, "#EXTINF:5.000,"},
+		{7203.25, "m4s", "", 1201, "#EXTINF:3.250,"},
+	}
+	for _, tt := range tests {
+		pl := VODPlaylist(tt.duration, tt.ext, tt.query)
+		lines := strings.Split(strings.TrimSpace(pl), "\n")
+
+		var infs, uris []string
+		for i, line := range lines {
+			if strings.HasPrefix(line, "#EXTINF:") {
+				infs = append(infs, line)
+				uris = append(uris, lines[i+1])
+			}
+		}
+		if len(infs) != tt.segments || SegmentCount(tt.duration) != tt.segments {
+			t.Errorf("%v s: %d segments (SegmentCount %d), want %d", tt.duration, len(infs), SegmentCount(tt.duration), tt.segments)
+			continue
+		}
+		if last := infs[len(infs)-1]; last != tt.lastInf {
+			t.Errorf("%v s: last segment %q, want %q", tt.duration, last, tt.lastInf)
+		}
+		wantURI := SegmentName(tt.segments-1, tt.ext)
+		if tt.query != "" {
+			wantURI += "?" + tt.query
+		}
+		if last := uris[len(uris)-1]; last != wantURI {
+			t.Errorf("%v s: last URI %q, want %q", tt.duration, last, wantURI)
+		}
+		if hasMap := strings.Contains(pl, "#EXT-X-MAP:"); hasMap != (tt.ext != "ts") {
+			t.Errorf("%v s %s: EXT-X-MAP present = %v", tt.duration, tt.ext, hasMap)
+		}
+		if lines[len(lines)-1] != "#EXT-X-ENDLIST" {
+			t.Errorf("%v s: playlist does not end with #EXT-X-ENDLIST", tt.duration)
+		}
+	}
+	if pl := VODPlaylist(0, "ts", ""); strings.Contains(pl, "#EXTINF") {
+		t.Error("zero-length source produced segments")
+	}
+}
+
+func TestSegmentNames(t *testing.T) {
+	for _, n := range []int{0, 7, 12345} {
+		name := SegmentName(n, "m4s")
+		if got, ok := ParseSegmentName(name); !ok || got != n {
+			t.Errorf("ParseSegmentName(%q) = %d, %v", name, got, ok)
+		}
+	}
+	for _, bad := range []string{"init.mp4", "segment_x.ts", "segment_-1.ts", "playlist.m3u8"} {
+		if _, ok := ParseSegmentName(bad); ok {
+			t.Errorf("ParseSegmentName(%q) accepted", bad)
+		}
+	}
+}
+
+func TestCompletedSegments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ffmpeg.m3u8")
+	os.WriteFile(path, []byte("#EXTM3U\n#EXTINF:6.000,\nsegment_00020.ts\n#EXTINF:6.000,\nsegment_00021.ts\n"), 0644)
+	done := completedSegments(path)
+	if len(done) != 2 || !done[20] || !done[21] {
+		t.Errorf("completedSegments = %v, want 20 and 21", done)
+	}
+	if got := completedSegments(filepath.Join(t.TempDir(), "missing.m3u8")); len(got) != 0 {
+		t.Errorf("missing playlist: %v", got)
+	}
+}
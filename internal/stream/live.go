@@ -0,0 +1,251 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoTuner is returned by StartLive when LiveOptions.Acquire refuses a tuner.
+var ErrNoTuner = errors.New("all tuners are busy")
+
+const (
+	liveSegmentSeconds = 4
+	liveIdleTimeout    = 90 * time.Second
+
+	// LiveBufferPlaylist is the playlist ffmpeg writes; it lists every segment
+	// still inside the timeshift buffer.
+	LiveBufferPlaylist = "buffer.m3u8"
+)
+
+// LiveOptions configures a live channel session.
+type LiveOptions struct {
+	Quality        string        // key into Qualities, or "copy" to pass the broadcast video through
+	Buffer         time.Duration // timeshift window kept on disk (default 30m)
+	Acquire        func() bool   // reserves a tuner; called only when a new upstream connection is opened
+	OnStop         func()        // releases the tuner once the session ends
+	WindowSegments int           // segments in the live-edge playlist (default 6)
+}
+
+// LiveSessionKey is the session ID used for a channel's shared live stream.
+func LiveSessionKey(channelID string) string {
+	return "live-" + channelID
+}
+
+// StartLive returns the running live session for a channel or opens the
+// channel's MPEG-TS source and starts segmenting it into a sliding-window
+// HLS buffer. Every viewer of the channel shares this one session, and so
+// one upstream tuner connection. The session ends when ffmpeg exits or
+// nobody has fetched a playlist or segment for liveIdleTimeout.
+func (t *Transcoder) StartLive(channelID, sourceURL string, opt LiveOptions) (*Session, error) {
+	key := LiveSessionKey(channelID)
+
+	t.liveMu.Lock()
+	defer t.liveMu.Unlock()
+	if s := t.GetSession(key); s != nil {
+		return s, nil
+	}
+
+	if opt.Buffer <= 0 {
+		opt.Buffer = 30 * time.Minute
+	}
+	if opt.WindowSegments <= 0 {
+		opt.WindowSegments = 6
+	}
+	if opt.Acquire != nil && !opt.Acquire() {
+		return nil, ErrNoTuner
+	}
+
+	outputDir := filepath.Join(t.outputBase, key)
+	os.RemoveAll(outputDir)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		if opt.OnStop != nil {
+			opt.OnStop()
+		}
+		return nil, fmt.Errorf("create output dir: %w", err)
+	}
+
+	args := []string{"-nostdin", "-fflags", "+genpts+discardcorrupt"}
+	if strings.HasPrefix(sourceURL, "http://") || strings.HasPrefix(sourceURL, "https://") {
+		args = append(args, "-reconnect", "1", "-reconnect_streamed", "1", "-reconnect_delay_max", "5")
+	}
+	encoder := ""
+	if opt.Quality != "copy" {
+		encoder = t.DetectHWAccel()
+		if strings.Contains(encoder, "vaapi") {
+			args = append(args, "-vaapi_device", "/dev/dri/renderD128")
+		}
+	}
+	args = append(args, "-i", sourceURL, "-map", "0:v:0?", "-map", "0:a:0?")
+
+	if opt.Quality == "copy" {
+		args = append(args, "-c:v", "copy")
+	} else {
+		q, ok := Qualities[opt.Quality]
+		if !ok {
+			q = Qualities["720p"]
+		}
+		// Broadcast video is usually interlaced; decode in software so the
+		// deinterlace and scale filters work with every encoder.
+		filters := []string{"yadif=deint=interlaced", fmt.Sprintf("scale=-2:%d", q.Height)}
+		switch {
+		case strings.Contains(encoder, "vaapi"):
+			filters = append(filters, "format=nv12", "hwupload")
+		case strings.Contains(encoder, "qsv"):
+			filters = append(filters, "format=nv12")
+		}
+		args = append(args,
+			"-vf", strings.Join(filters, ","),
+			"-c:v", encoder,
+			"-b:v", q.VideoBitrate,
+			"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", liveSegmentSeconds),
+		)
+	}
+	args = append(args, BuildAudioTranscodeArgs("unknown", 2, 0)...)
+
+	bufferSegments := int(opt.Buffer.Seconds()) / liveSegmentSeconds
+	if bufferSegments < opt.WindowSegments {
+		bufferSegments = opt.WindowSegments
+	}
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(liveSegmentSeconds),
+		"-hls_list_size", strconv.Itoa(bufferSegments),
+		"-hls_delete_threshold", "2",
+		"-hls_flags", "delete_segments+independent_segments+program_date_time",
+		"-hls_segment_filename", filepath.Join(outputDir, "live_%06d.ts"),
+		"-y", filepath.Join(outputDir, LiveBufferPlaylist),
+	)
+
+	cmd := exec.Command(t.ffmpegPath, args...)
+	stderrBuf := &strings.Builder{}
+	cmd.Stderr = stderrBuf
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(outputDir)
+		if opt.OnStop != nil {
+			opt.OnStop()
+		}
+		return nil, fmt.Errorf("start ffmpeg: %w", err)
+	}
+
+	session := &Session{
+		ID:             key,
+		MediaItemID:    channelID,
+		Quality:        opt.Quality,
+		OutputDir:      outputDir,
+		Cmd:            cmd,
+		StartedAt:      time.Now(),
+		LastAccess:     time.Now(),
+		Live:           true,
+		WindowSegments: opt.WindowSegments,
+		onStop:         opt.OnStop,
+	}
+	t.mu.Lock()
+	t.sessions[key] = session
+	t.mu.Unlock()
+	log.Printf("Live session started: %s (%s, buffer=%s)", key, opt.Quality, opt.Buffer)
+
+	exited := make(chan struct{})
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			errStr := stderrBuf.String()
+			if len(errStr) > 1000 {
+				errStr = errStr[len(errStr)-1000:]
+			}
+			log.Printf("Live session %s ended: %v | stderr: %s", key, err, errStr)
+		}
+		close(exited)
+		t.stopIfCurrent(session)
+	}()
+	go func() {
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-exited:
+				return
+			case <-ticker.C:
+				t.mu.Lock()
+				idle := time.Since(session.LastAccess)
+				t.mu.Unlock()
+				if idle > liveIdleTimeout {
+					log.Printf("Live session %s idle for %s, closing", key, idle.Round(time.Second))
+					t.stopIfCurrent(session)
+					return
+				}
+			}
+		}
+	}()
+
+	return session, nil
+}
+
+// stopIfCurrent stops a session unless it has already been replaced or removed.
+func (t *Transcoder) stopIfCurrent(s *Session) {
+	t.mu.Lock()
+	current := t.sessions[s.ID] == s
+	t.mu.Unlock()
+	if current {
+		t.StopSession(s.ID)
+	}
+}
+
+// LivePlaylist rewrites ffmpeg's buffer playlist into the playlist served to
+// clients. window > 0 keeps only the newest window segments (the live edge);
+// window <= 0 keeps the whole timeshift buffer so players can pause and seek
+// back. uriPrefix is prepended to each segment URI. Returns nil until the
+// first segment is available.
+func LivePlaylist(data []byte, window int, uriPrefix string) []byte {
+	var header []string
+	var segments [][]string
+	var pending []string
+	mediaSeq := 0
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "" || line == "#EXT-X-ENDLIST":
+			continue
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			mediaSeq, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+		case strings.HasPrefix(line, "#EXTINF"), strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME"),
+			strings.HasPrefix(line, "#EXT-X-DISCONTINUITY"):
+			pending = append(pending, line)
+		case strings.HasPrefix(line, "#"):
+			if len(segments) == 0 && len(pending) == 0 {
+				header = append(header, line)
+			}
+		default:
+			segments = append(segments, append(pending, uriPrefix+line))
+			pending = nil
+		}
+	}
+	if len(segments) == 0 {
+		return nil
+	}
+	if window > 0 && len(segments) > window {
+		mediaSeq += len(segments) - window
+		segments = segments[len(segments)-window:]
+	}
+
+	var b bytes.Buffer
+	for _, h := range header {
+		b.WriteString(h + "\n")
+	}
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", mediaSeq)
+	for _, seg := range segments {
+		for _, l := range seg {
+			b.WriteString(l + "\n")
+		}
+	}
+	return b.Bytes()
+}
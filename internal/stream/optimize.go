@@ -0,0 +1,109 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// OptimizeProfile is a target format for optimized versions: H.264 and
+// stereo AAC in a fast-start MP4, which every client direct plays.
+type OptimizeProfile struct {
+	Name    string `json:"name"`
+	Label   string `json:"label"`
+	Quality string `json:"quality"` // key into Qualities; caps height and bitrate
+}
+
+// OptimizeProfiles are the profiles optimized versions can be made in.
+var OptimizeProfiles = map[string]OptimizeProfile{
+	"mobile": {Name: "mobile", Label: "Mobile (720p)", Quality: "720p"},
+	"low":    {Name: "low", Label: "Low bandwidth (480p)", Quality: "480p"},
+	"tv":     {Name: "tv", Label: "TV (1080p)", Quality: "1080p"},
+}
+
+// EstimatedSize is roughly how many bytes an optimized version of a
+// durationSeconds long source takes.
+func (p OptimizeProfile) EstimatedSize(durationSeconds float64) int64 {
+	q := Qualities[p.Quality]
+	return int64(durationSeconds * float64(bitrateBPS(q.VideoBitrate)+bitrateBPS(q.AudioBitrate)) / 8)
+}
+
+// OptimizeArgs builds the ffmpeg arguments that transcode filePath into an
+// optimized version at outPath. Offline jobs encode with libx264 rather
+// than the hardware encoder, leaving the GPU to live transcodes and getting
+// better quality per bit. The source is never upscaled.
+func OptimizeArgs(filePath, outPath string, p OptimizeProfile, hdrToSDR bool) []string {
+	q := Qualities[p.Quality]
+	filters := []string{fmt.Sprintf("scale=-2:'min(%d,ih)'", q.Height)}
+	if hdrToSDR {
+		filters = append(filters, HDRToSDRFilter("software"))
+	}
+	filters = append(filters, "format=yuv420p")
+	bufsize := fmt.Sprintf("%dk", 2*bitrateBPS(q.VideoBitrate)/1000)
+	return []string{
+		"-nostdin", "-y",
+		"-i", filePath,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-map_metadata", "0", "-map_chapters", "0",
+		"-c:v", "libx264", "-preset", "medium", "-profile:v", "high",
+		"-vf", strings.Join(filters, ","),
+		"-b:v", q.VideoBitrate, "-maxrate", q.VideoBitrate, "-bufsize", bufsize,
+		"-c:a", "aac", "-ac", "2", "-b:a", q.AudioBitrate,
+		"-movflags", "+faststart",
+		"-progress", "pipe:1", "-nostats",
+		"-f", "mp4", outPath,
+	}
+}
+
+// Optimize runs ffmpeg to make an optimized version, calling progress with
+// the percentage done as it goes. Cancelling ctx kills ffmpeg.
+func Optimize(ctx context.Context, ffmpegPath, filePath, outPath string, p OptimizeProfile, hdrToSDR bool, durationSeconds float64, progress func(pct int)) error {
+	cmd := exec.CommandContext(ctx, ffmpegPath, OptimizeArgs(filePath, outPath, p, hdrToSDR)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderrBuf := &strings.Builder{}
+	cmd.Stderr = stderrBuf
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start ffmpeg: %w", err)
+	}
+
+	// -progress writes key=value blocks; out_time_us (out_time_ms in older
+	// builds, also in microseconds) is the position reached
+	sc := bufio.NewScanner(stdout)
+	last := -1
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), "=")
+		if !ok || (key != "out_time_us" && key != "out_time_ms") || durationSeconds <= 0 || progress == nil {
+			continue
+		}
+		us, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		pct := int(float64(us) / 1e6 / durationSeconds * 100)
+		if pct > 99 {
+			pct = 99
+		}
+		if pct > last {
+			last = pct
+			progress(pct)
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		errStr := stderrBuf.String()
+		if len(errStr) > 500 {
+			errStr = errStr[len(errStr)-500:]
+		}
+		return fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(errStr))
+	}
+	return nil
+}
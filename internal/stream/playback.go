@@ -0,0 +1,225 @@
+package stream
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/JustinTDCT/CineVault/internal/ffmpeg"
+)
+
+// DeviceProfile is what a client reports it can play. Empty container and
+// codec lists accept anything; zero limits mean no limit.
+type DeviceProfile struct {
+	Name        string   `json:"name,omitempty"`
+	Containers  []string `json:"containers"`   // played as-is, e.g. "mp4", "webm"
+	VideoCodecs []string `json:"video_codecs"` // e.g. "h264", "hevc", "vp9", "av1"
+	AudioCodecs []string `json:"audio_codecs"` // e.g. "aac", "mp3", "opus", "ac3"
+	MaxWidth    int      `json:"max_width,omitempty"`
+	MaxHeight   int      `json:"max_height,omitempty"`
+	MaxBitrate  int64    `json:"max_bitrate,omitempty"` // bits per second
+	// Highest decodable level per video codec, as ffprobe reports it
+	// (41 for H.264 4.1, 153 for HEVC 5.1)
+	MaxVideoLevels   map[string]int `json:"max_video_levels,omitempty"`
+	MaxAudioChannels int            `json:"max_audio_channels,omitempty"`
+	// HDR formats rendered natively ("HDR10", "HLG", "Dolby Vision", ...);
+	// empty means SDR only
+	HDRFormats []string `json:"hdr_formats,omitempty"`
+	// Subtitle codecs the client renders itself; text subtitles it doesn't
+	// list are still fine if it lists "webvtt", which the server converts to
+	SubtitleFormats []string `json:"subtitle_formats,omitempty"`
+}
+
+// PlaybackMethod is how a media item reaches a client.
+type PlaybackMethod string
+
+const (
+	MethodDirectPlay     PlaybackMethod = "direct_play"     // the file as-is
+	MethodDirectStream   PlaybackMethod = "direct_stream"   // remuxed, both streams copied
+	MethodAudioTranscode PlaybackMethod = "audio_transcode" // remuxed, video copied, audio to AAC
+	MethodTranscode      PlaybackMethod = "transcode"       // HLS transcode
+)
+
+// Reasons a stream can't be played as-is.
+const (
+	ReasonContainer       = "container_not_supported"
+	ReasonVideoCodec      = "video_codec_not_supported"
+	ReasonVideoResolution = "video_resolution_not_supported"
+	ReasonVideoLevel      = "video_level_not_supported"
+	ReasonVideoBitrate    = "bitrate_too_high"
+	ReasonHDR             = "hdr_not_supported"
+	ReasonAudioCodec      = "audio_codec_not_supported"
+	ReasonAudioChannels   = "audio_channels_not_supported"
+	ReasonSubtitleBurnIn  = "subtitle_needs_burn_in"
+)
+
+// PlaybackRequest is the tracks the client wants.
+type PlaybackRequest struct {
+	AudioStreamIndex    int // -1 for the default audio stream
+	SubtitleStreamIndex int // -1 for none
+}
+
+// PlaybackDecision is the outcome of Decide.
+type PlaybackDecision struct {
+	Method  PlaybackMethod `json:"method"`
+	Reasons []string       `json:"reasons,omitempty"`
+	// Transcode parameters
+	Quality       string `json:"quality,omitempty"`
+	ToneMap       bool   `json:"tone_map,omitempty"`
+	BurnSubtitles bool   `json:"burn_subtitles,omitempty"`
+}
+
+// imageSubtitleCodecs can't be converted to WebVTT and are burned in when
+// the client can't render them.
+var imageSubtitleCodecs = []string{"hdmv_pgs_subtitle", "pgssub", "dvd_subtitle", "dvdsub", "dvb_subtitle", "xsub"}
+
+// Decide picks how to deliver a probed file to a client. Video problems
+// (codec, size, level, bitrate, HDR, burned-in subtitles) need a full
+// transcode; audio problems alone an audio-only transcode; a container the
+// client can't open alone a remux.
+func Decide(probe *ffmpeg.ProbeResult, profile *DeviceProfile, req PlaybackRequest) PlaybackDecision {
+	var d PlaybackDecision
+	var videoReasons, audioReasons, containerReasons []string
+
+	video := primaryVideo(probe)
+	audio := selectedAudio(probe, req.AudioStreamIndex)
+
+	container := strings.ToLower(strings.TrimPrefix(filepath.Ext(probe.Format.Filename), "."))
+	if !accepts(profile.Containers, container) {
+		containerReasons = append(containerReasons, ReasonContainer)
+	}
+
+	if video != nil {
+		codec := strings.ToLower(video.CodecName)
+		if !accepts(profile.VideoCodecs, codec) {
+			videoReasons = append(videoReasons, ReasonVideoCodec)
+		}
+		if (profile.MaxHeight > 0 && video.Height > profile.MaxHeight) || (profile.MaxWidth > 0 && video.Width > profile.MaxWidth) {
+			videoReasons = append(videoReasons, ReasonVideoResolution)
+		}
+		if limit := profile.MaxVideoLevels[codec]; limit > 0 && video.Level > limit {
+			videoReasons = append(videoReasons, ReasonVideoLevel)
+		}
+		if hdr := probe.GetHDRFormat(); hdr != "" && !containsFold(profile.HDRFormats, hdr) {
+			videoReasons = append(videoReasons, ReasonHDR)
+			d.ToneMap = true
+		}
+	}
+	if profile.MaxBitrate > 0 && probe.GetBitrate() > profile.MaxBitrate {
+		videoReasons = append(videoReasons, ReasonVideoBitrate)
+	}
+
+	if audio != nil {
+		if !accepts(profile.AudioCodecs, strings.ToLower(audio.CodecName)) {
+			audioReasons = append(audioReasons, ReasonAudioCodec)
+		}
+		if profile.MaxAudioChannels > 0 && audio.Channels > profile.MaxAudioChannels {
+			audioReasons = append(audioReasons, ReasonAudioChannels)
+		}
+	}
+
+	if sub := streamByIndex(probe, req.SubtitleStreamIndex, "subtitle"); sub != nil && !canRenderSubtitle(profile, sub.CodecName) {
+		videoReasons = append(videoReasons, ReasonSubtitleBurnIn)
+		d.BurnSubtitles = true
+	}
+
+	d.Reasons = append(append(append(d.Reasons, containerReasons...), videoReasons...), audioReasons...)
+	switch {
+	case len(videoReasons) > 0:
+		d.Method = MethodTranscode
+		d.Quality = transcodeQuality(video, profile)
+	case len(audioReasons) > 0:
+		d.Method = MethodAudioTranscode
+	case len(containerReasons) > 0:
+		d.Method = MethodDirectStream
+	default:
+		d.Method = MethodDirectPlay
+	}
+	return d
+}
+
+// transcodeQuality is the highest quality within both the source's and the
+// client's size and bitrate limits.
+func transcodeQuality(video *ffmpeg.StreamInfo, profile *DeviceProfile) string {
+	best := "360p"
+	for _, name := range []string{"360p", "480p", "720p", "1080p", "4K"} {
+		q := Qualities[name]
+		if video != nil && video.Height > 0 && q.Height > video.Height+video.Height*15/100 {
+			break
+		}
+		if profile.MaxHeight > 0 && q.Height > profile.MaxHeight {
+			break
+		}
+		if profile.MaxWidth > 0 && q.Width > profile.MaxWidth {
+			break
+		}
+		if profile.MaxBitrate > 0 && QualityBitrate(q) > profile.MaxBitrate {
+			break
+		}
+		best = name
+	}
+	return best
+}
+
+func primaryVideo(probe *ffmpeg.ProbeResult) *ffmpeg.StreamInfo {
+	for i := range probe.Streams {
+		s := &probe.Streams[i]
+		if s.CodecType == "video" && s.Disposition.AttachedPic == 0 {
+			return s
+		}
+	}
+	return nil
+}
+
+// selectedAudio is the requested audio stream, else the first one, which is
+// what the remux and transcode paths map by default.
+func selectedAudio(probe *ffmpeg.ProbeResult, index int) *ffmpeg.StreamInfo {
+	if s := streamByIndex(probe, index, "audio"); s != nil {
+		return s
+	}
+	for i := range probe.Streams {
+		if probe.Streams[i].CodecType == "audio" {
+			return &probe.Streams[i]
+		}
+	}
+	return nil
+}
+
+func streamByIndex(probe *ffmpeg.ProbeResult, index int, codecType string) *ffmpeg.StreamInfo {
+	if index < 0 {
+		return nil
+	}
+	for i := range probe.Streams {
+		if probe.Streams[i].Index == index && probe.Streams[i].CodecType == codecType {
+			return &probe.Streams[i]
+		}
+	}
+	return nil
+}
+
+func canRenderSubtitle(profile *DeviceProfile, codec string) bool {
+	codec = strings.ToLower(codec)
+	if containsFold(profile.SubtitleFormats, codec) {
+		return true
+	}
+	for _, c := range imageSubtitleCodecs {
+		if c == codec {
+			return false
+		}
+	}
+	// Text subtitles are served converted to WebVTT
+	return containsFold(profile.SubtitleFormats, "webvtt") || containsFold(profile.SubtitleFormats, "vtt")
+}
+
+// accepts reports whether v is in list; an empty list accepts anything.
+func accepts(list []string, v string) bool {
+	return len(list) == 0 || containsFold(list, v)
+}
+
+func containsFold(list []string, v string) bool {
+	for _, x := range list {
+		if strings.EqualFold(x, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package stream
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/JustinTDCT/CineVault/internal/ffmpeg"
+)
+
+// testProbe is a 1080p H.264 4.1 / stereo AAC MP4 at 8 Mb/s with an SRT
+// and a PGS subtitle; rows change what they test.
+func testProbe(change func(p *ffmpeg.ProbeResult)) *ffmpeg.ProbeResult {
+	p := &ffmpeg.ProbeResult{
+		Format: ffmpeg.FormatInfo{Filename: "/media/Movie (2020).mp4", Bitrate: "8000000"},
+		Streams: []ffmpeg.StreamInfo{
+			{Index: 0, CodecType: "video", CodecName: "h264", Width: 1920, Height: 1080, Level: 41},
+			{Index: 1, CodecType: "audio", CodecName: "aac", Channels: 2},
+			{Index: 2, CodecType: "audio", CodecName: "dts", Channels: 6},
+			{Index: 3, CodecType: "subtitle", CodecName: "subrip"},
+			{Index: 4, CodecType: "subtitle", CodecName: "hdmv_pgs_subtitle"},
+		},
+	}
+	if change != nil {
+		change(p)
+	}
+	return p
+}
+
+var (
+	browserProfile = DeviceProfile{
+		Name:            "browser",
+		Containers:      []string{"mp4", "webm"},
+		VideoCodecs:     []string{"h264", "vp9"},
+		AudioCodecs:     []string{"aac", "mp3", "opus"},
+		MaxVideoLevels:  map[string]int{"h264": 51},
+		SubtitleFormats: []string{"webvtt"},
+	}
+	tvProfile = DeviceProfile{
+		Name:             "tv",
+		Containers:       []string{"mp4", "mkv"},
+		VideoCodecs:      []string{"h264", "hevc"},
+		AudioCodecs:      []string{"aac", "ac3", "eac3"},
+		MaxWidth:         1920,
+		MaxHeight:        1080,
+		MaxBitrate:       20000000,
+		MaxVideoLevels:   map[string]int{"h264": 41, "hevc": 153},
+		MaxAudioChannels: 6,
+		HDRFormats:       []string{"HDR10"},
+		SubtitleFormats:  []string{"subrip", "hdmv_pgs_subtitle"},
+	}
+)
+
+// withVideo changes the probe's video stream.
+func withVideo(change func(v *ffmpeg.StreamInfo)) *ffmpeg.ProbeResult {
+	return testProbe(func(p *ffmpeg.ProbeResult) { change(&p.Streams[0]) })
+}
+
+// withFile changes the probe's file name.
+func withFile(name string) *ffmpeg.ProbeResult {
+	return testProbe(func(p *ffmpeg.ProbeResult) { p.Format.Filename = name })
+}
+
+func TestDecide(t *testing.T) {
+	noTracks := PlaybackRequest{AudioStreamIndex: -1, SubtitleStreamIndex: -1}
+	dts := PlaybackRequest{AudioStreamIndex: 2, SubtitleStreamIndex: -1}
+	srt := PlaybackRequest{AudioStreamIndex: -1, SubtitleStreamIndex: 3}
+	pgs := PlaybackRequest{AudioStreamIndex: -1, SubtitleStreamIndex: 4}
+	everything := testProbe(func(p *ffmpeg.ProbeResult) {
+		p.Format.Filename = "/media/Movie.avi"
+		p.Streams[0].CodecName = "mpeg4"
+	})
+
+	tests := []struct {
+		name    string
+		probe   *ffmpeg.ProbeResult
+		profile DeviceProfile
+		req     PlaybackRequest
+		method  PlaybackMethod
+		reasons []string
+		quality string
+	}{
+		{"direct play", testProbe(nil), browserProfile, noTracks, MethodDirectPlay, nil, ""},
+		{"empty profile accepts anything", testProbe(nil), DeviceProfile{}, noTracks, MethodDirectPlay, nil, ""},
+		{"text subtitle served as WebVTT", testProbe(nil), browserProfile, srt, MethodDirectPlay, nil, ""},
+		{"image subtitle the client renders", testProbe(nil), tvProfile, pgs, MethodDirectPlay, nil, ""},
+		{"HDR the client shows", withVideo(func(v *ffmpeg.StreamInfo) { v.ColorTransfer, v.ColorPrimaries = "smpte2084", "bt2020" }),
+			tvProfile, noTracks, MethodDirectPlay, nil, ""},
+
+		{"container only: remux", withFile("/media/Movie.mkv"), browserProfile, noTracks,
+			MethodDirectStream, []string{ReasonContainer}, ""},
+
+		{"audio codec", testProbe(nil), browserProfile, dts,
+			MethodAudioTranscode, []string{ReasonAudioCodec}, ""},
+		{"audio channels", testProbe(func(p *ffmpeg.ProbeResult) { p.Streams[1].Channels = 8 }), tvProfile, noTracks,
+			MethodAudioTranscode, []string{ReasonAudioChannels}, ""},
+		{"container and audio codec", withFile("/media/Movie.mkv"), browserProfile, dts,
+			MethodAudioTranscode, []string{ReasonContainer, ReasonAudioCodec}, ""},
+
+		{"video codec", withVideo(func(v *ffmpeg.StreamInfo) { v.CodecName = "hevc" }), browserProfile, noTracks,
+			MethodTranscode, []string{ReasonVideoCodec}, "1080p"},
+		{"video level", withVideo(func(v *ffmpeg.StreamInfo) { v.Level = 51 }), tvProfile, noTracks,
+			MethodTranscode, []string{ReasonVideoLevel}, "1080p"},
+		{"bitrate", testProbe(func(p *ffmpeg.ProbeResult) { p.Format.Bitrate = "40000000" }), tvProfile, noTracks,
+			MethodTranscode, []string{ReasonVideoBitrate}, "1080p"},
+		{"bitrate caps the quality", testProbe(nil), DeviceProfile{MaxBitrate: 3000000}, noTracks,
+			MethodTranscode, []string{ReasonVideoBitrate}, "720p"},
+		{"height", withVideo(func(v *ffmpeg.StreamInfo) { v.Width, v.Height = 3840, 2160 }), tvProfile, noTracks,
+			MethodTranscode, []string{ReasonVideoResolution}, "1080p"},
+		{"HDR the client can't show", withVideo(func(v *ffmpeg.StreamInfo) { v.ColorTransfer, v.ColorPrimaries = "arib-std-b67", "bt2020" }),
+			tvProfile, noTracks, MethodTranscode, []string{ReasonHDR}, "1080p"},
+		{"image subtitle burned in", testProbe(nil), browserProfile, pgs,
+			MethodTranscode, []string{ReasonSubtitleBurnIn}, "1080p"},
+		{"every problem at once", everything, browserProfile, PlaybackRequest{AudioStreamIndex: 2, SubtitleStreamIndex: 4},
+			MethodTranscode, []string{ReasonContainer, ReasonVideoCodec, ReasonSubtitleBurnIn, ReasonAudioCodec}, "1080p"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Decide(tt.probe, &tt.profile, tt.req)
+			if d.Method != tt.method {
+				t.Errorf("method = %s, want %s", d.Method, tt.method)
+			}
+			if !reflect.DeepEqual(d.Reasons, tt.reasons) {
+				t.Errorf("reasons = %v, want %v", d.Reasons, tt.reasons)
+			}
+			if d.Quality != tt.quality {
+				t.Errorf("quality = %q, want %q", d.Quality, tt.quality)
+			}
+			if want := contains(tt.reasons, ReasonHDR); d.ToneMap != want {
+				t.Errorf("tone map = %v, want %v", d.ToneMap, want)
+			}
+			if want := contains(tt.reasons, ReasonSubtitleBurnIn); d.BurnSubtitles != want {
+				t.Errorf("burn subtitles = %v, want %v", d.BurnSubtitles, want)
+			}
+		})
+	}
+}
+
+func contains(list []string, v string) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
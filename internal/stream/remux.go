@@ -51,6 +51,9 @@ type RemuxOptions struct {
 	AudioCodec       string  // Source audio codec for transcode decision
 	AudioChannels    int     // Source audio channel count
 	GainDB           float64 // Audio normalization gain in dB (0 = no gain)
+	TranscodeAudio   bool    // Re-encode audio to stereo AAC even if the source codec is browser-compatible
+	TranscodeVideo   bool    // Re-encode video to H.264 for players that can't decode the source
+	MaxHeight        int     // With TranscodeVideo, scale down to this height (0 = keep)
 }
 
 func ServeRemuxedMPEGTS(ctx context.Context, w http.ResponseWriter, ffmpegPath, filePath, audioCodec string, startSeconds float64, opts ...RemuxOptions) error {
@@ -80,7 +83,14 @@ func ServeRemuxedMPEGTS(ctx context.Context, w http.ResponseWriter, ffmpegPath,
 		args = append(args, "-map", "0:a:0")
 	}
 
-	args = append(args, "-c:v", "copy") // Copy video as-is (no re-encoding!)
+	if len(opts) > 0 && opts[0].TranscodeVideo {
+		if opts[0].MaxHeight > 0 {
+			args = append(args, "-vf", fmt.Sprintf("scale=-2:'min(%d,ih)'", opts[0].MaxHeight))
+		}
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "21", "-pix_fmt", "yuv420p")
+	} else {
+		args = append(args, "-c:v", "copy") // Copy video as-is (no re-encoding!)
+	}
 
 	// Audio: use BuildAudioTranscodeArgs for smart codec/channel handling
 	channels := 2
@@ -88,6 +98,10 @@ func ServeRemuxedMPEGTS(ctx context.Context, w http.ResponseWriter, ffmpegPath,
 	if len(opts) > 0 && opts[0].AudioChannels > 0 {
 		channels = opts[0].AudioChannels
 	}
+	if len(opts) > 0 && opts[0].TranscodeAudio {
+		// Unknown codecs go to AAC; surround would go to AC3 instead
+		audioCodec, channels = "", 2
+	}
 	if len(opts) > 0 {
 		gainDB = opts[0].GainDB
 	}
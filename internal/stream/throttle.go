@@ -0,0 +1,257 @@
+package stream
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// Throttling keeps a transcode from racing to the end of the file: once
+// ffmpeg is throttleAhead segments past the furthest segment the client has
+// asked for it is paused with SIGSTOP, and continued when the client is
+// back within half that distance. Segments the client has played are kept
+// until the disk quota is exceeded, then evicted furthest-behind first.
+
+// DefaultThrottleSegments is how far ahead of the client an encoder may get
+// before it is paused (2 minutes of video).
+const DefaultThrottleSegments = 20
+
+// evictKeepBehind is how many segments behind the playhead are never
+// evicted, so a short rewind doesn't have to re-encode.
+const evictKeepBehind = 10
+
+// SetThrottle sets how many segments ahead of the client an encoder may get
+// (0 disables throttling) and the total size transcode segments may take on
+// disk (0 for no quota).
+func (t *Transcoder) SetThrottle(aheadSegments int, diskQuotaBytes int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.throttleAhead = aheadSegments
+	t.diskQuota = diskQuotaBytes
+}
+
+// noteRequest records a client request for segment n. A request well
+// behind the furthest one is a seek back, which moves the playhead back
+// with it. A paused encoder is woken for a segment it still has to write,
+// or once the client is back within reach; requests for finished segments
+// leave it paused. Caller holds t.mu.
+func (t *Transcoder) noteRequest(session *Session, n int) {
+	if n > session.requested || n < session.requested-maxWaitSegments {
+		session.requested = n
+	}
+	if !session.paused {
+		return
+	}
+	if _, resume := t.throttleAction(session); resume || !session.done[n] {
+		t.resumeRun(session)
+	}
+}
+
+// throttleAction decides whether a session's encoder should be paused,
+// once it is throttleAhead segments past the client, or resumed, once the
+// client is back within half that. Caller holds t.mu.
+func (t *Transcoder) throttleAction(s *Session) (pause, resume bool) {
+	if t.throttleAhead <= 0 || s.exited {
+		return false, s.paused
+	}
+	ahead := s.head() - s.requested
+	return !s.paused && ahead >= t.throttleAhead, s.paused && ahead < t.throttleAhead/2
+}
+
+// refreshDone banks the segments the current run has finished, except ones
+// evicted since. Caller holds t.mu.
+func (s *Session) refreshDone() {
+	for n := range completedSegments(filepath.Join(s.OutputDir, ffmpegPlaylist)) {
+		if !s.evicted[n] {
+			s.done[n] = true
+		}
+	}
+}
+
+// head is the last segment of the current run's contiguous output, or
+// RunStart-1 if it hasn't finished one. Evicted segments were finished too.
+// Caller holds t.mu.
+func (s *Session) head() int {
+	head := s.RunStart - 1
+	for s.done[head+1] || s.evicted[head+1] {
+		head++
+	}
+	return head
+}
+
+func (t *Transcoder) pauseRun(session *Session) {
+	if session.Cmd == nil || session.Cmd.Process == nil || session.exited {
+		return
+	}
+	if err := session.Cmd.Process.Signal(syscall.SIGSTOP); err != nil {
+		log.Printf("Transcode throttle: pause %s: %v", session.ID, err)
+		return
+	}
+	session.paused = true
+	session.pausedAt = time.Now()
+}
+
+func (t *Transcoder) resumeRun(session *Session) {
+	session.paused = false
+	if session.Cmd == nil || session.Cmd.Process == nil || session.exited {
+		return
+	}
+	if err := session.Cmd.Process.Signal(syscall.SIGCONT); err != nil {
+		log.Printf("Transcode throttle: resume %s: %v", session.ID, err)
+	}
+	session.throttledFor += time.Since(session.pausedAt)
+}
+
+// Throttle pauses encoders that are far enough ahead of their client,
+// resumes ones the client is catching up with, and evicts played segments
+// while the disk quota is exceeded.
+func (t *Transcoder) Throttle() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, s := range t.sessions {
+		if s.Live || s.Cmd == nil {
+			continue
+		}
+		if !s.exited {
+			s.refreshDone()
+		}
+		switch pause, resume := t.throttleAction(s); {
+		case pause:
+			t.pauseRun(s)
+		case resume:
+			t.resumeRun(s)
+		}
+	}
+
+	if t.diskQuota > 0 {
+		t.evictSegments()
+	}
+}
+
+// evictSegments deletes finished segments behind their client's playhead,
+// furthest behind first, until transcode output fits the disk quota.
+// Caller holds t.mu.
+func (t *Transcoder) evictSegments() {
+	type candidate struct {
+		session *Session
+		n       int
+		path    string
+		size    int64
+		behind  int
+	}
+	var total int64
+	var candidates []candidate
+	for _, s := range t.sessions {
+		if s.Live {
+			continue
+		}
+		s.diskBytes = 0
+		entries, _ := os.ReadDir(s.OutputDir)
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			s.diskBytes += info.Size()
+			n, ok := ParseSegmentName(e.Name())
+			if ok && s.done[n] && n < s.requested-evictKeepBehind {
+				candidates = append(candidates, candidate{s, n, filepath.Join(s.OutputDir, e.Name()), info.Size(), s.requested - n})
+			}
+		}
+		total += s.diskBytes
+	}
+	if total <= t.diskQuota {
+		return
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].behind > candidates[j].behind })
+	evicted := 0
+	for _, c := range candidates {
+		if total <= t.diskQuota {
+			break
+		}
+		if err := os.Remove(c.path); err != nil {
+			continue
+		}
+		delete(c.session.done, c.n)
+		c.session.evicted[c.n] = true
+		c.session.diskBytes -= c.size
+		total -= c.size
+		evicted++
+	}
+	if evicted > 0 {
+		log.Printf("Transcode cache: evicted %d played segments, %d MB in use", evicted, total>>20)
+	}
+}
+
+// SessionStats describes a running transcode session.
+type SessionStats struct {
+	ID             string    `json:"id"`
+	MediaItemID    string    `json:"media_item_id"`
+	UserID         string    `json:"user_id"`
+	Quality        string    `json:"quality"`
+	Live           bool      `json:"live"`
+	Running        bool      `json:"running"`
+	Throttled      bool      `json:"throttled"`
+	ThrottledSecs  float64   `json:"throttled_seconds"` // total time paused
+	RunStart       int       `json:"run_start_segment"`
+	EncodedSegment int       `json:"encoded_segment"`   // last segment of the current run
+	RequestedSeg   int       `json:"requested_segment"` // furthest segment the client asked for
+	CachedSegments int       `json:"cached_segments"`
+	DiskBytes      int64     `json:"disk_bytes"`
+	StartedAt      time.Time `json:"started_at"`
+	LastAccess     time.Time `json:"last_access"`
+}
+
+// Stats reports every session with its throttle state.
+func (t *Transcoder) Stats() []SessionStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := make([]SessionStats, 0, len(t.sessions))
+	for _, s := range t.sessions {
+		throttled := s.throttledFor
+		if s.paused {
+			throttled += time.Since(s.pausedAt)
+		}
+		stats = append(stats, SessionStats{
+			ID:             s.ID,
+			MediaItemID:    s.MediaItemID,
+			UserID:         s.UserID,
+			Quality:        s.Quality,
+			Live:           s.Live,
+			Running:        s.Cmd != nil && !s.exited,
+			Throttled:      s.paused,
+			ThrottledSecs:  throttled.Seconds(),
+			RunStart:       s.RunStart,
+			EncodedSegment: s.head(),
+			RequestedSeg:   s.requested,
+			CachedSegments: len(s.done),
+			DiskBytes:      s.diskBytes,
+			StartedAt:      s.StartedAt,
+			LastAccess:     s.LastAccess,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].StartedAt.Before(stats[j].StartedAt) })
+	return stats
+}
+
+// RunThrottleLoop checks throttling and the disk quota every interval until
+// done is closed.
+func (t *Transcoder) RunThrottleLoop(done <-chan struct{}, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				t.Throttle()
+			}
+		}
+	}()
+}
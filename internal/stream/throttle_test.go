@@ -0,0 +1,136 @@
+package stream
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// throttleSession has segments from runStart to head finished and the
+// client at requested.
+func throttleSession(runStart, head, requested int, paused bool) *Session {
+	s := &Session{RunStart: runStart, requested: requested, paused: paused,
+		done: make(map[int]bool), evicted: make(map[int]bool)}
+	for n := runStart; n <= head; n++ {
+		s.done[n] = true
+	}
+	return s
+}
+
+func TestThrottleAction(t *testing.T) {
+	tests := []struct {
+		name          string
+		ahead         int // throttle distance
+		head          int
+		requested     int
+		paused        bool
+		exited        bool
+		pause, resume bool
+	}{
+		{"running, within reach", 20, 25, 10, false, false, false, false},
+		{"running, reaches the limit", 20, 30, 10, false, false, true, false},
+		{"paused, client still far behind", 20, 30, 15, true, false, false, false},
+		{"paused, client just outside half", 20, 30, 20, true, false, false, false},
+		{"paused, client back within half", 20, 30, 21, true, false, false, true},
+		{"paused, throttling switched off", 0, 30, 10, true, false, false, true},
+		{"running, throttling switched off", 0, 30, 10, false, false, false, false},
+		{"paused after the run exited", 20, 30, 10, true, true, false, true},
+		{"nothing finished yet", 20, -1, 0, false, false, false, false},
+	}
+	for _, tt := range tests {
+		tr := &Transcoder{throttleAhead: tt.ahead}
+		s := throttleSession(0, tt.head, tt.requested, tt.paused)
+		s.exited = tt.exited
+		if pause, resume := tr.throttleAction(s); pause != tt.pause || resume != tt.resume {
+			t.Errorf("%s: pause %v resume %v, want %v %v", tt.name, pause, resume, tt.pause, tt.resume)
+		}
+	}
+}
+
+func TestNoteRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested int // furthest segment asked for so far
+		n         int
+		paused    bool // still paused afterwards
+		playhead  int
+	}{
+		// Encoder paused 20 ahead: segments 0-30 done, client at 10
+		{"next segment, already done", 10, 11, true, 11},
+		{"rewatching a done segment", 10, 8, true, 10},
+		{"seek back past the wait window", 10, 2, true, 2},
+		{"client back within half the distance", 10, 21, false, 21},
+		{"segment the encoder hasn't written", 10, 31, false, 31},
+	}
+	for _, tt := range tests {
+		tr := &Transcoder{throttleAhead: 20}
+		s := throttleSession(0, 30, tt.requested, true)
+		tr.noteRequest(s, tt.n)
+		if s.paused != tt.paused || s.requested != tt.playhead {
+			t.Errorf("%s: paused %v at %d, want %v at %d", tt.name, s.paused, s.requested, tt.paused, tt.playhead)
+		}
+	}
+}
+
+func TestEvictSegments(t *testing.T) {
+	const size = 100
+	// Each session has segments 0-39 on disk and the client at requested
+	type session struct {
+		requested int
+		live      bool
+	}
+	tests := []struct {
+		name     string
+		sessions []session
+		quota    int64
+		evicted  [][]int // per session
+	}{
+		{"under quota", []session{{35, false}}, 40 * size, [][]int{nil}},
+		{"nothing far enough behind", []session{{10, false}}, 10 * size, [][]int{nil}},
+		{"furthest behind first", []session{{20, false}}, 37 * size, [][]int{{0, 1, 2}}},
+		{"never within keep-behind of the playhead", []session{{20, false}}, 1, [][]int{{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}}},
+		{"across sessions by distance", []session{{15, false}, {18, false}}, 76 * size, [][]int{{0}, {0, 1, 2}}},
+		{"live sessions untouched", []session{{30, true}, {12, false}}, 1, [][]int{nil, {0, 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &Transcoder{sessions: make(map[string]*Session), diskQuota: tt.quota}
+			var sessions []*Session
+			for i, ss := range tt.sessions {
+				s := throttleSession(0, 39, ss.requested, false)
+				s.Live = ss.live
+				s.OutputDir = t.TempDir()
+				for n := 0; n < 40; n++ {
+					if err := os.WriteFile(filepath.Join(s.OutputDir, SegmentName(n, "ts")), make([]byte, size), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				tr.sessions[string(rune('a'+i))] = s
+				sessions = append(sessions, s)
+			}
+
+			tr.evictSegments()
+
+			for i, s := range sessions {
+				var evicted []int
+				for n := 0; n < 40; n++ {
+					_, err := os.Stat(filepath.Join(s.OutputDir, SegmentName(n, "ts")))
+					if os.IsNotExist(err) {
+						evicted = append(evicted, n)
+						if s.done[n] || !s.evicted[n] {
+							t.Errorf("session %d: segment %d deleted but not marked evicted", i, n)
+						}
+					}
+				}
+				if !reflect.DeepEqual(evicted, tt.evicted[i]) {
+					t.Errorf("session %d: evicted %v, want %v", i, evicted, tt.evicted[i])
+				}
+				// The session still counts evicted segments as encoded
+				if head := s.head(); head != 39 {
+					t.Errorf("session %d: head %d after eviction, want 39", i, head)
+				}
+			}
+		})
+	}
+}
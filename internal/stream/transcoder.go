@@ -1,6 +1,10 @@
 package stream
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -29,11 +33,22 @@ var Qualities = map[string]Quality{
 	"4K":    {Name: "4K", Width: 3840, Height: 2160, VideoBitrate: "14000k", AudioBitrate: "192k"},
 }
 
+// ffmpegPlaylist is the playlist ffmpeg maintains in each session directory.
+const ffmpegPlaylist = "ffmpeg.m3u8"
+
+// ErrTranscodeBusy is returned when the server-wide transcode budget is
+// used up.
+var ErrTranscodeBusy = errors.New("server transcode limit reached")
+
 type Transcoder struct {
 	ffmpegPath    string
 	outputBase    string
 	mu            sync.Mutex
 	sessions      map[string]*Session
+	maxTranscodes int   // concurrent VOD ffmpeg runs; 0 = unlimited
+	throttleAhead int   // segments ffmpeg may run ahead of the client; 0 = no throttling
+	diskQuota     int64 // bytes of segments kept on disk; 0 = no quota
+	liveMu        sync.Mutex
 	hwMu          sync.Mutex
 	cachedH264Enc string
 	cachedHEVCEnc string
@@ -52,6 +67,37 @@ type Session struct {
 	StartedAt     time.Time
 	LastAccess    time.Time
 	ErrorLog      string
+	SegmentExt    string // "ts", or "mp4" for fmp4 (HEVC)
+	RunStart      int    // segment the current ffmpeg run started at
+
+	filePath string
+	quality  Quality
+	opts     TranscodeOptions
+	done     map[int]bool // segments fully written, across runs
+	run      int          // bumped on every restart
+	exited   bool
+	failed   bool
+
+	// Throttling and eviction (see throttle.go)
+	requested    int  // furthest segment the client has asked for
+	paused       bool // ffmpeg is stopped with SIGSTOP
+	pausedAt     time.Time
+	throttledFor time.Duration
+	evicted      map[int]bool // finished segments deleted under the disk quota
+	diskBytes    int64
+
+	// Live channel sessions (see StartLive)
+	Live           bool
+	WindowSegments int
+	onStop         func()
+	stopOnce       sync.Once
+}
+
+// release runs the session's OnStop hook exactly once.
+func (s *Session) release() {
+	if s.onStop != nil {
+		s.stopOnce.Do(s.onStop)
+	}
 }
 
 // TranscodeOptions holds optional parameters for transcoding.
@@ -63,9 +109,57 @@ type TranscodeOptions struct {
 	SubtitleFormat   string  // Subtitle codec name for burn-in filter selection
 	BurnSubtitles    bool    // Whether to burn in subtitles
 	HDRToSDR         bool    // Whether to convert HDR to SDR
-	StartSeconds     float64 // Seek position (0 for beginning)
+	StartSeconds     float64 // Seek position (0 for beginning); rounded down to a segment boundary
 	Codec            string  // Output codec: "h264" (default), "hevc"
 	GainDB           float64 // Audio normalization gain in dB (0 = no gain)
+	DeviceID         string  // Viewer's device; only used to key the session
+	Packaging        string  // "" for muxed HLS segments, PackagingCMAF for separate fMP4 tracks
+}
+
+// SessionKey identifies the transcode session for a viewer's request.
+// Sessions are per user and device, and two requests only share one when
+// ffmpeg would produce the same output for both. StartSeconds is left out
+// since seeking restarts within a session on the same segment timeline;
+// AudioCodec, AudioChannels, SubtitleFormat and GainDB follow from the
+// media item and the stream indexes.
+func SessionKey(mediaItemID, userID, quality string, opt TranscodeOptions) string {
+	quality, _ = resolveQuality(quality, opt)
+	audio := opt.AudioStreamIndex
+	subtitle := -1
+	if opt.BurnSubtitles && opt.SubtitleIndex >= 0 {
+		subtitle = opt.SubtitleIndex
+	}
+	codec := opt.Codec
+	if codec != "hevc" {
+		codec = "h264"
+	}
+	packaging := "ts"
+	if opt.Packaging == PackagingCMAF {
+		packaging = PackagingCMAF
+	}
+	switch {
+	case quality == AudioRendition:
+		// Audio sessions have no video
+		subtitle, codec, opt.HDRToSDR = -1, "", false
+	case opt.Packaging == PackagingCMAF:
+		// CMAF video sessions have no audio
+		audio = -1
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|p=%s|a=%d|s=%d|hdr=%t|c=%s",
+		userID, opt.DeviceID, packaging, audio, subtitle, opt.HDRToSDR, codec)))
+	return fmt.Sprintf("%s-%s-%s", mediaItemID, quality, hex.EncodeToString(sum[:6]))
+}
+
+// resolveQuality maps a requested quality to a known one; unknown names
+// fall back to 720p. Audio-only sessions have no video quality.
+func resolveQuality(quality string, opt TranscodeOptions) (string, Quality) {
+	if quality == AudioRendition {
+		return AudioRendition, Quality{Name: AudioRendition}
+	}
+	if q, ok := Qualities[quality]; ok {
+		return quality, q
+	}
+	return "720p", Qualities["720p"]
 }
 
 func NewTranscoder(ffmpegPath, outputBase string) *Transcoder {
@@ -73,6 +167,8 @@ func NewTranscoder(ffmpegPath, outputBase string) *Transcoder {
 		ffmpegPath: ffmpegPath,
 		outputBase: outputBase,
 		sessions:   make(map[string]*Session),
+
+		throttleAhead: DefaultThrottleSegments,
 	}
 }
 
@@ -106,14 +202,13 @@ func (t *Transcoder) DetectHWAccel() string {
 }
 
 func (t *Transcoder) StartTranscode(mediaItemID, userID, filePath, quality string, opts ...TranscodeOptions) (*Session, error) {
-	q, ok := Qualities[quality]
-	if !ok {
-		q = Qualities["720p"]
-		quality = "720p"
+	// Resolve options
+	var opt TranscodeOptions
+	if len(opts) > 0 {
+		opt = opts[0]
 	}
-
-	// Use composite key so we can find existing sessions
-	sessionKey := fmt.Sprintf("%s-%s", mediaItemID, quality)
+	quality, q := resolveQuality(quality, opt)
+	sessionKey := SessionKey(mediaItemID, userID, quality, opt)
 
 	// Return existing session if already running
 	t.mu.Lock()
@@ -130,22 +225,133 @@ func (t *Transcoder) StartTranscode(mediaItemID, userID, filePath, quality strin
 		return nil, fmt.Errorf("create output dir: %w", err)
 	}
 
-	// Resolve options
-	var opt TranscodeOptions
-	if len(opts) > 0 {
-		opt = opts[0]
+	session := &Session{
+		ID:          sessionKey,
+		MediaItemID: mediaItemID,
+		UserID:      userID,
+		Quality:     quality,
+		OutputDir:   outputDir,
+		StartedAt:   time.Now(),
+		LastAccess:  time.Now(),
+		SegmentExt:  "ts",
+		filePath:    filePath,
+		quality:     q,
+		opts:        opt,
+		done:        make(map[int]bool),
+		evicted:     make(map[int]bool),
+		requested:   int(opt.StartSeconds / SegmentDuration),
+	}
+	// HLS output — use fmp4 for HEVC (required by spec), mpegts for H.264
+	switch {
+	case opt.Packaging == PackagingCMAF:
+		session.SegmentExt = cmafSegmentExt
+	case opt.Codec == "hevc" && quality != AudioRendition:
+		session.SegmentExt = "mp4"
 	}
 
-	// Determine encoder
-	encoder := t.DetectHWAccel()
-	if opt.Codec == "hevc" {
-		encoder = t.detectHEVCEncoder()
+	t.mu.Lock()
+	if existing, ok := t.sessions[sessionKey]; ok {
+		// Another request for the same output got there first
+		existing.LastAccess = time.Now()
+		t.mu.Unlock()
+		os.RemoveAll(outputDir)
+		return existing, nil
+	}
+	if t.maxTranscodes > 0 && t.runningTranscodes() >= t.maxTranscodes {
+		t.mu.Unlock()
+		os.RemoveAll(outputDir)
+		return nil, ErrTranscodeBusy
+	}
+	err := t.startRun(session, int(opt.StartSeconds/SegmentDuration))
+	if err == nil {
+		t.sessions[sessionKey] = session
+	}
+	t.mu.Unlock()
+	if err != nil {
+		os.RemoveAll(outputDir)
+		return nil, err
 	}
+	return session, nil
+}
+
+// QueueTranscode is StartTranscode that waits up to timeout for a slot in
+// the transcode budget instead of failing straight away.
+func (t *Transcoder) QueueTranscode(ctx context.Context, timeout time.Duration, mediaItemID, userID, filePath, quality string, opts ...TranscodeOptions) (*Session, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		session, err := t.StartTranscode(mediaItemID, userID, filePath, quality, opts...)
+		if !errors.Is(err, ErrTranscodeBusy) || time.Now().After(deadline) {
+			return session, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// SetMaxTranscodes caps how many VOD transcodes run ffmpeg at once; 0
+// removes the cap. Live TV sessions aren't counted.
+func (t *Transcoder) SetMaxTranscodes(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.maxTranscodes = n
+}
+
+// runningTranscodes counts VOD sessions whose ffmpeg is running. Caller
+// holds t.mu.
+func (t *Transcoder) runningTranscodes() int {
+	n := 0
+	for _, s := range t.sessions {
+		if !s.Live && s.Cmd != nil && !s.exited {
+			n++
+		}
+	}
+	return n
+}
+
+// UserPlaybacks returns the media items a user is watching through VOD
+// transcode sessions, keyed "mediaItemID|deviceID". Sessions of one
+// playback (qualities, CMAF audio) count once.
+func (t *Transcoder) UserPlaybacks(userID string) map[string]bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	playbacks := make(map[string]bool)
+	for _, s := range t.sessions {
+		if !s.Live && s.UserID == userID {
+			playbacks[s.MediaItemID+"|"+s.opts.DeviceID] = true
+		}
+	}
+	return playbacks
+}
 
-	// Build hwaccel input args for hardware decode
-	hwAccelArgs := t.buildHWAccelInputArgs(encoder)
+// startRun starts ffmpeg at segment startSegment. Segment boundaries are
+// forced to multiples of SegmentDuration and output timestamps keep the
+// source timeline, so segments from different runs line up. Caller holds
+// t.mu.
+func (t *Transcoder) startRun(session *Session, startSegment int) error {
+	opt, q, filePath, outputDir := session.opts, session.quality, session.filePath, session.OutputDir
+	startSeconds := float64(startSegment) * SegmentDuration
+	// Audio renditions and CMAF video sessions carry a single track
+	audioOnly := session.Quality == AudioRendition
+	videoOnly := opt.Packaging == PackagingCMAF && !audioOnly
+
+	// Determine encoder
+	encoder := "none"
+	var hwAccelArgs []string
+	if !audioOnly {
+		encoder = t.DetectHWAccel()
+		if opt.Codec == "hevc" {
+			encoder = t.detectHEVCEncoder()
+		}
+		// Build hwaccel input args for hardware decode
+		hwAccelArgs = t.buildHWAccelInputArgs(encoder)
+	}
 
-	playlistPath := filepath.Join(outputDir, "stream.m3u8")
+	// ffmpeg's own playlist only tells us which segments are complete;
+	// clients get the full VOD playlist from VODPlaylist
+	playlistPath := filepath.Join(outputDir, ffmpegPlaylist)
 
 	args := []string{"-nostdin"}
 
@@ -153,22 +359,121 @@ func (t *Transcoder) StartTranscode(mediaItemID, userID, filePath, quality strin
 	args = append(args, hwAccelArgs...)
 
 	// Seek support (before -i for fast keyframe seek)
-	if opt.StartSeconds > 0 {
-		args = append(args, "-ss", fmt.Sprintf("%.3f", opt.StartSeconds))
+	if startSeconds > 0 {
+		args = append(args, "-ss", fmt.Sprintf("%.3f", startSeconds))
 	}
 
 	args = append(args, "-i", filePath)
 
 	// Video stream mapping
-	args = append(args, "-map", "0:v:0")
+	if !audioOnly {
+		args = append(args, "-map", "0:v:0")
+	}
 
 	// Audio stream mapping
-	if opt.AudioStreamIndex >= 0 {
+	switch {
+	case videoOnly:
+	case opt.AudioStreamIndex >= 0:
 		args = append(args, SelectAudioStream(opt.AudioStreamIndex)...)
-	} else {
+	default:
 		args = append(args, "-map", "0:a:0")
 	}
 
+	if audioOnly {
+		args = append(args, "-vn")
+	} else {
+		args = t.videoArgs(args, encoder, opt, q, filePath)
+	}
+
+	// Audio transcoding with smart codec/channel handling
+	if videoOnly {
+		args = append(args, "-an")
+	} else {
+		channels := 2
+		audioCodec := "unknown"
+		if opt.AudioChannels > 0 {
+			channels = opt.AudioChannels
+		}
+		if opt.AudioCodec != "" {
+			audioCodec = opt.AudioCodec
+		}
+		args = append(args, BuildAudioTranscodeArgs(audioCodec, channels, opt.GainDB)...)
+	}
+
+	if startSeconds > 0 {
+		args = append(args, "-output_ts_offset", fmt.Sprintf("%.3f", startSeconds))
+	}
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", fmt.Sprintf("%g", SegmentDuration),
+		"-hls_list_size", "0",
+		"-start_number", fmt.Sprintf("%d", startSegment),
+		"-hls_segment_filename", filepath.Join(outputDir, fmt.Sprintf("segment_%%05d.%s", session.SegmentExt)),
+		"-hls_flags", "independent_segments",
+	)
+	if session.SegmentExt != "ts" {
+		args = append(args, "-hls_segment_type", "fmp4", "-hls_fmp4_init_filename", "init.mp4")
+	}
+	args = append(args, "-y", playlistPath)
+
+	cmd := exec.Command(t.ffmpegPath, args...)
+
+	// Capture stderr for error logging
+	stderrBuf := &strings.Builder{}
+	cmd.Stderr = stderrBuf
+
+	// A fresh run rewrites ffmpeg's playlist, so bank what the last one
+	// finished; anything it produces from here on is on disk again
+	session.refreshDone()
+	os.Remove(playlistPath)
+	for n := range session.evicted {
+		if n >= startSegment {
+			delete(session.evicted, n)
+		}
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start ffmpeg: %w", err)
+	}
+
+	session.run++
+	run := session.run
+	session.Cmd = cmd
+	session.RunStart = startSegment
+	session.exited = false
+	session.failed = false
+
+	log.Printf("Transcode session started: %s (%s, encoder=%s, segment %d)", session.ID, session.Quality, encoder, startSegment)
+
+	// Wait for FFmpeg in background, capture errors and track progress
+	go func() {
+		err := cmd.Wait()
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if session.run != run {
+			// Killed for a restart; the new run owns the session now
+			return
+		}
+		session.exited = true
+		if err != nil {
+			errStr := stderrBuf.String()
+			if len(errStr) > 1000 {
+				errStr = errStr[len(errStr)-1000:]
+			}
+			log.Printf("FFmpeg transcode ended: %v | stderr: %s", err, errStr)
+			session.ErrorLog = errStr
+			session.failed = true
+		}
+		// Count segments produced
+		session.refreshDone()
+		session.SegmentsReady = len(session.done)
+	}()
+
+	return nil
+}
+
+// videoArgs appends the video filter chain and encoder settings of a run.
+func (t *Transcoder) videoArgs(args []string, encoder string, opt TranscodeOptions, q Quality, filePath string) []string {
 	// Build video filter chain
 	var videoFilters []string
 
@@ -208,96 +513,85 @@ func (t *Transcoder) StartTranscode(mediaItemID, userID, filePath, quality strin
 		"-c:v", encoder,
 		"-vf", strings.Join(videoFilters, ","),
 		"-b:v", q.VideoBitrate,
+		// Keyframe on every segment boundary so segments are exactly
+		// SegmentDuration long whichever segment this run starts at
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%g)", SegmentDuration),
 	)
 
-	// Audio transcoding with smart codec/channel handling
-	channels := 2
-	audioCodec := "unknown"
-	if opt.AudioChannels > 0 {
-		channels = opt.AudioChannels
-	}
-	if opt.AudioCodec != "" {
-		audioCodec = opt.AudioCodec
-	}
-	args = append(args, BuildAudioTranscodeArgs(audioCodec, channels, opt.GainDB)...)
-
-	// HLS output — use fmp4 for HEVC (required by spec), mpegts for H.264
-	segExt := "ts"
-	hlsSegType := ""
-	if opt.Codec == "hevc" {
-		segExt = "mp4"
-		hlsSegType = "-hls_segment_type fmp4"
-	}
-
-	args = append(args,
-		"-f", "hls",
-		"-hls_time", "6",
-		"-hls_list_size", "0",
-		"-hls_segment_filename", filepath.Join(outputDir, fmt.Sprintf("segment_%%05d.%s", segExt)),
-		"-hls_flags", "independent_segments",
-	)
-	if hlsSegType != "" {
-		args = append(args, "-hls_segment_type", "fmp4")
-	}
-	args = append(args, "-y", playlistPath)
-
-	cmd := exec.Command(t.ffmpegPath, args...)
-
-	// Capture stderr for error logging
-	stderrBuf := &strings.Builder{}
-	cmd.Stderr = stderrBuf
-
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("start ffmpeg: %w", err)
-	}
-
-	session := &Session{
-		ID:          sessionKey,
-		MediaItemID: mediaItemID,
-		UserID:      userID,
-		Quality:     quality,
-		OutputDir:   outputDir,
-		Cmd:         cmd,
-		StartedAt:   time.Now(),
-		LastAccess:  time.Now(),
-	}
-
-	t.mu.Lock()
-	t.sessions[sessionKey] = session
-	t.mu.Unlock()
+	return args
+}
 
-	log.Printf("Transcode session started: %s (%s, encoder=%s)", sessionKey, quality, encoder)
+// WaitSegment returns the path of segment n of a session once it is fully
+// written. If the running encoder won't reach n soon (a seek) it is
+// restarted at n, so scrubbing anywhere in the VOD playlist plays at once.
+func (t *Transcoder) WaitSegment(ctx context.Context, sessionID string, n int, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		t.mu.Lock()
+		session := t.sessions[sessionID]
+		if session == nil {
+			t.mu.Unlock()
+			return "", fmt.Errorf("no transcode session %s", sessionID)
+		}
+		session.LastAccess = time.Now()
+		if !session.exited {
+			session.refreshDone()
+		}
+		t.noteRequest(session, n)
+		path := filepath.Join(session.OutputDir, SegmentName(n, session.SegmentExt))
+		if session.done[n] {
+			t.mu.Unlock()
+			return path, nil
+		}
 
-	// Wait for FFmpeg in background, capture errors and track progress
-	go func() {
-		if err := cmd.Wait(); err != nil {
-			errStr := stderrBuf.String()
-			if len(errStr) > 1000 {
-				errStr = errStr[len(errStr)-1000:]
+		head := session.head()
+		if planSegment(n, session.RunStart, head, !session.exited) == segmentRestart {
+			if session.exited && session.failed && session.RunStart == n {
+				errLog := session.ErrorLog
+				t.mu.Unlock()
+				return "", fmt.Errorf("transcode failed at segment %d: %s", n, errLog)
 			}
-			log.Printf("FFmpeg transcode ended: %v | stderr: %s", err, errStr)
-			t.mu.Lock()
-			if s, ok := t.sessions[sessionKey]; ok {
-				s.ErrorLog = errStr
+			if session.exited && !session.failed && n >= session.RunStart {
+				// Finished cleanly without producing it: past the end
+				t.mu.Unlock()
+				return "", os.ErrNotExist
 			}
-			t.mu.Unlock()
-		}
-		// Count segments produced
-		t.mu.Lock()
-		if s, ok := t.sessions[sessionKey]; ok {
-			entries, _ := os.ReadDir(s.OutputDir)
-			count := 0
-			for _, e := range entries {
-				if strings.HasSuffix(e.Name(), ".ts") || strings.HasSuffix(e.Name(), ".mp4") {
-					count++
-				}
+			t.killRun(session)
+			if err := t.startRun(session, n); err != nil {
+				t.mu.Unlock()
+				return "", err
 			}
-			s.SegmentsReady = count
 		}
 		t.mu.Unlock()
-	}()
 
-	return session, nil
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("segment %d not ready", n)
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// killRun stops the session's current ffmpeg run and drops the segment it
+// was in the middle of writing. Caller holds t.mu.
+func (t *Transcoder) killRun(session *Session) {
+	if session.Cmd != nil && session.Cmd.Process != nil && !session.exited {
+		session.Cmd.Process.Kill()
+	}
+	if session.paused {
+		session.paused = false
+		session.throttledFor += time.Since(session.pausedAt)
+	}
+	session.refreshDone()
+	entries, _ := os.ReadDir(session.OutputDir)
+	for _, e := range entries {
+		if n, ok := ParseSegmentName(e.Name()); ok && !session.done[n] {
+			os.Remove(filepath.Join(session.OutputDir, e.Name()))
+		}
+	}
 }
 
 // detectHEVCEncoder probes for HEVC hardware encoders, falls back to libx265.
@@ -410,25 +704,12 @@ func (t *Transcoder) StopSession(sessionID string) {
 		s.Cmd.Process.Kill()
 		s.Cmd.Wait()
 	}
+	s.release()
 	if err := os.RemoveAll(s.OutputDir); err != nil {
 		log.Printf("Transcode cleanup: failed to remove %s: %v", s.OutputDir, err)
 	}
 }
 
-func (t *Transcoder) GenerateMasterPlaylist(mediaItemID, filePath string, availableQualities []string) string {
-	var sb strings.Builder
-	sb.WriteString("#EXTM3U\n")
-
-	for _, qName := range availableQualities {
-		q := Qualities[qName]
-		sb.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%s,RESOLUTION=%dx%d,NAME=\"%s\"\n",
-			strings.TrimSuffix(q.VideoBitrate, "k")+"000", q.Width, q.Height, q.Name))
-		sb.WriteString(fmt.Sprintf("/api/v1/stream/%s/%s/stream.m3u8\n", mediaItemID, qName))
-	}
-
-	return sb.String()
-}
-
 // ActiveSessionCount returns the number of active transcode sessions.
 func (t *Transcoder) ActiveSessionCount() int {
 	t.mu.Lock()
@@ -460,6 +741,7 @@ func (t *Transcoder) CleanupExpired(maxAge time.Duration) {
 			s.Cmd.Process.Kill()
 			s.Cmd.Wait()
 		}
+		s.release()
 		os.RemoveAll(s.OutputDir)
 		log.Printf("Cleaned up expired session: %s", expiredIDs[i])
 	}
@@ -0,0 +1,127 @@
+package stream
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MPEGTSTimestampOffset is where ffmpeg's MPEG-TS muxer starts output
+// timestamps (1.4s at 90kHz). WebVTT segments for TS sessions map their
+// cue times onto it; fMP4 timelines start at 0.
+const MPEGTSTimestampOffset = 126000
+
+// IsTextSubtitle reports whether a subtitle format can be converted to
+// WebVTT; image formats (PGS, VobSub, DVB) can only be burned in.
+func IsTextSubtitle(format string) bool {
+	format = strings.ToLower(format)
+	if format == "vobsub" {
+		return false
+	}
+	for _, c := range imageSubtitleCodecs {
+		if c == format {
+			return false
+		}
+	}
+	return true
+}
+
+// SubtitlePlaylist is a WebVTT subtitle media playlist split on the same
+// SegmentDuration boundaries as the video; segmentURI names segment n.
+func SubtitlePlaylist(durationSeconds float64, segmentURI func(n int) string) string {
+	var sb strings.Builder
+	sb.WriteString("#EXTM3U\n")
+	sb.WriteString("#EXT-X-VERSION:3\n")
+	sb.WriteString("#EXT-X-PLAYLIST-TYPE:VOD\n")
+	sb.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(SegmentDuration))))
+	sb.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n")
+	count := SegmentCount(durationSeconds)
+	for n := 0; n < count; n++ {
+		length := SegmentDuration
+		if n == count-1 {
+			length = durationSeconds - float64(n)*SegmentDuration
+		}
+		sb.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%s\n", length, segmentURI(n)))
+	}
+	sb.WriteString("#EXT-X-ENDLIST\n")
+	return sb.String()
+}
+
+// SegmentWebVTT cuts segment n out of a whole WebVTT file: the header's
+// STYLE and REGION blocks and every cue overlapping the segment, with an
+// X-TIMESTAMP-MAP pinning cue time 0 to mpegtsOffset. Cues spanning a
+// boundary appear in both segments; players drop the duplicate.
+func SegmentWebVTT(vtt string, n int, mpegtsOffset int64) string {
+	start := float64(n) * SegmentDuration
+	end := start + SegmentDuration
+
+	var sb strings.Builder
+	sb.WriteString("WEBVTT\n")
+	sb.WriteString(fmt.Sprintf("X-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:00:00:00.000\n", mpegtsOffset))
+
+	vtt = strings.ReplaceAll(strings.TrimPrefix(vtt, "\ufeff"), "\r\n", "\n")
+	blocks := strings.Split(vtt, "\n\n")
+	for i, block := range blocks {
+		block = strings.Trim(block, "\n")
+		switch {
+		case block == "":
+			continue
+		case i == 0 && strings.HasPrefix(block, "WEBVTT"):
+			// File header, replaced above
+			continue
+		case strings.HasPrefix(block, "STYLE") || strings.HasPrefix(block, "REGION"):
+			sb.WriteString("\n" + block + "\n")
+			continue
+		case strings.HasPrefix(block, "NOTE"):
+			continue
+		}
+		cueStart, cueEnd, ok := cueTiming(block)
+		if !ok || cueEnd <= start || cueStart >= end {
+			continue
+		}
+		sb.WriteString("\n" + block + "\n")
+	}
+	return sb.String()
+}
+
+// cueTiming reads the start and end of a cue block from its timing line.
+func cueTiming(block string) (start, end float64, ok bool) {
+	for _, line := range strings.Split(block, "\n") {
+		from, rest, found := strings.Cut(line, "-->")
+		if !found {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return 0, 0, false
+		}
+		start, okStart := parseVTTTime(strings.TrimSpace(from))
+		end, okEnd := parseVTTTime(fields[0])
+		return start, end, okStart && okEnd
+	}
+	return 0, 0, false
+}
+
+// parseVTTTime parses hh:mm:ss.ttt or mm:ss.ttt.
+func parseVTTTime(s string) (float64, bool) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+	secs, err := strconv.ParseFloat(strings.Replace(parts[len(parts)-1], ",", ".", 1), 64)
+	if err != nil {
+		return 0, false
+	}
+	total := secs
+	mult := 60.0
+	for i := len(parts) - 2; i >= 0; i-- {
+		v, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return 0, false
+		}
+		total += float64(v) * mult
+		mult *= 60
+	}
+	return total, true
+}
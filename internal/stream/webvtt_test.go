@@ -0,0 +1,37 @@
+package stream
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSubtitlePlaylist(t *testing.T) {
+	pl := SubtitlePlaylist(15.5, func(n int) string {
+		return fmt.Sprintf("segment_%d.vtt?container=ts", n)
+	})
+	golden(t, "subtitles.m3u8", pl)
+}
+
+func TestSegmentWebVTT(t *testing.T) {
+	source, err := os.ReadFile(filepath.Join("testdata", "source.vtt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		n      int
+		offset int64
+	}{
+		{0, MPEGTSTimestampOffset},
+		{1, MPEGTSTimestampOffset},
+		{2, 0},
+		{10, 0}, // past the last cue: header only
+	}
+	for _, tt := range tests {
+		name := fmt.Sprintf("segment_%d.vtt", tt.n)
+		t.Run(name, func(t *testing.T) {
+			golden(t, name, SegmentWebVTT(string(source), tt.n, tt.offset))
+		})
+	}
+}
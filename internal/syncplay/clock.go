@@ -0,0 +1,60 @@
+package syncplay
+
+import (
+	"time"
+)
+
+const clockSamples = 8
+
+// clockEstimate tracks one client's clock offset from ping/pong round
+// trips, NTP style: each sample assumes the pong was stamped halfway
+// through the round trip, and the sample with the smallest RTT wins since
+// it has the least room for asymmetric delay.
+type clockEstimate struct {
+	samples []clockSample
+	best    clockSample
+	valid   bool
+}
+
+type clockSample struct {
+	offset time.Duration // client clock minus server clock
+	rtt    time.Duration
+}
+
+// add records a round trip: the ping left at sent, the client stamped its
+// reply with clientTime and the pong arrived at received.
+func (c *clockEstimate) add(sent, received, clientTime time.Time) {
+	rtt := received.Sub(sent)
+	if rtt < 0 {
+		return
+	}
+	sample := clockSample{
+		offset: clientTime.Sub(sent.Add(rtt / 2)),
+		rtt:    rtt,
+	}
+	c.samples = append(c.samples, sample)
+	if len(c.samples) > clockSamples {
+		c.samples = c.samples[len(c.samples)-clockSamples:]
+	}
+	c.best = c.samples[0]
+	for _, s := range c.samples[1:] {
+		if s.rtt < c.best.rtt {
+			c.best = s
+		}
+	}
+	c.valid = true
+}
+
+// toServer converts a client timestamp to server time.
+func (c *clockEstimate) toServer(t time.Time) time.Time {
+	return t.Add(-c.best.offset)
+}
+
+// toClient converts a server timestamp to the client's clock.
+func (c *clockEstimate) toClient(t time.Time) time.Time {
+	return t.Add(c.best.offset)
+}
+
+func (c *clockEstimate) rtt() time.Duration {
+	return c.best.rtt
+}
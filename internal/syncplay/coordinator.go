@@ -0,0 +1,578 @@
+// Package syncplay keeps Watch Together groups in step. The Coordinator
+// owns each live session's playback state; clients talk to it over the
+// WebSocket hub and it answers with scheduled commands, clock pings and
+// per-client drift corrections.
+package syncplay
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Group states, as stored in sync_sessions.state.
+const (
+	StateWaiting = "waiting" // paused until every member has buffered
+	StatePlaying = "playing"
+	StatePaused  = "paused"
+)
+
+var (
+	ErrNoSession = errors.New("sync session is not active")
+	ErrNotHost   = errors.New("only the host can control playback")
+)
+
+const (
+	pingInterval = 2 * time.Second
+	// A member that hasn't buffered by then is left behind; drift
+	// correction catches it up once it plays
+	barrierTimeout = 10 * time.Second
+	// Playback starts this far after the barrier clears (plus half the
+	// slowest RTT) so every client gets the command before the start time
+	startLead = 300 * time.Millisecond
+
+	driftRateThreshold = 100 * time.Millisecond
+	driftSeekThreshold = time.Second
+	rateWindow         = 5 * time.Second // drift is worked off over this long
+	correctionCooldown = 3 * time.Second
+)
+
+// Session is the persisted part of a sync session.
+type Session struct {
+	ID          uuid.UUID
+	MediaItemID uuid.UUID
+	HostID      uuid.UUID
+	State       string
+	Position    float64
+}
+
+// Store loads and persists sessions.
+type Store interface {
+	// Open returns the session if userID is a participant and it hasn't ended.
+	Open(sessionID, userID uuid.UUID) (*Session, error)
+	SaveState(sessionID uuid.UUID, state string, position float64)
+	SetHost(sessionID, hostID uuid.UUID)
+}
+
+// SendFunc delivers an event to one connection.
+type SendFunc func(memberID, event string, data interface{})
+
+// Coordinator runs every live sync session. A session is live while at
+// least one connection has joined it.
+type Coordinator struct {
+	store Store
+	send  SendFunc
+	now   func() time.Time
+
+	mu      sync.Mutex
+	groups  map[uuid.UUID]*group
+	members map[string]*member // connection ID → member
+	writes  []func()           // store writes queued under mu, run by unlock
+
+	// Held while queued writes run, so they reach the store in order
+	writeMu sync.Mutex
+
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+type group struct {
+	Session
+	positionAt time.Time // Position is the playhead at this instant
+	resume     bool      // play once the barrier clears
+	deadline   time.Time // barrier timeout
+	members    map[string]*member
+}
+
+type member struct {
+	id       string
+	userID   uuid.UUID
+	group    *group
+	joined   time.Time
+	ready    bool
+	clock    clockEstimate
+	pingID   int64
+	pingSent time.Time
+	// Last drift correction; reports are ignored for a while after one so
+	// the client can act on it
+	corrected time.Time
+}
+
+// NewCoordinator creates a coordinator and starts its ping/timeout loop.
+func NewCoordinator(store Store, send SendFunc) *Coordinator {
+	c := &Coordinator{
+		store:   store,
+		send:    send,
+		now:     time.Now,
+		groups:  make(map[uuid.UUID]*group),
+		members: make(map[string]*member),
+		done:    make(chan struct{}),
+	}
+	go c.loop()
+	return c
+}
+
+// Shutdown stops the background loop.
+func (c *Coordinator) Shutdown() {
+	c.stopOnce.Do(func() { close(c.done) })
+}
+
+// clientMessage is the payload of every sync:* event a client sends.
+// Times are Unix milliseconds on the client's clock.
+type clientMessage struct {
+	SessionID  uuid.UUID `json:"session_id"`
+	Position   float64   `json:"position"`
+	PingID     int64     `json:"ping_id"`
+	ClientTime int64     `json:"client_time"`
+}
+
+// Handle processes a sync:* event from a WebSocket connection.
+func (c *Coordinator) Handle(memberID string, userID uuid.UUID, event string, raw json.RawMessage) {
+	var msg clientMessage
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			c.send(memberID, "sync:error", map[string]interface{}{"error": "invalid message"})
+			return
+		}
+	}
+
+	if event == "sync:join" {
+		if err := c.join(memberID, userID, msg.SessionID); err != nil {
+			c.send(memberID, "sync:error", map[string]interface{}{"session_id": msg.SessionID, "error": err.Error()})
+		}
+		return
+	}
+
+	c.mu.Lock()
+	defer c.unlock()
+	m := c.members[memberID]
+	if m == nil || (msg.SessionID != uuid.Nil && m.group.ID != msg.SessionID) {
+		c.send(memberID, "sync:error", map[string]interface{}{"session_id": msg.SessionID, "error": ErrNoSession.Error()})
+		return
+	}
+	g := m.group
+	now := c.now()
+
+	switch event {
+	case "sync:leave":
+		c.leave(m)
+	case "sync:play", "sync:pause", "sync:seek":
+		if g.HostID != m.userID {
+			c.send(memberID, "sync:error", map[string]interface{}{"session_id": g.ID, "error": ErrNotHost.Error()})
+			return
+		}
+		c.command(g, event[len("sync:"):], msg.Position)
+	case "sync:buffering":
+		m.ready = false
+		if g.State == StatePlaying {
+			c.wait(g, g.position(now), true, "buffering")
+		}
+	case "sync:ready":
+		if g.State == StateWaiting {
+			m.ready = true
+			c.checkBarrier(g, false)
+		}
+	case "sync:pong":
+		if msg.PingID == m.pingID && !m.pingSent.IsZero() {
+			m.clock.add(m.pingSent, now, time.UnixMilli(msg.ClientTime))
+			m.pingSent = time.Time{}
+		}
+	case "sync:position":
+		c.checkDrift(m, msg.Position, time.UnixMilli(msg.ClientTime))
+	}
+}
+
+func (c *Coordinator) join(memberID string, userID, sessionID uuid.UUID) error {
+	c.mu.Lock()
+	g := c.groups[sessionID]
+	c.mu.Unlock()
+	if g == nil {
+		// Load outside the lock; another join may race us, in which
+		// case its group wins below
+		sess, err := c.store.Open(sessionID, userID)
+		if err != nil {
+			return err
+		}
+		g = &group{Session: *sess, members: make(map[string]*member)}
+		// Nobody has been playing it since it went idle
+		g.State = StatePaused
+	} else if _, err := c.store.Open(sessionID, userID); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.unlock()
+	if existing := c.groups[sessionID]; existing != nil {
+		g = existing
+	} else {
+		g.positionAt = c.now()
+		c.groups[sessionID] = g
+	}
+	if old := c.members[memberID]; old != nil {
+		if old.group == g {
+			c.sendState(old)
+			return nil
+		}
+		c.leave(old)
+	}
+
+	m := &member{id: memberID, userID: userID, group: g, joined: c.now()}
+	c.members[memberID] = m
+	g.members[memberID] = m
+	c.ping(m)
+	c.sendState(m)
+	c.broadcast(g, "sync:join", map[string]interface{}{"session_id": g.ID, "user_id": userID}, memberID)
+	return nil
+}
+
+// leave removes a member, handing the session to someone else if it was
+// the host's last connection. Caller holds c.mu.
+func (c *Coordinator) leave(m *member) {
+	g := m.group
+	delete(c.members, m.id)
+	delete(g.members, m.id)
+	now := c.now()
+
+	if len(g.members) == 0 {
+		// Keep the playhead for whoever comes back
+		g.Position = g.position(now)
+		c.save(g.ID, StatePaused, g.Position)
+		delete(c.groups, g.ID)
+		return
+	}
+	c.broadcast(g, "sync:leave", map[string]interface{}{"session_id": g.ID, "user_id": m.userID}, "")
+
+	if m.userID == g.HostID && !g.hasUser(m.userID) {
+		next := g.oldestMember()
+		g.HostID = next.userID
+		sessionID, hostID := g.ID, g.HostID
+		c.queue(func() { c.store.SetHost(sessionID, hostID) })
+		log.Printf("[sync] session %s: host left, handing over to %s", g.ID, g.HostID)
+		c.broadcast(g, "sync:host", map[string]interface{}{"session_id": g.ID, "host_id": g.HostID}, "")
+	}
+	if g.State == StateWaiting {
+		c.checkBarrier(g, false)
+	}
+}
+
+// save queues a write of a session's state. Caller holds c.mu.
+func (c *Coordinator) save(sessionID uuid.UUID, state string, position float64) {
+	c.queue(func() { c.store.SaveState(sessionID, state, position) })
+}
+
+// queue defers a store write until c.mu is released, so a slow database
+// doesn't hold up every other session. Caller holds c.mu.
+func (c *Coordinator) queue(write func()) {
+	c.writes = append(c.writes, write)
+}
+
+// unlock releases c.mu and then runs the writes queued under it.
+func (c *Coordinator) unlock() {
+	c.mu.Unlock()
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.mu.Lock()
+	writes := c.writes
+	c.writes = nil
+	c.mu.Unlock()
+	for _, write := range writes {
+		write()
+	}
+}
+
+// Disconnect removes a closed connection from its session.
+func (c *Coordinator) Disconnect(memberID string) {
+	c.mu.Lock()
+	defer c.unlock()
+	if m := c.members[memberID]; m != nil {
+		c.leave(m)
+	}
+}
+
+// Command applies play, pause or seek from userID, who must be the host.
+// It returns ErrNoSession if nobody is connected to the session.
+func (c *Coordinator) Command(sessionID, userID uuid.UUID, command string, position float64) error {
+	c.mu.Lock()
+	defer c.unlock()
+	g := c.groups[sessionID]
+	if g == nil {
+		return ErrNoSession
+	}
+	if g.HostID != userID {
+		return ErrNotHost
+	}
+	c.command(g, command, position)
+	return nil
+}
+
+// command applies a host action. Play and seek go through the buffering
+// barrier so nobody starts before everyone can. Caller holds c.mu.
+func (c *Coordinator) command(g *group, command string, position float64) {
+	now := c.now()
+	switch command {
+	case "play":
+		if g.State != StatePlaying {
+			c.wait(g, g.position(now), true, "play")
+		}
+	case "pause":
+		g.Position, g.positionAt = g.position(now), now
+		g.State, g.resume = StatePaused, false
+		c.save(g.ID, g.State, g.Position)
+		c.broadcast(g, "sync:command", map[string]interface{}{
+			"session_id": g.ID, "command": "pause", "position": g.Position,
+		}, "")
+	case "seek":
+		if position < 0 {
+			position = 0
+		}
+		resume := g.State == StatePlaying || (g.State == StateWaiting && g.resume)
+		c.wait(g, position, resume, "seek")
+	}
+}
+
+// wait pauses everyone at position until they all report ready (or the
+// barrier times out), then resumes if resume is set. Caller holds c.mu.
+func (c *Coordinator) wait(g *group, position float64, resume bool, reason string) {
+	now := c.now()
+	g.State, g.resume = StateWaiting, resume
+	g.Position, g.positionAt = position, now
+	g.deadline = now.Add(barrierTimeout)
+	for _, m := range g.members {
+		m.ready = false
+	}
+	c.save(g.ID, g.State, g.Position)
+	c.broadcast(g, "sync:command", map[string]interface{}{
+		"session_id": g.ID, "command": "wait", "reason": reason, "position": position,
+	}, "")
+}
+
+// checkBarrier releases a waiting group once every member is ready, or
+// unconditionally when force is set. Caller holds c.mu.
+func (c *Coordinator) checkBarrier(g *group, force bool) {
+	if g.State != StateWaiting {
+		return
+	}
+	if !force {
+		for _, m := range g.members {
+			if !m.ready {
+				return
+			}
+		}
+	}
+	now := c.now()
+	if !g.resume {
+		g.State, g.positionAt = StatePaused, now
+		c.save(g.ID, g.State, g.Position)
+		c.broadcast(g, "sync:command", map[string]interface{}{
+			"session_id": g.ID, "command": "pause", "position": g.Position,
+		}, "")
+		return
+	}
+
+	lead := startLead
+	for _, m := range g.members {
+		if half := m.clock.rtt() / 2; startLead+half > lead {
+			lead = startLead + half
+		}
+	}
+	if lead > 2*time.Second {
+		lead = 2 * time.Second
+	}
+	at := now.Add(lead)
+	g.State, g.positionAt = StatePlaying, at
+	c.save(g.ID, g.State, g.Position)
+	for _, m := range g.members {
+		c.send(m.id, "sync:command", map[string]interface{}{
+			"session_id": g.ID,
+			"command":    "play",
+			"position":   g.Position,
+			"at":         at.UnixMilli(),
+			"at_client":  m.clock.toClient(at).UnixMilli(),
+		})
+	}
+}
+
+// checkDrift compares a member's reported playhead with where the group
+// should be at the moment it was sampled, and nudges the member back in
+// line: a playback-rate change for small drift, a seek for large. Caller
+// holds c.mu.
+func (c *Coordinator) checkDrift(m *member, position float64, clientTime time.Time) {
+	g := m.group
+	now := c.now()
+	if g.State != StatePlaying || now.Before(g.positionAt) || !m.clock.valid || now.Sub(m.corrected) < correctionCooldown {
+		return
+	}
+	sampled := m.clock.toServer(clientTime)
+	drift := time.Duration((position - g.position(sampled)) * float64(time.Second))
+	abs := drift
+	if abs < 0 {
+		abs = -abs
+	}
+	switch {
+	case abs >= driftSeekThreshold:
+		// Aim for where the group will be when the seek lands
+		target := g.position(now.Add(m.clock.rtt() / 2))
+		c.send(m.id, "sync:correct", map[string]interface{}{
+			"session_id": g.ID, "mode": "seek", "position": target, "drift": drift.Seconds(),
+		})
+	case abs >= driftRateThreshold:
+		rate := 1 - drift.Seconds()/rateWindow.Seconds()
+		rate = math.Max(0.9, math.Min(1.1, rate))
+		c.send(m.id, "sync:correct", map[string]interface{}{
+			"session_id": g.ID, "mode": "rate", "rate": rate, "duration_ms": rateWindow.Milliseconds(),
+			"drift": drift.Seconds(),
+		})
+	default:
+		return
+	}
+	m.corrected = now
+}
+
+// Broadcast sends an event to everyone connected to the session.
+func (c *Coordinator) Broadcast(sessionID uuid.UUID, event string, data interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if g := c.groups[sessionID]; g != nil {
+		c.broadcast(g, event, data, "")
+	}
+}
+
+// End tells every member the session is over and drops it.
+func (c *Coordinator) End(sessionID uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g := c.groups[sessionID]
+	if g == nil {
+		return
+	}
+	c.broadcast(g, "sync:ended", map[string]interface{}{"session_id": sessionID}, "")
+	for id := range g.members {
+		delete(c.members, id)
+	}
+	delete(c.groups, sessionID)
+}
+
+// Snapshot is a live session's state for the HTTP API.
+type Snapshot struct {
+	State    string      `json:"state"`
+	Position float64     `json:"current_time"`
+	HostID   uuid.UUID   `json:"host_id"`
+	Users    []uuid.UUID `json:"connected_users"`
+}
+
+// Snapshot returns the session's live state, if anyone is connected.
+func (c *Coordinator) Snapshot(sessionID uuid.UUID) (*Snapshot, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g := c.groups[sessionID]
+	if g == nil {
+		return nil, false
+	}
+	return &Snapshot{State: g.State, Position: g.position(c.now()), HostID: g.HostID, Users: g.users()}, true
+}
+
+func (c *Coordinator) loop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		c.tick()
+	}
+}
+
+func (c *Coordinator) tick() {
+	c.mu.Lock()
+	defer c.unlock()
+	now := c.now()
+	for _, g := range c.groups {
+		for _, m := range g.members {
+			c.ping(m)
+		}
+		if g.State == StateWaiting && now.After(g.deadline) {
+			log.Printf("[sync] session %s: buffering barrier timed out, starting without stragglers", g.ID)
+			c.checkBarrier(g, true)
+		}
+	}
+}
+
+// ping starts a clock round trip; the client answers with sync:pong
+// carrying ping_id and its own time. Caller holds c.mu.
+func (c *Coordinator) ping(m *member) {
+	now := c.now()
+	m.pingID++
+	m.pingSent = now
+	c.send(m.id, "sync:ping", map[string]interface{}{"ping_id": m.pingID, "server_time": now.UnixMilli()})
+}
+
+// sendState gives a (re)joining member the full picture. Caller holds c.mu.
+func (c *Coordinator) sendState(m *member) {
+	g := m.group
+	now := c.now()
+	c.send(m.id, "sync:state", map[string]interface{}{
+		"session_id":    g.ID,
+		"media_item_id": g.MediaItemID,
+		"host_id":       g.HostID,
+		"state":         g.State,
+		"position":      g.position(now),
+		"server_time":   now.UnixMilli(),
+		"users":         g.users(),
+	})
+}
+
+func (c *Coordinator) broadcast(g *group, event string, data interface{}, except string) {
+	for id := range g.members {
+		if id != except {
+			c.send(id, event, data)
+		}
+	}
+}
+
+// position extrapolates the playhead to t.
+func (g *group) position(t time.Time) float64 {
+	if g.State != StatePlaying || t.Before(g.positionAt) {
+		return g.Position
+	}
+	return g.Position + t.Sub(g.positionAt).Seconds()
+}
+
+func (g *group) hasUser(userID uuid.UUID) bool {
+	for _, m := range g.members {
+		if m.userID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *group) oldestMember() *member {
+	var oldest *member
+	for _, m := range g.members {
+		if oldest == nil || m.joined.Before(oldest.joined) || (m.joined.Equal(oldest.joined) && m.id < oldest.id) {
+			oldest = m
+		}
+	}
+	return oldest
+}
+
+func (g *group) users() []uuid.UUID {
+	seen := make(map[uuid.UUID]bool)
+	var users []uuid.UUID
+	for _, m := range g.members {
+		if !seen[m.userID] {
+			seen[m.userID] = true
+			users = append(users, m.userID)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].String() < users[j].String() })
+	return users
+}
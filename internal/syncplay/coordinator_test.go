@@ -0,0 +1,347 @@
+package syncplay
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type sent struct {
+	member, event string
+	data          map[string]interface{}
+}
+
+// fakeStore records writes and checks that none of them run while the
+// coordinator's lock is held.
+type fakeStore struct {
+	c       *Coordinator
+	session Session
+	mu      sync.Mutex
+	states  []string
+	hosts   []uuid.UUID
+	locked  int
+}
+
+func (s *fakeStore) Open(sessionID, _ uuid.UUID) (*Session, error) {
+	sess := s.session
+	sess.ID = sessionID
+	return &sess, nil
+}
+
+func (s *fakeStore) checkUnlocked() {
+	if s.c.mu.TryLock() {
+		s.c.mu.Unlock()
+	} else {
+		s.locked++
+	}
+}
+
+func (s *fakeStore) SaveState(_ uuid.UUID, state string, position float64) {
+	s.checkUnlocked()
+	s.mu.Lock()
+	s.states = append(s.states, fmt.Sprintf("%s@%g", state, position))
+	s.mu.Unlock()
+}
+
+func (s *fakeStore) SetHost(_, hostID uuid.UUID) {
+	s.checkUnlocked()
+	s.mu.Lock()
+	s.hosts = append(s.hosts, hostID)
+	s.mu.Unlock()
+}
+
+// harness is a coordinator without its background loop, on a clock the
+// test moves by hand.
+type harness struct {
+	*Coordinator
+	t       *testing.T
+	store   *fakeStore
+	clock   time.Time
+	session uuid.UUID
+	out     []sent
+}
+
+func newHarness(t *testing.T, host uuid.UUID) *harness {
+	h := &harness{t: t, clock: time.Unix(1700000000, 0), session: uuid.New()}
+	h.store = &fakeStore{session: Session{MediaItemID: uuid.New(), HostID: host, State: StatePlaying, Position: 42}}
+	h.Coordinator = &Coordinator{
+		store:   h.store,
+		now:     func() time.Time { return h.clock },
+		groups:  make(map[uuid.UUID]*group),
+		members: make(map[string]*member),
+		done:    make(chan struct{}),
+	}
+	h.store.c = h.Coordinator
+	h.send = func(memberID, event string, data interface{}) {
+		d, _ := data.(map[string]interface{})
+		h.out = append(h.out, sent{memberID, event, d})
+	}
+	return h
+}
+
+func (h *harness) advance(d time.Duration) { h.clock = h.clock.Add(d) }
+
+func (h *harness) handle(member string, user uuid.UUID, event string, msg map[string]interface{}) {
+	h.t.Helper()
+	if msg == nil {
+		msg = map[string]interface{}{}
+	}
+	msg["session_id"] = h.session
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	h.Handle(member, user, event, raw)
+}
+
+// join connects a member and answers its first ping with a clock offset
+// of offset and a round trip of rtt.
+func (h *harness) join(member string, user uuid.UUID, offset, rtt time.Duration) {
+	h.t.Helper()
+	h.handle(member, user, "sync:join", nil)
+	sentAt := h.clock
+	h.advance(rtt)
+	h.handle(member, user, "sync:pong", map[string]interface{}{
+		"ping_id":     h.members[member].pingID,
+		"client_time": sentAt.Add(rtt / 2).Add(offset).UnixMilli(),
+	})
+	h.out = nil
+}
+
+// take returns and clears what was sent for an event.
+func (h *harness) take(event string) []sent {
+	var got, rest []sent
+	for _, s := range h.out {
+		if s.event == event {
+			got = append(got, s)
+		} else {
+			rest = append(rest, s)
+		}
+	}
+	h.out = rest
+	return got
+}
+
+func TestClockEstimate(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	ms := time.Millisecond
+	type sample struct {
+		sent, rtt, offset time.Duration
+		asymmetry         time.Duration // how much later than halfway the pong was stamped
+	}
+	tests := []struct {
+		name    string
+		samples []sample
+		offset  time.Duration
+		rtt     time.Duration
+		valid   bool
+	}{
+		{"one sample", []sample{{0, 40 * ms, 250 * ms, 0}}, 250 * ms, 40 * ms, true},
+		{"smallest RTT wins", []sample{
+			{0, 200 * ms, 0, 80 * ms}, {time.Second, 20 * ms, 0, 5 * ms}, {2 * time.Second, 100 * ms, 0, -40 * ms},
+		}, 5 * ms, 20 * ms, true},
+		{"negative round trip ignored", []sample{{0, -10 * ms, 500 * ms, 0}}, 0, 0, false},
+		{"best sample ages out of the window", append(
+			[]sample{{0, 5 * ms, 900 * ms, 0}},
+			repeat(clockSamples, sample{time.Second, 50 * ms, 100 * ms, 0})...,
+		), 100 * ms, 50 * ms, true},
+	}
+	for _, tt := range tests {
+		var c clockEstimate
+		for _, s := range tt.samples {
+			sent := base.Add(s.sent)
+			c.add(sent, sent.Add(s.rtt), sent.Add(s.rtt/2+s.asymmetry+s.offset))
+		}
+		if c.valid != tt.valid || c.best.offset != tt.offset || c.rtt() != tt.rtt {
+			t.Errorf("%s: offset %v rtt %v valid %v; want %v %v %v", tt.name, c.best.offset, c.rtt(), c.valid, tt.offset, tt.rtt, tt.valid)
+		}
+	}
+
+	c := clockEstimate{best: clockSample{offset: 3 * time.Second}}
+	if got := c.toClient(base); !got.Equal(base.Add(3 * time.Second)) {
+		t.Errorf("toClient = %v", got)
+	}
+	if got := c.toServer(base.Add(3 * time.Second)); !got.Equal(base) {
+		t.Errorf("toServer = %v", got)
+	}
+}
+
+func repeat[T any](n int, v T) []T {
+	out := make([]T, n)
+	for i := range out {
+		out[i] = v
+	}
+	return out
+}
+
+func TestBarrier(t *testing.T) {
+	host, guest := uuid.New(), uuid.New()
+	h := newHarness(t, host)
+	h.join("a", host, 0, 20*time.Millisecond)
+	h.join("b", guest, 2*time.Second, 600*time.Millisecond)
+
+	h.handle("a", host, "sync:play", nil)
+	if waits := h.take("sync:command"); len(waits) != 2 || waits[0].data["command"] != "wait" {
+		t.Fatalf("play sent %v, want a wait to both members", waits)
+	}
+	h.handle("a", host, "sync:ready", nil)
+	if cmds := h.take("sync:command"); len(cmds) != 0 {
+		t.Fatalf("played with one member still buffering: %v", cmds)
+	}
+
+	h.advance(100 * time.Millisecond)
+	h.handle("b", guest, "sync:ready", nil)
+	plays := h.take("sync:command")
+	if len(plays) != 2 {
+		t.Fatalf("barrier cleared with %v, want a play to both members", plays)
+	}
+	// The start waits for half the slowest round trip on top of the lead
+	at := h.clock.Add(startLead + 300*time.Millisecond)
+	for _, p := range plays {
+		want := at
+		if p.member == "b" {
+			want = at.Add(2 * time.Second)
+		}
+		if p.data["command"] != "play" || p.data["at"] != at.UnixMilli() || p.data["at_client"] != want.UnixMilli() {
+			t.Errorf("%s: got %v, want play at %d (%d on its clock)", p.member, p.data, at.UnixMilli(), want.UnixMilli())
+		}
+	}
+	if got := h.groups[h.session].State; got != StatePlaying {
+		t.Errorf("state %s after the barrier, want playing", got)
+	}
+
+	// A member that never buffers is left behind once the barrier times out
+	h.handle("a", host, "sync:seek", map[string]interface{}{"position": 600.0})
+	h.handle("a", host, "sync:ready", nil)
+	h.advance(barrierTimeout / 2)
+	h.tick()
+	if cmds := h.take("sync:command"); len(cmds) != 2 || cmds[0].data["command"] != "wait" {
+		t.Fatalf("before the timeout: %v", cmds)
+	}
+	h.advance(barrierTimeout)
+	h.tick()
+	plays = h.take("sync:command")
+	if len(plays) != 2 || plays[0].data["command"] != "play" || plays[0].data["position"] != 600.0 {
+		t.Fatalf("after the timeout: %v, want a play at 600 to both members", plays)
+	}
+	if h.store.locked > 0 {
+		t.Errorf("%d store writes ran under the coordinator lock", h.store.locked)
+	}
+}
+
+func TestHostHandOff(t *testing.T) {
+	host, guest := uuid.New(), uuid.New()
+	h := newHarness(t, host)
+	h.join("host-tv", host, 0, 0)
+	h.advance(time.Second)
+	h.join("guest", guest, 0, 0)
+	h.advance(time.Second)
+	h.join("host-phone", host, 0, 0)
+
+	// The host still has a connection
+	h.handle("host-tv", host, "sync:leave", nil)
+	if got := h.take("sync:host"); len(got) != 0 || h.groups[h.session].HostID != host {
+		t.Fatalf("host handed over while still connected: %v", got)
+	}
+
+	h.Disconnect("host-phone")
+	if got := h.groups[h.session].HostID; got != guest {
+		t.Fatalf("host is %v after the host's last connection left, want %v", got, guest)
+	}
+	if got := h.take("sync:host"); len(got) != 1 || got[0].member != "guest" || got[0].data["host_id"] != guest {
+		t.Errorf("sync:host sent %v", got)
+	}
+	if len(h.store.hosts) != 1 || h.store.hosts[0] != guest {
+		t.Errorf("stored hosts %v, want [%v]", h.store.hosts, guest)
+	}
+	if err := h.Command(h.session, host, "pause", 0); err != ErrNotHost {
+		t.Errorf("old host's command = %v, want ErrNotHost", err)
+	}
+	if err := h.Command(h.session, guest, "pause", 0); err != nil {
+		t.Errorf("new host's command = %v", err)
+	}
+
+	// The last one out keeps the playhead and ends the live session
+	h.Disconnect("guest")
+	if _, ok := h.Snapshot(h.session); ok {
+		t.Error("session still live with nobody connected")
+	}
+	if last := h.store.states[len(h.store.states)-1]; last != "paused@42" {
+		t.Errorf("last stored state %s, want paused@42", last)
+	}
+	if h.store.locked > 0 {
+		t.Errorf("%d store writes ran under the coordinator lock", h.store.locked)
+	}
+}
+
+func TestDrift(t *testing.T) {
+	tests := []struct {
+		name  string
+		drift float64 // seconds ahead of the group
+		mode  string
+		rate  float64
+	}{
+		{"in step", 0.05, "", 0},
+		{"just under the rate threshold", driftRateThreshold.Seconds() - 0.01, "", 0},
+		{"slightly ahead slows down", 0.25, "rate", 0.95},
+		{"slightly behind speeds up", -0.5, "rate", 1.1},
+		{"far behind seeks", -1.5, "seek", 0},
+		{"far ahead seeks", 5, "seek", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := uuid.New()
+			h := newHarness(t, user)
+			offset, rtt := 90*time.Second, 200*time.Millisecond
+			h.join("a", user, offset, rtt)
+			h.handle("a", user, "sync:play", nil)
+			h.handle("a", user, "sync:ready", nil)
+			h.advance(10 * time.Second)
+			h.out = nil
+
+			// Sampled 150ms ago on the client's clock
+			sampled := h.clock.Add(-150 * time.Millisecond)
+			position := h.groups[h.session].position(sampled) + tt.drift
+			report := map[string]interface{}{"position": position, "client_time": sampled.Add(offset).UnixMilli()}
+			h.handle("a", user, "sync:position", report)
+
+			got := h.take("sync:correct")
+			if tt.mode == "" {
+				if len(got) != 0 {
+					t.Fatalf("corrected %v", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0].data["mode"] != tt.mode {
+				t.Fatalf("sent %v, want a %s correction", got, tt.mode)
+			}
+			switch tt.mode {
+			case "rate":
+				if rate := got[0].data["rate"].(float64); math.Abs(rate-tt.rate) > 1e-9 {
+					t.Errorf("rate %v, want %v", rate, tt.rate)
+				}
+			case "seek":
+				want := h.groups[h.session].position(h.clock.Add(rtt / 2))
+				if pos := got[0].data["position"].(float64); math.Abs(pos-want) > 1e-9 {
+					t.Errorf("seek to %v, want %v", pos, want)
+				}
+			}
+
+			// The client gets time to act on it before the next one
+			h.handle("a", user, "sync:position", report)
+			if again := h.take("sync:correct"); len(again) != 0 {
+				t.Errorf("corrected again during the cooldown: %v", again)
+			}
+			h.advance(correctionCooldown)
+			h.handle("a", user, "sync:position", report)
+			if again := h.take("sync:correct"); len(again) != 1 {
+				t.Errorf("not corrected after the cooldown: %v", again)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package watcher
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/JustinTDCT/CineVault/internal/models"
+	"github.com/google/uuid"
+)
+
+// pollBatch is how many entries a walk stats between checks of its pace.
+const pollBatch = 200
+
+// fileState is what a poll snapshot records about a media file.
+type fileState struct {
+	size    int64
+	modTime time.Time
+	pending bool // new or changed, and still changing; reported once it holds still
+	known   bool // pending, but reported before it started changing
+}
+
+// poller watches one library folder by walking it on an interval and
+// diffing path/size/mtime snapshots, for NFS, SMB and FUSE mounts where
+// fsnotify hears nothing of changes made by other hosts. It reports through
+// the same debounced callbacks as fsnotify events, so a library watched
+// both ways sees each change once.
+type poller struct {
+	root     string
+	libID    uuid.UUID
+	interval time.Duration
+	rate     int // entries stat'ed per second; 0 = unlimited
+	emit     func(libID uuid.UUID, path string, isCreate bool, delay time.Duration)
+	stop     chan struct{}
+}
+
+func (p *poller) run() {
+	// The first snapshot is the baseline; library scans cover what was
+	// there before the watcher started
+	snapshot, ok := p.walk()
+	for !ok {
+		if !p.sleep(p.interval) {
+			return
+		}
+		snapshot, ok = p.walk()
+	}
+	lastWalk := time.Now()
+
+	wait := p.interval
+	for {
+		if !p.sleep(wait) {
+			return
+		}
+		began := time.Now()
+		current, ok := p.walk()
+		took := time.Since(began)
+
+		// Stay idle at least as long as a walk takes, so very large trees
+		// don't keep the mount busy
+		wait = p.interval
+		if took > wait {
+			wait = took
+		}
+		if !ok || !p.diff(snapshot, current, lastWalk) {
+			continue
+		}
+		snapshot, lastWalk = current, began
+	}
+}
+
+// diff reports files created and removed between two snapshots, marking
+// files still being written as pending in current. A known file that
+// changes (replaced by an upgrade, say) is reported as created again once
+// it holds still. Creates are reported first so a moved file is picked up
+// at its new path before its old one is removed. It returns false, having
+// reported nothing, if current looks like a dropped mount.
+func (p *poller) diff(previous, current map[string]fileState, lastWalk time.Time) bool {
+	// An emptied folder is far more likely a dropped mount than every
+	// file deleted at once
+	if len(current) == 0 && len(previous) > 0 {
+		log.Printf("[watcher] %s is empty, skipping poll (mount unavailable?)", p.root)
+		return false
+	}
+
+	var created, removed []string
+	for path, st := range current {
+		old, seen := previous[path]
+		unchanged := seen && old.size == st.size && old.modTime.Equal(st.modTime)
+		switch {
+		case unchanged && !old.pending:
+			// Known file
+		case unchanged:
+			// Held still for a whole interval
+			created = append(created, path)
+		case !seen && st.modTime.Before(lastWalk):
+			// Moved or renamed in: written before the last walk
+			created = append(created, path)
+		default:
+			st.pending = true
+			st.known = seen && (!old.pending || old.known)
+			current[path] = st
+		}
+	}
+	for path, old := range previous {
+		if _, ok := current[path]; !ok && (!old.pending || old.known) {
+			removed = append(removed, path)
+		}
+	}
+
+	for _, path := range created {
+		p.emit(p.libID, path, true, createDelay)
+	}
+	for _, path := range removed {
+		p.emit(p.libID, path, false, removeDelay)
+	}
+	return true
+}
+
+// walk snapshots the media files under the root, pacing itself to p.rate
+// entries a second. ok is false if the root is unreachable or the poller
+// was stopped.
+func (p *poller) walk() (snapshot map[string]fileState, ok bool) {
+	if _, err := os.Stat(p.root); err != nil {
+		return nil, false
+	}
+	snapshot = make(map[string]fileState)
+	began := time.Now()
+	entries := 0
+	stopped := false
+	filepath.WalkDir(p.root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil // skip inaccessible dirs
+		}
+		entries++
+		if p.rate > 0 && entries%pollBatch == 0 {
+			due := time.Duration(entries) * time.Second / time.Duration(p.rate)
+			if ahead := due - time.Since(began); ahead > 0 && !p.sleep(ahead) {
+				stopped = true
+				return filepath.SkipAll
+			}
+		}
+
+		name := d.Name()
+		if d.IsDir() {
+			if path != p.root && (name == models.OptimizedDirName || strings.HasPrefix(name, ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") || strings.HasSuffix(name, ".part") ||
+			!isMediaExtension(strings.ToLower(filepath.Ext(name))) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		snapshot[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return snapshot, !stopped
+}
+
+// sleep waits for d, returning false if the poller is stopped first.
+func (p *poller) sleep(d time.Duration) bool {
+	select {
+	case <-p.stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
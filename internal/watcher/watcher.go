@@ -14,20 +14,30 @@ import (
 	"github.com/google/uuid"
 )
 
-// OnFileEvent is called when a file is created or removed.
+// OnFileEvent is called when a file is created or removed. For removals
+// path may be a folder, meaning everything under it.
 type OnFileEvent func(libraryID uuid.UUID, path string, isCreate bool)
 
-// Watcher monitors library folders for filesystem changes.
+// Watcher monitors library folders for filesystem changes, through
+// fsnotify, by polling, or both, as each library's watch mode says.
 type Watcher struct {
 	libRepo  *repository.LibraryRepository
 	callback OnFileEvent
 	watcher  *fsnotify.Watcher
 	mu       sync.Mutex
+	roots    map[string]uuid.UUID // library folder → library ID, fsnotify
 	watched  map[string]uuid.UUID // folder path → library ID
+	pollers  map[string]*poller   // library folder → its poller
 	debounce map[string]*time.Timer
 	stop     chan struct{}
+
+	pollInterval time.Duration
+	pollRate     int
 }
 
+// refreshInterval is how often library watch settings are reloaded.
+const refreshInterval = 5 * time.Minute
+
 // New creates a filesystem watcher.
 func New(libRepo *repository.LibraryRepository, cb OnFileEvent) (*Watcher, error) {
 	fw, err := fsnotify.NewWatcher()
@@ -35,15 +45,28 @@ func New(libRepo *repository.LibraryRepository, cb OnFileEvent) (*Watcher, error
 		return nil, err
 	}
 	return &Watcher{
-		libRepo:  libRepo,
-		callback: cb,
-		watcher:  fw,
-		watched:  make(map[string]uuid.UUID),
-		debounce: make(map[string]*time.Timer),
-		stop:     make(chan struct{}),
+		libRepo:      libRepo,
+		callback:     cb,
+		watcher:      fw,
+		roots:        make(map[string]uuid.UUID),
+		watched:      make(map[string]uuid.UUID),
+		pollers:      make(map[string]*poller),
+		debounce:     make(map[string]*time.Timer),
+		stop:         make(chan struct{}),
+		pollInterval: time.Minute,
+		pollRate:     2000,
 	}, nil
 }
 
+// SetPolling sets how often polled folders are walked and how many entries
+// a second a walk may stat (0 = unlimited). Call before Start.
+func (w *Watcher) SetPolling(interval time.Duration, rate int) {
+	if interval > 0 {
+		w.pollInterval = interval
+	}
+	w.pollRate = rate
+}
+
 // Start begins watching all enabled libraries and processes events.
 func (w *Watcher) Start() {
 	go w.eventLoop()
@@ -54,6 +77,11 @@ func (w *Watcher) Start() {
 // Stop stops the watcher.
 func (w *Watcher) Stop() {
 	close(w.stop)
+	w.mu.Lock()
+	for _, p := range w.pollers {
+		close(p.stop)
+	}
+	w.mu.Unlock()
 	w.watcher.Close()
 }
 
@@ -69,35 +97,86 @@ func (w *Watcher) Refresh() {
 	defer w.mu.Unlock()
 
 	// Collect desired paths
-	desired := make(map[string]uuid.UUID)
+	notify := make(map[string]uuid.UUID)
+	poll := make(map[string]uuid.UUID)
 	for _, lib := range libs {
-		for _, f := range lib.Folders {
-			desired[f.FolderPath] = lib.ID
+		paths := []string{lib.Path}
+		if len(lib.Folders) > 0 {
+			paths = paths[:0]
+			for _, f := range lib.Folders {
+				paths = append(paths, f.FolderPath)
+			}
 		}
-		if len(lib.Folders) == 0 {
-			desired[lib.Path] = lib.ID
+		for _, p := range paths {
+			if lib.WatchMode != models.WatchModePoll {
+				notify[p] = lib.ID
+			}
+			if lib.WatchMode == models.WatchModePoll || lib.WatchMode == models.WatchModeBoth {
+				poll[p] = lib.ID
+			}
 		}
 	}
 
 	// Remove paths no longer desired
-	for p := range w.watched {
-		if _, ok := desired[p]; !ok {
-			w.watcher.Remove(p)
-			delete(w.watched, p)
+	changed := false
+	for p, libID := range w.roots {
+		if notify[p] != libID {
+			w.unwatchTree(p)
+			delete(w.roots, p)
+			changed = true
+		}
+	}
+	for p, pl := range w.pollers {
+		if poll[p] != pl.libID {
+			close(pl.stop)
+			delete(w.pollers, p)
+			changed = true
 		}
 	}
 
 	// Add new paths
-	for p, libID := range desired {
-		if _, ok := w.watched[p]; ok {
+	for p, libID := range notify {
+		if _, ok := w.roots[p]; ok {
 			continue
 		}
 		if err := w.addRecursive(p, libID); err != nil {
 			log.Printf("[watcher] error adding %s: %v", p, err)
 		}
+		w.roots[p] = libID
+		changed = true
+	}
+	for p, libID := range poll {
+		if _, ok := w.pollers[p]; ok {
+			continue
+		}
+		pl := &poller{
+			root:     p,
+			libID:    libID,
+			interval: w.pollInterval,
+			rate:     w.pollRate,
+			emit:     w.schedule,
+			stop:     make(chan struct{}),
+		}
+		w.pollers[p] = pl
+		go pl.run()
+		changed = true
 	}
 
-	log.Printf("[watcher] watching %d paths across %d libraries", len(w.watched), len(libs))
+	if changed {
+		log.Printf("[watcher] watching %d paths and polling %d across %d libraries", len(w.watched), len(w.pollers), len(libs))
+	}
+}
+
+// unwatchTree stops fsnotify watches on path and every folder under it.
+// The caller holds w.mu.
+func (w *Watcher) unwatchTree(path string) {
+	prefix := path + string(filepath.Separator)
+	for p := range w.watched {
+		if p == path || strings.HasPrefix(p, prefix) {
+			w.watcher.Remove(p)
+			delete(w.watched, p)
+		}
+	}
 }
 
 func (w *Watcher) addRecursive(root string, libID uuid.UUID) error {
@@ -106,6 +185,9 @@ func (w *Watcher) addRecursive(root string, libID uuid.UUID) error {
 			return nil // skip inaccessible dirs
 		}
 		if info.IsDir() {
+			if info.Name() == models.OptimizedDirName {
+				return filepath.SkipDir
+			}
 			if err := w.watcher.Add(path); err != nil {
 				return nil
 			}
@@ -116,8 +198,13 @@ func (w *Watcher) addRecursive(root string, libID uuid.UUID) error {
 }
 
 func (w *Watcher) eventLoop() {
+	// Pick up watch settings changed since start
+	refresh := time.NewTicker(refreshInterval)
+	defer refresh.Stop()
 	for {
 		select {
+		case <-refresh.C:
+			w.Refresh()
 		case event, ok := <-w.watcher.Events:
 			if !ok {
 				return
@@ -134,6 +221,17 @@ func (w *Watcher) eventLoop() {
 	}
 }
 
+// Variables so tests can shorten them.
+var (
+	// createDelay lets a new file settle before it is scanned.
+	createDelay = 1 * time.Second
+
+	// removeDelay holds removals back so the create half of a move is
+	// scanned first and keeps the item (the scanner matches it to the
+	// missing file).
+	removeDelay = 30 * time.Second
+)
+
 func (w *Watcher) handleEvent(event fsnotify.Event) {
 	// Skip hidden files and temp files
 	base := filepath.Base(event.Name)
@@ -142,58 +240,93 @@ func (w *Watcher) handleEvent(event fsnotify.Event) {
 		return
 	}
 
-	isCreate := event.Has(fsnotify.Create) || event.Has(fsnotify.Rename)
-	isRemove := event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)
+	// A rename reports the old name; the new one arrives as a Create
+	switch {
+	case event.Has(fsnotify.Create):
+		w.handleCreate(event.Name)
+	case event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename):
+		w.handleRemove(event.Name)
+	}
+}
 
-	if !isCreate && !isRemove {
+func (w *Watcher) handleCreate(path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	libID := w.resolveLibrary(path)
+	if libID == uuid.Nil {
 		return
 	}
 
-	// For created dirs, add them to the watch list
-	if isCreate {
-		info, err := os.Stat(event.Name)
-		if err == nil && info.IsDir() {
-			libID := w.resolveLibrary(event.Name)
-			if libID != uuid.Nil {
-				w.mu.Lock()
-				w.watcher.Add(event.Name)
-				w.watched[event.Name] = libID
-				w.mu.Unlock()
-			}
+	// For created dirs, add them to the watch list. A folder moved in
+	// brings files that raise no events of their own.
+	if info.IsDir() {
+		if info.Name() == models.OptimizedDirName {
 			return
 		}
+		w.mu.Lock()
+		w.addRecursive(path, libID)
+		w.mu.Unlock()
+		filepath.WalkDir(path, func(p string, d os.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if d.Name() == models.OptimizedDirName || strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !strings.HasPrefix(d.Name(), ".") && isMediaExtension(strings.ToLower(filepath.Ext(p))) {
+				w.schedule(libID, p, true, createDelay)
+			}
+			return nil
+		})
+		return
 	}
 
 	// Only process media files
-	ext := strings.ToLower(filepath.Ext(event.Name))
-	if !isMediaExtension(ext) {
+	if !isMediaExtension(strings.ToLower(filepath.Ext(path))) {
 		return
 	}
+	w.schedule(libID, path, true, createDelay)
+}
+
+func (w *Watcher) handleRemove(path string) {
+	// A watched folder that went away takes its subfolders with it
+	w.mu.Lock()
+	_, wasDir := w.watched[path]
+	if wasDir {
+		w.unwatchTree(path)
+	}
+	w.mu.Unlock()
 
-	libID := w.resolveLibrary(event.Name)
+	if !wasDir && !isMediaExtension(strings.ToLower(filepath.Ext(path))) {
+		return
+	}
+	libID := w.resolveLibrary(path)
 	if libID == uuid.Nil {
 		return
 	}
+	w.schedule(libID, path, false, removeDelay)
+}
 
-	// Debounce: 1 second
+// schedule debounces events per path: only the last one within delay is
+// passed to the callback.
+func (w *Watcher) schedule(libID uuid.UUID, path string, isCreate bool, delay time.Duration) {
 	w.mu.Lock()
-	if timer, ok := w.debounce[event.Name]; ok {
+	defer w.mu.Unlock()
+	if timer, ok := w.debounce[path]; ok {
 		timer.Stop()
 	}
-	eventName := event.Name
-	w.debounce[eventName] = time.AfterFunc(1*time.Second, func() {
+	w.debounce[path] = time.AfterFunc(delay, func() {
 		w.mu.Lock()
-		delete(w.debounce, eventName)
+		delete(w.debounce, path)
 		w.mu.Unlock()
 
-		if isCreate {
-			w.callback(libID, eventName, true)
-		}
-		if isRemove && !isCreate {
-			w.callback(libID, eventName, false)
-		}
+		w.callback(libID, path, isCreate)
 	})
-	w.mu.Unlock()
 }
 
 func (w *Watcher) resolveLibrary(path string) uuid.UUID {
@@ -0,0 +1,306 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// catalog stands in for the scanner behind the watcher callback: a create
+// takes over the item of a missing file with the same size and inode, and
+// a removal deletes items whose file is gone.
+type catalog struct {
+	mu      sync.Mutex
+	items   map[string]catalogItem // path → item
+	removes int
+}
+
+type catalogItem struct {
+	id    uuid.UUID
+	size  int64
+	inode uint64
+}
+
+func newCatalog() *catalog {
+	return &catalog{items: make(map[string]catalogItem)}
+}
+
+func statItem(path string) (catalogItem, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return catalogItem{}, false
+	}
+	return catalogItem{size: info.Size(), inode: info.Sys().(*syscall.Stat_t).Ino}, true
+}
+
+// scan adds a file as a library scan would.
+func (c *catalog) scan(t *testing.T, path string) uuid.UUID {
+	t.Helper()
+	it, ok := statItem(path)
+	if !ok {
+		t.Fatalf("stat %s", path)
+	}
+	it.id = uuid.New()
+	c.mu.Lock()
+	c.items[path] = it
+	c.mu.Unlock()
+	return it.id
+}
+
+func (c *catalog) onEvent(_ uuid.UUID, path string, isCreate bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !isCreate {
+		c.removes++
+		for p := range c.items {
+			if p == path || strings.HasPrefix(p, path+string(filepath.Separator)) {
+				if _, err := os.Stat(p); os.IsNotExist(err) {
+					delete(c.items, p)
+				}
+			}
+		}
+		return
+	}
+	if _, ok := c.items[path]; ok {
+		return
+	}
+	it, ok := statItem(path)
+	if !ok {
+		return
+	}
+	for p, old := range c.items {
+		if _, err := os.Stat(p); os.IsNotExist(err) && old.size == it.size && old.inode == it.inode {
+			delete(c.items, p)
+			c.items[path] = old
+			return
+		}
+	}
+	it.id = uuid.New()
+	c.items[path] = it
+}
+
+func (c *catalog) id(path string) uuid.UUID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.items[path].id
+}
+
+func (c *catalog) removals() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.removes
+}
+
+// watch starts a watcher on root that reports to c, with short delays.
+func watch(t *testing.T, root string, c *catalog) {
+	t.Helper()
+	created, removed := createDelay, removeDelay
+	createDelay, removeDelay = 10*time.Millisecond, 200*time.Millisecond
+	t.Cleanup(func() { createDelay, removeDelay = created, removed })
+
+	w, err := New(nil, c.onEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.mu.Lock()
+	w.addRecursive(root, uuid.New())
+	w.mu.Unlock()
+	go w.eventLoop()
+	t.Cleanup(w.Stop)
+}
+
+func eventually(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcherKeepsMovedItems(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+	}{
+		{"rename", "Movie (2020)/Movie (2020).mkv", "Movie (2020)/Movie.2020.1080p.mkv"},
+		{"move to another folder", "Movie (2020)/Movie (2020).mkv", "Other/Movie (2020).mkv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			from, to := filepath.Join(root, tt.from), filepath.Join(root, tt.to)
+			writeFile(t, from, "movie")
+			writeFile(t, filepath.Join(root, "Other", "keep.mkv"), "other")
+			c := newCatalog()
+			id := c.scan(t, from)
+			watch(t, root, c)
+
+			if err := os.Rename(from, to); err != nil {
+				t.Fatal(err)
+			}
+			eventually(t, "the move to be picked up", func() bool { return c.id(to) != uuid.Nil })
+			if got := c.id(to); got != id {
+				t.Fatalf("item at the new path is %v, want %v", got, id)
+			}
+			// The delayed removal of the old path must leave it alone
+			eventually(t, "the removal of the old path", func() bool { return c.removals() > 0 })
+			if got := c.id(to); got != id {
+				t.Errorf("after the removal the item is %v, want %v", got, id)
+			}
+		})
+	}
+}
+
+func TestWatcherKeepsItemsInMovedFolder(t *testing.T) {
+	root := t.TempDir()
+	oldDir := filepath.Join(root, "Show", "Season 1")
+	newDir := filepath.Join(root, "Show (2019)", "Season 01")
+	c := newCatalog()
+	ids := make(map[string]uuid.UUID)
+	for _, name := range []string{"S01E01.mkv", "S01E02.mkv"} {
+		writeFile(t, filepath.Join(oldDir, name), name)
+		ids[name] = c.scan(t, filepath.Join(oldDir, name))
+	}
+	if err := os.MkdirAll(filepath.Dir(newDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	watch(t, root, c)
+
+	if err := os.Rename(oldDir, newDir); err != nil {
+		t.Fatal(err)
+	}
+	for name, id := range ids {
+		path := filepath.Join(newDir, name)
+		eventually(t, name+" to be picked up", func() bool { return c.id(path) != uuid.Nil })
+		if got := c.id(path); got != id {
+			t.Errorf("%s: item is %v, want %v", name, got, id)
+		}
+	}
+	eventually(t, "the removal of the old folder", func() bool { return c.removals() > 0 })
+	for name, id := range ids {
+		if got := c.id(filepath.Join(newDir, name)); got != id {
+			t.Errorf("%s: after the removal the item is %v, want %v", name, got, id)
+		}
+	}
+
+	// The moved folder is watched in its new place
+	added := filepath.Join(newDir, "S01E03.mkv")
+	writeFile(t, added, "S01E03")
+	eventually(t, "a file added to the moved folder", func() bool { return c.id(added) != uuid.Nil })
+}
+
+func TestWatcherRemovesDeleted(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "Movie (2020).mkv")
+	writeFile(t, path, "movie")
+	c := newCatalog()
+	c.scan(t, path)
+	watch(t, root, c)
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the item to be removed", func() bool { return c.id(path) == uuid.Nil })
+}
+
+func TestPollerDiff(t *testing.T) {
+	lastWalk := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	before, after := lastWalk.Add(-time.Hour), lastWalk.Add(time.Second)
+	known := func(size int64) fileState { return fileState{size: size, modTime: before} }
+	tests := []struct {
+		name     string
+		previous map[string]fileState
+		current  map[string]fileState
+		events   []string // "+" create, "-" removal, in order
+		pending  map[string]bool
+		ok       bool
+	}{
+		{"unchanged",
+			map[string]fileState{"a.mkv": known(10)},
+			map[string]fileState{"a.mkv": known(10)},
+			nil, nil, true},
+		{"new file being written",
+			map[string]fileState{},
+			map[string]fileState{"a.mkv": {size: 10, modTime: after}},
+			nil, map[string]bool{"a.mkv": true}, true},
+		{"new file still growing",
+			map[string]fileState{"a.mkv": {size: 10, modTime: after, pending: true}},
+			map[string]fileState{"a.mkv": {size: 20, modTime: after.Add(time.Second)}},
+			nil, map[string]bool{"a.mkv": true}, true},
+		{"new file held still",
+			map[string]fileState{"a.mkv": {size: 20, modTime: after, pending: true}},
+			map[string]fileState{"a.mkv": {size: 20, modTime: after}},
+			[]string{"+a.mkv"}, nil, true},
+		{"moved in",
+			map[string]fileState{"old/a.mkv": known(10)},
+			map[string]fileState{"new/a.mkv": known(10)},
+			[]string{"+new/a.mkv", "-old/a.mkv"}, nil, true},
+		{"known file replaced",
+			map[string]fileState{"a.mkv": known(10)},
+			map[string]fileState{"a.mkv": {size: 30, modTime: after}},
+			nil, map[string]bool{"a.mkv": true}, true},
+		{"replaced file held still",
+			map[string]fileState{"a.mkv": {size: 30, modTime: after, pending: true, known: true}},
+			map[string]fileState{"a.mkv": {size: 30, modTime: after}},
+			[]string{"+a.mkv"}, nil, true},
+		{"known file deleted",
+			map[string]fileState{"a.mkv": known(10), "b.mkv": known(10)},
+			map[string]fileState{"b.mkv": known(10)},
+			[]string{"-a.mkv"}, nil, true},
+		{"new file deleted before it held still",
+			map[string]fileState{"a.mkv": {size: 10, modTime: after, pending: true}, "b.mkv": known(10)},
+			map[string]fileState{"b.mkv": known(10)},
+			nil, nil, true},
+		{"replaced file deleted before it held still",
+			map[string]fileState{"a.mkv": {size: 30, modTime: after, pending: true, known: true}, "b.mkv": known(10)},
+			map[string]fileState{"b.mkv": known(10)},
+			[]string{"-a.mkv"}, nil, true},
+		{"empty mount",
+			map[string]fileState{"a.mkv": known(10), "b.mkv": known(10)},
+			map[string]fileState{},
+			nil, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []string
+			p := &poller{root: "/media", emit: func(_ uuid.UUID, path string, isCreate bool, _ time.Duration) {
+				if isCreate {
+					events = append(events, "+"+path)
+				} else {
+					events = append(events, "-"+path)
+				}
+			}}
+			if ok := p.diff(tt.previous, tt.current, lastWalk); ok != tt.ok {
+				t.Errorf("diff = %v, want %v", ok, tt.ok)
+			}
+			if strings.Join(events, " ") != strings.Join(tt.events, " ") {
+				t.Errorf("events %v, want %v", events, tt.events)
+			}
+			for path, st := range tt.current {
+				if st.pending != tt.pending[path] {
+					t.Errorf("%s pending = %v, want %v", path, st.pending, tt.pending[path])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS import_job_items;
+ALTER TABLE import_jobs DROP COLUMN IF EXISTS file_path;
@@ -0,0 +1,16 @@
+-- Plex/Jellyfin import: source database path and per-item unmatched report
+ALTER TABLE import_jobs ADD COLUMN IF NOT EXISTS file_path TEXT;
+
+CREATE TABLE IF NOT EXISTS import_job_items (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    job_id UUID NOT NULL REFERENCES import_jobs(id) ON DELETE CASCADE,
+    source_item_id TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    title TEXT NOT NULL DEFAULT '',
+    year INT,
+    file_path TEXT,
+    provider_ids JSONB,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_import_job_items_job ON import_job_items(job_id);
@@ -0,0 +1,4 @@
+DELETE FROM webhook_secrets WHERE service = 'readarr';
+ALTER TABLE webhook_secrets DROP CONSTRAINT IF EXISTS webhook_secrets_service_check;
+ALTER TABLE webhook_secrets ADD CONSTRAINT webhook_secrets_service_check
+    CHECK (service IN ('sonarr', 'radarr', 'lidarr', 'custom'));
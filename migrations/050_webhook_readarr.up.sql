@@ -0,0 +1,4 @@
+-- Allow Readarr webhook secrets (audiobook libraries)
+ALTER TABLE webhook_secrets DROP CONSTRAINT IF EXISTS webhook_secrets_service_check;
+ALTER TABLE webhook_secrets ADD CONSTRAINT webhook_secrets_service_check
+    CHECK (service IN ('sonarr', 'radarr', 'lidarr', 'readarr', 'custom'));
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_dvr_recordings_state_time;
+ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS completed_at;
+ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS started_at;
+ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS media_item_id;
+ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS error_message;
+ALTER TABLE epg_channels DROP COLUMN IF EXISTS stream_url;
+ALTER TABLE tuner_devices DROP COLUMN IF EXISTS tuner_count;
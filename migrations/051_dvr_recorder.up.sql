@@ -0,0 +1,9 @@
+-- DVR recorder: tuner limits, per-channel stream URLs and recording outcome
+ALTER TABLE tuner_devices ADD COLUMN IF NOT EXISTS tuner_count INT NOT NULL DEFAULT 2;
+ALTER TABLE epg_channels ADD COLUMN IF NOT EXISTS stream_url TEXT;
+
+ALTER TABLE dvr_recordings ADD COLUMN IF NOT EXISTS error_message TEXT;
+ALTER TABLE dvr_recordings ADD COLUMN IF NOT EXISTS media_item_id UUID REFERENCES media_items(id) ON DELETE SET NULL;
+ALTER TABLE dvr_recordings ADD COLUMN IF NOT EXISTS started_at TIMESTAMPTZ;
+ALTER TABLE dvr_recordings ADD COLUMN IF NOT EXISTS completed_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_dvr_recordings_state_time ON dvr_recordings(state, start_time);
@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS idx_epg_programs_end;
+DROP INDEX IF EXISTS idx_epg_programs_channel_start;
+ALTER TABLE epg_programs DROP COLUMN IF EXISTS is_new;
+ALTER TABLE epg_programs DROP COLUMN IF EXISTS series_id;
+ALTER TABLE epg_programs DROP COLUMN IF EXISTS external_id;
+ALTER TABLE epg_programs DROP COLUMN IF EXISTS sub_title;
+DROP INDEX IF EXISTS idx_epg_channels_tuner_number;
+ALTER TABLE epg_channels DROP COLUMN IF EXISTS guide_id;
+ALTER TABLE tuner_devices DROP COLUMN IF EXISTS guide_error;
+ALTER TABLE tuner_devices DROP COLUMN IF EXISTS guide_refreshed_at;
+ALTER TABLE tuner_devices DROP COLUMN IF EXISTS guide_url;
@@ -0,0 +1,30 @@
+-- EPG ingestion: guide sources per tuner and upsert keys for channels/programmes
+ALTER TABLE tuner_devices ADD COLUMN IF NOT EXISTS guide_url TEXT;
+ALTER TABLE tuner_devices ADD COLUMN IF NOT EXISTS guide_refreshed_at TIMESTAMPTZ;
+ALTER TABLE tuner_devices ADD COLUMN IF NOT EXISTS guide_error TEXT;
+
+ALTER TABLE epg_channels ADD COLUMN IF NOT EXISTS guide_id TEXT;
+-- Fold duplicate channels into the first of each (tuner, number) so their
+-- programmes and recordings survive the delete
+WITH dupes AS (
+    SELECT id, FIRST_VALUE(id) OVER (PARTITION BY tuner_id, channel_number ORDER BY id) AS keep_id
+    FROM epg_channels WHERE tuner_id IS NOT NULL
+)
+UPDATE epg_programs p SET channel_id = d.keep_id FROM dupes d WHERE p.channel_id = d.id AND d.id <> d.keep_id;
+WITH dupes AS (
+    SELECT id, FIRST_VALUE(id) OVER (PARTITION BY tuner_id, channel_number ORDER BY id) AS keep_id
+    FROM epg_channels WHERE tuner_id IS NOT NULL
+)
+UPDATE dvr_recordings r SET channel_id = d.keep_id FROM dupes d WHERE r.channel_id = d.id AND d.id <> d.keep_id;
+DELETE FROM epg_channels a USING epg_channels b
+    WHERE a.tuner_id = b.tuner_id AND a.channel_number = b.channel_number AND a.id > b.id;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_epg_channels_tuner_number ON epg_channels(tuner_id, channel_number);
+
+ALTER TABLE epg_programs ADD COLUMN IF NOT EXISTS sub_title TEXT;
+ALTER TABLE epg_programs ADD COLUMN IF NOT EXISTS external_id TEXT;
+ALTER TABLE epg_programs ADD COLUMN IF NOT EXISTS series_id TEXT;
+ALTER TABLE epg_programs ADD COLUMN IF NOT EXISTS is_new BOOLEAN NOT NULL DEFAULT FALSE;
+DELETE FROM epg_programs a USING epg_programs b
+    WHERE a.channel_id = b.channel_id AND a.start_time = b.start_time AND a.id > b.id;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_epg_programs_channel_start ON epg_programs(channel_id, start_time);
+CREATE INDEX IF NOT EXISTS idx_epg_programs_end ON epg_programs(end_time);
@@ -0,0 +1,9 @@
+DELETE FROM dvr_recordings WHERE state = 'expired';
+ALTER TABLE dvr_recordings DROP CONSTRAINT IF EXISTS dvr_recordings_state_check;
+ALTER TABLE dvr_recordings ADD CONSTRAINT dvr_recordings_state_check
+    CHECK (state IN ('scheduled', 'recording', 'completed', 'failed'));
+DROP INDEX IF EXISTS idx_dvr_recordings_rule;
+ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS episode_info;
+ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS external_id;
+ALTER TABLE dvr_recordings DROP COLUMN IF EXISTS rule_id;
+DROP TABLE IF EXISTS dvr_rules;
@@ -0,0 +1,26 @@
+-- DVR series recording rules, matched against the guide after each refresh
+CREATE TABLE IF NOT EXISTS dvr_rules (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    title TEXT NOT NULL,
+    series_id TEXT,
+    channel_id UUID REFERENCES epg_channels(id) ON DELETE CASCADE,
+    new_only BOOLEAN NOT NULL DEFAULT FALSE,
+    keep_count INT NOT NULL DEFAULT 0,
+    pad_start_minutes INT NOT NULL DEFAULT 0,
+    pad_end_minutes INT NOT NULL DEFAULT 0,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_dvr_rules_user ON dvr_rules(user_id);
+
+ALTER TABLE dvr_recordings ADD COLUMN IF NOT EXISTS rule_id UUID REFERENCES dvr_rules(id) ON DELETE SET NULL;
+ALTER TABLE dvr_recordings ADD COLUMN IF NOT EXISTS external_id TEXT;
+ALTER TABLE dvr_recordings ADD COLUMN IF NOT EXISTS episode_info TEXT;
+CREATE INDEX IF NOT EXISTS idx_dvr_recordings_rule ON dvr_recordings(rule_id);
+
+-- 'expired' recordings were deleted by keep_count but still count as
+-- recorded so the rule doesn't capture the same episode again
+ALTER TABLE dvr_recordings DROP CONSTRAINT IF EXISTS dvr_recordings_state_check;
+ALTER TABLE dvr_recordings ADD CONSTRAINT dvr_recordings_state_check
+    CHECK (state IN ('scheduled', 'recording', 'completed', 'failed', 'expired'));
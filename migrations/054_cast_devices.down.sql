@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_cast_sessions_user_media;
+ALTER TABLE cast_sessions DROP COLUMN IF EXISTS error_message;
+ALTER TABLE cast_sessions DROP COLUMN IF EXISTS volume;
+ALTER TABLE cast_sessions DROP COLUMN IF EXISTS device_address;
@@ -0,0 +1,12 @@
+-- Server-side Chromecast control: remember which receiver a session is on
+-- and the receiver's reported volume / last error
+ALTER TABLE cast_sessions ADD COLUMN IF NOT EXISTS device_address TEXT;
+ALTER TABLE cast_sessions ADD COLUMN IF NOT EXISTS volume FLOAT;
+ALTER TABLE cast_sessions ADD COLUMN IF NOT EXISTS error_message TEXT;
+
+-- handleCastSession upserts on (user_id, media_item_id); keep the newest row
+-- per pair so the unique index can be created
+DELETE FROM cast_sessions a USING cast_sessions b
+    WHERE a.user_id = b.user_id AND a.media_item_id = b.media_item_id
+      AND (a.updated_at, a.id) < (b.updated_at, b.id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cast_sessions_user_media ON cast_sessions(user_id, media_item_id);
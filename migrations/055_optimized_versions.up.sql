@@ -0,0 +1,24 @@
+-- Optimized versions: media items pre-transcoded to a playback profile for
+-- offline download and clients that can't direct play the original.
+-- media_item_id is nulled rather than cascaded so cleanup can still find
+-- the file of a version whose media item was removed.
+CREATE TABLE IF NOT EXISTS optimized_versions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    media_item_id UUID REFERENCES media_items(id) ON DELETE SET NULL,
+    profile VARCHAR(32) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    progress INT NOT NULL DEFAULT 0,
+    file_path TEXT,
+    file_size BIGINT NOT NULL DEFAULT 0,
+    width INT,
+    height INT,
+    bitrate BIGINT,
+    error_message TEXT,
+    requested_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ,
+    last_accessed_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_optimized_versions_media_profile ON optimized_versions(media_item_id, profile);
+CREATE INDEX IF NOT EXISTS idx_optimized_versions_status ON optimized_versions(status);
+CREATE INDEX IF NOT EXISTS idx_optimized_versions_requested_by ON optimized_versions(requested_by);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_media_items_library_size;
+ALTER TABLE media_items DROP COLUMN IF EXISTS file_inode;
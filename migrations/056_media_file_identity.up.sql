@@ -0,0 +1,5 @@
+-- File identity for move detection: file_hash holds a quick content hash
+-- (SHA-256 of the size and the first and last 64 KiB) and file_inode the
+-- inode, so a file that moves keeps its media item.
+ALTER TABLE media_items ADD COLUMN IF NOT EXISTS file_inode BIGINT;
+CREATE INDEX IF NOT EXISTS idx_media_items_library_size ON media_items(library_id, file_size);
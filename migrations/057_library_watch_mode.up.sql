@@ -0,0 +1,4 @@
+-- How a watched library notices changes: fsnotify events, polling (for
+-- NFS/SMB/FUSE mounts where changes made by other hosts raise no events)
+-- or both
+ALTER TABLE libraries ADD COLUMN IF NOT EXISTS watch_mode TEXT NOT NULL DEFAULT 'fsnotify';
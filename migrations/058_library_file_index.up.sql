@@ -0,0 +1,15 @@
+-- Per-file scan index: what the last scan saw of each file, so incremental
+-- scans skip unchanged files without a database lookup or ffprobe.
+-- probe_version records the scanner version that probed the file; bumping
+-- it re-probes everything on the next scan.
+CREATE TABLE IF NOT EXISTS library_files (
+    library_id UUID NOT NULL REFERENCES libraries(id) ON DELETE CASCADE,
+    file_path TEXT NOT NULL,
+    file_size BIGINT NOT NULL,
+    mod_time TIMESTAMPTZ NOT NULL,
+    probe_version INT NOT NULL DEFAULT 0,
+    media_item_id UUID REFERENCES media_items(id) ON DELETE CASCADE,
+    scanned_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (library_id, file_path)
+);
+CREATE INDEX IF NOT EXISTS idx_library_files_media_item ON library_files(media_item_id);
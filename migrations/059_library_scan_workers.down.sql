@@ -0,0 +1,3 @@
+ALTER TABLE libraries DROP COLUMN IF EXISTS scan_metadata_workers;
+ALTER TABLE libraries DROP COLUMN IF EXISTS scan_cpu_workers;
+ALTER TABLE libraries DROP COLUMN IF EXISTS scan_probe_workers;
@@ -0,0 +1,6 @@
+-- Per-library scan concurrency: how many files may be probed (local I/O),
+-- processed (CPU and database work) and matched against remote metadata
+-- sources at once. 0 uses the server default.
+ALTER TABLE libraries ADD COLUMN IF NOT EXISTS scan_probe_workers INT NOT NULL DEFAULT 0;
+ALTER TABLE libraries ADD COLUMN IF NOT EXISTS scan_cpu_workers INT NOT NULL DEFAULT 0;
+ALTER TABLE libraries ADD COLUMN IF NOT EXISTS scan_metadata_workers INT NOT NULL DEFAULT 0;
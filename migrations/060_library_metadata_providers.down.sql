@@ -0,0 +1,2 @@
+ALTER TABLE libraries DROP COLUMN IF EXISTS metadata_field_sources;
+ALTER TABLE libraries DROP COLUMN IF EXISTS metadata_providers;
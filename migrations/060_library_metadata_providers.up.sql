@@ -0,0 +1,4 @@
+-- Per-library metadata providers: the order providers are tried in, and
+-- per-field overrides of that order (field name → provider names)
+ALTER TABLE libraries ADD COLUMN IF NOT EXISTS metadata_providers TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE libraries ADD COLUMN IF NOT EXISTS metadata_field_sources JSONB NOT NULL DEFAULT '{}';
@@ -674,6 +674,35 @@ async function showEditLibraryForm(libId) {
                     <label><input type="radio" name="watchEnabled" value="no" ${!lib.watch_enabled?'checked':''}><span>No</span></label>
                 </div>
             </div>
+            <div class="option-row">
+                <div class="option-row-info">
+                    <div class="option-row-label">Watch Mode</div>
+                    <div class="option-row-desc">Polling finds changes made by other hosts on NFS/SMB/FUSE mounts, where filesystem events don't arrive</div>
+                </div>
+                <select id="editWatchMode" class="form-select" style="width:auto;">
+                    <option value="fsnotify" ${!lib.watch_mode||lib.watch_mode==='fsnotify'?'selected':''}>Filesystem Events</option>
+                    <option value="poll" ${lib.watch_mode==='poll'?'selected':''}>Polling</option>
+                    <option value="both" ${lib.watch_mode==='both'?'selected':''}>Both</option>
+                </select>
+            </div>
+            <div class="option-row">
+                <div class="option-row-info">
+                    <div class="option-row-label">Scan Workers</div>
+                    <div class="option-row-desc">Files probed, processed and matched against metadata sources at once; leave blank for the server default</div>
+                </div>
+                <div style="display:flex;gap:6px;">
+                    <input type="number" id="editScanProbeWorkers" min="0" max="64" placeholder="Probe" title="Probe workers" value="${lib.scan_probe_workers||''}" style="width:80px;">
+                    <input type="number" id="editScanCPUWorkers" min="0" max="64" placeholder="CPU" title="CPU workers" value="${lib.scan_cpu_workers||''}" style="width:80px;">
+                    <input type="number" id="editScanMetadataWorkers" min="0" max="64" placeholder="Metadata" title="Metadata workers" value="${lib.scan_metadata_workers||''}" style="width:80px;">
+                </div>
+            </div>
+            <div class="option-row">
+                <div class="option-row-info">
+                    <div class="option-row-label">Metadata Providers</div>
+                    <div class="option-row-desc">Providers to match against, in order, separated by commas (e.g. tmdb, omdb, fanarttv); leave blank for the defaults</div>
+                </div>
+                <input type="text" id="editMetadataProviders" placeholder="tmdb, omdb" value="${(lib.metadata_providers||[]).join(', ')}" style="width:250px;">
+            </div>
         </div>
 
         <button class="btn-primary" onclick="saveEditLibrary()">Save Changes</button>
@@ -720,6 +749,13 @@ async function saveEditLibrary() {
     const audio_normalization = document.querySelector('input[name="audioNormalization"]:checked')?.value === 'yes';
     const scan_interval = document.getElementById('editScanInterval')?.value || 'disabled';
     const watch_enabled = document.querySelector('input[name="watchEnabled"]:checked')?.value === 'yes';
+    const watch_mode = document.getElementById('editWatchMode')?.value || 'fsnotify';
+    const scanWorkers = id => parseInt(document.getElementById(id)?.value, 10) || 0;
+    const scan_probe_workers = scanWorkers('editScanProbeWorkers');
+    const scan_cpu_workers = scanWorkers('editScanCPUWorkers');
+    const scan_metadata_workers = scanWorkers('editScanMetadataWorkers');
+    const metadata_providers = (document.getElementById('editMetadataProviders')?.value || '')
+        .split(',').map(p => p.trim().toLowerCase()).filter(Boolean);
 
     let adult_content_type = null;
     if (media_type === 'adult_movies') {
@@ -732,7 +768,8 @@ async function saveEditLibrary() {
         include_in_homepage, include_in_search, retrieve_metadata,
         nfo_import, nfo_export, prefer_local_artwork,
         create_previews, create_thumbnails, audio_normalization, adult_content_type,
-        scan_interval, watch_enabled
+        scan_interval, watch_enabled, watch_mode,
+        scan_probe_workers, scan_cpu_workers, scan_metadata_workers, metadata_providers
     });
     if (d.success) { toast('Library updated!'); loadLibrariesView(); loadSidebarCounts(); }
     else toast('Failed: ' + d.error, 'error');
@@ -2,6 +2,7 @@
 let castSession = null;
 let castMediaId = null;
 let castProgressInterval = null;
+let serverCastId = null; // cast_sessions id when the server drives the receiver
 
 function initCast() {
     if (!window.chrome || !window.chrome.cast) return;
@@ -10,14 +11,14 @@ function initCast() {
         (session) => { castSession = session; toast('Connected to ' + session.receiver.friendlyName); },
         (availability) => {
             const btn = document.getElementById('castBtn');
-            if (btn) btn.style.display = availability === chrome.cast.ReceiverAvailability.AVAILABLE ? 'inline-block' : 'none';
+            if (btn && availability === chrome.cast.ReceiverAvailability.AVAILABLE) btn.style.display = 'inline-block';
         }
     );
     chrome.cast.initialize(apiConfig, () => {}, () => {});
 }
 
 async function startCast(mediaId, title) {
-    if (!window.chrome || !window.chrome.cast) { toast('Chromecast not available', 'error'); return; }
+    if (!window.chrome || !window.chrome.cast) { startServerCast(mediaId, title); return; }
     chrome.cast.requestSession((session) => {
         castSession = session;
         castMediaId = mediaId;
@@ -72,7 +73,39 @@ function startCastProgressPolling(mediaId) {
     }, 5000);
 }
 
+// Browsers without the Cast SDK: the server finds Chromecasts (mDNS) and
+// DLNA renderers (SSDP) and controls them directly
+async function startServerCast(mediaId, title) {
+    toast('Looking for devices...');
+    const [cc, dl] = await Promise.all([api('GET', '/cast/devices'), api('GET', '/dlna/renderers')]);
+    const devices = (cc.success ? cc.data.map(d => ({ name: d.name, type: 'chromecast', address: d.host + ':' + d.port })) : [])
+        .concat(dl.success ? dl.data.map(d => ({ name: d.name + ' (DLNA)', type: 'dlna', address: d.location })) : []);
+    if (devices.length === 0) { toast('No Cast or DLNA devices found', 'error'); return; }
+    let device = devices[0];
+    if (devices.length > 1) {
+        const pick = parseInt(prompt('Cast to:\n' + devices.map((d, i) => (i + 1) + '. ' + d.name).join('\n'), '1'));
+        if (!pick || !devices[pick - 1]) return;
+        device = devices[pick - 1];
+    }
+    const video = document.getElementById('videoPlayer');
+    const start = video && !isNaN(video.currentTime) ? Math.floor(video.currentTime) : 0;
+    const cr = await api('POST', '/cast/session', {
+        media_item_id: mediaId, device_name: device.name, device_type: device.type,
+        device_address: device.address, current_time: start
+    });
+    if (!cr.success) { toast(cr.error || 'Cast error', 'error'); return; }
+    serverCastId = cr.data.id;
+    castMediaId = mediaId;
+    if (video) video.pause();
+    toast('Casting: ' + title);
+}
+
 function castCommand(command, value) {
+    if (serverCastId) {
+        api('PUT', '/cast/session/' + serverCastId + '/command', { command: command, value: value || 0 });
+        if (command === 'stop') { serverCastId = null; castMediaId = null; }
+        return;
+    }
     if (!castSession || !castSession.media || castSession.media.length === 0) return;
     const media = castSession.media[0];
     switch (command) {
@@ -95,6 +128,7 @@ function castCommand(command, value) {
 
 function stopCast() {
     if (castProgressInterval) clearInterval(castProgressInterval);
+    if (serverCastId) { castCommand('stop'); toast('Cast stopped'); return; }
     if (castSession) { castSession.stop(() => {}, () => {}); castSession = null; castMediaId = null; toast('Cast stopped'); }
 }
 
@@ -157,15 +191,19 @@ async function loadLiveTVView() {
     const container = document.getElementById('liveTVContainer');
     if (!container) return;
 
+    const rules = await api('GET', '/livetv/recordings/rules');
+    window._epgPrograms = {};
+
     let html = '<div class="epg-grid">';
     if (epg.success && epg.data && epg.data.length > 0) {
         epg.data.forEach(ch => {
-            html += `<div class="epg-channel"><div class="epg-channel-name">${ch.icon_url ? '<img src="'+ch.icon_url+'" class="epg-icon">' : ''}${ch.channel_number} ${ch.name}${ch.is_favorite ? ' ★' : ''}</div><div class="epg-programs">`;
+            html += `<div class="epg-channel"><div class="epg-channel-name" style="cursor:pointer;" title="Watch live" onclick="playChannel('${ch.id}', '${(ch.channel_number + ' ' + ch.name).replace(/'/g, "\\'")}')">${ch.icon_url ? '<img src="'+ch.icon_url+'" class="epg-icon">' : ''}&#9654; ${ch.channel_number} ${ch.name}${ch.is_favorite ? ' ★' : ''}</div><div class="epg-programs">`;
             if (ch.programs) {
                 ch.programs.forEach(p => {
                     const start = new Date(p.start_time).toLocaleTimeString([], {hour:'2-digit',minute:'2-digit'});
                     const end = new Date(p.end_time).toLocaleTimeString([], {hour:'2-digit',minute:'2-digit'});
-                    html += `<div class="epg-program" title="${p.description||''}">${start}-${end} <strong>${p.title}</strong>${p.category ? ' <span class="tag tag-blue">'+p.category+'</span>' : ''}</div>`;
+                    window._epgPrograms[p.id] = Object.assign({ channel_id: ch.id }, p);
+                    html += `<div class="epg-program" style="cursor:pointer;" title="${p.description||''}" onclick="recordProgram('${p.id}')">${start}-${end} <strong>${p.title}</strong>${p.episode_info ? ' '+p.episode_info : ''}${p.is_new ? ' <span class="tag tag-green">New</span>' : ''}${p.category ? ' <span class="tag tag-blue">'+p.category+'</span>' : ''}</div>`;
                 });
             } else {
                 html += '<div class="epg-program">No program data</div>';
@@ -181,15 +219,51 @@ async function loadLiveTVView() {
     if (rec.success && rec.data && rec.data.length > 0) {
         html += '<h3 style="margin-top:24px;">DVR Recordings</h3><div class="recordings-list">';
         rec.data.forEach(r => {
-            const stateClass = r.state === 'recording' ? 'tag-red' : r.state === 'completed' ? 'tag-green' : 'tag-yellow';
+            const stateClass = r.state === 'recording' ? 'tag-red' : r.state === 'completed' ? 'tag-green' : r.state === 'failed' || r.state === 'expired' ? 'tag-orange' : 'tag-yellow';
             html += `<div class="recording-item"><strong>${r.title}</strong><span class="tag ${stateClass}">${r.state}</span><span class="text-muted">${new Date(r.start_time).toLocaleDateString()}</span></div>`;
         });
         html += '</div>';
     }
 
+    // Series rules
+    if (rules.success && rules.data && rules.data.length > 0) {
+        html += '<h3 style="margin-top:24px;">Series Rules</h3><div class="recordings-list">';
+        rules.data.forEach(r => {
+            const opts = [r.new_only ? 'new only' : 'all airings', r.keep_count ? 'keep ' + r.keep_count : 'keep all'];
+            if (r.pad_start_minutes || r.pad_end_minutes) opts.push(`pad -${r.pad_start_minutes}/+${r.pad_end_minutes} min`);
+            html += `<div class="recording-item"><strong>${r.title}</strong><span class="text-muted">${opts.join(' · ')}</span><button class="btn-danger btn-small" style="margin-left:auto;" onclick="deleteRecordingRule('${r.id}')">Delete</button></div>`;
+        });
+        html += '</div>';
+    }
+
     container.innerHTML = html;
 }
 
+async function recordProgram(programId) {
+    const p = window._epgPrograms[programId];
+    if (!p) return;
+    if (confirm(`Record every new episode of "${p.title}"?\n\nCancel to record just this airing.`)) {
+        const keep = parseInt(prompt('Keep how many episodes? (0 = keep all)', '0')) || 0;
+        const d = await api('POST', '/livetv/recordings/rules', {
+            title: p.title, series_id: p.series_id || '', new_only: true, keep_count: keep,
+            pad_start_minutes: 1, pad_end_minutes: 2
+        });
+        if (d.success) { toast(`Series rule created — ${d.data.scheduled} airing(s) scheduled`); loadLiveTVView(); } else toast(d.error, 'error');
+        return;
+    }
+    if (!confirm(`Record "${p.title}" once?`)) return;
+    const d = await api('POST', '/livetv/recordings', {
+        channel_id: p.channel_id, program_id: p.id, title: p.title, start_time: p.start_time, end_time: p.end_time
+    });
+    if (d.success) { toast('Recording scheduled'); loadLiveTVView(); } else toast(d.error, 'error');
+}
+
+async function deleteRecordingRule(id) {
+    if (!confirm('Delete this series rule? Pending recordings are cancelled; finished ones are kept.')) return;
+    const d = await api('DELETE', '/livetv/recordings/rules/' + id);
+    if (d.success) { toast('Rule deleted'); loadLiveTVView(); } else toast(d.error, 'error');
+}
+
 // ──── Comic / eBook Reader (P15-06) ────
 async function openReader(mediaId, title) {
     const mc = document.getElementById('mainContent');
@@ -67,17 +67,9 @@ const musicPlayer = {
         this.initAudioContext();
         if (this.audioCtx.state === 'suspended') this.audioCtx.resume();
         const track = this.queue[this.currentIndex];
-        const token = localStorage.getItem('token');
-        this.audio.src = '/api/v1/stream/' + track.id + '/direct?token=' + encodeURIComponent(token);
-
-        try {
-            const info = await api('GET', '/stream/' + track.id + '/info');
-            if (info.success && info.data && info.data.loudness_gain_db !== undefined) {
-                this.setGainDB(info.data.loudness_gain_db);
-            } else {
-                this.setGainDB(0);
-            }
-        } catch(e) { this.setGainDB(0); }
+        const src = await this.audioSource(this.currentIndex);
+        this.audio.src = src.url;
+        this.setGainDB(src.gainDB);
 
         this.audio.play();
         this.isPlaying = true;
@@ -87,6 +79,51 @@ const musicPlayer = {
         api('POST', '/watch/' + track.id + '/progress', { progress_seconds: 0, duration_seconds: track.duration_seconds || 0 });
     },
 
+    // Tracks next to one from the same album are an album run and use album
+    // gain, so quiet and loud tracks keep their intended balance
+    gainMode(index) {
+        const track = this.queue[index];
+        if (!track || !track.album_id) return 'track';
+        const prev = this.queue[index - 1], next = this.queue[index + 1];
+        if ((prev && prev.album_id === track.album_id) || (next && next.album_id === track.album_id)) return 'album';
+        return 'track';
+    },
+
+    // Originals the browser can decode are played as they are with gain
+    // applied here; anything else (ALAC in most browsers, WMA, APE) is
+    // converted to AAC by the server with the gain baked in.
+    canPlayCodec(codec) {
+        const types = {
+            flac: 'audio/flac', mp3: 'audio/mpeg', aac: 'audio/mp4; codecs="mp4a.40.2"',
+            alac: 'audio/mp4; codecs="alac"', opus: 'audio/ogg; codecs="opus"',
+            vorbis: 'audio/ogg; codecs="vorbis"', pcm_s16le: 'audio/wav', pcm_s24le: 'audio/wav'
+        };
+        return !!types[codec] && this.audio.canPlayType(types[codec]) !== '';
+    },
+
+    async audioSource(index) {
+        const track = this.queue[index];
+        const token = localStorage.getItem('token');
+        const mode = this.gainMode(index);
+        let info = null;
+        try {
+            const res = await api('GET', '/stream/' + track.id + '/info');
+            if (res.success && res.data) info = res.data;
+        } catch(e) {}
+
+        let url = '/api/v1/stream/' + track.id + '/audio?token=' + encodeURIComponent(token);
+        if (info && info.audio_codec && !this.canPlayCodec(info.audio_codec)) {
+            url += '&format=aac&gain=' + (info.loudness_gain_db !== undefined ? mode : 'off');
+            return { url, gainDB: 0 };
+        }
+        let gainDB = 0;
+        if (info) {
+            if (mode === 'album' && info.album_gain_db !== undefined) gainDB = info.album_gain_db;
+            else if (info.loudness_gain_db !== undefined) gainDB = info.loudness_gain_db;
+        }
+        return { url, gainDB };
+    },
+
     toggle() {
         if (this.isPlaying) { this.audio.pause(); this.isPlaying = false; }
         else {
@@ -99,20 +136,12 @@ const musicPlayer = {
     async preBufferNext() {
         this._nextGainDB = null;
         if (this.currentIndex < this.queue.length - 1) {
-            const next = this.queue[this.currentIndex + 1];
-            const token = localStorage.getItem('token');
-            this.nextAudio.src = '/api/v1/stream/' + next.id + '/direct?token=' + encodeURIComponent(token);
+            // Resolve the source and gain now so there's no async delay at
+            // the gapless transition
+            const src = await this.audioSource(this.currentIndex + 1);
+            this.nextAudio.src = src.url;
             this.nextAudio.preload = 'auto';
-
-            // Pre-fetch gain for gapless crossover so no async delay at transition
-            try {
-                const info = await api('GET', '/stream/' + next.id + '/info');
-                if (info.success && info.data && info.data.loudness_gain_db !== undefined) {
-                    this._nextGainDB = info.data.loudness_gain_db;
-                } else {
-                    this._nextGainDB = 0;
-                }
-            } catch(e) { this._nextGainDB = 0; }
+            this._nextGainDB = src.gainDB;
         }
     },
 
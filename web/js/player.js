@@ -10,6 +10,10 @@ let currentStreamInfo = null;
 let currentPlayMode = null; // 'direct', 'mpegts', 'hls'
 let knownDuration = 0; // Total duration from DB
 let seekOffset = 0; // FFmpeg -ss offset for MPEGTS streams
+let hlsQuality = null; // Quality of the current HLS transcode
+let remuxMode = ''; // 'remux' or 'audio' when PlaybackInfo asked for it
+let currentPlaybackInfo = null; // Server playback decision for the current item
+let hlsSessions = new Set(); // Server transcode sessions this player opened
 
 // ── Audio Normalization State ──
 let videoAudioCtx = null;
@@ -131,6 +135,7 @@ function skipCurrentSegment() {
 // Destroy all active players
 function destroyPlayers() {
     if (hlsPlayer) { hlsPlayer.destroy(); hlsPlayer = null; }
+    stopTranscodeSessions();
     if (mpegtsPlayer) {
         mpegtsPlayer.pause();
         mpegtsPlayer.unload();
@@ -291,8 +296,18 @@ async function playMediaDirect(mediaId, title) {
         setVideoGainDB(0);
     }
 
-    // Start playback — MPEGTS for non-native formats, direct for native
-    if (currentStreamInfo && currentStreamInfo.needs_remux) {
+    // Start playback the way the server decided for this browser
+    const pb = await api('POST', `/stream/${mediaId}/playback-info`, { device_profile: browserDeviceProfile() });
+    currentPlaybackInfo = pb.success ? pb.data : null;
+    remuxMode = '';
+    if (currentPlaybackInfo && currentPlaybackInfo.method === 'transcode') {
+        const q = currentPlaybackInfo.quality || '720p';
+        sel.value = 'transcode:' + q;
+        startHLSPlay(mediaId, q, token);
+    } else if (currentPlaybackInfo && currentPlaybackInfo.method !== 'direct_play') {
+        remuxMode = currentPlaybackInfo.method === 'audio_transcode' ? 'audio' : 'remux';
+        startMpegtsPlay(mediaId, token, 0);
+    } else if (!currentPlaybackInfo && currentStreamInfo && currentStreamInfo.needs_remux) {
         startMpegtsPlay(mediaId, token, 0);
     } else {
         startDirectPlay(mediaId, token, 0);
@@ -319,6 +334,34 @@ function playDirect(mediaId, title) {
     video.addEventListener('pause', updatePlayPauseIcon);
 }
 
+// What this browser can play, for the server's playback decision
+function browserDeviceProfile() {
+    const v = document.createElement('video');
+    const mse = window.MediaSource && MediaSource.isTypeSupported ? t => MediaSource.isTypeSupported(t) : () => false;
+    const can = t => v.canPlayType(t) !== '' || mse(t);
+    const videoCodecs = ['h264'];
+    if (can('video/mp4; codecs="hvc1.1.6.L120.90"')) videoCodecs.push('hevc');
+    if (can('video/webm; codecs="vp8"')) videoCodecs.push('vp8');
+    if (can('video/webm; codecs="vp9"')) videoCodecs.push('vp9');
+    if (can('video/mp4; codecs="av01.0.05M.08"')) videoCodecs.push('av1');
+    const audioCodecs = ['aac', 'mp3'];
+    if (can('audio/webm; codecs="opus"')) audioCodecs.push('opus');
+    if (can('audio/webm; codecs="vorbis"')) audioCodecs.push('vorbis');
+    if (can('audio/mp4; codecs="flac"')) audioCodecs.push('flac');
+    if (can('audio/mp4; codecs="ac-3"')) audioCodecs.push('ac3');
+    if (can('audio/mp4; codecs="ec-3"')) audioCodecs.push('eac3');
+    const hdr = window.matchMedia && window.matchMedia('(dynamic-range: high)').matches;
+    return {
+        name: 'Browser',
+        containers: ['mp4', 'm4v', 'webm'],
+        video_codecs: videoCodecs,
+        audio_codecs: audioCodecs,
+        max_audio_channels: 6,
+        hdr_formats: hdr ? ['HDR10', 'HLG'] : [],
+        subtitle_formats: ['webvtt'],
+    };
+}
+
 // Direct play for native browser formats (MP4/WebM) — supports range requests & seeking
 function startDirectPlay(mediaId, token, startSec) {
     const video = document.getElementById('videoPlayer');
@@ -326,7 +369,11 @@ function startDirectPlay(mediaId, token, startSec) {
     currentPlayMode = 'direct';
     seekOffset = 0;
 
-    const url = `/api/v1/stream/${mediaId}/direct?token=${encodeURIComponent(token)}`;
+    let url = `/api/v1/stream/${mediaId}/direct?token=${encodeURIComponent(token)}&device=${encodeURIComponent(playerDeviceId())}`;
+    // PlaybackInfo may have picked an optimized version of the file
+    if (currentPlaybackInfo && currentPlaybackInfo.version_id) {
+        url += `&version=${currentPlaybackInfo.version_id}`;
+    }
     video.src = url;
     if (startSec > 0) {
         video.currentTime = startSec;
@@ -344,7 +391,7 @@ function startMpegtsPlay(mediaId, token, startSec) {
     currentPlayMode = 'mpegts';
     seekOffset = startSec || 0;
 
-    let url = `/api/v1/stream/${mediaId}/direct?token=${encodeURIComponent(token)}`;
+    let url = `/api/v1/stream/${mediaId}/direct?token=${encodeURIComponent(token)}&device=${encodeURIComponent(playerDeviceId())}`;
     if (seekOffset > 0) {
         url += `&start=${seekOffset.toFixed(1)}`;
     }
@@ -352,6 +399,9 @@ function startMpegtsPlay(mediaId, token, startSec) {
     if (currentStreamInfo && currentStreamInfo.selectedAudioTrack !== undefined) {
         url += `&audio=${currentStreamInfo.selectedAudioTrack}`;
     }
+    if (remuxMode) {
+        url += `&mode=${remuxMode}`;
+    }
 
     mpegtsPlayer = mpegts.createPlayer({
         type: 'mpegts',
@@ -383,13 +433,37 @@ function startMpegtsPlay(mediaId, token, startSec) {
 }
 
 // HLS play for quality-specific transcodes
-function startHLSPlay(mediaId, quality, token) {
+// Transcode sessions are per device, so the server doesn't hand this tab's
+// stream to another one of the user's players
+function playerDeviceId() {
+    let id = localStorage.getItem('device_id');
+    if (!id) {
+        id = (crypto.randomUUID ? crypto.randomUUID() : Date.now().toString(36) + Math.random().toString(36).slice(2));
+        localStorage.setItem('device_id', id);
+    }
+    return id;
+}
+
+// Stop the server-side transcodes of the HLS stream being torn down
+function stopTranscodeSessions() {
+    hlsSessions.forEach(id => api('DELETE', '/stream/sessions/' + encodeURIComponent(id)));
+    hlsSessions.clear();
+}
+
+function startHLSPlay(mediaId, quality, token, startAt) {
     const video = document.getElementById('videoPlayer');
     destroyPlayers();
     currentPlayMode = 'hls';
     seekOffset = 0;
+    hlsQuality = quality;
 
-    const masterUrl = `/api/v1/stream/${mediaId}/master.m3u8?token=${encodeURIComponent(token)}`;
+    let masterUrl = `/api/v1/stream/${mediaId}/master.m3u8?token=${encodeURIComponent(token)}&device=${encodeURIComponent(playerDeviceId())}`;
+    if (currentStreamInfo && currentStreamInfo.selectedAudioTrack !== undefined) {
+        masterUrl += `&audio=${currentStreamInfo.selectedAudioTrack}`;
+    }
+    if (currentPlaybackInfo && currentPlaybackInfo.tone_map) {
+        masterUrl += '&hdr=false';
+    }
     hlsPlayer = new Hls({
         xhrSetup: (xhr, url) => {
             const sep = url.includes('?') ? '&' : '?';
@@ -398,6 +472,11 @@ function startHLSPlay(mediaId, quality, token) {
     });
     hlsPlayer.loadSource(masterUrl);
     hlsPlayer.attachMedia(video);
+    hlsPlayer.on(Hls.Events.LEVEL_LOADED, (event, data) => {
+        const xhr = data.networkDetails;
+        const id = xhr && xhr.getResponseHeader ? xhr.getResponseHeader('X-Transcode-Session') : null;
+        if (id) hlsSessions.add(id);
+    });
     hlsPlayer.on(Hls.Events.MANIFEST_PARSED, () => {
         const levels = hlsPlayer.levels;
         for (let i = 0; i < levels.length; i++) {
@@ -406,6 +485,7 @@ function startHLSPlay(mediaId, quality, token) {
                 break;
             }
         }
+        if (startAt > 0) video.currentTime = startAt;
         video.play().catch(() => {});
     });
     hlsPlayer.on(Hls.Events.ERROR, (event, data) => {
@@ -421,10 +501,49 @@ function startHLSPlay(mediaId, quality, token) {
     });
 }
 
+// Live TV channel playback — timeshift playlist so the player can pause and
+// rewind within the server's on-disk buffer
+function playChannel(channelId, title) {
+    const overlay = document.getElementById('playerOverlay');
+    const video = document.getElementById('videoPlayer');
+    document.getElementById('playerTitle').textContent = title;
+    overlay.classList.add('active');
+    const token = localStorage.getItem('token');
+    destroyPlayers();
+    currentPlayMode = 'live';
+    seekOffset = 0;
+
+    const url = `/api/v1/livetv/channels/${channelId}/stream/timeshift.m3u8`;
+    hlsPlayer = new Hls({
+        liveSyncDurationCount: 3,
+        xhrSetup: (xhr, u) => {
+            const sep = u.includes('?') ? '&' : '?';
+            xhr.open('GET', u + sep + 'token=' + encodeURIComponent(token), true);
+        }
+    });
+    hlsPlayer.loadSource(url);
+    hlsPlayer.attachMedia(video);
+    hlsPlayer.on(Hls.Events.MANIFEST_PARSED, () => video.play().catch(() => {}));
+    hlsPlayer.on(Hls.Events.ERROR, (event, data) => {
+        if (!data.fatal) return;
+        if (data.response && data.response.code === 503) {
+            toast('All tuners are busy', 'error');
+            closePlayer();
+        } else if (data.type === Hls.ErrorTypes.NETWORK_ERROR) {
+            toast('Tuning... retrying in 3s', 'info');
+            setTimeout(() => hlsPlayer && hlsPlayer.startLoad(), 3000);
+        } else {
+            toast('Playback error: ' + data.details, 'error');
+        }
+    });
+}
+
 function changeQuality(value) {
     const token = localStorage.getItem('token');
     if (value === 'direct') {
-        if (currentStreamInfo && currentStreamInfo.needs_remux) {
+        // "Original" means the original file, not an optimized version
+        if (currentPlaybackInfo) currentPlaybackInfo.version_id = null;
+        if (remuxMode || (currentStreamInfo && currentStreamInfo.needs_remux)) {
             startMpegtsPlay(currentMediaId, token, 0);
         } else {
             startDirectPlay(currentMediaId, token, 0);
@@ -444,7 +563,7 @@ function startDASHPlay(mediaId, token) {
     destroyPlayers();
     if (typeof dashjs !== 'undefined') {
         dashPlayer = dashjs.MediaPlayer().create();
-        dashPlayer.initialize(video, '/api/v1/stream/' + mediaId + '/manifest.mpd?token=' + encodeURIComponent(token), true);
+        dashPlayer.initialize(video, '/api/v1/stream/' + mediaId + '/manifest.mpd?token=' + encodeURIComponent(token) + '&device=' + encodeURIComponent(playerDeviceId()), true);
         currentPlayMode = 'dash';
     } else {
         toast('DASH.js not loaded, falling back to HLS', 'warning');
@@ -518,6 +637,9 @@ function closePlayer() {
     currentMediaId = null;
     currentStreamInfo = null;
     currentPlayMode = null;
+    hlsQuality = null;
+    remuxMode = '';
+    currentPlaybackInfo = null;
     knownDuration = 0;
     seekOffset = 0;
     currentSegments = [];
@@ -553,6 +675,7 @@ function updatePlayerUI() {
 }
 
 function togglePlay() {
+    if (typeof syncHandleLocal === 'function' && syncHandleLocal('toggle')) return;
     const v=document.getElementById('videoPlayer');
     v.paused?v.play():v.pause();
     updatePlayPauseIcon();
@@ -568,6 +691,7 @@ function updatePlayPauseIcon() {
 
 function skipBack() {
     const video = document.getElementById('videoPlayer');
+    if (typeof syncHandleLocal === 'function' && syncHandleLocal('seek', video.currentTime + seekOffset - 10)) return;
     if (currentPlayMode === 'mpegts') {
         // MPEGTS: restart stream from new position
         const target = Math.max(0, video.currentTime + seekOffset - 10);
@@ -579,6 +703,7 @@ function skipBack() {
 
 function skipForward() {
     const video = document.getElementById('videoPlayer');
+    if (typeof syncHandleLocal === 'function' && syncHandleLocal('seek', video.currentTime + seekOffset + 10)) return;
     if (currentPlayMode === 'mpegts') {
         // MPEGTS: restart stream from new position
         const target = video.currentTime + seekOffset + 10;
@@ -654,8 +779,9 @@ function changeAudioTrack(streamIndex) {
         const currentTime = document.getElementById('videoPlayer').currentTime + seekOffset;
         startMpegtsPlay(currentMediaId, token, currentTime);
     } else if (currentPlayMode === 'hls') {
-        // HLS transcode: audio selection handled server-side on next segment request
-        toast('Audio track will apply on next transcode start', 'info');
+        // A different audio track is a different transcode session
+        const currentTime = document.getElementById('videoPlayer').currentTime;
+        startHLSPlay(currentMediaId, hlsQuality || '720p', token, currentTime);
     }
 }
 
@@ -708,6 +834,7 @@ function seekPlayer(e) {
     if (totalDuration <= 0) return;
 
     const targetTime = pct * totalDuration;
+    if (typeof syncHandleLocal === 'function' && syncHandleLocal('seek', targetTime)) return;
 
     if (currentPlayMode === 'mpegts') {
         // MPEGTS: restart FFmpeg from seek position (Plex-style)
@@ -12,13 +12,104 @@ function togglePiP() {
 }
 
 // ──── Watch Together / SyncPlay (P12-01) ────
+// Playback is coordinated by the server over the WebSocket: the host's
+// play/pause/seek go through a buffering barrier, everyone starts at a
+// scheduled time, and drift is corrected per client.
 let currentSyncSession = null;
+let syncIsHost = false;
+let syncApplying = false;     // true while applying a server command (don't echo it back)
+let syncGroupState = 'paused';
+let syncPositionTimer = null;
+let syncRateTimer = null;
+
+function syncSend(event, data) {
+    if (ws && ws.readyState === WebSocket.OPEN) ws.send(JSON.stringify({ event, data }));
+}
+
+function syncVideoPosition() {
+    const video = document.getElementById('videoPlayer');
+    return video ? video.currentTime + (currentPlayMode === 'mpegts' ? seekOffset : 0) : 0;
+}
+
+function syncAttach(session) {
+    currentSyncSession = session;
+    syncIsHost = !!session.is_host;
+    syncSend('sync:join', { session_id: session.session_id });
+    const video = document.getElementById('videoPlayer');
+    if (video && !video._syncHooked) {
+        video._syncHooked = true;
+        video.addEventListener('waiting', () => {
+            if (currentSyncSession && !syncApplying && syncGroupState === 'playing') {
+                syncSend('sync:buffering', { session_id: currentSyncSession.session_id, position: syncVideoPosition() });
+            }
+        });
+    }
+    clearInterval(syncPositionTimer);
+    syncPositionTimer = setInterval(() => {
+        const v = document.getElementById('videoPlayer');
+        if (currentSyncSession && v && !v.paused) {
+            syncSend('sync:position', { session_id: currentSyncSession.session_id, position: syncVideoPosition(), client_time: Date.now() });
+        }
+    }, 2000);
+}
+
+function syncDetach() {
+    clearInterval(syncPositionTimer);
+    clearTimeout(syncRateTimer);
+    const video = document.getElementById('videoPlayer');
+    if (video) video.playbackRate = 1;
+    currentSyncSession = null;
+    syncGroupState = 'paused';
+}
+
+// Called by the player controls; returns true when the action was routed
+// to the sync session instead of being applied locally.
+function syncHandleLocal(action, position) {
+    if (!currentSyncSession || syncApplying) return false;
+    if (!syncIsHost) {
+        toast('Only the host can control playback');
+        return true;
+    }
+    const id = currentSyncSession.session_id;
+    if (action === 'toggle') {
+        syncSend(syncGroupState === 'paused' ? 'sync:play' : 'sync:pause', { session_id: id });
+    } else if (action === 'seek') {
+        syncSend('sync:seek', { session_id: id, position: Math.max(0, position) });
+    }
+    return true;
+}
+
+function syncApply(fn) {
+    syncApplying = true;
+    try { fn(); } finally { setTimeout(() => { syncApplying = false; }, 0); }
+}
+
+// Pause at position and report ready once enough is buffered to play.
+function syncPrepare(position) {
+    const video = document.getElementById('videoPlayer');
+    if (!video) return;
+    syncApply(() => {
+        video.pause();
+        if (Math.abs(syncVideoPosition() - position) > 0.5) seekToTime(position);
+    });
+    const id = currentSyncSession.session_id;
+    const check = () => {
+        if (!currentSyncSession || currentSyncSession.session_id !== id) return;
+        if (video.readyState >= 3) {
+            syncApply(() => video.pause()); // mpegts restarts autoplay
+            syncSend('sync:ready', { session_id: id, position: syncVideoPosition() });
+        } else {
+            setTimeout(check, 250);
+        }
+    };
+    setTimeout(check, 250);
+}
 
 async function createSyncSession(mediaId) {
     const res = await api('POST', '/sync/create', { media_item_id: mediaId });
     if (!res.success) { toast(res.error || 'Failed to create session', 'error'); return; }
-    currentSyncSession = res.data;
     toast('Watch Together session created! Code: ' + res.data.invite_code);
+    syncAttach({ ...res.data, is_host: true });
     showSyncPanel(res.data.session_id, true);
 }
 
@@ -27,9 +118,9 @@ async function joinSyncSession() {
     if (!code) return;
     const res = await api('POST', '/sync/join', { invite_code: code.trim() });
     if (!res.success) { toast(res.error || 'Failed to join session', 'error'); return; }
-    currentSyncSession = res.data;
     toast('Joined Watch Together session!');
-    playMediaDirect(res.data.media_item_id, 'Watch Together');
+    await playMediaDirect(res.data.media_item_id, 'Watch Together');
+    syncAttach({ ...res.data, is_host: false });
     showSyncPanel(res.data.session_id, false);
 }
 
@@ -47,7 +138,7 @@ function showSyncPanel(sessionId, isHost) {
             <span>Watch Together</span>
             <button onclick="endSyncSession('${sessionId}')" class="sync-close">&times;</button>
         </div>
-        <div class="sync-code">Code: <strong>${currentSyncSession.invite_code || ''}</strong></div>
+        <div class="sync-code">Code: <strong>${currentSyncSession.invite_code || ''}</strong> <span id="syncRole">${isHost ? '(host)' : ''}</span></div>
         <div id="syncChat" class="sync-chat"></div>
         <div class="sync-input-row">
             <input type="text" id="syncChatInput" placeholder="Chat..." onkeypress="if(event.key==='Enter')sendSyncChat('${sessionId}')">
@@ -64,22 +155,73 @@ async function sendSyncChat(sessionId) {
 }
 
 async function endSyncSession(sessionId) {
-    await api('DELETE', '/sync/' + sessionId);
+    if (syncIsHost) {
+        await api('DELETE', '/sync/' + sessionId);
+    } else {
+        syncSend('sync:leave', { session_id: sessionId });
+    }
     const panel = document.getElementById('syncPanel');
     if (panel) panel.style.display = 'none';
-    currentSyncSession = null;
+    syncDetach();
 }
 
 // Listen for sync WebSocket events
 function handleSyncWSMessage(event, data) {
-    if (event === 'sync:action' && currentSyncSession) {
-        const video = document.getElementById('videoPlayer');
-        if (!video) return;
-        if (data.action === 'play') video.play();
-        else if (data.action === 'pause') video.pause();
-        else if (data.action === 'seek') video.currentTime = data.current_time;
+    if (!currentSyncSession || (data.session_id && data.session_id !== currentSyncSession.session_id)) {
+        if (event !== 'sync:ping') return;
     }
-    if (event === 'sync:chat' && currentSyncSession) {
+    const video = document.getElementById('videoPlayer');
+    switch (event) {
+    case 'sync:ping':
+        syncSend('sync:pong', { ping_id: data.ping_id, client_time: Date.now() });
+        break;
+    case 'sync:state':
+        syncGroupState = data.state;
+        if (currentUser) syncIsHost = data.host_id === currentUser.id;
+        if (!video) break;
+        syncApply(() => {
+            if (Math.abs(syncVideoPosition() - data.position) > 1) seekToTime(data.position);
+            if (data.state === 'playing') video.play(); else video.pause();
+        });
+        break;
+    case 'sync:command':
+        if (!video) break;
+        if (data.command === 'wait') {
+            syncGroupState = 'waiting';
+            syncPrepare(data.position);
+        } else if (data.command === 'play') {
+            syncGroupState = 'playing';
+            const delay = Math.max(0, data.at_client - Date.now());
+            setTimeout(() => syncApply(() => video.play()), delay);
+        } else if (data.command === 'pause') {
+            syncGroupState = 'paused';
+            syncApply(() => {
+                video.pause();
+                if (Math.abs(syncVideoPosition() - data.position) > 0.5) seekToTime(data.position);
+            });
+        }
+        break;
+    case 'sync:correct':
+        if (!video) break;
+        if (data.mode === 'seek') {
+            syncApply(() => seekToTime(data.position));
+        } else if (data.mode === 'rate') {
+            video.playbackRate = data.rate;
+            clearTimeout(syncRateTimer);
+            syncRateTimer = setTimeout(() => { video.playbackRate = 1; }, data.duration_ms);
+        }
+        break;
+    case 'sync:host': {
+        syncIsHost = !!currentUser && data.host_id === currentUser.id;
+        const role = document.getElementById('syncRole');
+        if (role) role.textContent = syncIsHost ? '(host)' : '';
+        if (syncIsHost) toast('You are now the Watch Together host');
+        break;
+    }
+    case 'sync:error':
+        toast(data.error, 'error');
+        break;
+    case 'sync:chat': {
         const chatEl = document.getElementById('syncChat');
         if (chatEl) {
             const msg = document.createElement('div');
@@ -88,12 +230,15 @@ function handleSyncWSMessage(event, data) {
             chatEl.appendChild(msg);
             chatEl.scrollTop = chatEl.scrollHeight;
         }
+        break;
     }
-    if (event === 'sync:ended') {
+    case 'sync:ended': {
         const panel = document.getElementById('syncPanel');
         if (panel) panel.style.display = 'none';
-        currentSyncSession = null;
+        syncDetach();
         toast('Watch Together session ended');
+        break;
+    }
     }
 }
 
@@ -425,7 +425,7 @@ function handleWSEvent(msg) {
         }
         case 'scan:complete': {
             const r = msg.data?.result;
-            toast(`Scan complete: ${r?.files_added||0} added, ${r?.files_found||0} found`);
+            toast(`Scan complete: ${r?.files_added||0} added, ${r?.files_changed||0} changed, ${r?.files_removed||0} removed, ${r?.files_found||0} found`);
             loadSidebarCounts();
             const libId = msg.data?.library_id;
             if (libId) {
@@ -29,25 +29,25 @@ async function loadLibrariesView() {
             if (lib.create_thumbnails === false) settingsTags += '<span class="tag tag-orange" style="margin-left:4px;">No Thumbnails</span>';
             if (lib.audio_normalization) settingsTags += '<span class="tag tag-cyan" style="margin-left:4px;">Audio Normalization</span>';
             if (lib.media_type === 'adult_movies' && lib.adult_content_type) settingsTags += `<span class="tag tag-purple" style="margin-left:4px;">${lib.adult_content_type === 'clips' ? 'Clips' : 'Movies'}</span>`;
-            return `<div class="library-card" id="lib-card-${lib.id}"><div style="flex:1;"><h3>${lib.name}</h3><p style="color:#8a9bae;font-size:0.85rem;"><span class="tag tag-cyan">${MEDIA_LABELS[lib.media_type]||lib.media_type}</span>${lib.season_grouping?'<span class="tag tag-purple" style="margin-left:6px;">Season Grouping</span>':''}<span class="tag ${accessColor}" style="margin-left:6px;">${accessLabel}</span>${folderCount}<span style="margin-left:8px;">${folderPaths}</span></p><div class="lib-settings-tags">${settingsTags}</div><p style="color:#5a6a7f;font-size:0.78rem;margin-top:6px;">${lib.last_scan_at?'Last scan: '+new Date(lib.last_scan_at).toLocaleString():'Never scanned'}</p><div class="scan-progress" id="scan-progress-${lib.id}"><div class="scan-progress-bar"><div class="scan-progress-fill" id="scan-fill-${lib.id}"></div></div><div class="scan-progress-text"><span class="filename" id="scan-file-${lib.id}"></span><span id="scan-count-${lib.id}"></span></div></div></div><div class="library-actions">${isAdmin?`<button class="btn-secondary" id="scan-btn-${lib.id}" onclick="scanLibrary('${lib.id}',this)">&#128269; Scan</button><button class="btn-danger btn-small" onclick="deleteLibrary('${lib.id}')">Delete</button>`:''}</div></div>`;
+            return `<div class="library-card" id="lib-card-${lib.id}"><div style="flex:1;"><h3>${lib.name}</h3><p style="color:#8a9bae;font-size:0.85rem;"><span class="tag tag-cyan">${MEDIA_LABELS[lib.media_type]||lib.media_type}</span>${lib.season_grouping?'<span class="tag tag-purple" style="margin-left:6px;">Season Grouping</span>':''}<span class="tag ${accessColor}" style="margin-left:6px;">${accessLabel}</span>${folderCount}<span style="margin-left:8px;">${folderPaths}</span></p><div class="lib-settings-tags">${settingsTags}</div><p style="color:#5a6a7f;font-size:0.78rem;margin-top:6px;">${lib.last_scan_at?'Last scan: '+new Date(lib.last_scan_at).toLocaleString():'Never scanned'}</p><div class="scan-progress" id="scan-progress-${lib.id}"><div class="scan-progress-bar"><div class="scan-progress-fill" id="scan-fill-${lib.id}"></div></div><div class="scan-progress-text"><span class="filename" id="scan-file-${lib.id}"></span><span id="scan-count-${lib.id}"></span></div></div></div><div class="library-actions">${isAdmin?`<button class="btn-secondary" id="scan-btn-${lib.id}" title="Shift-click for a deep scan that re-probes every file" onclick="scanLibrary('${lib.id}',this,event.shiftKey)">&#128269; Scan</button><button class="btn-danger btn-small" onclick="deleteLibrary('${lib.id}')">Delete</button>`:''}</div></div>`;
         }).join('');
     } else div.innerHTML = `<div class="empty-state"><div class="empty-state-icon">&#128218;</div><div class="empty-state-title">No libraries configured</div><p>Create your first library to start organizing media</p></div>`;
 }
 
-async function scanLibrary(id, btn) {
-    btn.disabled = true; btn.innerHTML = '<span class="spinner"></span>Scanning...';
+async function scanLibrary(id, btn, deep) {
+    btn.disabled = true; btn.innerHTML = '<span class="spinner"></span>' + (deep ? 'Deep scanning...' : 'Scanning...');
     const prog = document.getElementById('scan-progress-' + id);
     if (prog) prog.classList.add('active');
     const countEl = document.getElementById('scan-count-' + id);
     if (countEl) countEl.textContent = 'Counting files...';
     try {
-        const data = await api('POST', '/libraries/'+id+'/scan');
+        const data = await api('POST', '/libraries/'+id+'/scan' + (deep ? '?deep=true' : ''));
         if (data.success) {
             if (data.data.job_id) { /* progress handled by WebSocket events */ }
             else {
                 // Synchronous scan fallback (no job queue)
                 const r = data.data;
-                toast(`Scan: ${r.files_added} added, ${r.files_found} total`);
+                toast(`Scan: ${r.files_added} added, ${r.files_changed} changed, ${r.files_removed} removed, ${r.files_found} total`);
                 loadLibrariesView(); loadSidebarCounts();
                 btn.disabled = false; btn.innerHTML = '&#128269; Scan';
                 if (prog) prog.classList.remove('active');
@@ -242,6 +242,35 @@ function showCreateLibrary() {
                     <label><input type="radio" name="watchEnabled" value="no" checked><span>No</span></label>
                 </div>
             </div>
+            <div class="option-row">
+                <div class="option-row-info">
+                    <div class="option-row-label">Watch Mode</div>
+                    <div class="option-row-desc">Polling finds changes made by other hosts on NFS/SMB/FUSE mounts, where filesystem events don't arrive</div>
+                </div>
+                <select id="editWatchMode" class="form-select" style="width:auto;">
+                    <option value="fsnotify" selected>Filesystem Events</option>
+                    <option value="poll">Polling</option>
+                    <option value="both">Both</option>
+                </select>
+            </div>
+            <div class="option-row">
+                <div class="option-row-info">
+                    <div class="option-row-label">Scan Workers</div>
+                    <div class="option-row-desc">Files probed, processed and matched against metadata sources at once; leave blank for the server default</div>
+                </div>
+                <div style="display:flex;gap:6px;">
+                    <input type="number" id="editScanProbeWorkers" min="0" max="64" placeholder="Probe" title="Probe workers" value="" style="width:80px;">
+                    <input type="number" id="editScanCPUWorkers" min="0" max="64" placeholder="CPU" title="CPU workers" value="" style="width:80px;">
+                    <input type="number" id="editScanMetadataWorkers" min="0" max="64" placeholder="Metadata" title="Metadata workers" value="" style="width:80px;">
+                </div>
+            </div>
+            <div class="option-row">
+                <div class="option-row-info">
+                    <div class="option-row-label">Metadata Providers</div>
+                    <div class="option-row-desc">Providers to match against, in order, separated by commas (e.g. tmdb, omdb, fanarttv); leave blank for the defaults</div>
+                </div>
+                <input type="text" id="editMetadataProviders" placeholder="tmdb, omdb" value="" style="width:250px;">
+            </div>
         </div>
 
         <button class="btn-primary" onclick="createLibrary()">Create Library</button>
@@ -379,6 +408,13 @@ async function createLibrary() {
     const audio_normalization = document.querySelector('input[name="audioNormalization"]:checked')?.value === 'yes';
     const scan_interval = document.getElementById('editScanInterval')?.value || 'disabled';
     const watch_enabled = document.querySelector('input[name="watchEnabled"]:checked')?.value === 'yes';
+    const watch_mode = document.getElementById('editWatchMode')?.value || 'fsnotify';
+    const scanWorkers = id => parseInt(document.getElementById(id)?.value, 10) || 0;
+    const scan_probe_workers = scanWorkers('editScanProbeWorkers');
+    const scan_cpu_workers = scanWorkers('editScanCPUWorkers');
+    const scan_metadata_workers = scanWorkers('editScanMetadataWorkers');
+    const metadata_providers = (document.getElementById('editMetadataProviders')?.value || '')
+        .split(',').map(p => p.trim().toLowerCase()).filter(Boolean);
 
     let adult_content_type = null;
     if (media_type === 'adult_movies') {
@@ -391,7 +427,8 @@ async function createLibrary() {
         include_in_homepage, include_in_search, retrieve_metadata,
         nfo_import, nfo_export, prefer_local_artwork,
         create_previews, create_thumbnails, audio_normalization, adult_content_type,
-        scan_interval, watch_enabled
+        scan_interval, watch_enabled, watch_mode,
+        scan_probe_workers, scan_cpu_workers, scan_metadata_workers, metadata_providers
     });
     if (d.success) { toast('Library created!'); loadLibrariesView(); loadSidebarCounts(); }
     else toast('Failed: ' + d.error, 'error');